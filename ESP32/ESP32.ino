@@ -1,5 +1,7 @@
 #include <driver/i2s.h>
 #include <WiFi.h>
+#include <time.h>
+#include <ArduinoJson.h>
 #include "ESP32MQTTClient.h"
 #include "esp_idf_version.h"
 
@@ -20,6 +22,12 @@ char *server = "mqtt://<ip>";
 
 char *subscribeTopic = "audio/room/speaker";
 char *audioTopic = "sensor/room/audio";
+char *bootTopic = "sensor/room/boot";
+char *commandTopic = "window/room/command";
+char *ackTopic = "window/room/ack"; // Backend needs MQTT_TOPIC_WINDOW_ACK=window/+/ack
+
+// Last applied command, so a resent command is acked again but not applied twice
+std::string lastCommandId;
 
 ESP32MQTTClient mqttClient;
 
@@ -91,6 +99,9 @@ void setup() {
     // Serial.println("\nWiFi connected. IP: " + WiFi.localIP().toString());
     // mqttClient.loopStart();
 
+    // UTC clock for checking command expiry
+    configTime(0, 0, "pool.ntp.org");
+
     i2s_driver_install(I2S_NUM_0, &i2s_mic_config, 0, NULL);
     i2s_set_pin(I2S_NUM_0, &i2s_mic_pins);
 
@@ -130,10 +141,75 @@ void loop() {
 }
 
 
+// Parses an RFC 3339 UTC timestamp ("2025-10-24T12:05:01.123Z") into epoch seconds
+bool parseUTC(const char *s, time_t *out) {
+    struct tm t = {};
+    if (sscanf(s, "%d-%d-%dT%d:%d:%d", &t.tm_year, &t.tm_mon, &t.tm_mday, &t.tm_hour, &t.tm_min, &t.tm_sec) != 6) {
+        return false;
+    }
+    t.tm_year -= 1900;
+    t.tm_mon -= 1;
+    *out = mktime(&t); // TZ is UTC (configTime with no offset)
+    return true;
+}
+
+void publishAck(const char *commandId, const char *status, const char *error) {
+    JsonDocument ack;
+    ack["command_id"] = commandId;
+    ack["status"] = status;
+    if (error != nullptr) {
+        ack["error"] = error;
+    }
+    std::string payload;
+    serializeJson(ack, payload);
+    mqttClient.publish(ackTopic, payload, 1, false);
+}
+
+// Applies a window command unless it arrived after its expiry. A broker holds
+// QoS 2 commands while the device is offline and MQTT 3.1.1 can't expire them,
+// so this check is what keeps a stale command from moving the window.
+void handleWindowCommand(const std::string &payload) {
+    JsonDocument cmd;
+    if (deserializeJson(cmd, payload) != DeserializationError::Ok) {
+        log_w("Undecodable window command: %s", payload.c_str());
+        return;
+    }
+    const char *commandId = cmd["command_id"] | "";
+    const char *expiresAt = cmd["expires_at"] | "";
+
+    if (*expiresAt != '\0') {
+        time_t expires, now = time(nullptr);
+        if (!parseUTC(expiresAt, &expires)) {
+            publishAck(commandId, "rejected", "invalid expires_at");
+            return;
+        }
+        // Before NTP sync the clock reads 1970 and can't tell a stale command
+        if (now < 1700000000) {
+            publishAck(commandId, "rejected", "clock not set");
+            return;
+        }
+        if (now > expires) {
+            publishAck(commandId, "rejected", "expired");
+            return;
+        }
+    }
+
+    float position = cmd["position"] | -1.0f;
+    if (lastCommandId != commandId) {
+        lastCommandId = commandId;
+        log_i("Moving window to %.1f%%", position); // Actuator driver goes here
+    }
+    publishAck(commandId, "applied", nullptr);
+}
+
 void onMqttConnect(esp_mqtt_client_handle_t client) {
     if (mqttClient.isMyTurn(client)) {
         mqttClient.subscribe(subscribeTopic, [](const std::string &payload)
                                 { log_i("%s: %s", subscribeTopic, payload.c_str()); });
+        mqttClient.subscribe(commandTopic, handleWindowCommand, 2);
+
+        // Announcing every (re)connect makes the backend re-issue the current command
+        mqttClient.publish(bootTopic, "{}", 1, false);
     }
 }
 
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"iot-backend/internal/aggregator"
+	"iot-backend/internal/models"
+	"iot-backend/pkg/audiofixture"
+)
+
+// goldenToleranceDB is how far a measured volume may be from its golden value
+const goldenToleranceDB = 0.05
+
+// runAudioCommand dispatches the audio fixture subcommands
+func runAudioCommand(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "fixture":
+			return runAudioFixtureCommand(args[1:])
+		case "verify":
+			return runAudioVerifyCommand(args[1:])
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Usage: iot-backend audio fixture|verify [flags]")
+	return 2
+}
+
+// toneFlags collects repeated --tone values
+type toneFlags []audiofixture.Tone
+
+func (t *toneFlags) String() string { return fmt.Sprint(*t) }
+
+func (t *toneFlags) Set(v string) error {
+	tone, err := audiofixture.ParseTone(v)
+	if err != nil {
+		return err
+	}
+	*t = append(*t, tone)
+	return nil
+}
+
+// runAudioFixtureCommand writes a synthetic clip, for publishing as a device would
+// (e.g., with mosquitto_pub -f) or feeding other tools.
+//
+//	iot-backend audio fixture --tone 1000:0.5 [--tone F:A ...] [--noise RMS] [--gain G]
+//	    [--rate HZ] [--duration S] [--bits N] [--channels N] [--format wav|pcm|json] [--out FILE]
+func runAudioFixtureCommand(args []string) int {
+	fs := flag.NewFlagSet("audio fixture", flag.ContinueOnError)
+	var tones toneFlags
+	fs.Var(&tones, "tone", "Sine component as frequency:amplitude, amplitude a fraction of full scale (repeatable)")
+	noise := fs.Float64("noise", 0, "White noise RMS as a fraction of full scale")
+	seed := fs.Int64("seed", 1, "Noise seed")
+	gain := fs.Float64("gain", 1, "Gain before clipping at full scale (above 1 clips loud tones)")
+	rate := fs.Int("rate", 16000, "Sample rate in Hz")
+	duration := fs.Float64("duration", 1, "Duration in seconds")
+	bits := fs.Int("bits", 16, "Bits per sample: 8, 16, 24, or 32")
+	channels := fs.Int("channels", 1, "Interleaved channels")
+	format := fs.String("format", "wav", "Output format: wav, pcm (raw little-endian), or json (MQTT audio payload)")
+	out := fs.String("out", "", "Output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	spec := audiofixture.Spec{
+		SampleRate:    *rate,
+		Duration:      *duration,
+		BitsPerSample: *bits,
+		Channels:      *channels,
+		Tones:         tones,
+		NoiseRMS:      *noise,
+		Seed:          *seed,
+		Gain:          *gain,
+	}
+
+	var data []byte
+	var err error
+	switch *format {
+	case "wav":
+		data, err = spec.WAV()
+	case "pcm":
+		data, err = spec.PCM()
+	case "json":
+		var wav []byte
+		if wav, err = spec.WAV(); err == nil {
+			data, err = json.Marshal(models.AudioPayload{Data: wav, SampleRate: *rate, Duration: *duration, Channels: *channels})
+		}
+	default:
+		err = fmt.Errorf("unknown format %q (want wav, pcm, or json)", *format)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid fixture: %v\n", err)
+		return 2
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Printf("Failed to create %s: %v", *out, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if _, err := w.Write(data); err != nil {
+		log.Printf("Failed to write fixture: %v", err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %d bytes: expected volume %.2f dB, %.2f%% of samples clipped\n",
+		len(data), spec.ExpectedVolumeDB(), 100*spec.ExpectedClippingRatio(clippingFraction()))
+	return 0
+}
+
+// clippingFraction is the default clipping threshold as a fraction of 16-bit full scale
+func clippingFraction() float64 {
+	return float64(aggregator.DefaultAudioConfig().ClippingThreshold+1) / 32768
+}
+
+// runAudioVerifyCommand measures the golden clips with the backend's audio code and
+// reports any volume off by more than goldenToleranceDB, or a clipping ratio that
+// differs from the waveform's. It exits non-zero on a mismatch, so it can gate
+// changes to the audio pipeline.
+//
+//	iot-backend audio verify [--verbose]
+func runAudioVerifyCommand(args []string) int {
+	fs := flag.NewFlagSet("audio verify", flag.ContinueOnError)
+	verbose := fs.Bool("verbose", false, "Keep the per-clip processing logs")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLIP\tEXPECTED dB\tMEASURED dB\tRESULT")
+	failures := 0
+	for _, golden := range audiofixture.Goldens() {
+		measured, problems := verifyGolden(golden)
+		result := "ok"
+		if len(problems) > 0 {
+			failures++
+			result = "FAIL: " + strings.Join(problems, "; ")
+		}
+		fmt.Fprintf(tw, "%s\t%.3f\t%.3f\t%s\n", golden.Name, golden.VolumeDB, measured, result)
+	}
+	tw.Flush()
+
+	if failures > 0 {
+		fmt.Printf("\n%d golden clips failed\n", failures)
+		return 1
+	}
+	return 0
+}
+
+// verifyGolden measures one golden clip as raw PCM and as a WAV file
+func verifyGolden(golden audiofixture.Golden) (float64, []string) {
+	spec := golden.Spec
+	pcm, err := spec.PCM()
+	if err != nil {
+		return 0, []string{err.Error()}
+	}
+	wav, err := spec.WAV()
+	if err != nil {
+		return 0, []string{err.Error()}
+	}
+
+	var problems []string
+	bits := spec.BitsPerSample
+	if bits == 0 {
+		bits = 16
+	}
+	config := aggregator.AudioConfigForBits(bits)
+	measured := aggregator.ExtractSoundVolumeWithConfig(pcm, spec.SampleRate, config)
+	if math.Abs(measured-golden.VolumeDB) > goldenToleranceDB {
+		problems = append(problems, fmt.Sprintf("volume off by %.3f dB", measured-golden.VolumeDB))
+	}
+	if expected := spec.ExpectedVolumeDB(); math.Abs(expected-golden.VolumeDB) > goldenToleranceDB {
+		problems = append(problems, fmt.Sprintf("fixture waveform is %.3f dB, not the golden value", expected))
+	}
+
+	// The WAV path must decode to the same samples
+	format, data, err := aggregator.ParseWAV(wav)
+	switch {
+	case err != nil:
+		problems = append(problems, fmt.Sprintf("WAV not parsed: %v", err))
+	case format.BitsPerSample != bits || string(data) != string(pcm):
+		problems = append(problems, "WAV samples differ from the PCM")
+	}
+
+	// Clipping is measured on 16-bit audio only
+	if bits == 16 {
+		analysis := aggregator.AnalyzeAudio(pcm, spec.SampleRate)
+		if expected := spec.ExpectedClippingRatio(clippingFraction()); math.Abs(analysis.ClippingRatio-expected) > 0.001 {
+			problems = append(problems, fmt.Sprintf("clipping ratio %.4f, want %.4f", analysis.ClippingRatio, expected))
+		}
+	}
+	return measured, problems
+}
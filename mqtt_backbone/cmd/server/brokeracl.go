@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"iot-backend/internal/brokeracl"
+	"iot-backend/internal/models"
+	"iot-backend/pkg/config"
+)
+
+// brokerACLUsage is printed for invalid broker-acl arguments
+const brokerACLUsage = "Usage: iot-backend broker-acl [--format mosquitto|emqx] [--out DIR] [--include-inactive]"
+
+// runBrokerACLCommand writes broker ACL and credential files from the device registry.
+//
+//	iot-backend broker-acl [--format mosquitto|emqx] [--out DIR] [--include-inactive]
+//
+// Every device gets a user named by its device ID that may publish only to its own
+// sensor and ack topics and subscribe only to its own command and config topics, as
+// configured (MQTT_TOPIC_*, MQTT_TOPIC_PREFIX, MQTT_TENANT). Passwords come from the
+// registry (config.mqtt_password, else config.device_token); devices without one
+// get ACL entries only. The backend's MQTT_USERNAME is granted every topic.
+//
+// mosquitto writes acl and passwd (acl_file / password_file); emqx writes acl.conf
+// and users.csv (file authorizer / built-in database bootstrap). Run it after
+// registering devices and reload the broker (mosquitto: SIGHUP).
+func runBrokerACLCommand(args []string) int {
+	fs := flag.NewFlagSet("broker-acl", flag.ContinueOnError)
+	format := fs.String("format", brokeracl.FormatMosquitto, "mosquitto or emqx")
+	out := fs.String("out", ".", "Directory the files are written to")
+	includeInactive := fs.Bool("include-inactive", false, "Also grant devices marked inactive in the registry")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 || (*format != brokeracl.FormatMosquitto && *format != brokeracl.FormatEMQX) {
+		fmt.Fprintln(os.Stderr, brokerACLUsage)
+		return 2
+	}
+
+	cfg := config.Load()
+	db, err := openDatabase(cfg)
+	if err != nil {
+		log.Printf("Failed to initialize ClickHouse: %v", err)
+		return 1
+	}
+	defer db.Close()
+
+	var devices []models.Device
+	if *includeInactive {
+		devices, err = db.ListDevices()
+	} else {
+		devices, err = db.ListActiveDevices()
+	}
+	if err != nil {
+		log.Printf("Failed to read device registry: %v", err)
+		return 1
+	}
+
+	users, skipped, err := brokeracl.DeviceUsers(devices, deviceTopics(cfg))
+	if err != nil {
+		log.Printf("Failed to build broker ACL: %v", err)
+		return 1
+	}
+	for _, id := range skipped {
+		log.Printf("Warning: Skipping device %q, its ID can't be a broker username or topic level", id)
+	}
+	var missing int
+	for _, user := range users {
+		if user.Password == "" {
+			missing++
+		}
+	}
+	if cfg.MQTTUsername != "" {
+		users = append([]brokeracl.User{{Username: cfg.MQTTUsername, Password: cfg.MQTTPassword, Superuser: true}}, users...)
+	}
+
+	aclFile, aclWrite := "acl", brokeracl.WriteMosquittoACL
+	credFile, credWrite := "passwd", brokeracl.WriteMosquittoPasswd
+	if *format == brokeracl.FormatEMQX {
+		aclFile, aclWrite = "acl.conf", brokeracl.WriteEMQXACL
+		credFile, credWrite = "users.csv", brokeracl.WriteEMQXUsers
+	}
+	if err := writeBrokerFile(filepath.Join(*out, aclFile), 0644, users, aclWrite); err != nil {
+		log.Printf("Failed to write ACL: %v", err)
+		return 1
+	}
+	if err := writeBrokerFile(filepath.Join(*out, credFile), 0600, users, credWrite); err != nil {
+		log.Printf("Failed to write credentials: %v", err)
+		return 1
+	}
+
+	log.Printf("Wrote %s and %s to %s for %d devices", aclFile, credFile, *out, len(devices)-len(skipped))
+	if missing > 0 {
+		log.Printf("Warning: %d devices have no %s or device token in the registry and can't log in yet",
+			missing, brokeracl.PasswordConfig)
+	}
+	return 0
+}
+
+// deviceTopics returns the configured topics devices publish and subscribe to
+func deviceTopics(cfg *config.Config) brokeracl.Topics {
+	topics := brokeracl.Topics{
+		Publish: []string{
+			cfg.MQTTTopicSafety,
+			cfg.MQTTTopicTemperature,
+			cfg.MQTTTopicHumidity,
+			cfg.MQTTTopicAudio,
+			cfg.MQTTTopicBoot,
+			cfg.MQTTTopicMotion,
+			cfg.MQTTTopicCO2,
+			cfg.MQTTTopicBackfill,
+			cfg.MQTTTopicWindowAck,
+		},
+		Subscribe: []string{cfg.MQTTTopicWindowCommand, cfg.MQTTTopicDeviceConfig},
+		Prefix:    cfg.MQTTTopicPrefix,
+		Tenant:    cfg.MQTTTenant,
+	}
+	if cfg.MQTTFrameLayout != "" {
+		topics.Publish = append(topics.Publish, cfg.MQTTTopicFrame)
+	}
+	return topics
+}
+
+// writeBrokerFile renders a file and replaces the old one in a single rename, so a
+// broker reloading meanwhile never reads half of it
+func writeBrokerFile(path string, perm os.FileMode, users []brokeracl.User, write func(io.Writer, []brokeracl.User) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf, users); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"iot-backend/internal/preflight"
+	"iot-backend/pkg/config"
+)
+
+// runCheckCommand runs the dependency preflight and exits non-zero if any check failed.
+//
+//	iot-backend --check [--json]
+func runCheckCommand(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	report := preflight.Run(config.Load())
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding report: %v\n", err)
+			return 1
+		}
+	} else {
+		report.Print(os.Stdout)
+	}
+
+	if !report.Passed {
+		return 1
+	}
+	return 0
+}
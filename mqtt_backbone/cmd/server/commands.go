@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runCommand dispatches a command-line subcommand and returns the process exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "audio":
+		return runAudioCommand(args[1:])
+	case "broker-acl":
+		return runBrokerACLCommand(args[1:])
+	case "dataset":
+		return runDatasetCommand(args[1:])
+	case "import":
+		return runImportCommand(args[1:])
+	case "migrate-legacy":
+		return runMigrateLegacyCommand(args[1:])
+	case "replay":
+		return runReplayCommand(args[1:])
+	case "snapshot":
+		return runSnapshotCommand(args[1:])
+	case "tail":
+		return runTailCommand(args[1:])
+	case "whatif":
+		return runWhatIfCommand(args[1:])
+	case "check", "--check":
+		return runCheckCommand(args[1:])
+	case "help", "-h", "--help":
+		printUsage()
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", args[0])
+		printUsage()
+		return 2
+	}
+}
+
+// printUsage lists available subcommands
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: iot-backend [command]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Without a command, the backend service is started.")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  --check         Verify broker, topic permissions, schema, and model file, then exit")
+	fmt.Fprintln(os.Stderr, "  audio fixture   Synthesize a WAV/PCM clip with known tones, noise, and clipping")
+	fmt.Fprintln(os.Stderr, "  audio verify    Check volume extraction against golden clips at every bit depth")
+	fmt.Fprintln(os.Stderr, "  broker-acl      Write Mosquitto/EMQX ACL and credential files from the device registry")
+	fmt.Fprintln(os.Stderr, "  dataset build   Build a labeled training dataset (CSV)")
+	fmt.Fprintln(os.Stderr, "  dataset aggregate  Export k-anonymized aggregate statistics for sharing (CSV)")
+	fmt.Fprintln(os.Stderr, "  import FILE...  Load historical temperature/humidity CSVs into ClickHouse")
+	fmt.Fprintln(os.Stderr, "  migrate-legacy  Copy history between the legacy sensor_readings table and the per-sensor tables")
+	fmt.Fprintln(os.Stderr, "  replay          Re-insert rows ClickHouse rejected (failed_inserts) after a fix")
+	fmt.Fprintln(os.Stderr, "  snapshot create Save the device registry and alert rules to an archive")
+	fmt.Fprintln(os.Stderr, "  snapshot restore FILE  Load a snapshot archive into this instance")
+	fmt.Fprintln(os.Stderr, "  tail --device ID  Print a device's readings, triggers, and window actions live")
+	fmt.Fprintln(os.Stderr, "  whatif --rules FILE  Replay history against proposed safety rules and report their effect")
+	fmt.Fprintln(os.Stderr, "  help            Show this help message")
+}
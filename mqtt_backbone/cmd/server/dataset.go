@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"iot-backend/internal/database"
+	"iot-backend/pkg/config"
+)
+
+// runDatasetCommand handles "iot-backend dataset <subcommand>"
+func runDatasetCommand(args []string) int {
+	if len(args) > 0 && args[0] == "aggregate" {
+		return runDatasetAggregateCommand(args[1:])
+	}
+	if len(args) == 0 || args[0] != "build" {
+		fmt.Fprintln(os.Stderr, "Usage: iot-backend dataset build [--days N | --from T --to T] [--window S] [--feedback S] [--device ID] [--tz ZONE] [--format csv|parquet] [--out FILE]")
+		fmt.Fprintln(os.Stderr, "       iot-backend dataset aggregate [--days N | --from T --to T] [--bucket hour|day] [--group-by TAG] [--k N] [--out FILE]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("dataset build", flag.ContinueOnError)
+	days := fs.Int("days", 30, "Number of days back from now to include (ignored when --from is set)")
+	fromStr := fs.String("from", "", "Start of period (RFC3339)")
+	toStr := fs.String("to", "", "End of period (RFC3339, default now)")
+	window := fs.Int("window", 120, "Seconds of sensor data averaged before each window action")
+	feedback := fs.Int("feedback", 1800, "Seconds after an action within which a manual override labels it as overridden")
+	deviceID := fs.String("device", "", "Restrict to a single device")
+	tz := fs.String("tz", "UTC", "IANA time zone of the hour_of_day and day_of_week columns")
+	format := fs.String("format", "", "Output format, csv or parquet (default from the --out extension, else csv)")
+	out := fs.String("out", "dataset.csv", "Output path ('-' for stdout)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	from, to, err := parsePeriod(*days, *fromStr, *toStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid period: %v\n", err)
+		return 2
+	}
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --tz: %v\n", err)
+		return 2
+	}
+	if *format == "" {
+		*format = "csv"
+		if strings.HasSuffix(*out, ".parquet") {
+			*format = "parquet"
+		}
+	}
+	if *format != "csv" && *format != "parquet" {
+		fmt.Fprintf(os.Stderr, "Invalid --format %q: must be csv or parquet\n", *format)
+		return 2
+	}
+
+	db, err := openDatabase(config.Load())
+	if err != nil {
+		log.Printf("Failed to initialize ClickHouse: %v", err)
+		return 1
+	}
+	defer db.Close()
+
+	log.Printf("Building dataset from %s to %s (window=%ds)", from.Format(time.RFC3339), to.Format(time.RFC3339), *window)
+
+	rows, err := db.GetTrainingRows(database.TrainingQuery{
+		From:            from,
+		To:              to,
+		WindowSeconds:   *window,
+		DeviceID:        *deviceID,
+		FeedbackSeconds: *feedback,
+	})
+	if err != nil {
+		log.Printf("Failed to query training rows: %v", err)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Printf("Failed to create %s: %v", *out, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	write := writeTrainingCSV
+	if *format == "parquet" {
+		write = writeTrainingParquet
+	}
+	if err := write(w, rows, loc); err != nil {
+		log.Printf("Failed to write dataset: %v", err)
+		return 1
+	}
+
+	log.Printf("Wrote %d labeled rows to %s", len(rows), *out)
+	return 0
+}
+
+// parsePeriod resolves the period flags of the dataset commands: from --from, or
+// days back from --to (default now)
+func parsePeriod(days int, fromStr, toStr string) (time.Time, time.Time, error) {
+	to := time.Now()
+	if toStr != "" {
+		t, err := time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid --to: %v", err)
+		}
+		to = t
+	}
+	from := to.Add(-time.Duration(days) * 24 * time.Hour)
+	if fromStr != "" {
+		t, err := time.Parse(time.RFC3339, fromStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid --from: %v", err)
+		}
+		from = t
+	}
+	return from, to, nil
+}
+
+// trainingColumn is one column of a training dataset. value returns nil for a
+// missing value, otherwise a time, string, int, bool or float64 matching kind.
+type trainingColumn struct {
+	name  string
+	kind  parquet.Node
+	value func(r database.TrainingRow) interface{}
+}
+
+// optionalFloat returns a nullable float as a column value
+func optionalFloat(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+// trainingColumns lists the dataset columns in output order: features, weather,
+// labels, feedback and then the lag features. The hour and weekday are local to loc.
+func trainingColumns(loc *time.Location) []trainingColumn {
+	double := parquet.Leaf(parquet.DoubleType)
+	optionalDouble := parquet.Optional(double)
+	columns := []trainingColumn{
+		{"timestamp", parquet.Timestamp(parquet.Millisecond), func(r database.TrainingRow) interface{} { return r.Timestamp }},
+		{"device_id", parquet.String(), func(r database.TrainingRow) interface{} { return r.DeviceID }},
+		{"hour_of_day", parquet.Int(32), func(r database.TrainingRow) interface{} { return r.Timestamp.In(loc).Hour() }},
+		{"day_of_week", parquet.Int(32), func(r database.TrainingRow) interface{} { return int(r.Timestamp.In(loc).Weekday()) }},
+		{"temperature", double, func(r database.TrainingRow) interface{} { return r.Temperature }},
+		{"humidity", double, func(r database.TrainingRow) interface{} { return r.Humidity }},
+		{"sound_volume", double, func(r database.TrainingRow) interface{} { return r.SoundVolume }},
+		{"outdoor_temperature", optionalDouble, func(r database.TrainingRow) interface{} { return optionalFloat(r.OutdoorTemperature) }},
+		{"rain", optionalDouble, func(r database.TrainingRow) interface{} { return optionalFloat(r.Rain) }},
+		{"wind", optionalDouble, func(r database.TrainingRow) interface{} { return optionalFloat(r.Wind) }},
+		{"confidence", double, func(r database.TrainingRow) interface{} { return r.Confidence }},
+		{"position", double, func(r database.TrainingRow) interface{} { return r.Position }},
+		{"overridden", parquet.Leaf(parquet.BooleanType), func(r database.TrainingRow) interface{} { return r.OverridePosition != nil }},
+		{"override_position", optionalDouble, func(r database.TrainingRow) interface{} { return optionalFloat(r.OverridePosition) }},
+	}
+	for _, name := range database.LagFeatureNames() {
+		name := name
+		columns = append(columns, trainingColumn{name, optionalDouble, func(r database.TrainingRow) interface{} {
+			if v, ok := r.Lags[name]; ok {
+				return v
+			}
+			return nil
+		}})
+	}
+	return columns
+}
+
+// writeTrainingCSV writes training rows as a flat CSV with a header row. Missing
+// values (weather, override positions and lag features) are left empty.
+func writeTrainingCSV(w io.Writer, rows []database.TrainingRow, loc *time.Location) error {
+	cw := csv.NewWriter(w)
+	columns := trainingColumns(loc)
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.name
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	record := make([]string, len(columns))
+	for _, r := range rows {
+		for i, c := range columns {
+			switch v := c.value(r).(type) {
+			case nil:
+				record[i] = ""
+			case time.Time:
+				record[i] = v.UTC().Format(time.RFC3339Nano)
+			case string:
+				record[i] = v
+			case int:
+				record[i] = strconv.Itoa(v)
+			case bool:
+				record[i] = strconv.FormatBool(v)
+			case float64:
+				record[i] = formatFloat(v)
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeTrainingParquet writes training rows as a Parquet file with the same columns
+// as the CSV. Missing values are nulls.
+func writeTrainingParquet(w io.Writer, rows []database.TrainingRow, loc *time.Location) error {
+	columns := trainingColumns(loc)
+	group := parquet.Group{}
+	for _, c := range columns {
+		group[c.name] = c.kind
+	}
+	schema := parquet.NewSchema("training_row", group)
+
+	// Parquet orders the columns of a group by name; look up where each one went
+	leaves := make([]parquet.LeafColumn, len(columns))
+	for i, c := range columns {
+		leaf, ok := schema.Lookup(c.name)
+		if !ok {
+			return fmt.Errorf("failed to find parquet column %s", c.name)
+		}
+		leaves[i] = leaf
+	}
+
+	pw := parquet.NewWriter(w, schema)
+	for _, r := range rows {
+		row := make(parquet.Row, len(columns))
+		for i, c := range columns {
+			index := leaves[i].ColumnIndex
+			switch v := c.value(r).(type) {
+			case nil:
+				row[index] = parquet.NullValue().Level(0, 0, index)
+			case time.Time:
+				row[index] = parquet.Int64Value(v.UnixMilli()).Level(0, leaves[i].MaxDefinitionLevel, index)
+			case int:
+				row[index] = parquet.Int32Value(int32(v)).Level(0, leaves[i].MaxDefinitionLevel, index)
+			default:
+				row[index] = parquet.ValueOf(v).Level(0, leaves[i].MaxDefinitionLevel, index)
+			}
+		}
+		if _, err := pw.WriteRows([]parquet.Row{row}); err != nil {
+			return fmt.Errorf("failed to write parquet row: %w", err)
+		}
+	}
+	if err := pw.Close(); err != nil {
+		return fmt.Errorf("failed to finish parquet file: %w", err)
+	}
+	return nil
+}
+
+// formatFloat formats a float for CSV output without trailing zeros
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
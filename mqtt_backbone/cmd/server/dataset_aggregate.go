@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"iot-backend/internal/database"
+	"iot-backend/pkg/config"
+)
+
+// untaggedGroup holds the devices without the --group-by tag
+const untaggedGroup = "untagged"
+
+// aggregateRow is one exported statistic: a metric over the devices of a group in a bucket
+type aggregateRow struct {
+	Bucket  time.Time
+	Group   string
+	Metric  string
+	Devices int
+	Mean    float64 // Mean of the per-device means
+	StdDev  float64 // Spread of the per-device means
+}
+
+// runDatasetAggregateCommand exports aggregated statistics safe to share outside the
+// deployment: per bucket and device group, the mean and spread of the devices'
+// hourly means. No device IDs, readings, or audio-derived values leave, and every
+// bucket-group covering fewer than --k devices is suppressed.
+//
+//	iot-backend dataset aggregate [--days N | --from T --to T] [--bucket hour|day] [--group-by TAG] [--k N] [--out FILE]
+func runDatasetAggregateCommand(args []string) int {
+	fs := flag.NewFlagSet("dataset aggregate", flag.ContinueOnError)
+	days := fs.Int("days", 30, "Number of days back from now to include (ignored when --from is set)")
+	fromStr := fs.String("from", "", "Start of period (RFC3339)")
+	toStr := fs.String("to", "", "End of period (RFC3339, default now)")
+	bucket := fs.String("bucket", "day", "Time bucket of the statistics: hour or day")
+	groupBy := fs.String("group-by", "", "Device tag whose values form the groups, e.g. floor (default one group of all devices)")
+	k := fs.Int("k", 5, "Minimum number of devices behind every exported statistic")
+	out := fs.String("out", "aggregates.csv", "Output CSV path ('-' for stdout)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	from, to, err := parsePeriod(*days, *fromStr, *toStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid period: %v\n", err)
+		return 2
+	}
+	if *k < 2 {
+		fmt.Fprintln(os.Stderr, "Invalid --k: must be at least 2")
+		return 2
+	}
+
+	db, err := openDatabase(config.Load())
+	if err != nil {
+		log.Printf("Failed to initialize ClickHouse: %v", err)
+		return 1
+	}
+	defer db.Close()
+
+	groups := map[string]string{} // Device ID → group, with --group-by
+	if *groupBy != "" {
+		devices, err := db.ListDevices()
+		if err != nil {
+			log.Printf("Failed to list devices: %v", err)
+			return 1
+		}
+		for _, d := range devices {
+			if value := d.Tags[*groupBy]; value != "" {
+				groups[d.DeviceID] = value
+			}
+		}
+	}
+	groupOf := func(deviceID string) string {
+		if *groupBy == "" {
+			return "all"
+		}
+		if group, ok := groups[deviceID]; ok {
+			return group
+		}
+		return untaggedGroup
+	}
+
+	log.Printf("Aggregating from %s to %s (bucket=%s, group-by=%q, k=%d)", from.Format(time.RFC3339), to.Format(time.RFC3339), *bucket, *groupBy, *k)
+
+	var rows []aggregateRow
+	suppressed := 0
+	for _, metric := range database.AggregateMetrics() {
+		means, err := db.GetDeviceBucketMeans(metric, *bucket, from, to)
+		if err != nil {
+			log.Printf("Failed to query %s: %v", metric, err)
+			return 1
+		}
+		metricRows, n := kAnonymousAggregates(metric, means, groupOf, *k)
+		rows = append(rows, metricRows...)
+		suppressed += n
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if !a.Bucket.Equal(b.Bucket) {
+			return a.Bucket.Before(b.Bucket)
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.Metric < b.Metric
+	})
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Printf("Failed to create %s: %v", *out, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := writeAggregateCSV(w, rows); err != nil {
+		log.Printf("Failed to write aggregates: %v", err)
+		return 1
+	}
+
+	log.Printf("Wrote %d aggregate rows to %s; suppressed %d with fewer than %d devices", len(rows), *out, suppressed, *k)
+	return 0
+}
+
+// kAnonymousAggregates summarizes the device means of a metric per bucket and
+// group. Bucket-groups with fewer than k devices are dropped and counted.
+func kAnonymousAggregates(metric string, means []database.DeviceBucketMean, groupOf func(string) string, k int) ([]aggregateRow, int) {
+	type key struct {
+		bucket int64
+		group  string
+	}
+	values := make(map[key][]float64)
+	buckets := make(map[key]time.Time)
+	for _, m := range means {
+		key := key{m.Bucket.Unix(), groupOf(m.DeviceID)}
+		values[key] = append(values[key], m.Mean)
+		buckets[key] = m.Bucket
+	}
+
+	var rows []aggregateRow
+	suppressed := 0
+	for key, vs := range values {
+		// One mean per device and bucket, so the values count devices
+		if len(vs) < k {
+			suppressed++
+			continue
+		}
+		var sum float64
+		for _, v := range vs {
+			sum += v
+		}
+		mean := sum / float64(len(vs))
+		var squares float64
+		for _, v := range vs {
+			squares += (v - mean) * (v - mean)
+		}
+		rows = append(rows, aggregateRow{
+			Bucket:  buckets[key],
+			Group:   key.group,
+			Metric:  metric,
+			Devices: len(vs),
+			Mean:    mean,
+			StdDev:  math.Sqrt(squares / float64(len(vs)-1)),
+		})
+	}
+	return rows, suppressed
+}
+
+// writeAggregateCSV writes aggregate rows as a flat CSV with a header row. Values
+// are rounded to three decimals.
+func writeAggregateCSV(w io.Writer, rows []aggregateRow) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"bucket", "group", "metric", "devices", "mean", "stddev"}); err != nil {
+		return err
+	}
+
+	for _, r := range rows {
+		record := []string{
+			r.Bucket.UTC().Format(time.RFC3339),
+			r.Group,
+			r.Metric,
+			strconv.Itoa(r.Devices),
+			strconv.FormatFloat(r.Mean, 'f', 3, 64),
+			strconv.FormatFloat(r.StdDev, 'f', 3, 64),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+
+	"iot-backend/internal/discovery"
+	"iot-backend/pkg/config"
+)
+
+// discoveryServices returns the mDNS services advertising the MQTT broker with the
+// topics devices use, and the REST API when it listens on a reachable address
+func discoveryServices(cfg *config.Config) ([]discovery.Service, error) {
+	broker, err := url.Parse(cfg.MQTTBroker)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MQTT broker URL: %w", err)
+	}
+
+	tls := "0"
+	port := 1883
+	switch broker.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		tls, port = "1", 8883
+	default:
+		return nil, fmt.Errorf("MQTT broker scheme %q can't be advertised, want tcp or ssl", broker.Scheme)
+	}
+	if p := broker.Port(); p != "" {
+		if port, err = strconv.Atoi(p); err != nil {
+			return nil, fmt.Errorf("invalid MQTT broker port %q", p)
+		}
+	}
+
+	// A broker on this host is advertised under this host's name
+	host := cfg.MDNSBrokerHost
+	if host == "" {
+		host = broker.Hostname()
+		if host == "localhost" || isLoopback(host) {
+			host = ""
+		}
+	}
+
+	txt := []string{
+		"txtvers=1",
+		"tls=" + tls,
+		"temperature=" + cfg.MQTTTopicTemperature,
+		"humidity=" + cfg.MQTTTopicHumidity,
+		"audio=" + cfg.MQTTTopicAudio,
+		"safety=" + cfg.MQTTTopicSafety,
+		"boot=" + cfg.MQTTTopicBoot,
+		"config=" + cfg.MQTTTopicDeviceConfig,
+		"command=" + cfg.MQTTTopicWindowCommand,
+	}
+	if cfg.MQTTTopicBackfill != "" {
+		txt = append(txt, "backfill="+cfg.MQTTTopicBackfill)
+	}
+	if cfg.MQTTTopicAudioChunk != "" {
+		txt = append(txt, "audio_chunk="+cfg.MQTTTopicAudioChunk, "audio_control="+cfg.MQTTTopicAudioControl)
+	}
+	if cfg.MQTTTopicPrefix != "" {
+		txt = append(txt, "prefix="+cfg.MQTTTopicPrefix)
+	}
+	if cfg.MQTTTenant != "" {
+		txt = append(txt, "tenant="+cfg.MQTTTenant)
+	}
+	if cfg.DeviceAuthRequired {
+		txt = append(txt, "auth=token")
+	}
+	services := []discovery.Service{{Type: "_mqtt._tcp", Port: port, Host: host, TXT: txt}}
+
+	if cfg.APIAddr != "" {
+		apiHost, apiPort, err := net.SplitHostPort(cfg.APIAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse API address: %w", err)
+		}
+		// An API bound to loopback isn't reachable from devices
+		if apiHost != "localhost" && !isLoopback(apiHost) {
+			p, err := strconv.Atoi(apiPort)
+			if err != nil {
+				return nil, fmt.Errorf("invalid API port %q", apiPort)
+			}
+			services = append(services, discovery.Service{Type: "_http._tcp", Port: p, TXT: []string{"txtvers=1", "path=/"}})
+		}
+	}
+	return services, nil
+}
+
+// isLoopback reports whether a host is a loopback IP address
+func isLoopback(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
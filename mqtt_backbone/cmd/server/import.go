@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"iot-backend/internal/models"
+	"iot-backend/internal/quality"
+	"iot-backend/pkg/config"
+)
+
+// readingBatchSaver stores imported readings in bulk
+type readingBatchSaver interface {
+	SaveTemperatureBatch(readings []*models.TemperatureReading) error
+	SaveHumidityBatch(readings []*models.HumidityReading) error
+}
+
+// runImportCommand loads historical sensor CSVs into ClickHouse.
+//
+//	iot-backend import [--device ID] [--batch N] [--dry-run] [--rejects FILE] FILE...
+//
+// Two layouts are accepted, detected from the header row:
+//
+//	wide: timestamp,device_id,temperature,humidity   (either value column may be absent or empty)
+//	long: timestamp,device_id,metric,value           (metric is "temperature" or "humidity")
+//
+// Timestamps may be RFC3339, "2006-01-02 15:04:05" (UTC), or Unix seconds/milliseconds.
+// Rows are scored by the same quality checks as live readings; out-of-range values are rejected.
+func runImportCommand(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	deviceID := fs.String("device", "", "Device ID for rows without a device_id column or value")
+	batchSize := fs.Int("batch", 10000, "Rows per insert")
+	dryRun := fs.Bool("dry-run", false, "Validate files without writing to ClickHouse")
+	rejectsPath := fs.String("rejects", "", "Write rejected rows with their reason to this CSV")
+	maxRejects := fs.Int("max-rejects", -1, "Abort when more rows than this are rejected (-1 for no limit)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: iot-backend import [--device ID] [--batch N] [--dry-run] [--rejects FILE] [--max-rejects N] FILE...")
+		return 2
+	}
+	if *batchSize <= 0 {
+		fmt.Fprintln(os.Stderr, "--batch must be positive")
+		return 2
+	}
+
+	imp := &csvImporter{
+		defaultDevice: *deviceID,
+		batchSize:     *batchSize,
+		maxRejects:    *maxRejects,
+		scorer:        quality.NewScorer(quality.DefaultConfig()),
+		now:           time.Now(),
+		lastProgress:  time.Now(),
+	}
+
+	if !*dryRun {
+		db, err := openDatabase(config.Load())
+		if err != nil {
+			log.Printf("Failed to initialize ClickHouse: %v", err)
+			return 1
+		}
+		defer db.Close()
+		imp.db = db
+	}
+
+	if *rejectsPath != "" {
+		f, err := os.Create(*rejectsPath)
+		if err != nil {
+			log.Printf("Failed to create %s: %v", *rejectsPath, err)
+			return 1
+		}
+		defer f.Close()
+		imp.rejects = csv.NewWriter(f)
+		imp.rejects.Write([]string{"file", "line", "reason", "record"})
+		defer imp.rejects.Flush()
+	}
+
+	start := time.Now()
+	for _, path := range fs.Args() {
+		if err := imp.importFile(path); err != nil {
+			log.Printf("Import of %s failed: %v", path, err)
+			imp.logSummary(start)
+			return 1
+		}
+	}
+	if err := imp.flush(); err != nil {
+		log.Printf("Import failed: %v", err)
+		imp.logSummary(start)
+		return 1
+	}
+
+	imp.logSummary(start)
+	if *dryRun {
+		log.Println("Dry run: nothing was written")
+	}
+	return 0
+}
+
+// errTooManyRejects aborts an import that exceeded --max-rejects
+var errTooManyRejects = errors.New("too many rejected rows")
+
+// csvImporter validates CSV rows and inserts them in batches
+type csvImporter struct {
+	db            readingBatchSaver // nil for dry runs
+	defaultDevice string
+	batchSize     int
+	maxRejects    int
+	scorer        *quality.Scorer
+	rejects       *csv.Writer
+	now           time.Time // Rows after this are rejected as future-dated
+
+	temperature []*models.TemperatureReading
+	humidity    []*models.HumidityReading
+
+	rows         int // Data rows read
+	imported     int // Readings written (a wide row can hold two)
+	rejected     int // Readings or rows that were not imported
+	suspect      int // Imported but flagged by quality checks
+	lastProgress time.Time
+}
+
+// csvColumns maps header names to column indexes (-1 when absent)
+type csvColumns struct {
+	timestamp, device, metric, value, temperature, humidity int
+}
+
+// importFile reads one CSV file
+func (imp *csvImporter) importFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	cols, err := parseImportHeader(header)
+	if err != nil {
+		return err
+	}
+	if cols.device < 0 && imp.defaultDevice == "" {
+		return fmt.Errorf("no device_id column, pass --device")
+	}
+
+	log.Printf("Importing %s", path)
+
+	line := 1
+	for {
+		record, err := r.Read()
+		line++
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if err := imp.reject(path, line, err.Error(), record); err != nil {
+				return err
+			}
+			continue
+		}
+
+		imp.rows++
+		if err := imp.importRecord(path, line, cols, record); err != nil {
+			return err
+		}
+		imp.reportProgress()
+	}
+}
+
+// parseImportHeader identifies the layout and column positions
+func parseImportHeader(header []string) (csvColumns, error) {
+	cols := csvColumns{-1, -1, -1, -1, -1, -1}
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) {
+		case "timestamp", "time", "ts":
+			cols.timestamp = i
+		case "device_id", "device":
+			cols.device = i
+		case "metric", "sensor":
+			cols.metric = i
+		case "value":
+			cols.value = i
+		case "temperature", "temp":
+			cols.temperature = i
+		case "humidity":
+			cols.humidity = i
+		}
+	}
+
+	if cols.timestamp < 0 {
+		return cols, fmt.Errorf("header has no timestamp column")
+	}
+	long := cols.metric >= 0 && cols.value >= 0
+	wide := cols.temperature >= 0 || cols.humidity >= 0
+	if long == wide {
+		return cols, fmt.Errorf("header must have either metric,value or temperature/humidity columns")
+	}
+	return cols, nil
+}
+
+// importRecord validates a row and queues its readings
+func (imp *csvImporter) importRecord(path string, line int, cols csvColumns, record []string) error {
+	field := func(i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	ts, err := parseImportTimestamp(field(cols.timestamp))
+	if err != nil {
+		return imp.reject(path, line, err.Error(), record)
+	}
+	if ts.After(imp.now) {
+		return imp.reject(path, line, "timestamp in the future", record)
+	}
+
+	deviceID := field(cols.device)
+	if deviceID == "" {
+		deviceID = imp.defaultDevice
+	}
+	if deviceID == "" {
+		return imp.reject(path, line, "missing device_id", record)
+	}
+
+	values := make(map[string]string)
+	if cols.metric >= 0 {
+		values[strings.ToLower(field(cols.metric))] = field(cols.value)
+	} else {
+		values["temperature"] = field(cols.temperature)
+		values["humidity"] = field(cols.humidity)
+	}
+
+	present := 0
+	for metric, raw := range values {
+		if raw == "" {
+			continue
+		}
+		present++
+
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			if err := imp.reject(path, line, fmt.Sprintf("invalid %s value %q", metric, raw), record); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := imp.queue(path, line, record, metric, ts, deviceID, v); err != nil {
+			return err
+		}
+	}
+	if present == 0 {
+		return imp.reject(path, line, "no values", record)
+	}
+
+	if len(imp.temperature) >= imp.batchSize || len(imp.humidity) >= imp.batchSize {
+		return imp.flush()
+	}
+	return nil
+}
+
+// queue scores a reading and adds it to the next batch unless it is unusable
+func (imp *csvImporter) queue(path string, line int, record []string, metric string, ts time.Time, deviceID string, v float64) error {
+	var result quality.Result
+	switch metric {
+	case "temperature":
+		reading := &models.TemperatureReading{Timestamp: ts, DeviceID: deviceID, Value: v}
+		result = imp.scorer.ScoreTemperature(reading)
+		if result.Flag != models.QualityBad {
+			reading.QualityScore, reading.QualityFlag = result.Score, result.Flag
+			imp.temperature = append(imp.temperature, reading)
+		}
+	case "humidity":
+		reading := &models.HumidityReading{Timestamp: ts, DeviceID: deviceID, Value: v}
+		result = imp.scorer.ScoreHumidity(reading)
+		if result.Flag != models.QualityBad {
+			reading.QualityScore, reading.QualityFlag = result.Score, result.Flag
+			imp.humidity = append(imp.humidity, reading)
+		}
+	default:
+		return imp.reject(path, line, fmt.Sprintf("unknown metric %q", metric), record)
+	}
+
+	switch result.Flag {
+	case models.QualityBad:
+		return imp.reject(path, line, metric+" "+result.Reason, record)
+	case models.QualitySuspect:
+		imp.suspect++
+	}
+	return nil
+}
+
+// parseImportTimestamp accepts the timestamp formats common in exports
+func parseImportTimestamp(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, fmt.Errorf("missing timestamp")
+	}
+
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n > 1e11 {
+			return time.UnixMilli(n).UTC(), nil
+		}
+		return time.Unix(n, 0).UTC(), nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+}
+
+// reject records a rejected row, failing once --max-rejects is exceeded
+func (imp *csvImporter) reject(path string, line int, reason string, record []string) error {
+	imp.rejected++
+	if imp.rejects != nil {
+		imp.rejects.Write([]string{path, strconv.Itoa(line), reason, strings.Join(record, ",")})
+	}
+	if imp.maxRejects >= 0 && imp.rejected > imp.maxRejects {
+		return fmt.Errorf("%w (%d, last at line %d: %s)", errTooManyRejects, imp.rejected, line, reason)
+	}
+	return nil
+}
+
+// flush writes queued readings
+func (imp *csvImporter) flush() error {
+	count := len(imp.temperature) + len(imp.humidity)
+
+	if imp.db != nil {
+		if len(imp.temperature) > 0 {
+			if err := imp.db.SaveTemperatureBatch(imp.temperature); err != nil {
+				return err
+			}
+		}
+		if len(imp.humidity) > 0 {
+			if err := imp.db.SaveHumidityBatch(imp.humidity); err != nil {
+				return err
+			}
+		}
+	}
+
+	imp.imported += count
+	imp.temperature = imp.temperature[:0]
+	imp.humidity = imp.humidity[:0]
+	return nil
+}
+
+// reportProgress logs running totals at most every 5 seconds
+func (imp *csvImporter) reportProgress() {
+	if time.Since(imp.lastProgress) < 5*time.Second {
+		return
+	}
+	imp.lastProgress = time.Now()
+	log.Printf("Import progress: %d rows read, %d readings imported, %d rejected", imp.rows, imp.imported, imp.rejected)
+}
+
+// logSummary logs final totals
+func (imp *csvImporter) logSummary(start time.Time) {
+	elapsed := time.Since(start)
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(imp.rows) / elapsed.Seconds()
+	}
+	log.Printf("Import finished: %d rows read, %d readings imported (%d suspect), %d rejected in %v (%.0f rows/s)",
+		imp.rows, imp.imported, imp.suspect, imp.rejected, elapsed.Round(time.Millisecond), rate)
+}
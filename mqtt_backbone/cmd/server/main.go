@@ -2,61 +2,153 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"iot-backend/internal/aggregator"
+	"iot-backend/internal/alerts"
+	"iot-backend/internal/api"
+	"iot-backend/internal/bus"
+	"iot-backend/internal/clipstore"
 	"iot-backend/internal/database"
-	"iot-backend/internal/models"
+	"iot-backend/internal/discovery"
+	"iot-backend/internal/gateway"
+	"iot-backend/internal/hotstore"
+	"iot-backend/internal/ingest"
+	"iot-backend/internal/metrics"
 	"iot-backend/internal/mqtt"
+	"iot-backend/internal/predict"
+	"iot-backend/internal/preflight"
+	"iot-backend/internal/quality"
+	"iot-backend/internal/rules"
 	"iot-backend/internal/services"
+	"iot-backend/internal/state"
 	"iot-backend/pkg/config"
 )
 
+// version is reported in logs and on the backend status topic
+const version = "2.0"
+
 func main() {
-	log.Println("Starting IoT Backend Service v2.0 (CQRS-Based Inference)...")
+	// Subcommands (e.g., "iot-backend dataset build") run instead of the server
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	log.Printf("Starting IoT Backend Service v%s (CQRS-Based Inference)...", version)
 
 	// Load configuration
 	cfg := config.Load()
+	observer, err := applyInstanceRole(cfg)
+	if err != nil {
+		log.Fatalf("Invalid instance role: %v", err)
+	}
 
 	// Initialize ClickHouse database
-	db, err := database.NewClickHouseDB(
-		cfg.ClickHouseAddr,
-		cfg.ClickHouseDB,
-		cfg.ClickHouseUser,
-		cfg.ClickHousePass,
-	)
+	db, err := openDatabase(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize ClickHouse: %v", err)
 	}
 	defer db.Close()
+	if observer {
+		db.SetReadOnly()
+	}
+
+	// === Preflight ===
+	// Runs after schema initialization so a fresh database passes
+	if cfg.PreflightOnStartup {
+		report := preflight.Run(cfg)
+		report.Print(log.Writer())
+		if !report.Passed {
+			log.Fatalf("Preflight failed: %d check(s) did not pass", len(report.Failures()))
+		}
+	}
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// === Channel Creation ===
-	// These channels connect MQTT layer with services layer
-	log.Println("Creating communication channels...")
+	// === Restore Device State ===
+	deviceState := state.NewStore(cfg.DeviceStateFile)
+	if cfg.DeviceStateShared {
+		if cfg.DeviceStateHandoffIdleSeconds <= 0 {
+			log.Fatalf("Invalid DEVICE_STATE_HANDOFF_IDLE_SECONDS: must be positive")
+		}
+		// Replicas taking devices over read their state from ClickHouse instead of starting fresh
+		deviceState.Shared = db
+		deviceState.HandoffIdle = time.Duration(cfg.DeviceStateHandoffIdleSeconds) * time.Second
+		log.Printf("Device state handed off through ClickHouse (re-read after %v idle)", deviceState.HandoffIdle)
+	}
+	if err := deviceState.Load(); err != nil {
+		log.Printf("Warning: could not restore device state, starting fresh: %v", err)
+	}
+	go deviceState.Start(ctx, time.Duration(cfg.DeviceStateSaveIntervalSeconds)*time.Second)
 
-	// Sensor data channels (MQTT → Services)
-	tempChan := make(chan *models.TemperatureReading, 100)
-	humidityChan := make(chan *models.HumidityReading, 100)
-	audioChan := make(chan *models.AudioRecording, 50)
-	windowControlChan := make(chan *models.InferenceResponse, 50)
+	// === Hot Reading Store ===
+	// The last minutes of readings, so inference windows and short history ranges skip ClickHouse
+	var hotStore *hotstore.Store
+	if cfg.HotStoreMinutes > 0 {
+		if cfg.HotStoreMaxReadings <= 0 {
+			log.Fatalf("Invalid HOT_STORE_MAX_READINGS: must be positive")
+		}
+		hotConfig := hotstore.DefaultConfig()
+		hotConfig.Window = time.Duration(cfg.HotStoreMinutes) * time.Minute
+		hotConfig.MaxReadings = cfg.HotStoreMaxReadings
+		hotStore = hotstore.New(hotConfig)
+	}
 
-	// Inference request channel (Services → MQTT)
-	inferenceReqChan := make(chan *models.InferenceRequest, 50)
+	// === Event Bus ===
+	// Typed topics connect the MQTT layer with the services layer.
+	// New consumers subscribe to a topic instead of being wired channel by channel.
+	log.Println("Creating event bus...")
+	if cfg.ChannelReadingSize <= 0 || cfg.ChannelAudioSize <= 0 || cfg.ChannelSafetySize <= 0 || cfg.ChannelInferenceSize <= 0 {
+		log.Fatalf("Invalid channel sizes: CHANNEL_READING_SIZE, CHANNEL_AUDIO_SIZE, CHANNEL_SAFETY_SIZE, and CHANNEL_INFERENCE_SIZE must be positive")
+	}
+	busConfig := bus.DefaultConfig()
+	busConfig.ReadingBufferSize = cfg.ChannelReadingSize
+	busConfig.AudioBufferSize = cfg.ChannelAudioSize
+	busConfig.SafetyBufferSize = cfg.ChannelSafetySize
+	busConfig.InferenceBufferSize = cfg.ChannelInferenceSize
+	eventBus := bus.New(busConfig)
+
+	// === Metrics ===
+	mqtt.SetSlowBrokerThreshold(time.Duration(cfg.MQTTSlowBrokerMs) * time.Millisecond)
+	go metrics.Default.Start(ctx, time.Duration(cfg.MetricsLogIntervalSeconds)*time.Second)
 
 	// === Initialize MQTT Client ===
 	log.Println("Connecting to MQTT broker...")
 	mqttConfig := mqtt.ClientConfig{
-		Broker:   cfg.MQTTBroker,
-		ClientID: cfg.MQTTClientID,
-		Username: cfg.MQTTUsername,
-		Password: cfg.MQTTPassword,
+		Broker:      cfg.MQTTBroker,
+		ClientID:    cfg.MQTTClientID,
+		Username:    cfg.MQTTUsername,
+		Password:    cfg.MQTTPassword,
+		TopicPrefix: cfg.MQTTTopicPrefix,
+		TLS: mqtt.TLSConfig{
+			CAFile:     cfg.MQTTCAFile,
+			CertFile:   cfg.MQTTCertFile,
+			KeyFile:    cfg.MQTTKeyFile,
+			ServerName: cfg.MQTTTLSServerName,
+		},
+		ReadOnly: observer,
+		Backoff: mqtt.BackoffConfig{
+			Initial: time.Duration(cfg.MQTTReconnectInitialMs) * time.Millisecond,
+			Max:     time.Duration(cfg.MQTTReconnectMaxSeconds) * time.Second,
+			Jitter:  cfg.MQTTReconnectJitter,
+		},
+	}
+	if mqttConfig.Backoff.Initial <= 0 || mqttConfig.Backoff.Max < mqttConfig.Backoff.Initial ||
+		mqttConfig.Backoff.Jitter < 0 || mqttConfig.Backoff.Jitter > 1 {
+		log.Fatalf("Invalid MQTT reconnect backoff: need 0 < MQTT_RECONNECT_INITIAL_MS <= MQTT_RECONNECT_MAX_SECONDS and 0 <= MQTT_RECONNECT_JITTER <= 1")
+	}
+	if cfg.MQTTTopicBackendStatus != "" {
+		// The broker marks the backend offline if it disappears without a clean shutdown
+		mqttConfig.WillTopic = cfg.MQTTTopicBackendStatus
+		mqttConfig.Will = mqtt.LastWill(version)
 	}
 
 	mqttClient, err := mqtt.NewClient(mqttConfig)
@@ -65,94 +157,804 @@ func main() {
 	}
 	defer mqttClient.Close()
 
+	// === Initialize ML Routing ===
+	// Inference requests are spread over one or more ML service instances
+	routingPolicy, err := mqtt.ParseRoutingPolicy(cfg.MQTTInferenceRouting)
+	if err != nil {
+		log.Fatalf("Invalid inference routing: %v", err)
+	}
+	inferenceRouter, err := mqtt.NewInferenceRouter(
+		strings.Split(cfg.MQTTTopicInferenceReq, ","),
+		routingPolicy,
+		time.Duration(cfg.MQTTInferenceTimeoutSeconds)*time.Second,
+	)
+	if err != nil {
+		log.Fatalf("Invalid inference request topics: %v", err)
+	}
+
+	// Additional models receive every request on their own topics
+	mlModels, err := mqtt.ParseMLModels(cfg.MLModels)
+	if err != nil {
+		log.Fatalf("Invalid ML models: %v", err)
+	}
+	for _, m := range mlModels {
+		log.Printf("ML model %s: requests on %s, responses on %s, features %v", m.Name, m.RequestTopic, m.ResponseTopic, m.Features)
+	}
+
+	// Inference domains replace the single window request with one request per domain,
+	// each sent to its own model; the window service merges their positions
+	domainRoutes, err := mqtt.ParseMLModels(cfg.InferenceDomains)
+	if err != nil {
+		log.Fatalf("Invalid inference domains: %v", err)
+	}
+	inferenceDomains, err := services.InferenceDomains(domainRoutes)
+	if err != nil {
+		log.Fatalf("Invalid inference domains: %v", err)
+	}
+	if len(inferenceDomains) > 0 && cfg.InferenceDomainHoldMinutes < 1 {
+		log.Fatalf("Invalid inference domain hold: %d minutes (must be at least 1)", cfg.InferenceDomainHoldMinutes)
+	}
+	for _, d := range domainRoutes {
+		log.Printf("Inference domain %s: requests on %s, responses on %s, features %v", d.Name, d.RequestTopic, d.ResponseTopic, d.Features)
+	}
+
+	// Unanswered requests are recorded in ml_timeouts
+	pendingInferences := mqtt.NewPendingInferences(time.Duration(cfg.MQTTInferenceTimeoutSeconds)*time.Second, db)
+	go pendingInferences.Start(ctx)
+
+	// Window commands are resent until their actuator acknowledges them; outcomes go to window_command_outcomes
+	var commandTracker *mqtt.CommandTracker
+	if cfg.MQTTTopicWindowAck != "" {
+		trackerConfig := mqtt.DefaultCommandTrackerConfig()
+		trackerConfig.AckTimeout = time.Duration(cfg.WindowCommandAckTimeoutSeconds) * time.Second
+		trackerConfig.MaxAttempts = cfg.WindowCommandMaxAttempts
+		trackerConfig.PositionTolerance = cfg.WindowCommandPositionTolerance
+		if trackerConfig.MaxAttempts < 1 {
+			log.Fatalf("Invalid window command max attempts: %d (must be at least 1)", trackerConfig.MaxAttempts)
+		}
+		commandTracker = mqtt.NewCommandTracker(trackerConfig, db)
+		go commandTracker.Start(ctx)
+	}
+
+	// Chunked audio uploads are reassembled on disk, so a dropped connection or a restart only costs missing chunks
+	var audioUploads *mqtt.AudioUploads
+	if cfg.MQTTTopicAudioChunk != "" {
+		uploadConfig := mqtt.DefaultAudioUploadConfig()
+		uploadConfig.NackAfter = time.Duration(cfg.AudioUploadNackSeconds) * time.Second
+		uploadConfig.MaxNacks = cfg.AudioUploadMaxNacks
+		uploadConfig.MaxChunks = cfg.AudioUploadMaxChunks
+		uploadConfig.MaxClipBytes = cfg.AudioUploadMaxBytes
+		if uploadConfig.NackAfter <= 0 || uploadConfig.MaxNacks < 1 || uploadConfig.MaxChunks < 1 || uploadConfig.MaxClipBytes < 1 {
+			log.Fatalf("Invalid chunked audio uploads: NACK interval, max NACKs, max chunks and max bytes must be positive")
+		}
+		if cfg.MQTTTopicAudioControl == "" {
+			log.Fatalf("Invalid chunked audio uploads: MQTT_TOPIC_AUDIO_CONTROL is required with MQTT_TOPIC_AUDIO_CHUNK")
+		}
+		uploadStore, err := clipstore.NewFileStore(cfg.AudioUploadDir)
+		if err != nil {
+			log.Fatalf("Failed to open audio upload directory: %v", err)
+		}
+		audioUploads, err = mqtt.NewAudioUploads(uploadConfig, uploadStore)
+		if err != nil {
+			log.Fatalf("Failed to restore audio uploads: %v", err)
+		}
+		go audioUploads.Start(ctx)
+	}
+
+	// === Initialize Reading Ingestion ===
+	// Every transport hands its readings to one pipeline, which validates them and
+	// queues them on the event bus for the sensor service
+	readingPipeline := ingest.NewBusPipeline(
+		ingest.DefaultPipelineConfig(),
+		eventBus.Temperature.In(),
+		eventBus.Humidity.In(),
+		eventBus.Audio.In(),
+		eventBus.Safety.In(),
+		eventBus.Presence.In(),
+	)
+	startIngestor := func(ingestor ingest.ReadingIngestor) {
+		if err := ingestor.Start(ctx, readingPipeline); err != nil {
+			log.Fatalf("Failed to start %s ingestion: %v", ingestor.Name(), err)
+		}
+		log.Printf("Ingesting readings over %s", ingestor.Name())
+	}
+
 	// === Initialize MQTT Subscriber ===
 	log.Println("Setting up MQTT subscriber...")
 	subscriberConfig := mqtt.SubscriberConfig{
 		TemperatureTopic:   cfg.MQTTTopicTemperature,
 		HumidityTopic:      cfg.MQTTTopicHumidity,
 		AudioTopic:         cfg.MQTTTopicAudio,
+		AudioChunkTopic:    cfg.MQTTTopicAudioChunk,
 		WindowControlTopic: cfg.MQTTTopicWindowControl,
+		WindowAckTopic:     cfg.MQTTTopicWindowAck,
+		SafetyTopic:        cfg.MQTTTopicSafety,
+		FrameTopic:         cfg.MQTTTopicFrame,
+		BackfillTopic:      cfg.MQTTTopicBackfill,
+		BootTopic:          cfg.MQTTTopicBoot,
+		MotionTopic:        cfg.MQTTTopicMotion,
+		CO2Topic:           cfg.MQTTTopicCO2,
+		Models:             mlModels,
+		Domains:            domainRoutes,
+		Tenant:             cfg.MQTTTenant,
+	}
+
+	if cfg.MQTTFrameLayout != "" {
+		layout, err := mqtt.ParseFrameLayout(cfg.MQTTFrameLayout, cfg.MQTTFrameByteOrder)
+		if err != nil {
+			log.Fatalf("Invalid binary frame layout: %v", err)
+		}
+		subscriberConfig.FrameLayout = layout
+	}
+
+	if cfg.MQTTTopicLoRaWAN != "" {
+		codecs, err := mqtt.ParseLoRaWANCodecs(cfg.LoRaWANCodecs, cfg.LoRaWANDefaultCodec, cfg.LoRaWANByteOrder)
+		if err != nil {
+			log.Fatalf("Invalid LoRaWAN codecs: %v", err)
+		}
+		subscriberConfig.LoRaWANTopic = cfg.MQTTTopicLoRaWAN
+		subscriberConfig.LoRaWANCodecs = codecs
 	}
 
 	subscriber := mqtt.NewSubscriber(
 		mqttClient.GetNativeClient(),
 		subscriberConfig,
-		tempChan,
-		humidityChan,
-		audioChan,
-		windowControlChan,
+		eventBus.InferenceResponses.In(),
 	)
 
+	// Unparseable ML responses are kept for inspection
+	subscriber.DeadLetters = db
+
+	// Responses of the additional models are recorded, not actuated
+	subscriber.ModelPredictions = db
+
+	// Disconnections are recorded per subscription, which is restored after reconnecting
+	subscriber.Gaps = db
+
+	// Devices with a registry payload key encrypt audio and receive encrypted configs
+	cipherConfig := mqtt.DefaultCipherConfig()
+	cipherConfig.Required = cfg.PayloadEncryptionRequired
+	payloadCipher := mqtt.NewPayloadCipher(db, cipherConfig)
+	subscriber.Cipher = payloadCipher
+
+	// Devices with a registry token must send it with every message
+	authConfig := mqtt.DefaultAuthConfig()
+	authConfig.Required = cfg.DeviceAuthRequired
+	subscriber.Auth = mqtt.NewDeviceAuthenticator(db, authConfig)
+
+	// Boot announcements are answered by the config sync service
+	subscriber.BootChan = eventBus.Boot.In()
+
+	// Replayed offline buffers are stored by the sensor service with their own timestamps
+	subscriber.BackfillChan = eventBus.Backfill.In()
+
+	// Sampled message lifecycles for debugging parsing problems in production
+	if cfg.MQTTTraceRates != "" {
+		traceConfig := mqtt.DefaultTraceConfig()
+		traceConfig.Rates, err = mqtt.ParseTraceRates(cfg.MQTTTraceRates)
+		if err != nil {
+			log.Fatalf("Invalid MQTT trace rates: %v", err)
+		}
+		traceConfig.MaxPayloadBytes = cfg.MQTTTraceMaxPayloadBytes
+
+		var traceSink mqtt.TraceSink = db
+		if cfg.MQTTTraceFile != "" {
+			fileSink, err := mqtt.NewFileTraceSink(cfg.MQTTTraceFile)
+			if err != nil {
+				log.Fatalf("Failed to open MQTT trace file: %v", err)
+			}
+			defer fileSink.Close()
+			traceSink = fileSink
+		}
+
+		tracer := mqtt.NewTracer(traceConfig, traceSink)
+		go tracer.Start(ctx)
+		subscriber.Tracer = tracer
+		log.Printf("MQTT tracing enabled: %s", cfg.MQTTTraceRates)
+	}
+
+	statsConfig := mqtt.DefaultStatsConfig()
+	statsConfig.FlushInterval = time.Duration(cfg.DeviceStatsFlushSeconds) * time.Second
+	messageStats := mqtt.NewMessageStats(statsConfig, db)
+	go messageStats.Start(ctx)
+	subscriber.Stats = messageStats
+
+	// ML responses release the request's in-flight slot
+	subscriber.InferenceRouter = inferenceRouter
+	subscriber.Pending = pendingInferences
+	subscriber.Commands = commandTracker
+	subscriber.Uploads = audioUploads
+
 	// Subscribe to all topics
-	if err := subscriber.SubscribeAll(); err != nil {
-		log.Fatalf("Failed to subscribe to MQTT topics: %v", err)
+	startIngestor(subscriber)
+	if n, err := db.CloseOpenConnectionGaps(time.Now()); err != nil {
+		log.Printf("Warning: Could not close connection gaps left open: %v", err)
+	} else if n > 0 {
+		log.Printf("Closed %d connection gaps left open by the previous run", n)
 	}
+	mqttClient.OnConnectionLost(subscriber.ConnectionLost)
+	mqttClient.OnConnect(subscriber.Resubscribe)
 
 	// === Initialize MQTT Publisher ===
 	log.Println("Setting up MQTT publisher...")
 	publisherConfig := mqtt.PublisherConfig{
-		InferenceReqTopic: cfg.MQTTTopicInferenceReq,
+		InferenceReqTopic:  cfg.MQTTTopicInferenceReq,
+		InferenceRouter:    inferenceRouter,
+		Pending:            pendingInferences,
+		Commands:           commandTracker,
+		Uploads:            audioUploads,
+		WindowCommandTopic: cfg.MQTTTopicWindowCommand,
+		ShadowCommandTopic: cfg.MQTTTopicShadowCommand,
+		DeviceConfigTopic:  cfg.MQTTTopicDeviceConfig,
+		AudioControlTopic:  cfg.MQTTTopicAudioControl,
+		Models:             mlModels,
+		Domains:            domainRoutes,
+		Tenant:             cfg.MQTTTenant,
+	}
+	for _, topic := range publisherTopics(cfg, mlModels, domainRoutes) {
+		if _, err := mqtt.ParseTopicTemplate(topic); err != nil {
+			log.Fatalf("Invalid MQTT topic: %v", err)
+		}
 	}
 
 	publisher := mqtt.NewPublisher(
 		mqttClient.GetNativeClient(),
 		publisherConfig,
-		inferenceReqChan,
+		eventBus.InferenceRequests.Subscribe("mqtt-publisher", cfg.ChannelInferenceSize),
 	)
 
+	// Every published feature vector is kept for reproducing predictions
+	publisher.FeatureSnapshots = db
+	publisher.Cipher = payloadCipher
+
 	// Start publisher goroutine
 	go publisher.Start(ctx)
 
+	// === Initialize Gateway Ingestion ===
+	// Gateways stream readings over gRPC and receive their devices' window commands the same way
+	var commandPublisher services.CommandPublisher = publisher
+	if cfg.GatewayGRPCAddr != "" {
+		gatewayConfig := gateway.DefaultServerConfig()
+		gatewayConfig.Addr = cfg.GatewayGRPCAddr
+		gatewayTokens, err := gateway.ParseTokens(cfg.GatewayGRPCTokens)
+		if err != nil {
+			log.Fatalf("Invalid GATEWAY_GRPC_TOKENS: %v", err)
+		}
+		gatewayConfig.Tokens = gatewayTokens
+		gatewayConfig.MaxMessageBytes = cfg.GatewayGRPCMaxMessageBytes
+		gatewayConfig.TLSCertFile = cfg.GatewayGRPCTLSCertFile
+		gatewayConfig.TLSKeyFile = cfg.GatewayGRPCTLSKeyFile
+		gatewayConfig.TLSClientCAFile = cfg.GatewayGRPCClientCAFile
+		gatewayConfig.Insecure = cfg.GatewayGRPCInsecure
+		gatewayConfig.ReleaseAfter = time.Duration(cfg.GatewayGRPCReleaseMinutes) * time.Minute
+
+		gatewayServer := gateway.NewServer(gatewayConfig)
+		commandPublisher = gateway.NewCommandRouter(gatewayServer, publisher)
+		startIngestor(gatewayServer)
+	}
+
+	// Made-up devices for trying a deployment without hardware
+	if cfg.SimulatorDevices > 0 {
+		simulatorConfig := ingest.DefaultSimulatorConfig()
+		simulatorConfig.Devices = cfg.SimulatorDevices
+		simulatorConfig.Interval = time.Duration(cfg.SimulatorIntervalSeconds) * time.Second
+		startIngestor(ingest.NewSimulator(simulatorConfig))
+	}
+
 	// === Initialize Inference Service (CQRS-based) ===
 	log.Println("Initializing CQRS-based inference service...")
+	pollingMode, err := services.ParsePollingMode(cfg.InferencePollingMode)
+	if err != nil {
+		log.Fatalf("Invalid inference polling mode: %v", err)
+	}
+	// Sample minimums are compared as unsigned counts, where a negative one would never be reached
+	if min(cfg.InferenceMinBaselineSamples, cfg.InferenceMinHourSamples, cfg.InferenceDriftMinSamples) < 0 {
+		log.Fatalf("Invalid INFERENCE_MIN_BASELINE_SAMPLES, INFERENCE_MIN_HOUR_SAMPLES or INFERENCE_DRIFT_MIN_SAMPLES: must not be negative")
+	}
 	inferenceConfig := services.InferenceServiceConfig{
 		PollingIntervalSeconds: cfg.InferencePollingIntervalSeconds,
+		PollingMode:            pollingMode,
 		DataWindowSeconds:      cfg.InferenceDataWindowSeconds,
 		HistoricalBaselineDays: cfg.InferenceHistoricalBaselineDays,
 		ZScoreThreshold:        cfg.InferenceZScoreThreshold,
-		ChannelSize:            50,
+		ChannelSize:            cfg.ChannelInferenceSize,
+		HourlyBaseline:         cfg.InferenceHourlyBaseline,
+		MinHourSamples:         cfg.InferenceMinHourSamples,
+		ProfileRefreshMinutes:  cfg.InferenceProfileRefreshMinutes,
+		DriftWindowSeconds:     cfg.InferenceDriftWindowSeconds,
+		DriftThreshold:         cfg.InferenceDriftThreshold,
+		DriftMinSamples:        cfg.InferenceDriftMinSamples,
+		MinBaselineSamples:     cfg.InferenceMinBaselineSamples,
+		ColdStartMaxPerPoll:    cfg.InferenceColdStartMaxPerPoll,
+		ColdStartJitterSeconds: cfg.InferenceColdStartJitterSeconds,
+		ManualCooldownSeconds:  cfg.InferenceManualCooldownSeconds,
+		Domains:                inferenceDomains,
 	}
 
-	inferenceService := services.NewInferenceService(db, inferenceConfig)
+	inferenceService := services.NewInferenceService(db, deviceState, inferenceConfig)
+	inferenceService.Hot = hotStore
 
-	// Connect inference service output to publisher input
-	// (They share the same channel)
-	inferenceService.InferenceReqChan = inferenceReqChan
+	// The local model's feature scaling goes with every request, so the ML service
+	// sees inputs scaled exactly as dry-run predictions are
+	var localModel *predict.LinearModel
+	if cfg.ModelPath != "" {
+		localModel, err = predict.LoadLinearModel(cfg.ModelPath)
+		if err != nil {
+			log.Printf("Warning: Local model unavailable, dry-run predictions and request scaling disabled: %v", err)
+			localModel = nil
+		} else {
+			log.Printf("Loaded local model %s from %s (%d features, %s scaling)",
+				localModel.Version, cfg.ModelPath, len(localModel.Features()), localModel.Scaling().Type)
+		}
+	}
+
+	// Seasonal and outdoor temperature variants replace the local model while they match;
+	// each request names the variant it was scaled for
+	modelVariants, err := predict.ParseModelVariants(cfg.ModelVariants)
+	if err != nil {
+		log.Fatalf("Invalid model variants: %v", err)
+	}
+	if cfg.ModelSeasonHemisphere != "north" && cfg.ModelSeasonHemisphere != "south" {
+		log.Fatalf("Invalid model season hemisphere: %q (want north or south)", cfg.ModelSeasonHemisphere)
+	}
+	for _, v := range modelVariants {
+		log.Printf("Loaded model variant %s: %s (season %q, outdoor %.1f..%.1f°C)", v.Name, v.Model.Version, v.Season, v.OutdoorMin, v.OutdoorMax)
+	}
+	var localModels *predict.ModelSet
+	if localModel != nil || len(modelVariants) > 0 {
+		localModels = predict.NewModelSet(localModel, modelVariants, cfg.ModelSeasonHemisphere == "south")
+		inferenceService.Models = localModels
+	}
+
+	// Inference requests are published on the bus (the MQTT publisher subscribes)
+	inferenceService.InferenceReqChan = eventBus.InferenceRequests.In()
 
-	// Start inference service (polling loop)
-	go inferenceService.Start(ctx)
+	// Flat sensors get the last response again instead of another ML round trip
+	if cfg.InferenceCacheEnabled {
+		if min(cfg.InferenceCacheEpsilon, cfg.InferenceCacheEpsilonTemperature, cfg.InferenceCacheEpsilonHumidity,
+			cfg.InferenceCacheEpsilonSoundVolume) < 0 || cfg.InferenceCacheMaxAgeSeconds <= 0 {
+			log.Fatalf("Invalid inference cache: INFERENCE_CACHE_EPSILON* must not be negative and INFERENCE_CACHE_MAX_AGE_SECONDS must be positive")
+		}
+		cacheConfig := services.DefaultInferenceCacheConfig()
+		cacheConfig.FeatureEpsilons = map[string]float64{
+			"temperature":  cfg.InferenceCacheEpsilonTemperature,
+			"humidity":     cfg.InferenceCacheEpsilonHumidity,
+			"sound_volume": cfg.InferenceCacheEpsilonSoundVolume,
+		}
+		cacheConfig.Epsilon = cfg.InferenceCacheEpsilon
+		cacheConfig.MaxAge = time.Duration(cfg.InferenceCacheMaxAgeSeconds) * time.Second
+		inferenceCache := services.NewInferenceCache(cacheConfig)
+		inferenceCache.ResponseChan = eventBus.InferenceResponses.Subscribe("inference-cache", cacheConfig.ChannelSize)
+		go inferenceCache.Start(ctx)
+		inferenceService.Cache = inferenceCache
+		inferenceService.CachedResponseChan = eventBus.InferenceResponses.In()
+	}
+
+	// === Initialize Threshold Suggestions ===
+	// Recommended trigger thresholds for the configured trigger frequency, served by the API
+	var thresholdAdvisor *services.ThresholdAdvisor
+	if cfg.ThresholdSuggestionRefreshHours > 0 {
+		advisorConfig := services.DefaultThresholdAdvisorConfig()
+		advisorConfig.Days = cfg.ThresholdSuggestionDays
+		advisorConfig.WindowSeconds = cfg.InferenceDataWindowSeconds
+		advisorConfig.TargetTriggersPerDay = cfg.ThresholdTargetTriggersPerDay
+		advisorConfig.CurrentZScore = cfg.InferenceZScoreThreshold
+		advisorConfig.RefreshInterval = time.Duration(cfg.ThresholdSuggestionRefreshHours) * time.Hour
+		thresholdAdvisor = services.NewThresholdAdvisor(db, advisorConfig)
+		go thresholdAdvisor.Start(ctx)
+	}
+
+	// === Initialize Alerts ===
+	alertManager := alerts.NewManager(db, time.Duration(cfg.AlertCooldownMinutes)*time.Minute, alerts.LogNotifier{})
+	alertRules, err := alerts.ParseRules(cfg.AlertRules)
+	if err != nil {
+		log.Fatalf("Invalid alert rules: %v", err)
+	}
+	alertManager.SetRules(alertRules, db)
+	alertManager.SetSyntheticDevices(syntheticDevices(cfg))
+	if err := alertManager.EnableSnoozes(db); err != nil {
+		log.Fatalf("Failed to restore alert snoozes: %v", err)
+	}
+	go alertManager.Start(ctx)
+	if cfg.SMTPHost != "" && cfg.AlertEmailRoutes != "" {
+		emailRoutes, err := alerts.ParseRoutes(cfg.AlertEmailRoutes)
+		if err != nil {
+			log.Fatalf("Invalid alert email routes: %v", err)
+		}
+		emailConfig := alerts.DefaultEmailConfig()
+		emailConfig.Host = cfg.SMTPHost
+		emailConfig.Port = cfg.SMTPPort
+		emailConfig.Username = cfg.SMTPUsername
+		emailConfig.Password = cfg.SMTPPassword
+		emailConfig.From = cfg.SMTPFrom
+		alertManager.AddNotifier(alerts.NewEmailNotifier(emailConfig, emailRoutes, db))
+		log.Printf("Email alerts enabled via %s:%d (%d routes)", cfg.SMTPHost, cfg.SMTPPort, len(emailRoutes))
+	}
 
 	// === Initialize Sensor Service ===
 	log.Println("Initializing sensor service...")
 	sensorConfig := services.DefaultSensorServiceConfig()
+	sensorConfig.TempChannelSize = cfg.ChannelReadingSize
+	sensorConfig.HumidityChannelSize = cfg.ChannelReadingSize
+	sensorConfig.PresenceChannelSize = cfg.ChannelReadingSize
+	sensorConfig.AudioChannelSize = cfg.ChannelAudioSize
+	sensorConfig.SafetyChannelSize = cfg.ChannelSafetySize
+	sensorConfig.SafetyMaxLatencyMs = cfg.SafetyMaxLatencyMs
+	sensorConfig.SuppressOutliers = cfg.SuppressOutliers
+	sensorConfig.OutdoorMaxAge = time.Duration(cfg.ModelOutdoorMinutes) * time.Minute
+	sensorConfig.Quality.AudioSampleRates, err = quality.ParseSampleRates(cfg.AudioSampleRates)
+	if err != nil {
+		log.Fatalf("Invalid audio sample rates: %v", err)
+	}
+	sensorConfig.Quality.AudioDurationTolerance = cfg.AudioDurationTolerance
+	sensorConfig.AudioDownmix, err = aggregator.ParseDownmixStrategy(cfg.AudioDownmix)
+	if err != nil {
+		log.Fatalf("Invalid audio downmix: %v", err)
+	}
+	if cfg.AudioClippingThreshold <= 0 || cfg.AudioClippingThreshold > 32767 {
+		log.Fatalf("Invalid AUDIO_CLIPPING_THRESHOLD: must be between 1 and 32767")
+	}
+	sensorConfig.Quality.AudioClippingThreshold = cfg.AudioClippingThreshold
+	sensorConfig.AGCCompensation = cfg.AudioAGCCompensation
+	sensorConfig.AudioDedupWindow = time.Duration(cfg.AudioDedupWindowSeconds) * time.Second
+	if cfg.BackfillMaxAgeHours < 1 {
+		log.Fatalf("Invalid BACKFILL_MAX_AGE_HOURS: %d (must be at least 1)", cfg.BackfillMaxAgeHours)
+	}
+	sensorConfig.BackfillMaxAge = time.Duration(cfg.BackfillMaxAgeHours) * time.Hour
+	sensorConfig.BackfillDedupWindow = time.Duration(cfg.BackfillDedupMinutes) * time.Minute
+	sensorConfig.AudioExtraction.Workers = cfg.AudioExtractionWorkers
+	sensorConfig.WorkersPerSensor = cfg.SensorWorkersPerType
+	sensorConfig.MoldRisk.Thresholds.HumidityThreshold = cfg.MoldRiskHumidityThreshold
+	sensorConfig.MoldRisk.Thresholds.SustainedHours = cfg.MoldRiskSustainedHours
+	sensorConfig.MoldRisk.AlertThreshold = cfg.MoldRiskAlertThreshold
+	sensorConfig.AudioAnomaly.ScoreThreshold = cfg.AudioAnomalyThreshold
+	sensorConfig.AudioAnomaly.SustainedClips = cfg.AudioAnomalySustainedClips
+	sensorConfig.NoiseFloor.CalibrationHours = cfg.NoiseFloorCalibrationHours
+	sensorConfig.Occupancy.Estimator.Prior = cfg.OccupancyPrior
+	sensorConfig.Occupancy.SaveIntervalSeconds = cfg.OccupancySaveIntervalSeconds
+	sensorConfig.Noise.Level = cfg.NoiseExposureLevel
+	sensorConfig.Noise.Percentile = cfg.NoiseExposurePercentile
+	sensorConfig.Noise.Minutes = cfg.NoiseExposureMinutes
+	sensorConfig.Noise.CloseWindow = cfg.NoiseExposureCloseWindow
+	sensorConfig.Noise.QuietStartHour, sensorConfig.Noise.QuietEndHour, err = services.ParseQuietHours(cfg.NoiseExposureQuietHours)
+	if err != nil {
+		log.Fatalf("Invalid noise exposure quiet hours: %v", err)
+	}
+	if err := sensorConfig.Noise.Validate(); err != nil {
+		log.Fatalf("Invalid noise exposure config: %v", err)
+	}
 
-	sensorService := services.NewSensorService(db, inferenceService, sensorConfig)
+	sensorService := services.NewSensorService(db, inferenceService, deviceState, sensorConfig)
+	sensorService.Alerts = alertManager
+	sensorService.Hot = hotStore
+	if cfg.AudioClipStoreDir != "" {
+		clipStore, err := clipstore.NewFileStore(cfg.AudioClipStoreDir)
+		if err != nil {
+			log.Fatalf("Failed to open audio clip store: %v", err)
+		}
+		sensorService.ClipStore = clipStore
+	}
 
-	// Connect sensor service inputs to subscriber outputs
-	sensorService.TempChan = tempChan
-	sensorService.HumidityChan = humidityChan
-	sensorService.AudioChan = audioChan
+	// Mold risk is passed to the ML service as a feature
+	inferenceService.MoldRisk = sensorService.MoldRisk()
+	inferenceService.Occupancy = sensorService.Occupancy()
+	inferenceService.Outdoor = sensorService.OutdoorTemperatures()
+	inferenceService.LagFeatures = cfg.LagFeaturesEnabled
 
-	// Start sensor service
-	go sensorService.Start(ctx)
+	// Start inference service (polling loop, after its feature sources are wired);
+	// observers follow the primary's responses instead of requesting their own
+	if !observer {
+		go inferenceService.Start(ctx)
+	}
+
+	// Sensor service consumes readings and safety events from the bus
+	sensorService.TempChan = eventBus.Temperature.Subscribe("sensor-service", sensorConfig.TempChannelSize)
+	sensorService.HumidityChan = eventBus.Humidity.Subscribe("sensor-service", sensorConfig.HumidityChannelSize)
+	if cfg.AudioSpillDir != "" {
+		// A broker or database hiccup backs audio up for longer than the queue covers
+		if cfg.AudioSpillMaxClips <= 0 {
+			log.Fatalf("Invalid AUDIO_SPILL_MAX_CLIPS: must be positive")
+		}
+		spillStore, err := clipstore.NewFileStore(cfg.AudioSpillDir)
+		if err != nil {
+			log.Fatalf("Failed to open audio spill directory: %v", err)
+		}
+		audioSpill, err := bus.NewAudioSpillQueue(spillStore, cfg.AudioSpillMaxClips)
+		if err != nil {
+			log.Fatalf("Failed to open audio spill queue: %v", err)
+		}
+		sensorService.AudioChan = eventBus.Audio.SubscribeWithSpill("sensor-service", sensorConfig.AudioChannelSize, audioSpill)
+	} else {
+		sensorService.AudioChan = eventBus.Audio.Subscribe("sensor-service", sensorConfig.AudioChannelSize)
+	}
+	sensorService.SafetyChan = eventBus.Safety.Subscribe("sensor-service", sensorConfig.SafetyChannelSize)
+	sensorService.PresenceChan = eventBus.Presence.Subscribe("sensor-service", sensorConfig.PresenceChannelSize)
+	sensorService.BackfillChan = eventBus.Backfill.Subscribe("sensor-service", sensorConfig.BackfillChannelSize)
 
 	// === Initialize Window Control Service ===
-	// This service handles window control responses from ML service
-	go handleWindowControlLoop(ctx, db, windowControlChan)
+	// This service turns window control responses from ML service into actuator commands
+	log.Println("Initializing window control service...")
+	windowConfig := services.DefaultWindowControlServiceConfig()
+	windowConfig.ChannelSize = cfg.ChannelInferenceSize
+	windowConfig.DryRun = cfg.ActuatorDryRun
+	windowConfig.SafetyHoldMinutes = cfg.SafetyHoldMinutes
+	windowConfig.DomainHoldMinutes = cfg.InferenceDomainHoldMinutes
+	if cfg.WindowCommandTTLSeconds < 0 {
+		log.Fatalf("Invalid WINDOW_COMMAND_TTL_SECONDS: must not be negative")
+	}
+	windowConfig.CommandTTL = time.Duration(cfg.WindowCommandTTLSeconds) * time.Second
+	windowConfig.Frost.Enabled = cfg.FrostProtectionEnabled
+	windowConfig.Frost.CapTemperature = cfg.FrostCapTemperature
+	windowConfig.Frost.CloseTemperature = cfg.FrostCloseTemperature
+	windowConfig.Frost.MaxPosition = cfg.FrostMaxPosition
+	windowConfig.Frost.Hysteresis = cfg.FrostHysteresis
+	windowConfig.Frost.HoldMinutes = cfg.FrostHoldMinutes
+	if cfg.ActuationMaxMovements < 0 || cfg.ActuationMaxTravel < 0 {
+		log.Fatalf("Invalid actuation budget: ACTUATION_MAX_MOVEMENTS and ACTUATION_MAX_TRAVEL must not be negative")
+	}
+	windowConfig.Budget.MaxMovements = cfg.ActuationMaxMovements
+	windowConfig.Budget.MaxTravel = cfg.ActuationMaxTravel
+
+	windowService := services.NewWindowControlService(db, commandPublisher, windowConfig)
+	windowService.ResponseChan = eventBus.InferenceResponses.Subscribe("window-control", windowConfig.ChannelSize)
+	if !observer {
+		// A rebooted actuator gets its current command again
+		windowService.BootChan = eventBus.Boot.Subscribe("window-control", windowConfig.ChannelSize)
+	}
+
+	// Safety events are arbitrated with the highest priority
+	sensorService.SafetyHandler = windowService
+	sensorService.TemperatureHandler = windowService
+
+	// === Initialize Local Automations ===
+	// Rules evaluated by the backend itself keep basic behaviour working without the ML service
+	if cfg.AutomationsFile != "" {
+		f, err := os.Open(cfg.AutomationsFile)
+		if err != nil {
+			log.Fatalf("Failed to open automations file: %v", err)
+		}
+		automations, err := rules.ParseAutomations(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Invalid automations file: %v", err)
+		}
+
+		automationConfig := services.DefaultAutomationConfig()
+		automationConfig.StaleMinutes = cfg.AutomationsStaleMinutes
+		automationService := services.NewAutomationService(automations, deviceState, windowService, automationConfig)
+		automationService.Occupancy = sensorService.Occupancy()
+		go automationService.Start(ctx)
+	}
+
+	// === Initialize Scheduler ===
+	// One-off commands planned through the API (e.g., close bedroom windows at 22:30)
+	schedulerConfig := services.DefaultSchedulerConfig()
+	schedulerConfig.MissedGraceMinutes = cfg.ScheduleMissedGraceMinutes
+	schedulerService := services.NewSchedulerService(db, windowService, schedulerConfig)
+	if !observer {
+		go schedulerService.Start(ctx)
+	}
+
+	// === Initialize Window Analytics ===
+	// Per-device window usage statistics, stored for the API
+	if cfg.WindowAnalyticsIntervalMinutes > 0 {
+		if cfg.WindowAnalyticsBackfillDays < 1 {
+			log.Fatalf("Invalid WINDOW_ANALYTICS_BACKFILL_DAYS: must be at least 1")
+		}
+		analyticsConfig := services.DefaultWindowAnalyticsConfig()
+		analyticsConfig.Interval = time.Duration(cfg.WindowAnalyticsIntervalMinutes) * time.Minute
+		analyticsConfig.BackfillDays = cfg.WindowAnalyticsBackfillDays
+		go services.NewWindowAnalyticsService(db, analyticsConfig).Start(ctx)
+	}
+
+	// === Initialize Sensor Cross-Validation ===
+	// Devices sharing a room check each other; a persistent outlier is likely failing
+	if cfg.SensorCrossCheckEnabled {
+		if cfg.SensorCrossCheckMinNeighbors < 2 || cfg.SensorCrossCheckWindowHours < 1 {
+			log.Fatalf("Invalid sensor cross-validation: SENSOR_CROSSCHECK_MIN_NEIGHBORS must be at least 2 and SENSOR_CROSSCHECK_WINDOW_HOURS at least 1")
+		}
+		crossCheckConfig := services.DefaultSensorCrossCheckConfig()
+		crossCheckConfig.RoomTag = cfg.SensorCrossCheckRoomTag
+		crossCheckConfig.WindowHours = cfg.SensorCrossCheckWindowHours
+		crossCheckConfig.MinHours = min(crossCheckConfig.MinHours, cfg.SensorCrossCheckWindowHours)
+		crossCheckConfig.MinNeighbors = cfg.SensorCrossCheckMinNeighbors
+		crossCheckConfig.TemperatureThreshold = cfg.SensorCrossCheckTemperatureC
+		crossCheckConfig.HumidityThreshold = cfg.SensorCrossCheckHumidity
+		go services.NewSensorCrossCheckService(db, alertManager, crossCheckConfig).Start(ctx)
+	}
+
+	// === Initialize Sensor Readings Roll-up ===
+	// One joined row per device-minute for analytics that want a wide table
+	if cfg.SensorReadingsRollupEnabled {
+		if cfg.SensorReadingsLatenessMinutes < 0 || cfg.SensorReadingsBackfillHours < 0 {
+			log.Fatalf("Invalid SENSOR_READINGS_LATENESS_MINUTES or SENSOR_READINGS_BACKFILL_HOURS: must not be negative")
+		}
+		rollupConfig := services.DefaultReadingsRollupConfig()
+		rollupConfig.Lateness = time.Duration(cfg.SensorReadingsLatenessMinutes) * time.Minute
+		rollupConfig.Backfill = time.Duration(cfg.SensorReadingsBackfillHours) * time.Hour
+		go services.NewReadingsRollupService(db, rollupConfig).Start(ctx)
+	}
+
+	// === Initialize Lag Features ===
+	// Lagged means per metric, read by inference requests and training datasets alike
+	if cfg.LagFeaturesEnabled {
+		lateness := time.Duration(cfg.LagFeaturesLatenessMinutes) * time.Minute
+		if lateness < 0 || lateness >= database.ShortestLag || cfg.LagFeaturesBackfillHours < 0 {
+			log.Fatalf("Invalid LAG_FEATURES_LATENESS_MINUTES or LAG_FEATURES_BACKFILL_HOURS: lateness must be 0 to 14 minutes, backfill not negative")
+		}
+		lagConfig := services.DefaultLagFeaturesConfig()
+		lagConfig.Lateness = lateness
+		lagConfig.Backfill = time.Duration(cfg.LagFeaturesBackfillHours) * time.Hour
+		go services.NewLagFeaturesService(db, lagConfig).Start(ctx)
+	}
+
+	// === Initialize Storage Report ===
+	// Table sizes and ingestion rates in the log, for capacity planning (also GET /admin/storage)
+	if cfg.StorageReportIntervalHours > 0 {
+		if cfg.StorageReportDays < 1 || cfg.StorageReportTopDevices < 0 {
+			log.Fatalf("Invalid STORAGE_REPORT_DAYS or STORAGE_REPORT_TOP_DEVICES: days must be at least 1, devices not negative")
+		}
+		storageConfig := services.DefaultStorageReportConfig()
+		storageConfig.Interval = time.Duration(cfg.StorageReportIntervalHours) * time.Hour
+		storageConfig.Days = cfg.StorageReportDays
+		storageConfig.TopDevices = cfg.StorageReportTopDevices
+		go services.NewStorageReportService(db, storageConfig).Start(ctx)
+	}
+
+	// === Initialize Config Sync Service ===
+	// Devices announcing a boot receive their stored configuration
+	configSyncConfig := services.DefaultConfigSyncServiceConfig()
+	configSyncConfig.ChannelSize = cfg.ChannelSafetySize
+	configSyncConfig.DefaultSamplingIntervalSeconds = cfg.DeviceDefaultSamplingSeconds
+
+	configSyncService := services.NewConfigSyncService(db, publisher, configSyncConfig)
+	if !observer {
+		configSyncService.BootChan = eventBus.Boot.Subscribe("config-sync", configSyncConfig.ChannelSize)
+		go configSyncService.Start(ctx)
+	}
+
+	// === Initialize End-to-End Canary ===
+	// Synthetic readings for a reserved device, followed from the broker to the ML response
+	var canaryService *services.CanaryService
+	if cfg.CanaryEnabled {
+		if cfg.CanaryDeviceID == "" || cfg.CanaryIntervalSeconds <= 0 || cfg.CanaryDeadlineSeconds <= 0 {
+			log.Fatalf("Invalid canary: CANARY_DEVICE_ID must be set, CANARY_INTERVAL_SECONDS and CANARY_DEADLINE_SECONDS positive")
+		}
+		canaryDevice, err := mqtt.NewSyntheticDevice(mqttClient.GetNativeClient(), mqtt.SyntheticDeviceConfig{
+			DeviceID:         cfg.CanaryDeviceID,
+			Token:            cfg.CanaryDeviceToken,
+			TemperatureTopic: cfg.MQTTTopicTemperature,
+			HumidityTopic:    cfg.MQTTTopicHumidity,
+			AudioTopic:       cfg.MQTTTopicAudio,
+			Tenant:           cfg.MQTTTenant,
+		})
+		if err != nil {
+			log.Fatalf("Invalid canary: %v", err)
+		}
+		canaryConfig := services.DefaultCanaryServiceConfig()
+		canaryConfig.Interval = time.Duration(cfg.CanaryIntervalSeconds) * time.Second
+		canaryConfig.Deadline = time.Duration(cfg.CanaryDeadlineSeconds) * time.Second
+		canaryService = services.NewCanaryService(db, canaryDevice, inferenceService, alertManager, canaryConfig)
+		canaryService.ResponseChan = eventBus.InferenceResponses.Subscribe("canary", canaryConfig.ChannelSize)
+		windowService.CanaryDeviceID = cfg.CanaryDeviceID
+		go canaryService.Start(ctx)
+	}
+
+	// Start delivering events once every consumer has subscribed
+	eventBus.Start(ctx)
+
+	go windowService.Start(ctx)
+
+	// Start sensor service (after its safety handler is wired)
+	go sensorService.Start(ctx)
+
+	// === Publish Backend Status ===
+	if cfg.MQTTTopicBackendStatus != "" {
+		statusPublisher := mqtt.NewStatusPublisher(mqttClient.GetNativeClient(), mqtt.StatusConfig{
+			Topic:           cfg.MQTTTopicBackendStatus,
+			Version:         version,
+			IntervalSeconds: cfg.BackendStatusIntervalSeconds,
+		})
+		statusPublisher.DeviceCount = func() int { return len(inferenceService.GetTrackedDevices()) }
+		go statusPublisher.Start(ctx)
+
+		// A reconnect means the broker may have published the last will
+		mqttClient.OnConnect(statusPublisher.PublishOnline)
+	}
+
+	// === Initialize REST API ===
+	if cfg.APIAddr != "" {
+		apiServer := api.NewServer(db, deviceState, api.ServerConfig{
+			Addr:         cfg.APIAddr,
+			CommandToken: cfg.APICommandToken,
+			ReadOnly:     observer,
+			QueryLimits: database.QueryLimits{
+				Timeout:       time.Duration(cfg.APIQueryTimeoutSeconds) * time.Second,
+				MaxRowsToRead: uint64(max(cfg.APIQueryMaxRowsRead, 0)),
+				MaxResultRows: uint64(max(cfg.APIQueryMaxResultRows, 0)),
+			},
+		})
+		apiServer.MoldRisk = sensorService.MoldRisk()
+		apiServer.Occupancy = sensorService.Occupancy()
+		apiServer.Inference = inferenceService
+		apiServer.Window = windowService
+		apiServer.Thresholds = thresholdAdvisor
+		apiServer.DeviceStats = messageStats
+		apiServer.Hot = hotStore
+		apiServer.Config = cfg
+		apiServer.NoiseFloors = sensorService.NoiseFloors()
+
+		// The local model only serves dry-run predictions; the ML service decides actuation
+		apiServer.Models = localModels
+		apiServer.Outdoor = sensorService.OutdoorTemperatures()
+		apiServer.Canary = canaryService
+		apiServer.Alerts = alertManager
+
+		// Devices and bridges without MQTT post readings instead
+		if cfg.APIIngestEnabled {
+			apiServer.Readings = api.NewHTTPIngestor(subscriber.Auth)
+			startIngestor(apiServer.Readings)
+		}
+		go apiServer.Start(ctx)
+	}
+
+	// === Initialize mDNS Discovery ===
+	if cfg.MDNSEnabled {
+		services, err := discoveryServices(cfg)
+		if err != nil {
+			log.Fatalf("Invalid mDNS discovery: %v", err)
+		}
+		discoveryConfig := discovery.DefaultAdvertiserConfig()
+		discoveryConfig.Instance = cfg.MDNSInstance
+		discoveryConfig.Hostname = cfg.MDNSHostname
+		discoveryConfig.Interface = cfg.MDNSInterface
+		discoveryConfig.Services = services
+		advertiser, err := discovery.NewAdvertiser(discoveryConfig)
+		if err != nil {
+			log.Fatalf("Invalid mDNS discovery: %v", err)
+		}
+		go advertiser.Start(ctx)
+	}
 
 	// === Log startup info ===
-	log.Println("=== IoT Backend Service v2.0 is running ===")
+	log.Printf("=== IoT Backend Service v%s is running ===", version)
 	log.Printf("Architecture: CQRS-based inference with time-based polling")
 	log.Printf("Inference polling: Every %d seconds, data window=%d seconds",
 		cfg.InferencePollingIntervalSeconds, cfg.InferenceDataWindowSeconds)
 	log.Printf("Historical baseline: %d days, Z-score threshold=%.2f",
 		cfg.InferenceHistoricalBaselineDays, cfg.InferenceZScoreThreshold)
-	log.Printf("MQTT Topics:")
+	if cfg.MQTTTopicPrefix != "" {
+		log.Printf("MQTT Topics (under prefix %q):", mqtt.NormalizePrefix(cfg.MQTTTopicPrefix))
+	} else {
+		log.Printf("MQTT Topics:")
+	}
 	log.Printf("  - Temperature:    %s", cfg.MQTTTopicTemperature)
 	log.Printf("  - Humidity:       %s", cfg.MQTTTopicHumidity)
 	log.Printf("  - Audio:          %s", cfg.MQTTTopicAudio)
-	log.Printf("  - Inference Req:  %s", cfg.MQTTTopicInferenceReq)
+	if cfg.MQTTTopicAudioChunk != "" {
+		log.Printf("  - Audio Chunks:   %s -> %s", cfg.MQTTTopicAudioChunk, cfg.MQTTTopicAudioControl)
+	}
+	log.Printf("  - Safety:         %s", cfg.MQTTTopicSafety)
+	if cfg.MQTTTopicLoRaWAN != "" {
+		log.Printf("  - LoRaWAN:        %s", cfg.MQTTTopicLoRaWAN)
+	}
+	log.Printf("  - Inference Req:  %s (%s)", strings.Join(inferenceRouter.Topics(), ", "), inferenceRouter.Policy())
 	log.Printf("  - Window Control: %s", cfg.MQTTTopicWindowControl)
+	log.Printf("  - Window Command: %s (QoS 2)", cfg.MQTTTopicWindowCommand)
+	if cfg.MQTTTopicWindowAck != "" {
+		log.Printf("  - Window Ack:     %s", cfg.MQTTTopicWindowAck)
+	}
+	log.Printf("  - Boot / Config:  %s -> %s", cfg.MQTTTopicBoot, cfg.MQTTTopicDeviceConfig)
+	log.Printf("  - Occupancy:      %s, %s", cfg.MQTTTopicMotion, cfg.MQTTTopicCO2)
+	if cfg.MQTTTopicBackfill != "" {
+		log.Printf("  - Backfill:       %s", cfg.MQTTTopicBackfill)
+	}
+	if cfg.MQTTTopicBackendStatus != "" {
+		log.Printf("  - Backend Status: %s (retained)", cfg.MQTTTopicBackendStatus)
+	}
+	if cfg.ActuatorDryRun {
+		log.Printf("Actuator DRY-RUN enabled (shadow topic: %q)", cfg.MQTTTopicShadowCommand)
+	}
 	log.Println("Press Ctrl+C to exit...")
 
 	// === Wait for interrupt signal ===
@@ -170,70 +972,70 @@ func main() {
 	log.Println("Shutdown complete. Goodbye!")
 }
 
-// handleWindowControlLoop processes window control responses from ML service
-func handleWindowControlLoop(ctx context.Context, db *database.ClickHouseDB, windowControlChan chan *models.InferenceResponse) {
-	log.Println("WindowControlService: Starting...")
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("WindowControlService: Shutting down...")
-			return
-
-		case response, ok := <-windowControlChan:
-			if !ok {
-				log.Println("WindowControlService: Channel closed, shutting down...")
-				return
-			}
-
-			handleWindowControl(response, db)
-		}
+// syntheticDevices returns the device IDs whose readings the backend generates. The
+// canary's ID is reserved even while the canary is off, so readings stored by earlier
+// runs stay out of fleet data too.
+func syntheticDevices(cfg *config.Config) []string {
+	if cfg.CanaryDeviceID == "" {
+		return nil
 	}
+	return []string{cfg.CanaryDeviceID}
 }
 
-// handleWindowControl logs and saves window control responses from ML service
-func handleWindowControl(response *models.InferenceResponse, db *database.ClickHouseDB) {
-	log.Printf("Window control received: Device=%s, Position=%.2f%%, Confidence=%.2f",
-		response.DeviceID, response.Position, response.Confidence)
-
-	// Create window action record
-	windowAction := &models.WindowAction{
-		Timestamp:   response.Timestamp,
-		DeviceID:    response.DeviceID,
-		Position:    response.Position,
-		Confidence:  response.Confidence,
-		Temperature: 0.0,
-		Humidity:    0.0,
-		SoundVolume: 0.0,
-	}
-
-	// Extract features from response if available
-	if temp, ok := response.FeaturesUsed["temperature"].(float64); ok {
-		windowAction.Temperature = temp
-	}
-	if humidity, ok := response.FeaturesUsed["humidity"].(float64); ok {
-		windowAction.Humidity = humidity
+// openDatabase connects to ClickHouse using the loaded configuration and, unless
+// disabled, creates and migrates the schema
+func openDatabase(cfg *config.Config) (*database.ClickHouseDB, error) {
+	db, err := database.OpenClickHouseDB(
+		cfg.ClickHouseAddr,
+		cfg.ClickHouseDB,
+		cfg.ClickHouseUser,
+		cfg.ClickHousePass,
+		database.ClusterConfig{
+			Cluster:       cfg.ClickHouseCluster,
+			ZooKeeperPath: cfg.ClickHouseZooKeeperPath,
+			ReplicaName:   cfg.ClickHouseReplicaName,
+		},
+		database.PoolsConfig{
+			Write: database.PoolConfig{
+				MaxOpenConns: cfg.ClickHouseWriteMaxOpenConns,
+				MaxIdleConns: cfg.ClickHouseWriteMaxIdleConns,
+			},
+			Read: database.PoolConfig{
+				Addr:         cfg.ClickHouseReadAddr,
+				MaxOpenConns: cfg.ClickHouseReadMaxOpenConns,
+				MaxIdleConns: cfg.ClickHouseReadMaxIdleConns,
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
 	}
-	if volume, ok := response.FeaturesUsed["sound_volume"].(float64); ok {
-		windowAction.SoundVolume = volume
+	if cfg.ClickHouseFailedInsertsFile != "" {
+		db.SetFailedInsertFile(cfg.ClickHouseFailedInsertsFile)
 	}
+	db.SetSyntheticDevices(syntheticDevices(cfg))
 
-	// Save window action to database
-	if err := db.SaveWindowAction(windowAction); err != nil {
-		log.Printf("Error saving window action: %v", err)
-		return
+	if !cfg.ClickHouseInitSchema {
+		log.Println("Schema initialization disabled; tables must be created and migrated out of band (verify with 'iot-backend check')")
+		return db, nil
 	}
-
-	// Save ML prediction metadata
-	mlPrediction := &models.MLPrediction{
-		Timestamp:    response.Timestamp,
-		DeviceID:     response.DeviceID,
-		Prediction:   response.Position,
-		Confidence:   response.Confidence,
-		ModelVersion: "v1.0.0", // Could be extracted from response if available
+	db.SetSchemaConcurrency(cfg.ClickHouseSchemaConcurrency)
+	if err := db.InitSchema(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
+	return db, nil
+}
 
-	if err := db.SaveMLPrediction(mlPrediction); err != nil {
-		log.Printf("Error saving ML prediction: %v", err)
+// publisherTopics returns every configured topic template the backend publishes to
+func publisherTopics(cfg *config.Config, mlModels, domainRoutes []mqtt.MLModel) []string {
+	topics := strings.Split(cfg.MQTTTopicInferenceReq, ",")
+	topics = append(topics, cfg.MQTTTopicWindowCommand, cfg.MQTTTopicShadowCommand, cfg.MQTTTopicDeviceConfig, cfg.MQTTTopicAudioControl)
+	for _, m := range mlModels {
+		topics = append(topics, m.RequestTopic)
+	}
+	for _, d := range domainRoutes {
+		topics = append(topics, d.RequestTopic)
 	}
+	return topics
 }
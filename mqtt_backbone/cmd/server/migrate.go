@@ -0,0 +1,150 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"iot-backend/internal/database"
+	"iot-backend/pkg/config"
+)
+
+// legacyMigrationUsage is printed for invalid migrate-legacy arguments
+const legacyMigrationUsage = "Usage: iot-backend migrate-legacy [--direction to-sensors|to-legacy] [--days N | --from T --to T] [--dry-run]"
+
+// runMigrateLegacyCommand copies history between the legacy sensor_readings wide
+// table (one row per device-minute) and the per-sensor tables, so upgrading from the
+// old topic format keeps the history baselines are computed from.
+//
+//	iot-backend migrate-legacy [--direction to-sensors|to-legacy] [--days N | --from T --to T] [--dry-run]
+//
+// to-sensors (the default) writes a reading to sensor_temperature, sensor_humidity and
+// sensor_audio for every legacy value, skipping device-minutes those tables already
+// hold. to-legacy rolls the per-sensor tables up into sensor_readings, replacing the
+// minutes it covers, as SENSOR_READINGS_ROLLUP_ENABLED does for new readings. Both
+// are safe to run again. The range defaults to the source's whole history and is
+// processed a day at a time.
+func runMigrateLegacyCommand(args []string) int {
+	fs := flag.NewFlagSet("migrate-legacy", flag.ContinueOnError)
+	direction := fs.String("direction", "to-sensors", "to-sensors (legacy table into per-sensor tables) or to-legacy (the reverse)")
+	days := fs.Int("days", 0, "Number of days back from now to migrate (0 for all history; ignored when --from is set)")
+	fromStr := fs.String("from", "", "Start of period (RFC3339)")
+	toStr := fs.String("to", "", "End of period (RFC3339, default now)")
+	dryRun := fs.Bool("dry-run", false, "Count the rows that would be written without writing them")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 || *days < 0 || (*direction != "to-sensors" && *direction != "to-legacy") {
+		fmt.Fprintln(os.Stderr, legacyMigrationUsage)
+		return 2
+	}
+
+	to := time.Now()
+	if *toStr != "" {
+		t, err := time.Parse(time.RFC3339, *toStr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --to: %v\n", err)
+			return 2
+		}
+		to = t
+	}
+	var from time.Time
+	if *days > 0 {
+		from = to.Add(-time.Duration(*days) * 24 * time.Hour)
+	}
+	if *fromStr != "" {
+		t, err := time.Parse(time.RFC3339, *fromStr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --from: %v\n", err)
+			return 2
+		}
+		from = t
+	}
+
+	db, err := openDatabase(config.Load())
+	if err != nil {
+		log.Printf("Failed to initialize ClickHouse: %v", err)
+		return 1
+	}
+	defer db.Close()
+
+	sources := []string{"sensor_readings"}
+	if *direction == "to-legacy" {
+		sources = []string{"sensor_temperature", "sensor_humidity", "sensor_audio"}
+	}
+	if from.IsZero() {
+		for _, table := range sources {
+			first, _, ok, err := db.TableTimeRange(table)
+			if err != nil {
+				log.Printf("Migration failed: %v", err)
+				return 1
+			}
+			if ok && (from.IsZero() || first.Before(from)) {
+				from = first
+			}
+		}
+		if from.IsZero() {
+			log.Printf("Nothing to migrate: %v are empty", sources)
+			return 0
+		}
+	}
+
+	// Whole minutes, so to-legacy never rolls up part of one
+	from, to = from.Truncate(time.Minute), to.Truncate(time.Minute)
+	log.Printf("Migrating %s from %s to %s", *direction, from.Format(time.RFC3339), to.Format(time.RFC3339))
+
+	totals := make(map[string]int)
+	for start := from; start.Before(to); start = start.Add(24 * time.Hour) {
+		end := start.Add(24 * time.Hour)
+		if end.After(to) {
+			end = to
+		}
+		counts, err := migrateLegacyDay(db, *direction, start, end, *dryRun)
+		for table, n := range counts {
+			totals[table] += n
+		}
+		if err != nil {
+			log.Printf("Migration of %s failed: %v", start.Format("2006-01-02"), err)
+			logMigrationTotals(totals, *dryRun)
+			return 1
+		}
+	}
+
+	logMigrationTotals(totals, *dryRun)
+	return 0
+}
+
+// migrateLegacyDay migrates one chunk of the range and returns the rows written per table
+func migrateLegacyDay(db *database.ClickHouseDB, direction string, from, to time.Time, dryRun bool) (map[string]int, error) {
+	if direction == "to-sensors" {
+		return db.MigrateLegacyReadings(from, to, dryRun)
+	}
+
+	n, err := db.CountSensorReadingMinutes(from, to)
+	if err != nil || n == 0 || dryRun {
+		return map[string]int{"sensor_readings": n}, err
+	}
+	if err := db.RollupSensorReadings(from, to, time.Now()); err != nil {
+		return nil, err
+	}
+	return map[string]int{"sensor_readings": n}, nil
+}
+
+// logMigrationTotals reports the rows written (or that would be) per table
+func logMigrationTotals(totals map[string]int, dryRun bool) {
+	verb := "Migrated"
+	if dryRun {
+		verb = "Would migrate"
+	}
+	if len(totals) == 0 {
+		log.Printf("%s nothing", verb)
+	}
+	for table, n := range totals {
+		log.Printf("%s %d rows into %s", verb, n, table)
+	}
+	if dryRun {
+		log.Println("Dry run: nothing was written")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"iot-backend/pkg/config"
+)
+
+// Instance roles
+const (
+	rolePrimary  = "primary"  // Writes to ClickHouse, publishes requests and commands
+	roleObserver = "observer" // Read-only replica next to a primary, e.g. serving dashboards
+)
+
+// applyInstanceRole validates the instance role and reports whether this is an
+// observer. Observers turn off everything whose only effect is writing or
+// publishing: schema initialization, the backend status topic (and its last
+// will, which would mark the primary offline), mDNS advertisement, the canary,
+// the analytics roll-ups, the lag features table, sensor cross-validation, the
+// reading simulator, and alert emails (the primary sends them). An observer must
+// have its own MQTT client ID, and subscribes to shared subscriptions
+// ("$share/<group>/...") unshared: in the primary's group it would take a share
+// of the primary's messages and discard their writes.
+func applyInstanceRole(cfg *config.Config) (bool, error) {
+	switch cfg.InstanceRole {
+	case rolePrimary:
+		return false, nil
+	case roleObserver:
+	default:
+		return false, fmt.Errorf("INSTANCE_ROLE %q must be %s or %s", cfg.InstanceRole, rolePrimary, roleObserver)
+	}
+
+	if cfg.MQTTClientID == "" || cfg.MQTTClientID == config.DefaultMQTTClientID {
+		// The broker drops the older of two sessions with the same client ID
+		return false, fmt.Errorf("observer needs its own MQTT_CLIENT_ID; the default %q would disconnect the primary", config.DefaultMQTTClientID)
+	}
+
+	log.Println("Observer instance: ClickHouse writes and MQTT publishes are discarded, state-changing API requests refused")
+	cfg.ClickHouseInitSchema = false
+	cfg.MQTTTopicBackendStatus = ""
+	cfg.MDNSEnabled = false
+	cfg.CanaryEnabled = false
+	cfg.WindowAnalyticsIntervalMinutes = 0
+	cfg.SensorReadingsRollupEnabled = false
+	cfg.LagFeaturesEnabled = false
+	cfg.SensorCrossCheckEnabled = false
+	cfg.SimulatorDevices = 0
+	cfg.SMTPHost = ""
+
+	for _, topic := range []*string{&cfg.MQTTTopicTemperature, &cfg.MQTTTopicHumidity, &cfg.MQTTTopicAudio,
+		&cfg.MQTTTopicInferenceReq, &cfg.MQTTTopicWindowControl, &cfg.MQTTTopicSafety, &cfg.MQTTTopicWindowAck,
+		&cfg.MQTTTopicBoot, &cfg.MQTTTopicMotion, &cfg.MQTTTopicCO2, &cfg.MQTTTopicBackfill, &cfg.MQTTTopicAudioChunk,
+		&cfg.MQTTTopicLoRaWAN, &cfg.MQTTTopicFrame, &cfg.MQTTTopicSensor, &cfg.MQTTTopicAction} {
+		*topic = unshare(*topic)
+	}
+	return true, nil
+}
+
+// unshare removes the "$share/<group>/" prefix from every filter of a
+// comma-separated list
+func unshare(filters string) string {
+	parts := strings.Split(filters, ",")
+	for i, p := range parts {
+		p = strings.TrimSpace(p)
+		if strings.HasPrefix(p, "$share/") {
+			if levels := strings.SplitN(p, "/", 3); len(levels) == 3 {
+				p = levels[2]
+			}
+		}
+		parts[i] = p
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"iot-backend/internal/database"
+	"iot-backend/internal/models"
+	"iot-backend/pkg/config"
+)
+
+// failedInsertReplayer re-inserts rows ClickHouse rejected earlier
+type failedInsertReplayer interface {
+	FailedInserts(table string, limit int) ([]*models.FailedInsert, error)
+	ReplayFailedInsert(failed *models.FailedInsert) error
+	MarkFailedInsertReplayed(failed *models.FailedInsert) error
+}
+
+// runReplayCommand re-inserts permanently failed rows once the cause (e.g., a
+// schema or type bug) has been fixed.
+//
+//	iot-backend replay [--table NAME] [--limit N] [--dry-run] [--file FILE]
+//
+// Rows come from the failed_inserts table, or with --file from the fallback file
+// set by CLICKHOUSE_FAILED_INSERTS_FILE. Replayed rows are marked in the table;
+// a fallback file is rewritten to hold only the rows that still fail.
+func runReplayCommand(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	table := fs.String("table", "", "Only replay rows for this table")
+	limit := fs.Int("limit", 10000, "Maximum rows to replay")
+	dryRun := fs.Bool("dry-run", false, "List the rows that would be replayed without inserting them")
+	filePath := fs.String("file", "", "Replay rows from a failed inserts fallback file instead of the table")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 || *limit <= 0 {
+		fmt.Fprintln(os.Stderr, "Usage: iot-backend replay [--table NAME] [--limit N] [--dry-run] [--file FILE]")
+		return 2
+	}
+
+	var db failedInsertReplayer
+	if !*dryRun || *filePath == "" {
+		conn, err := openDatabase(config.Load())
+		if err != nil {
+			log.Printf("Failed to initialize ClickHouse: %v", err)
+			return 1
+		}
+		defer conn.Close()
+		db = conn
+	}
+
+	var failed []*models.FailedInsert
+	var err error
+	if *filePath != "" {
+		failed, err = database.ReadFailedInsertFile(*filePath)
+	} else {
+		failed, err = db.FailedInserts(*table, *limit)
+	}
+	if err != nil {
+		log.Printf("Failed to load failed inserts: %v", err)
+		return 1
+	}
+
+	var attempted, replayed int
+	var remaining []*models.FailedInsert
+	for _, f := range failed {
+		// File rows aren't filtered by a query, so --table and --limit apply here
+		if (*table != "" && f.Table != *table) || attempted >= *limit {
+			remaining = append(remaining, f)
+			continue
+		}
+		attempted++
+		if *dryRun {
+			log.Printf("Would replay %s into %s (device %s, failed %s): %s", f.ID, f.Table, f.DeviceID, f.Timestamp.Format("2006-01-02 15:04:05"), f.Error)
+			continue
+		}
+
+		if err := db.ReplayFailedInsert(f); err != nil {
+			log.Printf("Replay of %s is still failing: %v", f.ID, err)
+			remaining = append(remaining, f)
+			continue
+		}
+		replayed++
+		if *filePath == "" {
+			if err := db.MarkFailedInsertReplayed(f); err != nil {
+				log.Printf("Replayed %s but failed to mark it (it may be replayed again): %v", f.ID, err)
+			}
+		}
+	}
+
+	if *dryRun {
+		log.Printf("Dry run: %d failed inserts would be replayed", attempted)
+		return 0
+	}
+
+	if *filePath != "" {
+		if err := database.WriteFailedInsertFile(*filePath, remaining); err != nil {
+			log.Printf("Failed to rewrite %s: %v", *filePath, err)
+			return 1
+		}
+	}
+
+	log.Printf("Replayed %d of %d failed inserts", replayed, attempted)
+	if replayed < attempted {
+		return 1
+	}
+	return 0
+}
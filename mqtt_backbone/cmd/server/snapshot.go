@@ -0,0 +1,231 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"iot-backend/internal/snapshot"
+	"iot-backend/pkg/config"
+)
+
+// snapshotSettings returns the configuration-held rules included in a snapshot,
+// by environment variable
+func snapshotSettings(cfg *config.Config) map[string]string {
+	return map[string]string{
+		"ALERT_RULES":            cfg.AlertRules,
+		"ALERT_EMAIL_ROUTES":     cfg.AlertEmailRoutes,
+		"ALERT_COOLDOWN_MINUTES": strconv.Itoa(cfg.AlertCooldownMinutes),
+	}
+}
+
+// runSnapshotCommand saves or restores operational state.
+//
+//	iot-backend snapshot create [--out FILE]
+//	iot-backend snapshot restore [--dry-run] [--overwrite] [--env-file FILE] FILE
+//
+// The archive holds the device registry (names, locations, tags, and per-device
+// config: sampling interval, thresholds, calibration, frost settings) and the alert
+// rules from the environment. Restored rules are written as KEY="value" lines to
+// --env-file (or printed) for the target instance's environment.
+func runSnapshotCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: iot-backend snapshot create|restore [flags]")
+		return 2
+	}
+
+	switch args[0] {
+	case "create":
+		return runSnapshotCreate(args[1:])
+	case "restore":
+		return runSnapshotRestore(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown snapshot command: %s\n", args[0])
+		return 2
+	}
+}
+
+// runSnapshotCreate writes the current state to an archive
+func runSnapshotCreate(args []string) int {
+	fs := flag.NewFlagSet("snapshot create", flag.ContinueOnError)
+	out := fs.String("out", "", "Archive path (default iot-backend-snapshot-<time>.tar.gz)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: iot-backend snapshot create [--out FILE]")
+		return 2
+	}
+
+	now := time.Now().UTC()
+	if *out == "" {
+		*out = fmt.Sprintf("iot-backend-snapshot-%s.tar.gz", now.Format("20060102-150405"))
+	}
+
+	cfg := config.Load()
+	db, err := openDatabase(cfg)
+	if err != nil {
+		log.Printf("Failed to initialize ClickHouse: %v", err)
+		return 1
+	}
+	defer db.Close()
+
+	devices, err := db.ListDevices()
+	if err != nil {
+		log.Printf("Failed to read device registry: %v", err)
+		return 1
+	}
+
+	source, _ := os.Hostname()
+	snap := &snapshot.Snapshot{
+		Manifest: snapshot.Manifest{CreatedAt: now, Source: source},
+		Devices:  devices,
+		Settings: snapshotSettings(cfg),
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Printf("Failed to create %s: %v", *out, err)
+		return 1
+	}
+	if err := snapshot.Write(f, snap); err != nil {
+		f.Close()
+		log.Printf("Failed to write snapshot: %v", err)
+		return 1
+	}
+	if err := f.Close(); err != nil {
+		log.Printf("Failed to write snapshot: %v", err)
+		return 1
+	}
+
+	log.Printf("Snapshot written to %s: %d devices, %d settings", *out, len(snap.Devices), len(snap.Settings))
+	return 0
+}
+
+// runSnapshotRestore loads an archive into this instance
+func runSnapshotRestore(args []string) int {
+	fs := flag.NewFlagSet("snapshot restore", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Show what would be restored without writing")
+	overwrite := fs.Bool("overwrite", false, "Replace registry entries that are newer here than in the snapshot")
+	envFile := fs.String("env-file", "", "Write restored settings to this env file instead of printing them")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: iot-backend snapshot restore [--dry-run] [--overwrite] [--env-file FILE] FILE")
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Printf("Failed to open %s: %v", fs.Arg(0), err)
+		return 1
+	}
+	snap, unknown, err := snapshot.Read(f)
+	f.Close()
+	if err != nil {
+		log.Printf("Failed to read snapshot: %v", err)
+		return 1
+	}
+	log.Printf("Snapshot from %s taken %s: %d devices, %d settings",
+		snap.Manifest.Source, snap.Manifest.CreatedAt.Format(time.RFC3339), len(snap.Devices), len(snap.Settings))
+	for _, name := range unknown {
+		log.Printf("Skipping unknown section %s (written by a newer version)", name)
+	}
+
+	db, err := openDatabase(config.Load())
+	if err != nil {
+		log.Printf("Failed to initialize ClickHouse: %v", err)
+		return 1
+	}
+	defer db.Close()
+
+	var restored, kept, failed int
+	for i := range snap.Devices {
+		device := &snap.Devices[i]
+		existing, err := db.GetDevice(device.DeviceID)
+		if err != nil {
+			log.Printf("Failed to read %s from the registry: %v", device.DeviceID, err)
+			failed++
+			continue
+		}
+
+		// The registry keeps the row with the latest last_seen, so a stale row would be ignored
+		if existing != nil && existing.LastSeen.After(device.LastSeen) {
+			if !*overwrite {
+				log.Printf("Keeping %s: registry entry is newer (%s) than the snapshot (%s)",
+					device.DeviceID, existing.LastSeen.Format(time.RFC3339), device.LastSeen.Format(time.RFC3339))
+				kept++
+				continue
+			}
+			device.LastSeen = time.Now()
+		}
+
+		if *dryRun {
+			log.Printf("Would restore %s (%s, %d config keys, %d tags)", device.DeviceID, device.Name, len(device.Config), len(device.Tags))
+			restored++
+			continue
+		}
+		if err := db.UpsertDevice(device); err != nil {
+			log.Printf("Failed to restore %s: %v", device.DeviceID, err)
+			failed++
+			continue
+		}
+		restored++
+	}
+
+	verb := "Restored"
+	if *dryRun {
+		verb = "Would restore"
+	}
+	log.Printf("%s %d devices (%d newer entries kept, %d failed)", verb, restored, kept, failed)
+
+	if err := writeSnapshotSettings(snap.Settings, *envFile, *dryRun); err != nil {
+		log.Printf("Failed to write settings: %v", err)
+		return 1
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// writeSnapshotSettings writes settings as env file lines to path, or to stdout
+// when no path is given (or in a dry run)
+func writeSnapshotSettings(settings map[string]string, path string, dryRun bool) error {
+	if len(settings) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := os.Stdout
+	if path != "" && !dryRun {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	} else {
+		fmt.Fprintln(out, "# Settings from the snapshot; add them to this instance's environment")
+	}
+
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(out, "%s=%q\n", key, settings[key]); err != nil {
+			return err
+		}
+	}
+	if out != os.Stdout {
+		log.Printf("Wrote %d settings to %s", len(keys), path)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
+
+	"iot-backend/internal/mqtt"
+	"iot-backend/pkg/config"
+)
+
+// runTailCommand prints a device's readings, inference triggers and responses, and
+// window commands and acks as they cross the broker, until interrupted.
+//
+//	iot-backend tail --device ID [--json] [--no-audio]
+func runTailCommand(args []string) int {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	deviceID := fs.String("device", "", "Device to follow (required)")
+	asJSON := fs.Bool("json", false, "Print one JSON event per line")
+	noAudio := fs.Bool("no-audio", false, "Leave out audio clips")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *deviceID == "" {
+		fmt.Fprintln(os.Stderr, "Usage: iot-backend tail --device ID [--json] [--no-audio]")
+		return 2
+	}
+
+	cfg := config.Load()
+	topics := map[string]string{
+		mqtt.TailTemperature: cfg.MQTTTopicTemperature,
+		mqtt.TailHumidity:    cfg.MQTTTopicHumidity,
+		mqtt.TailAudio:       cfg.MQTTTopicAudio,
+		mqtt.TailSafety:      cfg.MQTTTopicSafety,
+		mqtt.TailTrigger:     cfg.MQTTTopicInferenceReq,
+		mqtt.TailResponse:    cfg.MQTTTopicWindowControl,
+		mqtt.TailCommand:     cfg.MQTTTopicWindowCommand,
+		mqtt.TailAck:         cfg.MQTTTopicWindowAck,
+	}
+	if *noAudio {
+		delete(topics, mqtt.TailAudio)
+	}
+
+	// Read-only under its own client ID, so it can't disturb the backend's session
+	client, err := mqtt.NewClient(mqtt.ClientConfig{
+		Broker:      cfg.MQTTBroker,
+		ClientID:    cfg.MQTTClientID + "-tail-" + strconv.Itoa(os.Getpid()),
+		Username:    cfg.MQTTUsername,
+		Password:    cfg.MQTTPassword,
+		TopicPrefix: cfg.MQTTTopicPrefix,
+		TLS: mqtt.TLSConfig{
+			CAFile:     cfg.MQTTCAFile,
+			CertFile:   cfg.MQTTCertFile,
+			KeyFile:    cfg.MQTTKeyFile,
+			ServerName: cfg.MQTTTLSServerName,
+		},
+		ReadOnly: true,
+	})
+	if err != nil {
+		log.Printf("Failed to connect to the broker: %v", err)
+		return 1
+	}
+	defer client.Close()
+
+	tail, err := mqtt.NewTail(client.GetNativeClient(), mqtt.TailConfig{
+		DeviceID: *deviceID,
+		Tenant:   cfg.MQTTTenant,
+		Topics:   topics,
+	})
+	if err != nil {
+		log.Printf("Invalid topic: %v", err)
+		return 1
+	}
+
+	events := make(chan *mqtt.TailEvent, 100)
+	if err := tail.Start(events); err != nil {
+		log.Printf("Failed to subscribe: %v", err)
+		return 1
+	}
+	client.OnConnect(func() {
+		// A reconnect starts a clean session without the subscriptions
+		if err := tail.Start(events); err != nil {
+			log.Printf("Failed to resubscribe: %v", err)
+		}
+	})
+
+	filters := tail.Filters()
+	kinds := make([]string, 0, len(filters))
+	for kind := range filters {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		log.Printf("Following %-11s %s", kind, filters[kind])
+	}
+	log.Printf("Tailing %s, Ctrl-C to stop", *deviceID)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		select {
+		case <-sigChan:
+			return 0
+		case event := <-events:
+			if *asJSON {
+				if err := enc.Encode(event); err != nil {
+					log.Printf("Error encoding event: %v", err)
+				}
+				continue
+			}
+			fmt.Printf("%s  %-11s %s\n", event.Received.Format("15:04:05.000"), event.Kind, event.Summary)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"iot-backend/internal/database"
+	"iot-backend/internal/rules"
+	"iot-backend/pkg/config"
+)
+
+// runWhatIfCommand replays recorded conditions and ML decisions against a proposed
+// rule set and reports how often each rule would have fired and overridden the
+// model, so rules can be tuned before they drive real windows.
+//
+//	iot-backend whatif --rules FILE [--days N | --from T --to T] [--device ID] [--step S] [--json]
+func runWhatIfCommand(args []string) int {
+	fs := flag.NewFlagSet("whatif", flag.ContinueOnError)
+	rulesPath := fs.String("rules", "", "Rule set file (JSON)")
+	days := fs.Int("days", 7, "Number of days back from now to replay (ignored when --from is set)")
+	fromStr := fs.String("from", "", "Start of period (RFC3339)")
+	toStr := fs.String("to", "", "End of period (RFC3339, default now)")
+	deviceID := fs.String("device", "", "Restrict to a single device")
+	step := fs.Int("step", 60, "Seconds per reading bucket (coarsened for long ranges)")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *rulesPath == "" || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: iot-backend whatif --rules FILE [--days N | --from T --to T] [--device ID] [--step S] [--json]")
+		return 2
+	}
+
+	to := time.Now()
+	if *toStr != "" {
+		t, err := time.Parse(time.RFC3339, *toStr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --to: %v\n", err)
+			return 2
+		}
+		to = t
+	}
+	from := to.Add(-time.Duration(*days) * 24 * time.Hour)
+	if *fromStr != "" {
+		t, err := time.Parse(time.RFC3339, *fromStr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --from: %v\n", err)
+			return 2
+		}
+		from = t
+	}
+
+	f, err := os.Open(*rulesPath)
+	if err != nil {
+		log.Printf("Failed to open %s: %v", *rulesPath, err)
+		return 1
+	}
+	set, err := rules.Parse(f)
+	f.Close()
+	if err != nil {
+		log.Printf("Invalid rule set: %v", err)
+		return 1
+	}
+
+	db, err := openDatabase(config.Load())
+	if err != nil {
+		log.Printf("Failed to initialize ClickHouse: %v", err)
+		return 1
+	}
+	defer db.Close()
+
+	log.Printf("Replaying %d rules from %s to %s", len(set.Rules), from.Format(time.RFC3339), to.Format(time.RFC3339))
+
+	timelines, err := loadTimelines(db, set, *deviceID, from, to, time.Duration(*step)*time.Second)
+	if err != nil {
+		log.Printf("Failed to load history: %v", err)
+		return 1
+	}
+
+	evaluator := rules.NewEvaluator(set, from, to)
+	for _, tl := range timelines {
+		evaluator.Replay(tl)
+	}
+	report := evaluator.Report()
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Printf("Failed to write report: %v", err)
+			return 1
+		}
+		return 0
+	}
+	printWhatIfReport(report)
+	return 0
+}
+
+// loadTimelines reads the history of every device with ML decisions in the range
+func loadTimelines(db *database.ClickHouseDB, set *rules.RuleSet, deviceID string, from, to time.Time, step time.Duration) ([]*rules.Timeline, error) {
+	predictions, err := db.GetMLPredictions(deviceID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	events, err := db.GetSafetyEvents(deviceID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	timelines := make(map[string]*rules.Timeline)
+	timeline := func(id string) *rules.Timeline {
+		tl, ok := timelines[id]
+		if !ok {
+			tl = &rules.Timeline{DeviceID: id, From: from, To: to}
+			timelines[id] = tl
+		}
+		return tl
+	}
+	for _, p := range predictions {
+		tl := timeline(p.DeviceID)
+		tl.Predictions = append(tl.Predictions, rules.Prediction{Timestamp: p.Timestamp, Position: p.Prediction})
+	}
+	for _, e := range events {
+		// Devices without ML decisions have nothing to override
+		if tl, ok := timelines[e.DeviceID]; ok {
+			tl.Samples = append(tl.Samples, rules.Sample{Timestamp: e.Timestamp, Metric: e.EventType, Value: e.Value})
+		}
+	}
+
+	// Only read the reading metrics some rule refers to
+	var metrics []string
+	for _, metric := range rules.ReadingMetrics {
+		for _, rule := range set.Rules {
+			if referencesMetric(rule, metric) {
+				metrics = append(metrics, metric)
+				break
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(timelines))
+	for id := range timelines {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	result := make([]*rules.Timeline, 0, len(ids))
+	for _, id := range ids {
+		tl := timelines[id]
+		for _, metric := range metrics {
+			series, err := db.GetSeries(id, metric, from, to, step)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s for %s: %w", metric, id, err)
+			}
+			// A bucket stays current for a few buckets so short gaps don't release rules
+			resolution := time.Duration(series.Resolution) * time.Second
+			if ttl := 3 * resolution; ttl > tl.ReadingTTL {
+				tl.ReadingTTL = ttl
+			}
+			for _, p := range series.Points {
+				tl.Samples = append(tl.Samples, rules.Sample{Timestamp: p.Timestamp, Metric: metric, Value: p.Value})
+			}
+		}
+		if tl.ReadingTTL < 10*time.Minute {
+			tl.ReadingTTL = 10 * time.Minute
+		}
+		result = append(result, tl)
+	}
+	return result, nil
+}
+
+func referencesMetric(rule rules.Rule, metric string) bool {
+	for _, c := range rule.Conditions {
+		if c.Metric == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// printWhatIfReport prints a report as a table
+func printWhatIfReport(report rules.Report) {
+	fmt.Printf("Replayed %d ML decisions on %d devices (%s to %s)\n",
+		report.MLDecisions, report.Devices, report.From.Format(time.RFC3339), report.To.Format(time.RFC3339))
+	percent := 0.0
+	if report.MLDecisions > 0 {
+		percent = float64(report.Changed) / float64(report.MLDecisions) * 100
+	}
+	fmt.Printf("Rules would have changed %d decisions (%.1f%%)\n\n", report.Changed, percent)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RULE\tACTIVATIONS\tACTIVE (h)\tOVERRIDDEN\tCAPPED")
+	for _, r := range report.Rules {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%d\t%d\n", r.Name, r.Activations, r.ActiveMinutes/60, r.Overridden, r.Capped)
+	}
+	tw.Flush()
+}
@@ -10,9 +10,10 @@ import (
 
 // AudioConfig holds configuration for audio processing
 type AudioConfig struct {
-	BitsPerSample  int     // Typically 16 for 16-bit PCM
-	ReferenceLevel float64 // Reference level for dB calculation (default: 32768.0 for 16-bit)
-	MinimumRMS     float64 // Minimum RMS to avoid log(0), represents silence threshold
+	BitsPerSample     int     // Typically 16 for 16-bit PCM
+	ReferenceLevel    float64 // Reference level for dB calculation (default: 32768.0 for 16-bit)
+	MinimumRMS        float64 // Minimum RMS to avoid log(0), represents silence threshold
+	ClippingThreshold int     // Absolute sample value above which a sample counts as clipped
 }
 
 // DefaultAudioConfig returns default audio processing configuration
@@ -21,9 +22,23 @@ func DefaultAudioConfig() AudioConfig {
 		BitsPerSample:  16,
 		ReferenceLevel: 32768.0, // Maximum value for signed 16-bit audio
 		MinimumRMS:     1.0,     // Prevents log(0) and extremely low values
+
+		ClippingThreshold: 32000, // Close to max value of 32767
 	}
 }
 
+// AudioConfigForBits returns the default configuration for PCM of another bit depth
+// (8-bit unsigned, or 16-, 24-, or 32-bit signed little-endian), with the reference
+// and silence levels scaled so volumes are comparable across depths
+func AudioConfigForBits(bits int) AudioConfig {
+	config := DefaultAudioConfig()
+	scale := math.Ldexp(1, bits-16)
+	config.BitsPerSample = bits
+	config.ReferenceLevel *= scale
+	config.MinimumRMS *= scale
+	return config
+}
+
 // ExtractSoundVolume extracts sound volume in dB from audio data
 // Assumes 16-bit PCM little-endian format (standard for WAV files)
 func ExtractSoundVolume(audioData []byte, sampleRate int) float64 {
@@ -46,7 +61,12 @@ func ExtractSoundVolumeWithConfig(audioData []byte, sampleRate int, config Audio
 	}
 
 	// Parse samples and calculate RMS
-	rms := calculateRMS16Bit(audioData)
+	var rms float64
+	if config.BitsPerSample == 16 {
+		rms = calculateRMS16Bit(audioData)
+	} else {
+		rms = calculateRMSPCM(audioData, bytesPerSample)
+	}
 
 	// Apply minimum threshold to avoid log(0)
 	if rms < config.MinimumRMS {
@@ -88,6 +108,34 @@ func calculateRMS16Bit(audioData []byte) float64 {
 	return math.Sqrt(meanSquares)
 }
 
+// calculateRMSPCM calculates RMS from 8-bit unsigned or 24- and 32-bit signed
+// little-endian PCM, in units of the sample's own depth
+func calculateRMSPCM(audioData []byte, bytesPerSample int) float64 {
+	if bytesPerSample < 1 || bytesPerSample > 4 || len(audioData) < bytesPerSample {
+		return 0.0
+	}
+
+	var sumSquares float64
+	sampleCount := len(audioData) / bytesPerSample
+	for i := 0; i+bytesPerSample <= len(audioData); i += bytesPerSample {
+		var sample float64
+		if bytesPerSample == 1 {
+			sample = float64(int(audioData[i]) - 128) // 8-bit WAV is unsigned around 128
+		} else {
+			// Little-endian, sign-extended from the top byte
+			var v int32
+			for b := bytesPerSample - 1; b >= 0; b-- {
+				v = v<<8 | int32(audioData[i+b])
+			}
+			shift := 32 - 8*bytesPerSample
+			sample = float64(v << shift >> shift)
+		}
+		sumSquares += sample * sample
+	}
+
+	return math.Sqrt(sumSquares / float64(sampleCount))
+}
+
 // calculateDecibels converts RMS value to decibels
 // Formula: dB = 20 * log10(RMS / reference)
 func calculateDecibels(rms float64, reference float64) float64 {
@@ -110,19 +158,33 @@ func calculateDecibels(rms float64, reference float64) float64 {
 	return db
 }
 
+// CompensateAGC converts a level measured after an AGC stage back to the level at the
+// microphone. ESP32 microphones with automatic gain control (e.g., ES7210 or a
+// firmware AGC) raise quiet rooms towards a target level, so without compensation
+// loudness isn't comparable over time or across devices.
+func CompensateAGC(levelDB, gainDB float64) float64 {
+	return levelDB - gainDB
+}
+
 // AnalyzeAudioQuality provides basic audio quality metrics
 type AudioQualityMetrics struct {
-	RMS           float64 // RMS value
-	VolumeDB      float64 // Volume in decibels
-	PeakAmplitude int16   // Peak sample value
-	IsClipping    bool    // True if clipping detected
-	IsSilent      bool    // True if audio is essentially silent
-	SampleCount   int     // Number of samples
+	RMS            float64 // RMS value
+	VolumeDB       float64 // Volume in decibels
+	PeakAmplitude  int16   // Peak sample value
+	IsClipping     bool    // True if clipping detected
+	IsSilent       bool    // True if audio is essentially silent
+	SampleCount    int     // Number of samples
+	ClippedSamples int     // Samples above the clipping threshold
+	ClippingRatio  float64 // Share of samples above the clipping threshold (0-1)
 }
 
 // AnalyzeAudio provides detailed audio analysis
 func AnalyzeAudio(audioData []byte, sampleRate int) AudioQualityMetrics {
-	config := DefaultAudioConfig()
+	return AnalyzeAudioWithConfig(audioData, sampleRate, DefaultAudioConfig())
+}
+
+// AnalyzeAudioWithConfig provides detailed audio analysis with custom configuration
+func AnalyzeAudioWithConfig(audioData []byte, sampleRate int, config AudioConfig) AudioQualityMetrics {
 	metrics := AudioQualityMetrics{
 		SampleCount: len(audioData) / 2,
 	}
@@ -134,14 +196,13 @@ func AnalyzeAudio(audioData []byte, sampleRate int) AudioQualityMetrics {
 	}
 
 	var sumSquares float64
-	var peakAmp int16 = 0
-	clippingThreshold := int16(32000) // Close to max value of 32767
+	var peakAmp int = 0
 
 	for i := 0; i < len(audioData)-1; i += 2 {
 		sample := int16(binary.LittleEndian.Uint16(audioData[i : i+2]))
 
-		// Track peak amplitude
-		absSample := sample
+		// Track peak amplitude (-32768 has no int16 absolute value)
+		absSample := int(sample)
 		if absSample < 0 {
 			absSample = -absSample
 		}
@@ -150,8 +211,8 @@ func AnalyzeAudio(audioData []byte, sampleRate int) AudioQualityMetrics {
 		}
 
 		// Check for clipping
-		if absSample > clippingThreshold {
-			metrics.IsClipping = true
+		if absSample > config.ClippingThreshold {
+			metrics.ClippedSamples++
 		}
 
 		// Accumulate for RMS
@@ -162,7 +223,9 @@ func AnalyzeAudio(audioData []byte, sampleRate int) AudioQualityMetrics {
 	// Calculate RMS
 	meanSquares := sumSquares / float64(metrics.SampleCount)
 	metrics.RMS = math.Sqrt(meanSquares)
-	metrics.PeakAmplitude = peakAmp
+	metrics.PeakAmplitude = int16(min(peakAmp, math.MaxInt16))
+	metrics.IsClipping = metrics.ClippedSamples > 0
+	metrics.ClippingRatio = float64(metrics.ClippedSamples) / float64(metrics.SampleCount)
 
 	// Check for silence (RMS below threshold)
 	if metrics.RMS < config.MinimumRMS {
@@ -0,0 +1,70 @@
+package aggregator
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"math"
+	"os"
+	"testing"
+
+	"iot-backend/pkg/audiofixture"
+)
+
+// goldenToleranceDB is how far a measured volume may be from its golden value
+const goldenToleranceDB = 0.05
+
+// TestGoldenVolumes measures every golden clip as raw PCM and as a WAV file, the
+// same checks "iot-backend audio verify" runs against a deployed build
+func TestGoldenVolumes(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	clippingFraction := float64(DefaultAudioConfig().ClippingThreshold+1) / 32768
+
+	for _, golden := range audiofixture.Goldens() {
+		golden := golden
+		t.Run(golden.Name, func(t *testing.T) {
+			spec := golden.Spec
+			pcm, err := spec.PCM()
+			if err != nil {
+				t.Fatalf("failed to synthesize PCM: %v", err)
+			}
+			wav, err := spec.WAV()
+			if err != nil {
+				t.Fatalf("failed to synthesize WAV: %v", err)
+			}
+			bits := spec.BitsPerSample
+			if bits == 0 {
+				bits = 16
+			}
+
+			if expected := spec.ExpectedVolumeDB(); math.Abs(expected-golden.VolumeDB) > goldenToleranceDB {
+				t.Errorf("fixture waveform is %.3f dB, golden value %.3f dB", expected, golden.VolumeDB)
+			}
+			measured := ExtractSoundVolumeWithConfig(pcm, spec.SampleRate, AudioConfigForBits(bits))
+			if math.Abs(measured-golden.VolumeDB) > goldenToleranceDB {
+				t.Errorf("volume %.3f dB, want %.3f dB", measured, golden.VolumeDB)
+			}
+
+			// The WAV path must decode to the same samples
+			format, data, err := ParseWAV(wav)
+			switch {
+			case err != nil:
+				t.Errorf("WAV not parsed: %v", err)
+			case format.BitsPerSample != bits:
+				t.Errorf("WAV has %d bits per sample, want %d", format.BitsPerSample, bits)
+			case !bytes.Equal(data, pcm):
+				t.Errorf("WAV samples differ from the PCM")
+			}
+
+			// Clipping is measured on 16-bit audio only
+			if bits == 16 {
+				analysis := AnalyzeAudio(pcm, spec.SampleRate)
+				if expected := spec.ExpectedClippingRatio(clippingFraction); math.Abs(analysis.ClippingRatio-expected) > 0.001 {
+					t.Errorf("clipping ratio %.4f, want %.4f", analysis.ClippingRatio, expected)
+				}
+			}
+		})
+	}
+}
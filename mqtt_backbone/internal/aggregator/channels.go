@@ -0,0 +1,153 @@
+package aggregator
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// DownmixStrategy selects how multi-channel audio is reduced to mono for level
+// and feature extraction
+type DownmixStrategy string
+
+const (
+	// DownmixAverage averages the channels of each frame
+	DownmixAverage DownmixStrategy = "average"
+	// DownmixLoudest keeps the channel with the highest RMS, which avoids the
+	// phase cancellation averaging can cause with spaced microphones
+	DownmixLoudest DownmixStrategy = "loudest"
+	// DownmixFirst keeps the first (left) channel
+	DownmixFirst DownmixStrategy = "first"
+)
+
+// ParseDownmixStrategy validates a downmix strategy name
+func ParseDownmixStrategy(name string) (DownmixStrategy, error) {
+	switch s := DownmixStrategy(name); s {
+	case DownmixAverage, DownmixLoudest, DownmixFirst:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown downmix strategy %q (want average, loudest, or first)", name)
+}
+
+// ChannelLevels returns the RMS level (dB) of each channel of interleaved 16-bit PCM
+func ChannelLevels(audioData []byte, channels int) []float64 {
+	config := DefaultAudioConfig()
+	if channels < 1 {
+		channels = 1
+	}
+
+	sumSquares := make([]float64, channels)
+	frames := len(audioData) / (2 * channels)
+	for f := 0; f < frames; f++ {
+		for c := 0; c < channels; c++ {
+			i := (f*channels + c) * 2
+			sample := float64(int16(binary.LittleEndian.Uint16(audioData[i : i+2])))
+			sumSquares[c] += sample * sample
+		}
+	}
+
+	levels := make([]float64, channels)
+	for c := range levels {
+		rms := config.MinimumRMS
+		if frames > 0 {
+			rms = math.Max(math.Sqrt(sumSquares[c]/float64(frames)), config.MinimumRMS)
+		}
+		levels[c] = calculateDecibels(rms, config.ReferenceLevel)
+	}
+	return levels
+}
+
+// Downmix reduces interleaved 16-bit PCM to mono. Mono input is returned unchanged;
+// a trailing partial frame is dropped.
+func Downmix(audioData []byte, channels int, strategy DownmixStrategy) []byte {
+	if channels <= 1 {
+		return audioData
+	}
+
+	frames := len(audioData) / (2 * channels)
+	mono := make([]byte, frames*2)
+	sample := func(f, c int) int16 {
+		i := (f*channels + c) * 2
+		return int16(binary.LittleEndian.Uint16(audioData[i : i+2]))
+	}
+
+	switch strategy {
+	case DownmixFirst, DownmixLoudest:
+		channel := 0
+		if strategy == DownmixLoudest {
+			levels := ChannelLevels(audioData, channels)
+			for c, level := range levels {
+				if level > levels[channel] {
+					channel = c
+				}
+			}
+		}
+		for f := 0; f < frames; f++ {
+			binary.LittleEndian.PutUint16(mono[f*2:], uint16(sample(f, channel)))
+		}
+	default: // DownmixAverage
+		for f := 0; f < frames; f++ {
+			var sum int
+			for c := 0; c < channels; c++ {
+				sum += int(sample(f, c))
+			}
+			binary.LittleEndian.PutUint16(mono[f*2:], uint16(int16(sum/channels)))
+		}
+	}
+	return mono
+}
+
+// WAVFormat is the format chunk of a WAV file
+type WAVFormat struct {
+	Channels      int
+	SampleRate    int
+	BitsPerSample int
+}
+
+// errNotWAV is returned by ParseWAV for data without a RIFF/WAVE header
+var errNotWAV = errors.New("not a WAV file")
+
+// IsWAV reports whether data starts with a RIFF/WAVE header
+func IsWAV(data []byte) bool {
+	return len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE"
+}
+
+// ParseWAV reads the format of a PCM WAV file and returns it with the sample data
+func ParseWAV(data []byte) (WAVFormat, []byte, error) {
+	var format WAVFormat
+	if !IsWAV(data) {
+		return format, nil, errNotWAV
+	}
+
+	haveFormat := false
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := data[pos+8:]
+		if size > len(body) {
+			size = len(body) // Streaming encoders may leave the size unset or too large
+		}
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return format, nil, fmt.Errorf("WAV format chunk too short (%d bytes)", size)
+			}
+			if tag := binary.LittleEndian.Uint16(body[0:2]); tag != 1 && tag != 0xFFFE {
+				return format, nil, fmt.Errorf("unsupported WAV encoding %d (want PCM)", tag)
+			}
+			format.Channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			format.SampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			format.BitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+			haveFormat = true
+		case "data":
+			if !haveFormat {
+				return format, nil, errors.New("WAV data chunk before format chunk")
+			}
+			return format, body[:size], nil
+		}
+		pos += 8 + size + size%2 // Chunks are padded to even sizes
+	}
+	return format, nil, errors.New("WAV file has no data chunk")
+}
@@ -0,0 +1,145 @@
+package aggregator
+
+import (
+	"encoding/binary"
+	"math"
+	"math/cmplx"
+)
+
+// spectrumSize is the FFT length used for spectral features (64 ms at 16 kHz)
+const spectrumSize = 1024
+
+// maxSpectrumFrames bounds the FFT work per clip
+const maxSpectrumFrames = 16
+
+// ClipFeatures summarizes a clip's acoustic fingerprint
+type ClipFeatures struct {
+	VolumeDB         float64 // RMS level (dB)
+	CrestFactorDB    float64 // Peak-to-RMS ratio (dB); impulsive sounds score high
+	ZeroCrossingRate float64 // Sign changes per sample; rises with noisy/high-pitched content
+	SpectralCentroid float64 // Energy-weighted mean frequency (Hz)
+	SpectralFlatness float64 // 0 (tonal, e.g., alarm beeps) - 1 (noise-like)
+}
+
+// Map returns the features keyed by name (as stored with the clip)
+func (f ClipFeatures) Map() map[string]float64 {
+	return map[string]float64{
+		"volume_db":          f.VolumeDB,
+		"crest_factor_db":    f.CrestFactorDB,
+		"zero_crossing_rate": f.ZeroCrossingRate,
+		"spectral_centroid":  f.SpectralCentroid,
+		"spectral_flatness":  f.SpectralFlatness,
+	}
+}
+
+// ExtractClipFeatures computes the features of 16-bit PCM little-endian audio
+func ExtractClipFeatures(audioData []byte, sampleRate int) ClipFeatures {
+	config := DefaultAudioConfig()
+	n := len(audioData) / 2
+	if n == 0 {
+		return ClipFeatures{VolumeDB: -80.0}
+	}
+
+	samples := make([]float64, n)
+	for i := 0; i < n; i++ {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(audioData[i*2 : i*2+2])))
+	}
+
+	var sumSquares, peak float64
+	crossings := 0
+	for i, s := range samples {
+		sumSquares += s * s
+		if math.Abs(s) > peak {
+			peak = math.Abs(s)
+		}
+		if i > 0 && (s >= 0) != (samples[i-1] >= 0) {
+			crossings++
+		}
+	}
+
+	rms := math.Max(math.Sqrt(sumSquares/float64(n)), config.MinimumRMS)
+	features := ClipFeatures{
+		VolumeDB:         calculateDecibels(rms, config.ReferenceLevel),
+		CrestFactorDB:    20 * math.Log10(math.Max(peak, config.MinimumRMS)/rms),
+		ZeroCrossingRate: float64(crossings) / float64(n),
+	}
+	features.SpectralCentroid, features.SpectralFlatness = spectralShape(samples, sampleRate)
+
+	return features
+}
+
+// spectralShape averages the power spectrum over evenly spaced frames and
+// returns its centroid (Hz) and flatness
+func spectralShape(samples []float64, sampleRate int) (float64, float64) {
+	if len(samples) < spectrumSize || sampleRate <= 0 {
+		return 0, 0
+	}
+
+	frames := len(samples) / spectrumSize
+	if frames > maxSpectrumFrames {
+		frames = maxSpectrumFrames
+	}
+	stride := (len(samples) - spectrumSize) / max(frames-1, 1)
+
+	power := make([]float64, spectrumSize/2)
+	buf := make([]complex128, spectrumSize)
+	for f := 0; f < frames; f++ {
+		offset := f * stride
+		for i := 0; i < spectrumSize; i++ {
+			// Hann window reduces leakage between bins
+			w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(spectrumSize-1))
+			buf[i] = complex(samples[offset+i]*w, 0)
+		}
+		fft(buf)
+		for k := range power {
+			m := cmplx.Abs(buf[k])
+			power[k] += m * m
+		}
+	}
+
+	binHz := float64(sampleRate) / float64(spectrumSize)
+	var total, weighted, logSum float64
+	for k := 1; k < len(power); k++ { // Skip DC
+		p := power[k] + 1e-12
+		total += p
+		weighted += p * float64(k) * binHz
+		logSum += math.Log(p)
+	}
+	if total <= 1e-6 {
+		return 0, 0
+	}
+
+	bins := float64(len(power) - 1)
+	centroid := weighted / total
+	flatness := math.Exp(logSum/bins) / (total / bins)
+	return centroid, flatness
+}
+
+// fft computes an in-place radix-2 FFT; len(x) must be a power of two
+func fft(x []complex128) {
+	n := len(x)
+
+	// Bit-reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
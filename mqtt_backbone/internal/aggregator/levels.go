@@ -0,0 +1,161 @@
+package aggregator
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"iot-backend/internal/stats"
+)
+
+// LevelFrameSeconds is the frame length used for percentile levels (the "fast" time weighting)
+const LevelFrameSeconds = 0.125
+
+// LevelStats holds the statistical sound levels of a clip or window, in dB.
+// Ln is the level exceeded n% of the time: L10 captures intermittent peaks,
+// L50 the median level, and L90 the background level.
+type LevelStats struct {
+	L10    float64
+	L50    float64
+	L90    float64
+	Leq    float64 // Energy-equivalent continuous level
+	Frames int     // Number of frames the statistics cover
+}
+
+// FrameLevels splits 16-bit PCM little-endian audio into fixed frames and returns each frame's level in dB
+func FrameLevels(audioData []byte, sampleRate int) []float64 {
+	config := DefaultAudioConfig()
+
+	samplesPerFrame := int(float64(sampleRate) * LevelFrameSeconds)
+	if samplesPerFrame <= 0 {
+		samplesPerFrame = 1
+	}
+	frameBytes := samplesPerFrame * 2
+
+	var levels []float64
+	for start := 0; start+1 < len(audioData); start += frameBytes {
+		end := min(start+frameBytes, len(audioData))
+		// A trailing frame shorter than half a frame is too noisy to count
+		if end-start < frameBytes/2 && len(levels) > 0 {
+			break
+		}
+
+		var sumSquares float64
+		count := 0
+		for i := start; i+1 < end; i += 2 {
+			sample := float64(int16(binary.LittleEndian.Uint16(audioData[i : i+2])))
+			sumSquares += sample * sample
+			count++
+		}
+		if count == 0 {
+			continue
+		}
+
+		rms := math.Sqrt(sumSquares / float64(count))
+		if rms < config.MinimumRMS {
+			rms = config.MinimumRMS
+		}
+		levels = append(levels, calculateDecibels(rms, config.ReferenceLevel))
+	}
+
+	return levels
+}
+
+// ComputeLevelStats computes percentile levels over frame levels
+func ComputeLevelStats(levels []float64) LevelStats {
+	if len(levels) == 0 {
+		return LevelStats{L10: -80.0, L50: -80.0, L90: -80.0, Leq: -80.0}
+	}
+
+	sorted := append([]float64(nil), levels...)
+	sort.Float64s(sorted)
+
+	return LevelStats{
+		L10:    stats.Percentile(sorted, 90),
+		L50:    stats.Percentile(sorted, 50),
+		L90:    stats.Percentile(sorted, 10),
+		Leq:    energyMean(levels),
+		Frames: len(levels),
+	}
+}
+
+// energyMean averages levels in the energy domain
+func energyMean(levels []float64) float64 {
+	var sum float64
+	for _, l := range levels {
+		sum += math.Pow(10, l/10)
+	}
+	return 10 * math.Log10(sum/float64(len(levels)))
+}
+
+// levelHistogramBins covers -80..0 dB in 0.5 dB steps
+const (
+	levelHistogramMin  = -80.0
+	levelHistogramStep = 0.5
+	levelHistogramBins = 161
+)
+
+// LevelHistogram accumulates frame levels over a long window (e.g., an hour) in constant memory.
+// Percentiles are accurate to the 0.5 dB bin width.
+type LevelHistogram struct {
+	bins      [levelHistogramBins]uint32
+	count     int
+	energySum float64
+}
+
+// Add records frame levels
+func (h *LevelHistogram) Add(levels []float64) {
+	for _, l := range levels {
+		idx := int(math.Round((l - levelHistogramMin) / levelHistogramStep))
+		if idx < 0 {
+			idx = 0
+		}
+		if idx >= levelHistogramBins {
+			idx = levelHistogramBins - 1
+		}
+		h.bins[idx]++
+		h.count++
+		h.energySum += math.Pow(10, l/10)
+	}
+}
+
+// Count returns the number of recorded frames
+func (h *LevelHistogram) Count() int {
+	return h.count
+}
+
+// Stats returns the percentile levels of all recorded frames
+func (h *LevelHistogram) Stats() LevelStats {
+	if h.count == 0 {
+		return ComputeLevelStats(nil)
+	}
+	return LevelStats{
+		L10:    h.quantile(0.90),
+		L50:    h.quantile(0.50),
+		L90:    h.quantile(0.10),
+		Leq:    10 * math.Log10(h.energySum/float64(h.count)),
+		Frames: h.count,
+	}
+}
+
+// quantile returns the bin level at which the cumulative share reaches q
+func (h *LevelHistogram) quantile(q float64) float64 {
+	target := q * float64(h.count)
+	var cumulative float64
+	for i, n := range h.bins {
+		cumulative += float64(n)
+		if cumulative >= target && n > 0 {
+			return levelHistogramMin + float64(i)*levelHistogramStep
+		}
+	}
+	return levelHistogramMin + float64(levelHistogramBins-1)*levelHistogramStep
+}
+
+// Merge adds another histogram's frames to this one
+func (h *LevelHistogram) Merge(other *LevelHistogram) {
+	for i, n := range other.bins {
+		h.bins[i] += n
+	}
+	h.count += other.count
+	h.energySum += other.energySum
+}
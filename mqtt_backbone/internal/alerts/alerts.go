@@ -0,0 +1,174 @@
+// Package alerts raises, deduplicates, stores, and delivers alerts
+// through pluggable notifiers.
+package alerts
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+)
+
+// Notifier delivers alerts to a destination (log, email, ...)
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, alert *models.Alert) error
+}
+
+// Store persists raised alerts
+type Store interface {
+	SaveAlert(alert *models.Alert) error
+}
+
+// LogNotifier writes alerts to the service log
+type LogNotifier struct{}
+
+// Name returns the notifier name
+func (LogNotifier) Name() string { return "log" }
+
+// Notify logs the alert
+func (LogNotifier) Notify(_ context.Context, alert *models.Alert) error {
+	log.Printf("ALERT [%s] %s on %s: %s", alert.Severity, alert.Type, alert.DeviceID, alert.Message)
+	return nil
+}
+
+// Manager deduplicates alerts per device and type, stores them, and fans them out to notifiers
+type Manager struct {
+	store     Store
+	notifiers []Notifier
+	cooldown  time.Duration
+
+	// Optional tag scoping per alert type
+	rules map[string][]Rule
+	tags  TagLookup
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time // device_id/type -> last time raised
+
+	// Optional persisted snoozes silencing a device or alert type for a while
+	snoozeStore SnoozeStore
+	snoozes     map[string]*models.AlertSnooze // Active snoozes by ID
+
+	synthetic map[string]bool // Devices generated by the backend; only canary alerts are raised for them
+}
+
+// NewManager creates an alert manager. Repeats of the same alert type for a
+// device within cooldown are suppressed.
+func NewManager(store Store, cooldown time.Duration, notifiers ...Notifier) *Manager {
+	return &Manager{
+		store:     store,
+		notifiers: notifiers,
+		cooldown:  cooldown,
+		lastSent:  make(map[string]time.Time),
+	}
+}
+
+// AddNotifier registers an additional notifier
+func (m *Manager) AddNotifier(n Notifier) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifiers = append(m.notifiers, n)
+}
+
+// SetRules scopes alert types to tagged devices, looking tags up through lookup
+func (m *Manager) SetRules(rules []Rule, lookup TagLookup) {
+	byType := make(map[string][]Rule)
+	for _, r := range rules {
+		byType[r.Type] = append(byType[r.Type], r)
+		log.Printf("Alerts: Scoping rule %s", r)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rules = byType
+	m.tags = lookup
+}
+
+// SetSyntheticDevices names devices whose readings the backend generates itself (the
+// end-to-end canary). Alerts about their readings are dropped; canary failures aren't.
+func (m *Manager) SetSyntheticDevices(deviceIDs []string) {
+	synthetic := make(map[string]bool, len(deviceIDs))
+	for _, id := range deviceIDs {
+		synthetic[id] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.synthetic = synthetic
+}
+
+// inScope reports whether an alert passes the rules for its type.
+// Alerts about synthetic devices' readings are never in scope.
+// A failed tag lookup lets the alert through rather than hiding it.
+func (m *Manager) inScope(alert *models.Alert) bool {
+	m.mu.Lock()
+	rules := m.rules[alert.Type]
+	lookup := m.tags
+	synthetic := m.synthetic[alert.DeviceID]
+	m.mu.Unlock()
+
+	if synthetic && alert.Type != models.AlertCanaryFailed {
+		return false
+	}
+	if len(rules) == 0 || lookup == nil {
+		return true
+	}
+
+	tags, err := lookup.DeviceTags(alert.DeviceID)
+	if err != nil {
+		log.Printf("Alerts: Error looking up tags for %s: %v", alert.DeviceID, err)
+		return true
+	}
+	for _, r := range rules {
+		if r.matches(tags) {
+			return true
+		}
+	}
+	return false
+}
+
+// Raise stores and delivers an alert unless it is within its cooldown, outside its rules, or snoozed.
+// Delivery happens in the background so callers on hot paths never block on a notifier.
+// Returns true if the alert was raised.
+func (m *Manager) Raise(alert *models.Alert) bool {
+	if alert.Timestamp.IsZero() {
+		alert.Timestamp = time.Now()
+	}
+	if !m.inScope(alert) {
+		return false
+	}
+	if m.snoozed(alert) {
+		metrics.Default.Counter("alerts_snoozed").Inc()
+		return false
+	}
+
+	key := alert.DeviceID + "/" + alert.Type
+	m.mu.Lock()
+	if last, ok := m.lastSent[key]; ok && alert.Timestamp.Sub(last) < m.cooldown {
+		m.mu.Unlock()
+		return false
+	}
+	m.lastSent[key] = alert.Timestamp
+	notifiers := append([]Notifier(nil), m.notifiers...)
+	m.mu.Unlock()
+
+	if m.store != nil {
+		if err := m.store.SaveAlert(alert); err != nil {
+			log.Printf("Alerts: Error saving alert: %v", err)
+		}
+	}
+
+	for _, n := range notifiers {
+		go func(n Notifier) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := n.Notify(ctx, alert); err != nil {
+				log.Printf("Alerts: %s notifier failed for %s/%s: %v", n.Name(), alert.DeviceID, alert.Type, err)
+			}
+		}(n)
+	}
+	return true
+}
@@ -0,0 +1,339 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+// EmailConfig holds SMTP settings for the email notifier
+type EmailConfig struct {
+	Host     string // SMTP server, e.g. "smtp.example.com"
+	Port     int    // Default 587 (STARTTLS is used when the server offers it)
+	Username string // Empty disables authentication
+	Password string
+	From     string // Sender address
+}
+
+// DefaultEmailConfig returns default SMTP settings
+func DefaultEmailConfig() EmailConfig {
+	return EmailConfig{Port: 587}
+}
+
+// Route sends alerts of the given types (empty = all) to a recipient,
+// optionally only for devices carrying all of the given tags
+type Route struct {
+	Recipient string
+	Types     []string
+	Tags      map[string]string
+}
+
+// ParseRoutes parses "recipient:type,type[:key=value,key=value];...".
+// The type list may be "*" to receive every alert type.
+func ParseRoutes(spec string) ([]Route, error) {
+	var routes []Route
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		fields := strings.SplitN(part, ":", 3)
+		recipient := strings.TrimSpace(fields[0])
+		if len(fields) < 2 || !strings.Contains(recipient, "@") {
+			return nil, fmt.Errorf("email route %q must be recipient:types", part)
+		}
+
+		route := Route{Recipient: recipient}
+		for _, t := range strings.Split(fields[1], ",") {
+			t = strings.TrimSpace(t)
+			if t != "" && t != "*" {
+				route.Types = append(route.Types, t)
+			}
+		}
+		if len(fields) == 3 {
+			tags, err := ParseTags(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("email route %q: %w", part, err)
+			}
+			route.Tags = tags
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// String formats a route in ParseRoutes syntax
+func (r Route) String() string {
+	types := "*"
+	if len(r.Types) > 0 {
+		types = strings.Join(r.Types, ",")
+	}
+	s := r.Recipient + ":" + types
+	if len(r.Tags) > 0 {
+		s += ":" + formatTags(r.Tags)
+	}
+	return s
+}
+
+// accepts reports whether the route takes an alert type
+func (r Route) accepts(alertType string) bool {
+	if len(r.Types) == 0 {
+		return true
+	}
+	for _, t := range r.Types {
+		if t == alertType {
+			return true
+		}
+	}
+	return false
+}
+
+// emailTemplate renders the subject and body for one alert type
+type emailTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+func newEmailTemplate(subject, body string) emailTemplate {
+	return emailTemplate{
+		subject: template.Must(template.New("subject").Parse(subject)),
+		body:    template.Must(template.New("body").Parse(body)),
+	}
+}
+
+// Templates per alert type; other types use defaultEmailTemplate
+var emailTemplates = map[string]emailTemplate{
+	models.AlertDeviceOffline: newEmailTemplate(
+		"[{{.Severity}}] Device {{.DeviceID}} is offline",
+		`Device {{.DeviceID}} has stopped reporting.
+
+{{.Message}}
+
+Minutes without data: {{printf "%.0f" .Value}}
+Detected at: {{.Timestamp.Format "2006-01-02 15:04:05 MST"}}
+
+Check the device's power and Wi-Fi connection.
+`),
+	models.AlertHighCO2: newEmailTemplate(
+		`[{{.Severity}}] High CO2 at {{.DeviceID}} ({{printf "%.0f" .Value}} ppm)`,
+		`CO2 at device {{.DeviceID}} has reached {{printf "%.0f" .Value}} ppm.
+
+{{.Message}}
+
+Measured at: {{.Timestamp.Format "2006-01-02 15:04:05 MST"}}
+
+Open a window or increase ventilation.
+`),
+	models.AlertMoldRisk: newEmailTemplate(
+		`[{{.Severity}}] Mold risk at {{.DeviceID}} ({{printf "%.2f" .Value}})`,
+		`Conditions at device {{.DeviceID}} have favoured mold growth for a sustained period.
+
+{{.Message}}
+
+Risk index: {{printf "%.2f" .Value}} (0 = none, 1 = sustained risk)
+Measured at: {{.Timestamp.Format "2006-01-02 15:04:05 MST"}}
+
+Ventilate the room and reduce humidity.
+`),
+	models.AlertNoiseExposure: newEmailTemplate(
+		`[{{.Severity}}] Sustained noise at {{.DeviceID}} ({{printf "%.0f" .Value}} dB)`,
+		`Noise at device {{.DeviceID}} has stayed above the configured level.
+
+{{.Message}}
+
+Level: {{printf "%.1f" .Value}} dB
+Measured at: {{.Timestamp.Format "2006-01-02 15:04:05 MST"}}
+
+Close the window or check for a nearby noise source.
+`),
+	models.AlertCanaryFailed: newEmailTemplate(
+		`[{{.Severity}}] End-to-end canary failed ({{.DeviceID}})`,
+		`Synthetic readings published as device {{.DeviceID}} did not make it through the pipeline in time.
+
+{{.Message}}
+
+Seconds since publish: {{printf "%.0f" .Value}}
+Detected at: {{.Timestamp.Format "2006-01-02 15:04:05 MST"}}
+
+Check the broker, ClickHouse, and the ML service; GET /health shows the stages the run reached.
+`),
+	models.AlertSensorDivergence: newEmailTemplate(
+		`[{{.Severity}}] Sensor at {{.DeviceID}} disagrees with its room ({{printf "%+.1f" .Value}})`,
+		`Readings of device {{.DeviceID}} have persistently differed from the other devices in its room.
+
+{{.Message}}
+
+Mean divergence: {{printf "%+.2f" .Value}}
+Detected at: {{.Timestamp.Format "2006-01-02 15:04:05 MST"}}
+
+Check the sensor's placement (sun, heater, draft) and replace it if it is failing; GET /devices/{{.DeviceID}}/divergence shows the history.
+`),
+}
+
+var defaultEmailTemplate = newEmailTemplate(
+	"[{{.Severity}}] {{.Type}} on {{.DeviceID}}",
+	`{{.Message}}
+
+Value: {{.Value}}
+Raised at: {{.Timestamp.Format "2006-01-02 15:04:05 MST"}}
+`)
+
+// EmailNotifier delivers alerts over SMTP to the recipients whose routes match
+type EmailNotifier struct {
+	config EmailConfig
+	routes []Route
+	tags   TagLookup
+}
+
+// NewEmailNotifier creates an email notifier. lookup resolves device tags for
+// routes with tag filters and may be nil if no route uses them.
+func NewEmailNotifier(config EmailConfig, routes []Route, lookup TagLookup) *EmailNotifier {
+	if config.Port == 0 {
+		config.Port = DefaultEmailConfig().Port
+	}
+	for _, r := range routes {
+		log.Printf("Alerts: Email route %s", r)
+	}
+	return &EmailNotifier{config: config, routes: routes, tags: lookup}
+}
+
+// Name returns the notifier name
+func (n *EmailNotifier) Name() string { return "email" }
+
+// Notify emails the alert to every matching recipient
+func (n *EmailNotifier) Notify(ctx context.Context, alert *models.Alert) error {
+	recipients, err := n.recipients(alert)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	msg, err := n.render(alert, recipients)
+	if err != nil {
+		return err
+	}
+	if err := n.send(ctx, recipients, msg); err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", strings.Join(recipients, ", "), err)
+	}
+	return nil
+}
+
+// recipients returns the deduplicated addresses whose routes match the alert
+func (n *EmailNotifier) recipients(alert *models.Alert) ([]string, error) {
+	var tags map[string]string
+	var recipients []string
+	seen := make(map[string]bool)
+
+	for _, r := range n.routes {
+		if seen[r.Recipient] || !r.accepts(alert.Type) {
+			continue
+		}
+		if len(r.Tags) > 0 {
+			if n.tags == nil {
+				continue
+			}
+			if tags == nil {
+				var err error
+				if tags, err = n.tags.DeviceTags(alert.DeviceID); err != nil {
+					return nil, fmt.Errorf("failed to look up tags for %s: %w", alert.DeviceID, err)
+				}
+			}
+			if !(Rule{Tags: r.Tags}).matches(tags) {
+				continue
+			}
+		}
+		seen[r.Recipient] = true
+		recipients = append(recipients, r.Recipient)
+	}
+	return recipients, nil
+}
+
+// render builds the RFC 5322 message for an alert
+func (n *EmailNotifier) render(alert *models.Alert, recipients []string) ([]byte, error) {
+	tmpl, ok := emailTemplates[alert.Type]
+	if !ok {
+		tmpl = defaultEmailTemplate
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, alert); err != nil {
+		return nil, fmt.Errorf("failed to render email subject: %w", err)
+	}
+	if err := tmpl.body.Execute(&body, alert); err != nil {
+		return nil, fmt.Errorf("failed to render email body: %w", err)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.ReplaceAll(subject.String(), "\n", " "))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+	return msg.Bytes(), nil
+}
+
+// send delivers a message, honouring the context deadline
+func (n *EmailNotifier) send(ctx context.Context, recipients []string, msg []byte) error {
+	addr := net.JoinHostPort(n.config.Host, strconv.Itoa(n.config.Port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, n.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: n.config.Host}); err != nil {
+			return err
+		}
+	}
+	if n.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(n.config.From); err != nil {
+		return err
+	}
+	for _, rcpt := range recipients {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,90 @@
+package alerts
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Rule scopes an alert type to devices carrying all of the given tags.
+// Alert types without rules are raised for every device.
+type Rule struct {
+	Type string            // Alert type, e.g. "mold_risk"
+	Tags map[string]string // Every tag must match
+}
+
+// TagLookup returns a device's registry tags
+type TagLookup interface {
+	DeviceTags(deviceID string) (map[string]string, error)
+}
+
+// ParseRules parses "type:key=value,key=value;type:key=value". Several rules
+// for the same type are alternatives (a device matching any of them qualifies).
+func ParseRules(spec string) ([]Rule, error) {
+	var rules []Rule
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		alertType, tagSpec, ok := strings.Cut(part, ":")
+		alertType = strings.TrimSpace(alertType)
+		if !ok || alertType == "" {
+			return nil, fmt.Errorf("alert rule %q must be type:key=value", part)
+		}
+
+		tags, err := ParseTags(tagSpec)
+		if err != nil {
+			return nil, fmt.Errorf("alert rule %q: %w", part, err)
+		}
+		if len(tags) == 0 {
+			return nil, fmt.Errorf("alert rule %q has no tags", part)
+		}
+		rules = append(rules, Rule{Type: alertType, Tags: tags})
+	}
+	return rules, nil
+}
+
+// ParseTags parses "key=value,key=value"
+func ParseTags(spec string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("tag %q must be key=value", pair)
+		}
+		tags[k] = v
+	}
+	return tags, nil
+}
+
+// String formats a rule in ParseRules syntax
+func (r Rule) String() string {
+	return r.Type + ":" + formatTags(r.Tags)
+}
+
+// formatTags formats tags in ParseTags syntax, sorted by key
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// matches reports whether a device's tags satisfy the rule
+func (r Rule) matches(tags map[string]string) bool {
+	for k, v := range r.Tags {
+		if tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
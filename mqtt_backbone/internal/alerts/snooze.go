@@ -0,0 +1,202 @@
+package alerts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+)
+
+// ErrSnoozeNotFound is returned by Unsnooze for an unknown or no longer active snooze
+var ErrSnoozeNotFound = errors.New("no active snooze with this ID")
+
+// snoozeSweepInterval is how often expired snoozes are recorded as such
+const snoozeSweepInterval = time.Minute
+
+// SnoozeStore persists snoozes and their audit trail
+type SnoozeStore interface {
+	SaveAlertSnooze(snooze *models.AlertSnooze) error
+	GetAlertSnoozes(status string) ([]*models.AlertSnooze, error)
+	SaveAlertSnoozeAudit(entry *models.AlertSnoozeAudit) error
+}
+
+// EnableSnoozes restores the active snoozes from the store; snoozes that ran out
+// while the backend was down are recorded as expired at their end
+func (m *Manager) EnableSnoozes(store SnoozeStore) error {
+	snoozes, err := store.GetAlertSnoozes(models.SnoozeStatusActive)
+	if err != nil {
+		return fmt.Errorf("failed to load alert snoozes: %w", err)
+	}
+
+	m.mu.Lock()
+	m.snoozeStore = store
+	m.snoozes = make(map[string]*models.AlertSnooze)
+	for _, s := range snoozes {
+		m.snoozes[s.ID] = s
+	}
+	m.mu.Unlock()
+
+	log.Printf("Alerts: Restored %d active snoozes", len(snoozes))
+	m.expireSnoozes(time.Now())
+	return nil
+}
+
+// Snooze silences the alerts a snooze covers until its end. The ID, scope, end,
+// and reason come from the caller; the rest is filled in here.
+func (m *Manager) Snooze(snooze *models.AlertSnooze, actor string) error {
+	m.mu.Lock()
+	store := m.snoozeStore
+	m.mu.Unlock()
+	if store == nil {
+		return fmt.Errorf("alert snoozes not enabled")
+	}
+
+	now := time.Now()
+	snooze.CreatedBy = actor
+	snooze.Status = models.SnoozeStatusActive
+	snooze.CreatedAt, snooze.UpdatedAt = now, now
+	if err := store.SaveAlertSnooze(snooze); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.snoozes[snooze.ID] = snooze
+	m.mu.Unlock()
+
+	m.audit(store, snooze, "created", actor, now)
+	log.Printf("Alerts: %s snoozed %s until %s", actor, snoozeScope(snooze), snooze.Until.Format(time.RFC3339))
+	return nil
+}
+
+// Unsnooze cancels an active snooze and returns it
+func (m *Manager) Unsnooze(id, actor string) (*models.AlertSnooze, error) {
+	m.mu.Lock()
+	store := m.snoozeStore
+	active, ok := m.snoozes[id]
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrSnoozeNotFound
+	}
+
+	now := time.Now()
+	cancelled := *active
+	cancelled.Status, cancelled.UpdatedAt = models.SnoozeStatusCancelled, now
+	if err := store.SaveAlertSnooze(&cancelled); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	delete(m.snoozes, id)
+	m.mu.Unlock()
+
+	m.audit(store, &cancelled, models.SnoozeStatusCancelled, actor, now)
+	log.Printf("Alerts: %s cancelled the snooze of %s", actor, snoozeScope(&cancelled))
+	return &cancelled, nil
+}
+
+// Snoozes returns the active snoozes, ending soonest first
+func (m *Manager) Snoozes() []*models.AlertSnooze {
+	m.mu.Lock()
+	snoozes := make([]*models.AlertSnooze, 0, len(m.snoozes))
+	for _, s := range m.snoozes {
+		copied := *s
+		snoozes = append(snoozes, &copied)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(snoozes, func(i, j int) bool { return snoozes[i].Until.Before(snoozes[j].Until) })
+	return snoozes
+}
+
+// Start records snoozes as expired when they end, until context is cancelled
+func (m *Manager) Start(ctx context.Context) {
+	ticker := time.NewTicker(snoozeSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.expireSnoozes(now)
+		}
+	}
+}
+
+// snoozed reports whether an active snooze covers an alert
+func (m *Manager) snoozed(alert *models.Alert) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.snoozes {
+		if s.Matches(alert) && alert.Timestamp.Before(s.Until) {
+			return true
+		}
+	}
+	return false
+}
+
+// expireSnoozes stores the expiry of snoozes that ended by now. A snooze whose
+// expiry can't be stored stays in memory (without effect) and is retried.
+func (m *Manager) expireSnoozes(now time.Time) {
+	m.mu.Lock()
+	store := m.snoozeStore
+	var ended []*models.AlertSnooze
+	for _, s := range m.snoozes {
+		if !now.Before(s.Until) {
+			ended = append(ended, s)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, s := range ended {
+		expired := *s
+		expired.Status, expired.UpdatedAt = models.SnoozeStatusExpired, now
+		if err := store.SaveAlertSnooze(&expired); err != nil {
+			log.Printf("Alerts: Error expiring snooze %s: %v", s.ID, err)
+			continue
+		}
+
+		m.mu.Lock()
+		delete(m.snoozes, s.ID)
+		m.mu.Unlock()
+
+		m.audit(store, &expired, models.SnoozeStatusExpired, "", s.Until)
+		log.Printf("Alerts: Snooze of %s expired", snoozeScope(s))
+	}
+}
+
+// audit records a change to a snooze; a failure is logged, the change stands
+func (m *Manager) audit(store SnoozeStore, snooze *models.AlertSnooze, action, actor string, at time.Time) {
+	metrics.Default.Counter("alert_snoozes_" + action).Inc()
+	entry := &models.AlertSnoozeAudit{
+		Timestamp: at,
+		SnoozeID:  snooze.ID,
+		Action:    action,
+		DeviceID:  snooze.DeviceID,
+		Type:      snooze.Type,
+		Until:     snooze.Until,
+		Actor:     actor,
+		Reason:    snooze.Reason,
+	}
+	if err := store.SaveAlertSnoozeAudit(entry); err != nil {
+		log.Printf("Alerts: Error saving snooze audit entry: %v", err)
+	}
+}
+
+// snoozeScope describes what a snooze silences, for the log
+func snoozeScope(s *models.AlertSnooze) string {
+	switch {
+	case s.DeviceID != "" && s.Type != "":
+		return fmt.Sprintf("%s alerts of %s", s.Type, s.DeviceID)
+	case s.DeviceID != "":
+		return "all alerts of " + s.DeviceID
+	case s.Type != "":
+		return fmt.Sprintf("%s alerts of all devices", s.Type)
+	}
+	return "all alerts"
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"iot-backend/internal/derived"
+	"iot-backend/internal/models"
+	"iot-backend/pkg/config"
+)
+
+// AdminConfigResponse is returned by GET /admin/config
+type AdminConfigResponse struct {
+	GeneratedAt time.Time `json:"generated_at"`
+
+	// Settings in effect: defaults, the environment, and the instance role applied; secrets redacted
+	Settings *config.Config `json:"settings"`
+
+	// Registry config of every device that has any (sampling interval, thresholds, calibration); secrets redacted
+	DeviceOverrides map[string]map[string]interface{} `json:"device_overrides"`
+
+	// Learned trigger threshold suggestions, when the threshold advisor runs and has analyzed
+	Thresholds *models.ThresholdReport `json:"thresholds,omitempty"`
+
+	// Learned quiet baseline of each device that has sent audio since startup
+	NoiseFloors map[string]derived.NoiseFloor `json:"noise_floors,omitempty"`
+}
+
+// handleAdminConfig returns the configuration this instance is actually running with,
+// so support can check it without access to the host's environment
+func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	if s.Config == nil {
+		writeError(w, http.StatusServiceUnavailable, "configuration not available")
+		return
+	}
+
+	devices, err := s.dbFor(r).ListDevices()
+	if err != nil {
+		log.Printf("API: Error listing devices: %v", err)
+		s.writeQueryError(w, r, err, "failed to list devices")
+		return
+	}
+
+	settings := s.Config.Redacted()
+	response := AdminConfigResponse{
+		GeneratedAt:     time.Now(),
+		Settings:        &settings,
+		DeviceOverrides: make(map[string]map[string]interface{}),
+	}
+	for i := range devices {
+		if len(devices[i].Config) == 0 {
+			continue
+		}
+		redactDevice(&devices[i])
+		response.DeviceOverrides[devices[i].DeviceID] = devices[i].Config
+	}
+	if s.Thresholds != nil {
+		response.Thresholds = s.Thresholds.Report()
+	}
+	if s.NoiseFloors != nil {
+		response.NoiseFloors = s.NoiseFloors.Floors()
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"iot-backend/internal/alerts"
+	"iot-backend/internal/models"
+	"iot-backend/internal/mqtt"
+)
+
+// maxSnoozeHours bounds a snooze, so a forgotten one can't silence a device for good
+const maxSnoozeHours = 30 * 24
+
+// defaultSnoozeActor is recorded as the author of changes that don't name one
+const defaultSnoozeActor = "api"
+
+// SnoozeRequest silences the alerts of a device, of an alert type, or both
+type SnoozeRequest struct {
+	DeviceID string  `json:"device_id,omitempty"`
+	Type     string  `json:"type,omitempty"` // Alert type, e.g. "noise_exposure"
+	Hours    float64 `json:"hours"`          // How long alerts stay silenced (at most 720)
+	Reason   string  `json:"reason,omitempty"`
+	By       string  `json:"by,omitempty"` // Who is snoozing, for the audit trail (default "api")
+}
+
+// actor returns who made a change, defaulting to the API itself
+func actor(by string) string {
+	if by = strings.TrimSpace(by); by != "" {
+		return by
+	}
+	return defaultSnoozeActor
+}
+
+// handleListSnoozes lists alert snoozes, paged and filtered with the shared list parameters
+func (s *Server) handleListSnoozes(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	if s.Alerts == nil {
+		writeError(w, http.StatusServiceUnavailable, "alerts not enabled")
+		return
+	}
+
+	var snoozes []*models.AlertSnooze
+	switch status := r.URL.Query().Get("status"); status {
+	case "", models.SnoozeStatusActive:
+		snoozes = s.Alerts.Snoozes()
+	case models.SnoozeStatusExpired, models.SnoozeStatusCancelled, "all":
+		if status == "all" {
+			status = ""
+		}
+		var err error
+		if snoozes, err = s.dbFor(r).GetAlertSnoozes(status); err != nil {
+			log.Printf("API: Error listing alert snoozes: %v", err)
+			s.writeQueryError(w, r, err, "failed to list snoozes")
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "status must be active, expired, cancelled, or all")
+		return
+	}
+
+	page, ok := listPage(s, w, r, snoozes, listSpec[*models.AlertSnooze]{
+		key:      func(sn *models.AlertSnooze) string { return sn.ID },
+		deviceID: func(sn *models.AlertSnooze) string { return sn.DeviceID },
+		time:     func(sn *models.AlertSnooze) time.Time { return sn.CreatedAt },
+	})
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleCreateSnooze silences alerts of a device, an alert type, or both for a number of hours
+func (s *Server) handleCreateSnooze(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	if s.Alerts == nil {
+		writeError(w, http.StatusServiceUnavailable, "alerts not enabled")
+		return
+	}
+
+	var req SnoozeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	req.DeviceID, req.Type = strings.TrimSpace(req.DeviceID), strings.TrimSpace(req.Type)
+	if req.DeviceID == "" && req.Type == "" {
+		writeError(w, http.StatusBadRequest, "device_id or type is required")
+		return
+	}
+	if req.Hours <= 0 || req.Hours > maxSnoozeHours {
+		writeError(w, http.StatusBadRequest, "hours must be more than 0 and at most 720")
+		return
+	}
+	if req.DeviceID != "" {
+		device, err := s.dbFor(r).GetDevice(req.DeviceID)
+		if err != nil {
+			log.Printf("API: Error getting device %s: %v", req.DeviceID, err)
+			s.writeQueryError(w, r, err, "failed to get device")
+			return
+		}
+		if device == nil {
+			writeError(w, http.StatusNotFound, "device not found")
+			return
+		}
+	}
+
+	snooze := &models.AlertSnooze{
+		ID:       mqtt.NewCorrelationID(),
+		DeviceID: req.DeviceID,
+		Type:     req.Type,
+		Until:    time.Now().Add(time.Duration(req.Hours * float64(time.Hour))),
+		Reason:   req.Reason,
+	}
+	if err := s.Alerts.Snooze(snooze, actor(req.By)); err != nil {
+		log.Printf("API: Error saving alert snooze: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to save snooze")
+		return
+	}
+	writeJSON(w, http.StatusCreated, snooze)
+}
+
+// handleCancelSnooze ends an active snooze early
+func (s *Server) handleCancelSnooze(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	if s.Alerts == nil {
+		writeError(w, http.StatusServiceUnavailable, "alerts not enabled")
+		return
+	}
+
+	snooze, err := s.Alerts.Unsnooze(params["id"], actor(r.URL.Query().Get("by")))
+	if errors.Is(err, alerts.ErrSnoozeNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("API: Error cancelling alert snooze %s: %v", params["id"], err)
+		writeError(w, http.StatusInternalServerError, "failed to cancel snooze")
+		return
+	}
+	writeJSON(w, http.StatusOK, snooze)
+}
+
+// handleSnoozeAudit lists the creations, cancellations, and expiries of snoozes, newest first
+func (s *Server) handleSnoozeAudit(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	entries, err := s.dbFor(r).GetAlertSnoozeAudit(r.URL.Query().Get("snooze"))
+	if err != nil {
+		log.Printf("API: Error reading alert snooze audit: %v", err)
+		s.writeQueryError(w, r, err, "failed to read snooze audit")
+		return
+	}
+
+	page, ok := listPage(s, w, r, entries, listSpec[models.AlertSnoozeAudit]{
+		key:      func(e models.AlertSnoozeAudit) string { return e.SnoozeID + "/" + e.Action },
+		deviceID: func(e models.AlertSnoozeAudit) string { return e.DeviceID },
+		time:     func(e models.AlertSnoozeAudit) time.Time { return e.Timestamp },
+	})
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// handleBuildingSummary returns building-level rollups across every device
+func (s *Server) handleBuildingSummary(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	hours := 1
+	if v := r.URL.Query().Get("hours"); v != "" {
+		var err error
+		hours, err = strconv.Atoi(v)
+		if err != nil || hours <= 0 || hours > 24*31 {
+			writeError(w, http.StatusBadRequest, "hours must be between 1 and 744")
+			return
+		}
+	}
+
+	rooms := 5
+	if v := r.URL.Query().Get("rooms"); v != "" {
+		var err error
+		rooms, err = strconv.Atoi(v)
+		if err != nil || rooms <= 0 || rooms > 100 {
+			writeError(w, http.StatusBadRequest, "rooms must be between 1 and 100")
+			return
+		}
+	}
+
+	unit, ok := temperatureUnit(w, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	// The current hour counts as the first one
+	since := time.Now().Add(-time.Duration(hours-1) * time.Hour)
+	summary, err := s.dbFor(r).GetBuildingSummary(since, rooms)
+	if err != nil {
+		log.Printf("API: Error building summary: %v", err)
+		s.writeQueryError(w, r, err, "failed to summarize building")
+		return
+	}
+	t := &summary.Temperature
+	t.Unit = unit
+	if t.Devices > 0 {
+		t.Average, t.Min, t.Max = renderTemperature(t.Average, unit), renderTemperature(t.Min, unit), renderTemperature(t.Max, unit)
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
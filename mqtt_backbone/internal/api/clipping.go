@@ -0,0 +1,28 @@
+package api
+
+import (
+	"log"
+	"net/http"
+)
+
+// handleDeviceClipping returns how often a device's audio clipped over a time range,
+// to spot microphones whose gain (or AGC target) is set too high
+func (s *Server) handleDeviceClipping(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	query := r.URL.Query()
+	from, to, ok := parseTimeRange(w, query)
+	if !ok {
+		return
+	}
+	resolution, ok := parseResolution(w, query)
+	if !ok {
+		return
+	}
+
+	report, err := s.dbFor(r).GetClippingRates(params["id"], from, to, resolution)
+	if err != nil {
+		log.Printf("API: Error reading clipping rates for %s: %v", params["id"], err)
+		s.writeQueryError(w, r, err, "failed to read clipping rates")
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,311 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+)
+
+// Command channel limits
+const (
+	commandMaxMessageBytes   = 64 * 1024
+	commandStateInterval     = time.Second      // How often subscribed devices are checked for changes
+	commandPingInterval      = 30 * time.Second // Keeps idle connections open through proxies
+	commandDefaultHold       = time.Hour
+	commandMaxHold           = 24 * time.Hour
+	commandMaxSubscribedDevs = 1000
+)
+
+// CommandMessage is sent by a client on the command channel. Types:
+//
+//	subscribe:   receive "state" events for devices ("*" for every device)
+//	unsubscribe: stop receiving them
+//	override:    hold a window at position for hold_minutes (default 60, at most 1440)
+//	release:     withdraw the device's override
+//
+// override and release are answered with a "result" event carrying the same id.
+type CommandMessage struct {
+	Type        string   `json:"type"`
+	ID          string   `json:"id,omitempty"` // Client-chosen, echoed in the result
+	DeviceID    string   `json:"device_id,omitempty"`
+	Devices     []string `json:"devices,omitempty"`
+	Position    *float64 `json:"position,omitempty"` // 0-100%
+	HoldMinutes int      `json:"hold_minutes,omitempty"`
+}
+
+// CommandEvent is sent by the backend on the command channel: "state" when a
+// subscribed device's readings, window position, or safety conditions change,
+// "result" for a command, and "error" for a message that couldn't be handled
+type CommandEvent struct {
+	Type      string              `json:"type"`
+	ID        string              `json:"id,omitempty"`
+	DeviceID  string              `json:"device_id,omitempty"`
+	Timestamp time.Time           `json:"timestamp"`
+	State     *models.DeviceState `json:"state,omitempty"`
+	Position  *float64            `json:"position,omitempty"` // Last commanded window position
+	Safety    []string            `json:"safety,omitempty"`   // Safety conditions holding the window (e.g., "rain")
+	Winner    string              `json:"winner,omitempty"`   // Source whose proposal decided a command's position
+	Summary   string              `json:"summary,omitempty"`  // How the command was arbitrated
+	Error     string              `json:"error,omitempty"`
+}
+
+// commandAuthorized checks the channel's bearer token, from the Authorization header
+// or, for browser clients that can't set headers, the Sec-WebSocket-Protocol entry
+// after "bearer" (new WebSocket(url, ["bearer", token])). It is never taken from the
+// URL, which proxies and access logs record.
+func (s *Server) commandAuthorized(r *http.Request) bool {
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if got == "" {
+		protocols := websocket.Subprotocols(r)
+		for i := 0; i+1 < len(protocols); i++ {
+			if protocols[i] == wsBearerProtocol {
+				got = protocols[i+1]
+				break
+			}
+		}
+	}
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(s.commandToken)) == 1
+}
+
+// handleCommandChannel upgrades to a WebSocket on which a companion app follows
+// device state and sends window overrides, so phones never talk to the broker
+func (s *Server) handleCommandChannel(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	if s.commandToken == "" {
+		writeError(w, http.StatusServiceUnavailable, "command channel not enabled")
+		return
+	}
+	if !s.commandAuthorized(r) {
+		writeError(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+	conn, err := upgradeWebSocket(w, r, commandMaxMessageBytes)
+	if err != nil {
+		log.Printf("API: Command channel from %s not opened: %v", r.RemoteAddr, err)
+		return
+	}
+
+	clients := metrics.Default.Gauge("api_command_clients")
+	clients.Add(1)
+	defer clients.Add(-1)
+	log.Printf("API: Command channel opened by %s", r.RemoteAddr)
+
+	session := &commandSession{server: s, conn: conn, devices: make(map[string]bool), sent: make(map[string]string)}
+	session.run()
+	conn.Close(1001, "")
+	log.Printf("API: Command channel from %s closed", r.RemoteAddr)
+}
+
+// commandSession is one client's command channel
+type commandSession struct {
+	server *Server
+	conn   *wsConn
+
+	all     bool              // Subscribed to every device
+	devices map[string]bool   // Subscribed devices
+	sent    map[string]string // Device → fingerprint of the last state event sent
+}
+
+// run serves the session until the client disconnects or the server shuts down
+func (cs *commandSession) run() {
+	incoming := make(chan []byte)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(incoming)
+		for {
+			opcode, message, err := cs.conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if opcode != opText {
+				continue
+			}
+			select {
+			case incoming <- message:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	stateTicker := time.NewTicker(commandStateInterval)
+	defer stateTicker.Stop()
+	pingTicker := time.NewTicker(commandPingInterval)
+	defer pingTicker.Stop()
+
+	for {
+		select {
+		case <-cs.server.closing:
+			return
+		case message, ok := <-incoming:
+			if !ok {
+				return
+			}
+			if err := cs.handle(message); err != nil {
+				return
+			}
+		case <-stateTicker.C:
+			if err := cs.pushState(); err != nil {
+				return
+			}
+		case <-pingTicker.C:
+			if err := cs.conn.Ping(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// send writes an event; an error means the connection is gone
+func (cs *commandSession) send(event CommandEvent) error {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal command event: %w", err)
+	}
+	return cs.conn.WriteText(data)
+}
+
+// handle processes one client message
+func (cs *commandSession) handle(data []byte) error {
+	var msg CommandMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return cs.send(CommandEvent{Type: "error", Error: "invalid JSON message"})
+	}
+
+	switch msg.Type {
+	case "subscribe":
+		for _, id := range msg.Devices {
+			if id == "*" {
+				cs.all = true
+			} else if len(cs.devices) < commandMaxSubscribedDevs {
+				cs.devices[id] = true
+			}
+		}
+		return cs.pushState()
+	case "unsubscribe":
+		for _, id := range msg.Devices {
+			if id == "*" {
+				cs.all = false
+			}
+			delete(cs.devices, id)
+			delete(cs.sent, id)
+		}
+		return nil
+	case "override", "release":
+		return cs.send(cs.command(msg))
+	default:
+		return cs.send(CommandEvent{Type: "error", ID: msg.ID, Error: fmt.Sprintf("unknown message type %q", msg.Type)})
+	}
+}
+
+// command applies an override or release and returns its result
+func (cs *commandSession) command(msg CommandMessage) CommandEvent {
+	result := CommandEvent{Type: "result", ID: msg.ID, DeviceID: msg.DeviceID}
+	if cs.server.router.readOnly {
+		result.Error = errReadOnly
+		return result
+	}
+	window := cs.server.Window
+	if window == nil {
+		result.Error = "window control service not enabled"
+		return result
+	}
+	if msg.DeviceID == "" {
+		result.Error = "device_id is required"
+		return result
+	}
+	device, err := cs.server.db.GetDevice(msg.DeviceID)
+	if err != nil {
+		log.Printf("API: Error getting device %s: %v", msg.DeviceID, err)
+		result.Error = "failed to get device"
+		return result
+	}
+	if device == nil {
+		result.Error = "device not found"
+		return result
+	}
+
+	if msg.Type == "release" {
+		window.ReleaseOverride(msg.DeviceID)
+		log.Printf("API: Command channel released the override of %s", msg.DeviceID)
+		if position, ok := window.Position(msg.DeviceID); ok {
+			result.Position = &position
+		}
+		return result
+	}
+
+	if msg.Position == nil || math.IsNaN(*msg.Position) || *msg.Position < 0 || *msg.Position > 100 {
+		result.Error = "position must be between 0 and 100"
+		return result
+	}
+	hold := commandDefaultHold
+	if msg.HoldMinutes != 0 {
+		hold = time.Duration(msg.HoldMinutes) * time.Minute
+	}
+	if hold <= 0 || hold > commandMaxHold {
+		result.Error = fmt.Sprintf("hold_minutes must be between 1 and %d", int(commandMaxHold/time.Minute))
+		return result
+	}
+
+	decision := window.ApplyOverride(msg.DeviceID, *msg.Position, hold,
+		fmt.Sprintf("app override for %v", hold))
+	log.Printf("API: Command channel override of %s to %.0f%% for %v: %s", msg.DeviceID, *msg.Position, hold, decision.Summary)
+	result.Timestamp = decision.Timestamp
+	result.Position = &decision.Position
+	result.Winner = string(decision.Winner)
+	result.Summary = decision.Summary
+	return result
+}
+
+// pushState sends a state event for every subscribed device that changed since the last one
+func (cs *commandSession) pushState() error {
+	ids := make([]string, 0, len(cs.devices))
+	if cs.all {
+		ids = cs.server.state.DeviceIDs()
+	} else {
+		for id := range cs.devices {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+
+	for _, id := range ids {
+		event := CommandEvent{Type: "state", DeviceID: id}
+		if state, ok := cs.server.state.Get(id); ok {
+			event.State = &state
+		}
+		if window := cs.server.Window; window != nil {
+			if position, ok := window.Position(id); ok {
+				event.Position = &position
+			}
+			event.Safety = window.ActiveSafety(id)
+			sort.Strings(event.Safety)
+		}
+		if event.State == nil && event.Position == nil {
+			continue // Nothing known about the device yet
+		}
+
+		fingerprint, _ := json.Marshal(event)
+		if cs.sent[id] == string(fingerprint) {
+			continue
+		}
+		cs.sent[id] = string(fingerprint)
+		if err := cs.send(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
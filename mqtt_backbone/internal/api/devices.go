@@ -0,0 +1,193 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"iot-backend/internal/brokeracl"
+	"iot-backend/internal/models"
+	"iot-backend/internal/mqtt"
+	"iot-backend/internal/services"
+)
+
+// handleListDevices returns active devices, paged and filtered with the shared list parameters
+func (s *Server) handleListDevices(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	devices, err := s.dbFor(r).ListActiveDevices()
+	if err != nil {
+		log.Printf("API: Error listing devices: %v", err)
+		s.writeQueryError(w, r, err, "failed to list devices")
+		return
+	}
+	for i := range devices {
+		redactDevice(&devices[i])
+	}
+
+	page, ok := listPage(s, w, r, devices, listSpec[models.Device]{
+		key:      func(d models.Device) string { return d.DeviceID },
+		deviceID: func(d models.Device) string { return d.DeviceID },
+		tags:     func(d models.Device) map[string]string { return d.Tags },
+		time:     func(d models.Device) time.Time { return d.LastSeen },
+	})
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// redactDevice hides secrets in a device's registry config before it is returned
+func redactDevice(device *models.Device) {
+	var config map[string]interface{}
+	for _, secret := range []string{mqtt.PayloadKeyConfig, mqtt.DeviceTokenConfig, brokeracl.PasswordConfig} {
+		if _, ok := device.Config[secret]; !ok {
+			continue
+		}
+		if config == nil {
+			config = make(map[string]interface{}, len(device.Config))
+			for k, v := range device.Config {
+				config[k] = v
+			}
+		}
+		config[secret] = "[redacted]"
+	}
+	if config != nil {
+		device.Config = config
+	}
+}
+
+// handleGetDevice returns a single device
+func (s *Server) handleGetDevice(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	device, err := s.dbFor(r).GetDevice(params["id"])
+	if err != nil {
+		log.Printf("API: Error getting device %s: %v", params["id"], err)
+		s.writeQueryError(w, r, err, "failed to get device")
+		return
+	}
+	if device == nil {
+		writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+	redactDevice(device)
+	writeJSON(w, http.StatusOK, device)
+}
+
+// handleGetDeviceState returns the latest in-memory state of a device
+func (s *Server) handleGetDeviceState(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	unit, ok := temperatureUnit(w, r.URL.Query())
+	if !ok {
+		return
+	}
+	st, ok := s.state.Get(params["id"])
+	if !ok {
+		writeError(w, http.StatusNotFound, "no state for device")
+		return
+	}
+	st.LastTemperature = renderTemperature(st.LastTemperature, unit)
+	writeJSON(w, http.StatusOK, st)
+}
+
+// handleGetOccupancy returns the live occupancy estimate for a device
+func (s *Server) handleGetOccupancy(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	if s.Occupancy == nil {
+		writeError(w, http.StatusServiceUnavailable, "occupancy estimation not enabled")
+		return
+	}
+	occ, ok := s.Occupancy.Get(params["id"])
+	if !ok {
+		writeError(w, http.StatusNotFound, "no occupancy evidence for device")
+		return
+	}
+	writeJSON(w, http.StatusOK, occ)
+}
+
+// handleGetDeviceStats returns live message statistics for a device, optionally with stored history
+func (s *Server) handleGetDeviceStats(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	if s.DeviceStats == nil {
+		writeError(w, http.StatusServiceUnavailable, "message statistics not enabled")
+		return
+	}
+
+	hours := 0
+	if v := r.URL.Query().Get("hours"); v != "" {
+		var err error
+		hours, err = strconv.Atoi(v)
+		if err != nil || hours < 0 || hours > 24*31 {
+			writeError(w, http.StatusBadRequest, "hours must be between 0 and 744")
+			return
+		}
+	}
+
+	stats, ok := s.DeviceStats.Get(params["id"])
+	if hours > 0 {
+		history, err := s.dbFor(r).GetDeviceStatsHistory(params["id"], time.Now().Add(-time.Duration(hours)*time.Hour))
+		if err != nil {
+			log.Printf("API: Error getting stats history for %s: %v", params["id"], err)
+			s.writeQueryError(w, r, err, "failed to get stats history")
+			return
+		}
+		stats.DeviceID, stats.History = params["id"], history
+		ok = ok || len(history) > 0
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "no messages from device")
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// handleGetActuationBudget returns a window's daily movement budget and how much of it is used
+func (s *Server) handleGetActuationBudget(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	if s.Window == nil {
+		writeError(w, http.StatusServiceUnavailable, "window control not enabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Window.ActuationBudget(params["id"]))
+}
+
+// handleTriggerInference forces an inference for a device so installers can validate the loop on site
+func (s *Server) handleTriggerInference(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	if s.Inference == nil {
+		writeError(w, http.StatusServiceUnavailable, "inference service not enabled")
+		return
+	}
+
+	force := false
+	if v := r.URL.Query().Get("force"); v != "" {
+		var err error
+		if force, err = strconv.ParseBool(v); err != nil {
+			writeError(w, http.StatusBadRequest, "force must be true or false")
+			return
+		}
+	}
+
+	device, err := s.dbFor(r).GetDevice(params["id"])
+	if err != nil {
+		log.Printf("API: Error getting device %s: %v", params["id"], err)
+		s.writeQueryError(w, r, err, "failed to get device")
+		return
+	}
+	if device == nil {
+		writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+
+	request, err := s.Inference.TriggerManual(device.DeviceID, force)
+	var cooldown *services.CooldownError
+	switch {
+	case errors.As(err, &cooldown):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(cooldown.Remaining.Seconds()))))
+		writeError(w, http.StatusTooManyRequests, err.Error()+" (or pass force=true)")
+	case errors.Is(err, services.ErrNoCurrentData):
+		writeError(w, http.StatusConflict, "no recent readings for device")
+	case errors.Is(err, services.ErrInferenceQueueFull):
+		writeError(w, http.StatusServiceUnavailable, "inference queue full")
+	case err != nil:
+		log.Printf("API: Error triggering inference for %s: %v", device.DeviceID, err)
+		writeError(w, http.StatusInternalServerError, "failed to trigger inference")
+	default:
+		writeJSON(w, http.StatusAccepted, request)
+	}
+}
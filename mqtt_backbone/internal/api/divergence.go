@@ -0,0 +1,27 @@
+package api
+
+import (
+	"log"
+	"net/http"
+)
+
+// handleDeviceDivergence returns how far a device's hourly means were from the other
+// devices in its room
+func (s *Server) handleDeviceDivergence(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	query := r.URL.Query()
+	from, to, ok := parseTimeRange(w, query)
+	if !ok {
+		return
+	}
+	if query.Get("from") == "" {
+		from = to.AddDate(0, 0, -7)
+	}
+
+	divergences, err := s.dbFor(r).GetSensorDivergence(params["id"], from, to)
+	if err != nil {
+		log.Printf("API: Error reading sensor divergence for %s: %v", params["id"], err)
+		s.writeQueryError(w, r, err, "failed to read sensor divergence")
+		return
+	}
+	writeJSON(w, http.StatusOK, divergences)
+}
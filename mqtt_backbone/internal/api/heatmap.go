@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"iot-backend/internal/database"
+	"iot-backend/internal/services"
+)
+
+// handleDeviceHeatmap returns a device's metric as a day of week × hour of day matrix,
+// computed from the hourly rollups so clients don't pull raw readings to draw it
+func (s *Server) handleDeviceHeatmap(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	query := r.URL.Query()
+	metric := query.Get("metric")
+	if metric == "" {
+		metric = "sound_volume"
+	}
+	known := false
+	for _, m := range database.SeriesMetrics() {
+		known = known || m == metric
+	}
+	if !known {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("metric must be one of %s", strings.Join(database.SeriesMetrics(), ", ")))
+		return
+	}
+
+	from, to, ok := parseTimeRange(w, query)
+	if !ok {
+		return
+	}
+	if query.Get("from") == "" {
+		from = to.AddDate(0, 0, -28)
+	}
+	loc := time.Local
+	if v := query.Get("tz"); v != "" {
+		var err error
+		if loc, err = time.LoadLocation(v); err != nil {
+			writeError(w, http.StatusBadRequest, "tz must be an IANA time zone, e.g. Europe/Berlin")
+			return
+		}
+	}
+	unit, ok := temperatureUnit(w, query)
+	if !ok {
+		return
+	}
+
+	hours, err := s.dbFor(r).GetDeviceHourlyMeans(params["id"], metric, from, to)
+	if err != nil {
+		log.Printf("API: Error reading hourly %s for %s: %v", metric, params["id"], err)
+		s.writeQueryError(w, r, err, "failed to read hourly "+metric)
+		return
+	}
+	if metric == "temperature" {
+		for i := range hours {
+			hours[i].Value = renderTemperature(hours[i].Value, unit)
+		}
+	}
+
+	heatmap := services.BuildHeatmap(params["id"], metric, from, to, loc, hours)
+	if metric == "temperature" {
+		heatmap.Unit = unit
+	}
+	writeJSON(w, http.StatusOK, heatmap)
+}
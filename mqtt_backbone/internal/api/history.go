@@ -0,0 +1,193 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"iot-backend/internal/database"
+	"iot-backend/internal/models"
+	"iot-backend/internal/mqtt"
+)
+
+// metricStreams lists the subscriptions each metric arrives on
+var metricStreams = map[string][]string{
+	"temperature":  {mqtt.StreamTemperature, mqtt.StreamFrame, mqtt.StreamLoRaWAN},
+	"humidity":     {mqtt.StreamHumidity, mqtt.StreamFrame, mqtt.StreamLoRaWAN},
+	"sound_volume": {mqtt.StreamAudio, mqtt.StreamAudioChunk},
+}
+
+// aggregateStreams lists the subscriptions of every aggregated metric
+func aggregateStreams() []string {
+	seen := make(map[string]bool)
+	var streams []string
+	for _, metric := range []string{"temperature", "humidity", "sound_volume"} {
+		for _, stream := range metricStreams[metric] {
+			if !seen[stream] {
+				seen[stream] = true
+				streams = append(streams, stream)
+			}
+		}
+	}
+	return streams
+}
+
+// connectionGaps returns the connection gaps on the given streams overlapping a range.
+// The annotation is best effort: without it the response is still correct, so errors
+// are only logged.
+func (s *Server) connectionGaps(r *http.Request, from, to time.Time, streams ...string) []models.ConnectionGap {
+	gaps, err := s.dbFor(r).GetConnectionGaps(from, to, streams...)
+	if err != nil {
+		log.Printf("API: Error reading connection gaps: %v", err)
+		return nil
+	}
+	return gaps
+}
+
+// handleDeviceHistory returns one metric of a device bucketed over a time range. Long
+// ranges are served from the hourly rollups (see database.PlanSeries). Connection gaps
+// overlapping the range are listed so charts can tell lost readings from quiet devices.
+func (s *Server) handleDeviceHistory(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	query := r.URL.Query()
+
+	metric := query.Get("metric")
+	if metric == "" {
+		metric = "temperature"
+	}
+	known := false
+	for _, m := range database.SeriesMetrics() {
+		known = known || m == metric
+	}
+	if !known {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("metric must be one of %s", strings.Join(database.SeriesMetrics(), ", ")))
+		return
+	}
+
+	from, to, ok := parseTimeRange(w, query)
+	if !ok {
+		return
+	}
+
+	resolution, ok := parseResolution(w, query)
+	if !ok {
+		return
+	}
+	unit, ok := temperatureUnit(w, query)
+	if !ok {
+		return
+	}
+
+	series, ok := s.hotSeries(params["id"], metric, from, to, resolution)
+	if !ok {
+		var err error
+		series, err = s.dbFor(r).GetSeries(params["id"], metric, from, to, resolution)
+		if err != nil {
+			log.Printf("API: Error reading %s history for %s: %v", metric, params["id"], err)
+			s.writeQueryError(w, r, err, "failed to read history")
+			return
+		}
+	}
+	if metric == "temperature" {
+		series.Unit = unit
+		for i := range series.Points {
+			series.Points[i].Value = renderTemperature(series.Points[i].Value, unit)
+		}
+	}
+	series.Gaps = s.connectionGaps(r, from, to, metricStreams[metric]...)
+	writeJSON(w, http.StatusOK, series)
+}
+
+// hotSeries buckets a recent range from the hot store, the way GetSeries buckets raw
+// readings. It returns false when the store doesn't hold the whole range.
+func (s *Server) hotSeries(deviceID, metric string, from, to time.Time, resolution time.Duration) (*models.Series, bool) {
+	if s.Hot == nil || !s.Hot.Covers(deviceID, metric, from) {
+		return nil, false
+	}
+	source, step := database.PlanSeries(from, to, resolution)
+	if source != database.SeriesSourceRaw {
+		return nil, false
+	}
+
+	series := &models.Series{
+		DeviceID:   deviceID,
+		Metric:     metric,
+		From:       from,
+		To:         to,
+		Source:     database.SeriesSourceMemory,
+		Resolution: int(step / time.Second),
+		Points:     []models.SeriesPoint{},
+	}
+	for _, b := range s.Hot.Buckets(deviceID, metric, from, to, step) {
+		series.Points = append(series.Points, models.SeriesPoint{Timestamp: b.Start, Value: b.Mean})
+	}
+	return series, true
+}
+
+// parseTimeRange reads the "from" and "to" query parameters (RFC 3339), defaulting to
+// the 24 hours before now. It writes the error response and returns ok=false when
+// they are invalid.
+func parseTimeRange(w http.ResponseWriter, query url.Values) (from, to time.Time, ok bool) {
+	to = time.Now()
+	if v := query.Get("to"); v != "" {
+		var err error
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, "to must be an RFC 3339 timestamp")
+			return from, to, false
+		}
+	}
+	from = to.Add(-24 * time.Hour)
+	if v := query.Get("from"); v != "" {
+		var err error
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, "from must be an RFC 3339 timestamp")
+			return from, to, false
+		}
+	}
+	if !from.Before(to) {
+		writeError(w, http.StatusBadRequest, "from must be before to")
+		return from, to, false
+	}
+	return from, to, true
+}
+
+// parseResolution reads the "resolution" query parameter in seconds (0 = automatic)
+func parseResolution(w http.ResponseWriter, query url.Values) (time.Duration, bool) {
+	v := query.Get("resolution")
+	if v == "" {
+		return 0, true
+	}
+	seconds, err := strconv.Atoi(v)
+	if err != nil || seconds < 0 {
+		writeError(w, http.StatusBadRequest, "resolution must be a non-negative number of seconds")
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// handleDeviceTimeline returns everything recorded for a device over a time range as
+// one time-ordered event stream, for reconstructing incidents, with the connection
+// gaps during which its messages may have been lost
+func (s *Server) handleDeviceTimeline(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	query := r.URL.Query()
+	from, to, ok := parseTimeRange(w, query)
+	if !ok {
+		return
+	}
+	resolution, ok := parseResolution(w, query)
+	if !ok {
+		return
+	}
+
+	timeline, err := s.dbFor(r).GetTimeline(params["id"], from, to, resolution)
+	if err != nil {
+		log.Printf("API: Error reading timeline for %s: %v", params["id"], err)
+		s.writeQueryError(w, r, err, "failed to read timeline")
+		return
+	}
+	timeline.Gaps = s.connectionGaps(r, from, to)
+	writeJSON(w, http.StatusOK, timeline)
+}
@@ -0,0 +1,333 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"iot-backend/internal/database"
+)
+
+// List paging limits. Every list endpoint is paged, so a large fleet can't produce
+// a response of megabytes unless a client asks for it page by page.
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// Response headers of paged lists; the body stays a plain JSON array (or object) so
+// clients that ignore paging keep working
+const (
+	headerTotalCount = "X-Total-Count" // Items matching the filters, across all pages
+	headerNextCursor = "X-Next-Cursor" // Cursor of the next page (absent on the last page)
+)
+
+// listSpec describes the items of a list endpoint to the shared list layer. Items
+// are passed in their final order; key must be unique and stable across requests.
+type listSpec[T any] struct {
+	key      func(T) string            // Identifies an item in cursors
+	deviceID func(T) string            // Device of an item (nil: no device or tag filters)
+	tags     func(T) map[string]string // Tags of an item (nil: tag filters resolve devices in the registry)
+	time     func(T) time.Time         // Time the from/to range applies to (nil: no time range)
+}
+
+// listQuery holds the parsed shared list parameters
+type listQuery struct {
+	limit   int
+	offset  int
+	cursor  *listCursor
+	fields  []string
+	devices map[string]bool
+	tags    map[string]string
+	from    time.Time // Zero is unbounded
+	to      time.Time // Zero is unbounded
+}
+
+// listCursor resumes a list after the item with key; offset is where that item was,
+// which is used if the item has since disappeared
+type listCursor struct {
+	Key    string `json:"k"`
+	Offset int    `json:"o"`
+}
+
+// encode returns the opaque cursor string
+func (c listCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor returned by a previous page
+func decodeCursor(v string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Offset < 0 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &c, nil
+}
+
+// lists documents the shared list parameters a route supports
+func (r *Route) lists(devices, times bool) *Route {
+	r.query("limit", fmt.Sprintf("Items per page (default %d, at most %d); the total is in the %s header", defaultListLimit, maxListLimit, headerTotalCount)).
+		query("offset", "Items to skip (default 0)").
+		query("cursor", fmt.Sprintf("Continue after the previous page, from its %s header (instead of offset)", headerNextCursor)).
+		query("fields", "Comma-separated JSON fields to return per item (default all)")
+	if devices {
+		r.query("device", "Only items of these devices (repeatable or comma-separated)").
+			query("tag", "Only items of devices with this tag, as key=value (repeatable, all must match)")
+	}
+	if times {
+		r.query("from", "Only items at or after this time, RFC 3339").
+			query("to", "Only items before this time, RFC 3339")
+	}
+	return r
+}
+
+// parseListQuery reads the shared list parameters, rejecting filters the list
+// doesn't support and fields its items don't have
+func parseListQuery[T any](query url.Values, spec listSpec[T]) (*listQuery, error) {
+	q := &listQuery{limit: defaultListLimit}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		q.limit = limit
+	}
+	if v := query.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("offset must be a non-negative number")
+		}
+		q.offset = offset
+	}
+	if v := query.Get("cursor"); v != "" {
+		if query.Get("offset") != "" {
+			return nil, fmt.Errorf("use either offset or cursor, not both")
+		}
+		cursor, err := decodeCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		q.cursor = cursor
+	}
+
+	if v := query.Get("fields"); v != "" {
+		var zero T
+		known := jsonFields(reflect.TypeOf(zero))
+		for _, field := range strings.Split(v, ",") {
+			if field = strings.TrimSpace(field); field == "" {
+				continue
+			}
+			if !known[field] {
+				return nil, fmt.Errorf("unknown field %q (want one of %s)", field, strings.Join(sortedFields(known), ", "))
+			}
+			q.fields = append(q.fields, field)
+		}
+	}
+
+	for _, v := range query["device"] {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				if q.devices == nil {
+					q.devices = make(map[string]bool)
+				}
+				q.devices[id] = true
+			}
+		}
+	}
+	for _, pair := range query["tag"] {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("tag %q must be key=value", pair)
+		}
+		if q.tags == nil {
+			q.tags = make(map[string]string)
+		}
+		q.tags[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	if (q.devices != nil || q.tags != nil) && spec.deviceID == nil && spec.tags == nil {
+		return nil, fmt.Errorf("this list can't be filtered by device or tag")
+	}
+
+	for _, bound := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &q.from}, {"to", &q.to}} {
+		v := query.Get(bound.name)
+		if v == "" {
+			continue
+		}
+		if spec.time == nil {
+			return nil, fmt.Errorf("this list can't be filtered by time")
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC 3339 timestamp", bound.name)
+		}
+		*bound.dst = t
+	}
+	if !q.from.IsZero() && !q.to.IsZero() && !q.from.Before(q.to) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+	return q, nil
+}
+
+// listPage filters items with the shared list parameters and returns the requested
+// page: the items themselves, or with fields, each item reduced to those fields.
+// It sets the paging headers, and writes the error response and returns ok=false
+// when the parameters are invalid.
+func listPage[T any](s *Server, w http.ResponseWriter, r *http.Request, items []T, spec listSpec[T]) (interface{}, bool) {
+	q, err := parseListQuery(r.URL.Query(), spec)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	// Without tags of their own, items match the devices carrying the tags
+	tagged := map[string]bool(nil)
+	if q.tags != nil && spec.tags == nil {
+		devices, err := s.dbFor(r).ListDevicesByTags(q.tags)
+		if err != nil {
+			log.Printf("API: Error listing devices by tags: %v", err)
+			s.writeQueryError(w, r, err, "failed to list devices")
+			return nil, false
+		}
+		tagged = make(map[string]bool, len(devices))
+		for _, d := range devices {
+			tagged[d.DeviceID] = true
+		}
+	}
+
+	matched := make([]T, 0, len(items))
+	for _, item := range items {
+		if q.devices != nil && !q.devices[spec.deviceID(item)] {
+			continue
+		}
+		if q.tags != nil {
+			if spec.tags != nil && !database.HasTags(spec.tags(item), q.tags) {
+				continue
+			}
+			if tagged != nil && !tagged[spec.deviceID(item)] {
+				continue
+			}
+		}
+		if spec.time != nil {
+			t := spec.time(item)
+			if (!q.from.IsZero() && t.Before(q.from)) || (!q.to.IsZero() && !t.Before(q.to)) {
+				continue
+			}
+		}
+		matched = append(matched, item)
+	}
+
+	start := q.offset
+	if q.cursor != nil {
+		start = q.cursor.Offset
+		for i, item := range matched {
+			if spec.key(item) == q.cursor.Key {
+				start = i + 1
+				break
+			}
+		}
+	}
+	if start > len(matched) {
+		start = len(matched)
+	}
+	end := start + q.limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	page := matched[start:end]
+
+	w.Header().Set(headerTotalCount, strconv.Itoa(len(matched)))
+	if end < len(matched) {
+		w.Header().Set(headerNextCursor, listCursor{Key: spec.key(page[len(page)-1]), Offset: end}.encode())
+	}
+
+	if len(q.fields) == 0 {
+		return page, true
+	}
+	projected, err := projectFields(page, q.fields)
+	if err != nil {
+		log.Printf("API: Error selecting fields: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to select fields")
+		return nil, false
+	}
+	return projected, true
+}
+
+// projectFields reduces each item to the given JSON fields
+func projectFields[T any](items []T, fields []string) ([]map[string]json.RawMessage, error) {
+	projected := make([]map[string]json.RawMessage, 0, len(items))
+	for _, item := range items {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(data, &all); err != nil {
+			return nil, err
+		}
+		kept := make(map[string]json.RawMessage, len(fields))
+		for _, f := range fields {
+			if v, ok := all[f]; ok {
+				kept[f] = v
+			}
+		}
+		projected = append(projected, kept)
+	}
+	return projected, nil
+}
+
+// jsonFields returns the JSON field names of a struct type, including those of
+// embedded structs
+func jsonFields(t reflect.Type) map[string]bool {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	fields := make(map[string]bool)
+	if t == nil || t.Kind() != reflect.Struct {
+		return fields
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			for embedded := range jsonFields(f.Type) {
+				fields[embedded] = true
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = true
+	}
+	return fields
+}
+
+// sortedFields returns field names in sorted order
+func sortedFields(fields map[string]bool) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
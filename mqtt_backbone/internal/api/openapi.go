@@ -0,0 +1,230 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// OpenAPI 3 document types (only the subset the router can describe)
+type (
+	openAPISpec struct {
+		OpenAPI    string                        `json:"openapi"`
+		Info       openAPIInfo                   `json:"info"`
+		Paths      map[string]map[string]*opSpec `json:"paths"`
+		Components map[string]map[string]*schema `json:"components"`
+	}
+
+	openAPIInfo struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	}
+
+	opSpec struct {
+		Summary     string               `json:"summary"`
+		OperationID string               `json:"operationId"`
+		Parameters  []paramSpec          `json:"parameters,omitempty"`
+		RequestBody *bodySpec            `json:"requestBody,omitempty"`
+		Responses   map[string]*bodySpec `json:"responses"`
+	}
+
+	paramSpec struct {
+		Name        string  `json:"name"`
+		In          string  `json:"in"`
+		Required    bool    `json:"required"`
+		Description string  `json:"description,omitempty"`
+		Schema      *schema `json:"schema"`
+	}
+
+	bodySpec struct {
+		Description string               `json:"description,omitempty"`
+		Required    bool                 `json:"required,omitempty"`
+		Content     map[string]mediaSpec `json:"content,omitempty"`
+	}
+
+	mediaSpec struct {
+		Schema *schema `json:"schema"`
+	}
+
+	schema struct {
+		Ref                  string             `json:"$ref,omitempty"`
+		Type                 string             `json:"type,omitempty"`
+		Format               string             `json:"format,omitempty"`
+		Items                *schema            `json:"items,omitempty"`
+		Properties           map[string]*schema `json:"properties,omitempty"`
+		AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+	}
+)
+
+// consoleHTML is the self-contained interactive console served at /docs
+//
+//go:embed console.html
+var consoleHTML []byte
+
+// buildOpenAPISpec describes the registered routes as an OpenAPI 3 document
+func buildOpenAPISpec(routes []*Route) *openAPISpec {
+	gen := &schemaGenerator{components: make(map[string]*schema)}
+	spec := &openAPISpec{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: "IoT Backend API", Version: "2.0"},
+		Paths:   make(map[string]map[string]*opSpec),
+	}
+
+	errorBody := &bodySpec{
+		Description: "Error",
+		Content:     map[string]mediaSpec{"application/json": {Schema: gen.schemaFor(reflect.TypeOf(ErrorResponse{}))}},
+	}
+
+	for _, route := range routes {
+		op := &opSpec{
+			Summary:     route.Summary,
+			OperationID: operationID(route),
+			Responses:   map[string]*bodySpec{"default": errorBody},
+		}
+
+		for _, part := range strings.Split(strings.Trim(route.Pattern, "/"), "/") {
+			if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+				op.Parameters = append(op.Parameters, paramSpec{
+					Name: part[1 : len(part)-1], In: "path", Required: true, Schema: &schema{Type: "string"},
+				})
+			}
+		}
+		for _, q := range route.Query {
+			op.Parameters = append(op.Parameters, paramSpec{
+				Name: q.Name, In: "query", Description: q.Description, Schema: &schema{Type: "string"},
+			})
+		}
+
+		if route.Request != nil {
+			op.RequestBody = &bodySpec{
+				Required: true,
+				Content:  map[string]mediaSpec{"application/json": {Schema: gen.schemaFor(reflect.TypeOf(route.Request))}},
+			}
+		}
+
+		ok := &bodySpec{Description: "OK"}
+		if route.Response != nil {
+			ok.Content = map[string]mediaSpec{"application/json": {Schema: gen.schemaFor(reflect.TypeOf(route.Response))}}
+		}
+		op.Responses["200"] = ok
+
+		if spec.Paths[route.Pattern] == nil {
+			spec.Paths[route.Pattern] = make(map[string]*opSpec)
+		}
+		spec.Paths[route.Pattern][strings.ToLower(route.Method)] = op
+	}
+
+	spec.Components = map[string]map[string]*schema{"schemas": gen.components}
+	return spec
+}
+
+// operationID derives a stable operation name, e.g. "get_devices_id_state"
+func operationID(route *Route) string {
+	id := strings.ToLower(route.Method)
+	for _, part := range strings.Split(strings.Trim(route.Pattern, "/"), "/") {
+		part = strings.Trim(part, "{}")
+		part = strings.NewReplacer(".", "_", "-", "_").Replace(part)
+		if part != "" {
+			id += "_" + part
+		}
+	}
+	return id
+}
+
+// schemaGenerator maps Go types to JSON schemas, registering named structs as components
+type schemaGenerator struct {
+	components map[string]*schema
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns the schema for a Go type following encoding/json rules
+func (g *schemaGenerator) schemaFor(t reflect.Type) *schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return &schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &schema{Type: "string", Format: "byte"}
+		}
+		return &schema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		return g.structSchema(t)
+	default:
+		// interface{} and anything else: any value
+		return &schema{}
+	}
+}
+
+// structSchema registers a struct as a component and returns a reference to it
+func (g *schemaGenerator) structSchema(t reflect.Type) *schema {
+	name := t.Name()
+	if name == "" {
+		return g.objectSchema(t)
+	}
+	if _, ok := g.components[name]; !ok {
+		g.components[name] = &schema{} // Placeholder stops recursion on self-referencing types
+		*g.components[name] = *g.objectSchema(t)
+	}
+	return &schema{Ref: "#/components/schemas/" + name}
+}
+
+// objectSchema lists a struct's JSON properties
+func (g *schemaGenerator) objectSchema(t reflect.Type) *schema {
+	s := &schema{Type: "object", Properties: make(map[string]*schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			// Embedded structs are flattened by encoding/json
+			for k, v := range g.objectSchema(field.Type).Properties {
+				s.Properties[k] = v
+			}
+			continue
+		}
+		name := field.Name
+		if tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+		s.Properties[name] = g.schemaFor(field.Type)
+	}
+	return s
+}
+
+// handleOpenAPI serves the OpenAPI specification generated from the route table
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	writeJSON(w, http.StatusOK, buildOpenAPISpec(s.router.routes))
+}
+
+// handleDocs serves the interactive API console
+func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(consoleHTML)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"iot-backend/internal/predict"
+)
+
+// PredictRequest describes the conditions to predict a window position for.
+// Omitted readings are taken from the device's latest state when device_id is set.
+type PredictRequest struct {
+	DeviceID    string   `json:"device_id,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	Humidity    *float64 `json:"humidity,omitempty"`
+	SoundVolume *float64 `json:"sound_volume,omitempty"`
+	MoldRisk    *float64 `json:"mold_risk,omitempty"`
+	Occupancy   *float64 `json:"occupancy,omitempty"`
+
+	// Outdoor temperature (°C) and time the model variant is selected for; default the
+	// device's latest outdoor temperature and now
+	OutdoorTemperature *float64   `json:"outdoor_temperature,omitempty"`
+	At                 *time.Time `json:"at,omitempty"`
+}
+
+// PredictResponse is returned by POST /predict/dry-run
+type PredictResponse struct {
+	DeviceID     string             `json:"device_id,omitempty"`
+	ModelVersion string             `json:"model_version"`
+	ModelVariant string             `json:"model_variant"`   // Active variant, "default" for the base model
+	Features     map[string]float64 `json:"features"`        // Inputs the prediction used
+	Scaled       map[string]float64 `json:"scaled_features"` // Inputs after the model's scaler, as sent to the ML service
+	predict.Prediction
+	Executed bool `json:"executed"` // Always false: dry runs never actuate
+}
+
+// handlePredictDryRun evaluates the local model without publishing a command
+func (s *Server) handlePredictDryRun(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	if s.Models == nil {
+		writeError(w, http.StatusServiceUnavailable, "no local model loaded")
+		return
+	}
+
+	var req PredictRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	at := time.Now()
+	if req.At != nil {
+		at = *req.At
+	}
+	outdoor, known := 0.0, false
+	if req.OutdoorTemperature != nil {
+		outdoor, known = *req.OutdoorTemperature, true
+	} else if req.DeviceID != "" && s.Outdoor != nil {
+		outdoor, known = s.Outdoor.Get(req.DeviceID, time.Now())
+	}
+	model, variant := s.Models.Select(at, outdoor, known)
+	if model == nil {
+		writeError(w, http.StatusServiceUnavailable, "no local model for these conditions")
+		return
+	}
+
+	features := make(map[string]float64)
+	if req.DeviceID != "" {
+		st, ok := s.state.Get(req.DeviceID)
+		if !ok {
+			writeError(w, http.StatusNotFound, "no state for device")
+			return
+		}
+		features["temperature"] = st.LastTemperature
+		features["humidity"] = st.LastHumidity
+		features["sound_volume"] = st.LastSoundVolume
+		if s.MoldRisk != nil {
+			if risk, ok := s.MoldRisk.Get(req.DeviceID); ok {
+				features["mold_risk"] = risk.RiskIndex
+			}
+		}
+		if s.Occupancy != nil {
+			if occ, ok := s.Occupancy.Get(req.DeviceID); ok {
+				features["occupancy"] = occ.Probability
+			}
+		}
+	}
+
+	for name, value := range map[string]*float64{
+		"temperature":  req.Temperature,
+		"humidity":     req.Humidity,
+		"sound_volume": req.SoundVolume,
+		"mold_risk":    req.MoldRisk,
+		"occupancy":    req.Occupancy,
+	} {
+		if value != nil {
+			features[name] = *value
+		}
+	}
+
+	writeJSON(w, http.StatusOK, PredictResponse{
+		DeviceID:     req.DeviceID,
+		ModelVersion: model.Version,
+		ModelVariant: variant,
+		Features:     features,
+		Scaled:       predict.ScaleFeatures(model.Scaler(), features),
+		Prediction:   model.Predict(features),
+	})
+}
+
+// handleGetFeatureSnapshot returns the exact feature payload sent with an inference request
+func (s *Server) handleGetFeatureSnapshot(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	snapshot, err := s.dbFor(r).GetFeatureSnapshot(params["correlation_id"])
+	if err != nil {
+		log.Printf("API: Error getting feature snapshot %s: %v", params["correlation_id"], err)
+		s.writeQueryError(w, r, err, "failed to get feature snapshot")
+		return
+	}
+	if snapshot == nil {
+		writeError(w, http.StatusNotFound, "no feature snapshot for correlation ID")
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"iot-backend/internal/database"
+	"iot-backend/internal/metrics"
+)
+
+// dbFor returns the database handle for a request's queries: they stay within the
+// query limits and are cancelled when the client goes away or the server shuts down
+func (s *Server) dbFor(r *http.Request) *database.ClickHouseDB {
+	return s.db.WithQueryLimits(r.Context(), s.queryLimits)
+}
+
+// writeQueryError answers a request whose query failed. A query stopped by the
+// limits is the client's to narrow, so it gets a 422 saying so rather than a 500;
+// a cancelled request has no one left to answer.
+func (s *Server) writeQueryError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	switch {
+	case r.Context().Err() != nil && errors.Is(err, context.Canceled):
+		metrics.Default.Counter("api_queries_cancelled").Inc()
+	case database.IsQueryLimit(err):
+		metrics.Default.Counter("api_query_limit_exceeded").Inc()
+		writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("%s: the query exceeded the API limits (%s); narrow the range or coarsen the resolution",
+			message, describeQueryLimits(s.queryLimits)))
+	default:
+		writeError(w, http.StatusInternalServerError, message)
+	}
+}
+
+// describeQueryLimits lists the limits for error messages
+func describeQueryLimits(limits database.QueryLimits) string {
+	description := ""
+	add := func(part string) {
+		if description != "" {
+			description += ", "
+		}
+		description += part
+	}
+	if limits.Timeout > 0 {
+		add(fmt.Sprintf("%s per query", limits.Timeout))
+	}
+	if limits.MaxRowsToRead > 0 {
+		add(fmt.Sprintf("%d rows scanned", limits.MaxRowsToRead))
+	}
+	if limits.MaxResultRows > 0 {
+		add(fmt.Sprintf("%d rows returned", limits.MaxResultRows))
+	}
+	if description == "" {
+		return "none configured"
+	}
+	return description
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"iot-backend/internal/errs"
+	"iot-backend/internal/ingest"
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/mqtt"
+)
+
+// maxReadingsBodyBytes bounds the body of an ingestion request
+const maxReadingsBodyBytes = 1 << 20
+
+// ReadingsRequest is a batch of readings posted by a device or bridge
+type ReadingsRequest struct {
+	Readings []PostedReading `json:"readings"`
+}
+
+// PostedReading is a reading with the registry token of its device, which it
+// must carry when the device has one (or tokens are required)
+type PostedReading struct {
+	ingest.Reading
+	DeviceToken string `json:"device_token,omitempty"`
+}
+
+// ReadingsResponse reports which readings of a batch were accepted
+type ReadingsResponse struct {
+	Accepted int                `json:"accepted"`
+	Rejected []ReadingRejection `json:"rejected,omitempty"`
+}
+
+// ReadingRejection explains why one reading of a batch was refused
+type ReadingRejection struct {
+	Index  int    `json:"index"` // Position in the request's readings
+	Reason string `json:"reason"`
+}
+
+// HTTPIngestor accepts readings posted to POST /readings, for devices and bridges
+// that can't speak MQTT. Readings are verified with the device tokens the MQTT
+// subscriber checks; safety events, which close windows, are only taken from
+// senders whose token proved them to be the device.
+type HTTPIngestor struct {
+	auth *mqtt.DeviceAuthenticator
+
+	mu       sync.Mutex
+	pipeline ingest.Pipeline
+}
+
+// NewHTTPIngestor creates an HTTP ingestor verifying device tokens with auth (nil
+// takes no safety events); it refuses readings until started
+func NewHTTPIngestor(auth *mqtt.DeviceAuthenticator) *HTTPIngestor {
+	return &HTTPIngestor{auth: auth}
+}
+
+// Name identifies the ingestor
+func (h *HTTPIngestor) Name() string {
+	return "http"
+}
+
+// Start begins accepting readings into pipeline. The API server serves the route, so
+// there is nothing to listen on here.
+func (h *HTTPIngestor) Start(ctx context.Context, pipeline ingest.Pipeline) error {
+	h.mu.Lock()
+	h.pipeline = pipeline
+	h.mu.Unlock()
+	return nil
+}
+
+// target returns the pipeline, or nil before Start
+func (h *HTTPIngestor) target() ingest.Pipeline {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.pipeline
+}
+
+// authorize checks a posted reading's token against its device
+func (h *HTTPIngestor) authorize(r PostedReading) error {
+	authenticated := false
+	if h.auth != nil {
+		var err error
+		if authenticated, err = h.auth.Authenticate(r.DeviceID, r.DeviceToken); err != nil {
+			return errs.Wrap(errs.ErrUnauthorized, err)
+		}
+	}
+	if ingest.IsSafetyType(r.Type) && !authenticated {
+		return errs.Wrap(errs.ErrUnauthorized, fmt.Errorf("safety event %q needs the device's token", r.Type))
+	}
+	return nil
+}
+
+// handleIngestReadings hands a batch of readings to the ingestion pipeline. Invalid
+// readings are reported per index and don't fail the rest of the batch.
+func (s *Server) handleIngestReadings(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	var pipeline ingest.Pipeline
+	if s.Readings != nil {
+		pipeline = s.Readings.target()
+	}
+	if pipeline == nil {
+		writeError(w, http.StatusServiceUnavailable, "HTTP ingestion not enabled")
+		return
+	}
+
+	var req ReadingsRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReadingsBodyBytes)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if len(req.Readings) == 0 {
+		writeError(w, http.StatusBadRequest, "readings must not be empty")
+		return
+	}
+
+	var response ReadingsResponse
+	now := time.Now()
+	queueFull := false
+	for i, reading := range req.Readings {
+		if err := s.Readings.authorize(reading); err != nil {
+			metrics.Default.Counter("http_readings_unauthorized").Inc()
+			response.Rejected = append(response.Rejected, ReadingRejection{Index: i, Reason: err.Error()})
+			continue
+		}
+		if err := ingest.Submit(pipeline, reading.Reading, now); err != nil {
+			queueFull = queueFull || errors.Is(err, ingest.ErrQueueFull)
+			response.Rejected = append(response.Rejected, ReadingRejection{Index: i, Reason: err.Error()})
+			continue
+		}
+		response.Accepted++
+	}
+
+	metrics.Default.Counter("http_readings_accepted").Add(int64(response.Accepted))
+	metrics.Default.Counter("http_readings_rejected").Add(int64(len(response.Rejected)))
+	if len(response.Rejected) > 0 {
+		log.Printf("API: Readings batch: %d accepted, %d rejected (first: %s)",
+			response.Accepted, len(response.Rejected), response.Rejected[0].Reason)
+	}
+
+	// A backlog is worth retrying later; invalid readings are not
+	status := http.StatusOK
+	if response.Accepted == 0 && queueFull {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, response)
+}
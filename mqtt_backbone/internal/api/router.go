@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// HandlerFunc handles a request with path parameters extracted from the route pattern
+type HandlerFunc func(w http.ResponseWriter, r *http.Request, params map[string]string)
+
+// Route describes one REST endpoint
+type Route struct {
+	Method  string // HTTP method
+	Pattern string // Path with {param} segments, e.g. "/devices/{id}"
+	Summary string // One-line description
+	Handler HandlerFunc
+
+	// Documentation for the OpenAPI spec (zero values are omitted)
+	Request  interface{}  // Example request body; its type is documented
+	Response interface{}  // Example success response; its type is documented
+	Query    []QueryParam // Supported query parameters
+
+	// Changes stored or actuated state; refused on read-only (observer) instances
+	Mutates bool
+}
+
+// QueryParam documents a query string parameter
+type QueryParam struct {
+	Name        string
+	Description string
+}
+
+// accepts documents the route's request body type
+func (r *Route) accepts(body interface{}) *Route {
+	r.Request = body
+	return r
+}
+
+// returns documents the route's success response type
+func (r *Route) returns(body interface{}) *Route {
+	r.Response = body
+	return r
+}
+
+// query documents a query string parameter
+func (r *Route) query(name, description string) *Route {
+	r.Query = append(r.Query, QueryParam{Name: name, Description: description})
+	return r
+}
+
+// mutating marks the route as changing state
+func (r *Route) mutating() *Route {
+	r.Mutates = true
+	return r
+}
+
+// router dispatches requests to routes by method and path segments
+type router struct {
+	routes   []*Route
+	readOnly bool // Refuse mutating routes
+}
+
+// handle registers a route
+func (rt *router) handle(method, pattern, summary string, handler HandlerFunc) *Route {
+	route := &Route{Method: method, Pattern: pattern, Summary: summary, Handler: handler}
+	rt.routes = append(rt.routes, route)
+	return route
+}
+
+// ServeHTTP implements http.Handler
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pathMatched := false
+	for _, route := range rt.routes {
+		params, ok := matchPattern(route.Pattern, r.URL.Path)
+		if !ok {
+			continue
+		}
+		pathMatched = true
+		if route.Method != r.Method {
+			continue
+		}
+		if route.Mutates && rt.readOnly {
+			writeError(w, http.StatusForbidden, errReadOnly)
+			return
+		}
+		route.Handler(w, r, params)
+		return
+	}
+
+	if pathMatched {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeError(w, http.StatusNotFound, "not found")
+}
+
+// matchPattern matches a path against a pattern, returning its {param} values
+func matchPattern(pattern, path string) (map[string]string, bool) {
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternParts) != len(pathParts) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, part := range patternParts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			if pathParts[i] == "" {
+				return nil, false
+			}
+			params[part[1:len(part)-1]] = pathParts[i]
+			continue
+		}
+		if part != pathParts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// errReadOnly is the error of requests that would change state on an observer instance
+const errReadOnly = "read-only observer instance; send changes to the primary instance"
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("API: Error encoding response: %v", err)
+	}
+}
+
+// ErrorResponse is the body returned for failed requests
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, ErrorResponse{Error: message})
+}
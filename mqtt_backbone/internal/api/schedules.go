@@ -0,0 +1,192 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"iot-backend/internal/models"
+	"iot-backend/internal/mqtt"
+)
+
+// defaultScheduleHoldMinutes is how long a scheduled position holds when the request doesn't say
+const defaultScheduleHoldMinutes = 60
+
+// ScheduleRequest plans a one-off window command for several devices
+type ScheduleRequest struct {
+	ExecuteAt   string            `json:"execute_at"` // RFC 3339, or "HH:MM" for the next occurrence in server local time
+	Position    float64           `json:"position"`   // 0-100%
+	Devices     []string          `json:"devices,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`         // Every active device with all these tags (e.g., room=bedroom)
+	HoldMinutes *int              `json:"hold_minutes,omitempty"` // How long the position overrides the ML service (default 60, 0 = this command only)
+	Reason      string            `json:"reason,omitempty"`
+}
+
+// ScheduleResponse lists the commands of a schedule batch
+type ScheduleResponse struct {
+	BatchID  string                     `json:"batch_id"`
+	Commands []*models.ScheduledCommand `json:"commands"`
+}
+
+// parseExecuteAt reads an RFC 3339 time or the next occurrence of a local "HH:MM"
+func parseExecuteAt(v string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	clock, err := time.ParseInLocation("15:04", v, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("execute_at must be an RFC 3339 timestamp or HH:MM")
+	}
+	at := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at, nil
+}
+
+// handleCreateSchedule schedules a window command for the listed and tag-matched devices
+func (s *Server) handleCreateSchedule(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	var req ScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	now := time.Now()
+	executeAt, err := parseExecuteAt(req.ExecuteAt, now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !executeAt.After(now) {
+		writeError(w, http.StatusBadRequest, "execute_at must be in the future")
+		return
+	}
+	if req.Position < 0 || req.Position > 100 {
+		writeError(w, http.StatusBadRequest, "position must be between 0 and 100")
+		return
+	}
+	hold := defaultScheduleHoldMinutes
+	if req.HoldMinutes != nil {
+		hold = *req.HoldMinutes
+	}
+	if hold < 0 || hold > 24*60 {
+		writeError(w, http.StatusBadRequest, "hold_minutes must be between 0 and 1440")
+		return
+	}
+	if len(req.Devices) == 0 && len(req.Tags) == 0 {
+		writeError(w, http.StatusBadRequest, "devices or tags are required")
+		return
+	}
+
+	// Listed devices and tag matches, each once
+	seen := make(map[string]bool)
+	var deviceIDs []string
+	for _, id := range req.Devices {
+		if id = strings.TrimSpace(id); id != "" && !seen[id] {
+			seen[id] = true
+			deviceIDs = append(deviceIDs, id)
+		}
+	}
+	if len(req.Tags) > 0 {
+		devices, err := s.dbFor(r).ListDevicesByTags(req.Tags)
+		if err != nil {
+			log.Printf("API: Error listing devices by tags: %v", err)
+			s.writeQueryError(w, r, err, "failed to list devices")
+			return
+		}
+		for _, d := range devices {
+			if !seen[d.DeviceID] {
+				seen[d.DeviceID] = true
+				deviceIDs = append(deviceIDs, d.DeviceID)
+			}
+		}
+	}
+	if len(deviceIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "no devices match")
+		return
+	}
+
+	resp := ScheduleResponse{BatchID: mqtt.NewCorrelationID()}
+	for _, id := range deviceIDs {
+		resp.Commands = append(resp.Commands, &models.ScheduledCommand{
+			ID:          mqtt.NewCorrelationID(),
+			BatchID:     resp.BatchID,
+			DeviceID:    id,
+			ExecuteAt:   executeAt,
+			Position:    req.Position,
+			HoldMinutes: hold,
+			Reason:      req.Reason,
+			Status:      models.ScheduleStatusPending,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		})
+	}
+	if err := s.db.SaveScheduledCommands(resp.Commands); err != nil {
+		log.Printf("API: Error saving scheduled commands: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to save schedule")
+		return
+	}
+
+	log.Printf("API: Scheduled batch %s: %d devices to %.0f%% at %s", resp.BatchID, len(deviceIDs), req.Position, executeAt.Format(time.RFC3339))
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// handleListSchedules lists scheduled commands, paged and filtered with the shared list parameters
+func (s *Server) handleListSchedules(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	query := r.URL.Query()
+	status := query.Get("status")
+	switch status {
+	case "", models.ScheduleStatusPending, models.ScheduleStatusExecuted, models.ScheduleStatusMissed, models.ScheduleStatusCancelled:
+	default:
+		writeError(w, http.StatusBadRequest, "status must be pending, executed, missed, or cancelled")
+		return
+	}
+
+	commands, err := s.dbFor(r).GetScheduledCommands(status, query.Get("batch"))
+	if err != nil {
+		log.Printf("API: Error listing scheduled commands: %v", err)
+		s.writeQueryError(w, r, err, "failed to list schedules")
+		return
+	}
+
+	page, ok := listPage(s, w, r, commands, listSpec[*models.ScheduledCommand]{
+		key:      func(c *models.ScheduledCommand) string { return c.ID },
+		deviceID: func(c *models.ScheduledCommand) string { return c.DeviceID },
+		time:     func(c *models.ScheduledCommand) time.Time { return c.ExecuteAt },
+	})
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleCancelSchedule cancels the pending commands of a batch
+func (s *Server) handleCancelSchedule(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	commands, err := s.dbFor(r).GetScheduledCommands(models.ScheduleStatusPending, params["batch_id"])
+	if err != nil {
+		log.Printf("API: Error reading schedule %s: %v", params["batch_id"], err)
+		s.writeQueryError(w, r, err, "failed to read schedule")
+		return
+	}
+	if len(commands) == 0 {
+		writeError(w, http.StatusNotFound, "no pending commands in this batch")
+		return
+	}
+
+	now := time.Now()
+	for _, c := range commands {
+		c.Status, c.UpdatedAt = models.ScheduleStatusCancelled, now
+	}
+	if err := s.db.SaveScheduledCommands(commands); err != nil {
+		log.Printf("API: Error cancelling schedule %s: %v", params["batch_id"], err)
+		writeError(w, http.StatusInternalServerError, "failed to cancel schedule")
+		return
+	}
+
+	log.Printf("API: Cancelled %d commands of batch %s", len(commands), params["batch_id"])
+	writeJSON(w, http.StatusOK, ScheduleResponse{BatchID: params["batch_id"], Commands: commands})
+}
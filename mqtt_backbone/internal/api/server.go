@@ -0,0 +1,304 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"iot-backend/internal/alerts"
+	"iot-backend/internal/database"
+	"iot-backend/internal/derived"
+	"iot-backend/internal/hotstore"
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+	"iot-backend/internal/mqtt"
+	"iot-backend/internal/predict"
+	"iot-backend/internal/services"
+	"iot-backend/internal/state"
+	"iot-backend/pkg/config"
+)
+
+// Server exposes the backend's REST API
+type Server struct {
+	db     *database.ClickHouseDB
+	state  *state.Store
+	router *router
+
+	addr      string
+	startedAt time.Time
+
+	// Limits of the ClickHouse queries run for requests
+	queryLimits database.QueryLimits
+
+	// Bearer token of the WebSocket command channel (empty disables it)
+	commandToken string
+
+	// Closed when the server shuts down, ending hijacked connections
+	closing chan struct{}
+
+	// Optional local models for dry-run predictions, the active variant selected as for inference; set before Start
+	Models *predict.ModelSet
+
+	// Optional outdoor temperatures selecting the model variant of dry-run predictions; set before Start
+	Outdoor *derived.OutdoorTemperatures
+
+	// Optional source of the mold risk feature for dry-run predictions; set before Start
+	MoldRisk *derived.MoldRiskTracker
+
+	// Optional occupancy estimator for the occupancy endpoint and dry-run predictions; set before Start
+	Occupancy *derived.OccupancyEstimator
+
+	// Optional inference service for on-demand triggers; set before Start
+	Inference *services.InferenceService
+
+	// Optional window control service for actuation budgets; set before Start
+	Window *services.WindowControlService
+
+	// Optional alert manager whose snoozes the snooze endpoints manage; set before Start
+	Alerts *alerts.Manager
+
+	// Optional end-to-end canary whose latest run is reported by /health; set before Start
+	Canary *services.CanaryService
+
+	// Optional threshold advisor for trigger threshold suggestions; set before Start
+	Thresholds *services.ThresholdAdvisor
+
+	// Optional per-device MQTT message statistics; set before Start
+	DeviceStats *mqtt.MessageStats
+
+	// Optional in-memory recent readings; history ranges they cover skip ClickHouse; set before Start
+	Hot *hotstore.Store
+
+	// Optional ingestion of readings posted to /readings; set before Start
+	Readings *HTTPIngestor
+
+	// Optional effective configuration served, redacted, by /admin/config; set before Start
+	Config *config.Config
+
+	// Optional per-device noise floors reported by /admin/config; set before Start
+	NoiseFloors *derived.NoiseFloorCalibrator
+}
+
+// ServerConfig holds configuration for the API server
+type ServerConfig struct {
+	Addr         string // Listen address, e.g. ":8080"
+	CommandToken string // Bearer token for the WebSocket command channel (empty disables it)
+	ReadOnly     bool   // Observer instance: endpoints and commands that change state are refused
+
+	QueryLimits database.QueryLimits // Bounds on each ClickHouse query run for a request
+}
+
+// DefaultServerConfig returns default configuration
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Addr:        ":8080",
+		QueryLimits: database.DefaultQueryLimits(),
+	}
+}
+
+// NewServer creates a new API server
+func NewServer(db *database.ClickHouseDB, store *state.Store, config ServerConfig) *Server {
+	s := &Server{
+		db:        db,
+		state:     store,
+		router:    &router{readOnly: config.ReadOnly},
+		addr:      config.Addr,
+		startedAt: time.Now(),
+
+		queryLimits: config.QueryLimits,
+
+		commandToken: config.CommandToken,
+		closing:      make(chan struct{}),
+	}
+	s.registerRoutes()
+	return s
+}
+
+// registerRoutes declares every endpoint of the REST surface
+func (s *Server) registerRoutes() {
+	s.router.handle(http.MethodGet, "/health", "Service liveness and uptime", s.handleHealth).
+		returns(HealthResponse{})
+	s.router.handle(http.MethodGet, "/metrics", "In-process metrics snapshot", s.handleMetrics).
+		returns(metrics.Snapshot{})
+	s.router.handle(http.MethodGet, "/devices", "List active devices (from/to filter on last_seen)", s.handleListDevices).
+		returns([]models.Device{}).
+		lists(true, true)
+	s.router.handle(http.MethodGet, "/devices/{id}", "Get a device from the registry", s.handleGetDevice).
+		returns(models.Device{})
+	s.router.handle(http.MethodGet, "/devices/{id}/state", "Get the latest in-memory state of a device", s.handleGetDeviceState).
+		returns(models.DeviceState{}).
+		query("unit", "Temperature unit, C or F (default C)")
+	s.router.handle(http.MethodGet, "/devices/{id}/occupancy", "Get the current occupancy estimate of a device's room", s.handleGetOccupancy).
+		returns(models.Occupancy{})
+	s.router.handle(http.MethodGet, "/devices/{id}/stats", "Get a device's MQTT message rate, payload size, parse errors, and drops", s.handleGetDeviceStats).
+		returns(models.DeviceMessageStats{}).
+		query("hours", "Also return the stored counts of the last N hours (default 0, at most 744)")
+	s.router.handle(http.MethodGet, "/devices/{id}/history", "Get a metric of a device over time, downsampled for long ranges", s.handleDeviceHistory).
+		returns(models.Series{}).
+		query("metric", "temperature, humidity, or sound_volume (default temperature)").
+		query("from", "Start of the range, RFC 3339 (default 24 hours before to)").
+		query("to", "End of the range, RFC 3339 (default now)").
+		query("resolution", "Bucket size in seconds (default automatic; coarsened for long ranges)").
+		query("unit", "Temperature unit, C or F (default C)")
+	s.router.handle(http.MethodGet, "/devices/{id}/timeline", "Get a device's readings, inferences, predictions, window commands, overrides, and alerts as one time-ordered stream", s.handleDeviceTimeline).
+		returns(models.Timeline{}).
+		query("from", "Start of the range, RFC 3339 (default 24 hours before to)").
+		query("to", "End of the range, RFC 3339 (default now)").
+		query("resolution", "Reading summary bucket size in seconds (default the range split in 96 buckets)")
+	s.router.handle(http.MethodGet, "/devices/{id}/clipping", "Get how often a device's audio clipped over time", s.handleDeviceClipping).
+		returns(models.ClippingReport{}).
+		query("from", "Start of the range, RFC 3339 (default 24 hours before to)").
+		query("to", "End of the range, RFC 3339 (default now)").
+		query("resolution", "Bucket size in seconds (default the range split in 96 buckets)")
+	s.router.handle(http.MethodGet, "/devices/{id}/window-usage", "Get how often and how long a device's window is open, its position by hour of day, and its correlation with temperature and noise", s.handleWindowUsage).
+		returns(models.WindowUsageReport{}).
+		query("from", "Start of the range, RFC 3339, rounded down to local midnight (default 7 days before to)").
+		query("to", "End of the range, RFC 3339 (default now)")
+	s.router.handle(http.MethodGet, "/devices/{id}/heatmap", "Get a device's metric by day of week and hour of day (mean and percentiles of its hourly means), for usage heatmaps", s.handleDeviceHeatmap).
+		returns(models.Heatmap{}).
+		query("metric", "sound_volume, temperature, or humidity (default sound_volume)").
+		query("from", "Start of the range, RFC 3339 (default 28 days before to)").
+		query("to", "End of the range, RFC 3339 (default now)").
+		query("tz", "IANA time zone the days and hours are local to (default the server's)").
+		query("unit", "Temperature unit, C or F (default C)")
+	s.router.handle(http.MethodGet, "/devices/{id}/divergence", "Get how far a device's hourly temperature and humidity were from the other devices in its room", s.handleDeviceDivergence).
+		returns([]models.SensorDivergence{}).
+		query("from", "Start of the range, RFC 3339 (default 7 days before to)").
+		query("to", "End of the range, RFC 3339 (default now)")
+	s.router.handle(http.MethodGet, "/devices/{id}/actuation-budget", "Get a window's daily movement budget and how much of it today's commands used", s.handleGetActuationBudget).
+		returns(models.ActuationBudget{})
+	s.router.handle(http.MethodPost, "/devices/{id}/trigger-inference", "Force an inference for a device now (reason \"manual\")", s.handleTriggerInference).
+		returns(models.InferenceRequest{}).
+		query("force", "Set to true to bypass the manual trigger cooldown").
+		mutating()
+	s.router.handle(http.MethodPost, "/readings", "Ingest a batch of readings for devices without MQTT; each is validated and processed like an MQTT reading", s.handleIngestReadings).
+		accepts(ReadingsRequest{}).
+		returns(ReadingsResponse{}).
+		mutating()
+	s.router.handle(http.MethodPut, "/devices/{id}/tags", "Replace a device's tags", s.handleSetDeviceTags).
+		accepts(TagsRequest{}).
+		returns(models.Device{}).
+		mutating()
+	s.router.handle(http.MethodGet, "/aggregates", "Mean readings across devices matching a tag filter", s.handleGroupAggregates).
+		returns(GroupAggregatesResponse{}).
+		query("tag", "Only devices with this tag, as key=value (repeatable, all must match)").
+		query("window", "Window in seconds (default 300)").
+		query("unit", "Temperature unit, C or F (default C)")
+	s.router.handle(http.MethodGet, "/building/summary", "Average temperature, open windows, and noisiest rooms across the building", s.handleBuildingSummary).
+		returns(models.BuildingSummary{}).
+		query("hours", "Hours to summarize, counting the current one (default 1)").
+		query("rooms", "Number of noisiest rooms to list (default 5)").
+		query("unit", "Temperature unit, C or F (default C)")
+	s.router.handle(http.MethodPost, "/schedules", "Schedule a one-off window command for several devices (e.g., close bedroom windows at 22:30)", s.handleCreateSchedule).
+		accepts(ScheduleRequest{}).
+		returns(ScheduleResponse{}).
+		mutating()
+	s.router.handle(http.MethodGet, "/schedules", "List scheduled window commands (from/to filter on execute_at)", s.handleListSchedules).
+		returns([]models.ScheduledCommand{}).
+		query("status", "Only commands with this status: pending, executed, missed, or cancelled").
+		query("batch", "Only commands of this batch").
+		lists(true, true)
+	s.router.handle(http.MethodDelete, "/schedules/{batch_id}", "Cancel the pending commands of a schedule batch", s.handleCancelSchedule).
+		returns(ScheduleResponse{}).
+		mutating()
+	s.router.handle(http.MethodGet, "/alerts/snoozes", "List alert snoozes", s.handleListSnoozes).
+		returns([]models.AlertSnooze{}).
+		query("status", "active (default), expired, cancelled, or all").
+		lists(true, true)
+	s.router.handle(http.MethodPost, "/alerts/snoozes", "Silence the alerts of a device, an alert type, or both for a number of hours", s.handleCreateSnooze).
+		accepts(SnoozeRequest{}).
+		returns(models.AlertSnooze{}).
+		mutating()
+	s.router.handle(http.MethodDelete, "/alerts/snoozes/{id}", "Cancel an active alert snooze", s.handleCancelSnooze).
+		returns(models.AlertSnooze{}).
+		query("by", "Who is cancelling, for the audit trail (default \"api\")").
+		mutating()
+	s.router.handle(http.MethodGet, "/alerts/snoozes/audit", "Audit trail of snooze creations, cancellations, and expiries, newest first", s.handleSnoozeAudit).
+		returns([]models.AlertSnoozeAudit{}).
+		query("snooze", "Only entries of this snooze").
+		lists(true, true)
+	s.router.handle(http.MethodGet, "/thresholds/suggestions", "Recommended inference trigger thresholds per device and metric", s.handleThresholdSuggestions).
+		returns(models.ThresholdReport{}).
+		query("metric", "Only suggestions for this metric (temperature, humidity, or sound_volume)").
+		lists(true, false)
+	s.router.handle(http.MethodGet, "/admin/config", "Effective configuration, per-device overrides, and learned thresholds and noise floors, with secrets redacted", s.handleAdminConfig).
+		returns(AdminConfigResponse{})
+	s.router.handle(http.MethodGet, "/admin/storage", "Storage use per table and rows and bytes per day per table and device, for capacity planning", s.handleStorageReport).
+		returns(models.StorageReport{}).
+		query("days", "Days ingestion rates are averaged over, counting today (default 7)").
+		query("devices", "Number of devices with the highest ingestion to list (default 20, 0 for all)")
+	s.router.handle(http.MethodPost, "/predict/dry-run", "Predict a window position with the local model without actuating", s.handlePredictDryRun).
+		accepts(PredictRequest{}).
+		returns(PredictResponse{})
+	s.router.handle(http.MethodGet, "/inference/{correlation_id}/features", "Get the exact feature payload sent with an inference request", s.handleGetFeatureSnapshot).
+		returns(models.FeatureSnapshot{})
+	s.router.handle(http.MethodGet, "/ws/commands", "WebSocket command channel: follow device state and send window overrides (bearer token in the Authorization header or the Sec-WebSocket-Protocol entry after \"bearer\")", s.handleCommandChannel).
+		accepts(CommandMessage{}).
+		returns(CommandEvent{})
+
+	// API documentation
+	s.router.handle(http.MethodGet, "/openapi.json", "OpenAPI 3 specification of this API", s.handleOpenAPI)
+	s.router.handle(http.MethodGet, "/docs", "Interactive API console", s.handleDocs)
+}
+
+// Handler returns the HTTP handler for the API
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// Start serves the API until context is cancelled
+func (s *Server) Start(ctx context.Context) {
+	srv := &http.Server{
+		Addr:              s.addr,
+		Handler:           s.router,
+		ReadHeaderTimeout: 10 * time.Second,
+		// Requests end with the server, so their queries are cancelled on shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		close(s.closing)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("API: Error during shutdown: %v", err)
+		}
+	}()
+
+	log.Printf("API: Listening on %s", s.addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("API: Server error: %v", err)
+	}
+	log.Println("API: Shutdown complete")
+}
+
+// HealthResponse is returned by GET /health
+type HealthResponse struct {
+	Status        string               `json:"status"` // "ok", or "degraded" while the canary's latest run failed
+	UptimeSeconds float64              `json:"uptime_seconds"`
+	Canary        *models.CanaryStatus `json:"canary,omitempty"` // Latest canary run, when the canary is enabled
+}
+
+// handleHealth reports liveness; a failing canary degrades the status but not the HTTP code
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	response := HealthResponse{
+		Status:        "ok",
+		UptimeSeconds: time.Since(s.startedAt).Seconds(),
+	}
+	if s.Canary != nil {
+		response.Canary = s.Canary.Status()
+		if response.Canary != nil && !response.Canary.OK {
+			response.Status = "degraded"
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleMetrics returns the process metrics snapshot
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	writeJSON(w, http.StatusOK, metrics.Default.Snapshot())
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// handleStorageReport returns storage use and ingestion rates per table and device
+func (s *Server) handleStorageReport(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		var err error
+		days, err = strconv.Atoi(v)
+		if err != nil || days <= 0 || days > 90 {
+			writeError(w, http.StatusBadRequest, "days must be between 1 and 90")
+			return
+		}
+	}
+
+	devices := 20
+	if v := r.URL.Query().Get("devices"); v != "" {
+		var err error
+		devices, err = strconv.Atoi(v)
+		if err != nil || devices < 0 || devices > 10000 {
+			writeError(w, http.StatusBadRequest, "devices must be between 0 and 10000")
+			return
+		}
+	}
+
+	report, err := s.dbFor(r).GetStorageReport(days, devices)
+	if err != nil {
+		log.Printf("API: Error reading storage report: %v", err)
+		s.writeQueryError(w, r, err, "failed to read storage report")
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
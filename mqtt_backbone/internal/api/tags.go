@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"iot-backend/internal/database"
+	"iot-backend/internal/models"
+)
+
+// TagsRequest replaces a device's tags
+type TagsRequest struct {
+	Tags map[string]string `json:"tags"`
+}
+
+// GroupAggregatesResponse is returned by GET /aggregates
+type GroupAggregatesResponse struct {
+	Tags          map[string]string `json:"tags"`
+	WindowSeconds int               `json:"window_seconds"`
+	DeviceIDs     []string          `json:"device_ids"`
+	Unit          string            `json:"unit"` // Temperature unit, C or F
+	database.GroupAggregates
+
+	// Broker disconnections overlapping the window: the aggregates may be missing readings
+	Gaps []models.ConnectionGap `json:"gaps,omitempty"`
+}
+
+// parseTagQuery reads repeated ?tag=key=value parameters
+func parseTagQuery(r *http.Request) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range r.URL.Query()["tag"] {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("tag %q must be key=value", pair)
+		}
+		tags[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return tags, nil
+}
+
+// handleSetDeviceTags replaces the tags of a registered device
+func (s *Server) handleSetDeviceTags(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	var req TagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	for k := range req.Tags {
+		if strings.TrimSpace(k) == "" {
+			writeError(w, http.StatusBadRequest, "tag keys must not be empty")
+			return
+		}
+	}
+
+	device, err := s.dbFor(r).GetDevice(params["id"])
+	if err != nil {
+		log.Printf("API: Error getting device %s: %v", params["id"], err)
+		s.writeQueryError(w, r, err, "failed to get device")
+		return
+	}
+	if device == nil {
+		writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+
+	device.Tags = req.Tags
+	// The registry keeps the row with the latest last_seen, so the update must be newest
+	device.LastSeen = time.Now()
+	if err := s.db.UpsertDevice(device); err != nil {
+		log.Printf("API: Error updating tags for %s: %v", device.DeviceID, err)
+		writeError(w, http.StatusInternalServerError, "failed to update device")
+		return
+	}
+
+	log.Printf("API: Set tags for %s: %v", device.DeviceID, device.Tags)
+	redactDevice(device)
+	writeJSON(w, http.StatusOK, device)
+}
+
+// handleGroupAggregates returns mean readings across devices matching the tag filter
+func (s *Server) handleGroupAggregates(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	tags, err := parseTagQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	window := 300
+	if v := r.URL.Query().Get("window"); v != "" {
+		window, err = strconv.Atoi(v)
+		if err != nil || window <= 0 {
+			writeError(w, http.StatusBadRequest, "window must be a positive number of seconds")
+			return
+		}
+	}
+
+	unit, ok := temperatureUnit(w, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	devices, err := s.dbFor(r).ListDevicesByTags(tags)
+	if err != nil {
+		log.Printf("API: Error listing devices by tags: %v", err)
+		s.writeQueryError(w, r, err, "failed to list devices")
+		return
+	}
+
+	ids := make([]string, 0, len(devices))
+	for _, d := range devices {
+		ids = append(ids, d.DeviceID)
+	}
+
+	agg, err := s.dbFor(r).GetGroupAggregates(ids, window)
+	if err != nil {
+		log.Printf("API: Error aggregating devices %v: %v", ids, err)
+		s.writeQueryError(w, r, err, "failed to aggregate readings")
+		return
+	}
+	agg.Temperature = renderTemperature(agg.Temperature, unit)
+
+	now := time.Now()
+	writeJSON(w, http.StatusOK, GroupAggregatesResponse{
+		Tags:            tags,
+		WindowSeconds:   window,
+		DeviceIDs:       ids,
+		Unit:            unit,
+		GroupAggregates: *agg,
+		Gaps:            s.connectionGaps(r, now.Add(-time.Duration(window)*time.Second), now, aggregateStreams()...),
+	})
+}
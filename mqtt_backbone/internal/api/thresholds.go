@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+
+	"iot-backend/internal/models"
+)
+
+// handleThresholdSuggestions returns the latest trigger threshold recommendations
+func (s *Server) handleThresholdSuggestions(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	if s.Thresholds == nil {
+		writeError(w, http.StatusServiceUnavailable, "threshold suggestions not enabled")
+		return
+	}
+	report := s.Thresholds.Report()
+	if report == nil {
+		writeError(w, http.StatusServiceUnavailable, "threshold suggestions not computed yet")
+		return
+	}
+
+	metric := r.URL.Query().Get("metric")
+	suggestions := []models.ThresholdSuggestion{}
+	for _, suggestion := range report.Suggestions {
+		if metric == "" || suggestion.Metric == metric {
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+
+	page, ok := listPage(s, w, r, suggestions, listSpec[models.ThresholdSuggestion]{
+		key:      func(t models.ThresholdSuggestion) string { return t.DeviceID + "/" + t.Metric },
+		deviceID: func(t models.ThresholdSuggestion) string { return t.DeviceID },
+	})
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, thresholdPage{ThresholdReport: *report, Suggestions: page})
+}
+
+// thresholdPage is a threshold report with one page of its suggestions
+type thresholdPage struct {
+	models.ThresholdReport
+	Suggestions interface{} `json:"suggestions"`
+}
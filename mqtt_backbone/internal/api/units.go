@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+
+	"iot-backend/internal/models"
+)
+
+// temperatureUnit reads the "unit" query parameter (C or F, default C). It writes the
+// error response and returns ok=false when the unit is unknown.
+func temperatureUnit(w http.ResponseWriter, query url.Values) (string, bool) {
+	v := query.Get("unit")
+	if v == "" {
+		return models.UnitCelsius, true
+	}
+	unit, err := models.ParseTemperatureUnit(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "unit must be C or F")
+		return "", false
+	}
+	return unit, true
+}
+
+// renderTemperature converts a stored (Celsius) temperature to the requested unit
+func renderTemperature(celsius float64, unit string) float64 {
+	if unit == models.UnitFahrenheit {
+		return models.CelsiusToFahrenheit(celsius)
+	}
+	return celsius
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The command channel runs over gorilla/websocket, which handles framing, masking,
+// fragmentation, and control frames; this wraps it in the few calls the channel needs.
+
+// wsBearerProtocol is the subprotocol under which browser clients, which can't set
+// an Authorization header, offer their token as the next Sec-WebSocket-Protocol entry
+const wsBearerProtocol = "bearer"
+
+// opText is the type of the text messages the channel exchanges
+const opText = websocket.TextMessage
+
+// wsWriteTimeout bounds every frame write, so a stalled client can't block the channel
+const wsWriteTimeout = 10 * time.Second
+
+// wsUpgrader answers the opening handshake. Any origin may connect: clients
+// authenticate with a bearer token rather than cookies, so a foreign page gains
+// nothing from the browser's credentials.
+var wsUpgrader = websocket.Upgrader{
+	Subprotocols: []string{wsBearerProtocol},
+	CheckOrigin:  func(*http.Request) bool { return true },
+	Error: func(w http.ResponseWriter, _ *http.Request, status int, reason error) {
+		writeError(w, status, reason.Error())
+	},
+}
+
+// wsConn is a server-side WebSocket connection. Reads must come from one
+// goroutine; writes may come from any.
+type wsConn struct {
+	conn *websocket.Conn
+
+	writeMu sync.Mutex
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the connection.
+// On failure the client has already been answered.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, maxMessageBytes int) (*wsConn, error) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upgrade to websocket: %w", err)
+	}
+	conn.SetReadLimit(int64(maxMessageBytes))
+	return &wsConn{conn: conn}, nil
+}
+
+// ReadMessage returns the next text or binary message, answering pings on the way.
+// It returns io.EOF once the client closes the connection.
+func (c *wsConn) ReadMessage() (opcode int, message []byte, err error) {
+	opcode, message, err = c.conn.ReadMessage()
+	var closed *websocket.CloseError
+	if errors.As(err, &closed) {
+		return 0, nil, io.EOF
+	}
+	return opcode, message, err
+}
+
+// WriteText sends a text message
+func (c *wsConn) WriteText(message []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+		return fmt.Errorf("failed to write websocket message: %w", err)
+	}
+	return nil
+}
+
+// Ping sends a ping; the client's pong is consumed by ReadMessage
+func (c *wsConn) Ping() error {
+	return c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+}
+
+// Close sends a close frame with a status code and closes the connection
+func (c *wsConn) Close(code int, reason string) error {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteTimeout))
+	return c.conn.Close()
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"iot-backend/internal/services"
+)
+
+// handleWindowUsage returns a device's window usage analytics over whole local days
+func (s *Server) handleWindowUsage(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	query := r.URL.Query()
+	from, to, ok := parseTimeRange(w, query)
+	if !ok {
+		return
+	}
+	if query.Get("from") == "" {
+		from = to.AddDate(0, 0, -7)
+	}
+	// Stored days start at local midnight
+	from = from.Local()
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local)
+
+	days, hours, err := s.dbFor(r).GetWindowUsage(params["id"], from, to)
+	if err != nil {
+		log.Printf("API: Error reading window usage for %s: %v", params["id"], err)
+		s.writeQueryError(w, r, err, "failed to read window usage")
+		return
+	}
+	writeJSON(w, http.StatusOK, services.SummarizeWindowUsage(params["id"], from, to, days, hours))
+}
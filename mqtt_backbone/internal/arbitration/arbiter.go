@@ -0,0 +1,325 @@
+// Package arbitration resolves conflicting window position requests.
+//
+// Every source submits proposals; the arbiter keeps the active proposals per
+// device and picks a winner in this fixed priority order:
+//
+//  1. safety   - rain, wind, alarm, frost (never overridden)
+//  2. manual   - user overrides (app, wall switch)
+//  3. schedule - planned commands
+//  4. rules    - local automations evaluated by the backend
+//  5. ml       - ML service decisions
+//
+// The highest-priority "set" proposal chooses the position. "Cap" proposals
+// (e.g., "at most 20% open") from any source at or above the winner's priority
+// then limit that position. Set proposals from one source that carry a weight
+// (e.g., the ML models of separate inference domains) are merged into their
+// weighted mean before the winner is picked. Each decision carries a trace
+// explaining the outcome of every active proposal.
+package arbitration
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source identifies who proposed a window position
+type Source string
+
+// Proposal sources, see package documentation for priority order
+const (
+	SourceSafety   Source = "safety"
+	SourceManual   Source = "manual"
+	SourceSchedule Source = "schedule"
+	SourceRules    Source = "rules"
+	SourceML       Source = "ml"
+)
+
+// priority returns the rank of a source (lower wins)
+func (s Source) priority() int {
+	switch s {
+	case SourceSafety:
+		return 0
+	case SourceManual:
+		return 1
+	case SourceSchedule:
+		return 2
+	case SourceRules:
+		return 3
+	case SourceML:
+		return 4
+	}
+	return 5
+}
+
+// Proposal is a request from one source to move (or limit) a window
+type Proposal struct {
+	DeviceID  string
+	Source    Source
+	Key       string    // Distinguishes proposals from the same source (e.g., "rain", "wind")
+	Position  float64   // Target position, or the maximum position for caps (0-100%)
+	Cap       bool      // Limit other proposals to at most Position instead of setting it
+	Reason    string    // Human-readable reason recorded in the trace
+	Weight    float64   // Merge weight (e.g., model confidence); weighted set proposals of a source are averaged
+	CreatedAt time.Time // When the proposal was submitted
+	ExpiresAt time.Time // Zero means the proposal only applies to this decision
+}
+
+// TraceEntry records what happened to one proposal during a decision
+type TraceEntry struct {
+	Source   Source  `json:"source"`
+	Key      string  `json:"key,omitempty"`
+	Position float64 `json:"position"`
+	Cap      bool    `json:"cap,omitempty"`
+	Outcome  string  `json:"outcome"` // "won", "capped", "overridden", "merged", "applied_cap", "inactive_cap", "ignored_cap"
+	Reason   string  `json:"reason"`
+}
+
+// Decision is the arbitrated position for a device and why it was chosen
+type Decision struct {
+	DeviceID  string
+	Timestamp time.Time
+	Position  float64
+	Winner    Source
+	Summary   string
+	Trace     []TraceEntry
+}
+
+// CappedBy reports whether a cap from source limited the decision: the winner
+// was capped and the cap is one that set the final position
+func (d Decision) CappedBy(source Source) bool {
+	capped := false
+	for _, e := range d.Trace {
+		if e.Outcome == "capped" {
+			capped = true
+		}
+	}
+	if !capped {
+		return false
+	}
+	for _, e := range d.Trace {
+		if e.Outcome == "applied_cap" && e.Source == source {
+			return true
+		}
+	}
+	return false
+}
+
+// Arbiter holds active proposals per device and resolves them. Safe for concurrent use.
+type Arbiter struct {
+	mu     sync.Mutex
+	active map[string]map[string]Proposal // device_id -> source/key -> proposal
+	now    func() time.Time
+}
+
+// NewArbiter creates a new arbiter
+func NewArbiter() *Arbiter {
+	return &Arbiter{
+		active: make(map[string]map[string]Proposal),
+		now:    time.Now,
+	}
+}
+
+// proposalKey identifies a proposal slot within a device
+func proposalKey(p Proposal) string {
+	return string(p.Source) + "/" + p.Key
+}
+
+// Submit records a proposal and returns the resulting decision.
+// Proposals with an expiry stay active (and keep influencing decisions) until then;
+// a new proposal from the same source and key replaces the previous one.
+func (a *Arbiter) Submit(p Proposal) Decision {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = now
+	}
+
+	proposals := a.activeLocked(p.DeviceID, now)
+	if p.ExpiresAt.After(now) {
+		if a.active[p.DeviceID] == nil {
+			a.active[p.DeviceID] = make(map[string]Proposal)
+		}
+		a.active[p.DeviceID][proposalKey(p)] = p
+	}
+
+	// Replace any stored proposal in the same slot with the incoming one
+	candidates := []Proposal{p}
+	for _, existing := range proposals {
+		if proposalKey(existing) != proposalKey(p) {
+			candidates = append(candidates, existing)
+		}
+	}
+
+	return resolve(p.DeviceID, now, candidates)
+}
+
+// Release removes an active proposal (e.g., rain stopped) without producing a decision
+func (a *Arbiter) Release(deviceID string, source Source, key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.active[deviceID], string(source)+"/"+key)
+}
+
+// Active returns the unexpired proposals for a device
+func (a *Arbiter) Active(deviceID string) []Proposal {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.activeLocked(deviceID, a.now())
+}
+
+// activeLocked prunes expired proposals and returns the rest. Caller must hold mu.
+func (a *Arbiter) activeLocked(deviceID string, now time.Time) []Proposal {
+	var proposals []Proposal
+	for key, p := range a.active[deviceID] {
+		if !p.ExpiresAt.After(now) {
+			delete(a.active[deviceID], key)
+			continue
+		}
+		proposals = append(proposals, p)
+	}
+	return proposals
+}
+
+// Resolve arbitrates a set of proposals at a given time without storing them,
+// e.g., to evaluate proposed rules against historical decisions
+func Resolve(deviceID string, now time.Time, proposals []Proposal) Decision {
+	return resolve(deviceID, now, append([]Proposal(nil), proposals...))
+}
+
+// resolve picks the winning proposal and applies caps, producing a full trace
+func resolve(deviceID string, now time.Time, proposals []Proposal) Decision {
+	proposals, merged := mergeWeighted(proposals)
+
+	sort.SliceStable(proposals, func(i, j int) bool {
+		pi, pj := proposals[i].Source.priority(), proposals[j].Source.priority()
+		if pi != pj {
+			return pi < pj
+		}
+		// Newer proposals win within the same source
+		return proposals[i].CreatedAt.After(proposals[j].CreatedAt)
+	})
+
+	decision := Decision{DeviceID: deviceID, Timestamp: now}
+
+	// The winner is the highest-priority set proposal
+	winner := -1
+	for i, p := range proposals {
+		if !p.Cap {
+			winner = i
+			break
+		}
+	}
+
+	if winner >= 0 {
+		decision.Position = proposals[winner].Position
+		decision.Winner = proposals[winner].Source
+	} else {
+		// Only caps are active: hold the window at the tightest cap
+		decision.Position = 100
+	}
+
+	// Apply caps from sources at or above the winner's priority
+	capSource := Source("")
+	for _, p := range proposals {
+		if !p.Cap || (winner >= 0 && p.Source.priority() > proposals[winner].Source.priority()) {
+			continue
+		}
+		if p.Position < decision.Position {
+			decision.Position = p.Position
+			capSource = p.Source
+		}
+	}
+	if winner < 0 {
+		decision.Winner = capSource
+	}
+
+	for i, p := range proposals {
+		entry := TraceEntry{Source: p.Source, Key: p.Key, Position: p.Position, Cap: p.Cap, Reason: p.Reason}
+		switch {
+		case i == winner && decision.Position < p.Position:
+			entry.Outcome = "capped"
+		case i == winner:
+			entry.Outcome = "won"
+		case p.Cap && (winner < 0 || p.Source.priority() <= proposals[winner].Source.priority()):
+			if p.Position <= decision.Position {
+				entry.Outcome = "applied_cap"
+			} else {
+				entry.Outcome = "inactive_cap"
+			}
+		case p.Cap:
+			entry.Outcome = "ignored_cap"
+		default:
+			entry.Outcome = "overridden"
+		}
+		decision.Trace = append(decision.Trace, entry)
+	}
+	for _, p := range merged {
+		decision.Trace = append(decision.Trace, TraceEntry{Source: p.Source, Key: p.Key, Position: p.Position, Outcome: "merged", Reason: p.Reason})
+	}
+
+	decision.Summary = summarize(decision, proposals, winner)
+	return decision
+}
+
+// mergeWeighted replaces the weighted set proposals of each source that has more
+// than one with a single proposal at their weighted mean position, and returns the
+// proposals that were merged into it
+func mergeWeighted(proposals []Proposal) (result, merged []Proposal) {
+	groups := make(map[Source][]Proposal)
+	for _, p := range proposals {
+		if !p.Cap && p.Weight > 0 {
+			groups[p.Source] = append(groups[p.Source], p)
+		}
+	}
+
+	for _, p := range proposals {
+		group := groups[p.Source]
+		if p.Cap || p.Weight <= 0 || len(group) < 2 {
+			result = append(result, p)
+			continue
+		}
+		if proposalKey(p) != proposalKey(group[0]) {
+			continue // Folded into the group's merged proposal
+		}
+
+		var sum, weights float64
+		keys := make([]string, 0, len(group))
+		combined := Proposal{DeviceID: p.DeviceID, Source: p.Source, Key: "merged"}
+		for _, member := range group {
+			sum += member.Position * member.Weight
+			weights += member.Weight
+			keys = append(keys, fmt.Sprintf("%s=%.1f%%", member.Key, member.Position))
+			if member.CreatedAt.After(combined.CreatedAt) {
+				combined.CreatedAt = member.CreatedAt
+			}
+		}
+		sort.Strings(keys)
+		combined.Position = sum / weights
+		combined.Weight = weights
+		combined.Reason = fmt.Sprintf("weighted mean of %s", strings.Join(keys, ", "))
+		result = append(result, combined)
+		merged = append(merged, group...)
+	}
+	return result, merged
+}
+
+// summarize builds a one-line explanation of a decision
+func summarize(d Decision, proposals []Proposal, winner int) string {
+	if winner < 0 {
+		return fmt.Sprintf("%s cap holds window at %.1f%%", d.Winner, d.Position)
+	}
+	w := proposals[winner]
+	summary := fmt.Sprintf("%s won (%s)", w.Source, w.Reason)
+	if d.Position < w.Position {
+		summary += fmt.Sprintf(", capped from %.1f%% to %.1f%%", w.Position, d.Position)
+	}
+	if overridden := len(proposals) - 1; overridden > 0 {
+		summary += fmt.Sprintf(", %d other proposal(s) considered", overridden)
+	}
+	return summary
+}
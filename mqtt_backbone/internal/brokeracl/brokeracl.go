@@ -0,0 +1,225 @@
+// Package brokeracl generates broker access control and credential files from the
+// device registry, so every registered device connects as its own user that may
+// only publish and subscribe within its own topics.
+//
+// Mosquitto gets an acl_file and a password_file in mosquitto_passwd format
+// (PBKDF2-SHA512). EMQX gets an acl.conf for the file authorizer and a users.csv
+// bootstrap file for the built-in database authenticator, which must be configured
+// with password_hash_algorithm {name = sha256, salt_position = suffix}.
+package brokeracl
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"iot-backend/internal/models"
+	"iot-backend/internal/mqtt"
+)
+
+// PasswordConfig is the registry config key holding a device's broker password.
+// Devices without one use their device token (mqtt.DeviceTokenConfig).
+const PasswordConfig = "mqtt_password"
+
+// Output formats
+const (
+	FormatMosquitto = "mosquitto"
+	FormatEMQX      = "emqx"
+)
+
+// Mosquitto password hashing, as mosquitto_passwd does it
+const (
+	mosquittoIterations = 101
+	mosquittoSaltBytes  = 12
+)
+
+// Topics are the topic templates devices publish to and subscribe to
+type Topics struct {
+	Publish   []string // e.g., "sensor/+/temperature", "window/+/ack"
+	Subscribe []string // e.g., "window/{device_id}/command", "device/{device_id}/config"
+	Prefix    string   // Namespace prepended to every topic (MQTT_TOPIC_PREFIX)
+	Tenant    string   // Value of {tenant} (empty allows any tenant)
+}
+
+// User is one broker user and the topics it may use
+type User struct {
+	Username  string
+	Password  string // Empty when the device has no credential in the registry
+	Superuser bool   // May publish and subscribe anywhere (the backend)
+	Publish   []string
+	Subscribe []string
+}
+
+// DeviceUsers returns a user per device restricted to its own topics. Devices whose
+// ID can't be a broker username or topic level are returned as skipped.
+func DeviceUsers(devices []models.Device, topics Topics) (users []User, skipped []string, err error) {
+	publish, err := parseTemplates(topics.Publish)
+	if err != nil {
+		return nil, nil, err
+	}
+	subscribe, err := parseTemplates(topics.Subscribe)
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix := mqtt.NormalizePrefix(topics.Prefix)
+	vars := mqtt.TopicVars{Tenant: topics.Tenant}
+
+	for _, device := range devices {
+		if !validUsername(device.DeviceID) {
+			skipped = append(skipped, device.DeviceID)
+			continue
+		}
+		user := User{Username: device.DeviceID, Password: devicePassword(device)}
+		for _, t := range publish {
+			user.Publish = append(user.Publish, mqtt.PrefixTopic(prefix, t.DeviceFilter(device.DeviceID, vars)))
+		}
+		for _, t := range subscribe {
+			user.Subscribe = append(user.Subscribe, mqtt.PrefixTopic(prefix, t.DeviceFilter(device.DeviceID, vars)))
+		}
+		users = append(users, user)
+	}
+	return users, skipped, nil
+}
+
+// parseTemplates parses the non-empty topic templates
+func parseTemplates(patterns []string) ([]*mqtt.TopicTemplate, error) {
+	var templates []*mqtt.TopicTemplate
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		t, err := mqtt.ParseTopicTemplate(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid topic: %w", err)
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// validUsername reports whether a device ID works as a username, a topic level, and
+// a line in every generated file
+func validUsername(id string) bool {
+	return id != "" && !strings.ContainsAny(id, " \t\r\n:/+#\"\\")
+}
+
+// devicePassword returns a device's broker password from the registry
+func devicePassword(device models.Device) string {
+	for _, key := range []string{PasswordConfig, mqtt.DeviceTokenConfig} {
+		if password, ok := device.Config[key].(string); ok && password != "" {
+			return password
+		}
+	}
+	return ""
+}
+
+// WriteMosquittoACL writes an acl_file granting each user its topics
+func WriteMosquittoACL(w io.Writer, users []User) error {
+	var b strings.Builder
+	b.WriteString("# Generated by iot-backend broker-acl from the device registry; do not edit.\n")
+	for _, user := range users {
+		fmt.Fprintf(&b, "\nuser %s\n", user.Username)
+		if user.Superuser {
+			b.WriteString("topic readwrite #\n")
+			continue
+		}
+		for _, topic := range user.Publish {
+			fmt.Fprintf(&b, "topic write %s\n", topic)
+		}
+		for _, topic := range user.Subscribe {
+			fmt.Fprintf(&b, "topic read %s\n", topic)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteMosquittoPasswd writes a password_file for the users with a password
+func WriteMosquittoPasswd(w io.Writer, users []User) error {
+	var b strings.Builder
+	for _, user := range users {
+		if user.Password == "" {
+			continue
+		}
+		salt := make([]byte, mosquittoSaltBytes)
+		if _, err := rand.Read(salt); err != nil {
+			return fmt.Errorf("failed to generate salt: %w", err)
+		}
+		hash := pbkdf2SHA512([]byte(user.Password), salt, mosquittoIterations)
+		fmt.Fprintf(&b, "%s:$7$%d$%s$%s\n", user.Username, mosquittoIterations,
+			base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(hash))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteEMQXACL writes an acl.conf granting each user its topics and denying everything else
+func WriteEMQXACL(w io.Writer, users []User) error {
+	var b strings.Builder
+	b.WriteString("%% Generated by iot-backend broker-acl from the device registry; do not edit.\n")
+	for _, user := range users {
+		name := `"` + user.Username + `"`
+		if user.Superuser {
+			fmt.Fprintf(&b, "{allow, {username, %s}, all, [\"#\"]}.\n", name)
+			continue
+		}
+		if len(user.Publish) > 0 {
+			fmt.Fprintf(&b, "{allow, {username, %s}, publish, [%s]}.\n", name, quoteAll(user.Publish))
+		}
+		if len(user.Subscribe) > 0 {
+			fmt.Fprintf(&b, "{allow, {username, %s}, subscribe, [%s]}.\n", name, quoteAll(user.Subscribe))
+		}
+	}
+	b.WriteString("{deny, all}.\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteEMQXUsers writes a users.csv bootstrap file (salted SHA-256) for the users with a password
+func WriteEMQXUsers(w io.Writer, users []User) error {
+	var b strings.Builder
+	b.WriteString("user_id,password_hash,salt,is_superuser\n")
+	for _, user := range users {
+		if user.Password == "" {
+			continue
+		}
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return fmt.Errorf("failed to generate salt: %w", err)
+		}
+		saltHex := hex.EncodeToString(salt)
+		hash := sha256.Sum256([]byte(user.Password + saltHex))
+		fmt.Fprintf(&b, "%s,%s,%s,%t\n", user.Username, hex.EncodeToString(hash[:]), saltHex, user.Superuser)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// quoteAll quotes topics as a comma-separated list of Erlang strings
+func quoteAll(topics []string) string {
+	return `"` + strings.Join(topics, `", "`) + `"`
+}
+
+// pbkdf2SHA512 derives a 64-byte key (a single PBKDF2 block, RFC 8018)
+func pbkdf2SHA512(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha512.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
@@ -0,0 +1,245 @@
+// Package bus provides typed in-process publish/subscribe topics that connect
+// producers (MQTT subscriber, services) to any number of consumers.
+package bus
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+)
+
+// Topic fans out every published value to all of its subscribers.
+// Producers write to In(); the topic owns every channel and closes subscriber
+// queues when the bus stops, so producers and consumers never close them.
+type Topic[T any] struct {
+	name    string
+	in      chan T
+	timeout time.Duration // How long delivery waits on a full subscriber before dropping
+
+	mu   sync.RWMutex
+	subs []subscription[T]
+}
+
+// subscription is one consumer's queue
+type subscription[T any] struct {
+	name  string
+	ch    chan T
+	spill *SpillQueue[T] // Overflow for events the queue can't take (nil drops them)
+}
+
+// NewTopic creates a topic with the given input buffer and per-subscriber delivery timeout
+func NewTopic[T any](name string, size int, timeout time.Duration) *Topic[T] {
+	return &Topic[T]{
+		name:    name,
+		in:      make(chan T, size),
+		timeout: timeout,
+	}
+}
+
+// Name returns the topic name
+func (t *Topic[T]) Name() string {
+	return t.name
+}
+
+// In returns the channel producers publish to
+func (t *Topic[T]) In() chan<- T {
+	return t.in
+}
+
+// Subscribe registers a consumer and returns its queue.
+// Subscribe before the bus starts so no events are missed.
+func (t *Topic[T]) Subscribe(name string, size int) <-chan T {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ch := make(chan T, size)
+	t.subs = append(t.subs, subscription[T]{name: name, ch: ch})
+	log.Printf("Bus: %s subscribed to %s", name, t.name)
+	return ch
+}
+
+// SubscribeWithSpill registers a consumer whose overflow goes to a spill queue
+// instead of being dropped. Dropping only happens once the spill queue is full.
+func (t *Topic[T]) SubscribeWithSpill(name string, size int, spill *SpillQueue[T]) <-chan T {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ch := make(chan T, size)
+	t.subs = append(t.subs, subscription[T]{name: name, ch: ch, spill: spill})
+	log.Printf("Bus: %s subscribed to %s (spilling up to %d events)", name, t.name, spill.maxItems)
+	return ch
+}
+
+// run delivers published values until context is cancelled, then closes every subscriber queue
+func (t *Topic[T]) run(ctx context.Context) {
+	var drains sync.WaitGroup
+	t.mu.RLock()
+	for _, sub := range t.subs {
+		if sub.spill != nil {
+			drains.Add(1)
+			go func(sub subscription[T]) {
+				defer drains.Done()
+				t.drain(ctx, sub)
+			}(sub)
+		}
+	}
+	t.mu.RUnlock()
+
+	defer func() {
+		drains.Wait() // Drains send on subscriber queues, so they stop first
+		t.mu.Lock()
+		for _, sub := range t.subs {
+			close(sub.ch)
+		}
+		t.subs = nil
+		t.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v := <-t.in:
+			t.deliver(ctx, v)
+		}
+	}
+}
+
+// deliver hands a value to each subscriber, dropping it for subscribers that stay full past the timeout
+func (t *Topic[T]) deliver(ctx context.Context, v T) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, sub := range t.subs {
+		// Events queue behind spilled ones so the subscriber sees them in order
+		if sub.spill != nil && sub.spill.Len() > 0 {
+			t.spill(sub, v)
+			continue
+		}
+
+		select {
+		case sub.ch <- v:
+			continue
+		default:
+		}
+
+		timer := time.NewTimer(t.timeout)
+		select {
+		case sub.ch <- v:
+		case <-timer.C:
+			if sub.spill != nil {
+				t.spill(sub, v)
+				break
+			}
+			metrics.Default.Counter("bus_" + t.name + "_dropped").Inc()
+			log.Printf("Warning: Bus subscriber %s is full, dropping %s event", sub.name, t.name)
+		case <-ctx.Done():
+		}
+		timer.Stop()
+	}
+}
+
+// spill moves an event to a subscriber's spill queue, dropping it when the queue is full
+func (t *Topic[T]) spill(sub subscription[T], v T) {
+	if !sub.spill.push(v) {
+		metrics.Default.Counter("bus_" + t.name + "_dropped").Inc()
+		log.Printf("Warning: Bus subscriber %s spill queue is full, dropping %s event", sub.name, t.name)
+		return
+	}
+	metrics.Default.Counter("bus_" + t.name + "_spilled").Inc()
+	metrics.Default.Gauge("bus_" + t.name + "_spill_depth").Set(int64(sub.spill.Len()))
+}
+
+// drain feeds spilled events back to the subscriber as its queue frees up. An event
+// leaves the spill queue only once delivered, so none are lost at shutdown.
+func (t *Topic[T]) drain(ctx context.Context, sub subscription[T]) {
+	depth := metrics.Default.Gauge("bus_" + t.name + "_spill_depth")
+	for {
+		depth.Set(int64(sub.spill.Len()))
+		key, v, ok := sub.spill.peek()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sub.spill.notify:
+				continue
+			}
+		}
+
+		select {
+		case sub.ch <- v:
+			sub.spill.remove(key)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Bus holds the backend's event topics
+type Bus struct {
+	Temperature *Topic[*models.TemperatureReading]
+	Humidity    *Topic[*models.HumidityReading]
+	Audio       *Topic[*models.AudioRecording]
+	Safety      *Topic[*models.SafetyEvent]
+	Boot        *Topic[*models.DeviceBoot]
+	Presence    *Topic[*models.PresenceReading] // Motion and CO2
+	Backfill    *Topic[*models.BackfillBatch]   // Readings buffered while devices were offline
+
+	InferenceRequests  *Topic[*models.InferenceRequest]  // Services → ML service
+	InferenceResponses *Topic[*models.InferenceResponse] // ML service → services
+}
+
+// Config holds topic buffer sizes and delivery timeouts
+type Config struct {
+	ReadingBufferSize   int // Temperature and humidity
+	AudioBufferSize     int // Smaller since audio is larger
+	SafetyBufferSize    int
+	InferenceBufferSize int // Requests and responses
+
+	DeliveryTimeout time.Duration // Routine events wait this long on a full subscriber before dropping or spilling
+	SafetyTimeout   time.Duration // Safety events must never hold up the bus
+}
+
+// DefaultConfig returns default configuration
+func DefaultConfig() Config {
+	return Config{
+		ReadingBufferSize:   100,
+		AudioBufferSize:     50,
+		SafetyBufferSize:    20,
+		InferenceBufferSize: 50,
+		DeliveryTimeout:     1 * time.Second,
+		SafetyTimeout:       100 * time.Millisecond,
+	}
+}
+
+// New creates a bus with all topics
+func New(config Config) *Bus {
+	return &Bus{
+		Temperature:        NewTopic[*models.TemperatureReading]("temperature", config.ReadingBufferSize, config.DeliveryTimeout),
+		Humidity:           NewTopic[*models.HumidityReading]("humidity", config.ReadingBufferSize, config.DeliveryTimeout),
+		Audio:              NewTopic[*models.AudioRecording]("audio", config.AudioBufferSize, config.DeliveryTimeout),
+		Safety:             NewTopic[*models.SafetyEvent]("safety", config.SafetyBufferSize, config.SafetyTimeout),
+		Boot:               NewTopic[*models.DeviceBoot]("boot", config.SafetyBufferSize, config.DeliveryTimeout), // Rare, like safety events
+		Presence:           NewTopic[*models.PresenceReading]("presence", config.ReadingBufferSize, config.DeliveryTimeout),
+		Backfill:           NewTopic[*models.BackfillBatch]("backfill", config.SafetyBufferSize, config.DeliveryTimeout), // Rare but large
+		InferenceRequests:  NewTopic[*models.InferenceRequest]("inference_requests", config.InferenceBufferSize, config.DeliveryTimeout),
+		InferenceResponses: NewTopic[*models.InferenceResponse]("inference_responses", config.InferenceBufferSize, config.DeliveryTimeout),
+	}
+}
+
+// Start runs every topic until context is cancelled
+func (b *Bus) Start(ctx context.Context) {
+	go b.Temperature.run(ctx)
+	go b.Humidity.run(ctx)
+	go b.Audio.run(ctx)
+	go b.Safety.run(ctx)
+	go b.Boot.run(ctx)
+	go b.Presence.run(ctx)
+	go b.Backfill.run(ctx)
+	go b.InferenceRequests.run(ctx)
+	go b.InferenceResponses.run(ctx)
+}
@@ -0,0 +1,149 @@
+package bus
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"iot-backend/internal/clipstore"
+	"iot-backend/internal/models"
+)
+
+// SpillQueue is a bounded FIFO of events kept in a store. A subscriber with a spill
+// queue doesn't lose events while it is too slow to keep up (e.g., during a broker
+// hiccup): events that would be dropped wait on disk and are delivered in order once
+// the subscriber catches up. Events still queued at shutdown are delivered after the
+// next start.
+type SpillQueue[T any] struct {
+	store    clipstore.Store
+	maxItems int
+	encode   func(T) ([]byte, error)
+	decode   func([]byte) (T, error)
+
+	mu     sync.Mutex
+	keys   []string // Oldest first
+	seq    int
+	notify chan struct{} // Signalled when an event is pushed
+}
+
+// NewSpillQueue opens a queue holding at most maxItems events, picking up events left in the store
+func NewSpillQueue[T any](store clipstore.Store, maxItems int, encode func(T) ([]byte, error), decode func([]byte) (T, error)) (*SpillQueue[T], error) {
+	keys, err := store.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spill queue: %w", err)
+	}
+	if len(keys) > 0 {
+		log.Printf("Bus: Spill queue holds %d events from a previous run", len(keys))
+	}
+	return &SpillQueue[T]{
+		store:    store,
+		maxItems: maxItems,
+		encode:   encode,
+		decode:   decode,
+		keys:     keys,
+		notify:   make(chan struct{}, 1),
+	}, nil
+}
+
+// NewAudioSpillQueue opens a spill queue for audio recordings
+func NewAudioSpillQueue(store clipstore.Store, maxClips int) (*SpillQueue[*models.AudioRecording], error) {
+	return NewSpillQueue(store, maxClips, encodeAudio, decodeAudio)
+}
+
+// encodeAudio stores a recording as its JSON form, which carries the audio as base64
+func encodeAudio(recording *models.AudioRecording) ([]byte, error) {
+	stored := *recording
+	if stored.DataBase64 == "" {
+		stored.DataBase64 = base64.StdEncoding.EncodeToString(recording.Data)
+	}
+	return json.Marshal(&stored)
+}
+
+// decodeAudio restores a recording, including its raw audio bytes
+func decodeAudio(data []byte) (*models.AudioRecording, error) {
+	var recording models.AudioRecording
+	if err := json.Unmarshal(data, &recording); err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(recording.DataBase64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid audio data: %w", err)
+	}
+	recording.Data = raw
+	return &recording, nil
+}
+
+// Len returns the number of queued events
+func (q *SpillQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.keys)
+}
+
+// push appends an event; it returns false when the queue is full or the event can't be stored
+func (q *SpillQueue[T]) push(v T) bool {
+	data, err := q.encode(v)
+	if err != nil {
+		log.Printf("Warning: Could not encode event for spill queue: %v", err)
+		return false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.keys) >= q.maxItems {
+		return false
+	}
+	// Keys sort in push order, also across restarts
+	q.seq++
+	key := fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(), q.seq%1000000)
+	if err := q.store.Put(key, data); err != nil {
+		log.Printf("Warning: Could not write to spill queue: %v", err)
+		return false
+	}
+	q.keys = append(q.keys, key)
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// peek returns the oldest event without removing it. Events that can't be read back
+// are discarded, since they would block the queue forever.
+func (q *SpillQueue[T]) peek() (key string, v T, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.keys) > 0 {
+		key = q.keys[0]
+		data, err := q.store.Get(key)
+		if err == nil {
+			v, err = q.decode(data)
+			if err == nil {
+				return key, v, true
+			}
+		}
+		if !errors.Is(err, clipstore.ErrNotFound) {
+			log.Printf("Warning: Discarding unreadable spilled event %s: %v", key, err)
+			q.store.Delete(key)
+		}
+		q.keys = q.keys[1:]
+	}
+	return "", v, false
+}
+
+// remove deletes a delivered event
+func (q *SpillQueue[T]) remove(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.keys) > 0 && q.keys[0] == key {
+		q.keys = q.keys[1:]
+	}
+	if err := q.store.Delete(key); err != nil {
+		log.Printf("Warning: Could not remove spilled event %s: %v", key, err)
+	}
+}
@@ -0,0 +1,113 @@
+// Package clipstore persists raw audio clips as objects, so ingestion can accept a
+// clip as soon as it is stored and leave the expensive feature extraction to
+// background workers.
+//
+// Store is a minimal object store interface; FileStore keeps objects as files in a
+// directory, which also covers buckets mounted into the filesystem.
+package clipstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNotFound is returned by Get for a key without an object
+var ErrNotFound = errors.New("clip not found")
+
+// Store holds clip objects by key
+type Store interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+	List() ([]string, error) // Every key, in sorted order
+}
+
+// tmpSuffix marks objects still being written
+const tmpSuffix = ".tmp"
+
+// FileStore is a Store backed by a directory, one file per object
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates the directory if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create clip store directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the file of a key; keys must be plain file names
+func (s *FileStore) path(key string) (string, error) {
+	if key == "" || key != filepath.Base(key) || strings.HasPrefix(key, ".") || strings.HasSuffix(key, tmpSuffix) {
+		return "", fmt.Errorf("invalid clip key %q", key)
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+// Put writes an object; a crash never leaves a truncated one behind
+func (s *FileStore) Put(key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	tmp := path + tmpSuffix
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write clip %s: %w", key, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to store clip %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get reads an object
+func (s *FileStore) Get(key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clip %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// Delete removes an object; deleting a missing one is not an error
+func (s *FileStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete clip %s: %w", key, err)
+	}
+	return nil
+}
+
+// List returns the keys of every complete object
+func (s *FileStore) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clip store: %w", err)
+	}
+	var keys []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, tmpSuffix) {
+			keys = append(keys, name)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
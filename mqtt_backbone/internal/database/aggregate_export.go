@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// aggregateMetrics locates the metrics of aggregation-only exports: per-device
+// hourly rollups, never raw readings or anything derived from audio
+var aggregateMetrics = map[string]struct {
+	table string // Hourly table (hour, device_id, ...)
+	value string // Expression of a device's mean over the hours of a bucket
+	final string // FINAL for tables versioned by recomputation
+}{
+	"temperature": {table: "temperature_hourly", value: "avgMerge(temperature)"},
+	"humidity":    {table: "humidity_hourly", value: "avgMerge(humidity)"},
+	"window_open": {table: "window_usage_hourly", value: "sum(open_seconds) / sum(seconds)", final: "FINAL"},
+}
+
+// AggregateMetrics lists the metrics an aggregation-only export may contain
+func AggregateMetrics() []string {
+	return []string{"temperature", "humidity", "window_open"}
+}
+
+// DeviceBucketMean is one device's mean of a metric over a time bucket
+type DeviceBucketMean struct {
+	Bucket   time.Time
+	DeviceID string
+	Mean     float64
+}
+
+// GetDeviceBucketMeans returns each device's mean of a metric per hour or day
+// within [from, to), from the hourly rollups. window_open is the share of the
+// time the window was open.
+func (db *ClickHouseDB) GetDeviceBucketMeans(metric, bucket string, from, to time.Time) ([]DeviceBucketMean, error) {
+	ctx := context.Background()
+
+	m, ok := aggregateMetrics[metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregate metric %q", metric)
+	}
+	var bucketExpr string
+	switch bucket {
+	case "hour":
+		bucketExpr = "hour"
+	case "day":
+		bucketExpr = "toStartOfDay(hour)"
+	default:
+		return nil, fmt.Errorf("unknown bucket %q (must be hour or day)", bucket)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT toDateTime(%s) AS bucket, device_id, %s AS mean
+		FROM %s %s
+		WHERE hour >= ? AND hour < ? AND %s
+		GROUP BY bucket, device_id
+		HAVING isFinite(mean)
+		ORDER BY bucket, device_id
+	`, bucketExpr, m.value, m.table, m.final, db.fleetFilter("device_id"))
+
+	rows, err := db.read.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s bucket means: %w", metric, err)
+	}
+	defer rows.Close()
+
+	var means []DeviceBucketMean
+	for rows.Next() {
+		var mean DeviceBucketMean
+		if err := rows.Scan(&mean.Bucket, &mean.DeviceID, &mean.Mean); err != nil {
+			return nil, fmt.Errorf("failed to scan %s bucket mean: %w", metric, err)
+		}
+		means = append(means, mean)
+	}
+	return means, rows.Err()
+}
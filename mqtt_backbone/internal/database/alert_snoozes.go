@@ -0,0 +1,123 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"iot-backend/internal/models"
+)
+
+// SaveAlertSnooze inserts a snooze, or a new version of an existing one (e.g., after a status change)
+func (db *ClickHouseDB) SaveAlertSnooze(snooze *models.AlertSnooze) error {
+	ctx := context.Background()
+
+	query := `
+		INSERT INTO alert_snoozes (id, device_id, type, until, reason, created_by, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	err := db.conn.Exec(ctx, query,
+		snooze.ID,
+		snooze.DeviceID,
+		snooze.Type,
+		snooze.Until,
+		snooze.Reason,
+		snooze.CreatedBy,
+		snooze.Status,
+		snooze.CreatedAt,
+		snooze.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert alert snooze: %w", err)
+	}
+
+	return nil
+}
+
+// GetAlertSnoozes returns the latest version of snoozes, newest first. Empty status
+// selects every snooze. The write connection is used so a snooze created just
+// before a restart is never missed on a lagging replica.
+func (db *ClickHouseDB) GetAlertSnoozes(status string) ([]*models.AlertSnooze, error) {
+	ctx := context.Background()
+
+	query := `
+		SELECT * FROM (
+			SELECT id, device_id, type, until, reason, created_by, status, created_at, updated_at
+			FROM alert_snoozes FINAL
+		)
+		WHERE ? = '' OR status = ?
+		ORDER BY created_at DESC
+	`
+
+	rows, err := db.conn.Query(ctx, query, status, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alert snoozes: %w", err)
+	}
+	defer rows.Close()
+
+	var snoozes []*models.AlertSnooze
+	for rows.Next() {
+		var s models.AlertSnooze
+		if err := rows.Scan(&s.ID, &s.DeviceID, &s.Type, &s.Until, &s.Reason, &s.CreatedBy, &s.Status, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan alert snooze: %w", err)
+		}
+		snoozes = append(snoozes, &s)
+	}
+
+	return snoozes, rows.Err()
+}
+
+// SaveAlertSnoozeAudit records a change to a snooze
+func (db *ClickHouseDB) SaveAlertSnoozeAudit(entry *models.AlertSnoozeAudit) error {
+	ctx := context.Background()
+
+	query := `
+		INSERT INTO alert_snooze_audit (timestamp, snooze_id, action, device_id, type, until, actor, reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	err := db.conn.Exec(ctx, query,
+		entry.Timestamp,
+		entry.SnoozeID,
+		entry.Action,
+		entry.DeviceID,
+		entry.Type,
+		entry.Until,
+		entry.Actor,
+		entry.Reason,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert alert snooze audit entry: %w", err)
+	}
+
+	return nil
+}
+
+// GetAlertSnoozeAudit returns the audit entries of a snooze (empty snoozeID: of every snooze), newest first
+func (db *ClickHouseDB) GetAlertSnoozeAudit(snoozeID string) ([]models.AlertSnoozeAudit, error) {
+	ctx := context.Background()
+
+	query := `
+		SELECT timestamp, snooze_id, action, device_id, type, until, actor, reason
+		FROM alert_snooze_audit
+		WHERE ? = '' OR snooze_id = ?
+		ORDER BY timestamp DESC, snooze_id
+	`
+
+	rows, err := db.read.Query(ctx, query, snoozeID, snoozeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alert snooze audit: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.AlertSnoozeAudit{}
+	for rows.Next() {
+		var e models.AlertSnoozeAudit
+		if err := rows.Scan(&e.Timestamp, &e.SnoozeID, &e.Action, &e.DeviceID, &e.Type, &e.Until, &e.Actor, &e.Reason); err != nil {
+			return nil, fmt.Errorf("failed to scan alert snooze audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
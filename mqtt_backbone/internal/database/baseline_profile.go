@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// HourStats is the mean and standard deviation of one metric in one hour of the day
+type HourStats struct {
+	Mean    float64
+	StdDev  float64
+	Samples uint64
+}
+
+// BaselineProfile holds a device's readings by hour of the day (UTC, index 0-23),
+// so a change can be judged against the daily cycle instead of a flat spread
+type BaselineProfile struct {
+	Temperature [24]HourStats
+	Humidity    [24]HourStats
+	SoundVolume [24]HourStats
+}
+
+// GetBaselineProfile returns a device's per-hour-of-day means and standard deviations
+// over the last baselineDays. Hours without readings have zero samples.
+func (db *ClickHouseDB) GetBaselineProfile(deviceID string, baselineDays int) (*BaselineProfile, error) {
+	ctx := context.Background()
+
+	baselineStart := time.Now().Add(-time.Duration(baselineDays) * 24 * time.Hour)
+
+	query := `
+		SELECT metric, hour, avg(value), stddevPop(value), count()
+		FROM (
+			SELECT 'temperature' AS metric, toHour(timestamp, 'UTC') AS hour, value
+			FROM sensor_temperature
+			WHERE device_id = ? AND quality_flag != 'bad' AND NOT backfilled AND timestamp >= ?
+			UNION ALL
+			SELECT 'humidity', toHour(timestamp, 'UTC'), value
+			FROM sensor_humidity
+			WHERE device_id = ? AND quality_flag != 'bad' AND NOT backfilled AND timestamp >= ?
+			UNION ALL
+			SELECT 'sound_volume', toHour(timestamp, 'UTC'), sound_volume
+			FROM sensor_audio
+			WHERE device_id = ? AND quality_flag != 'bad' AND timestamp >= ?
+		)
+		GROUP BY metric, hour
+	`
+
+	rows, err := db.read.Query(ctx, query,
+		deviceID, baselineStart,
+		deviceID, baselineStart,
+		deviceID, baselineStart,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query baseline profile: %w", err)
+	}
+	defer rows.Close()
+
+	profile := &BaselineProfile{}
+	for rows.Next() {
+		var metric string
+		var hour uint8
+		var stats HourStats
+		if err := rows.Scan(&metric, &hour, &stats.Mean, &stats.StdDev, &stats.Samples); err != nil {
+			return nil, fmt.Errorf("failed to scan baseline profile: %w", err)
+		}
+		if hour > 23 {
+			continue
+		}
+		switch metric {
+		case "temperature":
+			profile.Temperature[hour] = stats
+		case "humidity":
+			profile.Humidity[hour] = stats
+		case "sound_volume":
+			profile.SoundVolume[hour] = stats
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read baseline profile: %w", err)
+	}
+	return profile, nil
+}
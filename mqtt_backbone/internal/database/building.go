@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+// WindowOpenAbove is the position (%) above which a window counts as open
+const WindowOpenAbove = 5.0
+
+// GetBuildingSummary rolls up temperature, window, and noise data across every
+// device since the start of the hour containing since. The queries read the
+// hourly rollup tables rather than raw readings.
+func (db *ClickHouseDB) GetBuildingSummary(since time.Time, rooms int) (*models.BuildingSummary, error) {
+	summary := &models.BuildingSummary{Since: since.Truncate(time.Hour)}
+
+	temperature, err := db.getBuildingTemperature(summary.Since)
+	if err != nil {
+		return nil, err
+	}
+	summary.Temperature = *temperature
+
+	windows, err := db.getBuildingWindows()
+	if err != nil {
+		return nil, err
+	}
+	summary.Windows = *windows
+
+	noisiest, err := db.GetNoisiestRooms(summary.Since, rooms)
+	if err != nil {
+		return nil, err
+	}
+	summary.NoisiestRooms = noisiest
+
+	return summary, nil
+}
+
+// getBuildingTemperature averages the per-room mean temperatures
+func (db *ClickHouseDB) getBuildingTemperature(since time.Time) (*models.BuildingTemperature, error) {
+	ctx := context.Background()
+
+	// Averages over no rooms are NaN, reported as 0 (Devices tells them apart)
+	query := `
+		SELECT ifNotFinite(avg(t), 0), ifNotFinite(min(t), 0), ifNotFinite(max(t), 0), count()
+		FROM (
+			SELECT device_id, avgMerge(temperature) AS t
+			FROM temperature_hourly
+			WHERE hour >= ? AND ` + db.fleetFilter("device_id") + `
+			GROUP BY device_id
+		)
+	`
+
+	var result models.BuildingTemperature
+	var devices uint64
+	row := db.read.QueryRow(ctx, query, since)
+	if err := row.Scan(&result.Average, &result.Min, &result.Max, &devices); err != nil {
+		return nil, fmt.Errorf("failed to query building temperature: %w", err)
+	}
+	result.Devices = int(devices)
+
+	return &result, nil
+}
+
+// getBuildingWindows counts windows whose last commanded position is open
+func (db *ClickHouseDB) getBuildingWindows() (*models.BuildingWindows, error) {
+	ctx := context.Background()
+
+	query := `
+		SELECT count(), countIf(position > ?)
+		FROM window_positions FINAL
+		WHERE ` + db.fleetFilter("device_id") + `
+	`
+
+	var total, open uint64
+	if err := db.read.QueryRow(ctx, query, WindowOpenAbove).Scan(&total, &open); err != nil {
+		return nil, fmt.Errorf("failed to query window positions: %w", err)
+	}
+
+	result := &models.BuildingWindows{Total: int(total), Open: int(open)}
+	if total > 0 {
+		result.OpenPercent = 100 * float64(open) / float64(total)
+	}
+	return result, nil
+}
+
+// GetNoisiestRooms returns the rooms with the highest mean sound volume since
+// the start of the hour containing since, loudest first
+func (db *ClickHouseDB) GetNoisiestRooms(since time.Time, limit int) ([]models.RoomNoise, error) {
+	ctx := context.Background()
+
+	query := `
+		SELECT n.device_id, r.name, r.location, n.volume, n.peak
+		FROM (
+			SELECT device_id, avgMerge(volume) AS volume, max(peak) AS peak
+			FROM noise_hourly
+			WHERE hour >= ? AND ` + db.fleetFilter("device_id") + `
+			GROUP BY device_id
+		) AS n
+		LEFT JOIN (
+			SELECT device_id, argMax(name, last_seen) AS name, argMax(location, last_seen) AS location
+			FROM device_registry
+			GROUP BY device_id
+		) AS r ON n.device_id = r.device_id
+		ORDER BY n.volume DESC
+		LIMIT ?
+	`
+
+	rows, err := db.read.Query(ctx, query, since.Truncate(time.Hour), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query noisiest rooms: %w", err)
+	}
+	defer rows.Close()
+
+	rooms := []models.RoomNoise{}
+	for rows.Next() {
+		var room models.RoomNoise
+		if err := rows.Scan(&room.DeviceID, &room.Name, &room.Location, &room.Volume, &room.Peak); err != nil {
+			return nil, fmt.Errorf("failed to scan room noise: %w", err)
+		}
+		rooms = append(rooms, room)
+	}
+
+	return rooms, rows.Err()
+}
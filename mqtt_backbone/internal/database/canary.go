@@ -0,0 +1,25 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// HasTemperatureReading reports whether a temperature reading of a value (to
+// within a thousandth of a degree) was stored for a device at or after since
+func (db *ClickHouseDB) HasTemperatureReading(deviceID string, value float64, since time.Time) (bool, error) {
+	ctx := context.Background()
+
+	query := `
+		SELECT count()
+		FROM sensor_temperature
+		WHERE device_id = ? AND timestamp >= ? AND abs(value - ?) < 0.0005
+	`
+
+	var count uint64
+	if err := db.read.QueryRow(ctx, query, deviceID, since, value).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to query temperature reading: %w", err)
+	}
+	return count > 0, nil
+}
@@ -2,71 +2,101 @@ package database
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
-	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"iot-backend/internal/models"
 )
 
 type ClickHouseDB struct {
-	conn driver.Conn
+	conn    *pooledConn // Writes, schema changes, and the device registry (read-your-writes)
+	read    *pooledConn // Aggregate and history queries
+	cluster ClusterConfig
+
+	failedFile *failedInsertFile // Fallback for rows failed_inserts can't take (nil = log only)
+	synthetic  []string          // Device IDs left out of fleet-wide queries
+
+	schemaConcurrency int // DDL statements InitSchema runs at once (0 = default)
 }
 
 // NewClickHouseDB creates a new ClickHouse database connection
 func NewClickHouseDB(addr, database, username, password string) (*ClickHouseDB, error) {
-	conn, err := clickhouse.Open(&clickhouse.Options{
-		Addr: []string{addr},
-		Auth: clickhouse.Auth{
-			Database: database,
-			Username: username,
-			Password: password,
-		},
-		Settings: clickhouse.Settings{
-			"max_execution_time": 60,
-		},
-		DialTimeout: 5 * time.Second,
-		Compression: &clickhouse.Compression{
-			Method: clickhouse.CompressionLZ4,
-		},
-	})
+	return NewClickHouseDBWithCluster(addr, database, username, password, ClusterConfig{}, PoolsConfig{})
+}
 
+// NewClickHouseDBWithCluster creates a new ClickHouse connection with optional cluster support.
+// addr may be a comma-separated list of hosts; the driver balances across them.
+func NewClickHouseDBWithCluster(addr, database, username, password string, cluster ClusterConfig, pools PoolsConfig) (*ClickHouseDB, error) {
+	db, err := OpenClickHouseDB(addr, database, username, password, cluster, pools)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to ClickHouse: %w", err)
+		return nil, err
 	}
 
-	if err := conn.Ping(context.Background()); err != nil {
-		return nil, fmt.Errorf("failed to ping ClickHouse: %w", err)
+	// Initialize schema
+	if err := db.InitSchema(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
 
-	log.Printf("Connected to ClickHouse at %s", addr)
+	return db, nil
+}
 
-	db := &ClickHouseDB{conn: conn}
+// OpenClickHouseDB connects the write and read pools without creating or migrating tables
+// (used by read-only checks such as the preflight)
+func OpenClickHouseDB(addr, database, username, password string, cluster ClusterConfig, pools PoolsConfig) (*ClickHouseDB, error) {
+	write, err := openPool("write", pools.Write, addr, database, username, password)
+	if err != nil {
+		return nil, err
+	}
 
-	// Initialize schema
-	if err := db.InitSchema(); err != nil {
-		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+	read, err := openPool("read", pools.Read, addr, database, username, password)
+	if err != nil {
+		write.Close()
+		return nil, err
 	}
 
+	log.Printf("Connected to ClickHouse at %s (writes) and %s (reads)", poolAddr(pools.Write, addr), poolAddr(pools.Read, addr))
+
+	db := &ClickHouseDB{conn: write, read: read, cluster: cluster}
+	write.onInsertError = db.recordFailedInsert
 	return db, nil
 }
 
-// InitSchema creates the necessary tables if they don't exist
-func (db *ClickHouseDB) InitSchema() error {
-	ctx := context.Background()
+// SetReadOnly makes every write a no-op, for observer instances that share the
+// database of an authoritative instance; queries are unaffected
+func (db *ClickHouseDB) SetReadOnly() {
+	db.conn.readOnly = true
+}
+
+// poolAddr returns the hosts a pool connects to
+func poolAddr(pool PoolConfig, addr string) string {
+	if pool.Addr != "" {
+		return pool.Addr
+	}
+	return addr
+}
 
-	// Create all tables from schema
-	tables := AllTables()
-	for _, tableSQL := range tables {
-		if err := db.conn.Exec(ctx, tableSQL); err != nil {
-			return fmt.Errorf("failed to create table: %w", err)
+// splitAddrs parses a comma-separated list of ClickHouse hosts
+func splitAddrs(addr string) []string {
+	var addrs []string
+	for _, a := range strings.Split(addr, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
 		}
 	}
+	return addrs
+}
 
-	log.Println("Database schema initialized successfully")
-	return nil
+// qualityOrDefault treats unscored readings as good quality
+func qualityOrDefault(score float64, flag string) (float64, string) {
+	if flag == "" {
+		return 1.0, models.QualityGood
+	}
+	return score, flag
 }
 
 // SaveTemperature saves a temperature reading to the database
@@ -74,14 +104,18 @@ func (db *ClickHouseDB) SaveTemperature(reading *models.TemperatureReading) erro
 	ctx := context.Background()
 
 	query := `
-		INSERT INTO sensor_temperature (timestamp, device_id, value)
-		VALUES (?, ?, ?)
+		INSERT INTO sensor_temperature (timestamp, device_id, value, quality_score, quality_flag, backfilled)
+		VALUES (?, ?, ?, ?, ?, ?)
 	`
 
+	score, flag := qualityOrDefault(reading.QualityScore, reading.QualityFlag)
 	err := db.conn.Exec(ctx, query,
 		reading.Timestamp,
 		reading.DeviceID,
 		reading.Value,
+		score,
+		flag,
+		reading.Backfilled,
 	)
 
 	if err != nil {
@@ -96,14 +130,18 @@ func (db *ClickHouseDB) SaveHumidity(reading *models.HumidityReading) error {
 	ctx := context.Background()
 
 	query := `
-		INSERT INTO sensor_humidity (timestamp, device_id, value)
-		VALUES (?, ?, ?)
+		INSERT INTO sensor_humidity (timestamp, device_id, value, quality_score, quality_flag, backfilled)
+		VALUES (?, ?, ?, ?, ?, ?)
 	`
 
+	score, flag := qualityOrDefault(reading.QualityScore, reading.QualityFlag)
 	err := db.conn.Exec(ctx, query,
 		reading.Timestamp,
 		reading.DeviceID,
 		reading.Value,
+		score,
+		flag,
+		reading.Backfilled,
 	)
 
 	if err != nil {
@@ -113,15 +151,76 @@ func (db *ClickHouseDB) SaveHumidity(reading *models.HumidityReading) error {
 	return nil
 }
 
+// SaveTemperatureBatch inserts many temperature readings in one block (used by bulk imports)
+func (db *ClickHouseDB) SaveTemperatureBatch(readings []*models.TemperatureReading) error {
+	ctx := context.Background()
+
+	batch, err := db.conn.PrepareBatch(ctx, "INSERT INTO sensor_temperature (timestamp, device_id, value, quality_score, quality_flag, backfilled)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare temperature batch: %w", err)
+	}
+
+	for _, reading := range readings {
+		score, flag := qualityOrDefault(reading.QualityScore, reading.QualityFlag)
+		if err := batch.Append(reading.Timestamp, reading.DeviceID, reading.Value, score, flag, reading.Backfilled); err != nil {
+			return fmt.Errorf("failed to append temperature reading: %w", err)
+		}
+	}
+
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to insert temperature batch: %w", err)
+	}
+
+	return nil
+}
+
+// SaveHumidityBatch inserts many humidity readings in one block (used by bulk imports)
+func (db *ClickHouseDB) SaveHumidityBatch(readings []*models.HumidityReading) error {
+	ctx := context.Background()
+
+	batch, err := db.conn.PrepareBatch(ctx, "INSERT INTO sensor_humidity (timestamp, device_id, value, quality_score, quality_flag, backfilled)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare humidity batch: %w", err)
+	}
+
+	for _, reading := range readings {
+		score, flag := qualityOrDefault(reading.QualityScore, reading.QualityFlag)
+		if err := batch.Append(reading.Timestamp, reading.DeviceID, reading.Value, score, flag, reading.Backfilled); err != nil {
+			return fmt.Errorf("failed to append humidity reading: %w", err)
+		}
+	}
+
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to insert humidity batch: %w", err)
+	}
+
+	return nil
+}
+
 // SaveAudio saves audio metadata to the database (not the raw audio data)
 func (db *ClickHouseDB) SaveAudio(recording *models.AudioRecording, audioHash string, soundVolume float64) error {
 	ctx := context.Background()
 
 	query := `
-		INSERT INTO sensor_audio (timestamp, device_id, sample_rate, duration, format, audio_hash, sound_volume, features)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO sensor_audio (timestamp, device_id, sample_rate, duration, format, audio_hash, sound_volume, features, quality_score, quality_flag, l10, l50, l90, anomaly_score, noise_floor, relative_volume, channels, channel_volumes, clipping_ratio, agc_compensation)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
+	featuresJSON := "{}"
+	if len(recording.Features) > 0 {
+		encoded, err := json.Marshal(recording.Features)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audio features: %w", err)
+		}
+		featuresJSON = string(encoded)
+	}
+
+	channelVolumes := recording.ChannelVolumes
+	if channelVolumes == nil {
+		channelVolumes = []float64{}
+	}
+
+	score, flag := qualityOrDefault(recording.QualityScore, recording.QualityFlag)
 	err := db.conn.Exec(ctx, query,
 		recording.Timestamp,
 		recording.DeviceID,
@@ -130,7 +229,19 @@ func (db *ClickHouseDB) SaveAudio(recording *models.AudioRecording, audioHash st
 		recording.Format,
 		audioHash,
 		soundVolume,
-		"{}", // Empty JSON for features (can be populated later)
+		featuresJSON,
+		score,
+		flag,
+		recording.L10,
+		recording.L50,
+		recording.L90,
+		recording.AnomalyScore,
+		recording.NoiseFloor,
+		recording.RelativeVolume,
+		uint8(max(recording.Channels, 1)),
+		channelVolumes,
+		recording.ClippingRatio,
+		recording.AGCCompensation,
 	)
 
 	if err != nil {
@@ -140,13 +251,123 @@ func (db *ClickHouseDB) SaveAudio(recording *models.AudioRecording, audioHash st
 	return nil
 }
 
+// GetHourlyBackgroundLevels returns a device's stored hourly L90 levels over the last hours,
+// used to restore noise floor calibration after a restart
+func (db *ClickHouseDB) GetHourlyBackgroundLevels(deviceID string, hours int) ([]float64, error) {
+	ctx := context.Background()
+
+	query := `
+		SELECT l90
+		FROM audio_levels_hourly
+		WHERE device_id = ? AND window_start >= ?
+		ORDER BY window_start
+	`
+
+	rows, err := db.read.Query(ctx, query, deviceID, time.Now().Add(-time.Duration(hours)*time.Hour))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hourly sound levels: %w", err)
+	}
+	defer rows.Close()
+
+	var levels []float64
+	for rows.Next() {
+		var l90 float64
+		if err := rows.Scan(&l90); err != nil {
+			return nil, fmt.Errorf("failed to scan hourly sound level: %w", err)
+		}
+		levels = append(levels, l90)
+	}
+
+	return levels, rows.Err()
+}
+
+// SaveAudioLevelWindow saves percentile sound levels for a device window
+func (db *ClickHouseDB) SaveAudioLevelWindow(window *models.AudioLevelWindow) error {
+	ctx := context.Background()
+
+	query := `
+		INSERT INTO audio_levels_hourly (window_start, device_id, l10, l50, l90, leq, frames)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	err := db.conn.Exec(ctx, query,
+		window.WindowStart,
+		window.DeviceID,
+		window.L10,
+		window.L50,
+		window.L90,
+		window.Leq,
+		uint32(window.Frames),
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert audio level window: %w", err)
+	}
+
+	return nil
+}
+
+// SaveQuarantinedReading saves a rejected reading to the quarantine table
+func (db *ClickHouseDB) SaveQuarantinedReading(reading *models.QuarantinedReading) error {
+	ctx := context.Background()
+
+	query := `
+		INSERT INTO sensor_quarantine (timestamp, device_id, metric, value, expected, reason)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	err := db.conn.Exec(ctx, query,
+		reading.Timestamp,
+		reading.DeviceID,
+		reading.Metric,
+		reading.Value,
+		reading.Expected,
+		reading.Reason,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert quarantined reading: %w", err)
+	}
+
+	return nil
+}
+
+// safetyWriteTimeout bounds the insert of a safety event, which runs on the
+// safety path and must not wait out a slow ClickHouse
+const safetyWriteTimeout = 2 * time.Second
+
+// SaveSafetyEvent saves a safety-critical event along with how long it took to process
+func (db *ClickHouseDB) SaveSafetyEvent(event *models.SafetyEvent, latency time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), safetyWriteTimeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO safety_events (timestamp, device_id, event_type, value, processing_latency_ms)
+		VALUES (?, ?, ?, ?, ?)
+	`
+
+	err := db.conn.Exec(ctx, query,
+		event.Timestamp,
+		event.DeviceID,
+		event.EventType,
+		event.Value,
+		float64(latency.Microseconds())/1000.0,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert safety event: %w", err)
+	}
+
+	return nil
+}
+
 // SaveWindowAction saves a window action decision to the database (updated for continuous control)
 func (db *ClickHouseDB) SaveWindowAction(action *models.WindowAction) error {
 	ctx := context.Background()
 
 	query := `
-		INSERT INTO window_actions (timestamp, device_id, position, confidence, temperature, humidity, sound_volume)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO window_actions (timestamp, device_id, position, confidence, temperature, humidity, sound_volume, dry_run)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	err := db.conn.Exec(ctx, query,
@@ -157,13 +378,327 @@ func (db *ClickHouseDB) SaveWindowAction(action *models.WindowAction) error {
 		action.Temperature,
 		action.Humidity,
 		action.SoundVolume,
+		action.DryRun,
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to insert window action: %w", err)
 	}
 
-	log.Printf("Saved window action to ClickHouse: Position=%.2f%%, DeviceID=%s", action.Position, action.DeviceID)
+	log.Printf("Saved window action to ClickHouse: Position=%.2f%%, DeviceID=%s, DryRun=%t", action.Position, action.DeviceID, action.DryRun)
+	return nil
+}
+
+// SaveWindowDecision saves the arbitration trace for a window command
+func (db *ClickHouseDB) SaveWindowDecision(decision *models.WindowDecision) error {
+	ctx := context.Background()
+
+	query := `
+		INSERT INTO window_decisions (timestamp, device_id, position, winner, summary, trace)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	err := db.conn.Exec(ctx, query,
+		decision.Timestamp,
+		decision.DeviceID,
+		decision.Position,
+		decision.Winner,
+		decision.Summary,
+		decision.Trace,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert window decision: %w", err)
+	}
+
+	return nil
+}
+
+// SaveDeadLetter saves a rejected message to the dead-letter table
+func (db *ClickHouseDB) SaveDeadLetter(letter *models.DeadLetter) error {
+	ctx := context.Background()
+
+	query := `
+		INSERT INTO dead_letters (timestamp, source, device_id, topic, payload, reason)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	err := db.conn.Exec(ctx, query,
+		letter.Timestamp,
+		letter.Source,
+		letter.DeviceID,
+		letter.Topic,
+		letter.Payload,
+		letter.Reason,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert dead letter: %w", err)
+	}
+
+	return nil
+}
+
+// SaveModelPrediction saves the response of an additional ML model
+func (db *ClickHouseDB) SaveModelPrediction(prediction *models.ModelPrediction) error {
+	ctx := context.Background()
+
+	query := `
+		INSERT INTO model_predictions (timestamp, device_id, model, correlation_id, prediction, confidence, label, model_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	err := db.conn.Exec(ctx, query,
+		prediction.Timestamp,
+		prediction.DeviceID,
+		prediction.Model,
+		prediction.CorrelationID,
+		prediction.Prediction,
+		prediction.Confidence,
+		prediction.Label,
+		prediction.ModelVersion,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert model prediction: %w", err)
+	}
+
+	return nil
+}
+
+// SaveFeatureSnapshot stores the payload of a published inference request
+func (db *ClickHouseDB) SaveFeatureSnapshot(snapshot *models.FeatureSnapshot) error {
+	ctx := context.Background()
+
+	query := `
+		INSERT INTO feature_snapshots (correlation_id, timestamp, device_id, topic, features)
+		VALUES (?, ?, ?, ?, ?)
+	`
+
+	err := db.conn.Exec(ctx, query,
+		snapshot.CorrelationID,
+		snapshot.Timestamp,
+		snapshot.DeviceID,
+		snapshot.Topic,
+		snapshot.Features,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert feature snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// GetFeatureSnapshot returns the feature payload sent with an inference request, or nil if none was stored
+func (db *ClickHouseDB) GetFeatureSnapshot(correlationID string) (*models.FeatureSnapshot, error) {
+	ctx := context.Background()
+
+	query := `
+		SELECT correlation_id, timestamp, device_id, topic, features
+		FROM feature_snapshots
+		WHERE correlation_id = ?
+		LIMIT 1
+	`
+
+	rows, err := db.read.Query(ctx, query, correlationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query feature snapshot: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	snapshot := &models.FeatureSnapshot{}
+	if err := rows.Scan(&snapshot.CorrelationID, &snapshot.Timestamp, &snapshot.DeviceID, &snapshot.Topic, &snapshot.Features); err != nil {
+		return nil, fmt.Errorf("failed to scan feature snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// SaveMLTimeout records an inference request that was never answered
+func (db *ClickHouseDB) SaveMLTimeout(timeout *models.MLTimeout) error {
+	ctx := context.Background()
+
+	query := `
+		INSERT INTO ml_timeouts (timestamp, correlation_id, device_id, topic, timeout_seconds)
+		VALUES (?, ?, ?, ?, ?)
+	`
+
+	err := db.conn.Exec(ctx, query,
+		timeout.Timestamp,
+		timeout.CorrelationID,
+		timeout.DeviceID,
+		timeout.Topic,
+		timeout.TimeoutSeconds,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert ml timeout: %w", err)
+	}
+
+	return nil
+}
+
+// SaveWindowCommandOutcome records how a window command ended
+func (db *ClickHouseDB) SaveWindowCommandOutcome(outcome *models.WindowCommandOutcome) error {
+	ctx := context.Background()
+
+	query := `
+		INSERT INTO window_command_outcomes (timestamp, device_id, command_id, commanded, reported, outcome, attempts, latency_ms, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	err := db.conn.Exec(ctx, query,
+		outcome.Timestamp,
+		outcome.DeviceID,
+		outcome.CommandID,
+		outcome.Commanded,
+		outcome.Reported,
+		outcome.Outcome,
+		uint8(outcome.Attempts),
+		uint32(outcome.LatencyMs),
+		outcome.Error,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert window command outcome: %w", err)
+	}
+
+	return nil
+}
+
+// SaveAudioFormatMismatch records a clip that failed the audio format checks
+func (db *ClickHouseDB) SaveAudioFormatMismatch(mismatch *models.AudioFormatMismatch) error {
+	ctx := context.Background()
+
+	query := `
+		INSERT INTO audio_format_mismatches (timestamp, device_id, issues, sample_rate, declared_duration, actual_duration, bytes)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	err := db.conn.Exec(ctx, query,
+		mismatch.Timestamp,
+		mismatch.DeviceID,
+		mismatch.Issues,
+		uint32(mismatch.SampleRate),
+		mismatch.DeclaredDuration,
+		mismatch.ActualDuration,
+		uint64(mismatch.Bytes),
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert audio format mismatch: %w", err)
+	}
+
+	return nil
+}
+
+// SaveMessageTrace saves a sampled MQTT message trace
+func (db *ClickHouseDB) SaveMessageTrace(trace *models.MessageTrace) error {
+	ctx := context.Background()
+
+	query := `
+		INSERT INTO message_traces (timestamp, trace_id, filter, topic, device_id, payload, payload_bytes, truncated, events, outcome, duration_us)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	err := db.conn.Exec(ctx, query,
+		trace.Timestamp,
+		trace.TraceID,
+		trace.Filter,
+		trace.Topic,
+		trace.DeviceID,
+		trace.Payload,
+		uint32(trace.PayloadBytes),
+		trace.Truncated,
+		trace.Events,
+		trace.Outcome,
+		uint64(trace.DurationUs),
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert message trace: %w", err)
+	}
+
+	return nil
+}
+
+// SaveMoldRisk saves a mold risk indicator sample to the database
+func (db *ClickHouseDB) SaveMoldRisk(risk *models.MoldRisk) error {
+	ctx := context.Background()
+
+	query := `
+		INSERT INTO mold_risk (timestamp, device_id, risk_index, hours_at_risk, temperature, humidity)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	err := db.conn.Exec(ctx, query,
+		risk.Timestamp,
+		risk.DeviceID,
+		risk.RiskIndex,
+		risk.HoursAtRisk,
+		risk.Temperature,
+		risk.Humidity,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert mold risk: %w", err)
+	}
+
+	return nil
+}
+
+// SaveOccupancy saves an occupancy estimate to the database
+func (db *ClickHouseDB) SaveOccupancy(occ *models.Occupancy) error {
+	ctx := context.Background()
+
+	query := `
+		INSERT INTO occupancy (timestamp, device_id, probability, occupied, sound_evidence, motion_evidence, co2_evidence, co2_slope)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	err := db.conn.Exec(ctx, query,
+		occ.Timestamp,
+		occ.DeviceID,
+		occ.Probability,
+		occ.Occupied,
+		occ.SoundEvidence,
+		occ.MotionEvidence,
+		occ.CO2Evidence,
+		occ.CO2Slope,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert occupancy: %w", err)
+	}
+
+	return nil
+}
+
+// SaveAlert saves a raised alert to the database
+func (db *ClickHouseDB) SaveAlert(alert *models.Alert) error {
+	ctx := context.Background()
+
+	query := `
+		INSERT INTO alerts (timestamp, device_id, type, severity, message, value)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	err := db.conn.Exec(ctx, query,
+		alert.Timestamp,
+		alert.DeviceID,
+		alert.Type,
+		alert.Severity,
+		alert.Message,
+		alert.Value,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert alert: %w", err)
+	}
+
 	return nil
 }
 
@@ -172,8 +707,8 @@ func (db *ClickHouseDB) SaveMLPrediction(prediction *models.MLPrediction) error
 	ctx := context.Background()
 
 	query := `
-		INSERT INTO ml_predictions (timestamp, device_id, prediction, confidence, inference_time_ms, model_version)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO ml_predictions (timestamp, device_id, prediction, confidence, inference_time_ms, model_version, model_variant)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
 	`
 
 	err := db.conn.Exec(ctx, query,
@@ -183,6 +718,7 @@ func (db *ClickHouseDB) SaveMLPrediction(prediction *models.MLPrediction) error
 		prediction.Confidence,
 		prediction.InferenceTimeMs,
 		prediction.ModelVersion,
+		prediction.ModelVariant,
 	)
 
 	if err != nil {
@@ -198,16 +734,24 @@ func (db *ClickHouseDB) UpsertDevice(device *models.Device) error {
 
 	// Convert config map to JSON string
 	configJSON := "{}"
-	if device.Config != nil {
-		// Simple JSON serialization (in production, use json.Marshal)
-		configJSON = "{}"
+	if len(device.Config) > 0 {
+		encoded, err := json.Marshal(device.Config)
+		if err != nil {
+			return fmt.Errorf("failed to marshal device config: %w", err)
+		}
+		configJSON = string(encoded)
 	}
 
 	query := `
-		INSERT INTO device_registry (device_id, name, location, registered_at, last_seen, is_active, config)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO device_registry (device_id, name, location, registered_at, last_seen, is_active, config, tags)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
+	tags := device.Tags
+	if tags == nil {
+		tags = map[string]string{}
+	}
+
 	err := db.conn.Exec(ctx, query,
 		device.DeviceID,
 		device.Name,
@@ -216,6 +760,7 @@ func (db *ClickHouseDB) UpsertDevice(device *models.Device) error {
 		device.LastSeen,
 		device.IsActive,
 		configJSON,
+		tags,
 	)
 
 	if err != nil {
@@ -225,6 +770,161 @@ func (db *ClickHouseDB) UpsertDevice(device *models.Device) error {
 	return nil
 }
 
+// latestDevicesSQL selects the latest row of every registry entry. The registry is a
+// ReplacingMergeTree, so until background merges run it holds older versions of a
+// device next to the current one; FINAL merges them at read time. Filters on columns
+// other than device_id belong outside this query, or they would match stale versions.
+const latestDevicesSQL = `
+	SELECT device_id, name, location, registered_at, last_seen, is_active, config, tags
+	FROM device_registry FINAL
+`
+
+// ListActiveDevices returns all active devices in the registry
+func (db *ClickHouseDB) ListActiveDevices() ([]models.Device, error) {
+	query := `
+		SELECT * FROM (` + latestDevicesSQL + `)
+		WHERE is_active
+		ORDER BY device_id
+	`
+	return db.queryDevices(query)
+}
+
+// ListDevices returns the latest registry row of every device, active or not
+func (db *ClickHouseDB) ListDevices() ([]models.Device, error) {
+	return db.queryDevices(latestDevicesSQL + ` ORDER BY device_id`)
+}
+
+// GetDevice returns a single device from the registry, or nil if it doesn't exist
+func (db *ClickHouseDB) GetDevice(deviceID string) (*models.Device, error) {
+	devices, err := db.queryDevices(latestDevicesSQL+` WHERE device_id = ?`, deviceID)
+	if err != nil || len(devices) == 0 {
+		return nil, err
+	}
+	return &devices[0], nil
+}
+
+// queryDevices runs a registry query and scans its rows
+func (db *ClickHouseDB) queryDevices(query string, args ...interface{}) ([]models.Device, error) {
+	ctx := context.Background()
+
+	rows, err := db.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query device registry: %w", err)
+	}
+	defer rows.Close()
+
+	var devices []models.Device
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, *device)
+	}
+
+	return devices, rows.Err()
+}
+
+// ListDevicesByTags returns active devices carrying every given tag
+func (db *ClickHouseDB) ListDevicesByTags(tags map[string]string) ([]models.Device, error) {
+	devices, err := db.ListActiveDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []models.Device
+	for _, device := range devices {
+		if HasTags(device.Tags, tags) {
+			matched = append(matched, device)
+		}
+	}
+	return matched, nil
+}
+
+// DeviceTags returns a device's registry tags (nil for unknown devices)
+func (db *ClickHouseDB) DeviceTags(deviceID string) (map[string]string, error) {
+	device, err := db.GetDevice(deviceID)
+	if err != nil || device == nil {
+		return nil, err
+	}
+	return device.Tags, nil
+}
+
+// HasTags reports whether tags contain every wanted key/value pair
+func HasTags(tags, wanted map[string]string) bool {
+	for k, v := range wanted {
+		if tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// GroupAggregates holds mean sensor values over a group of devices
+type GroupAggregates struct {
+	Devices     int     `json:"devices"`
+	Temperature float64 `json:"temperature"`
+	Humidity    float64 `json:"humidity"`
+	SoundVolume float64 `json:"sound_volume"`
+	Samples     uint64  `json:"samples"` // Readings and clips averaged
+}
+
+// GetGroupAggregates returns mean values over a window across several devices
+// (e.g., every device tagged floor=2)
+func (db *ClickHouseDB) GetGroupAggregates(deviceIDs []string, windowSeconds int) (*GroupAggregates, error) {
+	deviceIDs = db.fleetDevices(deviceIDs)
+	agg := &GroupAggregates{Devices: len(deviceIDs)}
+	if len(deviceIDs) == 0 {
+		return agg, nil
+	}
+
+	ctx := context.Background()
+	windowStart := time.Now().Add(-time.Duration(windowSeconds) * time.Second)
+
+	// Averages over no rows are NaN, reported as 0 (Samples tells them apart)
+	query := `
+		SELECT
+			(SELECT ifNotFinite(avg(value), 0) FROM sensor_temperature WHERE device_id IN ? AND quality_flag != 'bad' AND NOT backfilled AND timestamp >= ?) as avg_temp,
+			(SELECT ifNotFinite(avg(value), 0) FROM sensor_humidity WHERE device_id IN ? AND quality_flag != 'bad' AND NOT backfilled AND timestamp >= ?) as avg_humidity,
+			(SELECT ifNotFinite(avg(sound_volume), 0) FROM sensor_audio WHERE device_id IN ? AND quality_flag != 'bad' AND timestamp >= ?) as avg_volume,
+			(SELECT count() FROM sensor_temperature WHERE device_id IN ? AND quality_flag != 'bad' AND NOT backfilled AND timestamp >= ?) +
+			(SELECT count() FROM sensor_humidity WHERE device_id IN ? AND quality_flag != 'bad' AND NOT backfilled AND timestamp >= ?) +
+			(SELECT count() FROM sensor_audio WHERE device_id IN ? AND quality_flag != 'bad' AND timestamp >= ?) as samples
+	`
+
+	row := db.read.QueryRow(ctx, query,
+		deviceIDs, windowStart,
+		deviceIDs, windowStart,
+		deviceIDs, windowStart,
+		deviceIDs, windowStart,
+		deviceIDs, windowStart,
+		deviceIDs, windowStart,
+	)
+	if err := row.Scan(&agg.Temperature, &agg.Humidity, &agg.SoundVolume, &agg.Samples); err != nil {
+		return nil, fmt.Errorf("failed to query group aggregates: %w", err)
+	}
+
+	return agg, nil
+}
+
+// scanDevice scans a device_registry row, decoding its JSON config
+func scanDevice(rows driver.Rows) (*models.Device, error) {
+	var device models.Device
+	var configJSON string
+	if err := rows.Scan(&device.DeviceID, &device.Name, &device.Location,
+		&device.RegisteredAt, &device.LastSeen, &device.IsActive, &configJSON, &device.Tags); err != nil {
+		return nil, fmt.Errorf("failed to scan device: %w", err)
+	}
+
+	device.Config = make(map[string]interface{})
+	if configJSON != "" {
+		if err := json.Unmarshal([]byte(configJSON), &device.Config); err != nil {
+			log.Printf("Warning: invalid config JSON for device %s: %v", device.DeviceID, err)
+		}
+	}
+	return &device, nil
+}
+
 // SensorAggregates holds aggregated sensor values for a time window
 type SensorAggregates struct {
 	Temperature float64
@@ -240,13 +940,15 @@ type SensorStdDevs struct {
 	SoundVolume float64
 }
 
-// SaveInferenceHistory records when an inference was triggered
-func (db *ClickHouseDB) SaveInferenceHistory(deviceID string, triggerReason string, tempZ, humidityZ, volumeZ float64) error {
+// SaveInferenceHistory records when an inference was triggered, and for which domain
+// (empty without inference domains). A cached inference reused an earlier response
+// instead of asking the ML service.
+func (db *ClickHouseDB) SaveInferenceHistory(deviceID, domain string, triggerReason string, tempZ, humidityZ, volumeZ float64, correlationID string, cached bool) error {
 	ctx := context.Background()
 
 	query := `
-		INSERT INTO inference_history (timestamp, device_id, trigger_reason, temp_z_score, humidity_z_score, volume_z_score)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO inference_history (timestamp, device_id, trigger_reason, temp_z_score, humidity_z_score, volume_z_score, correlation_id, domain, cached)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	err := db.conn.Exec(ctx, query,
@@ -256,6 +958,9 @@ func (db *ClickHouseDB) SaveInferenceHistory(deviceID string, triggerReason stri
 		tempZ,
 		humidityZ,
 		volumeZ,
+		correlationID,
+		domain,
+		cached,
 	)
 
 	if err != nil {
@@ -265,20 +970,20 @@ func (db *ClickHouseDB) SaveInferenceHistory(deviceID string, triggerReason stri
 	return nil
 }
 
-// GetLastInferenceTimestamp returns the timestamp of the last inference for a device
-func (db *ClickHouseDB) GetLastInferenceTimestamp(deviceID string) (time.Time, error) {
+// GetLastInferenceTimestamp returns the timestamp of the last inference for a device in a domain
+func (db *ClickHouseDB) GetLastInferenceTimestamp(deviceID, domain string) (time.Time, error) {
 	ctx := context.Background()
 
 	query := `
 		SELECT timestamp
 		FROM inference_history
-		WHERE device_id = ?
+		WHERE device_id = ? AND domain = ?
 		ORDER BY timestamp DESC
 		LIMIT 1
 	`
 
 	var timestamp time.Time
-	row := db.conn.QueryRow(ctx, query, deviceID)
+	row := db.read.QueryRow(ctx, query, deviceID, domain)
 	err := row.Scan(&timestamp)
 	if err != nil {
 		// No previous inference found
@@ -302,15 +1007,15 @@ func (db *ClickHouseDB) GetCurrentWindowAggregates(deviceID string, windowSecond
 			avg(audio.sound_volume) as avg_volume,
 			count(*) as total_count
 		FROM
-			(SELECT value FROM sensor_temperature WHERE device_id = ? AND timestamp >= ?) as temp,
-			(SELECT value FROM sensor_humidity WHERE device_id = ? AND timestamp >= ?) as hum,
-			(SELECT sound_volume FROM sensor_audio WHERE device_id = ? AND timestamp >= ?) as audio
+			(SELECT value FROM sensor_temperature WHERE device_id = ? AND quality_flag != 'bad' AND NOT backfilled AND timestamp >= ?) as temp,
+			(SELECT value FROM sensor_humidity WHERE device_id = ? AND quality_flag != 'bad' AND NOT backfilled AND timestamp >= ?) as hum,
+			(SELECT sound_volume FROM sensor_audio WHERE device_id = ? AND quality_flag != 'bad' AND timestamp >= ?) as audio
 	`
 
 	var avgTemp, avgHumidity, avgVolume float64
 	var totalCount uint64
 
-	row := db.conn.QueryRow(ctx, query,
+	row := db.read.QueryRow(ctx, query,
 		deviceID, windowStart,
 		deviceID, windowStart,
 		deviceID, windowStart,
@@ -342,15 +1047,15 @@ func (db *ClickHouseDB) GetLastInferenceWindowAggregates(deviceID string, lastIn
 			avg(audio.sound_volume) as avg_volume,
 			count(*) as total_count
 		FROM
-			(SELECT value FROM sensor_temperature WHERE device_id = ? AND timestamp >= ? AND timestamp <= ?) as temp,
-			(SELECT value FROM sensor_humidity WHERE device_id = ? AND timestamp >= ? AND timestamp <= ?) as hum,
-			(SELECT sound_volume FROM sensor_audio WHERE device_id = ? AND timestamp >= ? AND timestamp <= ?) as audio
+			(SELECT value FROM sensor_temperature WHERE device_id = ? AND quality_flag != 'bad' AND NOT backfilled AND timestamp >= ? AND timestamp <= ?) as temp,
+			(SELECT value FROM sensor_humidity WHERE device_id = ? AND quality_flag != 'bad' AND NOT backfilled AND timestamp >= ? AND timestamp <= ?) as hum,
+			(SELECT sound_volume FROM sensor_audio WHERE device_id = ? AND quality_flag != 'bad' AND timestamp >= ? AND timestamp <= ?) as audio
 	`
 
 	var avgTemp, avgHumidity, avgVolume float64
 	var totalCount uint64
 
-	row := db.conn.QueryRow(ctx, query,
+	row := db.read.QueryRow(ctx, query,
 		deviceID, windowStart, lastInferenceTime,
 		deviceID, windowStart, lastInferenceTime,
 		deviceID, windowStart, lastInferenceTime,
@@ -381,14 +1086,14 @@ func (db *ClickHouseDB) GetHistoricalBaselineStats(deviceID string, baselineDays
 			stddevPop(hum.value) as std_humidity,
 			stddevPop(audio.sound_volume) as std_volume
 		FROM
-			(SELECT value FROM sensor_temperature WHERE device_id = ? AND timestamp >= ?) as temp,
-			(SELECT value FROM sensor_humidity WHERE device_id = ? AND timestamp >= ?) as hum,
-			(SELECT sound_volume FROM sensor_audio WHERE device_id = ? AND timestamp >= ?) as audio
+			(SELECT value FROM sensor_temperature WHERE device_id = ? AND quality_flag != 'bad' AND NOT backfilled AND timestamp >= ?) as temp,
+			(SELECT value FROM sensor_humidity WHERE device_id = ? AND quality_flag != 'bad' AND NOT backfilled AND timestamp >= ?) as hum,
+			(SELECT sound_volume FROM sensor_audio WHERE device_id = ? AND quality_flag != 'bad' AND timestamp >= ?) as audio
 	`
 
 	var stdTemp, stdHumidity, stdVolume float64
 
-	row := db.conn.QueryRow(ctx, query,
+	row := db.read.QueryRow(ctx, query,
 		deviceID, baselineStart,
 		deviceID, baselineStart,
 		deviceID, baselineStart,
@@ -405,8 +1110,231 @@ func (db *ClickHouseDB) GetHistoricalBaselineStats(deviceID string, baselineDays
 	}, nil
 }
 
+// GetBaselineSampleCount returns the number of baseline samples available for a device.
+// The smaller of the temperature and humidity counts is returned, since z-scores
+// need a meaningful std dev for every metric.
+func (db *ClickHouseDB) GetBaselineSampleCount(deviceID string, baselineDays int) (uint64, error) {
+	ctx := context.Background()
+
+	baselineStart := time.Now().Add(-time.Duration(baselineDays) * 24 * time.Hour)
+
+	query := `
+		SELECT
+			(SELECT count() FROM sensor_temperature WHERE device_id = ? AND quality_flag != 'bad' AND NOT backfilled AND timestamp >= ?) as temp_count,
+			(SELECT count() FROM sensor_humidity WHERE device_id = ? AND quality_flag != 'bad' AND NOT backfilled AND timestamp >= ?) as humidity_count
+	`
+
+	var tempCount, humidityCount uint64
+	row := db.read.QueryRow(ctx, query,
+		deviceID, baselineStart,
+		deviceID, baselineStart,
+	)
+	if err := row.Scan(&tempCount, &humidityCount); err != nil {
+		return 0, fmt.Errorf("failed to count baseline samples: %w", err)
+	}
+
+	if humidityCount < tempCount {
+		return humidityCount, nil
+	}
+	return tempCount, nil
+}
+
+// TrainingQuery selects the period and granularity of a training dataset
+type TrainingQuery struct {
+	From            time.Time
+	To              time.Time
+	WindowSeconds   int    // Sensor data averaged over this window before each label
+	DeviceID        string // Optional, empty for all devices
+	FeedbackSeconds int    // A manual override this soon after an action labels it as overridden
+}
+
+// trainingWeatherMaxAge bounds how old the last outdoor temperature, rain or wind
+// report before an action may be to enrich it, like OutdoorMaxAge in inference
+const trainingWeatherMaxAge = time.Hour
+
+// TrainingRow is one labeled example: the conditions leading up to a window action
+type TrainingRow struct {
+	Timestamp   time.Time
+	DeviceID    string
+	Temperature float64
+	Humidity    float64
+	SoundVolume float64
+	Confidence  float64
+	Position    float64 // Label: window position chosen
+
+	// Weather from the device's latest safety events before the action; nil when none is recent
+	OutdoorTemperature *float64
+	Rain               *float64
+	Wind               *float64
+
+	// User feedback: the position of a manual override following the action within
+	// FeedbackSeconds; nil when the user left the action alone
+	OverridePosition *float64
+
+	// Lag features at the label's timestamp by name (see LagFeatureNames); missing ones are absent
+	Lags map[string]float64
+}
+
+// GetTrainingRows joins each window action with the sensor aggregates preceding it.
+// Sensor values are averaged into WindowSeconds buckets and matched with ASOF joins,
+// so every label gets the most recent bucket that ended at or before its timestamp;
+// readings taken after the action never leak into its features.
+// Low-quality samples are excluded, matching inference feature building. Lag
+// features come from lag_features under the same rules as in inference requests.
+// Weather comes from the safety_events the device reported before the action, and
+// feedback from the first manual override decided after it.
+func (db *ClickHouseDB) GetTrainingRows(q TrainingQuery) ([]TrainingRow, error) {
+	ctx := context.Background()
+
+	if q.WindowSeconds <= 0 {
+		q.WindowSeconds = 120
+	}
+	if q.FeedbackSeconds <= 0 {
+		q.FeedbackSeconds = 1800
+	}
+	// Include two extra windows before From so the first labels have a finished bucket
+	dataStart := q.From.Add(-2 * time.Duration(q.WindowSeconds) * time.Second)
+
+	query := `
+		SELECT
+			a.timestamp,
+			a.device_id,
+			t.temperature,
+			h.humidity,
+			v.sound_volume,
+			a.confidence,
+			a.position,
+			if(dateDiff('second', wo.timestamp, a.timestamp) <= ?, wo.value, NULL),
+			if(dateDiff('second', wr.timestamp, a.timestamp) <= ?, wr.value, NULL),
+			if(dateDiff('second', ww.timestamp, a.timestamp) <= ?, ww.value, NULL),
+			if(m.timestamp > a.timestamp AND dateDiff('second', a.timestamp, m.timestamp) <= ?, m.position, NULL),
+			if(dateDiff('second', lt.available, a.timestamp) <= ?, lt.lag_15m, NULL),
+			if(dateDiff('second', lt.available, a.timestamp) <= ?, lt.lag_1h, NULL),
+			if(dateDiff('second', lt.available, a.timestamp) <= ?, lt.lag_24h, NULL),
+			if(dateDiff('second', lh.available, a.timestamp) <= ?, lh.lag_15m, NULL),
+			if(dateDiff('second', lh.available, a.timestamp) <= ?, lh.lag_1h, NULL),
+			if(dateDiff('second', lh.available, a.timestamp) <= ?, lh.lag_24h, NULL),
+			if(dateDiff('second', lv.available, a.timestamp) <= ?, lv.lag_15m, NULL),
+			if(dateDiff('second', lv.available, a.timestamp) <= ?, lv.lag_1h, NULL),
+			if(dateDiff('second', lv.available, a.timestamp) <= ?, lv.lag_24h, NULL)
+		FROM (
+			SELECT timestamp, device_id, position, confidence
+			FROM window_actions
+			WHERE timestamp >= ? AND timestamp <= ? AND (? = '' OR device_id = ?)
+		) AS a
+		ASOF LEFT JOIN (
+			SELECT device_id, toDateTime64(toStartOfInterval(timestamp, toIntervalSecond(?)) + toIntervalSecond(?), 3) AS available, avg(value) AS temperature
+			FROM sensor_temperature
+			WHERE quality_flag != 'bad' AND timestamp >= ? AND timestamp <= ?
+			GROUP BY device_id, available
+		) AS t ON a.device_id = t.device_id AND a.timestamp >= t.available
+		ASOF LEFT JOIN (
+			SELECT device_id, toDateTime64(toStartOfInterval(timestamp, toIntervalSecond(?)) + toIntervalSecond(?), 3) AS available, avg(value) AS humidity
+			FROM sensor_humidity
+			WHERE quality_flag != 'bad' AND timestamp >= ? AND timestamp <= ?
+			GROUP BY device_id, available
+		) AS h ON a.device_id = h.device_id AND a.timestamp >= h.available
+		ASOF LEFT JOIN (
+			SELECT device_id, toDateTime64(toStartOfInterval(timestamp, toIntervalSecond(?)) + toIntervalSecond(?), 3) AS available, avg(sound_volume) AS sound_volume
+			FROM sensor_audio
+			WHERE quality_flag != 'bad' AND timestamp >= ? AND timestamp <= ?
+			GROUP BY device_id, available
+		) AS v ON a.device_id = v.device_id AND a.timestamp >= v.available
+		ASOF LEFT JOIN (
+			SELECT device_id, timestamp, value
+			FROM safety_events
+			WHERE event_type = 'outdoor_temperature' AND timestamp >= ? AND timestamp <= ?
+		) AS wo ON a.device_id = wo.device_id AND a.timestamp >= wo.timestamp
+		ASOF LEFT JOIN (
+			SELECT device_id, timestamp, value
+			FROM safety_events
+			WHERE event_type = 'rain' AND timestamp >= ? AND timestamp <= ?
+		) AS wr ON a.device_id = wr.device_id AND a.timestamp >= wr.timestamp
+		ASOF LEFT JOIN (
+			SELECT device_id, timestamp, value
+			FROM safety_events
+			WHERE event_type = 'wind' AND timestamp >= ? AND timestamp <= ?
+		) AS ww ON a.device_id = ww.device_id AND a.timestamp >= ww.timestamp
+		ASOF LEFT JOIN (
+			SELECT device_id, timestamp, position
+			FROM window_decisions
+			WHERE winner = 'manual' AND timestamp > ? AND timestamp <= ?
+		) AS m ON a.device_id = m.device_id AND a.timestamp < m.timestamp
+		ASOF LEFT JOIN (
+			SELECT device_id, toDateTime64(timestamp + INTERVAL 1 MINUTE, 3) AS available, lag_15m, lag_1h, lag_24h
+			FROM lag_features FINAL
+			WHERE metric = 'temperature' AND timestamp >= ? AND timestamp <= ?
+		) AS lt ON a.device_id = lt.device_id AND a.timestamp >= lt.available
+		ASOF LEFT JOIN (
+			SELECT device_id, toDateTime64(timestamp + INTERVAL 1 MINUTE, 3) AS available, lag_15m, lag_1h, lag_24h
+			FROM lag_features FINAL
+			WHERE metric = 'humidity' AND timestamp >= ? AND timestamp <= ?
+		) AS lh ON a.device_id = lh.device_id AND a.timestamp >= lh.available
+		ASOF LEFT JOIN (
+			SELECT device_id, toDateTime64(timestamp + INTERVAL 1 MINUTE, 3) AS available, lag_15m, lag_1h, lag_24h
+			FROM lag_features FINAL
+			WHERE metric = 'sound_volume' AND timestamp >= ? AND timestamp <= ?
+		) AS lv ON a.device_id = lv.device_id AND a.timestamp >= lv.available
+		ORDER BY a.device_id, a.timestamp
+	`
+
+	weatherAge := int(trainingWeatherMaxAge.Seconds())
+	weatherStart := q.From.Add(-trainingWeatherMaxAge)
+	feedbackEnd := q.To.Add(time.Duration(q.FeedbackSeconds) * time.Second)
+	maxAge := int(LagFeatureMaxAge.Seconds())
+	lagStart := q.From.Add(-LagFeatureMaxAge - time.Minute)
+	args := []interface{}{weatherAge, weatherAge, weatherAge, q.FeedbackSeconds}
+	args = append(args, maxAge, maxAge, maxAge, maxAge, maxAge, maxAge, maxAge, maxAge, maxAge)
+	args = append(args,
+		q.From, q.To, q.DeviceID, q.DeviceID,
+		q.WindowSeconds, q.WindowSeconds, dataStart, q.To,
+		q.WindowSeconds, q.WindowSeconds, dataStart, q.To,
+		q.WindowSeconds, q.WindowSeconds, dataStart, q.To,
+		weatherStart, q.To,
+		weatherStart, q.To,
+		weatherStart, q.To,
+		q.From, feedbackEnd,
+		lagStart, q.To,
+		lagStart, q.To,
+		lagStart, q.To,
+	)
+	rows, err := db.read.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query training rows: %w", err)
+	}
+	defer rows.Close()
+
+	var result []TrainingRow
+	for rows.Next() {
+		var r TrainingRow
+		lags := make([]*float64, len(lagMetrics)*len(lagOffsets))
+		dest := []interface{}{&r.Timestamp, &r.DeviceID, &r.Temperature, &r.Humidity, &r.SoundVolume, &r.Confidence, &r.Position,
+			&r.OutdoorTemperature, &r.Rain, &r.Wind, &r.OverridePosition}
+		for i := range lags {
+			dest = append(dest, &lags[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan training row: %w", err)
+		}
+		r.Lags = make(map[string]float64)
+		for i, name := range LagFeatureNames() {
+			if lags[i] != nil {
+				r.Lags[name] = *lags[i]
+			}
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
 // Close closes the ClickHouse connection
 func (db *ClickHouseDB) Close() error {
+	if db.read != nil {
+		if err := db.read.Close(); err != nil {
+			return fmt.Errorf("failed to close ClickHouse read pool: %w", err)
+		}
+	}
 	if db.conn != nil {
 		if err := db.conn.Close(); err != nil {
 			return fmt.Errorf("failed to close ClickHouse connection: %w", err)
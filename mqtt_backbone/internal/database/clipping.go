@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+// clippingBuckets is the number of buckets in a clipping report at the automatic resolution
+const clippingBuckets = 96
+
+// GetClippingRates buckets how often a device's clips clipped over [from, to), in
+// buckets of resolution (0 = the range split in clippingBuckets, at least a minute)
+func (db *ClickHouseDB) GetClippingRates(deviceID string, from, to time.Time, resolution time.Duration) (*models.ClippingReport, error) {
+	ctx := context.Background()
+
+	if resolution <= 0 {
+		resolution = to.Sub(from) / clippingBuckets
+		if resolution < time.Minute {
+			resolution = time.Minute
+		}
+	}
+	report := &models.ClippingReport{
+		DeviceID:   deviceID,
+		From:       from,
+		To:         to,
+		Resolution: int(resolution / time.Second),
+		Buckets:    []models.ClippingBucket{},
+	}
+
+	query := `
+		SELECT
+			toDateTime(toStartOfInterval(timestamp, toIntervalSecond(?))) AS bucket,
+			count(),
+			countIf(clipping_ratio > 0),
+			avg(clipping_ratio),
+			max(clipping_ratio)
+		FROM sensor_audio
+		WHERE device_id = ? AND timestamp >= ? AND timestamp < ?
+		GROUP BY bucket
+		ORDER BY bucket
+	`
+
+	rows, err := db.read.Query(ctx, query, report.Resolution, deviceID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query clipping rates: %w", err)
+	}
+	defer rows.Close()
+
+	clipped := 0
+	for rows.Next() {
+		var b models.ClippingBucket
+		var clips, clippedClips uint64
+		if err := rows.Scan(&b.Timestamp, &clips, &clippedClips, &b.MeanRatio, &b.MaxRatio); err != nil {
+			return nil, fmt.Errorf("failed to scan clipping rates: %w", err)
+		}
+		b.Clips, b.ClippedClips = int(clips), int(clippedClips)
+		report.Clips += b.Clips
+		clipped += b.ClippedClips
+		report.Buckets = append(report.Buckets, b)
+	}
+	if report.Clips > 0 {
+		report.Rate = float64(clipped) / float64(report.Clips)
+	}
+
+	return report, rows.Err()
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ClusterConfig holds settings for running against a multi-node ClickHouse cluster.
+// When Cluster is empty, tables are created as plain single-host MergeTree tables.
+type ClusterConfig struct {
+	Cluster       string // Cluster name from remote_servers (e.g., "iot_cluster")
+	ZooKeeperPath string // Replication path template (e.g., "/clickhouse/tables/{shard}/{database}/{table}")
+	ReplicaName   string // Replica name macro (e.g., "{replica}")
+}
+
+// Enabled reports whether cluster (replicated + distributed) tables should be used
+func (c ClusterConfig) Enabled() bool {
+	return c.Cluster != ""
+}
+
+var createTableRe = regexp.MustCompile(`CREATE TABLE IF NOT EXISTS (\w+) \(`)
+var replacingEngineRe = regexp.MustCompile(`ENGINE = ReplacingMergeTree\((\w*)\)`)
+
+// ClusterTables converts the single-host table definitions into their cluster form.
+// Each table becomes a "<name>_local" ReplicatedMergeTree table on every node plus a
+// Distributed table under the original name, so inserts and queries are unchanged.
+// Rows are sharded by device_id so per-device aggregates stay on a single shard.
+func ClusterTables(tables []string, cluster ClusterConfig) ([]string, error) {
+	zkPath := cluster.ZooKeeperPath
+	if zkPath == "" {
+		zkPath = "/clickhouse/tables/{shard}/{database}/{table}"
+	}
+	replica := cluster.ReplicaName
+	if replica == "" {
+		replica = "{replica}"
+	}
+
+	statements := make([]string, 0, len(tables)*2)
+	for _, tableSQL := range tables {
+		match := createTableRe.FindStringSubmatch(tableSQL)
+		if match == nil {
+			return nil, fmt.Errorf("cannot determine table name for cluster schema: %.60s", strings.TrimSpace(tableSQL))
+		}
+		name := match[1]
+		local := name + "_local"
+
+		localSQL := strings.Replace(tableSQL, match[0],
+			fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ON CLUSTER %s (", local, cluster.Cluster), 1)
+
+		replicatedArgs := fmt.Sprintf("'%s', '%s'", zkPath, replica)
+		if replacingEngineRe.MatchString(localSQL) {
+			localSQL = replacingEngineRe.ReplaceAllStringFunc(localSQL, func(engine string) string {
+				version := replacingEngineRe.FindStringSubmatch(engine)[1]
+				if version == "" {
+					return fmt.Sprintf("ENGINE = ReplicatedReplacingMergeTree(%s)", replicatedArgs)
+				}
+				return fmt.Sprintf("ENGINE = ReplicatedReplacingMergeTree(%s, %s)", replicatedArgs, version)
+			})
+		} else if strings.Contains(localSQL, "ENGINE = AggregatingMergeTree()") {
+			localSQL = strings.Replace(localSQL, "ENGINE = AggregatingMergeTree()",
+				fmt.Sprintf("ENGINE = ReplicatedAggregatingMergeTree(%s)", replicatedArgs), 1)
+		} else {
+			localSQL = strings.Replace(localSQL, "ENGINE = MergeTree()",
+				fmt.Sprintf("ENGINE = ReplicatedMergeTree(%s)", replicatedArgs), 1)
+		}
+
+		distributedSQL := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s ON CLUSTER %s AS %s
+		ENGINE = Distributed(%s, currentDatabase(), %s, cityHash64(device_id))
+	`, name, cluster.Cluster, local, cluster.Cluster, local)
+
+		statements = append(statements, localSQL, distributedSQL)
+	}
+
+	return statements, nil
+}
+
+// ClusterViews creates each materialized view on every node, reading the local source
+// table and writing the local rollup table, so rollups stay sharded like their sources
+func ClusterViews(views []MaterializedView, cluster ClusterConfig) []string {
+	statements := make([]string, 0, len(views))
+	for _, v := range views {
+		statements = append(statements, fmt.Sprintf("CREATE MATERIALIZED VIEW IF NOT EXISTS %s ON CLUSTER %s TO %s_local AS %s",
+			v.Name, cluster.Cluster, v.Target, v.SelectSQL(v.Source+"_local", "")))
+	}
+	return statements
+}
+
+// ClusterMigrations converts migrations into ALTER statements for both the
+// replicated local table and the Distributed table in front of it
+func ClusterMigrations(migrations []Migration, cluster ClusterConfig) []string {
+	statements := make([]string, 0, len(migrations)*2)
+	for _, m := range migrations {
+		statements = append(statements,
+			fmt.Sprintf("ALTER TABLE %s_local ON CLUSTER %s %s", m.Table, cluster.Cluster, m.Change),
+			fmt.Sprintf("ALTER TABLE %s ON CLUSTER %s %s", m.Table, cluster.Cluster, m.Change),
+		)
+	}
+	return statements
+}
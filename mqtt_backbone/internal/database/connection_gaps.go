@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+// SaveConnectionGaps inserts connection gaps, or their closed versions, in one block
+func (db *ClickHouseDB) SaveConnectionGaps(gaps []*models.ConnectionGap) error {
+	ctx := context.Background()
+
+	batch, err := db.conn.PrepareBatch(ctx, "INSERT INTO connection_gaps (device_id, stream, topic, start, end, reason)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare connection gaps batch: %w", err)
+	}
+
+	for _, g := range gaps {
+		var end time.Time
+		if g.End != nil {
+			end = *g.End
+		}
+		if err := batch.Append("", g.Stream, g.Topic, g.Start, end, g.Reason); err != nil {
+			return fmt.Errorf("failed to append connection gap: %w", err)
+		}
+	}
+
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to insert connection gaps: %w", err)
+	}
+
+	return nil
+}
+
+// GetConnectionGaps returns the gaps overlapping [from, to), oldest first, on the
+// given streams (every stream when none are given)
+func (db *ClickHouseDB) GetConnectionGaps(from, to time.Time, streams ...string) ([]models.ConnectionGap, error) {
+	ctx := context.Background()
+
+	args := []interface{}{to}
+	streamFilter := ""
+	if len(streams) > 0 {
+		streamFilter = " AND stream IN ?"
+		args = append(args, streams)
+	}
+	args = append(args, from)
+
+	// end is not part of the key, so it is filtered after FINAL picked the latest version
+	query := fmt.Sprintf(`
+		SELECT stream, topic, start, end, reason
+		FROM (
+			SELECT stream, topic, start, end, reason
+			FROM connection_gaps FINAL
+			WHERE device_id = '' AND start < ?%s
+		)
+		WHERE end = toDateTime64(0, 3) OR end > ?
+		ORDER BY start, stream
+	`, streamFilter)
+
+	rows, err := db.read.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query connection gaps: %w", err)
+	}
+	defer rows.Close()
+
+	var gaps []models.ConnectionGap
+	for rows.Next() {
+		var g models.ConnectionGap
+		var end time.Time
+		if err := rows.Scan(&g.Stream, &g.Topic, &g.Start, &end, &g.Reason); err != nil {
+			return nil, fmt.Errorf("failed to scan connection gap: %w", err)
+		}
+		if end.Unix() > 0 {
+			g.End = &end
+		}
+		gaps = append(gaps, g)
+	}
+
+	return gaps, rows.Err()
+}
+
+// CloseOpenConnectionGaps ends the gaps a previous run left open because it stopped
+// while disconnected, at the given time (this run's first subscription), so they don't
+// annotate every later range
+func (db *ClickHouseDB) CloseOpenConnectionGaps(at time.Time) (int, error) {
+	gaps, err := db.GetConnectionGaps(time.Unix(0, 0), at)
+	if err != nil {
+		return 0, err
+	}
+	var open []*models.ConnectionGap
+	for i := range gaps {
+		if gaps[i].End == nil {
+			gaps[i].End = &at
+			open = append(open, &gaps[i])
+		}
+	}
+	if len(open) == 0 {
+		return 0, nil
+	}
+	return len(open), db.SaveConnectionGaps(open)
+}
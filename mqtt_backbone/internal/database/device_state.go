@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+// SaveDeviceStates writes device states to the shared store, for the replica that
+// handles a device next
+func (db *ClickHouseDB) SaveDeviceStates(states []models.DeviceState) error {
+	ctx := context.Background()
+
+	batch, err := db.conn.PrepareBatch(ctx, "INSERT INTO device_state (device_id, last_temperature, last_humidity, last_sound_volume, last_seen, last_inference_time, temperature_at, humidity_at, sound_volume_at, updated_at)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare device state batch: %w", err)
+	}
+	now := time.Now()
+	for _, st := range states {
+		if err := batch.Append(st.DeviceID, st.LastTemperature, st.LastHumidity, st.LastSoundVolume, st.LastSeen, st.LastInferenceTime,
+			st.TemperatureAt, st.HumidityAt, st.SoundVolumeAt, now); err != nil {
+			return fmt.Errorf("failed to append device state: %w", err)
+		}
+	}
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to insert device state: %w", err)
+	}
+
+	return nil
+}
+
+// GetDeviceState returns a device's state from the shared store, or nil when no
+// replica has written it. It reads the write pool, since read replicas may lag the
+// handoff.
+func (db *ClickHouseDB) GetDeviceState(ctx context.Context, deviceID string) (*models.DeviceState, error) {
+	query := `
+		SELECT device_id, last_temperature, last_humidity, last_sound_volume, last_seen, last_inference_time,
+			temperature_at, humidity_at, sound_volume_at
+		FROM device_state FINAL
+		WHERE device_id = ?
+		LIMIT 1
+	`
+
+	rows, err := db.conn.Query(ctx, query, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query device state: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	st := &models.DeviceState{}
+	if err := rows.Scan(&st.DeviceID, &st.LastTemperature, &st.LastHumidity, &st.LastSoundVolume, &st.LastSeen, &st.LastInferenceTime,
+		&st.TemperatureAt, &st.HumidityAt, &st.SoundVolumeAt); err != nil {
+		return nil, fmt.Errorf("failed to scan device state: %w", err)
+	}
+	return st, nil
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+// SaveDeviceStats inserts flushed per-device message counts in one block
+func (db *ClickHouseDB) SaveDeviceStats(windows []*models.DeviceStatsWindow) error {
+	ctx := context.Background()
+
+	batch, err := db.conn.PrepareBatch(ctx, "INSERT INTO device_stats (window_start, device_id, window_seconds, messages, payload_bytes, parse_errors, dropped, unauthorized)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare device stats batch: %w", err)
+	}
+
+	for _, w := range windows {
+		if err := batch.Append(w.WindowStart, w.DeviceID, uint32(w.WindowSeconds), w.Messages, w.PayloadBytes, w.ParseErrors, w.Dropped, w.Unauthorized); err != nil {
+			return fmt.Errorf("failed to append device stats: %w", err)
+		}
+	}
+
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to insert device stats: %w", err)
+	}
+
+	return nil
+}
+
+// GetDeviceStatsHistory returns a device's stored message counts since a point in time, oldest first
+func (db *ClickHouseDB) GetDeviceStatsHistory(deviceID string, since time.Time) ([]models.DeviceStatsWindow, error) {
+	ctx := context.Background()
+
+	query := `
+		SELECT window_start, window_seconds, messages, payload_bytes, parse_errors, dropped, unauthorized
+		FROM device_stats
+		WHERE device_id = ? AND window_start >= ?
+		ORDER BY window_start
+	`
+
+	rows, err := db.read.Query(ctx, query, deviceID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query device stats: %w", err)
+	}
+	defer rows.Close()
+
+	var windows []models.DeviceStatsWindow
+	for rows.Next() {
+		w := models.DeviceStatsWindow{DeviceID: deviceID}
+		var seconds uint32
+		if err := rows.Scan(&w.WindowStart, &seconds, &w.Messages, &w.PayloadBytes, &w.ParseErrors, &w.Dropped, &w.Unauthorized); err != nil {
+			return nil, fmt.Errorf("failed to scan device stats: %w", err)
+		}
+		w.WindowSeconds = int(seconds)
+		windows = append(windows, w)
+	}
+
+	return windows, rows.Err()
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+// SaveSensorDivergence inserts hourly divergences, replacing earlier computations of the same hours
+func (db *ClickHouseDB) SaveSensorDivergence(divergences []*models.SensorDivergence, computedAt time.Time) error {
+	if len(divergences) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+
+	batch, err := db.conn.PrepareBatch(ctx, "INSERT INTO sensor_divergence (hour, device_id, metric, room, value, room_median, divergence, neighbors, computed_at)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare sensor divergence batch: %w", err)
+	}
+	for _, d := range divergences {
+		if err := batch.Append(d.Hour, d.DeviceID, d.Metric, d.Room, d.Value, d.RoomMedian, d.Divergence, uint16(d.Neighbors), computedAt); err != nil {
+			return fmt.Errorf("failed to append sensor divergence: %w", err)
+		}
+	}
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to insert sensor divergence: %w", err)
+	}
+	return nil
+}
+
+// GetSensorDivergence returns a device's stored divergences for the hours starting
+// within a range, oldest first
+func (db *ClickHouseDB) GetSensorDivergence(deviceID string, from, to time.Time) ([]models.SensorDivergence, error) {
+	ctx := context.Background()
+
+	query := `
+		SELECT hour, metric, room, value, room_median, divergence, neighbors
+		FROM sensor_divergence FINAL
+		WHERE device_id = ? AND hour >= ? AND hour < ?
+		ORDER BY hour, metric
+	`
+
+	rows, err := db.read.Query(ctx, query, deviceID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sensor divergence: %w", err)
+	}
+	defer rows.Close()
+
+	divergences := []models.SensorDivergence{}
+	for rows.Next() {
+		d := models.SensorDivergence{DeviceID: deviceID}
+		var neighbors uint16
+		if err := rows.Scan(&d.Hour, &d.Metric, &d.Room, &d.Value, &d.RoomMedian, &d.Divergence, &neighbors); err != nil {
+			return nil, fmt.Errorf("failed to scan sensor divergence: %w", err)
+		}
+		d.Neighbors = int(neighbors)
+		divergences = append(divergences, d)
+	}
+	return divergences, rows.Err()
+}
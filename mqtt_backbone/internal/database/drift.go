@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// SensorDrift holds the least-squares slope of each metric over a window, in units
+// per hour, with the readings each slope was fitted to
+type SensorDrift struct {
+	Temperature        float64
+	Humidity           float64
+	SoundVolume        float64
+	TemperatureSamples uint64
+	HumiditySamples    uint64
+	VolumeSamples      uint64
+}
+
+// GetDriftSlopes fits a line through a device's readings within [from, to) for each
+// metric. A metric with fewer than two readings has a zero slope.
+func (db *ClickHouseDB) GetDriftSlopes(deviceID string, from, to time.Time) (*SensorDrift, error) {
+	ctx := context.Background()
+
+	query := `
+		SELECT metric, tupleElement(simpleLinearRegression(hours, value), 1) AS slope, count() AS samples
+		FROM (
+			SELECT 'temperature' AS metric, dateDiff('second', toDateTime(?), timestamp) / 3600 AS hours, value
+			FROM sensor_temperature
+			WHERE device_id = ? AND quality_flag != 'bad' AND NOT backfilled AND timestamp >= ? AND timestamp < ?
+			UNION ALL
+			SELECT 'humidity', dateDiff('second', toDateTime(?), timestamp) / 3600, value
+			FROM sensor_humidity
+			WHERE device_id = ? AND quality_flag != 'bad' AND NOT backfilled AND timestamp >= ? AND timestamp < ?
+			UNION ALL
+			SELECT 'sound_volume', dateDiff('second', toDateTime(?), timestamp) / 3600, sound_volume
+			FROM sensor_audio
+			WHERE device_id = ? AND quality_flag != 'bad' AND timestamp >= ? AND timestamp < ?
+		)
+		GROUP BY metric
+	`
+
+	rows, err := db.read.Query(ctx, query,
+		from, deviceID, from, to,
+		from, deviceID, from, to,
+		from, deviceID, from, to,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query drift slopes: %w", err)
+	}
+	defer rows.Close()
+
+	drift := &SensorDrift{}
+	for rows.Next() {
+		var metric string
+		var slope float64
+		var samples uint64
+		if err := rows.Scan(&metric, &slope, &samples); err != nil {
+			return nil, fmt.Errorf("failed to scan drift slope: %w", err)
+		}
+		if samples < 2 || math.IsNaN(slope) { // NaN when every reading shares a timestamp
+			slope = 0
+		}
+		switch metric {
+		case "temperature":
+			drift.Temperature, drift.TemperatureSamples = slope, samples
+		case "humidity":
+			drift.Humidity, drift.HumiditySamples = slope, samples
+		case "sound_volume":
+			drift.SoundVolume, drift.VolumeSamples = slope, samples
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read drift slopes: %w", err)
+	}
+	return drift, nil
+}
@@ -0,0 +1,300 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+)
+
+// insertRe extracts the table and column list from "INSERT INTO t (a, b) VALUES (?, ?)"
+// and from batch inserts, which name no VALUES
+var insertRe = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+(\w+)\s*\(([^)]*)\)\s*(VALUES|$)`)
+
+// tableNameRe guards table names that are interpolated into replay statements
+var tableNameRe = regexp.MustCompile(`^\w+$`)
+
+// failedInsertFile is the JSON lines fallback for rows that couldn't be
+// written to failed_inserts either
+type failedInsertFile struct {
+	mu   sync.Mutex
+	path string
+}
+
+// isInsert reports whether a statement is an INSERT
+func isInsert(query string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(query)), "INSERT")
+}
+
+// SetFailedInsertFile sets a file that failed rows are appended to when they
+// can't be stored in failed_inserts (e.g., the table is unreachable)
+func (db *ClickHouseDB) SetFailedInsertFile(path string) {
+	db.failedFile = &failedInsertFile{path: path}
+}
+
+// recordFailedInsert is the write pool's insert error hook. Permanently rejected
+// rows are kept in failed_inserts (or the fallback file) instead of being lost.
+func (db *ClickHouseDB) recordFailedInsert(query string, args []any, err error) {
+	if !permanentError(err) {
+		return
+	}
+
+	failed, ok := newFailedInsert(query, args, err)
+	if !ok {
+		log.Printf("Insert failed permanently and the row could not be captured: %v", err)
+		metrics.Default.Counter("clickhouse_failed_inserts_lost").Inc()
+		return
+	}
+	metrics.Default.Counter("clickhouse_failed_inserts").Inc()
+
+	saveErr := db.saveFailedInsert(context.Background(), failed)
+	if saveErr == nil {
+		log.Printf("Insert into %s failed permanently; row kept as failed insert %s: %v", failed.Table, failed.ID, err)
+		return
+	}
+	if db.failedFile != nil {
+		fileErr := db.failedFile.append(failed)
+		if fileErr == nil {
+			log.Printf("Insert into %s failed permanently; row kept in %s as %s: %v", failed.Table, db.failedFile.path, failed.ID, err)
+			return
+		}
+		saveErr = fmt.Errorf("%v; %w", saveErr, fileErr)
+	}
+	log.Printf("Insert into %s failed permanently and the row was lost: %v (%v)", failed.Table, err, saveErr)
+	metrics.Default.Counter("clickhouse_failed_inserts_lost").Inc()
+}
+
+// newFailedInsert serializes the row of a single-row INSERT, or one row of a batch
+// insert, as a column to value object
+func newFailedInsert(query string, args []any, err error) (*models.FailedInsert, bool) {
+	match := insertRe.FindStringSubmatch(query)
+	if match == nil || match[1] == "failed_inserts" {
+		return nil, false
+	}
+	columns := strings.Split(match[2], ",")
+	if len(columns) != len(args) {
+		return nil, false
+	}
+
+	failed := &models.FailedInsert{
+		ID:        newFailedInsertID(),
+		Timestamp: time.Now(),
+		Table:     match[1],
+		Error:     err.Error(),
+	}
+	row := make(map[string]any, len(columns))
+	for i, column := range columns {
+		column = strings.TrimSpace(column)
+		row[column] = jsonValue(args[i])
+		if s, ok := args[i].(string); ok && column == "device_id" {
+			failed.DeviceID = s
+		}
+	}
+
+	data, marshalErr := json.Marshal(row)
+	if marshalErr != nil {
+		return nil, false
+	}
+	failed.Row = string(data)
+	return failed, true
+}
+
+// jsonValue replaces values JSON can't represent (NaN and infinities become null,
+// which ClickHouse reads as the column default)
+func jsonValue(v any) any {
+	switch f := v.(type) {
+	case float64:
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil
+		}
+	case float32:
+		if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+			return nil
+		}
+	}
+	return v
+}
+
+// newFailedInsertID returns a random identifier for a failed row
+func newFailedInsertID() string {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// saveFailedInsert writes a failed_inserts row. It bypasses the insert error
+// hook so a failure here can't be captured recursively.
+func (db *ClickHouseDB) saveFailedInsert(ctx context.Context, failed *models.FailedInsert) error {
+	query := `
+		INSERT INTO failed_inserts (id, timestamp, table_name, device_id, row, error, replayed)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	var replayed uint8
+	if failed.Replayed {
+		replayed = 1
+	}
+
+	err := db.conn.Conn.Exec(ctx, query,
+		failed.ID,
+		failed.Timestamp,
+		failed.Table,
+		failed.DeviceID,
+		failed.Row,
+		failed.Error,
+		replayed,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert failed insert: %w", err)
+	}
+
+	return nil
+}
+
+// FailedInserts returns rows that have not been replayed yet, oldest first.
+// An empty table returns rows for every table.
+func (db *ClickHouseDB) FailedInserts(table string, limit int) ([]*models.FailedInsert, error) {
+	ctx := context.Background()
+
+	query := `
+		SELECT id, timestamp, table_name, device_id, row, error
+		FROM failed_inserts FINAL
+		WHERE replayed = 0 AND (? = '' OR table_name = ?)
+		ORDER BY timestamp
+		LIMIT ?
+	`
+
+	rows, err := db.conn.Query(ctx, query, table, table, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query failed inserts: %w", err)
+	}
+	defer rows.Close()
+
+	var failed []*models.FailedInsert
+	for rows.Next() {
+		f := &models.FailedInsert{}
+		if err := rows.Scan(&f.ID, &f.Timestamp, &f.Table, &f.DeviceID, &f.Row, &f.Error); err != nil {
+			return nil, fmt.Errorf("failed to scan failed insert: %w", err)
+		}
+		failed = append(failed, f)
+	}
+
+	return failed, rows.Err()
+}
+
+// ReplayFailedInsert re-inserts a failed row into its original table. Like
+// saveFailedInsert it bypasses the error hook, so a row that still fails is
+// not recorded a second time.
+func (db *ClickHouseDB) ReplayFailedInsert(failed *models.FailedInsert) error {
+	if !tableNameRe.MatchString(failed.Table) {
+		return fmt.Errorf("invalid table name %q", failed.Table)
+	}
+
+	// Timestamps were serialized as RFC 3339
+	ctx := clickhouse.Context(context.Background(), clickhouse.WithSettings(clickhouse.Settings{
+		"date_time_input_format": "best_effort",
+	}))
+
+	query := fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow %s", failed.Table, failed.Row)
+	if err := db.conn.Conn.Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to replay insert into %s: %w", failed.Table, err)
+	}
+	return nil
+}
+
+// MarkFailedInsertReplayed records that a failed row has been replayed
+func (db *ClickHouseDB) MarkFailedInsertReplayed(failed *models.FailedInsert) error {
+	replayed := *failed
+	replayed.Replayed = true
+	return db.saveFailedInsert(context.Background(), &replayed)
+}
+
+// append writes one failed row as a JSON line
+func (f *failedInsertFile) append(failed *models.FailedInsert) error {
+	line, err := json.Marshal(failed)
+	if err != nil {
+		return fmt.Errorf("failed to marshal failed insert: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open failed inserts file: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write failed inserts file: %w", err)
+	}
+	return nil
+}
+
+// ReadFailedInsertFile reads the rows kept in a fallback file
+func ReadFailedInsertFile(path string) ([]*models.FailedInsert, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open failed inserts file: %w", err)
+	}
+	defer file.Close()
+
+	var failed []*models.FailedInsert
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		f := &models.FailedInsert{}
+		if err := json.Unmarshal(scanner.Bytes(), f); err != nil {
+			return nil, fmt.Errorf("failed to parse %s line %d: %w", path, line, err)
+		}
+		failed = append(failed, f)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read failed inserts file: %w", err)
+	}
+	return failed, nil
+}
+
+// WriteFailedInsertFile replaces a fallback file with the given rows (e.g., those still failing after a replay)
+func WriteFailedInsertFile(path string, failed []*models.FailedInsert) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create failed inserts file: %w", err)
+	}
+
+	w := bufio.NewWriter(file)
+	for _, f := range failed {
+		line, err := json.Marshal(f)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("failed to marshal failed insert: %w", err)
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write failed inserts file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write failed inserts file: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
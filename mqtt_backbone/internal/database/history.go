@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+// GetSafetyEvents returns the safety events in [from, to) ordered by device and time.
+// An empty deviceID selects every device.
+func (db *ClickHouseDB) GetSafetyEvents(deviceID string, from, to time.Time) ([]models.SafetyEvent, error) {
+	ctx := context.Background()
+
+	query := `
+		SELECT timestamp, device_id, event_type, value
+		FROM safety_events
+		WHERE timestamp >= ? AND timestamp < ? AND (? = '' OR device_id = ?)
+		ORDER BY device_id, timestamp
+	`
+
+	rows, err := db.read.Query(ctx, query, from, to, deviceID, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query safety events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.SafetyEvent
+	for rows.Next() {
+		var e models.SafetyEvent
+		if err := rows.Scan(&e.Timestamp, &e.DeviceID, &e.EventType, &e.Value); err != nil {
+			return nil, fmt.Errorf("failed to scan safety event: %w", err)
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// GetMLPredictions returns the window model's predictions in [from, to) ordered by
+// device and time. An empty deviceID selects every device.
+func (db *ClickHouseDB) GetMLPredictions(deviceID string, from, to time.Time) ([]models.MLPrediction, error) {
+	ctx := context.Background()
+
+	query := `
+		SELECT timestamp, device_id, prediction, confidence, inference_time_ms, model_version, model_variant
+		FROM ml_predictions
+		WHERE timestamp >= ? AND timestamp < ? AND (? = '' OR device_id = ?)
+		ORDER BY device_id, timestamp
+	`
+
+	rows, err := db.read.Query(ctx, query, from, to, deviceID, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ML predictions: %w", err)
+	}
+	defer rows.Close()
+
+	var predictions []models.MLPrediction
+	for rows.Next() {
+		var p models.MLPrediction
+		if err := rows.Scan(&p.Timestamp, &p.DeviceID, &p.Prediction, &p.Confidence, &p.InferenceTimeMs, &p.ModelVersion, &p.ModelVariant); err != nil {
+			return nil, fmt.Errorf("failed to scan ML prediction: %w", err)
+		}
+		predictions = append(predictions, p)
+	}
+
+	return predictions, rows.Err()
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Lag definitions shared by inference requests and training datasets. A lag
+// feature of a moment t is read from the latest lag_features minute of its metric
+// that ended by t, and only when that minute ended within LagFeatureMaxAge of t;
+// its lags are the means of the minutes 15 minutes, 1 hour and 24 hours earlier.
+const (
+	// LagFeatureMaxAge is how long after its minute a lag_features row still
+	// describes the present; older rows leave the lag features missing
+	LagFeatureMaxAge = 5 * time.Minute
+
+	// ShortestLag is the smallest lag. Minutes are computed in batches no longer
+	// than this, so the minutes a batch lags to are always stored already.
+	ShortestLag = 15 * time.Minute
+)
+
+// lagMetrics are the metrics with lag features, as named in lag_features
+var lagMetrics = []string{"temperature", "humidity", "sound_volume"}
+
+// lagOffsets name the lags, as the suffix of their lag_features column
+var lagOffsets = []string{"15m", "1h", "24h"}
+
+// LagFeatureNames returns the names of the lag features in a stable order,
+// e.g. "temperature_lag_15m"
+func LagFeatureNames() []string {
+	names := make([]string, 0, len(lagMetrics)*len(lagOffsets))
+	for _, metric := range lagMetrics {
+		for _, lag := range lagOffsets {
+			names = append(names, lagFeatureName(metric, lag))
+		}
+	}
+	return names
+}
+
+// lagFeatureName names the lag of a metric as a model feature
+func lagFeatureName(metric, lag string) string {
+	return metric + "_lag_" + lag
+}
+
+// lagMinutesSQL averages the good temperature, humidity and audio readings within a
+// range per device, metric and minute. Its parameters are the range's start and end
+// for each of the three tables.
+const lagMinutesSQL = `
+	SELECT toStartOfMinute(timestamp) AS minute, device_id, 'temperature' AS metric, avg(value) AS value
+	FROM sensor_temperature
+	WHERE timestamp >= ? AND timestamp < ? AND quality_flag != 'bad'
+	GROUP BY minute, device_id
+	UNION ALL
+	SELECT toStartOfMinute(timestamp) AS minute, device_id, 'humidity' AS metric, avg(value) AS value
+	FROM sensor_humidity
+	WHERE timestamp >= ? AND timestamp < ? AND quality_flag != 'bad'
+	GROUP BY minute, device_id
+	UNION ALL
+	SELECT toStartOfMinute(timestamp) AS minute, device_id, 'sound_volume' AS metric, avg(sound_volume) AS value
+	FROM sensor_audio
+	WHERE timestamp >= ? AND timestamp < ? AND quality_flag != 'bad'
+	GROUP BY minute, device_id
+`
+
+// lagJoinSQL joins the stored minutes a lag earlier onto the computed minutes c.
+// Its parameters are the lagged range's start and end.
+const lagJoinSQL = `
+	LEFT JOIN (
+		SELECT timestamp + INTERVAL %[2]s AS minute, device_id, metric, toNullable(value) AS value
+		FROM lag_features FINAL
+		WHERE timestamp >= ? AND timestamp < ?
+	) AS l%[1]s ON c.device_id = l%[1]s.device_id AND c.metric = l%[1]s.metric AND c.minute = l%[1]s.minute
+`
+
+// UpdateLagFeatures computes the lag_features rows of every device for the minutes
+// within [from, to). Both ends should be minute boundaries and the range at most
+// ShortestLag long, so the lagged minutes are stored already; ranges are computed
+// oldest first. Computing a minute again replaces its earlier row.
+func (db *ClickHouseDB) UpdateLagFeatures(from, to, updatedAt time.Time) error {
+	ctx := context.Background()
+
+	if to.Sub(from) > ShortestLag {
+		return fmt.Errorf("lag feature range %v exceeds the shortest lag %v", to.Sub(from), ShortestLag)
+	}
+
+	query := `
+		INSERT INTO lag_features (timestamp, device_id, metric, value, lag_15m, lag_1h, lag_24h, updated_at)
+		SELECT c.minute, c.device_id, c.metric, c.value, l15m.value, l1h.value, l24h.value, ?
+		FROM (` + lagMinutesSQL + `) AS c` +
+		fmt.Sprintf(lagJoinSQL, "15m", "15 MINUTE") +
+		fmt.Sprintf(lagJoinSQL, "1h", "1 HOUR") +
+		fmt.Sprintf(lagJoinSQL, "24h", "24 HOUR")
+
+	args := []interface{}{updatedAt, from, to, from, to, from, to}
+	for _, lag := range []time.Duration{ShortestLag, time.Hour, 24 * time.Hour} {
+		args = append(args, from.Add(-lag), to.Add(-lag))
+	}
+	if err := db.conn.Exec(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to update lag features: %w", err)
+	}
+	return nil
+}
+
+// GetLastLagFeaturesMinute returns the latest minute computed into lag_features,
+// or the zero time when nothing has been
+func (db *ClickHouseDB) GetLastLagFeaturesMinute() (time.Time, error) {
+	ctx := context.Background()
+
+	var last time.Time
+	var rows uint64
+	query := `SELECT max(timestamp), count() FROM lag_features`
+	if err := db.read.QueryRow(ctx, query).Scan(&last, &rows); err != nil {
+		return time.Time{}, fmt.Errorf("failed to query last lag features minute: %w", err)
+	}
+	if rows == 0 {
+		return time.Time{}, nil
+	}
+	return last, nil
+}
+
+// GetLagFeatures returns a device's lag features at a moment by feature name.
+// Features without a recent enough minute or without readings a lag earlier are
+// missing from the map.
+func (db *ClickHouseDB) GetLagFeatures(deviceID string, at time.Time) (map[string]float64, error) {
+	ctx := context.Background()
+
+	// Rows are named by the minute's start; a minute ends one minute later
+	query := `
+		SELECT metric, lag_15m, lag_1h, lag_24h
+		FROM lag_features FINAL
+		WHERE device_id = ? AND timestamp + INTERVAL 1 MINUTE <= ? AND timestamp + INTERVAL 1 MINUTE >= ?
+		ORDER BY metric, timestamp DESC
+		LIMIT 1 BY metric
+	`
+
+	rows, err := db.read.Query(ctx, query, deviceID, at, at.Add(-LagFeatureMaxAge))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query lag features: %w", err)
+	}
+	defer rows.Close()
+
+	features := make(map[string]float64)
+	for rows.Next() {
+		var metric string
+		lags := make([]*float64, len(lagOffsets))
+		if err := rows.Scan(&metric, &lags[0], &lags[1], &lags[2]); err != nil {
+			return nil, fmt.Errorf("failed to scan lag features: %w", err)
+		}
+		for i, lag := range lagOffsets {
+			if lags[i] != nil {
+				features[lagFeatureName(metric, lag)] = *lags[i]
+			}
+		}
+	}
+	return features, rows.Err()
+}
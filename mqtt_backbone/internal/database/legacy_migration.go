@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// legacyColumns maps the per-sensor tables to their column in the legacy
+// sensor_readings wide table
+var legacyColumns = []struct {
+	table  string
+	column string
+	insert string // Columns written to the per-sensor table
+	values string // Matching expressions over the legacy row
+}{
+	{"sensor_temperature", "temperature", "timestamp, device_id, value, quality_score, quality_flag", "timestamp, device_id, assumeNotNull(temperature), 1, 'good'"},
+	{"sensor_humidity", "humidity", "timestamp, device_id, value, quality_score, quality_flag", "timestamp, device_id, assumeNotNull(humidity), 1, 'good'"},
+	{"sensor_audio", "sound_volume", "timestamp, device_id, format, sound_volume, features, quality_score, quality_flag", "timestamp, device_id, 'legacy', assumeNotNull(sound_volume), '{}', 1, 'good'"},
+}
+
+// TableTimeRange returns the first and last timestamp of a table, and false when
+// it's empty
+func (db *ClickHouseDB) TableTimeRange(table string) (time.Time, time.Time, bool, error) {
+	ctx := context.Background()
+
+	var first, last time.Time
+	var rows uint64
+	query := fmt.Sprintf(`SELECT min(timestamp), max(timestamp), count() FROM %s`, table)
+	if err := db.read.QueryRow(ctx, query).Scan(&first, &last, &rows); err != nil {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("failed to query time range of %s: %w", table, err)
+	}
+	return first, last, rows > 0, nil
+}
+
+// MigrateLegacyReadings copies the legacy sensor_readings rows within [from, to) into
+// the per-sensor tables, one reading per non-NULL value. A value is skipped when its
+// table already holds a reading of the device in the same minute, so migrating twice,
+// or over data that was rolled up from the per-sensor tables, adds nothing. It returns
+// the readings copied per table; with dryRun nothing is written.
+func (db *ClickHouseDB) MigrateLegacyReadings(from, to time.Time, dryRun bool) (map[string]int, error) {
+	ctx := context.Background()
+
+	counts := make(map[string]int)
+	for _, c := range legacyColumns {
+		where := fmt.Sprintf(`
+			FROM sensor_readings FINAL
+			WHERE timestamp >= ? AND timestamp < ? AND %[1]s IS NOT NULL
+				AND (device_id, toStartOfMinute(timestamp)) GLOBAL NOT IN (
+					SELECT device_id, toStartOfMinute(timestamp)
+					FROM %[2]s
+					WHERE timestamp >= ? AND timestamp < ?
+				)
+		`, c.column, c.table)
+		args := []interface{}{from, to, from, to}
+
+		var n uint64
+		if err := db.read.QueryRow(ctx, "SELECT count()"+where, args...).Scan(&n); err != nil {
+			return counts, fmt.Errorf("failed to count legacy %s readings: %w", c.column, err)
+		}
+		counts[c.table] = int(n)
+		if dryRun || n == 0 {
+			continue
+		}
+
+		insert := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s", c.table, c.insert, c.values) + where
+		if err := db.conn.Exec(ctx, insert, args...); err != nil {
+			return counts, fmt.Errorf("failed to migrate legacy %s readings: %w", c.column, err)
+		}
+	}
+	return counts, nil
+}
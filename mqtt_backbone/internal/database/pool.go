@@ -0,0 +1,259 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"iot-backend/internal/errs"
+	"iot-backend/internal/metrics"
+)
+
+// PoolConfig sizes one ClickHouse connection pool
+type PoolConfig struct {
+	Addr         string // Comma-separated hosts; empty uses the primary address
+	MaxOpenConns int    // 0 uses the driver default (MaxIdleConns + 5)
+	MaxIdleConns int    // 0 uses the driver default (5)
+}
+
+// PoolsConfig configures separate pools for inserts and for aggregate queries,
+// so polling queries don't queue behind insert traffic. The read pool may point
+// at different replicas than the write pool.
+type PoolsConfig struct {
+	Write PoolConfig
+	Read  PoolConfig
+}
+
+// pooledConn is a connection pool that records per-pool latency, errors, and usage
+type pooledConn struct {
+	driver.Conn
+	name string // "write" or "read"
+
+	// onInsertError is called when an INSERT statement fails (nil to ignore)
+	onInsertError func(query string, args []any, err error)
+
+	// readOnly discards statements and batch inserts instead of running them (observer instances)
+	readOnly bool
+
+	// scope cancels queries when it ends, and settings apply to them, when set
+	// (queries of an API request, see WithQueryLimits)
+	scope    context.Context
+	settings clickhouse.Settings
+}
+
+// openPool connects one pool
+func openPool(name string, pool PoolConfig, addr, database, username, password string) (*pooledConn, error) {
+	if pool.Addr != "" {
+		addr = pool.Addr
+	}
+
+	conn, err := clickhouse.Open(&clickhouse.Options{
+		Addr: splitAddrs(addr),
+		Auth: clickhouse.Auth{
+			Database: database,
+			Username: username,
+			Password: password,
+		},
+		Settings: clickhouse.Settings{
+			"max_execution_time": 60,
+		},
+		DialTimeout:  5 * time.Second,
+		MaxOpenConns: pool.MaxOpenConns,
+		MaxIdleConns: pool.MaxIdleConns,
+		Compression: &clickhouse.Compression{
+			Method: clickhouse.CompressionLZ4,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ClickHouse (%s pool): %w", name, errs.Wrap(errs.ErrStorageUnavailable, err))
+	}
+
+	if err := conn.Ping(context.Background()); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to ping ClickHouse (%s pool): %w", name, errs.Wrap(errs.ErrStorageUnavailable, err))
+	}
+
+	return &pooledConn{Conn: conn, name: name}, nil
+}
+
+// Exec runs a statement
+func (c *pooledConn) Exec(ctx context.Context, query string, args ...any) error {
+	if c.readOnly {
+		metrics.Default.Counter("clickhouse_discarded_writes").Inc()
+		return nil
+	}
+	start := time.Now()
+	err := c.Conn.Exec(ctx, query, args...)
+	c.observe("exec", start, err)
+	if err != nil && c.onInsertError != nil && isInsert(query) {
+		c.onInsertError(query, args, err)
+	}
+	return classify(err)
+}
+
+// queryContext returns the context a query runs under: the caller's, also cancelled
+// when the scope ends and carrying the scope's settings. The derived context is
+// released when either ends, since rows outlive the call that queried them.
+func (c *pooledConn) queryContext(ctx context.Context) context.Context {
+	if c.scope == nil {
+		return ctx
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	context.AfterFunc(c.scope, cancel)
+	return clickhouse.Context(ctx, clickhouse.WithSettings(c.settings))
+}
+
+// Query runs a query returning rows
+func (c *pooledConn) Query(ctx context.Context, query string, args ...any) (driver.Rows, error) {
+	ctx = c.queryContext(ctx)
+	start := time.Now()
+	rows, err := c.Conn.Query(ctx, query, args...)
+	c.observe("query", start, err)
+	return rows, classify(err)
+}
+
+// QueryRow runs a query returning a single row
+func (c *pooledConn) QueryRow(ctx context.Context, query string, args ...any) driver.Row {
+	ctx = c.queryContext(ctx)
+	start := time.Now()
+	row := c.Conn.QueryRow(ctx, query, args...)
+	c.observe("query", start, row.Err())
+	return classifiedRow{Row: row}
+}
+
+// PrepareBatch starts a batch insert (only the preparation is timed)
+func (c *pooledConn) PrepareBatch(ctx context.Context, query string, opts ...driver.PrepareBatchOption) (driver.Batch, error) {
+	if c.readOnly {
+		return &discardedBatch{}, nil
+	}
+	start := time.Now()
+	batch, err := c.Conn.PrepareBatch(ctx, query, opts...)
+	c.observe("batch", start, err)
+	if err != nil || c.onInsertError == nil {
+		return batch, classify(err)
+	}
+	return &recordedBatch{Batch: batch, query: query, onInsertError: c.onInsertError}, nil
+}
+
+// recordedBatch keeps the rows appended to a batch insert, so rows rejected by
+// Append or a failed Send reach the insert error hook one by one
+type recordedBatch struct {
+	driver.Batch
+	query         string
+	rows          [][]any
+	onInsertError func(query string, args []any, err error)
+}
+
+func (b *recordedBatch) Append(v ...any) error {
+	if err := b.Batch.Append(v...); err != nil {
+		b.onInsertError(b.query, v, err)
+		return err
+	}
+	b.rows = append(b.rows, v)
+	return nil
+}
+
+func (b *recordedBatch) Send() error {
+	err := b.Batch.Send()
+	if err != nil {
+		for _, row := range b.rows {
+			b.onInsertError(b.query, row, err)
+		}
+	}
+	b.rows = nil
+	return classify(err)
+}
+
+// discardedBatch accepts rows and drops them on Send (read-only pools)
+type discardedBatch struct {
+	rows int
+	sent bool
+}
+
+func (b *discardedBatch) Abort() error                  { b.sent = true; return nil }
+func (b *discardedBatch) Append(v ...any) error         { b.rows++; return nil }
+func (b *discardedBatch) AppendStruct(v any) error      { b.rows++; return nil }
+func (b *discardedBatch) Column(int) driver.BatchColumn { return discardedColumn{} }
+func (b *discardedBatch) Flush() error                  { return nil }
+func (b *discardedBatch) IsSent() bool                  { return b.sent }
+func (b *discardedBatch) Rows() int                     { return b.rows }
+func (b *discardedBatch) Send() error {
+	b.sent = true
+	metrics.Default.Counter("clickhouse_discarded_writes").Inc()
+	return nil
+}
+
+// discardedColumn accepts column values of a discarded batch
+type discardedColumn struct{}
+
+func (discardedColumn) Append(any) error    { return nil }
+func (discardedColumn) AppendRow(any) error { return nil }
+
+// observe records an operation's latency and outcome and the pool's current usage
+func (c *pooledConn) observe(op string, start time.Time, err error) {
+	prefix := "clickhouse_" + c.name + "_"
+	metrics.Default.Timer(prefix + op).Since(start)
+	if err != nil {
+		metrics.Default.Counter(prefix + "errors").Inc()
+	}
+
+	stats := c.Conn.Stats()
+	metrics.Default.Gauge(prefix + "open_conns").Set(int64(stats.Open))
+	metrics.Default.Gauge(prefix + "idle_conns").Set(int64(stats.Idle))
+}
+
+// Server errors that clear up on their own; anything else rejects the statement for good
+var transientExceptionCodes = map[int32]bool{
+	159: true, // TIMEOUT_EXCEEDED
+	202: true, // TOO_MANY_SIMULTANEOUS_QUERIES
+	209: true, // SOCKET_TIMEOUT
+	241: true, // MEMORY_LIMIT_EXCEEDED
+	242: true, // TABLE_IS_READ_ONLY
+	252: true, // TOO_MANY_PARTS
+	999: true, // KEEPER_EXCEPTION
+}
+
+// permanentError reports whether retrying the same statement can't succeed:
+// server-side rejections (e.g., type errors) and client-side conversion errors
+// are permanent; network, timeout, and pool errors are not.
+func permanentError(err error) bool {
+	var exception *clickhouse.Exception
+	if errors.As(err, &exception) {
+		return !transientExceptionCodes[exception.Code]
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, clickhouse.ErrAcquireConnTimeout):
+		return false
+	}
+	return true
+}
+
+// classify tags transient failures as ErrStorageUnavailable; permanent ones
+// (e.g., a type error in one statement) are left for the caller to report
+func classify(err error) error {
+	if err == nil || permanentError(err) {
+		return err
+	}
+	return errs.Wrap(errs.ErrStorageUnavailable, err)
+}
+
+// classifiedRow classifies the errors of a single-row query
+type classifiedRow struct {
+	driver.Row
+}
+
+func (r classifiedRow) Err() error                { return classify(r.Row.Err()) }
+func (r classifiedRow) Scan(dest ...any) error    { return classify(r.Row.Scan(dest...)) }
+func (r classifiedRow) ScanStruct(dest any) error { return classify(r.Row.ScanStruct(dest)) }
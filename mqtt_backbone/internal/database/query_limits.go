@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// QueryLimits bounds each query run for an API request, so a careless range (a
+// year of raw readings) fails fast instead of occupying the server the inserts
+// depend on
+type QueryLimits struct {
+	Timeout       time.Duration // Execution time of one query (0 = the pool's 60 s)
+	MaxRowsToRead uint64        // Rows one query may scan (0 = unlimited)
+	MaxResultRows uint64        // Rows one query may return (0 = unlimited)
+}
+
+// DefaultQueryLimits returns default limits
+func DefaultQueryLimits() QueryLimits {
+	return QueryLimits{
+		Timeout:       15 * time.Second,
+		MaxRowsToRead: 100_000_000,
+		MaxResultRows: 1_000_000,
+	}
+}
+
+// settings returns the ClickHouse settings enforcing the limits server-side
+func (l QueryLimits) settings() clickhouse.Settings {
+	settings := clickhouse.Settings{}
+	if l.Timeout > 0 {
+		settings["max_execution_time"] = int(math.Ceil(l.Timeout.Seconds()))
+		settings["timeout_overflow_mode"] = "throw"
+	}
+	if l.MaxRowsToRead > 0 {
+		settings["max_rows_to_read"] = l.MaxRowsToRead
+		settings["read_overflow_mode"] = "throw"
+	}
+	if l.MaxResultRows > 0 {
+		settings["max_result_rows"] = l.MaxResultRows
+		settings["result_overflow_mode"] = "throw"
+	}
+	return settings
+}
+
+// WithQueryLimits returns a handle on the same pools whose queries are also
+// cancelled when ctx is (a client hanging up), on the server too, and run within
+// limits. Each query keeps its own context's deadline and values. Writes through
+// the handle are unaffected.
+func (db *ClickHouseDB) WithQueryLimits(ctx context.Context, limits QueryLimits) *ClickHouseDB {
+	settings := limits.settings()
+	scoped := *db
+	scoped.conn = db.conn.scoped(ctx, settings)
+	scoped.read = db.read.scoped(ctx, settings)
+	return &scoped
+}
+
+// scoped returns a copy of the pool whose queries are cancelled with ctx and run
+// with settings
+func (c *pooledConn) scoped(ctx context.Context, settings clickhouse.Settings) *pooledConn {
+	scoped := *c
+	scoped.scope = ctx
+	scoped.settings = settings
+	return &scoped
+}
+
+// Server errors of a query stopped by its limits
+var queryLimitExceptionCodes = map[int32]bool{
+	158: true, // TOO_MANY_ROWS
+	159: true, // TIMEOUT_EXCEEDED
+	160: true, // TOO_SLOW
+	396: true, // TOO_MANY_ROWS_OR_BYTES
+}
+
+// IsQueryLimit reports whether a query failed because it exceeded its limits
+func IsQueryLimit(err error) bool {
+	var exception *clickhouse.Exception
+	return errors.As(err, &exception) && queryLimitExceptionCodes[exception.Code]
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+// SaveScheduledCommands inserts scheduled commands, or new versions of existing ones
+// (e.g., after a status change), in one block
+func (db *ClickHouseDB) SaveScheduledCommands(commands []*models.ScheduledCommand) error {
+	ctx := context.Background()
+
+	batch, err := db.conn.PrepareBatch(ctx, "INSERT INTO scheduled_commands (id, batch_id, device_id, execute_at, position, hold_minutes, reason, status, created_at, updated_at, executed_at)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare scheduled commands batch: %w", err)
+	}
+
+	for _, c := range commands {
+		var executedAt time.Time
+		if c.ExecutedAt != nil {
+			executedAt = *c.ExecutedAt
+		}
+		if err := batch.Append(c.ID, c.BatchID, c.DeviceID, c.ExecuteAt, c.Position, uint32(c.HoldMinutes),
+			c.Reason, c.Status, c.CreatedAt, c.UpdatedAt, executedAt); err != nil {
+			return fmt.Errorf("failed to append scheduled command: %w", err)
+		}
+	}
+
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to insert scheduled commands: %w", err)
+	}
+
+	return nil
+}
+
+// GetScheduledCommands returns the latest version of scheduled commands ordered by
+// execution time. Empty status or batchID select every command.
+func (db *ClickHouseDB) GetScheduledCommands(status, batchID string) ([]*models.ScheduledCommand, error) {
+	query := `
+		SELECT * FROM (
+			SELECT id, batch_id, device_id, execute_at, position, hold_minutes, reason, status, created_at, updated_at, executed_at
+			FROM scheduled_commands FINAL
+		)
+		WHERE (? = '' OR status = ?) AND (? = '' OR batch_id = ?)
+		ORDER BY execute_at, device_id
+	`
+	return db.queryScheduledCommands(query, status, status, batchID, batchID)
+}
+
+// GetDueScheduledCommands returns pending commands whose execution time has passed
+func (db *ClickHouseDB) GetDueScheduledCommands(now time.Time) ([]*models.ScheduledCommand, error) {
+	query := `
+		SELECT * FROM (
+			SELECT id, batch_id, device_id, execute_at, position, hold_minutes, reason, status, created_at, updated_at, executed_at
+			FROM scheduled_commands FINAL
+		)
+		WHERE status = ? AND execute_at <= ?
+		ORDER BY execute_at, device_id
+	`
+	return db.queryScheduledCommands(query, models.ScheduleStatusPending, now)
+}
+
+// queryScheduledCommands runs a scheduled_commands query and scans its rows. The
+// write connection is used so a command is never missed on a lagging replica.
+func (db *ClickHouseDB) queryScheduledCommands(query string, args ...interface{}) ([]*models.ScheduledCommand, error) {
+	ctx := context.Background()
+
+	rows, err := db.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scheduled commands: %w", err)
+	}
+	defer rows.Close()
+
+	var commands []*models.ScheduledCommand
+	for rows.Next() {
+		var c models.ScheduledCommand
+		var hold uint32
+		var executedAt time.Time
+		if err := rows.Scan(&c.ID, &c.BatchID, &c.DeviceID, &c.ExecuteAt, &c.Position, &hold,
+			&c.Reason, &c.Status, &c.CreatedAt, &c.UpdatedAt, &executedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan scheduled command: %w", err)
+		}
+		c.HoldMinutes = int(hold)
+		if executedAt.Unix() > 0 {
+			c.ExecutedAt = &executedAt
+		}
+		commands = append(commands, &c)
+	}
+
+	return commands, rows.Err()
+}
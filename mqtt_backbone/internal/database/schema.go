@@ -1,5 +1,10 @@
 package database
 
+import (
+	"fmt"
+	"strings"
+)
+
 // SQL schemas for all ClickHouse tables
 
 const (
@@ -8,7 +13,10 @@ const (
 		CREATE TABLE IF NOT EXISTS sensor_temperature (
 			timestamp DateTime64(3),
 			device_id String,
-			value Float64
+			value Float64,
+			quality_score Float64 DEFAULT 1,
+			quality_flag LowCardinality(String) DEFAULT 'good',
+			backfilled Bool DEFAULT false
 		) ENGINE = MergeTree()
 		ORDER BY (device_id, timestamp)
 		PARTITION BY toYYYYMM(timestamp)
@@ -19,7 +27,10 @@ const (
 		CREATE TABLE IF NOT EXISTS sensor_humidity (
 			timestamp DateTime64(3),
 			device_id String,
-			value Float64
+			value Float64,
+			quality_score Float64 DEFAULT 1,
+			quality_flag LowCardinality(String) DEFAULT 'good',
+			backfilled Bool DEFAULT false
 		) ENGINE = MergeTree()
 		ORDER BY (device_id, timestamp)
 		PARTITION BY toYYYYMM(timestamp)
@@ -35,7 +46,19 @@ const (
 			format String,
 			audio_hash String,
 			sound_volume Float64,
-			features String
+			features String,
+			quality_score Float64 DEFAULT 1,
+			quality_flag LowCardinality(String) DEFAULT 'good',
+			l10 Float64 DEFAULT 0,
+			l50 Float64 DEFAULT 0,
+			l90 Float64 DEFAULT 0,
+			anomaly_score Float64 DEFAULT 0,
+			noise_floor Float64 DEFAULT 0,
+			relative_volume Float64 DEFAULT 0,
+			channels UInt8 DEFAULT 1,
+			channel_volumes Array(Float64),
+			clipping_ratio Float64 DEFAULT 0,
+			agc_compensation Float64 DEFAULT 0
 		) ENGINE = MergeTree()
 		ORDER BY (device_id, timestamp)
 		PARTITION BY toYYYYMM(timestamp)
@@ -50,7 +73,8 @@ const (
 			confidence Float64,
 			temperature Float64,
 			humidity Float64,
-			sound_volume Float64
+			sound_volume Float64,
+			dry_run Bool DEFAULT false
 		) ENGINE = MergeTree()
 		ORDER BY (device_id, timestamp)
 		PARTITION BY toYYYYMM(timestamp)
@@ -65,7 +89,8 @@ const (
 			registered_at DateTime64(3),
 			last_seen DateTime64(3),
 			is_active Bool,
-			config String
+			config String,
+			tags Map(String, String)
 		) ENGINE = ReplacingMergeTree(last_seen)
 		ORDER BY device_id
 	`
@@ -78,12 +103,29 @@ const (
 			prediction Float64,
 			confidence Float64,
 			inference_time_ms Float64,
-			model_version String
+			model_version String,
+			model_variant LowCardinality(String) DEFAULT ''
 		) ENGINE = MergeTree()
 		ORDER BY (device_id, timestamp)
 		PARTITION BY toYYYYMM(timestamp)
 	`
 
+	// ModelPredictionsTableSQL stores responses of the additional ML models
+	ModelPredictionsTableSQL = `
+		CREATE TABLE IF NOT EXISTS model_predictions (
+			timestamp DateTime64(3),
+			device_id String,
+			model LowCardinality(String),
+			correlation_id String,
+			prediction Float64,
+			confidence Float64,
+			label String,
+			model_version String
+		) ENGINE = MergeTree()
+		ORDER BY (model, device_id, timestamp)
+		PARTITION BY toYYYYMM(timestamp)
+	`
+
 	// InferenceHistoryTableSQL tracks when inferences were triggered for each device
 	InferenceHistoryTableSQL = `
 		CREATE TABLE IF NOT EXISTS inference_history (
@@ -92,11 +134,474 @@ const (
 			trigger_reason String,
 			temp_z_score Float64,
 			humidity_z_score Float64,
-			volume_z_score Float64
+			volume_z_score Float64,
+			correlation_id String,
+			domain LowCardinality(String) DEFAULT '',
+			cached Bool DEFAULT false
+		) ENGINE = MergeTree()
+		ORDER BY (device_id, timestamp)
+		PARTITION BY toYYYYMM(timestamp)
+	`
+
+	// SafetyEventsTableSQL stores safety-critical events (rain, wind, alarm)
+	SafetyEventsTableSQL = `
+		CREATE TABLE IF NOT EXISTS safety_events (
+			timestamp DateTime64(3),
+			device_id String,
+			event_type String,
+			value Float64,
+			processing_latency_ms Float64
 		) ENGINE = MergeTree()
 		ORDER BY (device_id, timestamp)
 		PARTITION BY toYYYYMM(timestamp)
 	`
+
+	// SensorQuarantineTableSQL stores readings rejected as spikes or implausible values
+	SensorQuarantineTableSQL = `
+		CREATE TABLE IF NOT EXISTS sensor_quarantine (
+			timestamp DateTime64(3),
+			device_id String,
+			metric LowCardinality(String),
+			value Float64,
+			expected Float64,
+			reason String
+		) ENGINE = MergeTree()
+		ORDER BY (device_id, timestamp)
+		PARTITION BY toYYYYMM(timestamp)
+	`
+
+	// WindowDecisionsTableSQL stores the arbitration trace behind each window command
+	WindowDecisionsTableSQL = `
+		CREATE TABLE IF NOT EXISTS window_decisions (
+			timestamp DateTime64(3),
+			device_id String,
+			position Float64,
+			winner LowCardinality(String),
+			summary String,
+			trace String
+		) ENGINE = MergeTree()
+		ORDER BY (device_id, timestamp)
+		PARTITION BY toYYYYMM(timestamp)
+	`
+
+	// DeadLettersTableSQL stores rejected messages (e.g., invalid ML responses) for inspection
+	DeadLettersTableSQL = `
+		CREATE TABLE IF NOT EXISTS dead_letters (
+			timestamp DateTime64(3),
+			source LowCardinality(String),
+			device_id String,
+			topic String,
+			payload String,
+			reason String
+		) ENGINE = MergeTree()
+		ORDER BY (source, timestamp)
+		PARTITION BY toYYYYMM(timestamp)
+		TTL toDateTime(timestamp) + INTERVAL 30 DAY
+	`
+
+	// FeatureSnapshotsTableSQL stores the exact feature payload of every published
+	// inference request, looked up by correlation ID
+	FeatureSnapshotsTableSQL = `
+		CREATE TABLE IF NOT EXISTS feature_snapshots (
+			correlation_id String,
+			timestamp DateTime64(3),
+			device_id String,
+			topic String,
+			features String
+		) ENGINE = MergeTree()
+		ORDER BY correlation_id
+		PARTITION BY toYYYYMM(timestamp)
+		TTL toDateTime(timestamp) + INTERVAL 90 DAY
+	`
+
+	// FailedInsertsTableSQL stores rows ClickHouse rejected permanently so they can be replayed.
+	// A replayed row is re-inserted with replayed = 1 and replaces the original on merge.
+	FailedInsertsTableSQL = `
+		CREATE TABLE IF NOT EXISTS failed_inserts (
+			id String,
+			timestamp DateTime64(3),
+			table_name LowCardinality(String),
+			device_id String,
+			row String,
+			error String,
+			replayed UInt8
+		) ENGINE = ReplacingMergeTree(replayed)
+		ORDER BY (table_name, id)
+		PARTITION BY toYYYYMM(timestamp)
+		TTL toDateTime(timestamp) + INTERVAL 90 DAY
+	`
+
+	// MessageTracesTableSQL stores sampled MQTT message lifecycles for debugging
+	MessageTracesTableSQL = `
+		CREATE TABLE IF NOT EXISTS message_traces (
+			timestamp DateTime64(3),
+			trace_id String,
+			filter LowCardinality(String),
+			topic String,
+			device_id String,
+			payload String,
+			payload_bytes UInt32,
+			truncated Bool,
+			events Array(String),
+			outcome LowCardinality(String),
+			duration_us UInt64
+		) ENGINE = MergeTree()
+		ORDER BY (filter, timestamp)
+		PARTITION BY toYYYYMMDD(timestamp)
+		TTL toDateTime(timestamp) + INTERVAL 7 DAY
+	`
+
+	// MoldRiskTableSQL stores the derived mold risk indicator per device
+	MoldRiskTableSQL = `
+		CREATE TABLE IF NOT EXISTS mold_risk (
+			timestamp DateTime64(3),
+			device_id String,
+			risk_index Float64,
+			hours_at_risk Float64,
+			temperature Float64,
+			humidity Float64
+		) ENGINE = MergeTree()
+		ORDER BY (device_id, timestamp)
+		PARTITION BY toYYYYMM(timestamp)
+	`
+
+	// OccupancyTableSQL stores occupancy estimates fused from sound, motion, and CO2
+	OccupancyTableSQL = `
+		CREATE TABLE IF NOT EXISTS occupancy (
+			timestamp DateTime64(3),
+			device_id String,
+			probability Float64,
+			occupied Bool,
+			sound_evidence Float64,
+			motion_evidence Float64,
+			co2_evidence Float64,
+			co2_slope Float64
+		) ENGINE = MergeTree()
+		ORDER BY (device_id, timestamp)
+		PARTITION BY toYYYYMM(timestamp)
+	`
+
+	// AudioLevelsHourlyTableSQL stores hourly percentile sound levels per device
+	AudioLevelsHourlyTableSQL = `
+		CREATE TABLE IF NOT EXISTS audio_levels_hourly (
+			window_start DateTime,
+			device_id String,
+			l10 Float64,
+			l50 Float64,
+			l90 Float64,
+			leq Float64,
+			frames UInt32
+		) ENGINE = MergeTree()
+		ORDER BY (device_id, window_start)
+		PARTITION BY toYYYYMM(window_start)
+	`
+
+	// TemperatureHourlyTableSQL stores hourly mean temperature per device, kept up to date
+	// by a materialized view over sensor_temperature
+	TemperatureHourlyTableSQL = `
+		CREATE TABLE IF NOT EXISTS temperature_hourly (
+			hour DateTime,
+			device_id String,
+			temperature AggregateFunction(avg, Float64)
+		) ENGINE = AggregatingMergeTree()
+		ORDER BY (device_id, hour)
+		PARTITION BY toYYYYMM(hour)
+	`
+
+	// HumidityHourlyTableSQL stores hourly mean humidity per device, kept up to date
+	// by a materialized view over sensor_humidity
+	HumidityHourlyTableSQL = `
+		CREATE TABLE IF NOT EXISTS humidity_hourly (
+			hour DateTime,
+			device_id String,
+			humidity AggregateFunction(avg, Float64)
+		) ENGINE = AggregatingMergeTree()
+		ORDER BY (device_id, hour)
+		PARTITION BY toYYYYMM(hour)
+	`
+
+	// NoiseHourlyTableSQL stores hourly mean and peak sound volume per device, kept up to
+	// date by a materialized view over sensor_audio
+	NoiseHourlyTableSQL = `
+		CREATE TABLE IF NOT EXISTS noise_hourly (
+			hour DateTime,
+			device_id String,
+			volume AggregateFunction(avg, Float64),
+			peak SimpleAggregateFunction(max, Float64)
+		) ENGINE = AggregatingMergeTree()
+		ORDER BY (device_id, hour)
+		PARTITION BY toYYYYMM(hour)
+	`
+
+	// WindowPositionsTableSQL stores the latest commanded window position per device,
+	// kept up to date by a materialized view over window_actions
+	WindowPositionsTableSQL = `
+		CREATE TABLE IF NOT EXISTS window_positions (
+			device_id String,
+			timestamp DateTime64(3),
+			position Float64
+		) ENGINE = ReplacingMergeTree(timestamp)
+		ORDER BY device_id
+	`
+
+	// DeviceStatsTableSQL stores per-device MQTT message counts per flush interval
+	DeviceStatsTableSQL = `
+		CREATE TABLE IF NOT EXISTS device_stats (
+			window_start DateTime,
+			device_id String,
+			window_seconds UInt32,
+			messages UInt64,
+			payload_bytes UInt64,
+			parse_errors UInt64,
+			dropped UInt64,
+			unauthorized UInt64 DEFAULT 0
+		) ENGINE = MergeTree()
+		ORDER BY (device_id, window_start)
+		PARTITION BY toYYYYMM(window_start)
+	`
+
+	// ScheduledCommandsTableSQL stores one-off future window commands. Status changes
+	// are inserted as new versions of a row; reads use FINAL to see the latest.
+	ScheduledCommandsTableSQL = `
+		CREATE TABLE IF NOT EXISTS scheduled_commands (
+			id String,
+			batch_id String,
+			device_id String,
+			execute_at DateTime64(3),
+			position Float64,
+			hold_minutes UInt32,
+			reason String,
+			status LowCardinality(String),
+			created_at DateTime64(3),
+			updated_at DateTime64(3),
+			executed_at DateTime64(3)
+		) ENGINE = ReplacingMergeTree(updated_at)
+		ORDER BY id
+	`
+
+	// WindowUsageDailyTableSQL stores per-device window usage per local day. Days are
+	// recomputed while they are recent; reads use FINAL to see the latest computation.
+	WindowUsageDailyTableSQL = `
+		CREATE TABLE IF NOT EXISTS window_usage_daily (
+			day DateTime,
+			device_id String,
+			seconds UInt32,
+			open_seconds UInt32,
+			open_events UInt32,
+			avg_position Float64,
+			computed_at DateTime64(3)
+		) ENGINE = ReplacingMergeTree(computed_at)
+		ORDER BY (device_id, day)
+		PARTITION BY toYYYYMM(day)
+	`
+
+	// WindowUsageHourlyTableSQL stores per-device window position and room conditions
+	// per hour, for hour-of-day profiles and correlations
+	WindowUsageHourlyTableSQL = `
+		CREATE TABLE IF NOT EXISTS window_usage_hourly (
+			hour DateTime,
+			device_id String,
+			seconds UInt32,
+			open_seconds UInt32,
+			avg_position Float64,
+			temperature Nullable(Float64),
+			noise Nullable(Float64),
+			computed_at DateTime64(3)
+		) ENGINE = ReplacingMergeTree(computed_at)
+		ORDER BY (device_id, hour)
+		PARTITION BY toYYYYMM(hour)
+	`
+
+	// SensorDivergenceTableSQL stores how far each device's hourly means were from the
+	// other devices in its room, recomputed while the hour is within the judged window
+	SensorDivergenceTableSQL = `
+		CREATE TABLE IF NOT EXISTS sensor_divergence (
+			hour DateTime,
+			device_id String,
+			metric LowCardinality(String),
+			room String,
+			value Float64,
+			room_median Float64,
+			divergence Float64,
+			neighbors UInt16,
+			computed_at DateTime64(3)
+		) ENGINE = ReplacingMergeTree(computed_at)
+		ORDER BY (device_id, metric, hour)
+		PARTITION BY toYYYYMM(hour)
+	`
+
+	// DeviceStateTableSQL stores each device's change detection state as last written
+	// by the replica handling it, so a device moved to another replica brings it along.
+	// The row with the latest reading wins, whichever replica wrote last.
+	DeviceStateTableSQL = `
+		CREATE TABLE IF NOT EXISTS device_state (
+			device_id String,
+			last_temperature Float64,
+			last_humidity Float64,
+			last_sound_volume Float64,
+			last_seen DateTime64(3),
+			last_inference_time DateTime64(3),
+			temperature_at DateTime64(3),
+			humidity_at DateTime64(3),
+			sound_volume_at DateTime64(3),
+			updated_at DateTime64(3)
+		) ENGINE = ReplacingMergeTree(last_seen)
+		ORDER BY device_id
+	`
+
+	// SensorReadingsTableSQL stores one wide row per device and minute: the minute's
+	// mean temperature, humidity and sound volume, joined by the readings roll-up for
+	// analytics that want a single row per device-minute. Metrics without a good
+	// reading in the minute are NULL. Recent minutes are rolled up again as late
+	// readings arrive; reads use FINAL to see the latest roll-up.
+	SensorReadingsTableSQL = `
+		CREATE TABLE IF NOT EXISTS sensor_readings (
+			timestamp DateTime,
+			device_id String,
+			temperature Nullable(Float64),
+			humidity Nullable(Float64),
+			sound_volume Nullable(Float64),
+			samples UInt32,
+			rolled_up_at DateTime64(3)
+		) ENGINE = ReplacingMergeTree(rolled_up_at)
+		ORDER BY (device_id, timestamp)
+		PARTITION BY toYYYYMM(timestamp)
+	`
+
+	// LagFeaturesTableSQL stores, per device, metric and minute, the minute's mean of
+	// the good readings and the means of the minutes 15 minutes, 1 hour and 24 hours
+	// earlier (NULL without readings then). Inference requests and training datasets
+	// both read their lag features from here, so they share one definition. Recent
+	// minutes are recomputed as late readings arrive; reads use FINAL.
+	LagFeaturesTableSQL = `
+		CREATE TABLE IF NOT EXISTS lag_features (
+			timestamp DateTime,
+			device_id String,
+			metric LowCardinality(String),
+			value Float64,
+			lag_15m Nullable(Float64),
+			lag_1h Nullable(Float64),
+			lag_24h Nullable(Float64),
+			updated_at DateTime64(3)
+		) ENGINE = ReplacingMergeTree(updated_at)
+		ORDER BY (device_id, metric, timestamp)
+		PARTITION BY toYYYYMM(timestamp)
+	`
+
+	// ConnectionGapsTableSQL stores spans during which a subscription received nothing
+	// because the broker connection was down. A gap is inserted when the connection is
+	// lost and again, with its end, once resubscribed; the end versions the row, so
+	// reads use FINAL. device_id is always empty (a gap affects every device on the
+	// topic) and only gives the cluster schema its sharding key.
+	ConnectionGapsTableSQL = `
+		CREATE TABLE IF NOT EXISTS connection_gaps (
+			device_id String DEFAULT '',
+			stream LowCardinality(String),
+			topic String,
+			start DateTime64(3),
+			end DateTime64(3),
+			reason String
+		) ENGINE = ReplacingMergeTree(end)
+		ORDER BY (device_id, stream, start)
+		PARTITION BY toYYYYMM(start)
+		TTL toDateTime(start) + INTERVAL 365 DAY
+	`
+
+	// MLTimeoutsTableSQL stores inference requests that were never answered
+	MLTimeoutsTableSQL = `
+		CREATE TABLE IF NOT EXISTS ml_timeouts (
+			timestamp DateTime64(3),
+			correlation_id String,
+			device_id String,
+			topic String,
+			timeout_seconds Float64
+		) ENGINE = MergeTree()
+		ORDER BY (device_id, timestamp)
+		PARTITION BY toYYYYMM(timestamp)
+		TTL toDateTime(timestamp) + INTERVAL 90 DAY
+	`
+
+	// WindowCommandOutcomesTableSQL stores how window commands ended: acknowledged
+	// (and reconciled against the reported position), rejected, or expired
+	WindowCommandOutcomesTableSQL = `
+		CREATE TABLE IF NOT EXISTS window_command_outcomes (
+			timestamp DateTime64(3),
+			device_id String,
+			command_id String,
+			commanded Float64,
+			reported Nullable(Float64),
+			outcome LowCardinality(String),
+			attempts UInt8,
+			latency_ms UInt32,
+			error String
+		) ENGINE = MergeTree()
+		ORDER BY (device_id, timestamp)
+		PARTITION BY toYYYYMM(timestamp)
+		TTL toDateTime(timestamp) + INTERVAL 365 DAY
+	`
+
+	// AudioFormatMismatchesTableSQL stores clips whose declared duration or sample rate disagrees with their data
+	AudioFormatMismatchesTableSQL = `
+		CREATE TABLE IF NOT EXISTS audio_format_mismatches (
+			timestamp DateTime64(3),
+			device_id String,
+			issues Array(LowCardinality(String)),
+			sample_rate UInt32,
+			declared_duration Float64,
+			actual_duration Float64,
+			bytes UInt64
+		) ENGINE = MergeTree()
+		ORDER BY (device_id, timestamp)
+		PARTITION BY toYYYYMM(timestamp)
+		TTL toDateTime(timestamp) + INTERVAL 90 DAY
+	`
+
+	// AlertsTableSQL stores raised alerts
+	AlertsTableSQL = `
+		CREATE TABLE IF NOT EXISTS alerts (
+			timestamp DateTime64(3),
+			device_id String,
+			type LowCardinality(String),
+			severity LowCardinality(String),
+			message String,
+			value Float64
+		) ENGINE = MergeTree()
+		ORDER BY (device_id, timestamp)
+		PARTITION BY toYYYYMM(timestamp)
+	`
+
+	// AlertSnoozesTableSQL stores alert snoozes. Status changes are inserted as new
+	// versions of a row; reads use FINAL to see the latest.
+	AlertSnoozesTableSQL = `
+		CREATE TABLE IF NOT EXISTS alert_snoozes (
+			id String,
+			device_id String,
+			type LowCardinality(String),
+			until DateTime64(3),
+			reason String,
+			created_by String,
+			status LowCardinality(String),
+			created_at DateTime64(3),
+			updated_at DateTime64(3)
+		) ENGINE = ReplacingMergeTree(updated_at)
+		ORDER BY id
+	`
+
+	// AlertSnoozeAuditTableSQL records every creation, cancellation, and expiry of a snooze
+	AlertSnoozeAuditTableSQL = `
+		CREATE TABLE IF NOT EXISTS alert_snooze_audit (
+			timestamp DateTime64(3),
+			snooze_id String,
+			action LowCardinality(String),
+			device_id String,
+			type LowCardinality(String),
+			until DateTime64(3),
+			actor String,
+			reason String
+		) ENGINE = MergeTree()
+		ORDER BY (timestamp, snooze_id)
+		PARTITION BY toYYYYMM(timestamp)
+	`
 )
 
 // AllTables returns all table creation SQL statements
@@ -108,6 +613,152 @@ func AllTables() []string {
 		WindowActionsTableSQL,
 		DeviceRegistryTableSQL,
 		MLPredictionsTableSQL,
+		ModelPredictionsTableSQL,
 		InferenceHistoryTableSQL,
+		SafetyEventsTableSQL,
+		SensorQuarantineTableSQL,
+		WindowDecisionsTableSQL,
+		DeadLettersTableSQL,
+		MoldRiskTableSQL,
+		AlertsTableSQL,
+		AlertSnoozesTableSQL,
+		AlertSnoozeAuditTableSQL,
+		AudioLevelsHourlyTableSQL,
+		MLTimeoutsTableSQL,
+		WindowCommandOutcomesTableSQL,
+		AudioFormatMismatchesTableSQL,
+		OccupancyTableSQL,
+		MessageTracesTableSQL,
+		FailedInsertsTableSQL,
+		FeatureSnapshotsTableSQL,
+		TemperatureHourlyTableSQL,
+		HumidityHourlyTableSQL,
+		NoiseHourlyTableSQL,
+		WindowPositionsTableSQL,
+		DeviceStatsTableSQL,
+		ScheduledCommandsTableSQL,
+		WindowUsageDailyTableSQL,
+		WindowUsageHourlyTableSQL,
+		ConnectionGapsTableSQL,
+		SensorReadingsTableSQL,
+		LagFeaturesTableSQL,
+		SensorDivergenceTableSQL,
+		DeviceStateTableSQL,
+	}
+}
+
+// MaterializedView keeps a rollup table up to date as rows are inserted into a source table
+type MaterializedView struct {
+	Name    string // View name
+	Source  string // Table whose inserts feed the view
+	Target  string // Rollup table the view writes to
+	Select  string // Column list of the SELECT over Source
+	Where   string // Optional filter on Source rows
+	GroupBy string // Optional GROUP BY columns
+}
+
+// AllViews returns the materialized views behind the hourly and building-level rollups.
+// Views are created after the tables they read from and write to.
+func AllViews() []MaterializedView {
+	return []MaterializedView{
+		{
+			Name:    "temperature_hourly_mv",
+			Source:  "sensor_temperature",
+			Target:  "temperature_hourly",
+			Select:  "toStartOfHour(timestamp) AS hour, device_id, avgState(value) AS temperature",
+			Where:   "quality_flag != 'bad'",
+			GroupBy: "hour, device_id",
+		},
+		{
+			Name:    "humidity_hourly_mv",
+			Source:  "sensor_humidity",
+			Target:  "humidity_hourly",
+			Select:  "toStartOfHour(timestamp) AS hour, device_id, avgState(value) AS humidity",
+			Where:   "quality_flag != 'bad'",
+			GroupBy: "hour, device_id",
+		},
+		{
+			Name:    "noise_hourly_mv",
+			Source:  "sensor_audio",
+			Target:  "noise_hourly",
+			Select:  "toStartOfHour(timestamp) AS hour, device_id, avgState(sound_volume) AS volume, max(sound_volume) AS peak",
+			Where:   "quality_flag != 'bad'",
+			GroupBy: "hour, device_id",
+		},
+		{
+			Name:   "window_positions_mv",
+			Source: "window_actions",
+			Target: "window_positions",
+			Select: "device_id, timestamp, position",
+			Where:  "dry_run = false",
+		},
+	}
+}
+
+// SelectSQL returns the view's query over a source table, with an optional extra filter
+func (v MaterializedView) SelectSQL(source, filter string) string {
+	var conditions []string
+	for _, c := range []string{v.Where, filter} {
+		if c != "" {
+			conditions = append(conditions, "("+c+")")
+		}
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", v.Select, source)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if v.GroupBy != "" {
+		query += " GROUP BY " + v.GroupBy
+	}
+	return query
+}
+
+// CreateSQL returns the statement creating the view on a single host
+func (v MaterializedView) CreateSQL() string {
+	return fmt.Sprintf("CREATE MATERIALIZED VIEW IF NOT EXISTS %s TO %s AS %s", v.Name, v.Target, v.SelectSQL(v.Source, ""))
+}
+
+// Migration is an idempotent column change applied to an existing table
+type Migration struct {
+	Table  string // Table name (the local table in cluster mode)
+	Change string // ALTER clause, must be idempotent (e.g., ADD COLUMN IF NOT EXISTS)
+}
+
+// AllMigrations returns column changes for tables created by older versions.
+// CREATE TABLE IF NOT EXISTS never alters an existing table, so new columns
+// must also be listed here.
+func AllMigrations() []Migration {
+	return []Migration{
+		{Table: "sensor_temperature", Change: "ADD COLUMN IF NOT EXISTS quality_score Float64 DEFAULT 1"},
+		{Table: "sensor_temperature", Change: "ADD COLUMN IF NOT EXISTS quality_flag LowCardinality(String) DEFAULT 'good'"},
+		{Table: "sensor_humidity", Change: "ADD COLUMN IF NOT EXISTS quality_score Float64 DEFAULT 1"},
+		{Table: "sensor_humidity", Change: "ADD COLUMN IF NOT EXISTS quality_flag LowCardinality(String) DEFAULT 'good'"},
+		{Table: "sensor_audio", Change: "ADD COLUMN IF NOT EXISTS quality_score Float64 DEFAULT 1"},
+		{Table: "sensor_audio", Change: "ADD COLUMN IF NOT EXISTS quality_flag LowCardinality(String) DEFAULT 'good'"},
+		{Table: "window_actions", Change: "ADD COLUMN IF NOT EXISTS dry_run Bool DEFAULT false"},
+		{Table: "sensor_audio", Change: "ADD COLUMN IF NOT EXISTS l10 Float64 DEFAULT 0"},
+		{Table: "sensor_audio", Change: "ADD COLUMN IF NOT EXISTS l50 Float64 DEFAULT 0"},
+		{Table: "sensor_audio", Change: "ADD COLUMN IF NOT EXISTS l90 Float64 DEFAULT 0"},
+		{Table: "sensor_audio", Change: "ADD COLUMN IF NOT EXISTS anomaly_score Float64 DEFAULT 0"},
+		{Table: "sensor_audio", Change: "ADD COLUMN IF NOT EXISTS noise_floor Float64 DEFAULT 0"},
+		{Table: "sensor_audio", Change: "ADD COLUMN IF NOT EXISTS relative_volume Float64 DEFAULT 0"},
+		{Table: "sensor_audio", Change: "ADD COLUMN IF NOT EXISTS channels UInt8 DEFAULT 1"},
+		{Table: "sensor_audio", Change: "ADD COLUMN IF NOT EXISTS channel_volumes Array(Float64)"},
+		{Table: "sensor_audio", Change: "ADD COLUMN IF NOT EXISTS clipping_ratio Float64 DEFAULT 0"},
+		{Table: "sensor_audio", Change: "ADD COLUMN IF NOT EXISTS agc_compensation Float64 DEFAULT 0"},
+		{Table: "device_registry", Change: "ADD COLUMN IF NOT EXISTS tags Map(String, String)"},
+		{Table: "inference_history", Change: "ADD COLUMN IF NOT EXISTS correlation_id String DEFAULT ''"},
+		{Table: "sensor_readings", Change: "ADD COLUMN IF NOT EXISTS samples UInt32 DEFAULT 0"},
+		{Table: "device_stats", Change: "ADD COLUMN IF NOT EXISTS unauthorized UInt64 DEFAULT 0"},
+		{Table: "sensor_readings", Change: "ADD COLUMN IF NOT EXISTS rolled_up_at DateTime64(3) DEFAULT now64(3)"},
+		{Table: "inference_history", Change: "ADD COLUMN IF NOT EXISTS domain LowCardinality(String) DEFAULT ''"},
+		{Table: "ml_predictions", Change: "ADD COLUMN IF NOT EXISTS model_variant LowCardinality(String) DEFAULT ''"},
+		{Table: "inference_history", Change: "ADD COLUMN IF NOT EXISTS cached Bool DEFAULT false"},
+		{Table: "device_state", Change: "ADD COLUMN IF NOT EXISTS temperature_at DateTime64(3)"},
+		{Table: "device_state", Change: "ADD COLUMN IF NOT EXISTS humidity_at DateTime64(3)"},
+		{Table: "device_state", Change: "ADD COLUMN IF NOT EXISTS sound_volume_at DateTime64(3)"},
+		{Table: "sensor_temperature", Change: "ADD COLUMN IF NOT EXISTS backfilled Bool DEFAULT false"},
+		{Table: "sensor_humidity", Change: "ADD COLUMN IF NOT EXISTS backfilled Bool DEFAULT false"},
 	}
 }
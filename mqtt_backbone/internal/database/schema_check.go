@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var addColumnRe = regexp.MustCompile(`ADD COLUMN IF NOT EXISTS (\w+)`)
+
+// ExpectedColumns returns the columns every table must have, derived from the
+// table definitions and column migrations
+func ExpectedColumns() map[string][]string {
+	expected := make(map[string][]string)
+
+	for _, tableSQL := range AllTables() {
+		match := createTableRe.FindStringSubmatchIndex(tableSQL)
+		if match == nil {
+			continue
+		}
+		name := tableSQL[match[2]:match[3]]
+		body := tableSQL[match[1]:]
+		if end := strings.Index(body, ") ENGINE"); end >= 0 {
+			body = body[:end]
+		}
+
+		for _, line := range strings.Split(body, "\n") {
+			fields := strings.Fields(strings.TrimSpace(line))
+			if len(fields) == 0 {
+				continue
+			}
+			expected[name] = append(expected[name], fields[0])
+		}
+	}
+
+	for _, m := range AllMigrations() {
+		if match := addColumnRe.FindStringSubmatch(m.Change); match != nil {
+			expected[m.Table] = appendUnique(expected[m.Table], match[1])
+		}
+	}
+
+	return expected
+}
+
+// CheckSchema compares the live schema against ExpectedColumns without changing anything.
+// It returns one message per missing table or column; an empty result means the schema is compatible.
+func (db *ClickHouseDB) CheckSchema() ([]string, error) {
+	live, err := db.liveColumns(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for table, columns := range ExpectedColumns() {
+		have, ok := live[table]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("missing table %s", table))
+			continue
+		}
+		for _, column := range columns {
+			if !have[column] {
+				problems = append(problems, fmt.Sprintf("missing column %s.%s", table, column))
+			}
+		}
+	}
+	sort.Strings(problems)
+
+	return problems, nil
+}
+
+// liveColumns returns the columns of every table and view in the current database
+func (db *ClickHouseDB) liveColumns(ctx context.Context) (map[string]map[string]bool, error) {
+	rows, err := db.conn.Query(ctx, `
+		SELECT table, name
+		FROM system.columns
+		WHERE database = currentDatabase()
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema: %w", err)
+	}
+	defer rows.Close()
+
+	live := make(map[string]map[string]bool)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, fmt.Errorf("failed to scan schema: %w", err)
+		}
+		if live[table] == nil {
+			live[table] = make(map[string]bool)
+		}
+		live[table][column] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	return live, nil
+}
+
+// appendUnique appends s unless the slice already contains it
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// defaultSchemaConcurrency is the number of DDL statements InitSchema runs at once
+const defaultSchemaConcurrency = 4
+
+// createTargetRe extracts the object a CREATE statement creates, in single-host and cluster form
+var createTargetRe = regexp.MustCompile(`CREATE (?:TABLE|MATERIALIZED VIEW) IF NOT EXISTS (\w+)`)
+
+// schemaCatalog is one snapshot of the tables, views, and columns in the current database
+type schemaCatalog map[string]map[string]bool // Table -> column names
+
+// SetSchemaConcurrency sets how many DDL statements InitSchema runs at once
+func (db *ClickHouseDB) SetSchemaConcurrency(n int) {
+	db.schemaConcurrency = n
+}
+
+// InitSchema creates the necessary tables if they don't exist. Existing tables, columns,
+// and views are looked up once and skipped, and the remaining statements run
+// concurrently; on a cluster each ON CLUSTER statement waits for every node, so a
+// serial start can take minutes. In cluster mode the lookup reads the connected node
+// only, which is assumed to have the same schema as the others.
+func (db *ClickHouseDB) InitSchema() error {
+	ctx := context.Background()
+	start := time.Now()
+
+	live, err := db.liveColumns(ctx)
+	if err != nil {
+		return err
+	}
+	catalog := schemaCatalog(live)
+
+	// Create all tables from schema; in cluster mode each table is a local and a
+	// Distributed statement, which run in order
+	var tableGroups [][]string
+	if db.cluster.Enabled() {
+		clusterTables, err := ClusterTables(AllTables(), db.cluster)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(clusterTables); i += 2 {
+			tableGroups = append(tableGroups, clusterTables[i:i+2])
+		}
+		log.Printf("Using replicated schema on cluster %s", db.cluster.Cluster)
+	} else {
+		for _, tableSQL := range AllTables() {
+			tableGroups = append(tableGroups, []string{tableSQL})
+		}
+	}
+
+	created := make(map[string]bool)
+	var pending [][]string
+	for _, group := range tableGroups {
+		var missing []string
+		for _, tableSQL := range group {
+			if name := createTarget(tableSQL); name == "" || !catalog.exists(name) {
+				missing = append(missing, tableSQL)
+				created[name] = true
+			}
+		}
+		if len(missing) > 0 {
+			pending = append(pending, missing)
+		}
+	}
+	if err := db.runSchemaGroups(ctx, pending, "failed to create table: %w"); err != nil {
+		return err
+	}
+
+	// Apply column migrations for tables created by older versions. Tables created
+	// above already have every column (in cluster mode, the local table decides since
+	// the Distributed table copies its columns); changes to one table run in order.
+	var migrationGroups [][]string
+	byTable := make(map[string]int)
+	for _, m := range AllMigrations() {
+		tables := []string{m.Table}
+		if db.cluster.Enabled() {
+			tables = append(tables, m.Table+"_local")
+		}
+		if created[tables[len(tables)-1]] || catalog.hasColumn(tables, m.Change) {
+			continue
+		}
+		var statements []string
+		if db.cluster.Enabled() {
+			statements = ClusterMigrations([]Migration{m}, db.cluster)
+		} else {
+			statements = []string{fmt.Sprintf("ALTER TABLE %s %s", m.Table, m.Change)}
+		}
+		if i, ok := byTable[m.Table]; ok {
+			migrationGroups[i] = append(migrationGroups[i], statements...)
+			continue
+		}
+		byTable[m.Table] = len(migrationGroups)
+		migrationGroups = append(migrationGroups, statements)
+	}
+	if err := db.runSchemaGroups(ctx, migrationGroups, "failed to migrate table: %w"); err != nil {
+		return err
+	}
+
+	if err := db.createViews(ctx, catalog); err != nil {
+		return err
+	}
+
+	log.Printf("Database schema initialized successfully in %v (%d tables created, %d tables migrated)",
+		time.Since(start).Round(time.Millisecond), len(pending), len(migrationGroups))
+	return nil
+}
+
+// createViews creates the materialized views behind the rollup tables. A view only
+// sees rows inserted after it exists, so a new view's rollup is first backfilled
+// from every row already stored and the view created after it. Bounding the
+// backfill by timestamp instead would count twice the rows inserted after the view
+// with an earlier timestamp (late device clocks, backfills, imports). Rows other
+// replicas insert while the backfill runs are left out of the rollup.
+func (db *ClickHouseDB) createViews(ctx context.Context, catalog schemaCatalog) error {
+	views := AllViews()
+	statements := make([]string, len(views))
+	if db.cluster.Enabled() {
+		statements = ClusterViews(views, db.cluster)
+	} else {
+		for i, v := range views {
+			statements[i] = v.CreateSQL()
+		}
+	}
+
+	var missing []int
+	for i, v := range views {
+		if !catalog.exists(v.Name) {
+			missing = append(missing, i)
+		}
+	}
+
+	return db.parallel(len(missing), func(n int) error {
+		v := views[missing[n]]
+
+		// Rows left by a backfill whose view was never created are rolled up again
+		truncate := fmt.Sprintf("TRUNCATE TABLE IF EXISTS %s", v.Target)
+		if db.cluster.Enabled() {
+			truncate = fmt.Sprintf("TRUNCATE TABLE IF EXISTS %s_local ON CLUSTER %s", v.Target, db.cluster.Cluster)
+		}
+		if err := db.conn.Exec(ctx, truncate); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", v.Target, err)
+		}
+		backfill := fmt.Sprintf("INSERT INTO %s %s", v.Target, v.SelectSQL(v.Source, ""))
+		if err := db.conn.Exec(ctx, backfill); err != nil {
+			return fmt.Errorf("failed to backfill %s: %w", v.Target, err)
+		}
+
+		if err := db.conn.Exec(ctx, statements[missing[n]]); err != nil {
+			return fmt.Errorf("failed to create view %s: %w", v.Name, err)
+		}
+		log.Printf("Backfilled %s and created view %s", v.Target, v.Name)
+		return nil
+	})
+}
+
+// exists reports whether a table or view exists
+func (c schemaCatalog) exists(name string) bool {
+	return c[name] != nil
+}
+
+// hasColumn reports whether the column a migration adds already exists in every given table
+func (c schemaCatalog) hasColumn(tables []string, change string) bool {
+	match := addColumnRe.FindStringSubmatch(change)
+	if match == nil {
+		return false
+	}
+	for _, table := range tables {
+		if !c[table][match[1]] {
+			return false
+		}
+	}
+	return true
+}
+
+// createTarget returns the name of the table or view a CREATE statement creates
+func createTarget(statement string) string {
+	if match := createTargetRe.FindStringSubmatch(statement); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// runSchemaGroups runs groups concurrently and the statements of each group in order
+func (db *ClickHouseDB) runSchemaGroups(ctx context.Context, groups [][]string, format string) error {
+	return db.parallel(len(groups), func(i int) error {
+		for _, statement := range groups[i] {
+			if err := db.conn.Exec(ctx, statement); err != nil {
+				return fmt.Errorf(format, err)
+			}
+		}
+		return nil
+	})
+}
+
+// parallel calls fn for 0..n-1 with at most schemaConcurrency calls at once and returns every error
+func (db *ClickHouseDB) parallel(n int, fn func(i int) error) error {
+	limit := db.schemaConcurrency
+	if limit <= 0 {
+		limit = defaultSchemaConcurrency
+	}
+
+	sem := make(chan struct{}, limit)
+	failures := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			failures[i] = fn(i)
+		}(i)
+	}
+	wg.Wait()
+	return errors.Join(failures...)
+}
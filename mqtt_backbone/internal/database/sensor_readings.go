@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// sensorReadingsRollupSQL joins the good temperature, humidity and audio readings
+// within a range into per-minute rows, in the column order of sensor_readings. Its
+// parameters are the range's start and end for each of the three tables; %[1]s is
+// the fleet filter.
+const sensorReadingsRollupSQL = `
+	SELECT
+		minute,
+		device_id,
+		avgIfOrNull(value, metric = 1),
+		avgIfOrNull(value, metric = 2),
+		avgIfOrNull(value, metric = 3),
+		count()
+	FROM (
+		SELECT toStartOfMinute(timestamp) AS minute, device_id, 1 AS metric, value
+		FROM sensor_temperature
+		WHERE timestamp >= ? AND timestamp < ? AND quality_flag != 'bad' AND %[1]s
+		UNION ALL
+		SELECT toStartOfMinute(timestamp) AS minute, device_id, 2 AS metric, value
+		FROM sensor_humidity
+		WHERE timestamp >= ? AND timestamp < ? AND quality_flag != 'bad' AND %[1]s
+		UNION ALL
+		SELECT toStartOfMinute(timestamp) AS minute, device_id, 3 AS metric, sound_volume AS value
+		FROM sensor_audio
+		WHERE timestamp >= ? AND timestamp < ? AND quality_flag != 'bad' AND %[1]s
+	)
+	GROUP BY minute, device_id
+`
+
+// RollupSensorReadings joins the good temperature, humidity and audio readings of
+// every device into per-minute rows of sensor_readings, for the minutes within
+// [from, to). Both ends should be minute boundaries. Rolling up a minute again
+// replaces its earlier row.
+func (db *ClickHouseDB) RollupSensorReadings(from, to, rolledUpAt time.Time) error {
+	ctx := context.Background()
+
+	query := `
+		INSERT INTO sensor_readings (timestamp, device_id, temperature, humidity, sound_volume, samples, rolled_up_at)
+		SELECT *, ? FROM (` + fmt.Sprintf(sensorReadingsRollupSQL, db.fleetFilter("device_id")) + `)
+	`
+
+	if err := db.conn.Exec(ctx, query, rolledUpAt, from, to, from, to, from, to); err != nil {
+		return fmt.Errorf("failed to roll up sensor readings: %w", err)
+	}
+	return nil
+}
+
+// CountSensorReadingMinutes returns how many device-minutes within [from, to) a
+// roll-up would write
+func (db *ClickHouseDB) CountSensorReadingMinutes(from, to time.Time) (int, error) {
+	ctx := context.Background()
+
+	var n uint64
+	query := `SELECT count() FROM (` + fmt.Sprintf(sensorReadingsRollupSQL, db.fleetFilter("device_id")) + `)`
+	if err := db.read.QueryRow(ctx, query, from, to, from, to, from, to).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count sensor reading minutes: %w", err)
+	}
+	return int(n), nil
+}
+
+// GetLastSensorReadingsMinute returns the latest minute rolled up into sensor_readings,
+// or the zero time when nothing has been
+func (db *ClickHouseDB) GetLastSensorReadingsMinute() (time.Time, error) {
+	ctx := context.Background()
+
+	var last time.Time
+	var rows uint64
+	query := `SELECT max(timestamp), count() FROM sensor_readings`
+	if err := db.read.QueryRow(ctx, query).Scan(&last, &rows); err != nil {
+		return time.Time{}, fmt.Errorf("failed to query last rolled-up minute: %w", err)
+	}
+	if rows == 0 {
+		return time.Time{}, nil
+	}
+	return last, nil
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+const (
+	// RawSeriesMaxRange is the longest range read from raw readings; longer ranges
+	// read the hourly rollups so a year-long chart never scans every reading
+	RawSeriesMaxRange = 7 * 24 * time.Hour
+
+	// MaxSeriesPoints caps the buckets in one series; the resolution is coarsened to fit
+	MaxSeriesPoints = 2000
+)
+
+// Series sources
+const (
+	SeriesSourceRaw    = "raw"
+	SeriesSourceHourly = "hourly"
+	SeriesSourceMemory = "memory" // Raw readings served from the in-memory hot store
+)
+
+// seriesTables locates a metric in the raw and rollup tables
+type seriesTables struct {
+	raw         string // Raw readings table
+	rawValue    string // Column averaged in the raw table
+	rollup      string // Hourly rollup table (hour, device_id, ...)
+	rollupValue string // Expression merging the rollup's aggregate state
+}
+
+var seriesMetrics = map[string]seriesTables{
+	"temperature":  {raw: "sensor_temperature", rawValue: "value", rollup: "temperature_hourly", rollupValue: "avgMerge(temperature)"},
+	"humidity":     {raw: "sensor_humidity", rawValue: "value", rollup: "humidity_hourly", rollupValue: "avgMerge(humidity)"},
+	"sound_volume": {raw: "sensor_audio", rawValue: "sound_volume", rollup: "noise_hourly", rollupValue: "avgMerge(volume)"},
+}
+
+// SeriesMetrics returns the metrics GetSeries accepts, in sorted order
+func SeriesMetrics() []string {
+	names := make([]string, 0, len(seriesMetrics))
+	for name := range seriesMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PlanSeries picks the table and bucket size for a range. The requested resolution
+// (0 = automatic) is coarsened so the range fits in MaxSeriesPoints buckets; ranges
+// longer than RawSeriesMaxRange, or resolutions of an hour or more, read the hourly
+// rollup, whose buckets are whole hours.
+func PlanSeries(from, to time.Time, resolution time.Duration) (string, time.Duration) {
+	span := to.Sub(from)
+	step := resolution
+	if floor := span / MaxSeriesPoints; step < floor {
+		step = floor
+	}
+	step = step.Truncate(time.Second)
+	if step < time.Second {
+		step = time.Second
+	}
+
+	if span <= RawSeriesMaxRange && step < time.Hour {
+		// Round up so the coarsened step still fits the cap
+		if step*MaxSeriesPoints < span {
+			step += time.Second
+		}
+		return SeriesSourceRaw, step
+	}
+	if hours := (step + time.Hour - 1) / time.Hour; hours > 1 {
+		return SeriesSourceHourly, hours * time.Hour
+	}
+	return SeriesSourceHourly, time.Hour
+}
+
+// GetSeries returns a device's metric averaged into buckets over [from, to), reading
+// raw readings or the hourly rollup as chosen by PlanSeries. Readings flagged bad are
+// excluded in both, so the two sources agree.
+func (db *ClickHouseDB) GetSeries(deviceID, metric string, from, to time.Time, resolution time.Duration) (*models.Series, error) {
+	tables, ok := seriesMetrics[metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown series metric %q", metric)
+	}
+	ctx := context.Background()
+
+	source, step := PlanSeries(from, to, resolution)
+	series := &models.Series{
+		DeviceID:   deviceID,
+		Metric:     metric,
+		From:       from,
+		To:         to,
+		Source:     source,
+		Resolution: int(step / time.Second),
+		Points:     []models.SeriesPoint{},
+	}
+
+	var query string
+	if source == SeriesSourceRaw {
+		query = fmt.Sprintf(`
+			SELECT toDateTime(toStartOfInterval(timestamp, toIntervalSecond(?))) AS bucket, avg(%s)
+			FROM %s
+			WHERE device_id = ? AND timestamp >= ? AND timestamp < ? AND quality_flag != 'bad'
+			GROUP BY bucket
+			ORDER BY bucket
+		`, tables.rawValue, tables.raw)
+	} else {
+		// The first rollup hour may start before from
+		query = fmt.Sprintf(`
+			SELECT toStartOfInterval(hour, toIntervalSecond(?)) AS bucket, %s
+			FROM %s
+			WHERE device_id = ? AND hour >= toStartOfHour(toDateTime(?)) AND hour < ?
+			GROUP BY bucket
+			ORDER BY bucket
+		`, tables.rollupValue, tables.rollup)
+	}
+
+	rows, err := db.read.Query(ctx, query, series.Resolution, deviceID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s series: %w", metric, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.SeriesPoint
+		if err := rows.Scan(&p.Timestamp, &p.Value); err != nil {
+			return nil, fmt.Errorf("failed to scan %s series: %w", metric, err)
+		}
+		series.Points = append(series.Points, p)
+	}
+
+	return series, rows.Err()
+}
+
+// GetDeviceHourlyMeans returns a device's hourly means of a metric over [from, to)
+// from the hourly rollup, oldest first, however long the range
+func (db *ClickHouseDB) GetDeviceHourlyMeans(deviceID, metric string, from, to time.Time) ([]models.SeriesPoint, error) {
+	tables, ok := seriesMetrics[metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown series metric %q", metric)
+	}
+	ctx := context.Background()
+
+	query := fmt.Sprintf(`
+		SELECT hour, %s
+		FROM %s
+		WHERE device_id = ? AND hour >= toStartOfHour(toDateTime(?)) AND hour < ?
+		GROUP BY hour
+		ORDER BY hour
+	`, tables.rollupValue, tables.rollup)
+
+	rows, err := db.read.Query(ctx, query, deviceID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hourly %s: %w", metric, err)
+	}
+	defer rows.Close()
+
+	points := []models.SeriesPoint{}
+	for rows.Next() {
+		var p models.SeriesPoint
+		if err := rows.Scan(&p.Timestamp, &p.Value); err != nil {
+			return nil, fmt.Errorf("failed to scan hourly %s: %w", metric, err)
+		}
+		points = append(points, p)
+	}
+
+	return points, rows.Err()
+}
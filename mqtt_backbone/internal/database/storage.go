@@ -0,0 +1,201 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+// GetStorageReport sizes every table from system.parts and measures ingestion over
+// the last days from the tables with timestamp and device_id columns, per table and
+// per device. Device bytes are estimated from each table's average row size. At most
+// maxDevices devices are listed, the highest bytes per day first.
+func (db *ClickHouseDB) GetStorageReport(days, maxDevices int) (*models.StorageReport, error) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	from := now.Truncate(24*time.Hour).AddDate(0, 0, -days+1)
+
+	tables, err := db.tableStorage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	live, err := db.liveColumns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.StorageReport{GeneratedAt: now, Days: days, Tables: tables, Devices: []models.DeviceStorage{}}
+	devices := make(map[string]*models.DeviceStorage)
+	for i := range report.Tables {
+		t := &report.Tables[i]
+		report.Rows += t.Rows
+		report.BytesOnDisk += t.BytesOnDisk
+		if !live[t.Table]["timestamp"] || !live[t.Table]["device_id"] {
+			continue
+		}
+
+		if t.Daily, err = db.dailyRowCounts(ctx, t.Table, from); err != nil {
+			return nil, err
+		}
+		var rows uint64
+		for _, d := range t.Daily {
+			rows += d.Rows
+		}
+		t.RowsPerDay = float64(rows) / float64(days)
+		t.BytesPerDay = t.RowsPerDay * t.BytesPerRow
+		report.RowsPerDay += t.RowsPerDay
+		report.BytesPerDay += t.BytesPerDay
+
+		counts, err := db.deviceRowCounts(ctx, t.Table, from)
+		if err != nil {
+			return nil, err
+		}
+		for deviceID, n := range counts {
+			d, ok := devices[deviceID]
+			if !ok {
+				d = &models.DeviceStorage{DeviceID: deviceID, Tables: make(map[string]float64)}
+				devices[deviceID] = d
+			}
+			perDay := float64(n) / float64(days)
+			d.Tables[t.Table] = perDay
+			d.RowsPerDay += perDay
+			d.BytesPerDay += perDay * t.BytesPerRow
+		}
+	}
+
+	for _, d := range devices {
+		report.Devices = append(report.Devices, *d)
+	}
+	sort.Slice(report.Devices, func(i, j int) bool {
+		if report.Devices[i].BytesPerDay != report.Devices[j].BytesPerDay {
+			return report.Devices[i].BytesPerDay > report.Devices[j].BytesPerDay
+		}
+		return report.Devices[i].DeviceID < report.Devices[j].DeviceID
+	})
+	if maxDevices > 0 && len(report.Devices) > maxDevices {
+		report.Devices = report.Devices[:maxDevices]
+		report.DevicesTruncated = true
+	}
+	return report, nil
+}
+
+// tableStorage returns the size of every table with data parts, largest first. On
+// a cluster the parts of one replica per shard are summed under the Distributed
+// table's name.
+func (db *ClickHouseDB) tableStorage(ctx context.Context) ([]models.TableStorage, error) {
+	source := "system.parts"
+	if db.cluster.Enabled() {
+		source = fmt.Sprintf("cluster('%s', system.parts)", db.cluster.Cluster)
+	}
+	query := fmt.Sprintf(`
+		SELECT table, sum(rows), sum(bytes_on_disk), sum(data_uncompressed_bytes), count()
+		FROM %s
+		WHERE active AND database = currentDatabase()
+		GROUP BY table
+	`, source)
+
+	rows, err := db.read.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query table sizes: %w", err)
+	}
+	defer rows.Close()
+
+	byName := make(map[string]*models.TableStorage)
+	for rows.Next() {
+		var t models.TableStorage
+		if err := rows.Scan(&t.Table, &t.Rows, &t.BytesOnDisk, &t.BytesUncompressed, &t.Parts); err != nil {
+			return nil, fmt.Errorf("failed to scan table size: %w", err)
+		}
+		t.Table = strings.TrimSuffix(t.Table, "_local")
+		if existing, ok := byName[t.Table]; ok {
+			existing.Rows += t.Rows
+			existing.BytesOnDisk += t.BytesOnDisk
+			existing.BytesUncompressed += t.BytesUncompressed
+			existing.Parts += t.Parts
+			continue
+		}
+		byName[t.Table] = &t
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read table sizes: %w", err)
+	}
+
+	tables := make([]models.TableStorage, 0, len(byName))
+	for _, t := range byName {
+		if t.Rows > 0 {
+			t.BytesPerRow = float64(t.BytesOnDisk) / float64(t.Rows)
+		}
+		tables = append(tables, *t)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		if tables[i].BytesOnDisk != tables[j].BytesOnDisk {
+			return tables[i].BytesOnDisk > tables[j].BytesOnDisk
+		}
+		return tables[i].Table < tables[j].Table
+	})
+	return tables, nil
+}
+
+// dailyRowCounts returns a table's rows per UTC day of their timestamp since from
+func (db *ClickHouseDB) dailyRowCounts(ctx context.Context, table string, from time.Time) ([]models.DailyRowCount, error) {
+	query := fmt.Sprintf(`
+		SELECT toDate(timestamp, 'UTC') AS day, count()
+		FROM %s
+		WHERE timestamp >= ?
+		GROUP BY day
+		ORDER BY day
+	`, table)
+
+	rows, err := db.read.Query(ctx, query, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query daily rows of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var counts []models.DailyRowCount
+	for rows.Next() {
+		var c models.DailyRowCount
+		if err := rows.Scan(&c.Day, &c.Rows); err != nil {
+			return nil, fmt.Errorf("failed to scan daily rows of %s: %w", table, err)
+		}
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read daily rows of %s: %w", table, err)
+	}
+	return counts, nil
+}
+
+// deviceRowCounts returns a table's rows per device since from
+func (db *ClickHouseDB) deviceRowCounts(ctx context.Context, table string, from time.Time) (map[string]uint64, error) {
+	query := fmt.Sprintf(`
+		SELECT device_id, count()
+		FROM %s
+		WHERE timestamp >= ?
+		GROUP BY device_id
+	`, table)
+
+	rows, err := db.read.Query(ctx, query, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query device rows of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]uint64)
+	for rows.Next() {
+		var deviceID string
+		var n uint64
+		if err := rows.Scan(&deviceID, &n); err != nil {
+			return nil, fmt.Errorf("failed to scan device rows of %s: %w", table, err)
+		}
+		counts[deviceID] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read device rows of %s: %w", table, err)
+	}
+	return counts, nil
+}
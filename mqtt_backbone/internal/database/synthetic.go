@@ -0,0 +1,41 @@
+package database
+
+import (
+	"slices"
+	"strings"
+)
+
+// SetSyntheticDevices names devices whose readings the backend generates itself
+// (the end-to-end canary). Fleet-wide queries leave them out: rollups, exports,
+// building summaries, tag aggregates, cross-checks, and threshold statistics.
+// Queries about a single device still see them.
+func (db *ClickHouseDB) SetSyntheticDevices(deviceIDs []string) {
+	db.synthetic = append([]string(nil), deviceIDs...)
+}
+
+// fleetDevices returns the device IDs that aren't synthetic
+func (db *ClickHouseDB) fleetDevices(deviceIDs []string) []string {
+	if len(db.synthetic) == 0 {
+		return deviceIDs
+	}
+	var fleet []string
+	for _, id := range deviceIDs {
+		if !slices.Contains(db.synthetic, id) {
+			fleet = append(fleet, id)
+		}
+	}
+	return fleet
+}
+
+// fleetFilter returns a condition on a device ID column that excludes synthetic devices
+func (db *ClickHouseDB) fleetFilter(column string) string {
+	if len(db.synthetic) == 0 {
+		return "1"
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	quoted := make([]string, len(db.synthetic))
+	for i, id := range db.synthetic {
+		quoted[i] = "'" + escape.Replace(id) + "'"
+	}
+	return column + " NOT IN (" + strings.Join(quoted, ", ") + ")"
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// DeltaStats describes how much a device's metric moves between consecutive
+// polling windows, in the units the inference trigger compares
+type DeltaStats struct {
+	DeviceID      string
+	StdDev        float64 // Of the raw readings over the period (the z-score denominator)
+	Intervals     int     // Consecutive window pairs observed
+	DeltaQuantile float64 // Absolute change between windows at the requested quantile
+	Exceeding     int     // Window pairs whose change reaches zScore standard deviations
+}
+
+// GetDeltaStats averages a metric into windowSeconds buckets for every device since
+// from, and summarizes the changes between consecutive buckets: the absolute change at
+// quantile (0-1) and how many changes would have reached zScore.
+func (db *ClickHouseDB) GetDeltaStats(metric string, from time.Time, windowSeconds int, quantile, zScore float64) ([]DeltaStats, error) {
+	tables, ok := seriesMetrics[metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown metric %q", metric)
+	}
+	if quantile < 0 || quantile > 1 {
+		return nil, fmt.Errorf("quantile %v outside 0-1", quantile)
+	}
+	ctx := context.Background()
+
+	// Aggregate function parameters must be literals
+	fleet := db.fleetFilter("device_id")
+	query := fmt.Sprintf(`
+		SELECT
+			d.device_id,
+			s.sigma,
+			length(d.deltas),
+			arrayReduce('quantileExact(%s)', arrayMap(x -> abs(x), d.deltas)),
+			toUInt64(arrayCount(x -> abs(x) >= ? * s.sigma, d.deltas))
+		FROM (
+			SELECT device_id, arrayPopFront(arrayDifference(arrayMap(b -> b.2, arraySort(groupArray((bucket, mean)))))) AS deltas
+			FROM (
+				SELECT device_id, toStartOfInterval(timestamp, toIntervalSecond(?)) AS bucket, avg(%s) AS mean
+				FROM %s
+				WHERE timestamp >= ? AND quality_flag != 'bad' AND %s
+				GROUP BY device_id, bucket
+			)
+			GROUP BY device_id
+		) AS d
+		INNER JOIN (
+			SELECT device_id, stddevPop(%s) AS sigma
+			FROM %s
+			WHERE timestamp >= ? AND quality_flag != 'bad' AND %s
+			GROUP BY device_id
+		) AS s USING device_id
+		WHERE length(d.deltas) > 0 AND s.sigma > 0
+		ORDER BY d.device_id
+	`, strconv.FormatFloat(quantile, 'f', -1, 64), tables.rawValue, tables.raw, fleet, tables.rawValue, tables.raw, fleet)
+
+	rows, err := db.read.Query(ctx, query, zScore, windowSeconds, from, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s deltas: %w", metric, err)
+	}
+	defer rows.Close()
+
+	var stats []DeltaStats
+	for rows.Next() {
+		var s DeltaStats
+		var intervals, exceeding uint64
+		if err := rows.Scan(&s.DeviceID, &s.StdDev, &intervals, &s.DeltaQuantile, &exceeding); err != nil {
+			return nil, fmt.Errorf("failed to scan %s deltas: %w", metric, err)
+		}
+		s.Intervals, s.Exceeding = int(intervals), int(exceeding)
+		stats = append(stats, s)
+	}
+
+	return stats, rows.Err()
+}
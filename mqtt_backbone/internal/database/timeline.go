@@ -0,0 +1,305 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+const (
+	// MaxTimelineEvents caps the events of each kind in one timeline
+	MaxTimelineEvents = 2000
+
+	// timelineReadingBuckets is the number of reading summaries in a timeline at the
+	// automatic resolution
+	timelineReadingBuckets = 96
+)
+
+// timelineSource reads the events of one kind in [from, to), at most limit of them
+type timelineSource func(ctx context.Context, deviceID string, from, to time.Time, limit int) ([]models.TimelineEvent, error)
+
+// GetTimeline interleaves everything recorded for a device in [from, to): reading
+// summaries, inference triggers, predictions, window commands and overrides, safety
+// events, and alerts. Readings are summarized in buckets of resolution (0 = the range
+// split in timelineReadingBuckets). Each kind is limited to MaxTimelineEvents, keeping
+// the earliest; the timeline is marked truncated when a limit was hit.
+func (db *ClickHouseDB) GetTimeline(deviceID string, from, to time.Time, resolution time.Duration) (*models.Timeline, error) {
+	ctx := context.Background()
+
+	if resolution <= 0 {
+		resolution = to.Sub(from) / timelineReadingBuckets
+		if resolution < time.Minute {
+			resolution = time.Minute
+		}
+	}
+	readings, err := db.timelineReadings(deviceID, from, to, resolution)
+	if err != nil {
+		return nil, err
+	}
+
+	timeline := &models.Timeline{DeviceID: deviceID, From: from, To: to, Events: readings}
+	sources := []timelineSource{
+		db.timelineInferences,
+		db.timelinePredictions,
+		db.timelineDecisions,
+		db.timelineSafetyEvents,
+		db.timelineAlerts,
+	}
+	for _, source := range sources {
+		events, err := source(ctx, deviceID, from, to, MaxTimelineEvents+1)
+		if err != nil {
+			return nil, err
+		}
+		if len(events) > MaxTimelineEvents {
+			events = events[:MaxTimelineEvents]
+			timeline.Truncated = true
+		}
+		timeline.Events = append(timeline.Events, events...)
+	}
+
+	// Kinds were appended in causal order (readings, trigger, prediction, command), which
+	// the stable sort keeps for events recorded in the same millisecond
+	sort.SliceStable(timeline.Events, func(i, j int) bool {
+		return timeline.Events[i].Timestamp.Before(timeline.Events[j].Timestamp)
+	})
+	if timeline.Events == nil {
+		timeline.Events = []models.TimelineEvent{}
+	}
+	return timeline, nil
+}
+
+// timelineReadings summarizes the mean readings of each bucket as one event
+func (db *ClickHouseDB) timelineReadings(deviceID string, from, to time.Time, resolution time.Duration) ([]models.TimelineEvent, error) {
+	buckets := make(map[time.Time]map[string]interface{})
+	for _, metric := range SeriesMetrics() {
+		series, err := db.GetSeries(deviceID, metric, from, to, resolution)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range series.Points {
+			if buckets[p.Timestamp] == nil {
+				buckets[p.Timestamp] = map[string]interface{}{"resolution": series.Resolution}
+			}
+			buckets[p.Timestamp][metric] = p.Value
+		}
+	}
+
+	events := make([]models.TimelineEvent, 0, len(buckets))
+	for at, details := range buckets {
+		summary := "Readings:"
+		if v, ok := details["temperature"]; ok {
+			summary += fmt.Sprintf(" %.1f°C", v)
+		}
+		if v, ok := details["humidity"]; ok {
+			summary += fmt.Sprintf(" %.0f%%RH", v)
+		}
+		if v, ok := details["sound_volume"]; ok {
+			summary += fmt.Sprintf(" %.0f dB", v)
+		}
+		events = append(events, models.TimelineEvent{Timestamp: at, Kind: models.TimelineReadings, Summary: summary, Details: details})
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+	return events, nil
+}
+
+// timelineInferences reads the inference triggers
+func (db *ClickHouseDB) timelineInferences(ctx context.Context, deviceID string, from, to time.Time, limit int) ([]models.TimelineEvent, error) {
+	query := `
+		SELECT timestamp, trigger_reason, temp_z_score, humidity_z_score, volume_z_score, correlation_id
+		FROM inference_history
+		WHERE device_id = ? AND timestamp >= ? AND timestamp < ?
+		ORDER BY timestamp
+		LIMIT ?
+	`
+
+	rows, err := db.read.Query(ctx, query, deviceID, from, to, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query inference history: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.TimelineEvent
+	for rows.Next() {
+		var at time.Time
+		var reason, correlationID string
+		var tempZ, humidityZ, volumeZ float64
+		if err := rows.Scan(&at, &reason, &tempZ, &humidityZ, &volumeZ, &correlationID); err != nil {
+			return nil, fmt.Errorf("failed to scan inference history: %w", err)
+		}
+		events = append(events, models.TimelineEvent{
+			Timestamp: at,
+			Kind:      models.TimelineInference,
+			Summary:   fmt.Sprintf("Inference triggered (%s)", reason),
+			Details: map[string]interface{}{
+				"trigger_reason":   reason,
+				"temp_z_score":     tempZ,
+				"humidity_z_score": humidityZ,
+				"volume_z_score":   volumeZ,
+				"correlation_id":   correlationID,
+			},
+		})
+	}
+
+	return events, rows.Err()
+}
+
+// timelinePredictions reads the window model's predictions
+func (db *ClickHouseDB) timelinePredictions(ctx context.Context, deviceID string, from, to time.Time, limit int) ([]models.TimelineEvent, error) {
+	query := `
+		SELECT timestamp, prediction, confidence, inference_time_ms, model_version
+		FROM ml_predictions
+		WHERE device_id = ? AND timestamp >= ? AND timestamp < ?
+		ORDER BY timestamp
+		LIMIT ?
+	`
+
+	rows, err := db.read.Query(ctx, query, deviceID, from, to, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ML predictions: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.TimelineEvent
+	for rows.Next() {
+		var at time.Time
+		var prediction, confidence, inferenceMs float64
+		var modelVersion string
+		if err := rows.Scan(&at, &prediction, &confidence, &inferenceMs, &modelVersion); err != nil {
+			return nil, fmt.Errorf("failed to scan ML prediction: %w", err)
+		}
+		events = append(events, models.TimelineEvent{
+			Timestamp: at,
+			Kind:      models.TimelinePrediction,
+			Summary:   fmt.Sprintf("Model predicted %.0f%% open (confidence %.2f)", prediction, confidence),
+			Details: map[string]interface{}{
+				"prediction":        prediction,
+				"confidence":        confidence,
+				"inference_time_ms": inferenceMs,
+				"model_version":     modelVersion,
+			},
+		})
+	}
+
+	return events, rows.Err()
+}
+
+// timelineDecisions reads the arbitrated window commands. Commands won by a source
+// other than the ML service are reported as overrides.
+func (db *ClickHouseDB) timelineDecisions(ctx context.Context, deviceID string, from, to time.Time, limit int) ([]models.TimelineEvent, error) {
+	query := `
+		SELECT timestamp, position, winner, summary, trace
+		FROM window_decisions
+		WHERE device_id = ? AND timestamp >= ? AND timestamp < ?
+		ORDER BY timestamp
+		LIMIT ?
+	`
+
+	rows, err := db.read.Query(ctx, query, deviceID, from, to, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query window decisions: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.TimelineEvent
+	for rows.Next() {
+		var at time.Time
+		var position float64
+		var winner, summary, trace string
+		if err := rows.Scan(&at, &position, &winner, &summary, &trace); err != nil {
+			return nil, fmt.Errorf("failed to scan window decision: %w", err)
+		}
+		kind := models.TimelineWindowCommand
+		if winner != "ml" {
+			kind = models.TimelineOverride
+		}
+		events = append(events, models.TimelineEvent{
+			Timestamp: at,
+			Kind:      kind,
+			Summary:   fmt.Sprintf("Window set to %.0f%% by %s: %s", position, winner, summary),
+			Details: map[string]interface{}{
+				"position": position,
+				"winner":   winner,
+				"trace":    trace,
+			},
+		})
+	}
+
+	return events, rows.Err()
+}
+
+// timelineSafetyEvents reads the safety events
+func (db *ClickHouseDB) timelineSafetyEvents(ctx context.Context, deviceID string, from, to time.Time, limit int) ([]models.TimelineEvent, error) {
+	query := `
+		SELECT timestamp, event_type, value
+		FROM safety_events
+		WHERE device_id = ? AND timestamp >= ? AND timestamp < ?
+		ORDER BY timestamp
+		LIMIT ?
+	`
+
+	rows, err := db.read.Query(ctx, query, deviceID, from, to, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query safety events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.TimelineEvent
+	for rows.Next() {
+		var at time.Time
+		var eventType string
+		var value float64
+		if err := rows.Scan(&at, &eventType, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan safety event: %w", err)
+		}
+		events = append(events, models.TimelineEvent{
+			Timestamp: at,
+			Kind:      models.TimelineSafety,
+			Summary:   fmt.Sprintf("Safety event %s (%.1f)", eventType, value),
+			Details:   map[string]interface{}{"type": eventType, "value": value},
+		})
+	}
+
+	return events, rows.Err()
+}
+
+// timelineAlerts reads the raised alerts
+func (db *ClickHouseDB) timelineAlerts(ctx context.Context, deviceID string, from, to time.Time, limit int) ([]models.TimelineEvent, error) {
+	query := `
+		SELECT timestamp, type, severity, message, value
+		FROM alerts
+		WHERE device_id = ? AND timestamp >= ? AND timestamp < ?
+		ORDER BY timestamp
+		LIMIT ?
+	`
+
+	rows, err := db.read.Query(ctx, query, deviceID, from, to, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alerts: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.TimelineEvent
+	for rows.Next() {
+		var at time.Time
+		var alertType, severity, message string
+		var value float64
+		if err := rows.Scan(&at, &alertType, &severity, &message, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan alert: %w", err)
+		}
+		events = append(events, models.TimelineEvent{
+			Timestamp: at,
+			Kind:      models.TimelineAlert,
+			Summary:   fmt.Sprintf("%s alert %s: %s", severity, alertType, message),
+			Details: map[string]interface{}{
+				"type":     alertType,
+				"severity": severity,
+				"value":    value,
+			},
+		})
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+// windowUsageLookback bounds the search for a window's position before an analyzed range
+const windowUsageLookback = 30 * 24 * time.Hour
+
+// GetWindowPositionChanges returns every device's commanded positions over a range,
+// oldest first, and each device's last position before the range. Dry-run commands
+// never moved a window and are ignored.
+func (db *ClickHouseDB) GetWindowPositionChanges(from, to time.Time) (map[string]float64, map[string][]models.WindowAction, error) {
+	ctx := context.Background()
+
+	initialQuery := `
+		SELECT device_id, argMax(position, timestamp)
+		FROM window_actions
+		WHERE timestamp >= ? AND timestamp < ? AND dry_run = false
+		GROUP BY device_id
+	`
+
+	rows, err := db.read.Query(ctx, initialQuery, from.Add(-windowUsageLookback), from)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query window positions: %w", err)
+	}
+	initial := make(map[string]float64)
+	for rows.Next() {
+		var deviceID string
+		var position float64
+		if err := rows.Scan(&deviceID, &position); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to scan window position: %w", err)
+		}
+		initial[deviceID] = position
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	changesQuery := `
+		SELECT timestamp, device_id, position
+		FROM window_actions
+		WHERE timestamp >= ? AND timestamp < ? AND dry_run = false
+		ORDER BY device_id, timestamp
+	`
+
+	rows, err = db.read.Query(ctx, changesQuery, from, to)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query window actions: %w", err)
+	}
+	defer rows.Close()
+
+	changes := make(map[string][]models.WindowAction)
+	for rows.Next() {
+		var a models.WindowAction
+		if err := rows.Scan(&a.Timestamp, &a.DeviceID, &a.Position); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan window action: %w", err)
+		}
+		changes[a.DeviceID] = append(changes[a.DeviceID], a)
+	}
+
+	return initial, changes, rows.Err()
+}
+
+// GetHourlyMeans returns every device's hourly mean of a metric over a range from the
+// hourly rollups, keyed by device and then by the hour's Unix time
+func (db *ClickHouseDB) GetHourlyMeans(metric string, from, to time.Time) (map[string]map[int64]float64, error) {
+	tables, ok := seriesMetrics[metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown metric %q", metric)
+	}
+	ctx := context.Background()
+
+	query := fmt.Sprintf(`
+		SELECT device_id, hour, %s
+		FROM %s
+		WHERE hour >= ? AND hour < ? AND %s
+		GROUP BY device_id, hour
+	`, tables.rollupValue, tables.rollup, db.fleetFilter("device_id"))
+
+	rows, err := db.read.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hourly %s: %w", metric, err)
+	}
+	defer rows.Close()
+
+	means := make(map[string]map[int64]float64)
+	for rows.Next() {
+		var deviceID string
+		var hour time.Time
+		var value float64
+		if err := rows.Scan(&deviceID, &hour, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan hourly %s: %w", metric, err)
+		}
+		if means[deviceID] == nil {
+			means[deviceID] = make(map[int64]float64)
+		}
+		means[deviceID][hour.Unix()] = value
+	}
+
+	return means, rows.Err()
+}
+
+// SaveWindowUsage inserts computed window usage, replacing earlier computations of the same days and hours
+func (db *ClickHouseDB) SaveWindowUsage(days []*models.WindowUsageDay, hours []*models.WindowUsageHour, computedAt time.Time) error {
+	ctx := context.Background()
+
+	batch, err := db.conn.PrepareBatch(ctx, "INSERT INTO window_usage_daily (day, device_id, seconds, open_seconds, open_events, avg_position, computed_at)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare window usage batch: %w", err)
+	}
+	for _, d := range days {
+		if err := batch.Append(d.Day, d.DeviceID, uint32(d.Seconds), uint32(d.OpenSeconds), uint32(d.OpenEvents), d.AvgPosition, computedAt); err != nil {
+			return fmt.Errorf("failed to append window usage: %w", err)
+		}
+	}
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to insert window usage: %w", err)
+	}
+
+	batch, err = db.conn.PrepareBatch(ctx, "INSERT INTO window_usage_hourly (hour, device_id, seconds, open_seconds, avg_position, temperature, noise, computed_at)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare hourly window usage batch: %w", err)
+	}
+	for _, h := range hours {
+		if err := batch.Append(h.Hour, h.DeviceID, uint32(h.Seconds), uint32(h.OpenSeconds), h.AvgPosition, h.Temperature, h.Noise, computedAt); err != nil {
+			return fmt.Errorf("failed to append hourly window usage: %w", err)
+		}
+	}
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to insert hourly window usage: %w", err)
+	}
+
+	return nil
+}
+
+// GetWindowUsage returns a device's stored daily and hourly window usage for the days
+// and hours starting within a range, oldest first
+func (db *ClickHouseDB) GetWindowUsage(deviceID string, from, to time.Time) ([]models.WindowUsageDay, []models.WindowUsageHour, error) {
+	ctx := context.Background()
+
+	dailyQuery := `
+		SELECT day, seconds, open_seconds, open_events, avg_position
+		FROM window_usage_daily FINAL
+		WHERE device_id = ? AND day >= ? AND day < ?
+		ORDER BY day
+	`
+
+	rows, err := db.read.Query(ctx, dailyQuery, deviceID, from, to)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query window usage: %w", err)
+	}
+	var days []models.WindowUsageDay
+	for rows.Next() {
+		d := models.WindowUsageDay{DeviceID: deviceID}
+		var seconds, openSeconds, openEvents uint32
+		if err := rows.Scan(&d.Day, &seconds, &openSeconds, &openEvents, &d.AvgPosition); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to scan window usage: %w", err)
+		}
+		d.Seconds, d.OpenSeconds, d.OpenEvents = int(seconds), int(openSeconds), int(openEvents)
+		days = append(days, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	hourlyQuery := `
+		SELECT hour, seconds, open_seconds, avg_position, temperature, noise
+		FROM window_usage_hourly FINAL
+		WHERE device_id = ? AND hour >= ? AND hour < ?
+		ORDER BY hour
+	`
+
+	rows, err = db.read.Query(ctx, hourlyQuery, deviceID, from, to)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query hourly window usage: %w", err)
+	}
+	defer rows.Close()
+
+	var hours []models.WindowUsageHour
+	for rows.Next() {
+		h := models.WindowUsageHour{DeviceID: deviceID}
+		var seconds, openSeconds uint32
+		if err := rows.Scan(&h.Hour, &seconds, &openSeconds, &h.AvgPosition, &h.Temperature, &h.Noise); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan hourly window usage: %w", err)
+		}
+		h.Seconds, h.OpenSeconds = int(seconds), int(openSeconds)
+		hours = append(hours, h)
+	}
+
+	return days, hours, rows.Err()
+}
@@ -0,0 +1,138 @@
+package derived
+
+import (
+	"math"
+	"sync"
+)
+
+// AudioAnomalyConfig holds settings for clip anomaly scoring
+type AudioAnomalyConfig struct {
+	WarmupClips    int     // Clips needed before scores are reported
+	Alpha          float64 // EWMA weight of each new clip in the baseline (0-1)
+	ScoreThreshold float64 // Score above which a clip counts as anomalous
+	SustainedClips int     // Consecutive anomalous clips before an anomaly is sustained
+	RelearnClips   int     // Consecutive anomalous clips after which the baseline is relearned (e.g., device moved)
+}
+
+// DefaultAudioAnomalyConfig returns default anomaly scoring settings
+func DefaultAudioAnomalyConfig() AudioAnomalyConfig {
+	return AudioAnomalyConfig{
+		WarmupClips:    20,
+		Alpha:          0.05,
+		ScoreThreshold: 3.0,
+		SustainedClips: 3,
+		RelearnClips:   200,
+	}
+}
+
+// AnomalyResult is the outcome of scoring one clip
+type AnomalyResult struct {
+	Score     float64 // Root-mean-square z-score across features (0 during warm-up)
+	Anomalous bool    // Score above the threshold
+	Sustained bool    // The last SustainedClips clips were all anomalous
+	Streak    int     // Consecutive anomalous clips including this one
+	Warmup    bool    // Baseline still warming up
+}
+
+// featureBaseline is an exponentially weighted mean/variance of one feature
+type featureBaseline struct {
+	mean     float64
+	variance float64
+}
+
+// deviceFingerprint is one device's acoustic baseline
+type deviceFingerprint struct {
+	features map[string]*featureBaseline
+	clips    int
+	streak   int
+}
+
+// AudioAnomalyDetector keeps a rolling acoustic fingerprint per device and
+// scores each clip's deviation from it. Safe for concurrent use.
+type AudioAnomalyDetector struct {
+	config AudioAnomalyConfig
+
+	mu      sync.Mutex
+	devices map[string]*deviceFingerprint
+}
+
+// NewAudioAnomalyDetector creates a new detector
+func NewAudioAnomalyDetector(config AudioAnomalyConfig) *AudioAnomalyDetector {
+	return &AudioAnomalyDetector{
+		config:  config,
+		devices: make(map[string]*deviceFingerprint),
+	}
+}
+
+// Score compares a clip's features with the device baseline, then folds the clip
+// into the baseline. Anomalous clips are not learned, so a long alarm doesn't
+// become the new normal.
+func (d *AudioAnomalyDetector) Score(deviceID string, features map[string]float64) AnomalyResult {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fp, ok := d.devices[deviceID]
+	if !ok {
+		fp = &deviceFingerprint{features: make(map[string]*featureBaseline)}
+		d.devices[deviceID] = fp
+	}
+
+	result := AnomalyResult{Warmup: fp.clips < d.config.WarmupClips}
+	if !result.Warmup {
+		var sumSq float64
+		count := 0
+		for name, value := range features {
+			b, ok := fp.features[name]
+			if !ok {
+				continue
+			}
+			// Variance floor keeps near-constant features from producing huge z-scores
+			std := math.Max(math.Sqrt(b.variance), 1e-3*math.Max(math.Abs(b.mean), 1))
+			z := (value - b.mean) / std
+			sumSq += z * z
+			count++
+		}
+		if count > 0 {
+			result.Score = math.Sqrt(sumSq / float64(count))
+		}
+		result.Anomalous = result.Score > d.config.ScoreThreshold
+	}
+
+	if result.Anomalous {
+		fp.streak++
+	} else {
+		fp.streak = 0
+		d.learn(fp, features)
+	}
+	result.Streak = fp.streak
+	result.Sustained = d.config.SustainedClips > 0 && fp.streak >= d.config.SustainedClips
+
+	// A persistent shift is the new normal rather than an anomaly: start over
+	if d.config.RelearnClips > 0 && fp.streak >= d.config.RelearnClips {
+		d.devices[deviceID] = &deviceFingerprint{features: make(map[string]*featureBaseline)}
+	}
+
+	return result
+}
+
+// learn updates the baseline with a normal clip. Caller must hold mu.
+func (d *AudioAnomalyDetector) learn(fp *deviceFingerprint, features map[string]float64) {
+	fp.clips++
+
+	// Use a plain running average during warm-up so the first clip doesn't dominate
+	alpha := d.config.Alpha
+	if fp.clips <= d.config.WarmupClips {
+		alpha = 1.0 / float64(fp.clips)
+	}
+
+	for name, value := range features {
+		b, ok := fp.features[name]
+		if !ok {
+			fp.features[name] = &featureBaseline{mean: value}
+			continue
+		}
+		delta := value - b.mean
+		b.mean += alpha * delta
+		b.variance = (1 - alpha) * (b.variance + alpha*delta*delta)
+	}
+}
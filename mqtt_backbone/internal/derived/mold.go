@@ -0,0 +1,144 @@
+// Package derived computes indicators derived from raw sensor readings
+package derived
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+// MoldRiskConfig holds thresholds for the mold risk indicator
+type MoldRiskConfig struct {
+	HumidityThreshold float64 // Relative humidity above which mold can grow (%)
+	TemperatureMin    float64 // Lower bound of the risk temperature band (Celsius)
+	TemperatureMax    float64 // Upper bound of the risk temperature band (Celsius)
+	SustainedHours    float64 // Hours of continuous risk conditions for a full risk index
+	RecoveryFactor    float64 // Risk hours recovered per hour of safe conditions
+}
+
+// DefaultMoldRiskConfig returns default mold risk thresholds
+func DefaultMoldRiskConfig() MoldRiskConfig {
+	return MoldRiskConfig{
+		HumidityThreshold: 70.0,
+		TemperatureMin:    5.0,
+		TemperatureMax:    40.0,
+		SustainedHours:    6.0,
+		RecoveryFactor:    2.0, // Drying out is faster than accumulating risk
+	}
+}
+
+// moldState tracks the risk accumulation for one device
+type moldState struct {
+	temperature    float64
+	humidity       float64
+	hasTemperature bool
+	hasHumidity    bool
+	hoursAtRisk    float64
+	lastUpdate     time.Time
+}
+
+// MoldRiskTracker accumulates time spent in mold-favourable conditions per device.
+// Safe for concurrent use.
+type MoldRiskTracker struct {
+	config MoldRiskConfig
+
+	mu      sync.Mutex
+	devices map[string]*moldState
+}
+
+// NewMoldRiskTracker creates a new mold risk tracker
+func NewMoldRiskTracker(config MoldRiskConfig) *MoldRiskTracker {
+	return &MoldRiskTracker{
+		config:  config,
+		devices: make(map[string]*moldState),
+	}
+}
+
+// UpdateTemperature records a temperature reading and returns the updated risk
+func (t *MoldRiskTracker) UpdateTemperature(deviceID string, value float64, timestamp time.Time) (models.MoldRisk, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.state(deviceID)
+	t.advance(st, timestamp)
+	st.temperature, st.hasTemperature = value, true
+	return t.risk(deviceID, st, timestamp)
+}
+
+// UpdateHumidity records a humidity reading and returns the updated risk
+func (t *MoldRiskTracker) UpdateHumidity(deviceID string, value float64, timestamp time.Time) (models.MoldRisk, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.state(deviceID)
+	t.advance(st, timestamp)
+	st.humidity, st.hasHumidity = value, true
+	return t.risk(deviceID, st, timestamp)
+}
+
+// Get returns the current risk for a device. ok is false until both temperature and humidity were seen.
+func (t *MoldRiskTracker) Get(deviceID string) (models.MoldRisk, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st, ok := t.devices[deviceID]
+	if !ok {
+		return models.MoldRisk{}, false
+	}
+	return t.risk(deviceID, st, st.lastUpdate)
+}
+
+// state returns the tracker state for a device. Caller must hold mu.
+func (t *MoldRiskTracker) state(deviceID string) *moldState {
+	st, ok := t.devices[deviceID]
+	if !ok {
+		st = &moldState{}
+		t.devices[deviceID] = st
+	}
+	return st
+}
+
+// advance accumulates (or recovers) risk hours for the time since the last update,
+// using the conditions that held during that interval. Caller must hold mu.
+func (t *MoldRiskTracker) advance(st *moldState, now time.Time) {
+	if !st.lastUpdate.IsZero() && st.hasTemperature && st.hasHumidity && now.After(st.lastUpdate) {
+		elapsed := now.Sub(st.lastUpdate).Hours()
+		if t.atRisk(st) {
+			st.hoursAtRisk += elapsed
+		} else {
+			st.hoursAtRisk = math.Max(0, st.hoursAtRisk-elapsed*t.config.RecoveryFactor)
+		}
+		// Accumulation beyond the sustained window carries no extra information
+		st.hoursAtRisk = math.Min(st.hoursAtRisk, t.config.SustainedHours*2)
+	}
+	if now.After(st.lastUpdate) {
+		st.lastUpdate = now
+	}
+}
+
+// atRisk reports whether current conditions favour mold growth
+func (t *MoldRiskTracker) atRisk(st *moldState) bool {
+	return st.humidity > t.config.HumidityThreshold &&
+		st.temperature >= t.config.TemperatureMin &&
+		st.temperature <= t.config.TemperatureMax
+}
+
+// risk builds the indicator for a device. Caller must hold mu.
+func (t *MoldRiskTracker) risk(deviceID string, st *moldState, timestamp time.Time) (models.MoldRisk, bool) {
+	if !st.hasTemperature || !st.hasHumidity {
+		return models.MoldRisk{}, false
+	}
+
+	index := 0.0
+	if t.config.SustainedHours > 0 {
+		index = math.Min(1.0, st.hoursAtRisk/t.config.SustainedHours)
+	}
+
+	return models.MoldRisk{
+		Timestamp:   timestamp,
+		DeviceID:    deviceID,
+		RiskIndex:   index,
+		HoursAtRisk: st.hoursAtRisk,
+		Temperature: st.temperature,
+		Humidity:    st.humidity,
+	}, true
+}
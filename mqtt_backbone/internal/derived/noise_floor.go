@@ -0,0 +1,189 @@
+package derived
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"iot-backend/internal/aggregator"
+)
+
+// NoiseFloorConfig holds settings for per-device noise floor calibration
+type NoiseFloorConfig struct {
+	CalibrationHours int // Rolling window the quiet baseline is learned over
+	MinFrames        int // Frames needed before a provisional floor is reported
+}
+
+// DefaultNoiseFloorConfig returns default calibration settings
+func DefaultNoiseFloorConfig() NoiseFloorConfig {
+	return NoiseFloorConfig{
+		CalibrationHours: 24,
+		MinFrames:        80, // ~10 s of audio
+	}
+}
+
+// NoiseFloor is a device's learned quiet baseline
+type NoiseFloor struct {
+	Level      float64 `json:"level"`      // Background level (dB), the frame level exceeded 90% of the window
+	Calibrated bool    `json:"calibrated"` // A full calibration window has been observed
+	Hours      int     `json:"hours"`      // Hours of audio the floor is based on
+}
+
+// Relative returns a level in dB above the noise floor
+func (f NoiseFloor) Relative(level float64) float64 {
+	return level - f.Level
+}
+
+// floorState holds one device's hourly frame histograms
+type floorState struct {
+	hours     map[time.Time]*aggregator.LevelHistogram
+	firstSeen time.Time
+
+	seed *NoiseFloor // Restored floor used until the window is covered in memory
+}
+
+// NoiseFloorCalibrator learns each device's quiet baseline from a rolling
+// window of frame levels, so microphones with different self-noise can be
+// compared by loudness relative to their own floor. Safe for concurrent use.
+type NoiseFloorCalibrator struct {
+	config NoiseFloorConfig
+	window time.Duration
+
+	mu      sync.Mutex
+	devices map[string]*floorState
+}
+
+// NewNoiseFloorCalibrator creates a new calibrator
+func NewNoiseFloorCalibrator(config NoiseFloorConfig) *NoiseFloorCalibrator {
+	if config.CalibrationHours <= 0 {
+		config.CalibrationHours = DefaultNoiseFloorConfig().CalibrationHours
+	}
+	return &NoiseFloorCalibrator{
+		config:  config,
+		window:  time.Duration(config.CalibrationHours) * time.Hour,
+		devices: make(map[string]*floorState),
+	}
+}
+
+// CalibrationHours returns the length of the calibration window
+func (c *NoiseFloorCalibrator) CalibrationHours() int {
+	return c.config.CalibrationHours
+}
+
+// Known reports whether the calibrator has any state for a device
+func (c *NoiseFloorCalibrator) Known(deviceID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.devices[deviceID]
+	return ok
+}
+
+// Seed restores a device's floor from stored hourly background levels (L90 per hour).
+// The floor is the quietest tenth of those hours, matching what the rolling window learns.
+func (c *NoiseFloorCalibrator) Seed(deviceID string, hourlyL90 []float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := c.state(deviceID)
+	if len(hourlyL90) == 0 {
+		return
+	}
+
+	sorted := append([]float64(nil), hourlyL90...)
+	sort.Float64s(sorted)
+	st.seed = &NoiseFloor{
+		Level:      sorted[len(sorted)/10],
+		Calibrated: len(sorted) >= c.config.CalibrationHours*3/4, // Tolerate gaps in the stored hours
+		Hours:      len(sorted),
+	}
+}
+
+// Add records a clip's frame levels and returns the device's current floor.
+// ok is false while there isn't enough audio to report a floor.
+func (c *NoiseFloorCalibrator) Add(deviceID string, timestamp time.Time, levels []float64) (floor NoiseFloor, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := c.state(deviceID)
+	if st.firstSeen.IsZero() || timestamp.Before(st.firstSeen) {
+		st.firstSeen = timestamp
+	}
+
+	hour := timestamp.Truncate(time.Hour)
+	hist, exists := st.hours[hour]
+	if !exists {
+		hist = &aggregator.LevelHistogram{}
+		st.hours[hour] = hist
+	}
+	hist.Add(levels)
+
+	// Drop hours that left the window
+	cutoff := timestamp.Add(-c.window)
+	for start := range st.hours {
+		if !start.After(cutoff.Truncate(time.Hour)) {
+			delete(st.hours, start)
+		}
+	}
+
+	return c.floor(st, timestamp)
+}
+
+// Get returns a device's current floor
+func (c *NoiseFloorCalibrator) Get(deviceID string) (NoiseFloor, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st, exists := c.devices[deviceID]
+	if !exists {
+		return NoiseFloor{}, false
+	}
+	return c.floor(st, time.Now())
+}
+
+// Floors returns the current floor of every device that has one
+func (c *NoiseFloorCalibrator) Floors() map[string]NoiseFloor {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	floors := make(map[string]NoiseFloor, len(c.devices))
+	for deviceID, st := range c.devices {
+		if floor, ok := c.floor(st, now); ok {
+			floors[deviceID] = floor
+		}
+	}
+	return floors
+}
+
+// state returns a device's state, creating it if needed. Caller must hold mu.
+func (c *NoiseFloorCalibrator) state(deviceID string) *floorState {
+	st, exists := c.devices[deviceID]
+	if !exists {
+		st = &floorState{hours: make(map[time.Time]*aggregator.LevelHistogram)}
+		c.devices[deviceID] = st
+	}
+	return st
+}
+
+// floor computes the floor from in-memory hours, falling back to the seed
+// until a full window has been observed. Caller must hold mu.
+func (c *NoiseFloorCalibrator) floor(st *floorState, now time.Time) (NoiseFloor, bool) {
+	var merged aggregator.LevelHistogram
+	for _, hist := range st.hours {
+		merged.Merge(hist)
+	}
+
+	covered := !st.firstSeen.IsZero() && now.Sub(st.firstSeen) >= c.window
+	if !covered && st.seed != nil {
+		return *st.seed, true
+	}
+	if merged.Count() < c.config.MinFrames {
+		return NoiseFloor{}, false
+	}
+
+	return NoiseFloor{
+		Level:      merged.Stats().L90,
+		Calibrated: covered,
+		Hours:      len(st.hours),
+	}, true
+}
@@ -0,0 +1,214 @@
+package derived
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+// OccupancyConfig holds the evidence model for occupancy estimation.
+// Each source contributes a likelihood ratio (how much more likely the
+// observation is in an occupied room than an empty one); ratios combine
+// with the prior as independent evidence (naive Bayes).
+type OccupancyConfig struct {
+	Prior            float64       // Probability a room is occupied with no evidence (0-1)
+	OccupiedAbove    float64       // Probability at which a room is reported occupied
+	EvidenceLifetime time.Duration // Sound and CO2 evidence older than this is ignored
+
+	// Sound: loudness above the device's noise floor
+	SoundMidpointDB float64 // Relative level that is neutral evidence
+	SoundScaleDB    float64 // dB from the midpoint at which sound evidence saturates
+	SoundLR         float64 // Likelihood ratio at saturation
+	SoundHalfLife   time.Duration
+
+	// Motion: PIR triggers
+	MotionLR       float64       // Likelihood ratio right after a trigger
+	NoMotionLR     float64       // Likelihood ratio once the PIR has been quiet for MotionWindow
+	MotionWindow   time.Duration // How long a trigger counts as evidence of presence
+	MotionHalfLife time.Duration
+
+	// CO2: rising concentration indicates people breathing in the room
+	CO2Window      time.Duration // Readings used for the slope
+	CO2SlopeScale  float64       // ppm/min at which CO2 evidence saturates
+	CO2LR          float64       // Likelihood ratio at saturation
+	CO2MinReadings int           // Readings needed before a slope is trusted
+}
+
+// DefaultOccupancyConfig returns default occupancy evidence settings
+func DefaultOccupancyConfig() OccupancyConfig {
+	return OccupancyConfig{
+		Prior:            0.3,
+		OccupiedAbove:    0.5,
+		EvidenceLifetime: 30 * time.Minute,
+
+		SoundMidpointDB: 6,
+		SoundScaleDB:    10,
+		SoundLR:         6,
+		SoundHalfLife:   5 * time.Minute,
+
+		MotionLR:       12,
+		NoMotionLR:     0.3,
+		MotionWindow:   15 * time.Minute,
+		MotionHalfLife: 5 * time.Minute,
+
+		CO2Window:      15 * time.Minute,
+		CO2SlopeScale:  5,
+		CO2LR:          5,
+		CO2MinReadings: 3,
+	}
+}
+
+// co2Sample is one CO2 reading
+type co2Sample struct {
+	ppm       float64
+	timestamp time.Time
+}
+
+// occupancyState holds the latest evidence for one device
+type occupancyState struct {
+	soundDB  float64
+	soundAt  time.Time
+	motionAt time.Time
+	hasPIR   bool // A PIR has reported at least once, so silence from it is evidence
+	co2      []co2Sample
+}
+
+// OccupancyEstimator fuses sound, motion, and CO2 slope into an occupancy
+// probability per device (one device per room). Safe for concurrent use.
+type OccupancyEstimator struct {
+	config OccupancyConfig
+
+	mu      sync.Mutex
+	devices map[string]*occupancyState
+}
+
+// NewOccupancyEstimator creates a new occupancy estimator
+func NewOccupancyEstimator(config OccupancyConfig) *OccupancyEstimator {
+	return &OccupancyEstimator{
+		config:  config,
+		devices: make(map[string]*occupancyState),
+	}
+}
+
+// UpdateSound records a clip's loudness above the noise floor and returns the updated estimate
+func (e *OccupancyEstimator) UpdateSound(deviceID string, relativeDB float64, timestamp time.Time) models.Occupancy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st := e.state(deviceID)
+	st.soundDB, st.soundAt = relativeDB, timestamp
+	return e.estimate(deviceID, st, timestamp)
+}
+
+// UpdateMotion records a PIR reading (triggered or idle) and returns the updated estimate
+func (e *OccupancyEstimator) UpdateMotion(deviceID string, motion bool, timestamp time.Time) models.Occupancy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st := e.state(deviceID)
+	st.hasPIR = true
+	if motion {
+		st.motionAt = timestamp
+	}
+	return e.estimate(deviceID, st, timestamp)
+}
+
+// UpdateCO2 records a CO2 reading (ppm) and returns the updated estimate
+func (e *OccupancyEstimator) UpdateCO2(deviceID string, ppm float64, timestamp time.Time) models.Occupancy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st := e.state(deviceID)
+	st.co2 = append(st.co2, co2Sample{ppm: ppm, timestamp: timestamp})
+	cutoff := timestamp.Add(-e.config.CO2Window)
+	for len(st.co2) > 0 && st.co2[0].timestamp.Before(cutoff) {
+		st.co2 = st.co2[1:]
+	}
+	return e.estimate(deviceID, st, timestamp)
+}
+
+// Get returns the current estimate for a device, evaluated now. ok is false for unknown devices.
+func (e *OccupancyEstimator) Get(deviceID string) (models.Occupancy, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st, ok := e.devices[deviceID]
+	if !ok {
+		return models.Occupancy{}, false
+	}
+	return e.estimate(deviceID, st, time.Now()), true
+}
+
+// state returns the estimator state for a device. Caller must hold mu.
+func (e *OccupancyEstimator) state(deviceID string) *occupancyState {
+	st, ok := e.devices[deviceID]
+	if !ok {
+		st = &occupancyState{}
+		e.devices[deviceID] = st
+	}
+	return st
+}
+
+// estimate combines the evidence into a posterior at the given time. Caller must hold mu.
+func (e *OccupancyEstimator) estimate(deviceID string, st *occupancyState, now time.Time) models.Occupancy {
+	occ := models.Occupancy{Timestamp: now, DeviceID: deviceID}
+
+	// Sound: saturating evidence around the midpoint, fading as the clip ages
+	if !st.soundAt.IsZero() && now.Sub(st.soundAt) <= e.config.EvidenceLifetime && e.config.SoundScaleDB > 0 {
+		strength := clamp((st.soundDB-e.config.SoundMidpointDB)/e.config.SoundScaleDB, -1, 1)
+		occ.SoundEvidence = strength * math.Log(e.config.SoundLR) * decay(now.Sub(st.soundAt), e.config.SoundHalfLife)
+	}
+
+	// Motion: a recent trigger is strong evidence; a PIR quiet for the whole window argues for an empty room
+	if st.hasPIR {
+		if since := now.Sub(st.motionAt); !st.motionAt.IsZero() && since <= e.config.MotionWindow {
+			occ.MotionEvidence = math.Log(e.config.MotionLR) * decay(since, e.config.MotionHalfLife)
+		} else {
+			occ.MotionEvidence = math.Log(e.config.NoMotionLR)
+		}
+	}
+
+	// CO2: rising concentration needs occupants; falling is mild evidence of an empty room
+	if len(st.co2) >= e.config.CO2MinReadings && now.Sub(st.co2[len(st.co2)-1].timestamp) <= e.config.EvidenceLifetime {
+		if slope, ok := co2Slope(st.co2); ok && e.config.CO2SlopeScale > 0 {
+			occ.CO2Slope = slope
+			occ.CO2Evidence = clamp(slope/e.config.CO2SlopeScale, -1, 1) * math.Log(e.config.CO2LR)
+		}
+	}
+
+	prior := clamp(e.config.Prior, 0.01, 0.99)
+	logit := math.Log(prior/(1-prior)) + occ.SoundEvidence + occ.MotionEvidence + occ.CO2Evidence
+	occ.Probability = 1 / (1 + math.Exp(-logit))
+	occ.Occupied = occ.Probability >= e.config.OccupiedAbove
+	return occ
+}
+
+// co2Slope fits a least-squares line through the samples and returns ppm per minute
+func co2Slope(samples []co2Sample) (float64, bool) {
+	origin := samples[0].timestamp
+	var sumX, sumY, sumXX, sumXY float64
+	for _, s := range samples {
+		x := s.timestamp.Sub(origin).Minutes()
+		sumX += x
+		sumY += s.ppm
+		sumXX += x * x
+		sumXY += x * s.ppm
+	}
+	n := float64(len(samples))
+	denom := n*sumXX - sumX*sumX
+	if denom <= 0 {
+		return 0, false
+	}
+	return (n*sumXY - sumX*sumY) / denom, true
+}
+
+// decay halves evidence weight every halfLife
+func decay(age, halfLife time.Duration) float64 {
+	if halfLife <= 0 || age <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, age.Seconds()/halfLife.Seconds())
+}
+
+// clamp limits v to [lo, hi]
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
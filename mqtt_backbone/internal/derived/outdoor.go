@@ -0,0 +1,47 @@
+package derived
+
+import (
+	"sync"
+	"time"
+)
+
+// outdoorReading is the latest outdoor temperature of one device
+type outdoorReading struct {
+	value float64
+	at    time.Time
+}
+
+// OutdoorTemperatures keeps the latest outdoor temperature (°C) each device
+// reported in "outdoor_temperature" safety events. Safe for concurrent use.
+type OutdoorTemperatures struct {
+	maxAge time.Duration // Older temperatures are no longer current
+
+	mu      sync.Mutex
+	devices map[string]outdoorReading
+}
+
+// NewOutdoorTemperatures creates an empty tracker whose temperatures stay current for maxAge
+func NewOutdoorTemperatures(maxAge time.Duration) *OutdoorTemperatures {
+	return &OutdoorTemperatures{maxAge: maxAge, devices: make(map[string]outdoorReading)}
+}
+
+// Update records an outdoor temperature; older reports than the latest are ignored
+func (o *OutdoorTemperatures) Update(deviceID string, value float64, at time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if last, ok := o.devices[deviceID]; ok && at.Before(last.at) {
+		return
+	}
+	o.devices[deviceID] = outdoorReading{value: value, at: at}
+}
+
+// Get returns a device's outdoor temperature if it is still current at now
+func (o *OutdoorTemperatures) Get(deviceID string, now time.Time) (float64, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	r, ok := o.devices[deviceID]
+	if !ok || now.Sub(r.at) > o.maxAge {
+		return 0, false
+	}
+	return r.value, true
+}
@@ -0,0 +1,424 @@
+// Package discovery advertises the backend on the LAN with multicast DNS (RFC 6762)
+// and DNS-SD service records (RFC 6763), so freshly flashed ESP32s can find the
+// MQTT broker and the HTTP API without hardcoded addresses, e.g. with ESP-IDF's
+// mdns_query_ptr("_mqtt", "_tcp", ...).
+//
+// The responder answers PTR, SRV, TXT, and A queries for its own records and
+// announces them at startup; on shutdown it sends goodbyes so caches drop them.
+// It doesn't probe for name conflicts, so the instance name and host name must be
+// unique on the LAN.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"iot-backend/internal/metrics"
+)
+
+// mDNS multicast group and port
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+const (
+	// servicesName lists the service types on the link (DNS-SD service type enumeration)
+	servicesName = "_services._dns-sd._udp.local."
+
+	// cacheFlush marks records this host owns exclusively, in the class field
+	cacheFlush = 1 << 15
+
+	// unicastResponse asks for a unicast reply, in the question class field
+	unicastResponse = 1 << 15
+
+	// legacyTTL caps record lifetimes in replies to one-shot (non-5353) resolvers
+	legacyTTL = 10 * time.Second
+
+	// maxTXTEntry is the longest "key=value" string a TXT record holds
+	maxTXTEntry = 255
+)
+
+// Service is a service advertised under the backend's instance name
+type Service struct {
+	Type string   // DNS-SD service type, e.g. "_mqtt._tcp"
+	Port int      // TCP port the service listens on
+	Host string   // Host running the service; empty is this host, an IP is advertised under a name of its own
+	TXT  []string // "key=value" metadata, e.g. "tls=0"
+}
+
+// AdvertiserConfig holds configuration for the mDNS advertiser
+type AdvertiserConfig struct {
+	Instance  string        // Service instance name shown to browsers, e.g. "iot-backend"
+	Hostname  string        // Name of this host in the .local domain (empty = the OS host name)
+	Interface string        // Network interface to advertise on (empty = the system's default multicast interface)
+	TTL       time.Duration // Lifetime of the advertised records in caches
+	Services  []Service
+}
+
+// DefaultAdvertiserConfig returns default configuration
+func DefaultAdvertiserConfig() AdvertiserConfig {
+	return AdvertiserConfig{
+		Instance: "iot-backend",
+		TTL:      2 * time.Minute,
+	}
+}
+
+// Advertiser answers mDNS queries for the backend's services
+type Advertiser struct {
+	config   AdvertiserConfig
+	hostname string // FQDN of this host, e.g. "backend.local."
+	iface    *net.Interface
+
+	services []advertisedService
+}
+
+// advertisedService is a service with its DNS names resolved
+type advertisedService struct {
+	Service
+	typeName     string // e.g. "_mqtt._tcp.local."
+	instanceName string // e.g. "iot-backend._mqtt._tcp.local."
+	target       string // SRV target host
+	targetIP     net.IP // Address of a target other than this host (nil when it resolves elsewhere)
+}
+
+// NewAdvertiser validates the configuration and builds the service records
+func NewAdvertiser(config AdvertiserConfig) (*Advertiser, error) {
+	if config.Instance == "" || strings.Contains(config.Instance, ".") {
+		return nil, fmt.Errorf("mDNS instance name %q must be non-empty and contain no dots", config.Instance)
+	}
+	if config.TTL < time.Second {
+		return nil, fmt.Errorf("mDNS record TTL must be at least 1s, got %v", config.TTL)
+	}
+	if len(config.Services) == 0 {
+		return nil, fmt.Errorf("no services to advertise")
+	}
+
+	hostname := config.Hostname
+	if hostname == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get host name: %w", err)
+		}
+		hostname, _, _ = strings.Cut(h, ".")
+	}
+	hostname = strings.TrimSuffix(strings.TrimSuffix(hostname, "."), ".local")
+
+	a := &Advertiser{config: config, hostname: hostname + ".local."}
+	if config.Interface != "" {
+		iface, err := net.InterfaceByName(config.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find mDNS interface %s: %w", config.Interface, err)
+		}
+		a.iface = iface
+	}
+
+	seen := make(map[string]bool)
+	for _, svc := range config.Services {
+		if !strings.HasPrefix(svc.Type, "_") || (!strings.HasSuffix(svc.Type, "._tcp") && !strings.HasSuffix(svc.Type, "._udp")) {
+			return nil, fmt.Errorf("invalid service type %q, expected e.g. _mqtt._tcp", svc.Type)
+		}
+		if seen[svc.Type] {
+			return nil, fmt.Errorf("service type %s is advertised twice", svc.Type)
+		}
+		seen[svc.Type] = true
+		if svc.Port < 1 || svc.Port > 65535 {
+			return nil, fmt.Errorf("service %s port %d out of range", svc.Type, svc.Port)
+		}
+		for _, entry := range svc.TXT {
+			if len(entry) > maxTXTEntry {
+				return nil, fmt.Errorf("service %s TXT entry %.20q... is over %d bytes", svc.Type, entry, maxTXTEntry)
+			}
+		}
+
+		adv := advertisedService{
+			Service:      svc,
+			typeName:     svc.Type + ".local.",
+			instanceName: config.Instance + "." + svc.Type + ".local.",
+			target:       a.hostname,
+		}
+		switch ip := net.ParseIP(svc.Host); {
+		case svc.Host == "":
+		case ip != nil && ip.To4() != nil:
+			// Devices can't resolve a bare address, so it gets a .local name answered here
+			adv.target = fmt.Sprintf("%s-%s.local.", hostname, strings.TrimPrefix(strings.SplitN(svc.Type, ".", 2)[0], "_"))
+			adv.targetIP = ip.To4()
+		case ip != nil:
+			return nil, fmt.Errorf("service %s host %s: only IPv4 addresses are advertised", svc.Type, svc.Host)
+		default:
+			adv.target = strings.TrimSuffix(svc.Host, ".") + "."
+		}
+		for _, name := range []string{adv.instanceName, adv.target} {
+			if _, err := dnsmessage.NewName(name); err != nil {
+				return nil, fmt.Errorf("invalid mDNS name %s: %w", name, err)
+			}
+		}
+		a.services = append(a.services, adv)
+	}
+	return a, nil
+}
+
+// Start announces the services, answers queries until context is cancelled, and
+// then sends goodbyes
+func (a *Advertiser) Start(ctx context.Context) {
+	conn, err := net.ListenMulticastUDP("udp4", a.iface, mdnsGroup)
+	if err != nil {
+		log.Printf("mDNS: Failed to join multicast group, services not advertised: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	for _, svc := range a.services {
+		log.Printf("mDNS: Advertising %s on %s:%d", svc.instanceName, svc.target, svc.Port)
+	}
+
+	// Announce twice, a second apart (RFC 6762 section 8.3)
+	go func() {
+		for i := 0; i < 2; i++ {
+			a.send(conn, mdnsGroup, a.announcement(a.config.TTL))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		a.send(conn, mdnsGroup, a.announcement(0))
+		conn.Close()
+	}()
+
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				log.Println("mDNS: Stopped")
+				return
+			}
+			log.Printf("mDNS: Error reading query: %v", err)
+			continue
+		}
+		a.handleQuery(conn, src, buf[:n])
+	}
+}
+
+// handleQuery answers the questions of a query that concern this host's records
+func (a *Advertiser) handleQuery(conn *net.UDPConn, src *net.UDPAddr, packet []byte) {
+	var query dnsmessage.Message
+	if err := query.Unpack(packet); err != nil || query.Header.Response || query.Header.OpCode != 0 {
+		return // Not a well-formed query (other responders' answers included)
+	}
+
+	// One-shot resolvers query from an ephemeral port and expect a classic DNS reply
+	legacy := src.Port != mdnsGroup.Port
+	ttl := a.config.TTL
+	if legacy && ttl > legacyTTL {
+		ttl = legacyTTL
+	}
+
+	records := a.records(ttl)
+	var answers []dnsmessage.Resource
+	unicast := legacy
+	for _, q := range query.Questions {
+		matched := false
+		for _, r := range records {
+			if strings.EqualFold(r.Header.Name.String(), q.Name.String()) && (q.Type == r.Header.Type || q.Type == dnsmessage.TypeALL) {
+				answers = appendUnique(answers, r)
+				matched = true
+			}
+		}
+		if matched && q.Class&unicastResponse != 0 {
+			unicast = true
+		}
+	}
+	if len(answers) == 0 {
+		return
+	}
+	metrics.Default.Counter("mdns_queries_answered").Inc()
+
+	reply := dnsmessage.Message{
+		Header:      dnsmessage.Header{Response: true, Authoritative: true},
+		Answers:     answers,
+		Additionals: a.additionals(answers, records),
+	}
+	dst := mdnsGroup
+	if legacy {
+		// Legacy replies echo the query and must not set the cache-flush bit
+		reply.Header.ID = query.Header.ID
+		reply.Questions = query.Questions
+		for _, section := range [][]dnsmessage.Resource{reply.Answers, reply.Additionals} {
+			for i := range section {
+				section[i].Header.Class &^= cacheFlush
+			}
+		}
+	}
+	if unicast {
+		dst = src
+	}
+	a.send(conn, dst, reply)
+}
+
+// additionals returns the records a resolver needs next for the given answers:
+// SRV and TXT for instances, addresses for hosts (RFC 6763 section 12)
+func (a *Advertiser) additionals(answers, records []dnsmessage.Resource) []dnsmessage.Resource {
+	want := make(map[string]bool)
+	for _, r := range answers {
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			want[strings.ToLower(body.PTR.String())] = true
+			for _, svc := range a.services {
+				if strings.EqualFold(svc.instanceName, body.PTR.String()) {
+					want[strings.ToLower(svc.target)] = true
+				}
+			}
+		case *dnsmessage.SRVResource:
+			want[strings.ToLower(body.Target.String())] = true
+		}
+	}
+
+	var extra []dnsmessage.Resource
+	for _, r := range records {
+		if r.Header.Type == dnsmessage.TypePTR || !want[strings.ToLower(r.Header.Name.String())] {
+			continue
+		}
+		if !containsResource(answers, r) {
+			extra = appendUnique(extra, r)
+		}
+	}
+	return extra
+}
+
+// announcement is an unsolicited response carrying every record; a zero TTL says goodbye
+func (a *Advertiser) announcement(ttl time.Duration) dnsmessage.Message {
+	return dnsmessage.Message{
+		Header:  dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: a.records(ttl),
+	}
+}
+
+// records returns every record this host answers for
+func (a *Advertiser) records(ttl time.Duration) []dnsmessage.Resource {
+	seconds := uint32(ttl / time.Second)
+	header := func(name string, typ dnsmessage.Type, unique bool) dnsmessage.ResourceHeader {
+		class := dnsmessage.ClassINET
+		if unique {
+			class |= cacheFlush
+		}
+		return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: typ, Class: class, TTL: seconds}
+	}
+
+	var records []dnsmessage.Resource
+	for _, ip := range a.addresses() {
+		records = append(records, dnsmessage.Resource{
+			Header: header(a.hostname, dnsmessage.TypeA, true),
+			Body:   &dnsmessage.AResource{A: ip},
+		})
+	}
+	for _, svc := range a.services {
+		records = append(records,
+			dnsmessage.Resource{
+				Header: header(servicesName, dnsmessage.TypePTR, false),
+				Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(svc.typeName)},
+			},
+			dnsmessage.Resource{
+				Header: header(svc.typeName, dnsmessage.TypePTR, false),
+				Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(svc.instanceName)},
+			},
+			dnsmessage.Resource{
+				Header: header(svc.instanceName, dnsmessage.TypeSRV, true),
+				Body:   &dnsmessage.SRVResource{Port: uint16(svc.Port), Target: dnsmessage.MustNewName(svc.target)},
+			},
+			dnsmessage.Resource{
+				Header: header(svc.instanceName, dnsmessage.TypeTXT, true),
+				Body:   &dnsmessage.TXTResource{TXT: txtEntries(svc.TXT)},
+			},
+		)
+		if svc.targetIP != nil {
+			var ip [4]byte
+			copy(ip[:], svc.targetIP)
+			records = append(records, dnsmessage.Resource{
+				Header: header(svc.target, dnsmessage.TypeA, true),
+				Body:   &dnsmessage.AResource{A: ip},
+			})
+		}
+	}
+	return records
+}
+
+// addresses returns the IPv4 addresses of the advertising interface, or of every
+// multicast-capable interface that is up when none is configured
+func (a *Advertiser) addresses() [][4]byte {
+	ifaces := []net.Interface{}
+	if a.iface != nil {
+		ifaces = append(ifaces, *a.iface)
+	} else if all, err := net.Interfaces(); err == nil {
+		ifaces = all
+	}
+
+	var ips [][4]byte
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			var ip [4]byte
+			copy(ip[:], ipNet.IP.To4())
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// send packs and writes a message, logging failures
+func (a *Advertiser) send(conn *net.UDPConn, dst *net.UDPAddr, msg dnsmessage.Message) {
+	packet, err := msg.Pack()
+	if err != nil {
+		log.Printf("mDNS: Error packing response: %v", err)
+		return
+	}
+	if _, err := conn.WriteToUDP(packet, dst); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("mDNS: Error sending response to %v: %v", dst, err)
+	}
+}
+
+// txtEntries returns TXT strings; an empty record holds one empty string (RFC 6763 section 6.1)
+func txtEntries(entries []string) []string {
+	if len(entries) == 0 {
+		return []string{""}
+	}
+	return entries
+}
+
+// appendUnique appends a resource unless an identical one is already present
+func appendUnique(resources []dnsmessage.Resource, r dnsmessage.Resource) []dnsmessage.Resource {
+	if containsResource(resources, r) {
+		return resources
+	}
+	return append(resources, r)
+}
+
+// containsResource reports whether a resource with the same header and data is present
+func containsResource(resources []dnsmessage.Resource, r dnsmessage.Resource) bool {
+	for _, existing := range resources {
+		if existing.Header == r.Header && existing.Body.GoString() == r.Body.GoString() {
+			return true
+		}
+	}
+	return false
+}
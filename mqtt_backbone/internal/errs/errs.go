@@ -0,0 +1,95 @@
+// Package errs defines the classes of failure shared across the backend.
+// Errors are wrapped with their class where the failure is detected, so callers
+// can test for it with errors.Is and operators can alert on the per-class
+// counters (e.g., "errors_storage_unavailable") instead of grepping log text.
+package errs
+
+import (
+	"errors"
+
+	"iot-backend/internal/metrics"
+)
+
+var (
+	// ErrPayloadInvalid marks messages that could not be parsed or failed validation
+	ErrPayloadInvalid = errors.New("invalid payload")
+
+	// ErrStorageUnavailable marks ClickHouse failures expected to clear on retry
+	// (unreachable host, timeout, overload)
+	ErrStorageUnavailable = errors.New("storage unavailable")
+
+	// ErrBrokerDisconnected marks MQTT operations that failed because the broker was unreachable
+	ErrBrokerDisconnected = errors.New("broker disconnected")
+
+	// ErrModelInvalid marks ML models, or model outputs, that can't be used
+	ErrModelInvalid = errors.New("invalid model")
+
+	// ErrUnauthorized marks messages whose sender couldn't be verified as the device they name
+	ErrUnauthorized = errors.New("unauthorized device")
+)
+
+// classes lists every class with the name used in its counter
+var classes = []struct {
+	err  error
+	name string
+}{
+	{ErrPayloadInvalid, "payload_invalid"},
+	{ErrStorageUnavailable, "storage_unavailable"},
+	{ErrBrokerDisconnected, "broker_disconnected"},
+	{ErrModelInvalid, "model_invalid"},
+	{ErrUnauthorized, "unauthorized"},
+}
+
+// Register the counters up front so a class that never fired reports 0 rather than being absent
+func init() {
+	for _, c := range classes {
+		metrics.Default.Counter(counterName(c.name))
+	}
+}
+
+// classified is an error tagged with its class. Its message is the original error's.
+type classified struct {
+	class error
+	err   error
+}
+
+func (e *classified) Error() string   { return e.err.Error() }
+func (e *classified) Unwrap() []error { return []error{e.class, e.err} }
+
+// Wrap tags err with class and counts it. An error that already has a class is
+// returned unchanged, so a failure is counted once however often it is wrapped.
+func Wrap(class, err error) error {
+	if err == nil {
+		return nil
+	}
+	if Class(err) != nil {
+		return err
+	}
+	metrics.Default.Counter(counterName(Name(class))).Inc()
+	return &classified{class: class, err: err}
+}
+
+// Class returns the class an error was tagged with, or nil
+func Class(err error) error {
+	for _, c := range classes {
+		if errors.Is(err, c.err) {
+			return c.err
+		}
+	}
+	return nil
+}
+
+// Name returns the short name of an error's class (e.g., "payload_invalid"), or "unclassified"
+func Name(err error) string {
+	for _, c := range classes {
+		if errors.Is(err, c.err) {
+			return c.name
+		}
+	}
+	return "unclassified"
+}
+
+// counterName returns the metric counting a class
+func counterName(name string) string {
+	return "errors_" + name
+}
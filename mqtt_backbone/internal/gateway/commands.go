@@ -0,0 +1,35 @@
+package gateway
+
+import "iot-backend/internal/models"
+
+// CommandPublisher sends window commands to actuators (the MQTT publisher)
+type CommandPublisher interface {
+	PublishWindowCommand(cmd *models.WindowCommand) error
+	PublishShadowCommand(cmd *models.WindowCommand) error
+}
+
+// CommandRouter sends window commands for gateway-attached devices down their
+// gateway stream and everything else to the fallback publisher
+type CommandRouter struct {
+	gateway  *Server
+	fallback CommandPublisher
+}
+
+// NewCommandRouter creates a router in front of fallback
+func NewCommandRouter(gateway *Server, fallback CommandPublisher) *CommandRouter {
+	return &CommandRouter{gateway: gateway, fallback: fallback}
+}
+
+// PublishWindowCommand sends a command through the device's gateway, or the fallback
+// if the device isn't attached to an open stream
+func (r *CommandRouter) PublishWindowCommand(cmd *models.WindowCommand) error {
+	if sent, err := r.gateway.PublishWindowCommand(cmd); sent {
+		return err
+	}
+	return r.fallback.PublishWindowCommand(cmd)
+}
+
+// PublishShadowCommand always uses the fallback: shadow commands are observed, not actuated
+func (r *CommandRouter) PublishShadowCommand(cmd *models.WindowCommand) error {
+	return r.fallback.PublishShadowCommand(cmd)
+}
@@ -0,0 +1,625 @@
+// Package gateway implements streaming ingestion for gateways that aggregate
+// many ESP32s. A gateway opens one bidirectional gRPC stream (see gateway.proto),
+// pushes reading batches, and receives window commands for the devices behind it,
+// bypassing MQTT entirely.
+//
+// The gRPC wire protocol (HTTP/2, length-prefixed protobuf messages, status in
+// trailers) is implemented directly for the single Stream method, so the backend
+// doesn't need the gRPC runtime. Compressed messages are not supported; clients
+// must use the identity encoding.
+package gateway
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"iot-backend/internal/errs"
+	"iot-backend/internal/ingest"
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+)
+
+// StreamMethod is the gRPC method path of the gateway stream
+const StreamMethod = "/iot.gateway.v1.Gateway/Stream"
+
+// gRPC status codes used by the server
+const (
+	codeOK               = 0
+	codeInvalidArgument  = 3
+	codePermissionDenied = 7
+	codeResourceExhaust  = 8
+	codeUnimplemented    = 12
+	codeInternal         = 13
+	codeUnavailable      = 14
+	codeUnauthenticated  = 16
+	grpcFrameHeaderBytes = 5
+)
+
+// Server accepts gateway streams and hands their readings to the same ingestion
+// pipeline the MQTT subscriber feeds
+type Server struct {
+	config ServerConfig
+
+	// Destination of readings, given by Start
+	pipeline ingest.Pipeline
+
+	mu       sync.Mutex
+	sessions map[*session]bool
+	routes   map[string]*session // device_id -> stream the device is served by
+	owners   map[string]string   // device_id -> gateway the device is bound to
+	gateways map[string]*gatewayState
+}
+
+// gatewayState tracks the open streams of one gateway ID
+type gatewayState struct {
+	streams  int
+	closedAt time.Time // When the last stream closed
+}
+
+// ServerConfig holds configuration for the gateway server
+type ServerConfig struct {
+	Addr            string            // Listen address, e.g. ":9090"
+	Tokens          map[string]string // Bearer token of each gateway, keyed by gateway ID (see ParseTokens)
+	MaxMessageBytes int               // Largest accepted batch
+	CommandQueue    int               // Commands buffered per stream before sends fail
+
+	// TLS; without a certificate streams are served as HTTP/2 without TLS (h2c)
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string // Require gateways to present a certificate signed by this CA, naming their gateway ID
+
+	// Insecure allows serving without a token or client certificates, so any
+	// client reaching Addr can submit readings. Safety events are refused then.
+	Insecure bool
+
+	// A device stays bound to the first gateway that sent its readings until that
+	// gateway has had no open stream for this long; other gateways are refused meanwhile
+	ReleaseAfter time.Duration
+}
+
+// DefaultServerConfig returns default configuration
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Addr:            ":9090",
+		MaxMessageBytes: 4 << 20,
+		CommandQueue:    64,
+		ReleaseAfter:    10 * time.Minute,
+	}
+}
+
+// NewServer creates a gateway server
+func NewServer(config ServerConfig) *Server {
+	return &Server{
+		config:   config,
+		sessions: make(map[*session]bool),
+		routes:   make(map[string]*session),
+		owners:   make(map[string]string),
+		gateways: make(map[string]*gatewayState),
+	}
+}
+
+// ParseTokens parses per-gateway bearer tokens such as "gw-north=s3cret;gw-south=0th3r".
+// Every gateway needs a token of its own, so one gateway's token can't be used to
+// speak for another.
+func ParseTokens(spec string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	owners := make(map[string]string)
+	for i, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		gatewayID, token, ok := strings.Cut(entry, "=")
+		gatewayID, token = strings.TrimSpace(gatewayID), strings.TrimSpace(token)
+		if !ok || gatewayID == "" || token == "" {
+			// The entry itself isn't quoted, as it may hold a token
+			return nil, fmt.Errorf("invalid gateway token entry %d, expected gateway_id=token", i+1)
+		}
+		if _, ok := tokens[gatewayID]; ok {
+			return nil, fmt.Errorf("gateway %s has more than one token", gatewayID)
+		}
+		if other, ok := owners[token]; ok {
+			return nil, fmt.Errorf("gateways %s and %s share a token", other, gatewayID)
+		}
+		tokens[gatewayID] = token
+		owners[token] = gatewayID
+	}
+	return tokens, nil
+}
+
+// authenticated reports whether gateways must prove who they are, with a token or a client certificate
+func (s *Server) authenticated() bool {
+	return len(s.config.Tokens) > 0 || s.config.TLSClientCAFile != ""
+}
+
+// tlsConfig loads the server certificate and, for mutual TLS, the client CA
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(s.config.TLSCertFile, s.config.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load gateway TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{http2.NextProtoTLS},
+	}
+	if s.config.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(s.config.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gateway client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("failed to parse gateway client CA %s: no certificates found", s.config.TLSClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// Name identifies the server as an ingestor
+func (s *Server) Name() string {
+	return "grpc"
+}
+
+// Start serves gateway streams (HTTP/2, over TLS when a certificate is configured)
+// until context is cancelled, handing their readings to pipeline. It returns once
+// listening. Unless Insecure is set, gateways must authenticate with their token or
+// a client certificate.
+func (s *Server) Start(ctx context.Context, pipeline ingest.Pipeline) error {
+	if !s.authenticated() && !s.config.Insecure {
+		return fmt.Errorf("gateway streams need a token or TLS client certificates; set Insecure to accept any client")
+	}
+	if s.config.TLSClientCAFile != "" && s.config.TLSCertFile == "" {
+		return fmt.Errorf("gateway client certificates need a server TLS certificate")
+	}
+
+	s.pipeline = pipeline
+	listener, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.Addr, err)
+	}
+
+	srv := &http.Server{
+		Handler:           h2c.NewHandler(http.HandlerFunc(s.serveHTTP), &http2.Server{}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if s.config.TLSCertFile != "" {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		srv.Handler = http.HandlerFunc(s.serveHTTP)
+		srv.TLSConfig = tlsConfig
+		if err := http2.ConfigureServer(srv, &http2.Server{}); err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to configure HTTP/2: %w", err)
+		}
+		listener = tls.NewListener(listener, srv.TLSConfig)
+	}
+
+	go func() {
+		<-ctx.Done()
+		// Open streams never finish on their own, so close them rather than waiting
+		s.closeSessions()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Gateway: Error during shutdown: %v", err)
+		}
+	}()
+
+	if !s.authenticated() {
+		log.Printf("Gateway: Warning: streams on %s are unauthenticated; safety events are refused", s.config.Addr)
+	}
+	log.Printf("Gateway: Listening for gRPC streams on %s (tls=%t)", s.config.Addr, s.config.TLSCertFile != "")
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Gateway: Server error: %v", err)
+		}
+		log.Println("Gateway: Shutdown complete")
+	}()
+	return nil
+}
+
+// serveHTTP handles one gRPC call
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC over HTTP/2 required", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Accept-Encoding", "identity")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+	if r.Method != http.MethodPost || r.URL.Path != StreamMethod {
+		finish(w, codeUnimplemented, "unknown method "+r.URL.Path)
+		return
+	}
+	creds, ok := s.authenticate(r)
+	switch {
+	case !ok:
+		metrics.Default.Counter("gateway_unauthenticated").Inc()
+		finish(w, codeUnauthenticated, "missing or invalid bearer token")
+		return
+	case r.Header.Get("Grpc-Encoding") != "" && r.Header.Get("Grpc-Encoding") != "identity":
+		finish(w, codeUnimplemented, "compression is not supported")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	sess := s.open(r.Context(), creds)
+	defer s.close(sess)
+
+	// Acknowledgements and commands are written by one goroutine so frames never interleave
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sess.writeLoop(w, flusher)
+	}()
+
+	code, message := s.readLoop(sess, r.Body)
+	sess.cancel()
+	<-done
+	finish(w, code, message)
+}
+
+// authenticate identifies the gateway behind a call: by its bearer token, if tokens
+// are configured, and by the names in its client certificate, which the TLS
+// handshake has verified. It returns ok=false for a missing or unknown token.
+func (s *Server) authenticate(r *http.Request) (creds credentials, ok bool) {
+	if len(s.config.Tokens) > 0 {
+		got := []byte(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		// Compare against every token so the time taken doesn't reveal which gateway matched
+		for gatewayID, token := range s.config.Tokens {
+			if subtle.ConstantTimeCompare(got, []byte(token)) == 1 {
+				creds.tokenGateway = gatewayID
+			}
+		}
+		if creds.tokenGateway == "" {
+			return creds, false
+		}
+	}
+	if s.config.TLSClientCAFile != "" && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		cert := r.TLS.PeerCertificates[0]
+		creds.certNames = append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+		creds.certified = true
+	}
+	return creds, true
+}
+
+// credentials is what a stream's caller proved about itself
+type credentials struct {
+	tokenGateway string   // Gateway the bearer token belongs to ("" without tokens)
+	certified    bool     // A client certificate was verified
+	certNames    []string // Common name and DNS names of the client certificate
+}
+
+// allows reports whether the credentials may speak for a gateway ID. The token, if
+// any, must be the gateway's own, and the client certificate, if any, must name it.
+func (c credentials) allows(gatewayID string) bool {
+	if c.tokenGateway != "" && c.tokenGateway != gatewayID {
+		return false
+	}
+	if !c.certified {
+		return true
+	}
+	for _, name := range c.certNames {
+		if name == gatewayID {
+			return true
+		}
+	}
+	return false
+}
+
+// finish sets the gRPC status trailers
+func finish(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Grpc-Status", fmt.Sprint(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", url.PathEscape(message))
+	}
+}
+
+// readLoop handles batches until the gateway closes its side of the stream,
+// returning the gRPC status the stream ends with
+func (s *Server) readLoop(sess *session, body io.Reader) (int, string) {
+	header := make([]byte, grpcFrameHeaderBytes)
+	for {
+		if _, err := io.ReadFull(body, header); err != nil {
+			if errors.Is(err, io.EOF) || sess.ctx.Err() != nil {
+				return codeOK, ""
+			}
+			return codeUnavailable, fmt.Sprintf("failed to read message: %v", err)
+		}
+		if header[0] != 0 {
+			return codeUnimplemented, "compressed messages are not supported"
+		}
+		size := binary.BigEndian.Uint32(header[1:])
+		if int64(size) > int64(s.config.MaxMessageBytes) {
+			return codeResourceExhaust, fmt.Sprintf("message of %d bytes exceeds the %d byte limit", size, s.config.MaxMessageBytes)
+		}
+
+		data := make([]byte, size)
+		if _, err := io.ReadFull(body, data); err != nil {
+			return codeUnavailable, fmt.Sprintf("failed to read message: %v", err)
+		}
+
+		msg, err := UnmarshalGatewayMessage(data)
+		if err != nil {
+			err = errs.Wrap(errs.ErrPayloadInvalid, err)
+			log.Printf("Gateway: Invalid batch from %s: %v", sess.gatewayID, err)
+			return codeInvalidArgument, fmt.Sprintf("invalid batch: %v", err)
+		}
+		if err := s.identify(sess, msg.GatewayID); err != nil {
+			if errors.Is(err, errs.ErrUnauthorized) {
+				metrics.Default.Counter("gateway_identity_mismatch").Inc()
+				log.Printf("Gateway: Refused stream: %v", err)
+				return codePermissionDenied, err.Error()
+			}
+			return codeInvalidArgument, err.Error()
+		}
+
+		ack := s.ingestBatch(sess, msg)
+		if !sess.send(marshalAck(ack)) {
+			return codeInternal, "stream closed"
+		}
+	}
+}
+
+// identify records the gateway ID a stream's first batch names; later batches
+// must name the same one. The stream's credentials must belong to that gateway.
+func (s *Server) identify(sess *session, gatewayID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case gatewayID == "":
+		return fmt.Errorf("batch without a gateway_id")
+	case !sess.creds.allows(gatewayID):
+		return errs.Wrap(errs.ErrUnauthorized, fmt.Errorf("stream credentials are not those of gateway %s", gatewayID))
+	case sess.gatewayID == "":
+		sess.gatewayID = gatewayID
+		state := s.gateways[gatewayID]
+		if state == nil {
+			state = &gatewayState{}
+			s.gateways[gatewayID] = state
+		}
+		state.streams++
+		log.Printf("Gateway: Stream opened by gateway %s", gatewayID)
+	case sess.gatewayID != gatewayID:
+		return fmt.Errorf("batch from gateway %s on the stream of gateway %s", gatewayID, sess.gatewayID)
+	}
+	return nil
+}
+
+// ingestBatch hands every reading of a batch to the pipeline
+func (s *Server) ingestBatch(sess *session, msg *GatewayMessage) *BatchAck {
+	ack := &BatchAck{BatchID: msg.BatchID}
+	now := time.Now()
+	for i, reading := range msg.Readings {
+		if err := s.attach(reading.DeviceID, sess, now); err != nil {
+			metrics.Default.Counter("gateway_readings_unbound").Inc()
+			ack.Rejected = append(ack.Rejected, Rejection{Index: uint32(i), Reason: err.Error()})
+			continue
+		}
+		if err := s.forward(reading, now); err != nil {
+			ack.Rejected = append(ack.Rejected, Rejection{Index: uint32(i), Reason: err.Error()})
+			continue
+		}
+		ack.Accepted++
+	}
+
+	metrics.Default.Counter("gateway_readings_accepted").Add(int64(ack.Accepted))
+	metrics.Default.Counter("gateway_readings_rejected").Add(int64(len(ack.Rejected)))
+	if len(ack.Rejected) > 0 {
+		log.Printf("Gateway: Batch %d from %s: %d accepted, %d rejected (first: %s)",
+			msg.BatchID, sess.gatewayID, ack.Accepted, len(ack.Rejected), ack.Rejected[0].Reason)
+	}
+	return ack
+}
+
+// forward hands one reading to the pipeline. Safety events close windows, so
+// unauthenticated streams can't send them.
+func (s *Server) forward(r Reading, now time.Time) error {
+	if ingest.IsSafetyType(r.Type) && !s.authenticated() {
+		return errs.Wrap(errs.ErrUnauthorized, fmt.Errorf("safety event %q needs an authenticated stream", r.Type))
+	}
+	reading := ingest.Reading{DeviceID: r.DeviceID, Type: r.Type, Value: r.Value}
+	if r.TimestampMs != 0 {
+		reading.Timestamp = time.UnixMilli(r.TimestampMs)
+	}
+	return ingest.Submit(s.pipeline, reading, now)
+}
+
+// PublishWindowCommand sends a command down the stream of the gateway the device
+// was last seen on. It returns false when no open stream serves the device.
+func (s *Server) PublishWindowCommand(cmd *models.WindowCommand) (bool, error) {
+	s.mu.Lock()
+	sess := s.routes[cmd.DeviceID]
+	var gatewayID string
+	if sess != nil {
+		gatewayID = sess.gatewayID
+	}
+	s.mu.Unlock()
+	if sess == nil {
+		return false, nil
+	}
+
+	wire := &WindowCommand{
+		DeviceID:    cmd.DeviceID,
+		TimestampMs: cmd.Timestamp.UnixMilli(),
+		Position:    cmd.Position,
+		Source:      cmd.Source,
+	}
+	if cmd.ExpiresAt != nil {
+		wire.ExpiresAtMs = cmd.ExpiresAt.UnixMilli()
+	}
+	frame := marshalCommand(wire)
+	if !sess.trySend(frame) {
+		return true, fmt.Errorf("failed to send window command for %s: gateway %s stream is congested or closed", cmd.DeviceID, gatewayID)
+	}
+
+	metrics.Default.Counter("gateway_commands_sent").Inc()
+	log.Printf("Sent window command for device %s via gateway %s (position=%.2f%%)", cmd.DeviceID, gatewayID, cmd.Position)
+	return true, nil
+}
+
+// open registers a new stream
+func (s *Server) open(parent context.Context, creds credentials) *session {
+	ctx, cancel := context.WithCancel(parent)
+	sess := &session{creds: creds, ctx: ctx, cancel: cancel, out: make(chan []byte, s.config.CommandQueue)}
+
+	s.mu.Lock()
+	s.sessions[sess] = true
+	metrics.Default.Gauge("gateway_streams").Set(int64(len(s.sessions)))
+	s.mu.Unlock()
+	return sess
+}
+
+// close unregisters a stream and detaches its devices. They stay bound to the
+// gateway, so only its next stream can pick them up within ReleaseAfter.
+func (s *Server) close(sess *session) {
+	sess.cancel()
+
+	s.mu.Lock()
+	delete(s.sessions, sess)
+	if state := s.gateways[sess.gatewayID]; state != nil {
+		state.streams--
+		state.closedAt = time.Now()
+	}
+	detached := 0
+	for deviceID, owner := range s.routes {
+		if owner == sess {
+			delete(s.routes, deviceID)
+			detached++
+		}
+	}
+	metrics.Default.Gauge("gateway_streams").Set(int64(len(s.sessions)))
+	s.mu.Unlock()
+
+	log.Printf("Gateway: Stream from %s closed (%d devices detached)", sess.gatewayID, detached)
+}
+
+// closeSessions cancels every open stream
+func (s *Server) closeSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sess := range s.sessions {
+		sess.cancel()
+	}
+}
+
+// attach binds a device to the stream's gateway and routes its commands to the
+// stream. A device bound to another gateway is refused until that gateway has had
+// no open stream for ReleaseAfter.
+func (s *Server) attach(deviceID string, sess *session, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if owner, ok := s.owners[deviceID]; ok && owner != sess.gatewayID {
+		state := s.gateways[owner]
+		if state != nil && (state.streams > 0 || now.Sub(state.closedAt) < s.config.ReleaseAfter) {
+			return errs.Wrap(errs.ErrUnauthorized, fmt.Errorf("device %s is bound to gateway %s", deviceID, owner))
+		}
+		log.Printf("Gateway: Device %s released by gateway %s", deviceID, owner)
+	}
+	s.owners[deviceID] = sess.gatewayID
+	if s.routes[deviceID] != sess {
+		s.routes[deviceID] = sess
+		log.Printf("Gateway: Device %s attached to gateway %s", deviceID, sess.gatewayID)
+	}
+	return nil
+}
+
+// session is one open gateway stream
+type session struct {
+	gatewayID string // From the first batch; written under Server.mu
+	creds     credentials
+	ctx       context.Context
+	cancel    context.CancelFunc
+	out       chan []byte // Encoded ServerMessages
+}
+
+// send queues a message, waiting while the stream is open
+func (sess *session) send(msg []byte) bool {
+	select {
+	case sess.out <- msg:
+		return true
+	case <-sess.ctx.Done():
+		return false
+	}
+}
+
+// trySend queues a message without waiting
+func (sess *session) trySend(msg []byte) bool {
+	if sess.ctx.Err() != nil {
+		return false
+	}
+	select {
+	case sess.out <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeLoop frames and writes queued messages until the stream ends
+func (sess *session) writeLoop(w io.Writer, flusher http.Flusher) {
+	for {
+		select {
+		case <-sess.ctx.Done():
+			// Deliver what is already queued, e.g. the acknowledgement of the last batch
+			for {
+				select {
+				case msg := <-sess.out:
+					if !writeFrame(w, flusher, msg) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		case msg := <-sess.out:
+			if !writeFrame(w, flusher, msg) {
+				sess.cancel()
+				return
+			}
+		}
+	}
+}
+
+// writeFrame writes one length-prefixed message
+func writeFrame(w io.Writer, flusher http.Flusher, msg []byte) bool {
+	frame := make([]byte, grpcFrameHeaderBytes, grpcFrameHeaderBytes+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	if _, err := w.Write(append(frame, msg...)); err != nil {
+		return false
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	return true
+}
@@ -0,0 +1,308 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"iot-backend/internal/models"
+)
+
+// recordingPipeline keeps the readings handed to it
+type recordingPipeline struct {
+	mu           sync.Mutex
+	temperatures []*models.TemperatureReading
+}
+
+func (p *recordingPipeline) Temperature(r *models.TemperatureReading) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.temperatures = append(p.temperatures, r)
+	return nil
+}
+
+func (p *recordingPipeline) Humidity(*models.HumidityReading) error { return nil }
+func (p *recordingPipeline) Audio(*models.AudioRecording) error     { return nil }
+func (p *recordingPipeline) Safety(*models.SafetyEvent) error       { return nil }
+func (p *recordingPipeline) Presence(*models.PresenceReading) error { return nil }
+
+// streamResult is what a gateway saw of one stream
+type streamResult struct {
+	messages [][]byte // Encoded ServerMessages
+	status   string
+	message  string
+}
+
+// testServer serves gateway streams over HTTP/2 with TLS, as gateways connect in production
+func testServer(t *testing.T, config ServerConfig) (*Server, *httptest.Server) {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s := NewServer(config)
+	s.pipeline = &recordingPipeline{}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+// stream sends batches on one call and collects the responses and the status trailers
+func stream(t *testing.T, ts *httptest.Server, token string, batches ...*GatewayMessage) streamResult {
+	t.Helper()
+	body, w := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, ts.URL+StreamMethod, body)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	go func() {
+		for _, batch := range batches {
+			msg := MarshalGatewayMessage(batch)
+			frame := make([]byte, grpcFrameHeaderBytes, grpcFrameHeaderBytes+len(msg))
+			binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+			if _, err := w.Write(append(frame, msg...)); err != nil {
+				return
+			}
+		}
+		w.Close()
+	}()
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("stream failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("stream served over HTTP/%d.%d", resp.ProtoMajor, resp.ProtoMinor)
+	}
+
+	var result streamResult
+	header := make([]byte, grpcFrameHeaderBytes)
+	for {
+		if _, err := io.ReadFull(resp.Body, header); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("failed to read frame header: %v", err)
+		}
+		if header[0] != 0 {
+			t.Fatalf("compressed frame")
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(resp.Body, msg); err != nil {
+			t.Fatalf("failed to read frame: %v", err)
+		}
+		result.messages = append(result.messages, msg)
+	}
+
+	// Trailers are only complete once the body has been read to the end
+	result.status = resp.Trailer.Get("Grpc-Status")
+	if result.message, err = url.PathUnescape(resp.Trailer.Get("Grpc-Message")); err != nil {
+		t.Fatalf("invalid grpc-message %q: %v", resp.Trailer.Get("Grpc-Message"), err)
+	}
+	return result
+}
+
+// ackOf decodes a ServerMessage that must carry an acknowledgement
+func ackOf(t *testing.T, data []byte) (batchID, accepted uint64, rejected int) {
+	t.Helper()
+	msg := newMessage(t, gatewayProto(t), "ServerMessage")
+	if err := proto.Unmarshal(data, msg); err != nil {
+		t.Fatalf("failed to decode server message: %v", err)
+	}
+	payload := msg.WhichOneof(msg.Descriptor().Oneofs().ByName("payload"))
+	if payload == nil || payload.Name() != "ack" {
+		t.Fatalf("server message carries %v, want ack", payload)
+	}
+	ack := msg.Get(payload).Message()
+	fields := ack.Descriptor().Fields()
+	return ack.Get(fields.ByName("batch_id")).Uint(), ack.Get(fields.ByName("accepted")).Uint(), ack.Get(fields.ByName("rejected")).List().Len()
+}
+
+func batch(gatewayID string, batchID uint64, deviceIDs ...string) *GatewayMessage {
+	msg := &GatewayMessage{GatewayID: gatewayID, BatchID: batchID}
+	for _, deviceID := range deviceIDs {
+		msg.Readings = append(msg.Readings, Reading{DeviceID: deviceID, Type: "temperature", Value: 21})
+	}
+	return msg
+}
+
+// TestStreamStatus checks the acknowledgements and grpc-status trailers a gateway receives
+func TestStreamStatus(t *testing.T) {
+	config := DefaultServerConfig()
+	config.Tokens = map[string]string{"gw-north": "north-token", "gw-south": "south-token"}
+	_, ts := testServer(t, config)
+
+	t.Run("accepted", func(t *testing.T) {
+		result := stream(t, ts, "north-token", batch("gw-north", 1, "esp32-1", "esp32-2"), batch("gw-north", 2, "esp32-1"))
+		if result.status != "0" {
+			t.Fatalf("grpc-status %q (%s), want 0", result.status, result.message)
+		}
+		if len(result.messages) != 2 {
+			t.Fatalf("%d responses, want 2 acknowledgements", len(result.messages))
+		}
+		for i, want := range []uint64{2, 1} {
+			batchID, accepted, rejected := ackOf(t, result.messages[i])
+			if batchID != uint64(i+1) || accepted != want || rejected != 0 {
+				t.Errorf("ack %d is batch %d with %d accepted and %d rejected, want batch %d with %d accepted", i, batchID, accepted, rejected, i+1, want)
+			}
+		}
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		result := stream(t, ts, "guess", batch("gw-north", 1, "esp32-1"))
+		if result.status != "16" || len(result.messages) != 0 {
+			t.Errorf("grpc-status %q with %d responses, want 16 and none", result.status, len(result.messages))
+		}
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		if result := stream(t, ts, "", batch("gw-north", 1, "esp32-1")); result.status != "16" {
+			t.Errorf("grpc-status %q, want 16", result.status)
+		}
+	})
+
+	t.Run("other gateway's id", func(t *testing.T) {
+		result := stream(t, ts, "south-token", batch("gw-north", 1, "esp32-1"))
+		if result.status != "7" || len(result.messages) != 0 {
+			t.Errorf("grpc-status %q with %d responses, want 7 and none", result.status, len(result.messages))
+		}
+		if !strings.Contains(result.message, "gateway gw-north") {
+			t.Errorf("grpc-message %q doesn't name the gateway", result.message)
+		}
+	})
+
+	t.Run("gateway id changes mid-stream", func(t *testing.T) {
+		result := stream(t, ts, "north-token", batch("gw-north", 1, "esp32-1"), batch("gw-south", 2, "esp32-1"))
+		if result.status != "7" || len(result.messages) != 1 {
+			t.Errorf("grpc-status %q with %d responses, want 7 after one acknowledgement", result.status, len(result.messages))
+		}
+	})
+
+	t.Run("malformed batch", func(t *testing.T) {
+		body, w := io.Pipe()
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+StreamMethod, body)
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("Authorization", "Bearer north-token")
+		go func() {
+			w.Write([]byte{0, 0, 0, 0, 2, 0x0a, 0x05})
+			w.Close()
+		}()
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("stream failed: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if status := resp.Trailer.Get("Grpc-Status"); status != "3" {
+			t.Errorf("grpc-status %q, want 3", status)
+		}
+	})
+
+	t.Run("unknown method", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/iot.gateway.v1.Gateway/Other", strings.NewReader(""))
+		req.Header.Set("Content-Type", "application/grpc")
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("call failed: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if status := resp.Trailer.Get("Grpc-Status"); status != "12" {
+			t.Errorf("grpc-status %q, want 12", status)
+		}
+	})
+}
+
+// TestDeviceStaysWithItsGateway checks another gateway's readings for a bound device are rejected
+func TestDeviceStaysWithItsGateway(t *testing.T) {
+	config := DefaultServerConfig()
+	config.Tokens = map[string]string{"gw-north": "north-token", "gw-south": "south-token"}
+	s, ts := testServer(t, config)
+
+	if result := stream(t, ts, "north-token", batch("gw-north", 1, "esp32-1")); result.status != "0" {
+		t.Fatalf("grpc-status %q (%s), want 0", result.status, result.message)
+	}
+	result := stream(t, ts, "south-token", batch("gw-south", 1, "esp32-1", "esp32-9"))
+	if result.status != "0" || len(result.messages) != 1 {
+		t.Fatalf("grpc-status %q with %d responses, want 0 and one acknowledgement", result.status, len(result.messages))
+	}
+	if _, accepted, rejected := ackOf(t, result.messages[0]); accepted != 1 || rejected != 1 {
+		t.Errorf("%d accepted and %d rejected, want esp32-9 accepted and esp32-1 rejected", accepted, rejected)
+	}
+	pipeline := s.pipeline.(*recordingPipeline)
+	if len(pipeline.temperatures) != 2 || pipeline.temperatures[1].DeviceID != "esp32-9" {
+		t.Errorf("pipeline received %d readings, want esp32-1 from gw-north and esp32-9 from gw-south", len(pipeline.temperatures))
+	}
+}
+
+// TestCertificateNamesGateway checks streams authenticated by client certificate
+// may only use a gateway ID the certificate names
+func TestCertificateNamesGateway(t *testing.T) {
+	config := DefaultServerConfig()
+	config.TLSClientCAFile = "ca.pem" // Only its presence matters here; the handshake verifies certificates
+	s := NewServer(config)
+
+	r := httptest.NewRequest(http.MethodPost, StreamMethod, nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{
+		Subject:  pkix.Name{CommonName: "gw-north"},
+		DNSNames: []string{"gw-north-backup"},
+	}}}
+	creds, ok := s.authenticate(r)
+	if !ok {
+		t.Fatalf("certificate-only call not authenticated")
+	}
+	for gatewayID, want := range map[string]bool{"gw-north": true, "gw-north-backup": true, "gw-south": false, "": false} {
+		if got := creds.allows(gatewayID); got != want {
+			t.Errorf("allows(%q) = %t, want %t", gatewayID, got, want)
+		}
+	}
+
+	// With tokens configured as well, the token and the certificate must agree
+	config.Tokens = map[string]string{"gw-north": "north-token", "gw-south": "south-token"}
+	s = NewServer(config)
+	r.Header.Set("Authorization", "Bearer south-token")
+	if creds, ok = s.authenticate(r); !ok {
+		t.Fatalf("call with a valid token not authenticated")
+	}
+	if creds.allows("gw-north") || creds.allows("gw-south") {
+		t.Errorf("token of gw-south and certificate of gw-north allowed either gateway")
+	}
+}
+
+// TestParseTokens checks every gateway gets a token of its own
+func TestParseTokens(t *testing.T) {
+	tokens, err := ParseTokens(" gw-north = north-token ;gw-south=south-token;")
+	if err != nil {
+		t.Fatalf("failed to parse tokens: %v", err)
+	}
+	if len(tokens) != 2 || tokens["gw-north"] != "north-token" || tokens["gw-south"] != "south-token" {
+		t.Errorf("parsed %v", tokens)
+	}
+
+	for _, spec := range []string{"north-token", "gw-north=", "=north-token", "gw-north=a;gw-north=b", "gw-north=same;gw-south=same"} {
+		if _, err := ParseTokens(spec); err == nil {
+			t.Errorf("%q parsed without error", spec)
+		} else if strings.Contains(err.Error(), "north-token") || strings.Contains(err.Error(), "same") {
+			t.Errorf("error for %q reveals a token: %v", spec, err)
+		}
+	}
+}
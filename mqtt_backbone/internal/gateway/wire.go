@@ -0,0 +1,319 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Protocol buffer encoding of the messages in gateway.proto. Only the handful of
+// field types those messages use are supported; unknown fields are skipped so
+// gateways built against a newer schema keep working.
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated message")
+
+// GatewayMessage is a batch of readings from the devices behind a gateway
+type GatewayMessage struct {
+	GatewayID string
+	BatchID   uint64
+	Readings  []Reading
+}
+
+// Reading is one device reading within a batch
+type Reading struct {
+	DeviceID    string
+	TimestampMs int64 // Unix milliseconds; 0 = time of receipt
+	Type        string
+	Value       float64
+}
+
+// Rejection explains why a reading of a batch was not accepted
+type Rejection struct {
+	Index  uint32
+	Reason string
+}
+
+// BatchAck acknowledges a batch
+type BatchAck struct {
+	BatchID  uint64
+	Accepted uint32
+	Rejected []Rejection
+}
+
+// WindowCommand is a window command sent down a gateway stream
+type WindowCommand struct {
+	DeviceID    string
+	TimestampMs int64
+	Position    float64
+	Source      string
+	ExpiresAtMs int64 // 0 = never
+}
+
+// decoder reads fields from a protobuf message
+type decoder struct {
+	buf []byte
+}
+
+// next returns the next field number and wire type, or ok=false at the end
+func (d *decoder) next() (field int, wire int, ok bool, err error) {
+	if len(d.buf) == 0 {
+		return 0, 0, false, nil
+	}
+	key, err := d.varint()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if key>>3 == 0 || key>>3 > math.MaxInt32 {
+		return 0, 0, false, fmt.Errorf("invalid field number %d", key>>3)
+	}
+	return int(key >> 3), int(key & 7), true, nil
+}
+
+func (d *decoder) varint() (uint64, error) {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.buf = d.buf[n:]
+	return v, nil
+}
+
+func (d *decoder) fixed64() (uint64, error) {
+	if len(d.buf) < 8 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint64(d.buf)
+	d.buf = d.buf[8:]
+	return v, nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	n, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.buf)) {
+		return nil, errTruncated
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+// skip discards the value of an unknown field
+func (d *decoder) skip(wire int) error {
+	var err error
+	switch wire {
+	case wireVarint:
+		_, err = d.varint()
+	case wireFixed64:
+		_, err = d.fixed64()
+	case wireBytes:
+		_, err = d.bytes()
+	case wireFixed32:
+		if len(d.buf) < 4 {
+			return errTruncated
+		}
+		d.buf = d.buf[4:]
+	default:
+		return fmt.Errorf("unsupported wire type %d", wire)
+	}
+	return err
+}
+
+// expect checks that a known field arrived with its declared wire type
+func expect(field, wire, want int) error {
+	if wire != want {
+		return fmt.Errorf("field %d has wire type %d, want %d", field, wire, want)
+	}
+	return nil
+}
+
+// UnmarshalGatewayMessage decodes a GatewayMessage
+func UnmarshalGatewayMessage(data []byte) (*GatewayMessage, error) {
+	msg := &GatewayMessage{}
+	d := &decoder{buf: data}
+	for {
+		field, wire, ok, err := d.next()
+		if err != nil || !ok {
+			return msg, err
+		}
+		switch field {
+		case 1:
+			if err := expect(field, wire, wireBytes); err != nil {
+				return nil, err
+			}
+			b, err := d.bytes()
+			if err != nil {
+				return nil, err
+			}
+			msg.GatewayID = string(b)
+		case 2:
+			if err := expect(field, wire, wireVarint); err != nil {
+				return nil, err
+			}
+			if msg.BatchID, err = d.varint(); err != nil {
+				return nil, err
+			}
+		case 3:
+			if err := expect(field, wire, wireBytes); err != nil {
+				return nil, err
+			}
+			b, err := d.bytes()
+			if err != nil {
+				return nil, err
+			}
+			reading, err := unmarshalReading(b)
+			if err != nil {
+				return nil, fmt.Errorf("reading %d: %w", len(msg.Readings), err)
+			}
+			msg.Readings = append(msg.Readings, reading)
+		default:
+			if err := d.skip(wire); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func unmarshalReading(data []byte) (Reading, error) {
+	var r Reading
+	d := &decoder{buf: data}
+	for {
+		field, wire, ok, err := d.next()
+		if err != nil || !ok {
+			return r, err
+		}
+		switch field {
+		case 1, 3:
+			if err := expect(field, wire, wireBytes); err != nil {
+				return r, err
+			}
+			b, err := d.bytes()
+			if err != nil {
+				return r, err
+			}
+			if field == 1 {
+				r.DeviceID = string(b)
+			} else {
+				r.Type = string(b)
+			}
+		case 2:
+			if err := expect(field, wire, wireVarint); err != nil {
+				return r, err
+			}
+			v, err := d.varint()
+			if err != nil {
+				return r, err
+			}
+			r.TimestampMs = int64(v)
+		case 4:
+			if err := expect(field, wire, wireFixed64); err != nil {
+				return r, err
+			}
+			v, err := d.fixed64()
+			if err != nil {
+				return r, err
+			}
+			r.Value = math.Float64frombits(v)
+		default:
+			if err := d.skip(wire); err != nil {
+				return r, err
+			}
+		}
+	}
+}
+
+// encoder appends protobuf fields; zero values are omitted as in proto3
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) key(field, wire int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field)<<3|uint64(wire))
+}
+
+func (e *encoder) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.key(field, wireVarint)
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) double(field int, v float64) {
+	if v == 0 && !math.Signbit(v) {
+		return
+	}
+	e.key(field, wireFixed64)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v))
+}
+
+func (e *encoder) bytes(field int, b []byte) {
+	e.key(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) string(field int, s string) {
+	if s == "" {
+		return
+	}
+	e.bytes(field, []byte(s))
+}
+
+// MarshalGatewayMessage encodes a GatewayMessage (used by gateway clients and tools)
+func MarshalGatewayMessage(msg *GatewayMessage) []byte {
+	e := &encoder{}
+	e.string(1, msg.GatewayID)
+	e.uint(2, msg.BatchID)
+	for _, r := range msg.Readings {
+		var re encoder
+		re.string(1, r.DeviceID)
+		re.uint(2, uint64(r.TimestampMs))
+		re.string(3, r.Type)
+		re.double(4, r.Value)
+		e.bytes(3, re.buf)
+	}
+	return e.buf
+}
+
+// marshalAck encodes a ServerMessage carrying an acknowledgement
+func marshalAck(ack *BatchAck) []byte {
+	var inner encoder
+	inner.uint(1, ack.BatchID)
+	inner.uint(2, uint64(ack.Accepted))
+	for _, r := range ack.Rejected {
+		var re encoder
+		re.uint(1, uint64(r.Index))
+		re.string(2, r.Reason)
+		inner.bytes(3, re.buf)
+	}
+
+	var e encoder
+	e.bytes(1, inner.buf)
+	return e.buf
+}
+
+// marshalCommand encodes a ServerMessage carrying a window command
+func marshalCommand(cmd *WindowCommand) []byte {
+	var inner encoder
+	inner.string(1, cmd.DeviceID)
+	inner.uint(2, uint64(cmd.TimestampMs))
+	inner.double(3, cmd.Position)
+	inner.string(4, cmd.Source)
+	inner.uint(5, uint64(cmd.ExpiresAtMs))
+
+	var e encoder
+	e.bytes(2, inner.buf)
+	return e.buf
+}
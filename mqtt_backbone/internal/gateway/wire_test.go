@@ -0,0 +1,377 @@
+package gateway
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// gatewayProtoDescriptor is gateway.proto as a descriptor, so messages can be
+// encoded and decoded by the protobuf runtime gateway clients are generated for.
+// TestDescriptorMatchesProto keeps it in step with the file.
+const gatewayProtoDescriptor = `
+name: "gateway.proto"
+package: "iot.gateway.v1"
+syntax: "proto3"
+message_type {
+  name: "GatewayMessage"
+  field { name: "gateway_id" number: 1 type: TYPE_STRING label: LABEL_OPTIONAL }
+  field { name: "batch_id" number: 2 type: TYPE_UINT64 label: LABEL_OPTIONAL }
+  field { name: "readings" number: 3 type: TYPE_MESSAGE label: LABEL_REPEATED type_name: ".iot.gateway.v1.Reading" }
+}
+message_type {
+  name: "Reading"
+  field { name: "device_id" number: 1 type: TYPE_STRING label: LABEL_OPTIONAL }
+  field { name: "timestamp_ms" number: 2 type: TYPE_INT64 label: LABEL_OPTIONAL }
+  field { name: "type" number: 3 type: TYPE_STRING label: LABEL_OPTIONAL }
+  field { name: "value" number: 4 type: TYPE_DOUBLE label: LABEL_OPTIONAL }
+}
+message_type {
+  name: "ServerMessage"
+  field { name: "ack" number: 1 type: TYPE_MESSAGE label: LABEL_OPTIONAL type_name: ".iot.gateway.v1.BatchAck" oneof_index: 0 }
+  field { name: "command" number: 2 type: TYPE_MESSAGE label: LABEL_OPTIONAL type_name: ".iot.gateway.v1.WindowCommand" oneof_index: 0 }
+  oneof_decl { name: "payload" }
+}
+message_type {
+  name: "BatchAck"
+  field { name: "batch_id" number: 1 type: TYPE_UINT64 label: LABEL_OPTIONAL }
+  field { name: "accepted" number: 2 type: TYPE_UINT32 label: LABEL_OPTIONAL }
+  field { name: "rejected" number: 3 type: TYPE_MESSAGE label: LABEL_REPEATED type_name: ".iot.gateway.v1.Rejection" }
+}
+message_type {
+  name: "Rejection"
+  field { name: "index" number: 1 type: TYPE_UINT32 label: LABEL_OPTIONAL }
+  field { name: "reason" number: 2 type: TYPE_STRING label: LABEL_OPTIONAL }
+}
+message_type {
+  name: "WindowCommand"
+  field { name: "device_id" number: 1 type: TYPE_STRING label: LABEL_OPTIONAL }
+  field { name: "timestamp_ms" number: 2 type: TYPE_INT64 label: LABEL_OPTIONAL }
+  field { name: "position" number: 3 type: TYPE_DOUBLE label: LABEL_OPTIONAL }
+  field { name: "source" number: 4 type: TYPE_STRING label: LABEL_OPTIONAL }
+  field { name: "expires_at_ms" number: 5 type: TYPE_INT64 label: LABEL_OPTIONAL }
+}
+`
+
+// gatewayProto builds the descriptor of gateway.proto
+func gatewayProto(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+	var fdp descriptorpb.FileDescriptorProto
+	if err := prototext.Unmarshal([]byte(gatewayProtoDescriptor), &fdp); err != nil {
+		t.Fatalf("failed to parse descriptor: %v", err)
+	}
+	fd, err := protodesc.NewFile(&fdp, nil)
+	if err != nil {
+		t.Fatalf("failed to build descriptor: %v", err)
+	}
+	return fd
+}
+
+// newMessage creates an empty dynamic message of a gateway.proto type
+func newMessage(t *testing.T, fd protoreflect.FileDescriptor, name string) *dynamicpb.Message {
+	t.Helper()
+	md := fd.Messages().ByName(protoreflect.Name(name))
+	if md == nil {
+		t.Fatalf("no message %s in gateway.proto", name)
+	}
+	return dynamicpb.NewMessage(md)
+}
+
+// protoField matches a field declaration such as "string gateway_id = 1;"
+var protoField = regexp.MustCompile(`^\s*(repeated\s+)?(\w+)\s+(\w+)\s*=\s*(\d+);`)
+
+// TestDescriptorMatchesProto checks the test descriptor declares the same fields as gateway.proto
+func TestDescriptorMatchesProto(t *testing.T) {
+	source, err := os.ReadFile("gateway.proto")
+	if err != nil {
+		t.Fatalf("failed to read gateway.proto: %v", err)
+	}
+
+	var fromFile []string
+	message := ""
+	for _, line := range strings.Split(string(source), "\n") {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(line), "message "); ok {
+			message = strings.TrimSuffix(strings.TrimSpace(name), "{")
+			message = strings.TrimSpace(message)
+			continue
+		}
+		if m := protoField.FindStringSubmatch(line); m != nil && message != "" {
+			fromFile = append(fromFile, message+"."+m[3]+" "+strings.TrimSpace(m[1])+m[2]+" "+m[4])
+		}
+	}
+
+	var fromDescriptor []string
+	messages := gatewayProto(t).Messages()
+	for i := 0; i < messages.Len(); i++ {
+		md := messages.Get(i)
+		for j := 0; j < md.Fields().Len(); j++ {
+			f := md.Fields().Get(j)
+			kind := f.Kind().String()
+			if f.Kind() == protoreflect.MessageKind {
+				kind = string(f.Message().Name())
+			}
+			repeated := ""
+			if f.Cardinality() == protoreflect.Repeated {
+				repeated = "repeated"
+			}
+			fromDescriptor = append(fromDescriptor, string(md.Name())+"."+string(f.Name())+" "+repeated+kind+" "+fmt.Sprint(f.Number()))
+		}
+	}
+
+	sort.Strings(fromFile)
+	sort.Strings(fromDescriptor)
+	if strings.Join(fromFile, "\n") != strings.Join(fromDescriptor, "\n") {
+		t.Errorf("descriptor is out of date with gateway.proto\nproto:\n%s\ndescriptor:\n%s",
+			strings.Join(fromFile, "\n"), strings.Join(fromDescriptor, "\n"))
+	}
+}
+
+// sampleBatch exercises every field, including values with awkward encodings
+func sampleBatch() *GatewayMessage {
+	return &GatewayMessage{
+		GatewayID: "gw-north",
+		BatchID:   math.MaxUint64,
+		Readings: []Reading{
+			{DeviceID: "esp32-1", TimestampMs: 1_700_000_000_123, Type: "temperature", Value: 21.5},
+			{DeviceID: "esp32-2", TimestampMs: -1, Type: "outdoor_temperature", Value: -12.25},
+			{DeviceID: "esp32-3", Type: "motion", Value: math.Copysign(0, -1)},
+			{DeviceID: "ésp32-ü", TimestampMs: math.MinInt64, Type: "rain", Value: math.MaxFloat64},
+			{},
+		},
+	}
+}
+
+// TestGatewayMessageRoundTrip decodes what MarshalGatewayMessage encodes
+func TestGatewayMessageRoundTrip(t *testing.T) {
+	want := sampleBatch()
+	got, err := UnmarshalGatewayMessage(MarshalGatewayMessage(want))
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	compareBatches(t, got, want)
+}
+
+// TestGatewayMessageFromProtobuf decodes batches encoded by the protobuf runtime
+func TestGatewayMessageFromProtobuf(t *testing.T) {
+	fd := gatewayProto(t)
+	want := sampleBatch()
+
+	msg := newMessage(t, fd, "GatewayMessage")
+	fields := msg.Descriptor().Fields()
+	msg.Set(fields.ByName("gateway_id"), protoreflect.ValueOfString(want.GatewayID))
+	msg.Set(fields.ByName("batch_id"), protoreflect.ValueOfUint64(want.BatchID))
+	readings := msg.Mutable(fields.ByName("readings")).List()
+	for _, r := range want.Readings {
+		reading := newMessage(t, fd, "Reading")
+		rf := reading.Descriptor().Fields()
+		reading.Set(rf.ByName("device_id"), protoreflect.ValueOfString(r.DeviceID))
+		reading.Set(rf.ByName("timestamp_ms"), protoreflect.ValueOfInt64(r.TimestampMs))
+		reading.Set(rf.ByName("type"), protoreflect.ValueOfString(r.Type))
+		reading.Set(rf.ByName("value"), protoreflect.ValueOfFloat64(r.Value))
+		readings.Append(protoreflect.ValueOfMessage(reading))
+	}
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	got, err := UnmarshalGatewayMessage(data)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	compareBatches(t, got, want)
+}
+
+// TestGatewayMessageToProtobuf checks the protobuf runtime reads what MarshalGatewayMessage writes
+func TestGatewayMessageToProtobuf(t *testing.T) {
+	fd := gatewayProto(t)
+	want := sampleBatch()
+
+	msg := newMessage(t, fd, "GatewayMessage")
+	if err := (proto.UnmarshalOptions{DiscardUnknown: false}).Unmarshal(MarshalGatewayMessage(want), msg); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if len(msg.GetUnknown()) != 0 {
+		t.Errorf("%d bytes of unknown fields", len(msg.GetUnknown()))
+	}
+
+	fields := msg.Descriptor().Fields()
+	got := &GatewayMessage{
+		GatewayID: msg.Get(fields.ByName("gateway_id")).String(),
+		BatchID:   msg.Get(fields.ByName("batch_id")).Uint(),
+	}
+	readings := msg.Get(fields.ByName("readings")).List()
+	for i := 0; i < readings.Len(); i++ {
+		reading := readings.Get(i).Message()
+		rf := reading.Descriptor().Fields()
+		got.Readings = append(got.Readings, Reading{
+			DeviceID:    reading.Get(rf.ByName("device_id")).String(),
+			TimestampMs: reading.Get(rf.ByName("timestamp_ms")).Int(),
+			Type:        reading.Get(rf.ByName("type")).String(),
+			Value:       reading.Get(rf.ByName("value")).Float(),
+		})
+	}
+	compareBatches(t, got, want)
+}
+
+// TestGatewayMessageSkipsUnknownFields accepts batches from gateways built against a newer schema
+func TestGatewayMessageSkipsUnknownFields(t *testing.T) {
+	reading := MarshalGatewayMessage(&GatewayMessage{Readings: []Reading{{DeviceID: "esp32-1", Type: "humidity", Value: 40}}})
+	// Take the encoded reading back out of its batch to append a field to it
+	_, _, n := protowire.ConsumeTag(reading)
+	inner, _ := protowire.ConsumeBytes(reading[n:])
+	inner = protowire.AppendTag(append([]byte{}, inner...), 9, protowire.Fixed32Type)
+	inner = protowire.AppendFixed32(inner, 7)
+
+	var data []byte
+	data = protowire.AppendTag(data, 1, protowire.BytesType)
+	data = protowire.AppendString(data, "gw-north")
+	data = protowire.AppendTag(data, 15, protowire.VarintType)
+	data = protowire.AppendVarint(data, 300)
+	data = protowire.AppendTag(data, 16, protowire.BytesType)
+	data = protowire.AppendString(data, "future")
+	data = protowire.AppendTag(data, 17, protowire.Fixed64Type)
+	data = protowire.AppendFixed64(data, 1)
+	data = protowire.AppendTag(data, 3, protowire.BytesType)
+	data = protowire.AppendBytes(data, inner)
+
+	got, err := UnmarshalGatewayMessage(data)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	compareBatches(t, got, &GatewayMessage{GatewayID: "gw-north", Readings: []Reading{{DeviceID: "esp32-1", Type: "humidity", Value: 40}}})
+}
+
+// TestGatewayMessageRejectsMalformed refuses truncated input and fields of the wrong wire type
+func TestGatewayMessageRejectsMalformed(t *testing.T) {
+	valid := MarshalGatewayMessage(sampleBatch())
+	// A cut between top-level fields leaves a valid, shorter batch
+	boundaries := make(map[int]bool)
+	for offset := 0; offset < len(valid); {
+		_, _, n := protowire.ConsumeField(valid[offset:])
+		if n < 0 {
+			t.Fatalf("encoded batch is malformed at byte %d", offset)
+		}
+		offset += n
+		boundaries[offset] = true
+	}
+	for n := 1; n < len(valid); n++ {
+		if _, err := UnmarshalGatewayMessage(valid[:n]); err == nil && !boundaries[n] {
+			t.Errorf("batch truncated to %d of %d bytes decoded", n, len(valid))
+		}
+	}
+
+	cases := map[string][]byte{
+		"gateway_id as varint": protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), 1),
+		"batch_id as bytes":    protowire.AppendString(protowire.AppendTag(nil, 2, protowire.BytesType), "1"),
+		"field number 0":       {0x02, 0x00},
+		"group wire type":      protowire.AppendTag(nil, 5, protowire.StartGroupType),
+		"oversized length":     {0x0a, 0xff, 0x01, 'g'},
+	}
+	for name, data := range cases {
+		if _, err := UnmarshalGatewayMessage(data); err == nil {
+			t.Errorf("%s: decoded without error", name)
+		}
+	}
+}
+
+// TestAckToProtobuf checks the protobuf runtime reads acknowledgements as ServerMessage.ack
+func TestAckToProtobuf(t *testing.T) {
+	fd := gatewayProto(t)
+	ack := &BatchAck{
+		BatchID:  42,
+		Accepted: 3,
+		Rejected: []Rejection{{Index: 0, Reason: "device esp32-9 is bound to gateway gw-south"}, {Index: 4, Reason: "unknown reading type \"x\""}},
+	}
+
+	msg := newMessage(t, fd, "ServerMessage")
+	if err := proto.Unmarshal(marshalAck(ack), msg); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	payload := msg.WhichOneof(msg.Descriptor().Oneofs().ByName("payload"))
+	if payload == nil || payload.Name() != "ack" {
+		t.Fatalf("payload is %v, want ack", payload)
+	}
+
+	got := msg.Get(payload).Message()
+	fields := got.Descriptor().Fields()
+	if v := got.Get(fields.ByName("batch_id")).Uint(); v != ack.BatchID {
+		t.Errorf("batch_id %d, want %d", v, ack.BatchID)
+	}
+	if v := got.Get(fields.ByName("accepted")).Uint(); v != uint64(ack.Accepted) {
+		t.Errorf("accepted %d, want %d", v, ack.Accepted)
+	}
+	rejected := got.Get(fields.ByName("rejected")).List()
+	if rejected.Len() != len(ack.Rejected) {
+		t.Fatalf("%d rejections, want %d", rejected.Len(), len(ack.Rejected))
+	}
+	for i, want := range ack.Rejected {
+		r := rejected.Get(i).Message()
+		rf := r.Descriptor().Fields()
+		if index, reason := r.Get(rf.ByName("index")).Uint(), r.Get(rf.ByName("reason")).String(); index != uint64(want.Index) || reason != want.Reason {
+			t.Errorf("rejection %d is (%d, %q), want (%d, %q)", i, index, reason, want.Index, want.Reason)
+		}
+	}
+}
+
+// TestCommandToProtobuf checks the protobuf runtime reads window commands as ServerMessage.command
+func TestCommandToProtobuf(t *testing.T) {
+	fd := gatewayProto(t)
+	for _, cmd := range []*WindowCommand{
+		{DeviceID: "esp32-1", TimestampMs: 1_700_000_000_000, Position: 37.5, Source: "ml", ExpiresAtMs: 1_700_000_060_000},
+		{DeviceID: "esp32-2", TimestampMs: 1_700_000_000_000, Position: 0, Source: "safety"},
+		{DeviceID: "esp32-3", TimestampMs: -5, Position: 100},
+	} {
+		msg := newMessage(t, fd, "ServerMessage")
+		if err := proto.Unmarshal(marshalCommand(cmd), msg); err != nil {
+			t.Fatalf("%s: failed to decode: %v", cmd.DeviceID, err)
+		}
+		payload := msg.WhichOneof(msg.Descriptor().Oneofs().ByName("payload"))
+		if payload == nil || payload.Name() != "command" {
+			t.Fatalf("%s: payload is %v, want command", cmd.DeviceID, payload)
+		}
+
+		got := msg.Get(payload).Message()
+		fields := got.Descriptor().Fields()
+		decoded := WindowCommand{
+			DeviceID:    got.Get(fields.ByName("device_id")).String(),
+			TimestampMs: got.Get(fields.ByName("timestamp_ms")).Int(),
+			Position:    got.Get(fields.ByName("position")).Float(),
+			Source:      got.Get(fields.ByName("source")).String(),
+			ExpiresAtMs: got.Get(fields.ByName("expires_at_ms")).Int(),
+		}
+		if decoded != *cmd {
+			t.Errorf("decoded %+v, want %+v", decoded, *cmd)
+		}
+	}
+}
+
+// compareBatches reports how a decoded batch differs from the expected one
+func compareBatches(t *testing.T, got, want *GatewayMessage) {
+	t.Helper()
+	if got.GatewayID != want.GatewayID || got.BatchID != want.BatchID {
+		t.Errorf("batch (%q, %d), want (%q, %d)", got.GatewayID, got.BatchID, want.GatewayID, want.BatchID)
+	}
+	if len(got.Readings) != len(want.Readings) {
+		t.Fatalf("%d readings, want %d", len(got.Readings), len(want.Readings))
+	}
+	for i, w := range want.Readings {
+		g := got.Readings[i]
+		// Compare value bits so a negative zero must survive too
+		if g.DeviceID != w.DeviceID || g.TimestampMs != w.TimestampMs || g.Type != w.Type || math.Float64bits(g.Value) != math.Float64bits(w.Value) {
+			t.Errorf("reading %d is %+v, want %+v", i, g, w)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Package hotstore keeps the last minutes of every device's readings in memory, so
+// aggregates over very recent data (the inference trigger's windows, short history
+// charts) are answered without querying ClickHouse. ClickHouse stays the store of
+// record: callers ask Covers first and fall back to the database for older ranges.
+package hotstore
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config holds hot store settings
+type Config struct {
+	Window      time.Duration // How far back readings are kept
+	MaxReadings int           // Per device and metric; older readings are evicted early beyond this
+}
+
+// DefaultConfig returns default configuration
+func DefaultConfig() Config {
+	return Config{
+		Window:      10 * time.Minute,
+		MaxReadings: 600, // One reading per second over the default window
+	}
+}
+
+// sample is one stored reading
+type sample struct {
+	timestamp time.Time
+	value     float64
+}
+
+// ring is a bounded circular buffer of one device's readings of one metric. It grows
+// up to the store's MaxReadings, so quiet devices stay small.
+type ring struct {
+	samples []sample
+	head    int // Index of the oldest sample
+	n       int
+
+	// Latest timestamp evicted before aging out: nothing at or before it is complete
+	evictedUntil time.Time
+}
+
+// Store holds recent readings per device and metric
+type Store struct {
+	window      time.Duration
+	maxReadings int
+	startedAt   time.Time // Nothing before this was ever stored
+
+	mu    sync.RWMutex
+	rings map[string]map[string]*ring // Device → metric → readings
+}
+
+// New creates an empty hot store
+func New(config Config) *Store {
+	return &Store{
+		window:      config.Window,
+		maxReadings: config.MaxReadings,
+		startedAt:   time.Now(),
+		rings:       make(map[string]map[string]*ring),
+	}
+}
+
+// Add stores a reading. Only readings that count towards aggregates (not flagged bad)
+// should be added, so answers match the database's.
+func (s *Store) Add(deviceID, metric string, timestamp time.Time, value float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metrics, ok := s.rings[deviceID]
+	if !ok {
+		metrics = make(map[string]*ring)
+		s.rings[deviceID] = metrics
+	}
+	r, ok := metrics[metric]
+	if !ok {
+		r = &ring{}
+		metrics[metric] = r
+	}
+
+	// Age out readings that left the window
+	cutoff := time.Now().Add(-s.window)
+	for r.n > 0 && r.samples[r.head].timestamp.Before(cutoff) {
+		r.pop()
+	}
+	if r.n == len(r.samples) && r.n < s.maxReadings {
+		r.grow(min(max(2*r.n, 16), s.maxReadings))
+	}
+	if r.n == len(r.samples) {
+		if evicted := r.pop(); evicted.timestamp.After(r.evictedUntil) {
+			r.evictedUntil = evicted.timestamp
+		}
+	}
+	r.samples[(r.head+r.n)%len(r.samples)] = sample{timestamp: timestamp, value: value}
+	r.n++
+}
+
+// grow moves the samples, oldest first, into a larger buffer
+func (r *ring) grow(capacity int) {
+	samples := make([]sample, capacity)
+	for i := 0; i < r.n; i++ {
+		samples[i] = r.samples[(r.head+i)%len(r.samples)]
+	}
+	r.samples, r.head = samples, 0
+}
+
+// pop removes and returns the oldest sample
+func (r *ring) pop() sample {
+	oldest := r.samples[r.head]
+	r.head = (r.head + 1) % len(r.samples)
+	r.n--
+	return oldest
+}
+
+// each calls fn for every sample within [from, to]
+func (r *ring) each(from, to time.Time, fn func(sample)) {
+	for i := 0; i < r.n; i++ {
+		smp := r.samples[(r.head+i)%len(r.samples)]
+		if !smp.timestamp.Before(from) && !smp.timestamp.After(to) {
+			fn(smp)
+		}
+	}
+}
+
+// Covers reports whether the store holds every stored reading of a device's metric
+// since from: the range starts within the window, after the backend started, and
+// after anything evicted early.
+func (s *Store) Covers(deviceID, metric string, from time.Time) bool {
+	if from.Before(s.startedAt) || from.Before(time.Now().Add(-s.window)) {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if r, ok := s.rings[deviceID][metric]; ok {
+		return from.After(r.evictedUntil)
+	}
+	return true // Nothing received since from
+}
+
+// Mean returns the mean and count of a device's readings of a metric within [from, to]
+func (s *Store) Mean(deviceID, metric string, from, to time.Time) (float64, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.rings[deviceID][metric]
+	if !ok {
+		return 0, 0
+	}
+	var sum float64
+	var count int
+	r.each(from, to, func(smp sample) {
+		sum += smp.value
+		count++
+	})
+	if count == 0 {
+		return 0, 0
+	}
+	return sum / float64(count), count
+}
+
+// Bucket is the mean of the readings in one bucket
+type Bucket struct {
+	Start time.Time
+	Mean  float64
+}
+
+// Buckets averages a device's readings of a metric within [from, to) into buckets of
+// step aligned to the Unix epoch, like ClickHouse's toStartOfInterval, oldest first.
+// Empty buckets are omitted.
+func (s *Store) Buckets(deviceID, metric string, from, to time.Time, step time.Duration) []Bucket {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.rings[deviceID][metric]
+	if !ok || step < time.Second {
+		return nil
+	}
+	seconds := int64(step / time.Second)
+	type total struct {
+		sum   float64
+		count int
+	}
+	totals := make(map[int64]*total)
+	var starts []int64
+	r.each(from, to, func(smp sample) {
+		if !smp.timestamp.Before(to) {
+			return
+		}
+		start := smp.timestamp.Unix() / seconds * seconds
+		t, ok := totals[start]
+		if !ok {
+			t = &total{}
+			totals[start] = t
+			starts = append(starts, start)
+		}
+		t.sum += smp.value
+		t.count++
+	})
+
+	buckets := make([]Bucket, 0, len(starts))
+	for _, start := range starts {
+		buckets = append(buckets, Bucket{Start: time.Unix(start, 0), Mean: totals[start].sum / float64(totals[start].count)})
+	}
+	// Readings normally arrive in order; a late one may open an earlier bucket
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start) })
+	return buckets
+}
@@ -0,0 +1,196 @@
+// Package ingest defines how readings enter the backend. Every transport (the MQTT
+// subscriber, HTTP, the gRPC gateway, the simulator) is a ReadingIngestor handing
+// decoded readings to one Pipeline, which validates them and queues them for the
+// sensor service to persist and trigger inference on. Transports only decode, so a
+// reading is treated the same whichever way it came.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"iot-backend/internal/errs"
+	"iot-backend/internal/models"
+)
+
+// ErrQueueFull is returned when the pipeline didn't take a reading within its timeout
+var ErrQueueFull = errors.New("backend queue full")
+
+// maxClockSkew is how far in the future a reading timestamp may be
+const maxClockSkew = time.Minute
+
+// Pipeline accepts decoded readings from any transport. A returned error means the
+// reading was rejected or dropped and the transport should report it to its sender.
+type Pipeline interface {
+	Temperature(reading *models.TemperatureReading) error
+	Humidity(reading *models.HumidityReading) error
+	Audio(recording *models.AudioRecording) error
+	Safety(event *models.SafetyEvent) error
+	Presence(reading *models.PresenceReading) error
+}
+
+// ReadingIngestor is a transport bringing readings into the backend
+type ReadingIngestor interface {
+	// Name identifies the transport in logs, e.g. "mqtt"
+	Name() string
+
+	// Start begins feeding readings to the pipeline until context is cancelled. It
+	// returns once the transport is receiving, or with the error that kept it from it.
+	Start(ctx context.Context, pipeline Pipeline) error
+}
+
+// PipelineConfig holds the delivery timeouts of the bus pipeline
+type PipelineConfig struct {
+	ReadingTimeout time.Duration // Temperature, humidity and presence readings
+	AudioTimeout   time.Duration // Longer, since clips are larger
+	SafetyTimeout  time.Duration // Short: the priority queue should always have room
+}
+
+// DefaultPipelineConfig returns default configuration
+func DefaultPipelineConfig() PipelineConfig {
+	return PipelineConfig{
+		ReadingTimeout: time.Second,
+		AudioTimeout:   2 * time.Second,
+		SafetyTimeout:  100 * time.Millisecond,
+	}
+}
+
+// BusPipeline validates readings and writes them to the event bus inputs, from
+// which the sensor service persists them and triggers inference
+type BusPipeline struct {
+	config      PipelineConfig
+	temperature chan<- *models.TemperatureReading
+	humidity    chan<- *models.HumidityReading
+	audio       chan<- *models.AudioRecording
+	safety      chan<- *models.SafetyEvent
+	presence    chan<- *models.PresenceReading
+}
+
+// NewBusPipeline creates a pipeline writing to the given channels
+func NewBusPipeline(
+	config PipelineConfig,
+	temperature chan<- *models.TemperatureReading,
+	humidity chan<- *models.HumidityReading,
+	audio chan<- *models.AudioRecording,
+	safety chan<- *models.SafetyEvent,
+	presence chan<- *models.PresenceReading,
+) *BusPipeline {
+	return &BusPipeline{
+		config:      config,
+		temperature: temperature,
+		humidity:    humidity,
+		audio:       audio,
+		safety:      safety,
+		presence:    presence,
+	}
+}
+
+// Temperature queues a temperature reading
+func (p *BusPipeline) Temperature(reading *models.TemperatureReading) error {
+	if err := validate("temperature", reading.DeviceID, reading.Value, reading.Timestamp); err != nil {
+		return err
+	}
+	return deliver(p.temperature, reading, p.config.ReadingTimeout)
+}
+
+// Humidity queues a humidity reading
+func (p *BusPipeline) Humidity(reading *models.HumidityReading) error {
+	if err := validate("humidity", reading.DeviceID, reading.Value, reading.Timestamp); err != nil {
+		return err
+	}
+	return deliver(p.humidity, reading, p.config.ReadingTimeout)
+}
+
+// Audio queues an audio recording
+func (p *BusPipeline) Audio(recording *models.AudioRecording) error {
+	if err := validate("audio", recording.DeviceID, recording.Duration, recording.Timestamp); err != nil {
+		return err
+	}
+	if len(recording.Data) == 0 {
+		return errs.Wrap(errs.ErrPayloadInvalid, errors.New("empty audio clip"))
+	}
+	return deliver(p.audio, recording, p.config.AudioTimeout)
+}
+
+// Safety queues a safety event
+func (p *BusPipeline) Safety(event *models.SafetyEvent) error {
+	if err := validate(event.EventType, event.DeviceID, event.Value, event.Timestamp); err != nil {
+		return err
+	}
+	return deliver(p.safety, event, p.config.SafetyTimeout)
+}
+
+// Presence queues a motion or CO2 reading
+func (p *BusPipeline) Presence(reading *models.PresenceReading) error {
+	if err := validate(reading.Kind, reading.DeviceID, reading.Value, reading.Timestamp); err != nil {
+		return err
+	}
+	return deliver(p.presence, reading, p.config.ReadingTimeout)
+}
+
+// validate checks what every reading needs, whichever transport carried it
+func validate(kind, deviceID string, value float64, timestamp time.Time) error {
+	if deviceID == "" {
+		return errs.Wrap(errs.ErrPayloadInvalid, errors.New("missing device_id"))
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return errs.Wrap(errs.ErrPayloadInvalid, fmt.Errorf("%s value is not finite", kind))
+	}
+	if timestamp.After(time.Now().Add(maxClockSkew)) {
+		return errs.Wrap(errs.ErrPayloadInvalid, fmt.Errorf("timestamp %s is in the future", timestamp.Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// deliver writes to a channel, giving up after timeout
+func deliver[T any](ch chan<- T, v T, timeout time.Duration) error {
+	select {
+	case ch <- v:
+		return nil
+	case <-time.After(timeout):
+		return ErrQueueFull
+	}
+}
+
+// Reading is a single typed value, the form in which the HTTP and gRPC
+// transports and the simulator carry readings
+type Reading struct {
+	DeviceID  string    `json:"device_id"`
+	Type      string    `json:"type"` // "temperature", "humidity", "motion", "co2", or a safety event type
+	Value     float64   `json:"value"`
+	Timestamp time.Time `json:"timestamp"` // Zero means the time of receipt
+}
+
+// IsSafetyType reports whether a reading type is a safety event, which closes
+// windows and so must only come from authenticated senders
+func IsSafetyType(readingType string) bool {
+	switch readingType {
+	case "rain", "wind", "alarm", models.SafetyOutdoorTemperature:
+		return true
+	}
+	return false
+}
+
+// Submit hands a reading to the pipeline as its kind. A zero timestamp becomes now,
+// the time of receipt.
+func Submit(pipeline Pipeline, r Reading, now time.Time) error {
+	timestamp := r.Timestamp
+	if timestamp.IsZero() {
+		timestamp = now
+	}
+
+	switch r.Type {
+	case "temperature":
+		return pipeline.Temperature(&models.TemperatureReading{Timestamp: timestamp, DeviceID: r.DeviceID, Value: r.Value})
+	case "humidity":
+		return pipeline.Humidity(&models.HumidityReading{Timestamp: timestamp, DeviceID: r.DeviceID, Value: r.Value})
+	case models.PresenceMotion, models.PresenceCO2:
+		return pipeline.Presence(&models.PresenceReading{Timestamp: timestamp, DeviceID: r.DeviceID, Kind: r.Type, Value: r.Value})
+	case "rain", "wind", "alarm", models.SafetyOutdoorTemperature:
+		return pipeline.Safety(&models.SafetyEvent{Timestamp: timestamp, DeviceID: r.DeviceID, EventType: r.Type, Value: r.Value})
+	}
+	return errs.Wrap(errs.ErrPayloadInvalid, fmt.Errorf("unknown reading type %q", r.Type))
+}
@@ -0,0 +1,262 @@
+// Package metrics provides lightweight in-process counters and latency timers.
+// Values are kept in memory, summarized to the log periodically, and can be
+// exported as a snapshot.
+package metrics
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// timerSamples is the number of recent observations kept for percentile estimates
+const timerSamples = 512
+
+// Counter is a monotonically increasing count
+type Counter struct {
+	value atomic.Int64
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add increments the counter by n
+func (c *Counter) Add(n int64) {
+	c.value.Add(n)
+}
+
+// Value returns the current count
+func (c *Counter) Value() int64 {
+	return c.value.Load()
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	value atomic.Int64
+}
+
+// Set sets the gauge value
+func (g *Gauge) Set(v int64) {
+	g.value.Store(v)
+}
+
+// Add adjusts the gauge by n (negative to decrease)
+func (g *Gauge) Add(n int64) {
+	g.value.Add(n)
+}
+
+// Value returns the current gauge value
+func (g *Gauge) Value() int64 {
+	return g.value.Load()
+}
+
+// Timer tracks a latency distribution
+type Timer struct {
+	mu      sync.Mutex
+	count   int64
+	total   time.Duration
+	max     time.Duration
+	samples []time.Duration // Ring buffer of recent observations
+	next    int
+}
+
+// TimerSnapshot summarizes a timer
+type TimerSnapshot struct {
+	Count  int64   `json:"count"`
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P95Ms  float64 `json:"p95_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+// Observe records one latency observation
+func (t *Timer) Observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.count++
+	t.total += d
+	if d > t.max {
+		t.max = d
+	}
+	if len(t.samples) < timerSamples {
+		t.samples = append(t.samples, d)
+	} else {
+		t.samples[t.next] = d
+		t.next = (t.next + 1) % timerSamples
+	}
+}
+
+// Since records the time elapsed since start
+func (t *Timer) Since(start time.Time) {
+	t.Observe(time.Since(start))
+}
+
+// Snapshot returns a summary of the timer. Percentiles cover recent observations only.
+func (t *Timer) Snapshot() TimerSnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snap := TimerSnapshot{Count: t.count, MaxMs: toMs(t.max)}
+	if t.count == 0 {
+		return snap
+	}
+	snap.MeanMs = toMs(t.total) / float64(t.count)
+
+	sorted := append([]time.Duration(nil), t.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	snap.P50Ms = toMs(sorted[len(sorted)*50/100])
+	snap.P95Ms = toMs(sorted[(len(sorted)*95)/100])
+	return snap
+}
+
+// toMs converts a duration to fractional milliseconds
+func toMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000.0
+}
+
+// Registry holds named metrics
+type Registry struct {
+	mu       sync.Mutex
+	counters map[string]*Counter
+	gauges   map[string]*Gauge
+	timers   map[string]*Timer
+}
+
+// Snapshot is a point-in-time copy of all metrics in a registry
+type Snapshot struct {
+	Counters map[string]int64         `json:"counters"`
+	Gauges   map[string]int64         `json:"gauges"`
+	Timers   map[string]TimerSnapshot `json:"timers"`
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		counters: make(map[string]*Counter),
+		gauges:   make(map[string]*Gauge),
+		timers:   make(map[string]*Timer),
+	}
+}
+
+// Default is the process-wide registry
+var Default = NewRegistry()
+
+// Counter returns the named counter, creating it on first use
+func (r *Registry) Counter(name string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.counters[name]
+	if !ok {
+		c = &Counter{}
+		r.counters[name] = c
+	}
+	return c
+}
+
+// Gauge returns the named gauge, creating it on first use
+func (r *Registry) Gauge(name string) *Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	g, ok := r.gauges[name]
+	if !ok {
+		g = &Gauge{}
+		r.gauges[name] = g
+	}
+	return g
+}
+
+// Timer returns the named timer, creating it on first use
+func (r *Registry) Timer(name string) *Timer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.timers[name]
+	if !ok {
+		t = &Timer{}
+		r.timers[name] = t
+	}
+	return t
+}
+
+// Snapshot returns the current value of every metric
+func (r *Registry) Snapshot() Snapshot {
+	r.mu.Lock()
+	counters := make(map[string]*Counter, len(r.counters))
+	for k, v := range r.counters {
+		counters[k] = v
+	}
+	gauges := make(map[string]*Gauge, len(r.gauges))
+	for k, v := range r.gauges {
+		gauges[k] = v
+	}
+	timers := make(map[string]*Timer, len(r.timers))
+	for k, v := range r.timers {
+		timers[k] = v
+	}
+	r.mu.Unlock()
+
+	snap := Snapshot{
+		Counters: make(map[string]int64, len(counters)),
+		Gauges:   make(map[string]int64, len(gauges)),
+		Timers:   make(map[string]TimerSnapshot, len(timers)),
+	}
+	for k, c := range counters {
+		snap.Counters[k] = c.Value()
+	}
+	for k, g := range gauges {
+		snap.Gauges[k] = g.Value()
+	}
+	for k, t := range timers {
+		snap.Timers[k] = t.Snapshot()
+	}
+	return snap
+}
+
+// LogSummary writes every timer and counter to the log in sorted order
+func (r *Registry) LogSummary() {
+	snap := r.Snapshot()
+
+	names := make([]string, 0, len(snap.Timers))
+	for name := range snap.Timers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := snap.Timers[name]
+		log.Printf("Metrics: %s count=%d mean=%.1fms p50=%.1fms p95=%.1fms max=%.1fms",
+			name, t.Count, t.MeanMs, t.P50Ms, t.P95Ms, t.MaxMs)
+	}
+
+	names = names[:0]
+	for name := range snap.Counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Printf("Metrics: %s=%d", name, snap.Counters[name])
+	}
+}
+
+// Start logs a summary every interval until context is cancelled
+func (r *Registry) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.LogSummary()
+		}
+	}
+}
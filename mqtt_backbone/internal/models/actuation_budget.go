@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// ActuationBudget is a window's movement budget and how much of it today's commands used
+type ActuationBudget struct {
+	DeviceID     string    `json:"device_id"`
+	Day          time.Time `json:"day"`           // Local midnight the budget resets at
+	Movements    int       `json:"movements"`     // Position changes commanded today
+	MaxMovements int       `json:"max_movements"` // 0 = unlimited
+	Travel       float64   `json:"travel"`        // Summed position change today (%)
+	MaxTravel    float64   `json:"max_travel"`    // 0 = unlimited
+	Denied       int       `json:"denied"`        // Decisions held at the current position because the budget was spent
+
+	RemainingMovements *int     `json:"remaining_movements,omitempty"` // nil when unlimited
+	RemainingTravel    *float64 `json:"remaining_travel,omitempty"`    // nil when unlimited
+	Position           *float64 `json:"position,omitempty"`            // Last commanded position, nil if unknown
+}
@@ -0,0 +1,79 @@
+package models
+
+import "time"
+
+// Alert severities
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Alert types
+const (
+	AlertMoldRisk         = "mold_risk"
+	AlertAudioAnomaly     = "audio_anomaly"
+	AlertDeviceOffline    = "device_offline"
+	AlertHighCO2          = "high_co2"
+	AlertNoiseExposure    = "noise_exposure"
+	AlertCanaryFailed     = "canary_failed"
+	AlertSensorDivergence = "sensor_divergence"
+)
+
+// Alert represents a condition that operators or residents should be told about
+type Alert struct {
+	Timestamp time.Time `json:"timestamp"`
+	DeviceID  string    `json:"device_id"`
+	Type      string    `json:"type"` // One of the Alert* types
+	Severity  string    `json:"severity"`
+	Message   string    `json:"message"`
+	Value     float64   `json:"value"` // Metric value that raised the alert
+}
+
+// MoldRisk represents the derived mold risk indicator for a device
+type MoldRisk struct {
+	Timestamp   time.Time `json:"timestamp"`
+	DeviceID    string    `json:"device_id"`
+	RiskIndex   float64   `json:"risk_index"` // 0 (no risk) - 1 (sustained risk conditions)
+	HoursAtRisk float64   `json:"hours_at_risk"`
+	Temperature float64   `json:"temperature"`
+	Humidity    float64   `json:"humidity"`
+}
+
+// Alert snooze statuses
+const (
+	SnoozeStatusActive    = "active"
+	SnoozeStatusExpired   = "expired"
+	SnoozeStatusCancelled = "cancelled"
+)
+
+// AlertSnooze silences the alerts of a device, of an alert type, or of a type on
+// one device, until it expires or is cancelled
+type AlertSnooze struct {
+	ID        string    `json:"id"`
+	DeviceID  string    `json:"device_id,omitempty"` // Empty: every device
+	Type      string    `json:"type,omitempty"`      // Alert type (empty: every type)
+	Until     time.Time `json:"until"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	Status    string    `json:"status"` // One of the SnoozeStatus* values
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Matches reports whether the snooze covers an alert
+func (s *AlertSnooze) Matches(alert *Alert) bool {
+	return (s.DeviceID == "" || s.DeviceID == alert.DeviceID) && (s.Type == "" || s.Type == alert.Type)
+}
+
+// AlertSnoozeAudit records a change to a snooze: its creation, cancellation, or expiry
+type AlertSnoozeAudit struct {
+	Timestamp time.Time `json:"timestamp"`
+	SnoozeID  string    `json:"snooze_id"`
+	Action    string    `json:"action"` // created, or the status it moved to (expired, cancelled)
+	DeviceID  string    `json:"device_id,omitempty"`
+	Type      string    `json:"type,omitempty"`
+	Until     time.Time `json:"until"`
+	Actor     string    `json:"actor,omitempty"` // Who made the change (empty for expiry)
+	Reason    string    `json:"reason,omitempty"`
+}
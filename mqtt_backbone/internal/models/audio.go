@@ -11,11 +11,118 @@ type AudioRecording struct {
 	SampleRate int       `json:"sample_rate"` // e.g., 16000 Hz
 	Duration   float64   `json:"duration"`    // seconds
 	Format     string    `json:"format"`      // "wav", "pcm"
+	Channels   int       `json:"channels"`    // Interleaved channels in Data (1 = mono)
+
+	// Per-channel RMS level (dB), set by the sensor service for multi-channel clips
+	ChannelVolumes []float64 `json:"channel_volumes,omitempty"`
+
+	QualityScore float64 `json:"quality_score"` // 0-1, set by the quality scorer
+	QualityFlag  string  `json:"quality_flag"`  // good, suspect, bad
+
+	// Percentile sound levels over the clip (dB), set by the sensor service
+	L10 float64 `json:"l10"` // Exceeded 10% of the time (intermittent peaks)
+	L50 float64 `json:"l50"` // Median level
+	L90 float64 `json:"l90"` // Exceeded 90% of the time (background)
+
+	// Acoustic fingerprint and its deviation from the device baseline, set by the sensor service
+	Features     map[string]float64 `json:"features"`
+	AnomalyScore float64            `json:"anomaly_score"` // RMS z-score across features (0 during warm-up)
+
+	// Loudness relative to the device's learned noise floor, set by the sensor service (0 until a floor is known)
+	NoiseFloor           float64 `json:"noise_floor"`            // Quiet baseline (dB)
+	RelativeVolume       float64 `json:"relative_volume"`        // Volume in dB above the noise floor
+	NoiseFloorCalibrated bool    `json:"noise_floor_calibrated"` // False while the floor is provisional
+
+	// Gain the microphone's AGC applied to this clip (dB), when the firmware reports it
+	AGCGainDB *float64 `json:"agc_gain_db,omitempty"`
+
+	// Set by the sensor service: share of samples above the clipping threshold, and the
+	// AGC gain subtracted from the levels (0 when not compensated)
+	ClippingRatio   float64 `json:"clipping_ratio"`
+	AGCCompensation float64 `json:"agc_compensation"`
+}
+
+// ClippingBucket summarizes how often a device's audio clipped over one bucket
+type ClippingBucket struct {
+	Timestamp    time.Time `json:"timestamp"` // Start of the bucket
+	Clips        int       `json:"clips"`
+	ClippedClips int       `json:"clipped_clips"` // Clips with any sample above the threshold
+	MeanRatio    float64   `json:"mean_ratio"`    // Mean share of clipped samples per clip (0-1)
+	MaxRatio     float64   `json:"max_ratio"`
+}
+
+// ClippingReport is a device's clipping rate over a time range
+type ClippingReport struct {
+	DeviceID   string           `json:"device_id"`
+	From       time.Time        `json:"from"`
+	To         time.Time        `json:"to"`
+	Resolution int              `json:"resolution"` // Bucket size in seconds
+	Clips      int              `json:"clips"`
+	Rate       float64          `json:"rate"` // Share of clips that clipped over the whole range (0-1)
+	Buckets    []ClippingBucket `json:"buckets"`
+}
+
+// AudioLevelWindow holds percentile sound levels for a device over a fixed window (e.g., an hour)
+type AudioLevelWindow struct {
+	WindowStart time.Time `json:"window_start"`
+	DeviceID    string    `json:"device_id"`
+	L10         float64   `json:"l10"`
+	L50         float64   `json:"l50"`
+	L90         float64   `json:"l90"`
+	Leq         float64   `json:"leq"`    // Energy-equivalent level
+	Frames      int       `json:"frames"` // 125 ms frames covered
 }
 
 // AudioPayload represents the incoming audio MQTT message structure
 type AudioPayload struct {
-	Data       []byte  `json:"data"` // Base64 encoded in JSON, auto-decoded to bytes
+	Data       []byte  `json:"data"` // Base64 encoded in JSON, auto-decoded to bytes (raw PCM or a WAV file)
 	SampleRate int     `json:"sample_rate"`
 	Duration   float64 `json:"duration"`
+	Channels   int     `json:"channels"` // Interleaved channels in raw PCM (0 or 1 = mono); WAV files carry their own
+
+	AGCGainDB *float64 `json:"agc_gain_db,omitempty"` // Gain the microphone's AGC applied, when the firmware reports it
+}
+
+// AudioChunk is one piece of a clip uploaded in chunks, so a dropped connection
+// costs the chunks that didn't arrive instead of the whole clip. Chunks may arrive
+// out of order or repeatedly; the clip fields are taken from the first chunk
+// carrying them (usually chunk 0).
+type AudioChunk struct {
+	ClipID string `json:"clip_id"` // Unique per device and clip
+	Index  int    `json:"index"`   // 0-based position in the clip
+	Total  int    `json:"total"`   // Chunks in the clip, the same on every chunk
+	Data   []byte `json:"data"`    // Base64 encoded in JSON
+
+	SampleRate int      `json:"sample_rate,omitempty"`
+	Duration   float64  `json:"duration,omitempty"`
+	Channels   int      `json:"channels,omitempty"`
+	AGCGainDB  *float64 `json:"agc_gain_db,omitempty"`
+}
+
+// Statuses of chunked audio uploads, published on the device's upload control topic
+const (
+	UploadMissing   = "missing"   // NACK: the listed chunks haven't arrived, resend them
+	UploadComplete  = "complete"  // Every chunk arrived; the device may discard the clip
+	UploadAbandoned = "abandoned" // The backend gave up on the clip and discarded its chunks
+)
+
+// AudioUploadControl tells a device how one of its chunked uploads stands
+type AudioUploadControl struct {
+	ClipID   string `json:"clip_id"`
+	Status   string `json:"status"`            // UploadMissing, UploadComplete, or UploadAbandoned
+	Missing  []int  `json:"missing,omitempty"` // Chunk indexes to resend (UploadMissing; the first ones if many are missing)
+	Received int    `json:"received"`          // Chunks the backend holds
+	Total    int    `json:"total"`
+}
+
+// AudioFormatMismatch records a clip whose declared format disagrees with its data,
+// usually a firmware bug that would otherwise silently skew volume numbers
+type AudioFormatMismatch struct {
+	Timestamp        time.Time `json:"timestamp"`
+	DeviceID         string    `json:"device_id"`
+	Issues           []string  `json:"issues"`            // e.g., "duration_mismatch", "unsupported_sample_rate"
+	SampleRate       int       `json:"sample_rate"`       // Declared sample rate (Hz)
+	DeclaredDuration float64   `json:"declared_duration"` // Seconds, as sent by the device
+	ActualDuration   float64   `json:"actual_duration"`   // Seconds implied by byte length / sample rate
+	Bytes            int       `json:"bytes"`
 }
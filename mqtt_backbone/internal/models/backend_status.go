@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Backend availability values published on the status topic
+const (
+	BackendOnline  = "online"
+	BackendOffline = "offline"
+)
+
+// BackendStatus is the backend's own availability, published retained so
+// devices and dashboards can tell when the backend is down
+type BackendStatus struct {
+	Status         string    `json:"status"` // online or offline
+	Version        string    `json:"version"`
+	Timestamp      time.Time `json:"timestamp"`
+	StartedAt      time.Time `json:"started_at"`
+	UptimeSeconds  float64   `json:"uptime_seconds"`
+	TrackedDevices int       `json:"tracked_devices"`
+	Reason         string    `json:"reason,omitempty"` // Why the backend went offline, e.g. "shutdown" or "connection_lost"
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// BackfillPayload is the incoming backfill MQTT message structure: readings a device
+// buffered while offline, published in one batch once it reconnects
+type BackfillPayload struct {
+	Unit     string          `json:"unit,omitempty"` // Temperature unit of the batch (C or F; empty uses the device's unit)
+	Readings []BackfillEntry `json:"readings"`
+}
+
+// BackfillEntry is one buffered sample; either value may be missing
+type BackfillEntry struct {
+	Timestamp   float64  `json:"ts"` // Unix seconds when the sample was taken (fractions allowed)
+	Temperature *float64 `json:"temperature,omitempty"`
+	Humidity    *float64 `json:"humidity,omitempty"`
+}
+
+// BackfillBatch is a decoded backfill message. Its readings keep the timestamps the
+// device recorded and are stored without reaching real-time consumers.
+type BackfillBatch struct {
+	DeviceID    string
+	ReceivedAt  time.Time
+	PayloadHash string // Of the raw payload, so a redelivered batch is stored once
+	Temperature []*TemperatureReading
+	Humidity    []*HumidityReading
+}
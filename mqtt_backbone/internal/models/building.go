@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// BuildingSummary rolls up the latest readings across every device in the building
+type BuildingSummary struct {
+	Since         time.Time           `json:"since"` // Start of the first hour included
+	Temperature   BuildingTemperature `json:"temperature"`
+	Windows       BuildingWindows     `json:"windows"`
+	NoisiestRooms []RoomNoise         `json:"noisiest_rooms"`
+}
+
+// BuildingTemperature summarizes indoor temperature across rooms
+type BuildingTemperature struct {
+	Average float64 `json:"average"` // Mean of the per-room means, so every room counts equally
+	Min     float64 `json:"min"`     // Coolest room mean
+	Max     float64 `json:"max"`     // Warmest room mean
+	Devices int     `json:"devices"` // Rooms with readings since Since
+	Unit    string  `json:"unit"`    // C or F, as requested
+}
+
+// BuildingWindows summarizes the last commanded position of every window
+type BuildingWindows struct {
+	Total       int     `json:"total"`
+	Open        int     `json:"open"`
+	OpenPercent float64 `json:"open_percent"` // 0-100
+}
+
+// RoomNoise is a room's sound volume since the start of a summary
+type RoomNoise struct {
+	DeviceID string  `json:"device_id"`
+	Name     string  `json:"name"`
+	Location string  `json:"location"`
+	Volume   float64 `json:"volume"` // Mean clip volume
+	Peak     float64 `json:"peak"`   // Loudest clip
+}
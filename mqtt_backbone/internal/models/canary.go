@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Stages of a canary run, in the order the synthetic readings pass them
+const (
+	CanaryStagePublish   = "publish"   // Readings published to the broker
+	CanaryStagePersist   = "persist"   // Temperature reading stored in ClickHouse
+	CanaryStageInference = "inference" // Inference request queued for the canary
+	CanaryStageResponse  = "response"  // Window control response received
+)
+
+// CanaryStatus is the outcome of the latest end-to-end canary run
+type CanaryStatus struct {
+	DeviceID       string             `json:"device_id"`
+	OK             bool               `json:"ok"`                     // The latest finished run completed within the deadline
+	LastRun        time.Time          `json:"last_run"`               // Start of the latest finished run
+	LastSuccess    time.Time          `json:"last_success"`           // Start of the latest run that completed (zero if none has)
+	FailedStage    string             `json:"failed_stage,omitempty"` // Stage the latest run didn't reach
+	Error          string             `json:"error,omitempty"`
+	LatencySeconds float64            `json:"latency_seconds"` // Publish to response (or to the failure)
+	StageSeconds   map[string]float64 `json:"stage_seconds"`   // Time from publish until each reached stage
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// ConnectionGap is a span during which nothing arrived on one subscription because
+// the broker connection was down. Readings within a gap were lost, not absent.
+type ConnectionGap struct {
+	Stream string     `json:"stream"`        // What the subscription carries, e.g. "temperature" or "audio"
+	Topic  string     `json:"topic"`         // Subscribed filter
+	Start  time.Time  `json:"start"`         // Connection lost
+	End    *time.Time `json:"end,omitempty"` // Resubscribed; nil while still disconnected (or the backend stopped before reconnecting)
+	Reason string     `json:"reason,omitempty"`
+}
+
+// Overlaps reports whether the gap overlaps [from, to)
+func (g ConnectionGap) Overlaps(from, to time.Time) bool {
+	return g.Start.Before(to) && (g.End == nil || g.End.After(from))
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// DeadLetter represents a message that was rejected and never acted upon
+type DeadLetter struct {
+	Timestamp time.Time `json:"timestamp"`
+	Source    string    `json:"source"` // Pipeline stage, e.g. "window_control"
+	DeviceID  string    `json:"device_id"`
+	Topic     string    `json:"topic"`
+	Payload   string    `json:"payload"` // Raw or re-serialized message
+	Reason    string    `json:"reason"`
+}
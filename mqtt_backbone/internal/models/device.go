@@ -11,6 +11,7 @@ type Device struct {
 	LastSeen     time.Time              `json:"last_seen"`
 	IsActive     bool                   `json:"is_active"`
 	Config       map[string]interface{} `json:"config"`
+	Tags         map[string]string      `json:"tags"` // Arbitrary metadata, e.g. {"floor": "2", "room": "bedroom"}
 }
 
 // MLPrediction represents ML model prediction metadata for logging
@@ -21,4 +22,36 @@ type MLPrediction struct {
 	Confidence       float64   `json:"confidence"`        // 0-1
 	InferenceTimeMs  float64   `json:"inference_time_ms"` // Inference latency
 	ModelVersion     string    `json:"model_version"`
+	ModelVariant     string    `json:"model_variant,omitempty"` // Seasonal or outdoor temperature variant the prediction was requested from
+}
+
+// DeviceState holds the latest per-device values used for change detection and rate limiting
+type DeviceState struct {
+	DeviceID          string    `json:"device_id"`
+	LastTemperature   float64   `json:"last_temperature"`
+	LastHumidity      float64   `json:"last_humidity"`
+	LastSoundVolume   float64   `json:"last_sound_volume"`
+	LastSeen          time.Time `json:"last_seen"`
+	LastInferenceTime time.Time `json:"last_inference_time"`
+
+	// When each metric was last reported (zero = never), so a missing metric isn't read as 0
+	TemperatureAt time.Time `json:"temperature_at"`
+	HumidityAt    time.Time `json:"humidity_at"`
+	SoundVolumeAt time.Time `json:"sound_volume_at"`
+}
+
+// DeviceBoot represents a boot announcement published by a device
+type DeviceBoot struct {
+	DeviceID  string    `json:"device_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Firmware  string    `json:"firmware"` // Optional firmware version reported by the device
+}
+
+// DeviceConfig represents the configuration sent to a device on boot
+type DeviceConfig struct {
+	DeviceID                string             `json:"device_id"`
+	SamplingIntervalSeconds int                `json:"sampling_interval_seconds"`
+	Thresholds              map[string]float64 `json:"thresholds,omitempty"`  // e.g., {"humidity_high": 70}
+	Calibration             map[string]float64 `json:"calibration,omitempty"` // Offsets, e.g., {"temperature_offset": -0.4}
+	IssuedAt                time.Time          `json:"issued_at"`
 }
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// DeviceMessageStats summarizes the MQTT traffic received from one device
+type DeviceMessageStats struct {
+	DeviceID        string    `json:"device_id"`
+	Since           time.Time `json:"since"`             // Start of the counts (first message since the backend started)
+	LastMessage     time.Time `json:"last_message"`      // When the latest message arrived
+	Messages        uint64    `json:"messages"`          // Messages since Since
+	MessagesPerHour float64   `json:"messages_per_hour"` // Messages over the last hour (extrapolated while younger than an hour)
+	AvgPayloadBytes float64   `json:"avg_payload_bytes"`
+	ParseErrors     uint64    `json:"parse_errors"`
+	ParseErrorRate  float64   `json:"parse_error_rate"` // Parse errors per message (0-1)
+	Dropped         uint64    `json:"dropped"`          // Parsed messages dropped (e.g., full queues)
+	Unauthorized    uint64    `json:"unauthorized"`     // Messages rejected because the sender failed device authentication
+
+	History []DeviceStatsWindow `json:"history,omitempty"` // Stored windows, oldest first
+}
+
+// DeviceStatsWindow holds one device's message counts over a flush interval
+type DeviceStatsWindow struct {
+	WindowStart   time.Time `json:"window_start"`
+	DeviceID      string    `json:"device_id"`
+	WindowSeconds int       `json:"window_seconds"`
+	Messages      uint64    `json:"messages"`
+	PayloadBytes  uint64    `json:"payload_bytes"`
+	ParseErrors   uint64    `json:"parse_errors"`
+	Dropped       uint64    `json:"dropped"`
+	Unauthorized  uint64    `json:"unauthorized"`
+}
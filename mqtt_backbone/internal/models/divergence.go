@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// SensorDivergence is how far a device's hourly mean of a metric was from the other
+// devices in its room over one hour. The room median includes the device itself, so
+// one failing sensor doesn't drag the reference of its neighbors along.
+type SensorDivergence struct {
+	Hour       time.Time `json:"hour"`
+	DeviceID   string    `json:"device_id"`
+	Metric     string    `json:"metric"` // "temperature" (°C) or "humidity" (%)
+	Room       string    `json:"room"`   // Value of the room tag shared with the neighbors
+	Value      float64   `json:"value"`
+	RoomMedian float64   `json:"room_median"` // Median of the room's hourly means, this device's included
+	Divergence float64   `json:"divergence"`  // Value minus the room median
+	Neighbors  int       `json:"neighbors"`   // Other devices of the room reporting in the hour
+}
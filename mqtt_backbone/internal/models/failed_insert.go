@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// FailedInsert is a row ClickHouse rejected permanently (e.g., a type error),
+// kept so it can be replayed once the cause is fixed
+type FailedInsert struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Table     string    `json:"table"`
+	DeviceID  string    `json:"device_id"`
+	Row       string    `json:"row"` // JSON object of column name to value
+	Error     string    `json:"error"`
+	Replayed  bool      `json:"replayed"`
+}
@@ -0,0 +1,13 @@
+package models
+
+// FeatureScaling describes how features are normalized before prediction. It is sent
+// with inference requests so the ML service scales inputs exactly as the local model
+// does, and can reject requests scaled for another artifact.
+type FeatureScaling struct {
+	Type         string             `json:"type"`          // "standard" or "minmax"
+	ModelVersion string             `json:"model_version"` // Artifact the parameters come from
+	Means        map[string]float64 `json:"means,omitempty"`
+	Stds         map[string]float64 `json:"stds,omitempty"`
+	Mins         map[string]float64 `json:"mins,omitempty"`
+	Maxs         map[string]float64 `json:"maxs,omitempty"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// FeatureSnapshot is the exact inference request payload sent to the ML service,
+// kept so a prediction can be reproduced from the same input
+type FeatureSnapshot struct {
+	CorrelationID string    `json:"correlation_id"`
+	Timestamp     time.Time `json:"timestamp"`
+	DeviceID      string    `json:"device_id"`
+	Topic         string    `json:"topic"`    // ML service instance the request was routed to
+	Features      string    `json:"features"` // JSON request payload as published, byte for byte
+}
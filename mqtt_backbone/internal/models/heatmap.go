@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Heatmap summarizes a device's hourly means of a metric by day of week and local hour
+// of day, the matrix usage heatmaps are drawn from
+type Heatmap struct {
+	DeviceID string    `json:"device_id"`
+	Metric   string    `json:"metric"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	TimeZone string    `json:"time_zone"`      // IANA zone the days and hours are local to ("Local" = the server's)
+	Unit     string    `json:"unit,omitempty"` // Temperature unit of the cells (temperature only)
+	Days     []string  `json:"days"`           // Row labels, Monday first
+
+	// Cells[day][hour], hour 0 = midnight; nil where the range holds no data
+	Cells [][]*HeatmapCell `json:"cells"`
+}
+
+// HeatmapCell summarizes the hourly means falling on one day of week and hour of day
+type HeatmapCell struct {
+	Mean  float64 `json:"mean"`
+	P10   float64 `json:"p10"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	Max   float64 `json:"max"`
+	Hours int     `json:"hours"` // Hourly means summarized, one per week the range covers
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// MessageTrace records the lifecycle of one sampled MQTT message
+type MessageTrace struct {
+	Timestamp    time.Time `json:"timestamp"`
+	TraceID      string    `json:"trace_id"`
+	Filter       string    `json:"filter"` // Subscription filter the message arrived on
+	Topic        string    `json:"topic"`
+	DeviceID     string    `json:"device_id"`
+	Payload      string    `json:"payload"` // Raw payload (hex if not UTF-8), truncated to the configured limit
+	PayloadBytes int       `json:"payload_bytes"`
+	Truncated    bool      `json:"truncated"`
+	Events       []string  `json:"events"`  // Parse results and handler decisions, in order
+	Outcome      string    `json:"outcome"` // Final decision, e.g. "forwarded", "parse_error", "dropped"
+	DurationUs   int64     `json:"duration_us"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// MLTimeout records an inference request the ML service never answered
+type MLTimeout struct {
+	Timestamp      time.Time `json:"timestamp"` // When the request was published
+	CorrelationID  string    `json:"correlation_id"`
+	DeviceID       string    `json:"device_id"`
+	Topic          string    `json:"topic"`           // Request topic (identifies the ML service instance)
+	TimeoutSeconds float64   `json:"timeout_seconds"` // Timeout in effect when the request expired
+}
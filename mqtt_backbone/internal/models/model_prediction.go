@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// ModelPrediction is the response of an additional ML model (e.g., noise or
+// security), which is recorded but never actuated
+type ModelPrediction struct {
+	Timestamp     time.Time `json:"timestamp"`
+	DeviceID      string    `json:"device_id"`
+	Model         string    `json:"model"` // Configured model name
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	Prediction    float64   `json:"prediction"`
+	Confidence    float64   `json:"confidence"`      // 0-1
+	Label         string    `json:"label,omitempty"` // Optional class, e.g. "intrusion"
+	ModelVersion  string    `json:"model_version,omitempty"`
+}
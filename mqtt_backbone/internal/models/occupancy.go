@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Presence sensor kinds
+const (
+	PresenceMotion = "motion" // PIR trigger (value 1) or idle report (value 0)
+	PresenceCO2    = "co2"    // CO2 concentration (ppm)
+)
+
+// PresenceReading is a motion or CO2 reading used for occupancy estimation
+type PresenceReading struct {
+	Timestamp time.Time `json:"timestamp"`
+	DeviceID  string    `json:"device_id"`
+	Kind      string    `json:"kind"` // PresenceMotion or PresenceCO2
+	Value     float64   `json:"value"`
+}
+
+// Occupancy is the estimated probability that a device's room is in use
+type Occupancy struct {
+	Timestamp   time.Time `json:"timestamp"`
+	DeviceID    string    `json:"device_id"`
+	Probability float64   `json:"probability"` // 0-1
+	Occupied    bool      `json:"occupied"`
+
+	// Log-likelihood ratio contributed by each source (positive favours occupied, 0 = no evidence)
+	SoundEvidence  float64 `json:"sound_evidence"`
+	MotionEvidence float64 `json:"motion_evidence"`
+	CO2Evidence    float64 `json:"co2_evidence"`
+	CO2Slope       float64 `json:"co2_slope"` // ppm per minute over the slope window
+}
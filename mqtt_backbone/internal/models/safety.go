@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// SafetyEvent represents a safety-critical event reported by a device
+// (rain, wind, alarm). These bypass the routine sensor queues.
+type SafetyEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	DeviceID  string    `json:"device_id"`
+	EventType string    `json:"type"`  // "rain", "wind", "alarm", "outdoor_temperature"
+	Value     float64   `json:"value"` // Sensor-specific magnitude (e.g., wind speed m/s)
+}
+
+// SafetyOutdoorTemperature events report the outdoor temperature (°C) for frost
+// protection rather than a hazard; they never close a window on their own
+const SafetyOutdoorTemperature = "outdoor_temperature"
+
+// SafetyNoise events close a window during sustained noise exposure; they are
+// raised by the backend from audio levels rather than sent by devices
+const SafetyNoise = "noise"
+
+// SafetyPayload represents the incoming safety MQTT message structure
+type SafetyPayload struct {
+	Type  string  `json:"type"`
+	Value float64 `json:"value"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Scheduled command statuses
+const (
+	ScheduleStatusPending   = "pending"
+	ScheduleStatusExecuted  = "executed"
+	ScheduleStatusMissed    = "missed" // Came due while the backend was down for longer than the grace period
+	ScheduleStatusCancelled = "cancelled"
+)
+
+// ScheduledCommand is a one-off window command planned for a future time. Commands
+// created by one request share a batch ID.
+type ScheduledCommand struct {
+	ID          string     `json:"id"`
+	BatchID     string     `json:"batch_id"`
+	DeviceID    string     `json:"device_id"`
+	ExecuteAt   time.Time  `json:"execute_at"`
+	Position    float64    `json:"position"`     // 0-100%
+	HoldMinutes int        `json:"hold_minutes"` // How long the position overrides the ML service
+	Reason      string     `json:"reason"`
+	Status      string     `json:"status"` // One of the ScheduleStatus* values
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	ExecutedAt  *time.Time `json:"executed_at,omitempty"` // Set once executed
+}
@@ -2,11 +2,24 @@ package models
 
 import "time"
 
+// Data-quality flags assigned to each stored reading
+const (
+	QualityGood    = "good"
+	QualitySuspect = "suspect"
+	QualityBad     = "bad" // Excluded from inference aggregates
+)
+
 // TemperatureReading represents temperature sensor data
 type TemperatureReading struct {
 	Timestamp time.Time `json:"timestamp"`
 	DeviceID  string    `json:"device_id"`
 	Value     float64   `json:"value"` // Celsius
+	Unit      string    `json:"unit,omitempty"` // Unit named in the payload (empty = the device's registry setting); the sensor service converts Value to Celsius
+
+	QualityScore float64 `json:"quality_score"` // 0-1, set by the quality scorer
+	QualityFlag  string  `json:"quality_flag"`  // good, suspect, bad
+
+	Backfilled bool `json:"backfilled,omitempty"` // Replayed from the device's offline buffer; left out of triggers and baselines
 }
 
 // HumidityReading represents humidity sensor data
@@ -14,6 +27,11 @@ type HumidityReading struct {
 	Timestamp time.Time `json:"timestamp"`
 	DeviceID  string    `json:"device_id"`
 	Value     float64   `json:"value"` // Percentage 0-100
+
+	QualityScore float64 `json:"quality_score"` // 0-1, set by the quality scorer
+	QualityFlag  string  `json:"quality_flag"`  // good, suspect, bad
+
+	Backfilled bool `json:"backfilled,omitempty"` // Replayed from the device's offline buffer; left out of triggers and baselines
 }
 
 // WindowAction represents the ML model decision for continuous window control
@@ -25,22 +43,97 @@ type WindowAction struct {
 	Temperature float64   `json:"temperature"`  // Input feature
 	Humidity    float64   `json:"humidity"`     // Input feature
 	SoundVolume float64   `json:"sound_volume"` // Input feature (dB)
+	DryRun      bool      `json:"dry_run"`      // Computed but not sent to the actuator
+}
+
+// WindowCommand represents a position command sent by the backend to a window actuator
+type WindowCommand struct {
+	DeviceID  string    `json:"device_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Position  float64   `json:"position"` // 0-100%
+	Source    string    `json:"source"`   // Decision source, e.g. "ml"
+	WindowID  string    `json:"window_id,omitempty"` // Window of a multi-window device ({window_id}); defaults to the device ID
+	CommandID string    `json:"command_id,omitempty"` // Unique per command; actuators apply an ID once and echo it in their ack
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Actuators reject the command (error "expired") when it arrives later than this
+	Critical  bool      `json:"-"` // Safety or manual decision: never expires and is resent until acknowledged
+}
+
+// Expired reports whether a command arrived too late to be applied
+func (c *WindowCommand) Expired(now time.Time) bool {
+	return c.ExpiresAt != nil && now.After(*c.ExpiresAt)
 }
 
 // InferenceRequest represents the request sent to Python ML service
 type InferenceRequest struct {
+	CorrelationID string  `json:"correlation_id"` // Echoed back in the response
+	Domain      string    `json:"domain,omitempty"` // Inference domain (e.g., "thermal"); empty for the single window model
 	DeviceID    string    `json:"device_id"`
 	Timestamp   time.Time `json:"timestamp"`
 	Temperature float64   `json:"temperature"`
 	Humidity    float64   `json:"humidity"`
 	SoundVolume float64   `json:"sound_volume"` // dB level
+	MoldRisk    float64   `json:"mold_risk"`    // Derived mold risk index (0-1); high values favour ventilation
+	Occupancy   float64   `json:"occupancy"`    // Estimated probability the room is in use (0-1)
+
+	// Lagged aggregates by feature name (e.g., "temperature_lag_1h"), as in training datasets; missing ones are absent
+	Lags map[string]float64 `json:"lags,omitempty"`
+
+	// Active local model variant (e.g., "winter"; "default" for the base model) when one is loaded,
+	// its scaling, and the features as it scales them
+	ModelVariant   string             `json:"model_variant,omitempty"`
+	Scaling        *FeatureScaling    `json:"scaling,omitempty"`
+	ScaledFeatures map[string]float64 `json:"scaled_features,omitempty"`
+}
+
+// Features returns the request's raw model inputs by feature name, lags included
+func (r *InferenceRequest) Features() map[string]float64 {
+	features := map[string]float64{
+		"temperature":  r.Temperature,
+		"humidity":     r.Humidity,
+		"sound_volume": r.SoundVolume,
+		"mold_risk":    r.MoldRisk,
+		"occupancy":    r.Occupancy,
+	}
+	for name, value := range r.Lags {
+		features[name] = value
+	}
+	return features
 }
 
 // InferenceResponse represents the response from Python ML service
 type InferenceResponse struct {
+	CorrelationID string                `json:"correlation_id,omitempty"` // Matches the request; older ML services omit it
+	Domain       string                 `json:"domain,omitempty"`         // Set from the response topic of a domain model
 	DeviceID     string                 `json:"device_id"`
 	Timestamp    time.Time              `json:"timestamp"`
 	Position     float64                `json:"position"`    // 0-100%
 	Confidence   float64                `json:"confidence"`  // 0-1
 	FeaturesUsed map[string]interface{} `json:"features_used"`
+
+	// Model that produced the prediction, as echoed by the ML service or else as requested
+	ModelVariant string                 `json:"model_variant,omitempty"`
+	ModelVersion string                 `json:"model_version,omitempty"`
+
+	// Reused by the backend for a request whose features hadn't changed, not sent by the ML service
+	Cached bool `json:"cached,omitempty"`
+}
+
+// QuarantinedReading represents a reading rejected by quality checks or the spike filter
+type QuarantinedReading struct {
+	Timestamp time.Time `json:"timestamp"`
+	DeviceID  string    `json:"device_id"`
+	Metric    string    `json:"metric"` // "temperature", "humidity"
+	Value     float64   `json:"value"`
+	Expected  float64   `json:"expected"` // Rolling median at the time of rejection
+	Reason    string    `json:"reason"`
+}
+
+// WindowDecision records how the arbitration module chose a window command
+type WindowDecision struct {
+	Timestamp time.Time `json:"timestamp"`
+	DeviceID  string    `json:"device_id"`
+	Position  float64   `json:"position"` // Commanded position after arbitration
+	Winner    string    `json:"winner"`   // Source that won ("safety", "manual", "schedule", "ml")
+	Summary   string    `json:"summary"`
+	Trace     string    `json:"trace"` // JSON array of per-proposal outcomes
 }
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Series is one metric of a device bucketed over a time range
+type Series struct {
+	DeviceID   string        `json:"device_id"`
+	Metric     string        `json:"metric"`
+	From       time.Time     `json:"from"`
+	To         time.Time     `json:"to"`
+	Source     string        `json:"source"`         // "raw" readings, the "hourly" rollup, or recent readings from "memory"
+	Resolution int           `json:"resolution"`     // Bucket size in seconds
+	Unit       string        `json:"unit,omitempty"` // Temperature unit of the points (temperature only)
+	Points     []SeriesPoint `json:"points"`
+
+	// Broker disconnections overlapping the range: buckets within them may be missing
+	// readings that were lost rather than never sent
+	Gaps []ConnectionGap `json:"gaps,omitempty"`
+}
+
+// SeriesPoint is the mean of a metric over one bucket
+type SeriesPoint struct {
+	Timestamp time.Time `json:"timestamp"` // Start of the bucket
+	Value     float64   `json:"value"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// TableStorage is the size and ingestion rate of one ClickHouse table
+type TableStorage struct {
+	Table             string  `json:"table"`
+	Rows              uint64  `json:"rows"`
+	BytesOnDisk       uint64  `json:"bytes_on_disk"` // Compressed, one replica per shard
+	BytesUncompressed uint64  `json:"bytes_uncompressed"`
+	Parts             uint64  `json:"parts"`
+	BytesPerRow       float64 `json:"bytes_per_row"` // On disk
+
+	// Ingestion over the report's days (zero for tables without timestamp and device_id columns)
+	RowsPerDay  float64         `json:"rows_per_day"`
+	BytesPerDay float64         `json:"bytes_per_day"` // Estimated from BytesPerRow
+	Daily       []DailyRowCount `json:"daily,omitempty"`
+}
+
+// DailyRowCount is the number of rows a table received with timestamps on one UTC day
+type DailyRowCount struct {
+	Day  time.Time `json:"day"`
+	Rows uint64    `json:"rows"`
+}
+
+// DeviceStorage is one device's share of ingestion across tables
+type DeviceStorage struct {
+	DeviceID    string             `json:"device_id"`
+	RowsPerDay  float64            `json:"rows_per_day"`
+	BytesPerDay float64            `json:"bytes_per_day"` // Estimated from each table's bytes per row
+	Tables      map[string]float64 `json:"tables"`        // Rows per day by table
+}
+
+// StorageReport summarizes storage use and ingestion rates for capacity planning
+type StorageReport struct {
+	GeneratedAt      time.Time       `json:"generated_at"`
+	Days             int             `json:"days"` // Ingestion is averaged over this many days
+	Rows             uint64          `json:"rows"`
+	BytesOnDisk      uint64          `json:"bytes_on_disk"`
+	RowsPerDay       float64         `json:"rows_per_day"`
+	BytesPerDay      float64         `json:"bytes_per_day"`
+	Tables           []TableStorage  `json:"tables"`  // Largest first
+	Devices          []DeviceStorage `json:"devices"` // Highest bytes per day first
+	DevicesTruncated bool            `json:"devices_truncated"`
+}
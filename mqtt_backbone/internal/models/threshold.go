@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// ThresholdReport recommends inference trigger thresholds from recent data
+type ThresholdReport struct {
+	GeneratedAt          time.Time             `json:"generated_at"`
+	Days                 int                   `json:"days"`                    // Period analyzed
+	WindowSeconds        int                   `json:"window_seconds"`          // Polling window the changes are measured over
+	TargetTriggersPerDay float64               `json:"target_triggers_per_day"` // Desired triggers per device, across all metrics
+	CurrentZScore        float64               `json:"current_z_score"`         // Configured trigger threshold
+	Suggestions          []ThresholdSuggestion `json:"suggestions"`
+}
+
+// ThresholdSuggestion is the recommended trigger threshold for one metric of one device
+type ThresholdSuggestion struct {
+	DeviceID              string  `json:"device_id"`
+	Metric                string  `json:"metric"`
+	StdDev                float64 `json:"std_dev"`                  // Baseline standard deviation of the readings
+	Intervals             int     `json:"intervals"`                // Window changes observed
+	SuggestedZScore       float64 `json:"suggested_z_score"`        // Z-score reached at the target trigger rate
+	SuggestedDelta        float64 `json:"suggested_delta"`          // The same threshold in the metric's units
+	CurrentTriggersPerDay float64 `json:"current_triggers_per_day"` // Triggers per day this metric caused at the current z-score
+}
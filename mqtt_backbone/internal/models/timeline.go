@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Timeline event kinds
+const (
+	TimelineReadings      = "readings"       // Mean readings over one bucket
+	TimelineInference     = "inference"      // An inference was triggered
+	TimelinePrediction    = "prediction"     // The window model answered
+	TimelineWindowCommand = "window_command" // A position was commanded on the ML decision
+	TimelineOverride      = "override"       // A position was commanded by another source (safety, manual, schedule, rules)
+	TimelineSafety        = "safety_event"
+	TimelineAlert         = "alert"
+)
+
+// Timeline is the time-ordered event stream of a device, for reconstructing incidents
+type Timeline struct {
+	DeviceID  string          `json:"device_id"`
+	From      time.Time       `json:"from"`
+	To        time.Time       `json:"to"`
+	Truncated bool            `json:"truncated"` // Some kind had more events than the per-kind limit
+	Events    []TimelineEvent `json:"events"`
+	Gaps      []ConnectionGap `json:"gaps,omitempty"` // Broker disconnections overlapping the range, on any stream
+}
+
+// TimelineEvent is one entry of a device timeline
+type TimelineEvent struct {
+	Timestamp time.Time              `json:"timestamp"`
+	Kind      string                 `json:"kind"` // One of the Timeline* kinds
+	Summary   string                 `json:"summary"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
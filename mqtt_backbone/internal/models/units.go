@@ -0,0 +1,35 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Temperature units. Readings are stored in Celsius; devices may report Fahrenheit
+// (a per-device "temperature_unit" registry setting or a unit in the payload) and the
+// API can render either.
+const (
+	UnitCelsius    = "C"
+	UnitFahrenheit = "F"
+)
+
+// ParseTemperatureUnit normalizes a unit such as "F", "°F", or "fahrenheit"
+func ParseTemperatureUnit(s string) (string, error) {
+	switch strings.ToLower(strings.TrimPrefix(strings.TrimSpace(s), "°")) {
+	case "c", "celsius":
+		return UnitCelsius, nil
+	case "f", "fahrenheit":
+		return UnitFahrenheit, nil
+	}
+	return "", fmt.Errorf("unknown temperature unit %q (want C or F)", s)
+}
+
+// FahrenheitToCelsius converts a temperature
+func FahrenheitToCelsius(f float64) float64 {
+	return (f - 32) * 5 / 9
+}
+
+// CelsiusToFahrenheit converts a temperature
+func CelsiusToFahrenheit(c float64) float64 {
+	return c*9/5 + 32
+}
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// Window command ack statuses reported by actuators
+const (
+	AckApplied  = "applied"  // The window moved to the commanded position
+	AckRejected = "rejected" // The actuator refused the command (e.g., obstruction, motor fault)
+)
+
+// Window command outcomes recorded by the backend
+const (
+	CommandAcked    = "acked"    // Applied at the commanded position
+	CommandMismatch = "mismatch" // Applied, but the reported position is off the commanded one
+	CommandRejected = "rejected" // Refused by the actuator
+	CommandExpired  = "expired"  // Never acknowledged, even after resending
+)
+
+// WindowCommandAck is an actuator's acknowledgement of a window command, published
+// on the ack topic once per applied (or refused) command ID
+type WindowCommandAck struct {
+	CommandID string    `json:"command_id"`
+	DeviceID  string    `json:"device_id"`
+	Status    string    `json:"status"`          // AckApplied or AckRejected (empty means applied)
+	Position  *float64  `json:"position"`        // Position the window reports after the command, 0-100%
+	Error     string    `json:"error,omitempty"` // Why the command was rejected
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// WindowCommandOutcome records how a window command ended: acknowledged and
+// reconciled against the reported position, rejected, or expired unanswered
+type WindowCommandOutcome struct {
+	Timestamp time.Time `json:"timestamp"` // When the command was first published
+	DeviceID  string    `json:"device_id"`
+	CommandID string    `json:"command_id"`
+	Commanded float64   `json:"commanded"` // Commanded position, 0-100%
+	Reported  *float64  `json:"reported"`  // Reported position (nil without an ack or position)
+	Outcome   string    `json:"outcome"`   // CommandAcked, CommandMismatch, CommandRejected, or CommandExpired
+	Attempts  int       `json:"attempts"`  // Times the command was published
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// WindowUsageDay summarizes how a device's window was used over one local day
+type WindowUsageDay struct {
+	Day         time.Time `json:"day"` // Local midnight
+	DeviceID    string    `json:"device_id"`
+	Seconds     int       `json:"seconds"`      // Time with a known position (less for today or a new device)
+	OpenSeconds int       `json:"open_seconds"` // Time spent open
+	OpenEvents  int       `json:"open_events"`  // Times the window went from closed to open
+	AvgPosition float64   `json:"avg_position"` // Time-weighted mean position (0-100%)
+}
+
+// WindowUsageHour holds a device's window position and room conditions over one hour
+type WindowUsageHour struct {
+	Hour        time.Time `json:"hour"`
+	DeviceID    string    `json:"device_id"`
+	Seconds     int       `json:"seconds"` // Time with a known position
+	OpenSeconds int       `json:"open_seconds"`
+	AvgPosition float64   `json:"avg_position"`
+	Temperature *float64  `json:"temperature,omitempty"` // Mean over the hour (°C), nil without readings
+	Noise       *float64  `json:"noise,omitempty"`       // Mean sound volume (dB), nil without clips
+}
+
+// WindowUsageReport summarizes a device's stored window usage over a time range, for
+// insight into how occupants (or the ML service) actually use the windows
+type WindowUsageReport struct {
+	DeviceID     string    `json:"device_id"`
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	Seconds      int       `json:"seconds"` // Analyzed time with a known position
+	OpenSeconds  int       `json:"open_seconds"`
+	OpenFraction float64   `json:"open_fraction"` // Share of the analyzed time the window was open (0-1)
+	OpenEvents   int       `json:"open_events"`
+	AvgPosition  float64   `json:"avg_position"`
+
+	// Mean position per local hour of day (index 0 = midnight), nil for hours without data
+	AvgPositionByHour []*float64 `json:"avg_position_by_hour"`
+
+	// Pearson correlation of hourly mean position with temperature and noise (-1 to 1),
+	// nil when there are too few hours or either series is constant
+	TemperatureCorrelation *float64 `json:"temperature_correlation"`
+	NoiseCorrelation       *float64 `json:"noise_correlation"`
+
+	Days []WindowUsageDay `json:"days"`
+}
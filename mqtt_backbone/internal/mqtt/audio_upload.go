@@ -0,0 +1,465 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-backend/internal/clipstore"
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+)
+
+// maxNackIndexes bounds the chunk indexes listed in one NACK; the rest are asked
+// for once the first ones arrive
+const maxNackIndexes = 256
+
+// maxClipIDLength bounds the clip IDs devices choose
+const maxClipIDLength = 64
+
+// Object key suffixes of chunked uploads in the store
+const (
+	uploadMetaSuffix  = ".upload"
+	uploadChunkSuffix = ".chunk"
+)
+
+// AudioUploadConfig holds configuration for chunked audio uploads
+type AudioUploadConfig struct {
+	NackAfter    time.Duration // An incomplete upload without new chunks for this long is NACKed for its missing chunks
+	MaxNacks     int           // NACKs without progress before an upload is abandoned
+	MaxChunks    int           // Chunks per clip
+	MaxClipBytes int           // Size of a reassembled clip
+	MaxUploads   int           // Incomplete uploads held at once, across devices
+	CompletedTTL time.Duration // How long completed clip IDs are remembered, so repeated chunks are answered again
+}
+
+// DefaultAudioUploadConfig returns default configuration
+func DefaultAudioUploadConfig() AudioUploadConfig {
+	return AudioUploadConfig{
+		NackAfter:    5 * time.Second,
+		MaxNacks:     5,
+		MaxChunks:    1024,
+		MaxClipBytes: 8 << 20,
+		MaxUploads:   256,
+		CompletedTTL: 10 * time.Minute,
+	}
+}
+
+// chunkBitmap records which chunks of a clip have arrived
+type chunkBitmap []uint64
+
+func newChunkBitmap(n int) chunkBitmap {
+	return make(chunkBitmap, (n+63)/64)
+}
+
+func (b chunkBitmap) has(i int) bool { return b[i/64]&(1<<(uint(i)%64)) != 0 }
+func (b chunkBitmap) set(i int)      { b[i/64] |= 1 << (uint(i) % 64) }
+
+// count returns the number of chunks that have arrived
+func (b chunkBitmap) count() int {
+	n := 0
+	for _, w := range b {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// missing returns up to limit indexes of chunks that haven't arrived, of total
+func (b chunkBitmap) missing(total, limit int) []int {
+	var indexes []int
+	for i := 0; i < total && len(indexes) < limit; i++ {
+		if !b.has(i) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// uploadMeta is the stored description of an incomplete upload
+type uploadMeta struct {
+	DeviceID   string    `json:"device_id"`
+	ClipID     string    `json:"clip_id"`
+	Total      int       `json:"total"`
+	SampleRate int       `json:"sample_rate,omitempty"`
+	Duration   float64   `json:"duration,omitempty"`
+	Channels   int       `json:"channels,omitempty"`
+	AGCGainDB  *float64  `json:"agc_gain_db,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+// audioUpload is a clip whose chunks are still arriving
+type audioUpload struct {
+	meta     uploadMeta
+	received chunkBitmap
+	bytes    int
+	lastAt   time.Time // Last progress (or NACK)
+	nacks    int       // NACKs since the last progress
+}
+
+// AudioUploads reassembles clips devices upload in chunks. Chunks are kept in a
+// store as they arrive, so neither a flaky connection nor a backend restart costs
+// the chunks already received: an incomplete upload is NACKed on the device's
+// control topic with the chunks still missing once no new chunk has arrived for
+// NackAfter (right away when the last chunk arrives with gaps), and the device
+// resends just those. An upload NACKed MaxNacks times without progress is
+// abandoned. Completion is confirmed to the device, also for every chunk repeated
+// after it.
+type AudioUploads struct {
+	config  AudioUploadConfig
+	store   clipstore.Store
+	publish func(deviceID string, ctl *models.AudioUploadControl) error
+
+	mu        sync.Mutex
+	uploads   map[string]*audioUpload // upload ID -> upload
+	completed map[string]time.Time    // Upload ID of a completed clip -> when
+	restored  []RestoredClip          // Clips found complete by restore, until taken
+}
+
+// RestoredClip is a clip whose last chunk was stored by a previous run that
+// stopped before reassembling it
+type RestoredClip struct {
+	DeviceID string
+	ClipID   string
+	Payload  *models.AudioPayload
+}
+
+// NewAudioUploads creates the reassembler and restores the incomplete uploads kept
+// in store by a previous run. Give it to the publisher (PublisherConfig.Uploads),
+// which sends its NACKs.
+func NewAudioUploads(config AudioUploadConfig, store clipstore.Store) (*AudioUploads, error) {
+	u := &AudioUploads{
+		config:    config,
+		store:     store,
+		uploads:   make(map[string]*audioUpload),
+		completed: make(map[string]time.Time),
+	}
+	if err := u.restore(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// uploadID names a device's clip in the store; device and clip IDs are hashed so
+// any IDs make valid keys
+func uploadID(deviceID, clipID string) string {
+	sum := sha256.Sum256([]byte(deviceID + "\x00" + clipID))
+	return hex.EncodeToString(sum[:12])
+}
+
+func chunkKey(id string, index int) string {
+	return fmt.Sprintf("%s-%05d%s", id, index, uploadChunkSuffix)
+}
+
+// restore reloads incomplete uploads from the store. Chunks without an upload are
+// left over from a crash between writes and are removed. Uploads that turn out to
+// be complete are reassembled and kept for TakeRestored.
+func (u *AudioUploads) restore() error {
+	keys, err := u.store.List()
+	if err != nil {
+		return fmt.Errorf("failed to list audio uploads: %w", err)
+	}
+
+	now := time.Now()
+	chunks := make(map[string][]string)
+	for _, key := range keys {
+		switch {
+		case strings.HasSuffix(key, uploadMetaSuffix):
+			id := strings.TrimSuffix(key, uploadMetaSuffix)
+			data, err := u.store.Get(key)
+			if err != nil {
+				return fmt.Errorf("failed to read audio upload %s: %w", id, err)
+			}
+			var meta uploadMeta
+			if err := json.Unmarshal(data, &meta); err != nil || meta.Total < 1 || meta.Total > u.config.MaxChunks {
+				log.Printf("Warning: Discarding unreadable audio upload %s", id)
+				u.store.Delete(key)
+				continue
+			}
+			u.uploads[id] = &audioUpload{meta: meta, received: newChunkBitmap(meta.Total), lastAt: now}
+		case strings.HasSuffix(key, uploadChunkSuffix):
+			id, _, _ := strings.Cut(key, "-")
+			chunks[id] = append(chunks[id], key)
+		}
+	}
+
+	for id, keys := range chunks {
+		upload, ok := u.uploads[id]
+		for _, key := range keys {
+			index, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(key, id+"-"), uploadChunkSuffix))
+			if !ok || err != nil || index < 0 || index >= upload.meta.Total {
+				u.store.Delete(key)
+				continue
+			}
+			data, err := u.store.Get(key)
+			if err != nil {
+				return fmt.Errorf("failed to read audio upload %s: %w", id, err)
+			}
+			upload.received.set(index)
+			upload.bytes += len(data)
+		}
+	}
+
+	for id, upload := range u.uploads {
+		if upload.received.count() < upload.meta.Total {
+			continue
+		}
+		payload, err := u.assemble(id, upload)
+		u.remove(id, upload)
+		if err != nil {
+			log.Printf("Warning: Discarding audio upload %s from %s: %v", upload.meta.ClipID, upload.meta.DeviceID, err)
+			continue
+		}
+		u.completed[id] = now
+		u.restored = append(u.restored, RestoredClip{DeviceID: upload.meta.DeviceID, ClipID: upload.meta.ClipID, Payload: payload})
+		metrics.Default.Counter("audio_uploads_completed").Inc()
+	}
+
+	if len(u.restored) > 0 {
+		log.Printf("AudioUploads: Reassembled %d upload(s) completed by the previous run", len(u.restored))
+	}
+	if len(u.uploads) > 0 {
+		log.Printf("AudioUploads: Resuming %d incomplete upload(s) from the previous run", len(u.uploads))
+	}
+	metrics.Default.Gauge("audio_uploads_pending").Set(int64(len(u.uploads)))
+	return nil
+}
+
+// Receive stores a chunk of a device's clip. It returns the reassembled clip when
+// the chunk completes it, and nil while chunks are missing or for a repeated one.
+func (u *AudioUploads) Receive(deviceID string, chunk *models.AudioChunk) (*models.AudioPayload, error) {
+	switch {
+	case chunk.ClipID == "" || len(chunk.ClipID) > maxClipIDLength:
+		return nil, fmt.Errorf("clip_id must be 1-%d characters", maxClipIDLength)
+	case chunk.Total < 1 || chunk.Total > u.config.MaxChunks:
+		return nil, fmt.Errorf("total must be between 1 and %d chunks", u.config.MaxChunks)
+	case chunk.Index < 0 || chunk.Index >= chunk.Total:
+		return nil, fmt.Errorf("chunk index %d out of range for %d chunks", chunk.Index, chunk.Total)
+	case len(chunk.Data) == 0:
+		return nil, fmt.Errorf("chunk %d of clip %s has no data", chunk.Index, chunk.ClipID)
+	}
+
+	now := time.Now()
+	id := uploadID(deviceID, chunk.ClipID)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, done := u.completed[id]; done {
+		metrics.Default.Counter("audio_chunks_duplicate").Inc()
+		u.send(deviceID, &models.AudioUploadControl{ClipID: chunk.ClipID, Status: models.UploadComplete, Received: chunk.Total, Total: chunk.Total})
+		return nil, nil
+	}
+
+	upload, ok := u.uploads[id]
+	if !ok {
+		if len(u.uploads) >= u.config.MaxUploads {
+			return nil, fmt.Errorf("%d uploads already in progress", len(u.uploads))
+		}
+		upload = &audioUpload{
+			meta:     uploadMeta{DeviceID: deviceID, ClipID: chunk.ClipID, Total: chunk.Total, StartedAt: now},
+			received: newChunkBitmap(chunk.Total),
+		}
+	}
+	if upload.meta.Total != chunk.Total {
+		return nil, fmt.Errorf("chunk of clip %s says %d chunks, the upload has %d", chunk.ClipID, chunk.Total, upload.meta.Total)
+	}
+	if upload.received.has(chunk.Index) {
+		metrics.Default.Counter("audio_chunks_duplicate").Inc()
+		return nil, nil
+	}
+	if upload.bytes+len(chunk.Data) > u.config.MaxClipBytes {
+		return nil, fmt.Errorf("clip %s exceeds %d bytes", chunk.ClipID, u.config.MaxClipBytes)
+	}
+
+	// The upload is stored before its first chunk, and again when a chunk brings the clip fields
+	fields := upload.meta.SampleRate == 0 && chunk.SampleRate > 0
+	if fields {
+		upload.meta.SampleRate = chunk.SampleRate
+		upload.meta.Duration = chunk.Duration
+		upload.meta.Channels = chunk.Channels
+		upload.meta.AGCGainDB = chunk.AGCGainDB
+	}
+	if !ok || fields {
+		data, err := json.Marshal(upload.meta)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal audio upload: %w", err)
+		}
+		if err := u.store.Put(id+uploadMetaSuffix, data); err != nil {
+			return nil, fmt.Errorf("failed to store audio upload: %w", err)
+		}
+		u.uploads[id] = upload
+	}
+	if err := u.store.Put(chunkKey(id, chunk.Index), chunk.Data); err != nil {
+		return nil, fmt.Errorf("failed to store audio chunk: %w", err)
+	}
+
+	upload.received.set(chunk.Index)
+	upload.bytes += len(chunk.Data)
+	upload.lastAt = now
+	upload.nacks = 0
+	metrics.Default.Counter("audio_chunks_received").Inc()
+	metrics.Default.Gauge("audio_uploads_pending").Set(int64(len(u.uploads)))
+
+	received := upload.received.count()
+	if received < upload.meta.Total {
+		// The last chunk doesn't have to wait for the idle NACK to report gaps
+		if chunk.Index == upload.meta.Total-1 {
+			u.nack(upload)
+		}
+		return nil, nil
+	}
+
+	payload, err := u.assemble(id, upload)
+	if err != nil {
+		// A chunk lost from the store can't be asked for again
+		u.remove(id, upload)
+		u.send(deviceID, &models.AudioUploadControl{ClipID: chunk.ClipID, Status: models.UploadAbandoned, Total: upload.meta.Total})
+		return nil, err
+	}
+	u.remove(id, upload)
+	u.completed[id] = now
+	metrics.Default.Counter("audio_uploads_completed").Inc()
+	metrics.Default.Timer("audio_upload_duration").Observe(now.Sub(upload.meta.StartedAt))
+	u.send(deviceID, &models.AudioUploadControl{ClipID: chunk.ClipID, Status: models.UploadComplete, Received: received, Total: upload.meta.Total})
+	return payload, nil
+}
+
+// TakeRestored returns the clips restore found complete, once. Their IDs count as
+// completed, so a device repeating a chunk of one is told the clip is complete.
+func (u *AudioUploads) TakeRestored() []RestoredClip {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	clips := u.restored
+	u.restored = nil
+	return clips
+}
+
+// assemble joins the chunks of a complete upload into its clip
+func (u *AudioUploads) assemble(id string, upload *audioUpload) (*models.AudioPayload, error) {
+	data := make([]byte, 0, upload.bytes)
+	for i := 0; i < upload.meta.Total; i++ {
+		chunk, err := u.store.Get(chunkKey(id, i))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunk %d of clip %s: %w", i, upload.meta.ClipID, err)
+		}
+		data = append(data, chunk...)
+	}
+	return &models.AudioPayload{
+		Data:       data,
+		SampleRate: upload.meta.SampleRate,
+		Duration:   upload.meta.Duration,
+		Channels:   upload.meta.Channels,
+		AGCGainDB:  upload.meta.AGCGainDB,
+	}, nil
+}
+
+// remove forgets an upload and deletes its objects, the upload last so a crash
+// in between only leaves chunks that restore discards
+func (u *AudioUploads) remove(id string, upload *audioUpload) {
+	delete(u.uploads, id)
+	metrics.Default.Gauge("audio_uploads_pending").Set(int64(len(u.uploads)))
+
+	for i := 0; i < upload.meta.Total; i++ {
+		if upload.received.has(i) {
+			if err := u.store.Delete(chunkKey(id, i)); err != nil {
+				log.Printf("Warning: Could not remove audio chunk: %v", err)
+			}
+		}
+	}
+	if err := u.store.Delete(id + uploadMetaSuffix); err != nil {
+		log.Printf("Warning: Could not remove audio upload: %v", err)
+	}
+}
+
+// nack asks the device for the chunks of an upload that haven't arrived
+func (u *AudioUploads) nack(upload *audioUpload) {
+	upload.nacks++
+	metrics.Default.Counter("audio_upload_nacks").Inc()
+	u.send(upload.meta.DeviceID, &models.AudioUploadControl{
+		ClipID:   upload.meta.ClipID,
+		Status:   models.UploadMissing,
+		Missing:  upload.received.missing(upload.meta.Total, maxNackIndexes),
+		Received: upload.received.count(),
+		Total:    upload.meta.Total,
+	})
+}
+
+// send publishes a control message without blocking the caller, which may be an
+// MQTT message handler; it's a no-op before the publisher is wired
+func (u *AudioUploads) send(deviceID string, ctl *models.AudioUploadControl) {
+	publish := u.publish
+	if publish == nil {
+		return
+	}
+	go func() {
+		if err := publish(deviceID, ctl); err != nil {
+			log.Printf("Error publishing audio upload %s for %s: %v", ctl.Status, deviceID, err)
+		}
+	}()
+}
+
+// Start NACKs idle uploads and abandons stalled ones until context is cancelled
+func (u *AudioUploads) Start(ctx context.Context) {
+	interval := u.config.NackAfter / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("AudioUploads: NACKing uploads idle for %v (abandoned after %d NACKs without progress)",
+		u.config.NackAfter, u.config.MaxNacks)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			u.sweep(now)
+		}
+	}
+}
+
+// sweep NACKs or abandons every upload idle for NackAfter, and forgets completed
+// clip IDs older than CompletedTTL
+func (u *AudioUploads) sweep(now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for id, upload := range u.uploads {
+		if now.Sub(upload.lastAt) < u.config.NackAfter {
+			continue
+		}
+		if upload.nacks < u.config.MaxNacks {
+			upload.lastAt = now
+			u.nack(upload)
+			continue
+		}
+
+		received := upload.received.count()
+		u.remove(id, upload)
+		metrics.Default.Counter("audio_uploads_abandoned").Inc()
+		log.Printf("Warning: Abandoned audio upload %s from %s with %d of %d chunks after %d NACKs",
+			upload.meta.ClipID, upload.meta.DeviceID, received, upload.meta.Total, upload.nacks)
+		u.send(upload.meta.DeviceID, &models.AudioUploadControl{
+			ClipID:   upload.meta.ClipID,
+			Status:   models.UploadAbandoned,
+			Received: received,
+			Total:    upload.meta.Total,
+		})
+	}
+	for id, at := range u.completed {
+		if now.Sub(at) > u.config.CompletedTTL {
+			delete(u.completed, id)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package mqtt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"iot-backend/internal/metrics"
+)
+
+// Authenticated payloads are JSON envelopes carrying the device's token next to the
+// message the device would otherwise send:
+//
+//	{"device_token": "<token>", "payload": 21.5}
+//	{"device_token": "<token>", "payload": {"type": "rain", "value": 1}}
+//	{"device_token": "<token>", "payload": "<base64 frame>"}
+//
+// A JSON string payload stands for its contents, base64-decoded on binary streams
+// (packed frames); any other JSON value stands for its encoding. Tokens live in
+// the device registry at config["device_token"].
+//
+// The topic alone names the device, and MQTT 3.1.1 doesn't tell subscribers who
+// published, so broker username mapping has to be enforced by the broker's ACLs
+// (e.g., mosquitto's "pattern write sensor/%u/#"); tokens work on any broker.
+
+// DeviceTokenConfig is the registry config key holding a device's token
+const DeviceTokenConfig = "device_token"
+
+var (
+	// ErrMissingToken is returned for a message without a token from a device that needs one
+	ErrMissingToken = errors.New("device token missing")
+
+	// ErrInvalidToken is returned for a message whose token isn't the device's
+	ErrInvalidToken = errors.New("device token invalid")
+
+	// ErrTokenUnavailable is returned when the registry can't be read and the
+	// device's token has never been looked up
+	ErrTokenUnavailable = errors.New("device token unavailable")
+)
+
+// tokenRetryInterval spaces registry lookups for a device whose last one failed,
+// so an outage isn't queried on every message
+const tokenRetryInterval = 10 * time.Second
+
+// authenticatedStreams are the streams devices publish themselves, with whether
+// their payloads are binary. LoRaWAN uplinks are authenticated by the network
+// server; window control and model responses come from the ML services.
+var authenticatedStreams = map[string]bool{
+	StreamSafety:      false,
+	StreamTemperature: false,
+	StreamHumidity:    false,
+	StreamAudio:       false,
+	StreamAudioChunk:  false,
+	StreamFrame:       true,
+	StreamBackfill:    false,
+	StreamBoot:        false,
+	StreamMotion:      false,
+	StreamCO2:         false,
+	StreamWindowAck:   false,
+}
+
+// tokenEnvelope is the wire form of an authenticated payload
+type tokenEnvelope struct {
+	DeviceToken *string         `json:"device_token"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+// AuthConfig holds configuration for device identity verification
+type AuthConfig struct {
+	Required bool          // Reject messages from devices without a token in the registry
+	CacheTTL time.Duration // How long registry tokens are cached
+}
+
+// DefaultAuthConfig returns default configuration
+func DefaultAuthConfig() AuthConfig {
+	return AuthConfig{
+		Required: false,
+		CacheTTL: 5 * time.Minute,
+	}
+}
+
+// cachedToken is the digest of a device's token (nil without one) and when it was
+// looked up (zero if it never was), and when to try again after a failed lookup
+type cachedToken struct {
+	digest  []byte
+	fetched time.Time
+	retryAt time.Time
+}
+
+// DeviceAuthenticator verifies that messages on a device's topics come from the
+// device, by the token in their payload. Devices with a token must send it; others
+// may send bare payloads unless Required is set. While the registry can't be read,
+// the last token looked up for a device still applies; for devices never looked up,
+// messages are let through unverified unless Required is set. Safe for concurrent use.
+type DeviceAuthenticator struct {
+	devices  DeviceLookup
+	required bool
+	ttl      time.Duration
+
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+}
+
+// NewDeviceAuthenticator creates an authenticator reading tokens from the registry
+func NewDeviceAuthenticator(devices DeviceLookup, config AuthConfig) *DeviceAuthenticator {
+	return &DeviceAuthenticator{
+		devices:  devices,
+		required: config.Required,
+		ttl:      config.CacheTTL,
+		tokens:   make(map[string]cachedToken),
+	}
+}
+
+// digest returns the SHA-256 of the device's token, or nil if it has none. Tokens
+// are compared by digest so the comparison takes the same time whatever their length.
+func (a *DeviceAuthenticator) digest(deviceID string) ([]byte, error) {
+	now := time.Now()
+	a.mu.Lock()
+	cached, ok := a.tokens[deviceID]
+	a.mu.Unlock()
+	known := ok && !cached.fetched.IsZero()
+	switch {
+	case known && now.Sub(cached.fetched) < a.ttl:
+		return cached.digest, nil
+	case ok && now.Before(cached.retryAt):
+		if known {
+			return cached.digest, nil
+		}
+		return nil, ErrTokenUnavailable
+	}
+
+	device, err := a.devices.GetDevice(deviceID)
+	if err != nil {
+		metrics.Default.Counter("mqtt_auth_lookup_errors").Inc()
+		cached.retryAt = now.Add(tokenRetryInterval)
+		a.mu.Lock()
+		a.tokens[deviceID] = cached
+		a.mu.Unlock()
+		if known {
+			log.Printf("Warning: Could not look up the token of %s, using the last known one: %v", deviceID, err)
+			return cached.digest, nil
+		}
+		return nil, fmt.Errorf("%w: %v", ErrTokenUnavailable, err)
+	}
+	var digest []byte
+	if device != nil && device.Config[DeviceTokenConfig] != nil {
+		token, ok := device.Config[DeviceTokenConfig].(string)
+		if !ok || token == "" {
+			return nil, fmt.Errorf("device %s: %s must be a non-empty string", deviceID, DeviceTokenConfig)
+		}
+		sum := sha256.Sum256([]byte(token))
+		digest = sum[:]
+	}
+
+	a.mu.Lock()
+	a.tokens[deviceID] = cachedToken{digest: digest, fetched: time.Now()}
+	a.mu.Unlock()
+	return digest, nil
+}
+
+// isTokenEnvelope reports whether a payload looks like an authenticated envelope
+func isTokenEnvelope(payload []byte) bool {
+	payload = bytes.TrimSpace(payload)
+	return len(payload) > 0 && payload[0] == '{' && bytes.Contains(payload, []byte(`"device_token"`))
+}
+
+// Verify checks a message from a device and returns the payload inside its
+// envelope. Bare payloads are passed through unless the device has a token (or
+// tokens are required). binary decodes a string payload from base64. When the
+// device's token is unavailable, the error wraps ErrTokenUnavailable if tokens
+// are required; otherwise the payload is passed through unverified.
+func (a *DeviceAuthenticator) Verify(deviceID string, payload []byte, binary bool) ([]byte, error) {
+	digest, err := a.digest(deviceID)
+	if err != nil {
+		if a.required {
+			return nil, err
+		}
+		metrics.Default.Counter("mqtt_auth_unverified").Inc()
+		return Unverified(payload, binary)
+	}
+
+	if !isTokenEnvelope(payload) {
+		if digest != nil || a.required {
+			return nil, ErrMissingToken
+		}
+		return payload, nil
+	}
+
+	var env tokenEnvelope
+	if err := json.Unmarshal(payload, &env); err != nil || env.DeviceToken == nil {
+		if digest == nil && !a.required {
+			// Not an envelope after all, e.g. a JSON payload mentioning the key
+			return payload, nil
+		}
+		return nil, ErrMissingToken
+	}
+	if err := checkToken(deviceID, digest, *env.DeviceToken); err != nil {
+		return nil, err
+	}
+
+	return envelopePayload(env, binary)
+}
+
+// Authenticate checks a token sent beside a message rather than in an envelope
+// (e.g., with an HTTP reading) and reports whether it proved the sender is the
+// device. An empty token is let through unproven from a device without one unless
+// tokens are required, and so is any token while the device's is unavailable.
+func (a *DeviceAuthenticator) Authenticate(deviceID, token string) (bool, error) {
+	digest, err := a.digest(deviceID)
+	if err != nil {
+		if a.required {
+			return false, err
+		}
+		metrics.Default.Counter("mqtt_auth_unverified").Inc()
+		return false, nil
+	}
+
+	if token == "" {
+		if digest != nil || a.required {
+			return false, ErrMissingToken
+		}
+		return false, nil
+	}
+	if err := checkToken(deviceID, digest, token); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkToken compares a token with the digest of the device's token
+func checkToken(deviceID string, digest []byte, token string) error {
+	if digest == nil {
+		return fmt.Errorf("%w: no %s provisioned for device %s", ErrInvalidToken, DeviceTokenConfig, deviceID)
+	}
+	sum := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare(sum[:], digest) != 1 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// Unverified returns the payload of a message without checking its token: the
+// payload inside an envelope, or a bare payload as it is
+func Unverified(payload []byte, binary bool) ([]byte, error) {
+	if !isTokenEnvelope(payload) {
+		return payload, nil
+	}
+	var env tokenEnvelope
+	if err := json.Unmarshal(payload, &env); err != nil || env.DeviceToken == nil {
+		return payload, nil
+	}
+	return envelopePayload(env, binary)
+}
+
+// envelopePayload decodes the payload of an envelope
+func envelopePayload(env tokenEnvelope, binary bool) ([]byte, error) {
+	if len(env.Payload) == 0 {
+		return nil, fmt.Errorf("authenticated envelope has no payload")
+	}
+	var text string
+	if err := json.Unmarshal(env.Payload, &text); err != nil {
+		return env.Payload, nil // Not a string: the JSON value itself
+	}
+	if !binary {
+		return []byte(text), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 payload in authenticated envelope: %w", err)
+	}
+	return data, nil
+}
@@ -3,9 +3,12 @@ package mqtt
 import (
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"iot-backend/internal/errs"
+	"iot-backend/internal/metrics"
 )
 
 // Client manages the MQTT connection (low-level connection management only)
@@ -13,45 +16,142 @@ import (
 type Client struct {
 	client mqtt.Client
 	config ClientConfig
+
+	mu        sync.Mutex
+	onConnect []func()                 // Run after every (re)connect
+	onLost    []func(time.Time, error) // Run when the connection drops
+	closed    chan struct{}
 }
 
 // ClientConfig holds MQTT client configuration
 type ClientConfig struct {
-	Broker   string
+	Broker   string // e.g. tcp://host:1883, or tls://host:8883 for TLS
 	ClientID string
 	Username string
 	Password string
+
+	// Certificates of a tls:// broker: the CA verifying it and, for mutual TLS, the client's
+	TLS TLSConfig
+
+	// Optional namespace prepended to every topic (e.g., "site-A/"), so several
+	// deployments can share a broker; incoming topics have it removed again
+	TopicPrefix string
+
+	// Optional last will: the broker publishes Will (retained) to WillTopic if the backend disappears
+	WillTopic string
+	Will      []byte
+
+	// Reconnect backoff after losing the broker (zero = DefaultBackoffConfig)
+	Backoff BackoffConfig
+
+	// Observer instances subscribe as usual but every publish is discarded
+	ReadOnly bool
 }
 
 // NewClient creates a new MQTT client connection
 func NewClient(config ClientConfig) (*Client, error) {
+	tlsConfig, err := BuildTLSConfig(config.Broker, config.TLS)
+	if err != nil {
+		return nil, err
+	}
+
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(config.Broker)
 	opts.SetClientID(config.ClientID)
 	opts.SetUsername(config.Username)
 	opts.SetPassword(config.Password)
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
 	opts.SetDefaultPublishHandler(messagePubHandler)
-	opts.SetOnConnectHandler(connectHandler)
-	opts.SetConnectionLostHandler(connectLostHandler)
-	opts.SetAutoReconnect(true)
+	// Each message gets its own handler goroutine, so a safety event never waits
+	// behind readings blocked on a full queue. Audio chunks are reassembled by
+	// index, so they may be handled in any order.
+	opts.SetOrderMatters(false)
+	if config.Backoff == (BackoffConfig{}) {
+		config.Backoff = DefaultBackoffConfig()
+	}
+	c := &Client{config: config, closed: make(chan struct{})}
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		connectHandler(client)
+		metrics.Default.Gauge("mqtt_connected").Set(1)
+		c.runConnectHooks()
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		connectLostHandler(client, err)
+		metrics.Default.Counter("mqtt_connection_lost").Inc()
+		metrics.Default.Gauge("mqtt_connected").Set(0)
+		c.runLostHooks(time.Now(), err)
+		go c.reconnect()
+	})
+	opts.SetAutoReconnect(false) // See reconnect
 	opts.SetKeepAlive(60 * time.Second)
 	opts.SetPingTimeout(10 * time.Second)
+	config.TopicPrefix = NormalizePrefix(config.TopicPrefix)
+	if config.WillTopic != "" {
+		opts.SetBinaryWill(PrefixTopic(config.TopicPrefix, config.WillTopic), config.Will, 1, true)
+	}
 
-	client := mqtt.NewClient(opts)
+	client := withReadOnly(withPrefix(withInflight(mqtt.NewClient(opts)), config.TopicPrefix), config.ReadOnly)
+	c.client = client
 
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	if err := waitToken("connect", config.Broker, client.Connect()); err != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", err)
 	}
 
 	log.Println("MQTT Client: Connected to broker:", config.Broker)
+	if config.TLS.CertFile != "" {
+		log.Printf("MQTT Client: Authenticated with client certificate %s", config.TLS.CertFile)
+	}
+	if config.TopicPrefix != "" {
+		log.Printf("MQTT Client: All topics are namespaced under %q", config.TopicPrefix)
+	}
+	if config.ReadOnly {
+		log.Println("MQTT Client: Read-only, publishes are discarded")
+	}
+
+	return c, nil
+}
 
-	return &Client{
-		client: client,
-		config: config,
-	}, nil
+// OnConnect registers a function run after every reconnect, e.g. to re-assert
+// retained state that the broker replaced with the last will
+func (c *Client) OnConnect(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onConnect = append(c.onConnect, fn)
+}
+
+// runConnectHooks runs OnConnect functions without blocking the paho callback
+func (c *Client) runConnectHooks() {
+	c.mu.Lock()
+	hooks := append([]func(){}, c.onConnect...)
+	c.mu.Unlock()
+
+	for _, fn := range hooks {
+		go fn()
+	}
+}
+
+// OnConnectionLost registers a function run when the connection drops, before
+// reconnecting starts. It runs on paho's callback and must not block.
+func (c *Client) OnConnectionLost(fn func(at time.Time, err error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onLost = append(c.onLost, fn)
+}
+
+// runLostHooks runs OnConnectionLost functions in registration order
+func (c *Client) runLostHooks(at time.Time, err error) {
+	c.mu.Lock()
+	hooks := append([]func(time.Time, error){}, c.onLost...)
+	c.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn(at, err)
+	}
 }
 
-// GetNativeClient returns the underlying paho MQTT client
+// GetNativeClient returns the underlying paho MQTT client (namespaced when a topic prefix is set)
 // This is used by Subscriber and Publisher
 func (c *Client) GetNativeClient() mqtt.Client {
 	return c.client
@@ -64,7 +164,9 @@ func (c *Client) IsConnected() bool {
 
 // Close closes the MQTT client connection
 func (c *Client) Close() {
+	close(c.closed)
 	c.client.Disconnect(250)
+	metrics.Default.Gauge("mqtt_connected").Set(0)
 	log.Println("MQTT Client: Disconnected")
 }
 
@@ -78,5 +180,5 @@ var connectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
 }
 
 var connectLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Client, err error) {
-	log.Printf("MQTT: Connection lost: %v", err)
+	log.Printf("MQTT: Connection lost: %v", errs.Wrap(errs.ErrBrokerDisconnected, err))
 }
@@ -0,0 +1,274 @@
+package mqtt
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+)
+
+// windowCommandQoS is the QoS of window commands: exactly once between the backend,
+// the broker, and the actuator, so a command is neither lost nor applied twice
+const windowCommandQoS = 2
+
+// CommandOutcomeSink stores how window commands ended
+type CommandOutcomeSink interface {
+	SaveWindowCommandOutcome(outcome *models.WindowCommandOutcome) error
+}
+
+// CommandTrackerConfig holds configuration for window command acknowledgement tracking
+type CommandTrackerConfig struct {
+	AckTimeout        time.Duration // Unacknowledged commands are resent after this
+	MaxAttempts       int           // Publishes per command before it's recorded as expired
+	PositionTolerance float64       // Reported positions this far from the commanded one are mismatches
+	SeenTTL           time.Duration // How long answered command IDs are remembered, so repeated acks are ignored
+}
+
+// DefaultCommandTrackerConfig returns default configuration
+func DefaultCommandTrackerConfig() CommandTrackerConfig {
+	return CommandTrackerConfig{
+		AckTimeout:        30 * time.Second,
+		MaxAttempts:       3,
+		PositionTolerance: 2.0,
+		SeenTTL:           time.Hour,
+	}
+}
+
+// pendingCommand is a published window command waiting for its ack
+type pendingCommand struct {
+	cmd      models.WindowCommand
+	firstAt  time.Time
+	sentAt   time.Time
+	attempts int
+}
+
+// CommandTracker follows window commands until their actuator acknowledges them.
+// QoS 2 only ends at the broker, so commands without an ack are resent with the
+// same command ID (actuators apply an ID once) and recorded as expired after the
+// last attempt, except critical (safety and manual) commands, which are resent
+// until acknowledged. Acks are idempotent: repeats and acks of commands superseded by a
+// newer one for the device are counted and ignored. Every applied ack is reconciled
+// against the commanded position.
+type CommandTracker struct {
+	config CommandTrackerConfig
+	sink   CommandOutcomeSink // Optional
+	resend func(cmd *models.WindowCommand) error
+
+	mu      sync.Mutex
+	pending map[string]*pendingCommand // command_id -> command
+	latest  map[string]string          // device -> command_id of its last command
+	seen    map[string]time.Time       // Answered or superseded command_id -> when
+}
+
+// NewCommandTracker creates a tracker; sink may be nil to only log and count outcomes.
+// Give it to the publisher (PublisherConfig.Commands), which resends through it.
+func NewCommandTracker(config CommandTrackerConfig, sink CommandOutcomeSink) *CommandTracker {
+	return &CommandTracker{
+		config:  config,
+		sink:    sink,
+		pending: make(map[string]*pendingCommand),
+		latest:  make(map[string]string),
+		seen:    make(map[string]time.Time),
+	}
+}
+
+// Track registers a command about to be published. A command still pending for
+// the same device is superseded: it won't be resent, and its late ack is ignored.
+func (t *CommandTracker) Track(cmd *models.WindowCommand) {
+	now := time.Now()
+
+	t.mu.Lock()
+	if previous, ok := t.latest[cmd.DeviceID]; ok {
+		if _, pending := t.pending[previous]; pending {
+			delete(t.pending, previous)
+			t.seen[previous] = now
+			metrics.Default.Counter("window_commands_superseded").Inc()
+		}
+	}
+	t.pending[cmd.CommandID] = &pendingCommand{cmd: *cmd, firstAt: now, sentAt: now, attempts: 1}
+	t.latest[cmd.DeviceID] = cmd.CommandID
+	count := len(t.pending)
+	t.mu.Unlock()
+
+	metrics.Default.Gauge("window_commands_pending").Set(int64(count))
+}
+
+// Forget drops a command that was never actually sent (e.g., publish failed)
+func (t *CommandTracker) Forget(commandID string) {
+	t.mu.Lock()
+	delete(t.pending, commandID)
+	count := len(t.pending)
+	t.mu.Unlock()
+
+	metrics.Default.Gauge("window_commands_pending").Set(int64(count))
+}
+
+// Ack resolves the command an actuator acknowledged and reconciles the position it
+// reports with the commanded one. It returns the outcome, or nil for an ack that
+// was ignored (repeated, superseded, unknown, or from another device).
+func (t *CommandTracker) Ack(ack *models.WindowCommandAck) *models.WindowCommandOutcome {
+	now := time.Now()
+
+	t.mu.Lock()
+	pending, ok := t.pending[ack.CommandID]
+	_, seen := t.seen[ack.CommandID]
+	// Only the commanded device can resolve a command; another device's ack leaves it pending
+	foreign := ok && pending.cmd.DeviceID != ack.DeviceID
+	if ok && !foreign {
+		delete(t.pending, ack.CommandID)
+		t.seen[ack.CommandID] = now
+	}
+	count := len(t.pending)
+	t.mu.Unlock()
+
+	metrics.Default.Gauge("window_commands_pending").Set(int64(count))
+
+	if foreign {
+		metrics.Default.Counter("window_acks_foreign").Inc()
+		log.Printf("Warning: Ignoring ack from %s for window command %s sent to %s",
+			ack.DeviceID, ack.CommandID, pending.cmd.DeviceID)
+		return nil
+	}
+	if !ok {
+		if seen {
+			metrics.Default.Counter("window_acks_duplicate").Inc()
+		} else {
+			metrics.Default.Counter("window_acks_unmatched").Inc()
+			log.Printf("Warning: Ack from %s for unknown window command %s", ack.DeviceID, ack.CommandID)
+		}
+		return nil
+	}
+
+	outcome := &models.WindowCommandOutcome{
+		Timestamp: pending.firstAt,
+		DeviceID:  pending.cmd.DeviceID,
+		CommandID: ack.CommandID,
+		Commanded: pending.cmd.Position,
+		Reported:  ack.Position,
+		Attempts:  pending.attempts,
+		LatencyMs: now.Sub(pending.firstAt).Milliseconds(),
+		Error:     ack.Error,
+	}
+	switch {
+	case ack.Status == models.AckRejected:
+		outcome.Outcome = models.CommandRejected
+		metrics.Default.Counter("window_commands_rejected").Inc()
+		log.Printf("Warning: %s rejected window command %s (%.2f%%): %s",
+			outcome.DeviceID, outcome.CommandID, outcome.Commanded, ack.Error)
+	case ack.Position != nil && math.Abs(*ack.Position-pending.cmd.Position) > t.config.PositionTolerance:
+		outcome.Outcome = models.CommandMismatch
+		metrics.Default.Counter("window_position_mismatches").Inc()
+		log.Printf("Warning: %s reports %.2f%% after window command %s to %.2f%%",
+			outcome.DeviceID, *ack.Position, outcome.CommandID, outcome.Commanded)
+	default:
+		outcome.Outcome = models.CommandAcked
+		metrics.Default.Counter("window_commands_acked").Inc()
+	}
+	metrics.Default.Timer("window_command_ack_latency").Observe(now.Sub(pending.firstAt))
+
+	t.save(outcome)
+	return outcome
+}
+
+// Start resends unacknowledged commands and expires them until context is cancelled
+func (t *CommandTracker) Start(ctx context.Context) {
+	if t.config.AckTimeout <= 0 {
+		log.Println("CommandTracker: Ack timeout disabled, not resending window commands")
+		return
+	}
+
+	interval := t.config.AckTimeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("CommandTracker: Resending window commands unacknowledged after %v (at most %d attempts)",
+		t.config.AckTimeout, t.config.MaxAttempts)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.sweep(now)
+		}
+	}
+}
+
+// sweep resends or expires every command older than the ack timeout (resending
+// none past its own expiry), and forgets answered command IDs older than SeenTTL
+func (t *CommandTracker) sweep(now time.Time) {
+	var resend []models.WindowCommand
+	var expired []*models.WindowCommandOutcome
+
+	t.mu.Lock()
+	for id, p := range t.pending {
+		if now.Sub(p.sentAt) <= t.config.AckTimeout {
+			continue
+		}
+		// The actuator would reject a resent command past its expiry; critical
+		// commands carry none and are resent until acknowledged or superseded
+		if (p.attempts < t.config.MaxAttempts || p.cmd.Critical) && t.resend != nil && !p.cmd.Expired(now) {
+			p.attempts++
+			p.sentAt = now
+			resend = append(resend, p.cmd)
+			continue
+		}
+		delete(t.pending, id)
+		t.seen[id] = now
+		outcome := &models.WindowCommandOutcome{
+			Timestamp: p.firstAt,
+			DeviceID:  p.cmd.DeviceID,
+			CommandID: id,
+			Commanded: p.cmd.Position,
+			Outcome:   models.CommandExpired,
+			Attempts:  p.attempts,
+			LatencyMs: now.Sub(p.firstAt).Milliseconds(),
+		}
+		if p.cmd.Expired(now) {
+			outcome.Error = "past the command's expiry"
+		}
+		expired = append(expired, outcome)
+	}
+	for id, at := range t.seen {
+		if now.Sub(at) > t.config.SeenTTL {
+			delete(t.seen, id)
+		}
+	}
+	count := len(t.pending)
+	t.mu.Unlock()
+
+	metrics.Default.Gauge("window_commands_pending").Set(int64(count))
+
+	for i := range resend {
+		cmd := &resend[i]
+		metrics.Default.Counter("window_commands_resent").Inc()
+		log.Printf("Resending unacknowledged window command %s for %s", cmd.CommandID, cmd.DeviceID)
+		if err := t.resend(cmd); err != nil {
+			log.Printf("Error resending window command %s: %v", cmd.CommandID, err)
+		}
+	}
+
+	for _, outcome := range expired {
+		metrics.Default.Counter("window_commands_expired").Inc()
+		log.Printf("Warning: Window command %s for %s (%.2f%%) unacknowledged after %d attempts",
+			outcome.CommandID, outcome.DeviceID, outcome.Commanded, outcome.Attempts)
+		t.save(outcome)
+	}
+}
+
+// save stores an outcome, if a sink is configured
+func (t *CommandTracker) save(outcome *models.WindowCommandOutcome) {
+	if t.sink == nil {
+		return
+	}
+	if err := t.sink.SaveWindowCommandOutcome(outcome); err != nil {
+		log.Printf("Error saving window command outcome: %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+package mqtt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+)
+
+// Encrypted payloads are JSON envelopes:
+//
+//	{"alg": "A256GCM", "nonce": "<base64, 12 bytes>", "ciphertext": "<base64, data + 16-byte tag>"}
+//
+// sealed with AES-GCM under the device's key (16, 24, or 32 bytes) with the
+// device ID as additional data, so a payload replayed under another device's
+// topic fails to open. Keys live in the device registry as a base64 string at
+// config["payload_key"].
+
+// PayloadKeyConfig is the registry config key holding a device's payload key
+const PayloadKeyConfig = "payload_key"
+
+var (
+	// ErrPlaintextPayload is returned for an unencrypted payload where encryption is required
+	ErrPlaintextPayload = errors.New("payload must be encrypted")
+
+	// ErrKeyUnavailable is returned when the registry can't be read and the
+	// device's key has never been looked up
+	ErrKeyUnavailable = errors.New("payload key unavailable")
+)
+
+// encryptedEnvelope is the wire form of an encrypted payload
+type encryptedEnvelope struct {
+	Alg        string `json:"alg"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// DeviceLookup reads devices from the registry
+type DeviceLookup interface {
+	GetDevice(deviceID string) (*models.Device, error)
+}
+
+// CipherConfig holds configuration for payload encryption
+type CipherConfig struct {
+	Required bool          // Reject plaintext from every device, not only those with a key
+	CacheTTL time.Duration // How long registry keys are cached
+}
+
+// DefaultCipherConfig returns default configuration
+func DefaultCipherConfig() CipherConfig {
+	return CipherConfig{
+		Required: false,
+		CacheTTL: 5 * time.Minute,
+	}
+}
+
+// deviceKey is a device's AES-GCM instance
+type deviceKey struct {
+	aead cipher.AEAD
+	bits int // AES key size, for the envelope's alg field
+}
+
+// cachedKey is a device's key (nil without one) and when it was looked up (zero if
+// it never was), whether it is being refreshed, and when to try again after a
+// failed lookup
+type cachedKey struct {
+	key        *deviceKey
+	fetched    time.Time
+	retryAt    time.Time
+	refreshing bool
+}
+
+// PayloadCipher encrypts and decrypts payloads with per-device keys. Devices
+// with a key must encrypt; others may send plaintext unless Required is set.
+// An expired key keeps being used while it is looked up again in the background,
+// and while the registry can't be read; lookups of a device that failed are
+// retried after tokenRetryInterval. Safe for concurrent use.
+type PayloadCipher struct {
+	devices  DeviceLookup
+	required bool
+	ttl      time.Duration
+
+	mu   sync.Mutex
+	keys map[string]cachedKey
+}
+
+// NewPayloadCipher creates a cipher reading keys from the registry
+func NewPayloadCipher(devices DeviceLookup, config CipherConfig) *PayloadCipher {
+	return &PayloadCipher{
+		devices:  devices,
+		required: config.Required,
+		ttl:      config.CacheTTL,
+		keys:     make(map[string]cachedKey),
+	}
+}
+
+// key returns the device's key, or nil if it has none. Only a device whose key was
+// never looked up waits for the registry.
+func (c *PayloadCipher) key(deviceID string) (*deviceKey, error) {
+	now := time.Now()
+	c.mu.Lock()
+	cached, ok := c.keys[deviceID]
+	known := ok && !cached.fetched.IsZero()
+	switch {
+	case known && now.Sub(cached.fetched) < c.ttl:
+		c.mu.Unlock()
+		return cached.key, nil
+	case known:
+		if !cached.refreshing && !now.Before(cached.retryAt) {
+			cached.refreshing = true
+			c.keys[deviceID] = cached
+			go c.refresh(deviceID)
+		}
+		c.mu.Unlock()
+		return cached.key, nil
+	case ok && now.Before(cached.retryAt):
+		c.mu.Unlock()
+		return nil, ErrKeyUnavailable
+	}
+	c.mu.Unlock()
+	return c.lookup(deviceID)
+}
+
+// refresh looks up an expired key off the message delivery path
+func (c *PayloadCipher) refresh(deviceID string) {
+	if _, err := c.lookup(deviceID); err != nil {
+		log.Printf("Warning: Could not refresh the payload key of %s, using the last known one: %v", deviceID, err)
+	}
+}
+
+// lookup reads a device's key from the registry and caches it. A failed lookup
+// keeps the last known key and is retried after tokenRetryInterval.
+func (c *PayloadCipher) lookup(deviceID string) (*deviceKey, error) {
+	device, err := c.devices.GetDevice(deviceID)
+	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrKeyUnavailable, err)
+	}
+	var key *deviceKey
+	if err == nil && device != nil {
+		if key, err = parsePayloadKey(device.Config[PayloadKeyConfig]); err != nil {
+			err = fmt.Errorf("device %s: %w", deviceID, err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		metrics.Default.Counter("mqtt_payload_key_lookup_errors").Inc()
+		cached := c.keys[deviceID]
+		cached.refreshing = false
+		cached.retryAt = time.Now().Add(tokenRetryInterval)
+		c.keys[deviceID] = cached
+		return nil, err
+	}
+	c.keys[deviceID] = cachedKey{key: key, fetched: time.Now()}
+	return key, nil
+}
+
+// parsePayloadKey builds an AES-GCM key from a base64 registry value (nil for no key)
+func parsePayloadKey(value interface{}) (*deviceKey, error) {
+	if value == nil {
+		return nil, nil
+	}
+	encoded, ok := value.(string)
+	if !ok || encoded == "" {
+		return nil, fmt.Errorf("%s must be a base64 string", PayloadKeyConfig)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", PayloadKeyConfig, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", PayloadKeyConfig, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &deviceKey{aead: aead, bits: len(key) * 8}, nil
+}
+
+// isEnvelope reports whether a payload looks like an encrypted envelope
+func isEnvelope(payload []byte) bool {
+	payload = bytes.TrimSpace(payload)
+	return len(payload) > 0 && payload[0] == '{' && bytes.Contains(payload, []byte(`"ciphertext"`))
+}
+
+// Open returns the plaintext of a payload from a device. Plaintext payloads are
+// passed through unless the device has a key (or encryption is required).
+func (c *PayloadCipher) Open(deviceID string, payload []byte) ([]byte, error) {
+	key, err := c.key(deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isEnvelope(payload) {
+		if key != nil || c.required {
+			return nil, ErrPlaintextPayload
+		}
+		return payload, nil
+	}
+	if key == nil {
+		return nil, fmt.Errorf("encrypted payload but no %s for device %s", PayloadKeyConfig, deviceID)
+	}
+
+	var env encryptedEnvelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return nil, fmt.Errorf("invalid encrypted envelope: %w", err)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(env.Nonce)
+	if err != nil || len(nonce) != key.aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce in encrypted envelope")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(env.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext in encrypted envelope: %w", err)
+	}
+
+	plaintext, err := key.aead.Open(nil, nonce, ciphertext, []byte(deviceID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt payload: %w", err)
+	}
+	return plaintext, nil
+}
+
+// Seal encrypts a payload for a device that has a key; others receive it unchanged
+func (c *PayloadCipher) Seal(deviceID string, plaintext []byte) ([]byte, error) {
+	key, err := c.key(deviceID)
+	if err != nil || key == nil {
+		return plaintext, err
+	}
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	env := encryptedEnvelope{
+		Alg:        fmt.Sprintf("A%dGCM", key.bits),
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(key.aead.Seal(nil, nonce, plaintext, []byte(deviceID))),
+	}
+	return json.Marshal(env)
+}
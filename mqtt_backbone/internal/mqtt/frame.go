@@ -0,0 +1,124 @@
+package mqtt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FrameField describes one field of a packed binary sensor frame
+type FrameField struct {
+	Name  string  // Metric name ("temperature", "humidity") or "_" for padding
+	Type  string  // int8, uint8, int16, uint16, int32, uint32, float32
+	Scale float64 // Decoded value = raw * Scale
+}
+
+// FrameLayout describes a device-defined packed struct, decoded field by field in order
+type FrameLayout struct {
+	Fields    []FrameField
+	ByteOrder binary.ByteOrder
+}
+
+// fieldSizes maps supported field types to their size in bytes
+var fieldSizes = map[string]int{
+	"int8": 1, "uint8": 1,
+	"int16": 2, "uint16": 2,
+	"int32": 4, "uint32": 4,
+	"float32": 4,
+}
+
+// ParseFrameLayout parses a layout descriptor such as
+// "temperature:int16:0.01,humidity:uint16:0.01". The scale is optional (default 1).
+// byteOrder is "little" (ESP32 native) or "big".
+func ParseFrameLayout(spec, byteOrder string) (*FrameLayout, error) {
+	layout := &FrameLayout{ByteOrder: binary.LittleEndian}
+	switch strings.ToLower(byteOrder) {
+	case "", "little":
+	case "big":
+		layout.ByteOrder = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("unsupported byte order %q", byteOrder)
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		pieces := strings.Split(part, ":")
+		if len(pieces) < 2 || len(pieces) > 3 {
+			return nil, fmt.Errorf("invalid frame field %q, expected name:type[:scale]", part)
+		}
+
+		field := FrameField{Name: pieces[0], Type: pieces[1], Scale: 1.0}
+		if _, ok := fieldSizes[field.Type]; !ok {
+			return nil, fmt.Errorf("unsupported frame field type %q", field.Type)
+		}
+		if len(pieces) == 3 {
+			scale, err := strconv.ParseFloat(pieces[2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid scale for frame field %q: %w", field.Name, err)
+			}
+			field.Scale = scale
+		}
+
+		layout.Fields = append(layout.Fields, field)
+	}
+
+	if len(layout.Fields) == 0 {
+		return nil, fmt.Errorf("frame layout has no fields")
+	}
+	return layout, nil
+}
+
+// Size returns the frame length in bytes
+func (l *FrameLayout) Size() int {
+	size := 0
+	for _, f := range l.Fields {
+		size += fieldSizes[f.Type]
+	}
+	return size
+}
+
+// Decode unpacks a frame into named, scaled values. Padding fields are skipped.
+func (l *FrameLayout) Decode(payload []byte) (map[string]float64, error) {
+	if len(payload) != l.Size() {
+		return nil, fmt.Errorf("frame length %d does not match layout size %d", len(payload), l.Size())
+	}
+
+	values := make(map[string]float64, len(l.Fields))
+	offset := 0
+	for _, f := range l.Fields {
+		size := fieldSizes[f.Type]
+		raw := payload[offset : offset+size]
+		offset += size
+
+		if f.Name == "_" {
+			continue
+		}
+
+		var v float64
+		switch f.Type {
+		case "int8":
+			v = float64(int8(raw[0]))
+		case "uint8":
+			v = float64(raw[0])
+		case "int16":
+			v = float64(int16(l.ByteOrder.Uint16(raw)))
+		case "uint16":
+			v = float64(l.ByteOrder.Uint16(raw))
+		case "int32":
+			v = float64(int32(l.ByteOrder.Uint32(raw)))
+		case "uint32":
+			v = float64(l.ByteOrder.Uint32(raw))
+		case "float32":
+			v = float64(math.Float32frombits(l.ByteOrder.Uint32(raw)))
+		}
+		values[f.Name] = v * f.Scale
+	}
+
+	return values, nil
+}
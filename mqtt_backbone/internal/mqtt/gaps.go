@@ -0,0 +1,125 @@
+package mqtt
+
+import (
+	"log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+)
+
+// Subscription streams name what each subscription carries in connection gaps
+const (
+	StreamSafety        = "safety"
+	StreamTemperature   = "temperature"
+	StreamHumidity      = "humidity"
+	StreamAudio         = "audio"
+	StreamAudioChunk    = "audio_chunk" // Clips uploaded in resumable chunks
+	StreamFrame         = "frame"       // Packed binary readings (temperature, humidity, presence)
+	StreamBackfill      = "backfill"    // Readings buffered while offline, with their own timestamps
+	StreamLoRaWAN       = "lorawan"
+	StreamBoot          = "boot"
+	StreamMotion        = "motion"
+	StreamCO2           = "co2"
+	StreamWindowControl = "window_control"
+	StreamWindowAck     = "window_ack"
+)
+
+// modelStream names the response subscription of an additional ML model
+func modelStream(model MLModel) string {
+	return "model:" + model.Name
+}
+
+// domainStream names the response subscription of an inference domain's model
+func domainStream(domain MLModel) string {
+	return "domain:" + domain.Name
+}
+
+// ConnectionGapSink stores connection gaps
+type ConnectionGapSink interface {
+	SaveConnectionGaps(gaps []*models.ConnectionGap) error
+}
+
+// activeSubscription is a subscription restored after every reconnect
+type activeSubscription struct {
+	stream  string
+	filter  string
+	handler mqtt.MessageHandler
+}
+
+// ConnectionLost opens a gap on every subscription. Register it with
+// Client.OnConnectionLost; the gaps are stored without blocking the callback.
+func (s *Subscriber) ConnectionLost(at time.Time, err error) {
+	reason := ""
+	if err != nil {
+		reason = err.Error()
+	}
+
+	s.subsMu.Lock()
+	if s.openGap == nil {
+		s.openGap = make(map[string]*models.ConnectionGap)
+	}
+	var opened []*models.ConnectionGap
+	for _, sub := range s.subs {
+		if _, ok := s.openGap[sub.filter]; ok {
+			continue // Still open since an earlier drop it wasn't restored from
+		}
+		gap := &models.ConnectionGap{Stream: sub.stream, Topic: sub.filter, Start: at, Reason: reason}
+		s.openGap[sub.filter] = gap
+		opened = append(opened, gap)
+	}
+	s.subsMu.Unlock()
+
+	s.saveGaps(opened)
+}
+
+// Resubscribe restores every subscription after a reconnect (with a clean session the
+// broker forgets them) and closes the gaps of the restored ones. A subscription that
+// fails keeps its gap open until a later reconnect restores it. Register it with
+// Client.OnConnect.
+func (s *Subscriber) Resubscribe() {
+	s.subsMu.Lock()
+	subs := append([]activeSubscription{}, s.subs...)
+	s.subsMu.Unlock()
+
+	var closed []*models.ConnectionGap
+	restored := 0
+	for _, sub := range subs {
+		if err := waitToken("subscribe", sub.filter, s.client.Subscribe(sub.filter, 1, sub.handler)); err != nil {
+			log.Printf("Error resubscribing to %s: %v", sub.filter, err)
+			metrics.Default.Counter("mqtt_resubscribe_failures").Inc()
+			continue
+		}
+		restored++
+
+		s.subsMu.Lock()
+		open, ok := s.openGap[sub.filter]
+		delete(s.openGap, sub.filter)
+		s.subsMu.Unlock()
+		if ok {
+			end := time.Now()
+			gap := *open
+			gap.End = &end
+			closed = append(closed, &gap)
+		}
+	}
+	log.Printf("Resubscribed to %d of %d topics after reconnect", restored, len(subs))
+
+	for _, gap := range closed {
+		log.Printf("Connection gap on %s: %v without messages", gap.Topic, gap.End.Sub(gap.Start).Round(time.Millisecond))
+	}
+	s.saveGaps(closed)
+}
+
+// saveGaps stores gaps in the background
+func (s *Subscriber) saveGaps(gaps []*models.ConnectionGap) {
+	if s.Gaps == nil || len(gaps) == 0 {
+		return
+	}
+	go func() {
+		if err := s.Gaps.SaveConnectionGaps(gaps); err != nil {
+			log.Printf("Error saving connection gaps: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,44 @@
+package mqtt
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"iot-backend/internal/errs"
+	"iot-backend/internal/metrics"
+)
+
+// slowBrokerThreshold is the token round-trip time above which a slow-broker warning is logged
+var slowBrokerThreshold atomic.Int64
+
+func init() {
+	slowBrokerThreshold.Store(int64(500 * time.Millisecond))
+}
+
+// SetSlowBrokerThreshold sets the broker round-trip time that triggers slow-broker warnings
+func SetSlowBrokerThreshold(d time.Duration) {
+	slowBrokerThreshold.Store(int64(d))
+}
+
+// waitToken waits for a token to complete and records its broker round-trip
+// latency under "mqtt_<kind>_latency". Operation errors are counted under
+// "mqtt_<kind>_errors" and returned as ErrBrokerDisconnected.
+func waitToken(kind, topic string, token mqtt.Token) error {
+	start := time.Now()
+	token.Wait()
+	elapsed := time.Since(start)
+
+	metrics.Default.Timer("mqtt_" + kind + "_latency").Observe(elapsed)
+
+	if threshold := time.Duration(slowBrokerThreshold.Load()); threshold > 0 && elapsed > threshold {
+		log.Printf("Warning: Slow broker, %s on %s took %v (threshold %v)", kind, topic, elapsed.Round(time.Millisecond), threshold)
+	}
+
+	if err := token.Error(); err != nil {
+		metrics.Default.Counter("mqtt_" + kind + "_errors").Inc()
+		return errs.Wrap(errs.ErrBrokerDisconnected, err)
+	}
+	return nil
+}
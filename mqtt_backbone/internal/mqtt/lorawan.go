@@ -0,0 +1,173 @@
+package mqtt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LoRaWANUplink is a network-server-agnostic view of an uplink message
+type LoRaWANUplink struct {
+	DeviceID   string    // Network server device ID/name, falling back to the DevEUI
+	DevEUI     string    // Lowercase hex DevEUI
+	FPort      int       // LoRaWAN application port
+	Payload    []byte    // Raw FRMPayload
+	ReceivedAt time.Time // Network server receive time (zero if absent)
+
+	// Values already decoded by a network server payload formatter (may be nil)
+	Decoded map[string]interface{}
+}
+
+// ttnUplink is The Things Network (v3) uplink JSON
+type ttnUplink struct {
+	EndDeviceIDs struct {
+		DeviceID string `json:"device_id"`
+		DevEUI   string `json:"dev_eui"`
+	} `json:"end_device_ids"`
+	ReceivedAt    time.Time `json:"received_at"`
+	UplinkMessage *struct {
+		FPort          int                    `json:"f_port"`
+		FRMPayload     string                 `json:"frm_payload"`
+		DecodedPayload map[string]interface{} `json:"decoded_payload"`
+	} `json:"uplink_message"`
+}
+
+// chirpStackUplink is ChirpStack uplink JSON (v4 "deviceInfo" or v3 flat fields)
+type chirpStackUplink struct {
+	DeviceInfo *struct {
+		DeviceName string `json:"deviceName"`
+		DevEUI     string `json:"devEui"`
+	} `json:"deviceInfo"`
+	DeviceName string                 `json:"deviceName"` // v3
+	DevEUI     string                 `json:"devEUI"`     // v3
+	Time       time.Time              `json:"time"`
+	FPort      int                    `json:"fPort"`
+	Data       string                 `json:"data"`
+	Object     map[string]interface{} `json:"object"`
+}
+
+// ParseLoRaWANUplink parses TTN v3 or ChirpStack (v3/v4) uplink JSON
+func ParseLoRaWANUplink(payload []byte) (*LoRaWANUplink, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal uplink: %w", err)
+	}
+
+	uplink := &LoRaWANUplink{}
+	var encoded string
+
+	if _, ok := probe["end_device_ids"]; ok {
+		var msg ttnUplink
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal TTN uplink: %w", err)
+		}
+		if msg.UplinkMessage == nil {
+			return nil, fmt.Errorf("TTN message is not an uplink")
+		}
+		uplink.DeviceID = msg.EndDeviceIDs.DeviceID
+		uplink.DevEUI = msg.EndDeviceIDs.DevEUI
+		uplink.ReceivedAt = msg.ReceivedAt
+		uplink.FPort = msg.UplinkMessage.FPort
+		uplink.Decoded = msg.UplinkMessage.DecodedPayload
+		encoded = msg.UplinkMessage.FRMPayload
+	} else {
+		var msg chirpStackUplink
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal ChirpStack uplink: %w", err)
+		}
+		uplink.DeviceID, uplink.DevEUI = msg.DeviceName, msg.DevEUI
+		if msg.DeviceInfo != nil {
+			uplink.DeviceID, uplink.DevEUI = msg.DeviceInfo.DeviceName, msg.DeviceInfo.DevEUI
+		}
+		uplink.ReceivedAt = msg.Time
+		uplink.FPort = msg.FPort
+		uplink.Decoded = msg.Object
+		encoded = msg.Data
+	}
+
+	uplink.DevEUI = strings.ToLower(uplink.DevEUI)
+	if uplink.DeviceID == "" {
+		uplink.DeviceID = uplink.DevEUI
+	}
+	if uplink.DeviceID == "" {
+		return nil, fmt.Errorf("uplink has no device identifier")
+	}
+
+	if encoded != "" {
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode uplink payload: %w", err)
+		}
+		uplink.Payload = data
+	}
+
+	return uplink, nil
+}
+
+// LoRaWANCodecs maps devices to the frame layout of their uplink payload
+type LoRaWANCodecs struct {
+	devices map[string]*FrameLayout // Keyed by device ID or lowercase DevEUI
+	def     *FrameLayout            // Used when a device has no codec of its own (may be nil)
+}
+
+// ParseLoRaWANCodecs parses per-device codecs such as
+// "garden-1=temperature:int16:0.01,humidity:uint8:0.5;70b3d57ed0000001=temperature:int16:0.1".
+// defaultSpec applies to devices without an entry (empty for none).
+func ParseLoRaWANCodecs(spec, defaultSpec, byteOrder string) (*LoRaWANCodecs, error) {
+	codecs := &LoRaWANCodecs{devices: make(map[string]*FrameLayout)}
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		device, layoutSpec, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(device) == "" {
+			return nil, fmt.Errorf("invalid LoRaWAN codec %q, expected device=layout", entry)
+		}
+		layout, err := ParseFrameLayout(layoutSpec, byteOrder)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LoRaWAN codec for %s: %w", device, err)
+		}
+		codecs.devices[strings.ToLower(strings.TrimSpace(device))] = layout
+	}
+
+	if defaultSpec != "" {
+		layout, err := ParseFrameLayout(defaultSpec, byteOrder)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default LoRaWAN codec: %w", err)
+		}
+		codecs.def = layout
+	}
+
+	return codecs, nil
+}
+
+// Decode extracts metric values from an uplink. The device codec is preferred;
+// without one, values decoded by the network server's payload formatter are used.
+func (c *LoRaWANCodecs) Decode(uplink *LoRaWANUplink) (map[string]float64, error) {
+	layout := c.devices[strings.ToLower(uplink.DeviceID)]
+	if layout == nil && uplink.DevEUI != "" {
+		layout = c.devices[uplink.DevEUI]
+	}
+	if layout == nil && len(uplink.Decoded) == 0 {
+		layout = c.def
+	}
+
+	if layout != nil {
+		return layout.Decode(uplink.Payload)
+	}
+
+	values := make(map[string]float64)
+	for name, raw := range uplink.Decoded {
+		if v, ok := raw.(float64); ok {
+			values[name] = v
+		}
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no codec for device %s and no decoded payload", uplink.DeviceID)
+	}
+	return values, nil
+}
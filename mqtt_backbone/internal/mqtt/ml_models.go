@@ -0,0 +1,127 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"iot-backend/internal/models"
+)
+
+// MLModel is an additional ML service (e.g., a noise or security model) that
+// receives every inference request on its own topics with its own feature set.
+// Its responses are stored as model predictions; only the window model actuates.
+type MLModel struct {
+	Name          string   // e.g., "noise"
+	RequestTopic  string   // e.g., "ml/noise/request/{device_id}"
+	ResponseTopic string   // Subscription template, e.g., "ml/noise/response/+" or "ml/noise/response/{device_id}"
+	Features      []string // Request fields sent to the model (empty = all)
+}
+
+// requestEnvelope lists the request fields every model receives regardless of its features
+var requestEnvelope = map[string]bool{"correlation_id": true, "domain": true, "device_id": true, "timestamp": true, "model_variant": true}
+
+// requestFeatures returns the feature fields of an inference request
+func requestFeatures() map[string]bool {
+	fields := requestFields(&models.InferenceRequest{})
+	features := make(map[string]bool, len(fields))
+	for name := range fields {
+		if !requestEnvelope[name] {
+			features[name] = true
+		}
+	}
+	return features
+}
+
+// requestFields returns an inference request as its JSON fields
+func requestFields(req *models.InferenceRequest) map[string]json.RawMessage {
+	data, _ := json.Marshal(req)
+	var fields map[string]json.RawMessage
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// ParseMLModels parses model routes such as
+// "noise=ml/noise/request/{device_id}>ml/noise/response/+:sound_volume,occupancy;security=...".
+// The feature list after ":" is optional; without it the model receives every feature.
+func ParseMLModels(spec string) ([]MLModel, error) {
+	known := requestFeatures()
+	seen := make(map[string]bool)
+
+	var mlModels []MLModel
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, route, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid ML model %q, expected name=request_topic>response_topic[:features]", entry)
+		}
+		if name == "window" || seen[name] {
+			return nil, fmt.Errorf("ML model name %q is reserved or duplicated", name)
+		}
+		seen[name] = true
+
+		route, featureSpec, _ := strings.Cut(route, ":")
+		request, response, ok := strings.Cut(route, ">")
+		model := MLModel{
+			Name:          name,
+			RequestTopic:  strings.TrimSpace(request),
+			ResponseTopic: strings.TrimSpace(response),
+		}
+		if !ok || model.RequestTopic == "" || model.ResponseTopic == "" {
+			return nil, fmt.Errorf("ML model %s needs a request and response topic, request_topic>response_topic", name)
+		}
+
+		for _, feature := range strings.Split(featureSpec, ",") {
+			if feature = strings.TrimSpace(feature); feature == "" {
+				continue
+			}
+			if !known[feature] {
+				return nil, fmt.Errorf("ML model %s has unknown feature %q (want one of %s)", name, feature, strings.Join(sortedKeys(known), ", "))
+			}
+			model.Features = append(model.Features, feature)
+		}
+		mlModels = append(mlModels, model)
+	}
+	return mlModels, nil
+}
+
+// payload returns the JSON request for this model: the envelope fields, the
+// model's features, and its name
+func (m MLModel) payload(req *models.InferenceRequest) ([]byte, error) {
+	fields := requestFields(req)
+	if len(m.Features) > 0 {
+		keep := make(map[string]bool, len(m.Features))
+		for _, f := range m.Features {
+			keep[f] = true
+		}
+		for name := range fields {
+			if !requestEnvelope[name] && !keep[name] {
+				delete(fields, name)
+			}
+		}
+	}
+
+	name, _ := json.Marshal(m.Name)
+	fields["model"] = name
+
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s inference request: %w", m.Name, err)
+	}
+	return payload, nil
+}
+
+// sortedKeys returns the keys of a set in sorted order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
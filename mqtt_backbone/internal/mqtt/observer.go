@@ -0,0 +1,43 @@
+package mqtt
+
+import (
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"iot-backend/internal/metrics"
+)
+
+// readOnlyClient drops every publish, so an observer instance can subscribe next
+// to the authoritative one without sending commands, configs, or inference requests
+type readOnlyClient struct {
+	mqtt.Client
+}
+
+// withReadOnly wraps a client when publishing is disabled
+func withReadOnly(client mqtt.Client, readOnly bool) mqtt.Client {
+	if !readOnly {
+		return client
+	}
+	return &readOnlyClient{Client: client}
+}
+
+// Publish discards the message and returns a completed token
+func (c *readOnlyClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	metrics.Default.Counter("mqtt_discarded_publishes").Inc()
+	return discardedToken{}
+}
+
+// closedDone is the Done channel of every discarded publish
+var closedDone = func() chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}()
+
+// discardedToken is the already successful token of a discarded publish
+type discardedToken struct{}
+
+func (discardedToken) Wait() bool                     { return true }
+func (discardedToken) WaitTimeout(time.Duration) bool { return true }
+func (discardedToken) Done() <-chan struct{}          { return closedDone }
+func (discardedToken) Error() error                   { return nil }
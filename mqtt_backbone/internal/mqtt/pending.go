@@ -0,0 +1,212 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+)
+
+// TimeoutSink stores inference requests that were never answered
+type TimeoutSink interface {
+	SaveMLTimeout(timeout *models.MLTimeout) error
+}
+
+// pendingInference is a published request waiting for its response
+type pendingInference struct {
+	deviceID string
+	topic    string
+	sentAt   time.Time
+	variant  string // Local model variant the request was scaled for
+	version  string // Version of that model
+}
+
+// PendingInferences tracks published inference requests by correlation ID.
+// Requests not answered within the timeout are recorded as ML timeouts, so a
+// stalled ML service shows up in metrics and ClickHouse instead of silence.
+type PendingInferences struct {
+	timeout time.Duration
+	sink    TimeoutSink // Optional
+
+	mu      sync.Mutex
+	pending map[string]pendingInference // correlation_id -> request
+}
+
+// NewPendingInferences creates a registry; sink may be nil to only count timeouts
+func NewPendingInferences(timeout time.Duration, sink TimeoutSink) *PendingInferences {
+	return &PendingInferences{
+		timeout: timeout,
+		sink:    sink,
+		pending: make(map[string]pendingInference),
+	}
+}
+
+// NewCorrelationID returns a random ID for matching a request to its response
+func NewCorrelationID() string {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Track registers a published request
+func (p *PendingInferences) Track(req *models.InferenceRequest, topic string) {
+	if req.CorrelationID == "" {
+		return
+	}
+
+	pending := pendingInference{deviceID: req.DeviceID, topic: topic, sentAt: time.Now(), variant: req.ModelVariant}
+	if req.Scaling != nil {
+		pending.version = req.Scaling.ModelVersion
+	}
+
+	p.mu.Lock()
+	p.pending[req.CorrelationID] = pending
+	count := len(p.pending)
+	p.mu.Unlock()
+
+	metrics.Default.Gauge("inference_pending").Set(int64(count))
+}
+
+// Forget drops a request that was never actually sent (e.g., publish failed)
+func (p *PendingInferences) Forget(correlationID string) {
+	p.mu.Lock()
+	delete(p.pending, correlationID)
+	count := len(p.pending)
+	p.mu.Unlock()
+
+	metrics.Default.Gauge("inference_pending").Set(int64(count))
+}
+
+// Resolve marks a response as received and returns the round-trip time.
+// Responses without a correlation ID (older ML services) resolve every
+// pending request for the device.
+func (p *PendingInferences) Resolve(correlationID, deviceID string) (time.Duration, bool) {
+	_, rtt, ok := p.resolve(correlationID, deviceID)
+	return rtt, ok
+}
+
+// ResolveResponse resolves a window model response like Resolve, and fills in the
+// model variant and version it was requested from when the ML service didn't echo them
+func (p *PendingInferences) ResolveResponse(response *models.InferenceResponse) (time.Duration, bool) {
+	req, rtt, ok := p.resolve(response.CorrelationID, response.DeviceID)
+	if ok && response.ModelVariant == "" && response.ModelVersion == "" {
+		response.ModelVariant, response.ModelVersion = req.variant, req.version
+	}
+	return rtt, ok
+}
+
+// resolve removes the request a response answers and returns it with the
+// round-trip time; without a correlation ID it returns the device's oldest request
+func (p *PendingInferences) resolve(correlationID, deviceID string) (pendingInference, time.Duration, bool) {
+	p.mu.Lock()
+	defer func() {
+		count := len(p.pending)
+		p.mu.Unlock()
+		metrics.Default.Gauge("inference_pending").Set(int64(count))
+	}()
+
+	now := time.Now()
+	if correlationID != "" {
+		req, ok := p.pending[correlationID]
+		if !ok {
+			// Already timed out, or answered twice
+			metrics.Default.Counter("inference_responses_unmatched").Inc()
+			return pendingInference{}, 0, false
+		}
+		delete(p.pending, correlationID)
+		return req, now.Sub(req.sentAt), true
+	}
+
+	var oldest pendingInference
+	for id, req := range p.pending {
+		if req.deviceID != deviceID {
+			continue
+		}
+		if oldest.sentAt.IsZero() || req.sentAt.Before(oldest.sentAt) {
+			oldest = req
+		}
+		delete(p.pending, id)
+	}
+	if oldest.sentAt.IsZero() {
+		metrics.Default.Counter("inference_responses_unmatched").Inc()
+		return pendingInference{}, 0, false
+	}
+	return oldest, now.Sub(oldest.sentAt), true
+}
+
+// Pending returns the number of unanswered requests
+func (p *PendingInferences) Pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pending)
+}
+
+// Start sweeps for expired requests until context is cancelled
+func (p *PendingInferences) Start(ctx context.Context) {
+	if p.timeout <= 0 {
+		log.Println("PendingInferences: Timeout disabled, not tracking ML timeouts")
+		return
+	}
+
+	interval := p.timeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("PendingInferences: Recording requests unanswered after %v", p.timeout)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.sweep(now)
+		}
+	}
+}
+
+// sweep records and removes every request older than the timeout
+func (p *PendingInferences) sweep(now time.Time) {
+	var expired []*models.MLTimeout
+
+	p.mu.Lock()
+	for id, req := range p.pending {
+		if now.Sub(req.sentAt) <= p.timeout {
+			continue
+		}
+		delete(p.pending, id)
+		expired = append(expired, &models.MLTimeout{
+			Timestamp:      req.sentAt,
+			CorrelationID:  id,
+			DeviceID:       req.deviceID,
+			Topic:          req.topic,
+			TimeoutSeconds: p.timeout.Seconds(),
+		})
+	}
+	count := len(p.pending)
+	p.mu.Unlock()
+
+	metrics.Default.Gauge("inference_pending").Set(int64(count))
+
+	for _, timeout := range expired {
+		metrics.Default.Counter("inference_timeouts").Inc()
+		log.Printf("Warning: Inference request %s for %s on %s unanswered after %v",
+			timeout.CorrelationID, timeout.DeviceID, timeout.Topic, p.timeout)
+
+		if p.sink == nil {
+			continue
+		}
+		if err := p.sink.SaveMLTimeout(timeout); err != nil {
+			log.Printf("Error saving ml timeout: %v", err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package mqtt
+
+import (
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// NormalizePrefix returns a topic prefix ending in exactly one "/" (empty stays empty)
+func NormalizePrefix(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// PrefixTopic places a topic or filter under a namespace prefix.
+// Broker-reserved "$" topics are left alone, and shared subscriptions
+// ("$share/group/filter") are prefixed after the group.
+func PrefixTopic(prefix, topic string) string {
+	if prefix == "" || topic == "" {
+		return topic
+	}
+	if strings.HasPrefix(topic, "$share/") {
+		parts := strings.SplitN(topic, "/", 3)
+		if len(parts) == 3 {
+			return parts[0] + "/" + parts[1] + "/" + prefix + parts[2]
+		}
+		return topic
+	}
+	if strings.HasPrefix(topic, "$") {
+		return topic
+	}
+	return prefix + topic
+}
+
+// prefixedClient namespaces every topic of an underlying client, so several
+// deployments can share one broker. Incoming messages have the prefix removed,
+// so subscribers keep parsing topics (e.g., device IDs) as if it weren't there.
+type prefixedClient struct {
+	mqtt.Client
+	prefix string
+}
+
+// withPrefix wraps a client when a prefix is configured
+func withPrefix(client mqtt.Client, prefix string) mqtt.Client {
+	if prefix == "" {
+		return client
+	}
+	return &prefixedClient{Client: client, prefix: prefix}
+}
+
+// Publish publishes under the prefix
+func (c *prefixedClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	return c.Client.Publish(PrefixTopic(c.prefix, topic), qos, retained, payload)
+}
+
+// Subscribe subscribes under the prefix
+func (c *prefixedClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	return c.Client.Subscribe(PrefixTopic(c.prefix, topic), qos, c.wrap(callback))
+}
+
+// SubscribeMultiple subscribes to several filters under the prefix
+func (c *prefixedClient) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	prefixed := make(map[string]byte, len(filters))
+	for topic, qos := range filters {
+		prefixed[PrefixTopic(c.prefix, topic)] = qos
+	}
+	return c.Client.SubscribeMultiple(prefixed, c.wrap(callback))
+}
+
+// Unsubscribe unsubscribes from filters under the prefix
+func (c *prefixedClient) Unsubscribe(topics ...string) mqtt.Token {
+	prefixed := make([]string, len(topics))
+	for i, topic := range topics {
+		prefixed[i] = PrefixTopic(c.prefix, topic)
+	}
+	return c.Client.Unsubscribe(prefixed...)
+}
+
+// AddRoute adds a message handler under the prefix
+func (c *prefixedClient) AddRoute(topic string, callback mqtt.MessageHandler) {
+	c.Client.AddRoute(PrefixTopic(c.prefix, topic), c.wrap(callback))
+}
+
+// wrap strips the prefix from messages before they reach a handler
+func (c *prefixedClient) wrap(callback mqtt.MessageHandler) mqtt.MessageHandler {
+	if callback == nil {
+		return nil
+	}
+	return func(client mqtt.Client, msg mqtt.Message) {
+		callback(c, &unprefixedMessage{Message: msg, topic: strings.TrimPrefix(msg.Topic(), c.prefix)})
+	}
+}
+
+// unprefixedMessage reports its topic without the namespace prefix
+type unprefixedMessage struct {
+	mqtt.Message
+	topic string
+}
+
+// Topic returns the topic without the prefix
+func (m *unprefixedMessage) Topic() string {
+	return m.topic
+}
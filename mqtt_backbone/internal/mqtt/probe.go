@@ -0,0 +1,82 @@
+package mqtt
+
+import (
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// subackFailure is the SUBACK return code for a refused subscription
+const subackFailure = 0x80
+
+// ProbeResult reports whether the broker is reachable and which topic filters may be subscribed
+type ProbeResult struct {
+	ConnectErr error
+	Latency    time.Duration    // Connect round-trip
+	Topics     map[string]error // Topic filter -> nil if the broker granted the subscription
+}
+
+// ProbeBroker connects with a separate client ID, subscribes to each topic filter,
+// and unsubscribes again. It never publishes and doesn't disturb the service's own session.
+func ProbeBroker(config ClientConfig, topics []string, timeout time.Duration) *ProbeResult {
+	result := &ProbeResult{Topics: make(map[string]error)}
+	tlsConfig, err := BuildTLSConfig(config.Broker, config.TLS)
+	if err != nil {
+		result.ConnectErr = err
+		return result
+	}
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(config.Broker)
+	opts.SetClientID(config.ClientID + "-preflight")
+	opts.SetUsername(config.Username)
+	opts.SetPassword(config.Password)
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	opts.SetCleanSession(true)
+	opts.SetAutoReconnect(false)
+	opts.SetConnectTimeout(timeout)
+
+	client := mqtt.NewClient(opts)
+	start := time.Now()
+	token := client.Connect()
+	if !token.WaitTimeout(timeout) {
+		result.ConnectErr = fmt.Errorf("timed out after %v", timeout)
+		return result
+	}
+	if err := token.Error(); err != nil {
+		result.ConnectErr = err
+		return result
+	}
+	result.Latency = time.Since(start)
+	defer client.Disconnect(250)
+
+	prefix := NormalizePrefix(config.TopicPrefix)
+	for _, topic := range topics {
+		result.Topics[topic] = probeSubscribe(client, PrefixTopic(prefix, topic), timeout)
+	}
+
+	return result
+}
+
+// probeSubscribe checks that the broker grants a subscription to a topic filter
+func probeSubscribe(client mqtt.Client, topic string, timeout time.Duration) error {
+	token := client.Subscribe(topic, 1, func(mqtt.Client, mqtt.Message) {})
+	if !token.WaitTimeout(timeout) {
+		return fmt.Errorf("subscribe timed out after %v", timeout)
+	}
+	if err := token.Error(); err != nil {
+		return err
+	}
+
+	if sub, ok := token.(*mqtt.SubscribeToken); ok {
+		if code, ok := sub.Result()[topic]; ok && code == subackFailure {
+			return fmt.Errorf("subscription refused by broker")
+		}
+	}
+
+	client.Unsubscribe(topic).WaitTimeout(timeout)
+	return nil
+}
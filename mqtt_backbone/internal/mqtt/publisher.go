@@ -5,7 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"strings"
+	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"iot-backend/internal/models"
@@ -16,28 +16,118 @@ type Publisher struct {
 	client mqtt.Client
 
 	// Input channel (read by publisher, written by inference service)
-	InferenceReqChan chan *models.InferenceRequest
+	InferenceReqChan <-chan *models.InferenceRequest
 
-	// Topic pattern
-	inferenceReqTopic string // e.g., "ml/inference/request/{device_id}"
+	// Routes inference requests across ML service instances
+	inferenceRouter *InferenceRouter
+
+	// Optional registry of requests awaiting a response
+	pending *PendingInferences
+
+	// Optional tracker of window commands awaiting their actuator's ack
+	commands *CommandTracker
+
+	// Optional reassembly of chunked audio uploads, whose NACKs are published here
+	uploads *AudioUploads
+
+	// Optional store for the exact payload of each published request; set before Start
+	FeatureSnapshots FeatureSnapshotSink
+
+	// Optional cipher encrypting device configs for devices with a payload key; set before Start
+	Cipher *PayloadCipher
+
+	// Additional ML models that receive every inference request
+	mlModels []MLModel
+
+	// Window models of the inference domains, by name; domain requests go to these
+	// instead of the inference router
+	domains map[string]MLModel
+
+	// Value of the {tenant} placeholder
+	tenant string
+
+	// Topic patterns
+	windowCommandTopic string // e.g., "window/{device_id}/command"
+	shadowCommandTopic string // e.g., "shadow/window/{device_id}/command" (dry-run)
+	deviceConfigTopic  string // e.g., "device/{device_id}/config"
+	audioControlTopic  string // e.g., "device/{device_id}/audio/control"
+}
+
+// FeatureSnapshotSink stores the payloads of published inference requests
+type FeatureSnapshotSink interface {
+	SaveFeatureSnapshot(snapshot *models.FeatureSnapshot) error
 }
 
 // PublisherConfig holds configuration for MQTT publisher
 type PublisherConfig struct {
-	InferenceReqTopic string // e.g., "ml/inference/request/{device_id}"
+	InferenceReqTopic  string // e.g., "ml/inference/request/{device_id}"
+	WindowCommandTopic string // e.g., "window/{device_id}/command"
+	ShadowCommandTopic string // Optional, dry-run commands are published here
+	DeviceConfigTopic  string // e.g., "device/{device_id}/config"
+	AudioControlTopic  string // e.g., "device/{device_id}/audio/control" (NACKs of chunked audio uploads)
+	Tenant             string // Value of the {tenant} placeholder in every topic
+
+	// Optional router over several ML service instances; overrides InferenceReqTopic
+	InferenceRouter *InferenceRouter
+
+	// Optional registry that records requests the ML service never answers
+	Pending *PendingInferences
+
+	// Optional tracker that resends window commands until their actuator acknowledges them
+	Commands *CommandTracker
+
+	// Optional reassembly of chunked audio uploads; requires AudioControlTopic
+	Uploads *AudioUploads
+
+	// Additional ML models, each with its own topics and feature set
+	Models []MLModel
+
+	// Optional inference domains (e.g., thermal, noise), each with its own window model
+	Domains []MLModel
 }
 
 // NewPublisher creates a new MQTT publisher with channels
 func NewPublisher(
 	client mqtt.Client,
 	config PublisherConfig,
-	inferenceReqChan chan *models.InferenceRequest,
+	inferenceReqChan <-chan *models.InferenceRequest,
 ) *Publisher {
-	return &Publisher{
-		client:            client,
-		InferenceReqChan:  inferenceReqChan,
-		inferenceReqTopic: config.InferenceReqTopic,
+	router := config.InferenceRouter
+	if router == nil {
+		// Single ML service instance on InferenceReqTopic
+		router = &InferenceRouter{
+			topics:   []string{config.InferenceReqTopic},
+			policy:   RoutingRoundRobin,
+			inflight: make([]int, 1),
+			pending:  make(map[string]inflightRequest),
+		}
+	}
+
+	p := &Publisher{
+		client:             client,
+		InferenceReqChan:   inferenceReqChan,
+		inferenceRouter:    router,
+		pending:            config.Pending,
+		commands:           config.Commands,
+		uploads:            config.Uploads,
+		mlModels:           config.Models,
+		domains:            make(map[string]MLModel, len(config.Domains)),
+		windowCommandTopic: config.WindowCommandTopic,
+		shadowCommandTopic: config.ShadowCommandTopic,
+		deviceConfigTopic:  config.DeviceConfigTopic,
+		audioControlTopic:  config.AudioControlTopic,
+		tenant:             config.Tenant,
+	}
+	for _, domain := range config.Domains {
+		p.domains[domain.Name] = domain
+	}
+	if p.commands != nil {
+		p.commands.resend = p.resendWindowCommand
 	}
+	if p.uploads != nil {
+		p.uploads.publish = p.publishAudioControl
+	}
+	return p
 }
 
 // Start begins publishing inference requests from the channel
@@ -58,34 +148,250 @@ func (p *Publisher) Start(ctx context.Context) {
 				return
 			}
 
-			// Publish the inference request
-			if err := p.publishInferenceRequest(req); err != nil {
+			// Publish the inference request, to its domain's model if it has one
+			if req.Domain != "" {
+				if err := p.publishDomainRequest(req); err != nil {
+					log.Printf("Error publishing %s inference request: %v", req.Domain, err)
+				}
+			} else if err := p.publishInferenceRequest(req); err != nil {
 				log.Printf("Error publishing inference request: %v", err)
 			}
+			for _, model := range p.mlModels {
+				if err := p.publishModelRequest(model, req); err != nil {
+					log.Printf("Error publishing %s inference request: %v", model.Name, err)
+				}
+			}
 		}
 	}
 }
 
 // publishInferenceRequest publishes an inference request to the ML service
 func (p *Publisher) publishInferenceRequest(req *models.InferenceRequest) error {
+	if req.CorrelationID == "" {
+		req.CorrelationID = NewCorrelationID()
+	}
+
 	payload, err := json.Marshal(req)
 	if err != nil {
 		return fmt.Errorf("failed to marshal inference request: %w", err)
 	}
 
-	// Replace {device_id} placeholder with actual device ID
-	topic := formatTopic(p.inferenceReqTopic, req.DeviceID)
+	// Pick an ML service instance, then fill in the topic placeholders
+	topic, err := formatTopic(p.inferenceRouter.Route(req.DeviceID), p.topicVars(req.DeviceID))
+	if err != nil {
+		p.inferenceRouter.Complete(req.DeviceID)
+		return fmt.Errorf("failed to publish inference request: %w", err)
+	}
+
+	// Track before publishing so a fast response can't arrive before its request is registered
+	if p.pending != nil {
+		p.pending.Track(req, topic)
+	}
+
+	token := p.client.Publish(topic, 1, false, payload)
+	if err := waitToken("publish", topic, token); err != nil {
+		p.inferenceRouter.Complete(req.DeviceID)
+		if p.pending != nil {
+			p.pending.Forget(req.CorrelationID)
+		}
+		return fmt.Errorf("failed to publish inference request: %w", err)
+	}
+
+	log.Printf("Published inference request %s for device %s to topic: %s", req.CorrelationID, req.DeviceID, topic)
+
+	p.saveFeatureSnapshot(req.CorrelationID, req.DeviceID, topic, payload)
+	return nil
+}
+
+// publishModelRequest publishes an inference request to an additional ML model,
+// reduced to the model's features. The model gets a correlation ID of its own so
+// its response and timeout are tracked separately from the window model's.
+func (p *Publisher) publishModelRequest(model MLModel, req *models.InferenceRequest) error {
+	modelReq := *req
+	modelReq.CorrelationID = NewCorrelationID()
+	return p.publishToModel(model, &modelReq)
+}
+
+// publishDomainRequest publishes a domain's inference request to the domain's model
+// under the request's own correlation ID, which its inference history row carries
+func (p *Publisher) publishDomainRequest(req *models.InferenceRequest) error {
+	domain, ok := p.domains[req.Domain]
+	if !ok {
+		return fmt.Errorf("no model configured for inference domain %q", req.Domain)
+	}
+	if req.CorrelationID == "" {
+		req.CorrelationID = NewCorrelationID()
+	}
+	return p.publishToModel(domain, req)
+}
+
+// publishToModel publishes a request to a model's request topic, reduced to its features
+func (p *Publisher) publishToModel(model MLModel, req *models.InferenceRequest) error {
+	payload, err := model.payload(req)
+	if err != nil {
+		return err
+	}
+
+	topic, err := formatTopic(model.RequestTopic, p.topicVars(req.DeviceID))
+	if err != nil {
+		return fmt.Errorf("failed to publish %s inference request: %w", model.Name, err)
+	}
+	if p.pending != nil {
+		p.pending.Track(req, topic)
+	}
 
 	token := p.client.Publish(topic, 1, false, payload)
-	if token.Wait() && token.Error() != nil {
-		return fmt.Errorf("failed to publish inference request: %w", token.Error())
+	if err := waitToken("publish", topic, token); err != nil {
+		if p.pending != nil {
+			p.pending.Forget(req.CorrelationID)
+		}
+		return fmt.Errorf("failed to publish %s inference request: %w", model.Name, err)
 	}
 
-	log.Printf("Published inference request for device %s to topic: %s", req.DeviceID, topic)
+	log.Printf("Published %s inference request %s for device %s to topic: %s", model.Name, req.CorrelationID, req.DeviceID, topic)
+	p.saveFeatureSnapshot(req.CorrelationID, req.DeviceID, topic, payload)
+	return nil
+}
+
+// saveFeatureSnapshot stores a published request payload, if a snapshot sink is configured.
+// The marshaled payload, not the request struct, so the ML input can be replayed bit for bit.
+func (p *Publisher) saveFeatureSnapshot(correlationID, deviceID, topic string, payload []byte) {
+	if p.FeatureSnapshots == nil {
+		return
+	}
+	snapshot := &models.FeatureSnapshot{
+		CorrelationID: correlationID,
+		Timestamp:     time.Now(),
+		DeviceID:      deviceID,
+		Topic:         topic,
+		Features:      string(payload),
+	}
+	if err := p.FeatureSnapshots.SaveFeatureSnapshot(snapshot); err != nil {
+		log.Printf("Error saving feature snapshot for %s: %v", correlationID, err)
+	}
+}
+
+// PublishWindowCommand publishes a window command to the actuator topic at QoS 2,
+// under a new command ID. With a command tracker, the command is resent until
+// the actuator acknowledges it.
+func (p *Publisher) PublishWindowCommand(cmd *models.WindowCommand) error {
+	if p.windowCommandTopic == "" {
+		return fmt.Errorf("window command topic not configured")
+	}
+	cmd.CommandID = NewCorrelationID()
+
+	// Track before publishing so a fast ack can't arrive before its command is registered
+	if p.commands != nil {
+		p.commands.Track(cmd)
+	}
+	if err := p.publishWindowCommand(p.windowCommandTopic, cmd, windowCommandQoS); err != nil {
+		if p.commands != nil {
+			p.commands.Forget(cmd.CommandID)
+		}
+		return err
+	}
+	return nil
+}
+
+// resendWindowCommand publishes a tracked command again under its original ID
+func (p *Publisher) resendWindowCommand(cmd *models.WindowCommand) error {
+	return p.publishWindowCommand(p.windowCommandTopic, cmd, windowCommandQoS)
+}
+
+// PublishShadowCommand publishes a dry-run window command to the shadow topic.
+// It is a no-op when no shadow topic is configured.
+func (p *Publisher) PublishShadowCommand(cmd *models.WindowCommand) error {
+	if p.shadowCommandTopic == "" {
+		return nil
+	}
+	cmd.CommandID = NewCorrelationID()
+	return p.publishWindowCommand(p.shadowCommandTopic, cmd, 1)
+}
+
+// publishWindowCommand marshals and publishes a window command to a topic pattern
+func (p *Publisher) publishWindowCommand(topicPattern string, cmd *models.WindowCommand, qos byte) error {
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to marshal window command: %w", err)
+	}
+
+	vars := p.topicVars(cmd.DeviceID)
+	if cmd.WindowID != "" {
+		vars.WindowID = cmd.WindowID
+	}
+	topic, err := formatTopic(topicPattern, vars)
+	if err != nil {
+		return fmt.Errorf("failed to publish window command: %w", err)
+	}
+
+	token := p.client.Publish(topic, qos, false, payload)
+	if err := waitToken("publish", topic, token); err != nil {
+		return fmt.Errorf("failed to publish window command: %w", err)
+	}
+
+	log.Printf("Published window command %s for device %s to topic: %s (position=%.2f%%)", cmd.CommandID, cmd.DeviceID, topic, cmd.Position)
+	return nil
+}
+
+// PublishDeviceConfig publishes a device's configuration to its config topic
+func (p *Publisher) PublishDeviceConfig(cfg *models.DeviceConfig) error {
+	if p.deviceConfigTopic == "" {
+		return fmt.Errorf("device config topic not configured")
+	}
+
+	payload, err := json.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal device config: %w", err)
+	}
+	if p.Cipher != nil {
+		if payload, err = p.Cipher.Seal(cfg.DeviceID, payload); err != nil {
+			return fmt.Errorf("failed to encrypt device config: %w", err)
+		}
+	}
+
+	topic, err := formatTopic(p.deviceConfigTopic, p.topicVars(cfg.DeviceID))
+	if err != nil {
+		return fmt.Errorf("failed to publish device config: %w", err)
+	}
+
+	token := p.client.Publish(topic, 1, false, payload)
+	if err := waitToken("publish", topic, token); err != nil {
+		return fmt.Errorf("failed to publish device config: %w", err)
+	}
+
+	log.Printf("Published config for device %s to topic: %s", cfg.DeviceID, topic)
+	return nil
+}
+
+// publishAudioControl publishes the state of a chunked audio upload to its device's control topic
+func (p *Publisher) publishAudioControl(deviceID string, ctl *models.AudioUploadControl) error {
+	if p.audioControlTopic == "" {
+		return fmt.Errorf("audio control topic not configured")
+	}
+
+	payload, err := json.Marshal(ctl)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audio upload control: %w", err)
+	}
+
+	topic, err := formatTopic(p.audioControlTopic, p.topicVars(deviceID))
+	if err != nil {
+		return fmt.Errorf("failed to publish audio upload control: %w", err)
+	}
+
+	token := p.client.Publish(topic, 1, false, payload)
+	if err := waitToken("publish", topic, token); err != nil {
+		return fmt.Errorf("failed to publish audio upload control: %w", err)
+	}
+
+	if ctl.Status == models.UploadMissing {
+		log.Printf("Requested %d missing chunk(s) of clip %s from %s (%d of %d received)",
+			len(ctl.Missing), ctl.ClipID, deviceID, ctl.Received, ctl.Total)
+	}
 	return nil
 }
 
-// formatTopic replaces {device_id} placeholder with actual device ID
-func formatTopic(topicPattern, deviceID string) string {
-	return strings.ReplaceAll(topicPattern, "{device_id}", deviceID)
+// topicVars returns the placeholder values for a device's topics
+func (p *Publisher) topicVars(deviceID string) TopicVars {
+	return TopicVars{DeviceID: deviceID, Tenant: p.tenant, WindowID: deviceID}
 }
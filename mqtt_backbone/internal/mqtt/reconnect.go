@@ -0,0 +1,89 @@
+package mqtt
+
+import (
+	"log"
+	"math/rand"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"iot-backend/internal/metrics"
+)
+
+// BackoffConfig controls how the client reconnects after losing the broker. Delays
+// double from Initial up to Max; each is spread by ±Jitter (a fraction) so a fleet
+// of backends restarted together doesn't reconnect to an overloaded broker in lockstep.
+type BackoffConfig struct {
+	Initial time.Duration
+	Max     time.Duration
+	Jitter  float64 // 0-1
+}
+
+// DefaultBackoffConfig returns the reconnect backoff used when none is configured
+func DefaultBackoffConfig() BackoffConfig {
+	return BackoffConfig{
+		Initial: time.Second,
+		Max:     2 * time.Minute,
+		Jitter:  0.2,
+	}
+}
+
+// delay returns the wait before reconnect attempt n (0-based)
+func (b BackoffConfig) delay(n int) time.Duration {
+	d := b.Initial
+	for i := 0; i < n && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	if b.Jitter > 0 {
+		d = time.Duration(float64(d) * (1 - b.Jitter + 2*b.Jitter*rand.Float64()))
+	}
+	return d
+}
+
+// reconnect runs after the connection is lost and retries until connected or closed.
+// Paho's own reconnect is disabled because its backoff can't be tuned.
+func (c *Client) reconnect() {
+	for attempt := 0; ; attempt++ {
+		delay := c.config.Backoff.delay(attempt)
+		metrics.Default.Gauge("mqtt_reconnect_backoff_ms").Set(delay.Milliseconds())
+		select {
+		case <-c.closed:
+			return
+		case <-time.After(delay):
+		}
+
+		metrics.Default.Counter("mqtt_reconnect_attempts").Inc()
+		err := waitToken("connect", c.config.Broker, c.client.Connect())
+		if err == nil {
+			metrics.Default.Counter("mqtt_reconnects").Inc()
+			metrics.Default.Gauge("mqtt_reconnect_backoff_ms").Set(0)
+			log.Printf("MQTT Client: Reconnected to %s after %d attempts", c.config.Broker, attempt+1)
+			return
+		}
+		log.Printf("MQTT Client: Reconnect attempt %d failed: %v", attempt+1, err)
+	}
+}
+
+// inflightClient counts publishes the broker hasn't acknowledged yet
+type inflightClient struct {
+	mqtt.Client
+	inflight *metrics.Gauge
+}
+
+// withInflight wraps a client to report its in-flight publishes
+func withInflight(client mqtt.Client) mqtt.Client {
+	return &inflightClient{Client: client, inflight: metrics.Default.Gauge("mqtt_inflight_publishes")}
+}
+
+// Publish publishes and counts the message until its token completes
+func (c *inflightClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	token := c.Client.Publish(topic, qos, retained, payload)
+	c.inflight.Add(1)
+	go func() {
+		<-token.Done()
+		c.inflight.Add(-1)
+	}()
+	return token
+}
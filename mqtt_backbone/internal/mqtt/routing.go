@@ -0,0 +1,184 @@
+package mqtt
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-backend/internal/metrics"
+)
+
+// RoutingPolicy selects which ML service instance receives an inference request
+type RoutingPolicy string
+
+const (
+	RoutingRoundRobin    RoutingPolicy = "round_robin"    // Rotate through topics
+	RoutingSticky        RoutingPolicy = "sticky"         // Same device always goes to the same topic
+	RoutingLeastInflight RoutingPolicy = "least_inflight" // Topic with the fewest unanswered requests
+)
+
+// ParseRoutingPolicy validates a routing policy name (empty means round-robin)
+func ParseRoutingPolicy(name string) (RoutingPolicy, error) {
+	switch RoutingPolicy(strings.ToLower(strings.TrimSpace(name))) {
+	case "", RoutingRoundRobin:
+		return RoutingRoundRobin, nil
+	case RoutingSticky:
+		return RoutingSticky, nil
+	case RoutingLeastInflight:
+		return RoutingLeastInflight, nil
+	default:
+		return "", fmt.Errorf("unknown inference routing policy %q", name)
+	}
+}
+
+// inflightRequest is an inference request that hasn't been answered yet
+type inflightRequest struct {
+	topic  int
+	sentAt time.Time
+}
+
+// InferenceRouter distributes inference requests over several request topics
+// (one per ML service instance) and tracks unanswered requests per topic.
+// A device has at most one request in flight; a newer request supersedes it.
+type InferenceRouter struct {
+	topics  []string // Topic patterns, e.g. "ml/a/inference/request/{device_id}"
+	policy  RoutingPolicy
+	timeout time.Duration // Unanswered requests stop counting as in flight after this
+
+	mu       sync.Mutex
+	next     int
+	inflight []int
+	pending  map[string]inflightRequest // device_id -> outstanding request
+}
+
+// NewInferenceRouter creates a router over the given topic patterns
+func NewInferenceRouter(topics []string, policy RoutingPolicy, timeout time.Duration) (*InferenceRouter, error) {
+	var cleaned []string
+	for _, t := range topics {
+		if t = strings.TrimSpace(t); t != "" {
+			cleaned = append(cleaned, t)
+		}
+	}
+	if len(cleaned) == 0 {
+		return nil, fmt.Errorf("no inference request topics configured")
+	}
+
+	return &InferenceRouter{
+		topics:   cleaned,
+		policy:   policy,
+		timeout:  timeout,
+		inflight: make([]int, len(cleaned)),
+		pending:  make(map[string]inflightRequest),
+	}, nil
+}
+
+// Topics returns the configured topic patterns
+func (r *InferenceRouter) Topics() []string {
+	return append([]string(nil), r.topics...)
+}
+
+// Policy returns the routing policy
+func (r *InferenceRouter) Policy() RoutingPolicy {
+	return r.policy
+}
+
+// Route picks the topic pattern for a device's request and marks it in flight
+func (r *InferenceRouter) Route(deviceID string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.expire(now)
+	r.complete(deviceID)
+
+	idx := r.pick(deviceID)
+	r.inflight[idx]++
+	r.pending[deviceID] = inflightRequest{topic: idx, sentAt: now}
+	r.recordInflight(idx)
+
+	return r.topics[idx]
+}
+
+// Complete marks a device's outstanding request as answered
+func (r *InferenceRouter) Complete(deviceID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.complete(deviceID)
+}
+
+// Inflight returns unanswered request counts per topic pattern
+func (r *InferenceRouter) Inflight() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire(time.Now())
+
+	counts := make(map[string]int, len(r.topics))
+	for i, t := range r.topics {
+		counts[t] = r.inflight[i]
+	}
+	return counts
+}
+
+// pick selects a topic index. Caller must hold mu.
+func (r *InferenceRouter) pick(deviceID string) int {
+	if len(r.topics) == 1 {
+		return 0
+	}
+
+	switch r.policy {
+	case RoutingSticky:
+		h := fnv.New32a()
+		h.Write([]byte(deviceID))
+		return int(h.Sum32() % uint32(len(r.topics)))
+
+	case RoutingLeastInflight:
+		// Ties rotate so idle instances share the load
+		best := -1
+		for i := 0; i < len(r.topics); i++ {
+			idx := (r.next + i) % len(r.topics)
+			if best < 0 || r.inflight[idx] < r.inflight[best] {
+				best = idx
+			}
+		}
+		r.next = (best + 1) % len(r.topics)
+		return best
+
+	default:
+		idx := r.next
+		r.next = (r.next + 1) % len(r.topics)
+		return idx
+	}
+}
+
+// complete removes a device's outstanding request. Caller must hold mu.
+func (r *InferenceRouter) complete(deviceID string) {
+	req, ok := r.pending[deviceID]
+	if !ok {
+		return
+	}
+	delete(r.pending, deviceID)
+	r.inflight[req.topic]--
+	r.recordInflight(req.topic)
+}
+
+// expire drops requests that were never answered. Caller must hold mu.
+func (r *InferenceRouter) expire(now time.Time) {
+	if r.timeout <= 0 {
+		return
+	}
+	for deviceID, req := range r.pending {
+		if now.Sub(req.sentAt) > r.timeout {
+			delete(r.pending, deviceID)
+			r.inflight[req.topic]--
+			r.recordInflight(req.topic)
+			metrics.Default.Counter("inference_unanswered").Inc()
+		}
+	}
+}
+
+// recordInflight publishes a topic's in-flight count as a gauge
+func (r *InferenceRouter) recordInflight(idx int) {
+	metrics.Default.Gauge(fmt.Sprintf("inference_inflight_%d", idx)).Set(int64(r.inflight[idx]))
+}
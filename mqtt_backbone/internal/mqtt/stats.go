@@ -0,0 +1,214 @@
+package mqtt
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"iot-backend/internal/models"
+)
+
+// DeviceStatsSink stores flushed per-device message counts
+type DeviceStatsSink interface {
+	SaveDeviceStats(windows []*models.DeviceStatsWindow) error
+}
+
+// StatsConfig holds configuration for per-device message statistics
+type StatsConfig struct {
+	FlushInterval time.Duration // How often counts are written to the sink (0 or less keeps them in memory only)
+}
+
+// DefaultStatsConfig returns default configuration
+func DefaultStatsConfig() StatsConfig {
+	return StatsConfig{
+		FlushInterval: 5 * time.Minute,
+	}
+}
+
+// rateBuckets is the number of one-minute buckets behind messages/hour
+const rateBuckets = 60
+
+// deviceCounts holds the counters of one device
+type deviceCounts struct {
+	since, last time.Time
+	total       models.DeviceStatsWindow // Since the backend started
+	pending     models.DeviceStatsWindow // Since the last flush
+
+	// Messages per minute over the last hour, indexed by minute
+	minutes [rateBuckets]uint64
+	minute  int64 // Unix minute of the newest bucket
+}
+
+// advance clears buckets for minutes that passed without messages
+func (c *deviceCounts) advance(minute int64) {
+	if gap := minute - c.minute; gap > 0 {
+		for i := int64(1); i <= gap && i <= rateBuckets; i++ {
+			c.minutes[(c.minute+i)%rateBuckets] = 0
+		}
+		c.minute = minute
+	}
+}
+
+// MessageStats counts received messages, payload bytes, parse errors, and drops
+// per device in memory and periodically flushes the counts for history. Safe for
+// concurrent use.
+type MessageStats struct {
+	sink     DeviceStatsSink
+	interval time.Duration
+
+	mu         sync.Mutex
+	devices    map[string]*deviceCounts
+	flushStart time.Time
+}
+
+// NewMessageStats creates a statistics collector flushing to sink (nil keeps counts in memory only)
+func NewMessageStats(config StatsConfig, sink DeviceStatsSink) *MessageStats {
+	return &MessageStats{
+		sink:       sink,
+		interval:   config.FlushInterval,
+		devices:    make(map[string]*deviceCounts),
+		flushStart: time.Now(),
+	}
+}
+
+// record counts one message and its outcome ("parse_error", "dropped", "unauthorized", or anything else)
+func (ms *MessageStats) record(deviceID string, payloadBytes int, outcome string, at time.Time) {
+	if deviceID == "" {
+		return
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	c, ok := ms.devices[deviceID]
+	if !ok {
+		c = &deviceCounts{since: at, minute: at.Unix() / 60}
+		ms.devices[deviceID] = c
+	}
+	c.last = at
+	minute := at.Unix() / 60
+	c.advance(minute)
+	c.minutes[minute%rateBuckets]++
+
+	for _, w := range []*models.DeviceStatsWindow{&c.total, &c.pending} {
+		w.Messages++
+		w.PayloadBytes += uint64(payloadBytes)
+		switch outcome {
+		case "parse_error":
+			w.ParseErrors++
+		case "dropped":
+			w.Dropped++
+		case "unauthorized":
+			w.Unauthorized++
+		}
+	}
+}
+
+// Get returns the statistics of a device, or false if it hasn't sent a message
+func (ms *MessageStats) Get(deviceID string) (models.DeviceMessageStats, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	c, ok := ms.devices[deviceID]
+	if !ok {
+		return models.DeviceMessageStats{}, false
+	}
+	now := time.Now()
+	c.advance(now.Unix() / 60)
+
+	var lastHour uint64
+	for _, n := range c.minutes {
+		lastHour += n
+	}
+	perHour := float64(lastHour)
+	if age := now.Sub(c.since); age < time.Hour {
+		// Extrapolate from however long the device has been reporting
+		perHour = float64(lastHour) / max(age.Hours(), 1.0/60)
+	}
+
+	stats := models.DeviceMessageStats{
+		DeviceID:        deviceID,
+		Since:           c.since,
+		LastMessage:     c.last,
+		Messages:        c.total.Messages,
+		MessagesPerHour: perHour,
+		ParseErrors:     c.total.ParseErrors,
+		Dropped:         c.total.Dropped,
+		Unauthorized:    c.total.Unauthorized,
+	}
+	if c.total.Messages > 0 {
+		stats.AvgPayloadBytes = float64(c.total.PayloadBytes) / float64(c.total.Messages)
+		stats.ParseErrorRate = float64(c.total.ParseErrors) / float64(c.total.Messages)
+	}
+	return stats, true
+}
+
+// Start flushes counts every interval until context is cancelled, then flushes once more.
+// Without a positive interval it returns at once and counts stay in memory.
+func (ms *MessageStats) Start(ctx context.Context) {
+	if ms.interval <= 0 {
+		log.Printf("Device message stats are kept in memory only (flush interval %v)", ms.interval)
+		return
+	}
+	ticker := time.NewTicker(ms.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			ms.flush(time.Now())
+			return
+		case now := <-ticker.C:
+			ms.flush(now)
+		}
+	}
+}
+
+// flush writes and resets the counts accumulated since the previous flush
+func (ms *MessageStats) flush(now time.Time) {
+	ms.mu.Lock()
+	start := ms.flushStart
+	ms.flushStart = now
+	var windows []*models.DeviceStatsWindow
+	for deviceID, c := range ms.devices {
+		if c.pending.Messages == 0 {
+			continue
+		}
+		w := c.pending
+		w.WindowStart = start
+		w.DeviceID = deviceID
+		w.WindowSeconds = int(now.Sub(start) / time.Second)
+		windows = append(windows, &w)
+		c.pending = models.DeviceStatsWindow{}
+	}
+	ms.mu.Unlock()
+
+	if len(windows) == 0 || ms.sink == nil {
+		return
+	}
+	if err := ms.sink.SaveDeviceStats(windows); err != nil {
+		log.Printf("Error saving device message stats: %v", err)
+	}
+}
+
+// markOutcome records a handler's decision on a message for the statistics
+func markOutcome(msg mqtt.Message, outcome string) {
+	for {
+		switch m := msg.(type) {
+		case *templatedMessage:
+			m.outcome = outcome
+			return
+		case *tracedMessage:
+			msg = m.Message
+		default:
+			return
+		}
+	}
+}
+
+// dropMessage records that a parsed message was discarded, and why
+func dropMessage(msg mqtt.Message, format string, args ...interface{}) {
+	markOutcome(msg, "dropped")
+	traceOf(msg).decide("dropped", format, args...)
+}
@@ -0,0 +1,115 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"iot-backend/internal/models"
+)
+
+// StatusPublisher keeps the backend's retained status topic current:
+// "online" with uptime and device count while running, "offline" on shutdown,
+// and the client's last will ("offline", connection_lost) if the process dies.
+type StatusPublisher struct {
+	client    mqtt.Client
+	topic     string
+	version   string
+	interval  time.Duration
+	startedAt time.Time
+
+	// Optional source of the tracked device count
+	DeviceCount func() int
+}
+
+// StatusConfig holds configuration for the status publisher
+type StatusConfig struct {
+	Topic           string // e.g., "backend/status"
+	Version         string
+	IntervalSeconds int // How often uptime and device count are refreshed
+}
+
+// NewStatusPublisher creates a status publisher
+func NewStatusPublisher(client mqtt.Client, config StatusConfig) *StatusPublisher {
+	return &StatusPublisher{
+		client:    client,
+		topic:     config.Topic,
+		version:   config.Version,
+		interval:  time.Duration(config.IntervalSeconds) * time.Second,
+		startedAt: time.Now(),
+	}
+}
+
+// LastWill returns the payload the broker should publish if the backend disconnects ungracefully
+func LastWill(version string) []byte {
+	payload, _ := json.Marshal(&models.BackendStatus{
+		Status:  models.BackendOffline,
+		Version: version,
+		Reason:  "connection_lost",
+	})
+	return payload
+}
+
+// Start publishes online status periodically until context is cancelled, then publishes offline
+func (p *StatusPublisher) Start(ctx context.Context) {
+	if p.interval <= 0 {
+		p.interval = time.Minute
+	}
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	p.PublishOnline()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := p.publish(p.status(models.BackendOffline, "shutdown")); err != nil {
+				log.Printf("Error publishing offline status: %v", err)
+			}
+			return
+		case <-ticker.C:
+			p.PublishOnline()
+		}
+	}
+}
+
+// PublishOnline publishes the current online status (also called after reconnects,
+// since the broker has replaced it with the last will)
+func (p *StatusPublisher) PublishOnline() {
+	if err := p.publish(p.status(models.BackendOnline, "")); err != nil {
+		log.Printf("Error publishing backend status: %v", err)
+	}
+}
+
+// status builds a status snapshot
+func (p *StatusPublisher) status(state, reason string) *models.BackendStatus {
+	st := &models.BackendStatus{
+		Status:        state,
+		Version:       p.version,
+		Timestamp:     time.Now(),
+		StartedAt:     p.startedAt,
+		UptimeSeconds: time.Since(p.startedAt).Seconds(),
+		Reason:        reason,
+	}
+	if p.DeviceCount != nil {
+		st.TrackedDevices = p.DeviceCount()
+	}
+	return st
+}
+
+// publish writes a retained status message
+func (p *StatusPublisher) publish(status *models.BackendStatus) error {
+	payload, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("failed to marshal backend status: %w", err)
+	}
+
+	token := p.client.Publish(p.topic, 1, true, payload)
+	if err := waitToken("publish", p.topic, token); err != nil {
+		return fmt.Errorf("failed to publish backend status: %w", err)
+	}
+	return nil
+}
@@ -1,32 +1,121 @@
 package mqtt
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"iot-backend/internal/aggregator"
+	"iot-backend/internal/errs"
+	"iot-backend/internal/ingest"
+	"iot-backend/internal/metrics"
 	"iot-backend/internal/models"
 )
 
-// Subscriber handles MQTT subscriptions and writes messages to channels
+// Subscriber handles MQTT subscriptions, the MQTT ReadingIngestor. Readings go to
+// the ingestion pipeline; ML responses and device messages to channels.
 type Subscriber struct {
 	client mqtt.Client
 
-	// Output channels (written by subscriber, read by services)
-	TempChan          chan *models.TemperatureReading
-	HumidityChan      chan *models.HumidityReading
-	AudioChan         chan *models.AudioRecording
-	WindowControlChan chan *models.InferenceResponse
+	// Destination of sensor readings, given by Start
+	pipeline ingest.Pipeline
 
-	// Topic patterns
+	// Output channel for window control responses (written by subscriber, read by services)
+	WindowControlChan chan<- *models.InferenceResponse
+
+	// Optional output channel for device boot announcements; set before SubscribeAll
+	BootChan chan<- *models.DeviceBoot
+
+	// Optional output channel for buffered readings replayed by devices; set before SubscribeAll
+	BackfillChan chan<- *models.BackfillBatch
+
+	// Value substituted for {tenant} in subscription filters (empty matches any tenant)
+	tenant string
+
+	// Topic patterns (placeholders such as {device_id} become "+" in the filter)
 	temperatureTopic   string
 	humidityTopic      string
 	audioTopic         string
+	audioChunkTopic    string
 	windowControlTopic string
+	windowAckTopic     string
+	safetyTopic        string
+	frameTopic         string
+	backfillTopic      string
+	bootTopic          string
+	motionTopic        string
+	co2Topic           string
+
+	// Layout for packed binary frames (nil disables frame decoding)
+	frameLayout *FrameLayout
+
+	// LoRaWAN network server uplinks (TTN / ChirpStack)
+	loraWANTopic  string
+	loraWANCodecs *LoRaWANCodecs
+
+	// Additional ML models whose response topics are subscribed
+	mlModels []MLModel
+
+	// Inference domain models, whose responses are forwarded like window control responses
+	domains []MLModel
+
+	// Optional store for additional model responses; set before SubscribeAll
+	ModelPredictions ModelPredictionSink
+
+	// Optional sink for messages that can't be parsed; set before SubscribeAll
+	DeadLetters DeadLetterSink
+
+	// Optional router notified when an ML response arrives; set before SubscribeAll
+	InferenceRouter *InferenceRouter
+
+	// Optional registry resolved when an ML response arrives; set before SubscribeAll
+	Pending *PendingInferences
+
+	// Optional tracker resolved when an actuator acknowledges a window command; set before SubscribeAll
+	Commands *CommandTracker
+
+	// Optional tracer recording sampled message lifecycles; set before SubscribeAll
+	Tracer *Tracer
+
+	// Optional per-device message statistics; set before SubscribeAll
+	Stats *MessageStats
+
+	// Optional cipher for encrypted audio payloads; set before SubscribeAll
+	Cipher *PayloadCipher
+
+	// Optional reassembly of clips uploaded in chunks; set before SubscribeAll
+	Uploads *AudioUploads
+
+	// Optional store for connection gaps; set before SubscribeAll
+	Gaps ConnectionGapSink
+
+	// Optional verification of device identity by payload token; set before SubscribeAll
+	Auth *DeviceAuthenticator
+
+	// Subscriptions restored after every reconnect, and the gaps open since the connection dropped
+	subsMu  sync.Mutex
+	subs    []activeSubscription
+	openGap map[string]*models.ConnectionGap // Topic filter → open gap
+}
+
+// DeadLetterSink stores rejected messages
+type DeadLetterSink interface {
+	SaveDeadLetter(letter *models.DeadLetter) error
+}
+
+// ModelPredictionSink stores responses of additional ML models
+type ModelPredictionSink interface {
+	SaveModelPrediction(prediction *models.ModelPrediction) error
 }
 
 // SubscriberConfig holds configuration for MQTT subscriber
@@ -34,36 +123,84 @@ type SubscriberConfig struct {
 	TemperatureTopic   string // e.g., "sensor/+/temperature"
 	HumidityTopic      string // e.g., "sensor/+/humidity"
 	AudioTopic         string // e.g., "sensor/+/audio"
+	AudioChunkTopic    string // e.g., "sensor/+/audio/chunk" (clips uploaded in resumable chunks)
 	WindowControlTopic string // e.g., "window/+/control"
+	WindowAckTopic     string // e.g., "window/+/ack" (actuator acknowledgements of window commands)
+	SafetyTopic        string // e.g., "sensor/+/safety"
+	FrameTopic         string // e.g., "sensor/+/frame" (packed binary readings)
+	BackfillTopic      string // e.g., "sensor/+/backfill" (readings buffered while offline)
+	BootTopic          string // e.g., "sensor/+/boot"
+	MotionTopic        string // e.g., "sensor/+/motion" (PIR, payload 1 = triggered, 0 = idle)
+	CO2Topic           string // e.g., "sensor/+/co2" (ppm)
+	FrameLayout        *FrameLayout
+	LoRaWANTopic       string // e.g., "v3/+/devices/+/up" (TTN) or "application/+/device/+/event/up" (ChirpStack)
+	LoRaWANCodecs      *LoRaWANCodecs
+	Models             []MLModel // Additional ML models (responses on each model's ResponseTopic)
+	Domains            []MLModel // Inference domain models (responses arbitrated with the window model's)
+	Tenant             string    // Value of the {tenant} placeholder (empty subscribes to all tenants)
 }
 
-// NewSubscriber creates a new MQTT subscriber with channels
+// NewSubscriber creates a new MQTT subscriber
 func NewSubscriber(
 	client mqtt.Client,
 	config SubscriberConfig,
-	tempChan chan *models.TemperatureReading,
-	humidityChan chan *models.HumidityReading,
-	audioChan chan *models.AudioRecording,
-	windowControlChan chan *models.InferenceResponse,
+	windowControlChan chan<- *models.InferenceResponse,
 ) *Subscriber {
+	codecs := config.LoRaWANCodecs
+	if codecs == nil {
+		// Without codecs only network-server-decoded payloads are accepted
+		codecs = &LoRaWANCodecs{}
+	}
+
 	return &Subscriber{
 		client:             client,
-		TempChan:           tempChan,
-		HumidityChan:       humidityChan,
-		AudioChan:          audioChan,
 		WindowControlChan:  windowControlChan,
 		temperatureTopic:   config.TemperatureTopic,
 		humidityTopic:      config.HumidityTopic,
 		audioTopic:         config.AudioTopic,
+		audioChunkTopic:    config.AudioChunkTopic,
 		windowControlTopic: config.WindowControlTopic,
+		windowAckTopic:     config.WindowAckTopic,
+		safetyTopic:        config.SafetyTopic,
+		frameTopic:         config.FrameTopic,
+		backfillTopic:      config.BackfillTopic,
+		bootTopic:          config.BootTopic,
+		motionTopic:        config.MotionTopic,
+		co2Topic:           config.CO2Topic,
+		frameLayout:        config.FrameLayout,
+		loraWANTopic:       config.LoRaWANTopic,
+		loraWANCodecs:      codecs,
+		mlModels:           config.Models,
+		domains:            config.Domains,
+		tenant:             config.Tenant,
 	}
 }
 
+// Name identifies the subscriber as an ingestor
+func (s *Subscriber) Name() string {
+	return "mqtt"
+}
+
+// Start subscribes to all configured topics, handing readings to pipeline. The
+// subscriptions live as long as the client; ctx is not watched.
+func (s *Subscriber) Start(ctx context.Context, pipeline ingest.Pipeline) error {
+	s.pipeline = pipeline
+	return s.SubscribeAll()
+}
+
 // SubscribeAll subscribes to all configured sensor topics
 func (s *Subscriber) SubscribeAll() error {
+	// Subscribe to safety topic first so safety events are never missed
+	if s.safetyTopic != "" {
+		if err := s.subscribeToTopic(StreamSafety, s.safetyTopic, s.handleSafety); err != nil {
+			return fmt.Errorf("failed to subscribe to safety topic: %w", err)
+		}
+		log.Printf("Subscribed to safety topic: %s", s.safetyTopic)
+	}
+
 	// Subscribe to temperature topic
 	if s.temperatureTopic != "" {
-		if err := s.subscribeToTopic(s.temperatureTopic, s.handleTemperature); err != nil {
+		if err := s.subscribeToTopic(StreamTemperature, s.temperatureTopic, s.handleTemperature); err != nil {
 			return fmt.Errorf("failed to subscribe to temperature topic: %w", err)
 		}
 		log.Printf("Subscribed to temperature topic: %s", s.temperatureTopic)
@@ -71,7 +208,7 @@ func (s *Subscriber) SubscribeAll() error {
 
 	// Subscribe to humidity topic
 	if s.humidityTopic != "" {
-		if err := s.subscribeToTopic(s.humidityTopic, s.handleHumidity); err != nil {
+		if err := s.subscribeToTopic(StreamHumidity, s.humidityTopic, s.handleHumidity); err != nil {
 			return fmt.Errorf("failed to subscribe to humidity topic: %w", err)
 		}
 		log.Printf("Subscribed to humidity topic: %s", s.humidityTopic)
@@ -79,45 +216,161 @@ func (s *Subscriber) SubscribeAll() error {
 
 	// Subscribe to audio topic
 	if s.audioTopic != "" {
-		if err := s.subscribeToTopic(s.audioTopic, s.handleAudio); err != nil {
+		if err := s.subscribeToTopic(StreamAudio, s.audioTopic, s.handleAudio); err != nil {
 			return fmt.Errorf("failed to subscribe to audio topic: %w", err)
 		}
 		log.Printf("Subscribed to audio topic: %s", s.audioTopic)
 	}
 
+	// Subscribe to chunked audio uploads (only when reassembly is wired)
+	if s.audioChunkTopic != "" && s.Uploads != nil {
+		if err := s.subscribeToTopic(StreamAudioChunk, s.audioChunkTopic, s.handleAudioChunk); err != nil {
+			return fmt.Errorf("failed to subscribe to audio chunk topic: %w", err)
+		}
+		log.Printf("Subscribed to audio chunk topic: %s", s.audioChunkTopic)
+
+		// Clips whose last chunk arrived just before a restart won't get another chunk
+		for _, clip := range s.Uploads.TakeRestored() {
+			log.Printf("Forwarding audio clip %s from %s reassembled after restart", clip.ClipID, clip.DeviceID)
+			s.forwardAudio(nil, clip.DeviceID, clip.Payload)
+		}
+	}
+
+	// Subscribe to binary frame topic (only when a layout is configured)
+	if s.frameTopic != "" && s.frameLayout != nil {
+		if err := s.subscribeToTopic(StreamFrame, s.frameTopic, s.handleFrame); err != nil {
+			return fmt.Errorf("failed to subscribe to frame topic: %w", err)
+		}
+		log.Printf("Subscribed to binary frame topic: %s (%d bytes/frame)", s.frameTopic, s.frameLayout.Size())
+	}
+
+	// Subscribe to replayed offline buffers (only when a consumer is wired)
+	if s.backfillTopic != "" && s.BackfillChan != nil {
+		if err := s.subscribeToTopic(StreamBackfill, s.backfillTopic, s.handleBackfill); err != nil {
+			return fmt.Errorf("failed to subscribe to backfill topic: %w", err)
+		}
+		log.Printf("Subscribed to backfill topic: %s", s.backfillTopic)
+	}
+
+	// Subscribe to LoRaWAN uplinks forwarded by the network server
+	if s.loraWANTopic != "" {
+		if err := s.subscribeToTopic(StreamLoRaWAN, s.loraWANTopic, s.handleLoRaWAN); err != nil {
+			return fmt.Errorf("failed to subscribe to LoRaWAN topic: %w", err)
+		}
+		log.Printf("Subscribed to LoRaWAN uplink topic: %s", s.loraWANTopic)
+	}
+
+	// Subscribe to boot announcements (only when a consumer is wired)
+	if s.bootTopic != "" && s.BootChan != nil {
+		if err := s.subscribeToTopic(StreamBoot, s.bootTopic, s.handleBoot); err != nil {
+			return fmt.Errorf("failed to subscribe to boot topic: %w", err)
+		}
+		log.Printf("Subscribed to boot topic: %s", s.bootTopic)
+	}
+
+	// Subscribe to presence sensors
+	if s.motionTopic != "" {
+		if err := s.subscribeToTopic(StreamMotion, s.motionTopic, s.presenceHandler(models.PresenceMotion)); err != nil {
+			return fmt.Errorf("failed to subscribe to motion topic: %w", err)
+		}
+		log.Printf("Subscribed to motion topic: %s", s.motionTopic)
+	}
+	if s.co2Topic != "" {
+		if err := s.subscribeToTopic(StreamCO2, s.co2Topic, s.presenceHandler(models.PresenceCO2)); err != nil {
+			return fmt.Errorf("failed to subscribe to CO2 topic: %w", err)
+		}
+		log.Printf("Subscribed to CO2 topic: %s", s.co2Topic)
+	}
+
 	// Subscribe to window control topic for logging
 	if s.windowControlTopic != "" {
-		if err := s.subscribeToTopic(s.windowControlTopic, s.handleWindowControl); err != nil {
+		if err := s.subscribeToTopic(StreamWindowControl, s.windowControlTopic, s.handleWindowControl); err != nil {
 			return fmt.Errorf("failed to subscribe to window control topic: %w", err)
 		}
 		log.Printf("Subscribed to window control topic: %s", s.windowControlTopic)
 	}
 
+	// Subscribe to window command acks (only when commands are tracked)
+	if s.windowAckTopic != "" && s.Commands != nil {
+		if err := s.subscribeToTopic(StreamWindowAck, s.windowAckTopic, s.handleWindowAck); err != nil {
+			return fmt.Errorf("failed to subscribe to window ack topic: %w", err)
+		}
+		log.Printf("Subscribed to window ack topic: %s", s.windowAckTopic)
+	}
+
+	for _, model := range s.mlModels {
+		if err := s.subscribeToTopic(modelStream(model), model.ResponseTopic, s.modelResponseHandler(model)); err != nil {
+			return fmt.Errorf("failed to subscribe to %s model response topic: %w", model.Name, err)
+		}
+		log.Printf("Subscribed to %s model response topic: %s", model.Name, model.ResponseTopic)
+	}
+
+	for _, domain := range s.domains {
+		if err := s.subscribeToTopic(domainStream(domain), domain.ResponseTopic, s.domainResponseHandler(domain)); err != nil {
+			return fmt.Errorf("failed to subscribe to %s domain response topic: %w", domain.Name, err)
+		}
+		log.Printf("Subscribed to %s domain response topic: %s", domain.Name, domain.ResponseTopic)
+	}
+
 	return nil
 }
 
-// subscribeToTopic is a helper function to subscribe to a topic with a handler
-func (s *Subscriber) subscribeToTopic(topic string, handler mqtt.MessageHandler) error {
-	token := s.client.Subscribe(topic, 1, handler)
-	if token.Wait() && token.Error() != nil {
-		return token.Error()
+// subscribeToTopic subscribes a handler to a topic template. Handlers read the
+// values captured from each message's topic with topicVars. The subscription is
+// remembered so Resubscribe can restore it after a reconnect.
+func (s *Subscriber) subscribeToTopic(stream, topic string, handler mqtt.MessageHandler) error {
+	template, err := ParseTopicTemplate(topic)
+	if err != nil {
+		return err
 	}
+	filter := template.Filter(TopicVars{Tenant: s.tenant})
+
+	if s.Tracer != nil {
+		handler = s.Tracer.Wrap(filter, handler)
+	}
+	inner := handler
+	handler = func(client mqtt.Client, msg mqtt.Message) {
+		vars, ok := template.Match(msg.Topic())
+		tm := &templatedMessage{Message: msg, vars: vars}
+		switch {
+		case !ok:
+			err := invalidPayload(msg, fmt.Errorf("topic %s doesn't match %s", msg.Topic(), template))
+			log.Printf("Dropping message: %v", err)
+			tm.outcome = "parse_error"
+		case s.authenticate(stream, tm):
+			inner(client, tm)
+		}
+		if s.Stats != nil {
+			s.Stats.record(vars.DeviceID, len(msg.Payload()), tm.outcome, time.Now())
+		}
+	}
+
+	token := s.client.Subscribe(filter, 1, handler)
+	if err := waitToken("subscribe", filter, token); err != nil {
+		return err
+	}
+
+	s.subsMu.Lock()
+	s.subs = append(s.subs, activeSubscription{stream: stream, filter: filter, handler: handler})
+	s.subsMu.Unlock()
 	return nil
 }
 
-// handleTemperature processes temperature sensor messages and writes to channel
+// handleTemperature processes temperature sensor messages and hands them to the pipeline
 func (s *Subscriber) handleTemperature(client mqtt.Client, msg mqtt.Message) {
-	// Parse raw float value from payload
-	var value float64
-	if _, err := fmt.Sscanf(string(msg.Payload()), "%f", &value); err != nil {
+	// Parse raw float value from payload, optionally followed by a unit ("72.5F")
+	value, unit, err := parseTemperaturePayload(string(msg.Payload()))
+	if err != nil {
+		err = invalidPayload(msg, err)
 		log.Printf("Error parsing temperature value: %v", err)
 		return
 	}
 
 	// Extract device ID from topic (sensor/{device_id}/temperature)
-	deviceID := extractDeviceID(msg.Topic())
+	deviceID := topicVars(msg).DeviceID
 	if deviceID == "" {
 		log.Printf("Could not extract device ID from topic: %s", msg.Topic())
+		traceOf(msg).decide("no_device_id", "no device ID in topic")
 		return
 	}
 
@@ -128,32 +381,51 @@ func (s *Subscriber) handleTemperature(client mqtt.Client, msg mqtt.Message) {
 		Timestamp: timestamp,
 		DeviceID:  deviceID,
 		Value:     value,
+		Unit:      unit,
 	}
 
-	log.Printf("Received temperature from %s: %.2f°C", deviceID, value)
+	log.Printf("Received temperature from %s: %.2f%s", deviceID, value, unit)
+	traceOf(msg).notef("parsed temperature=%.2f%s device=%s", value, unit, deviceID)
 
-	// Write to channel (non-blocking with timeout)
-	select {
-	case s.TempChan <- reading:
-		// Successfully sent
-	case <-time.After(1 * time.Second):
-		log.Printf("Warning: Temperature channel full, dropping message from %s", deviceID)
+	s.forwarded(msg, "temperature", deviceID, s.pipeline.Temperature(reading))
+}
+
+// parseTemperaturePayload parses a plain number with an optional unit suffix such as
+// "21.5", "72.5F", or "72.5 °F". The unit is empty when the payload names none.
+func parseTemperaturePayload(payload string) (float64, string, error) {
+	payload = strings.TrimSpace(payload)
+	end := strings.LastIndexFunc(payload, func(r rune) bool { return r >= '0' && r <= '9' || r == '.' })
+	number, suffix := payload[:end+1], strings.TrimSpace(payload[end+1:])
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid temperature %q", payload)
 	}
+	if suffix == "" {
+		return value, "", nil
+	}
+	unit, err := models.ParseTemperatureUnit(suffix)
+	if err != nil {
+		return 0, "", err
+	}
+	return value, unit, nil
 }
 
-// handleHumidity processes humidity sensor messages and writes to channel
+// handleHumidity processes humidity sensor messages and hands them to the pipeline
 func (s *Subscriber) handleHumidity(client mqtt.Client, msg mqtt.Message) {
 	// Parse raw float value from payload
 	var value float64
 	if _, err := fmt.Sscanf(string(msg.Payload()), "%f", &value); err != nil {
+		err = invalidPayload(msg, err)
 		log.Printf("Error parsing humidity value: %v", err)
 		return
 	}
 
 	// Extract device ID from topic (sensor/{device_id}/humidity)
-	deviceID := extractDeviceID(msg.Topic())
+	deviceID := topicVars(msg).DeviceID
 	if deviceID == "" {
 		log.Printf("Could not extract device ID from topic: %s", msg.Topic())
+		traceOf(msg).decide("no_device_id", "no device ID in topic")
 		return
 	}
 
@@ -167,32 +439,88 @@ func (s *Subscriber) handleHumidity(client mqtt.Client, msg mqtt.Message) {
 	}
 
 	log.Printf("Received humidity from %s: %.2f%%", deviceID, value)
+	traceOf(msg).notef("parsed humidity=%.2f device=%s", value, deviceID)
 
-	// Write to channel (non-blocking with timeout)
-	select {
-	case s.HumidityChan <- reading:
-		// Successfully sent
-	case <-time.After(1 * time.Second):
-		log.Printf("Warning: Humidity channel full, dropping message from %s", deviceID)
-	}
+	s.forwarded(msg, "humidity", deviceID, s.pipeline.Humidity(reading))
 }
 
-// handleAudio processes audio sensor messages and writes to channel
+// handleAudio processes audio sensor messages and hands them to the pipeline
 func (s *Subscriber) handleAudio(client mqtt.Client, msg mqtt.Message) {
-	var payload models.AudioPayload
+	// Extract device ID from topic (sensor/{device_id}/audio)
+	deviceID := topicVars(msg).DeviceID
+	if deviceID == "" {
+		log.Printf("Could not extract device ID from topic: %s", msg.Topic())
+		traceOf(msg).decide("no_device_id", "no device ID in topic")
+		return
+	}
 
-	if err := json.Unmarshal(msg.Payload(), &payload); err != nil {
+	// Devices with a payload key encrypt their audio end to end
+	data := msg.Payload()
+	if s.Cipher != nil {
+		plaintext, err := s.Cipher.Open(deviceID, data)
+		if err != nil {
+			err = invalidPayload(msg, err)
+			log.Printf("Error decrypting audio from %s: %v", deviceID, err)
+			return
+		}
+		data = plaintext
+	}
+
+	var payload models.AudioPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		err = invalidPayload(msg, err)
 		log.Printf("Error unmarshaling audio data: %v", err)
 		return
 	}
+	s.forwardAudio(msg, deviceID, &payload)
+}
 
-	// Extract device ID from topic (sensor/{device_id}/audio)
-	deviceID := extractDeviceID(msg.Topic())
+// handleAudioChunk processes a chunk of a clip uploaded in chunks; the completed
+// clip is forwarded like one sent whole
+func (s *Subscriber) handleAudioChunk(client mqtt.Client, msg mqtt.Message) {
+	// Extract device ID from topic (sensor/{device_id}/audio/chunk)
+	deviceID := topicVars(msg).DeviceID
 	if deviceID == "" {
 		log.Printf("Could not extract device ID from topic: %s", msg.Topic())
+		traceOf(msg).decide("no_device_id", "no device ID in topic")
 		return
 	}
 
+	data := msg.Payload()
+	if s.Cipher != nil {
+		plaintext, err := s.Cipher.Open(deviceID, data)
+		if err != nil {
+			err = invalidPayload(msg, err)
+			log.Printf("Error decrypting audio chunk from %s: %v", deviceID, err)
+			return
+		}
+		data = plaintext
+	}
+
+	var chunk models.AudioChunk
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		err = invalidPayload(msg, err)
+		log.Printf("Error unmarshaling audio chunk: %v", err)
+		return
+	}
+
+	payload, err := s.Uploads.Receive(deviceID, &chunk)
+	if err != nil {
+		err = invalidPayload(msg, err)
+		log.Printf("Error receiving audio chunk from %s: %v", deviceID, err)
+		return
+	}
+	if payload == nil {
+		traceOf(msg).decide("buffered", fmt.Sprintf("chunk %d of %d of clip %s", chunk.Index, chunk.Total, chunk.ClipID))
+		return
+	}
+	traceOf(msg).notef("reassembled clip %s from %d chunks", chunk.ClipID, chunk.Total)
+	s.forwardAudio(msg, deviceID, payload)
+}
+
+// forwardAudio turns a device's clip into a recording and hands it to the pipeline;
+// msg is nil for a clip not completed by a message (reassembled after a restart)
+func (s *Subscriber) forwardAudio(msg mqtt.Message, deviceID string, payload *models.AudioPayload) {
 	// Generate timestamp server-side
 	timestamp := time.Now()
 
@@ -204,18 +532,33 @@ func (s *Subscriber) handleAudio(client mqtt.Client, msg mqtt.Message) {
 		DataBase64: base64.StdEncoding.EncodeToString(payload.Data),
 		SampleRate: payload.SampleRate,
 		Duration:   payload.Duration,
-		Format:     "wav", // Default format
+		Format:     "pcm",
+		Channels:   max(payload.Channels, 1),
+		AGCGainDB:  payload.AGCGainDB,
 	}
 
-	log.Printf("Received audio from %s: %.2fs @ %dHz", deviceID, payload.Duration, payload.SampleRate)
-
-	// Write to channel (non-blocking with timeout)
-	select {
-	case s.AudioChan <- recording:
-		// Successfully sent
-	case <-time.After(2 * time.Second): // Longer timeout for audio
-		log.Printf("Warning: Audio channel full, dropping message from %s", deviceID)
+	// WAV files describe their own format; the header wins over the payload fields
+	if aggregator.IsWAV(payload.Data) {
+		format, pcm, err := aggregator.ParseWAV(payload.Data)
+		if err == nil && format.BitsPerSample != 16 {
+			err = fmt.Errorf("unsupported %d-bit WAV (want 16-bit PCM)", format.BitsPerSample)
+		}
+		if err != nil {
+			err = invalidPayload(msg, err)
+			log.Printf("Error parsing WAV audio from %s: %v", deviceID, err)
+			return
+		}
+		recording.Data = pcm
+		recording.SampleRate = format.SampleRate
+		recording.Channels = max(format.Channels, 1)
+		recording.Format = "wav"
 	}
+
+	log.Printf("Received audio from %s: %.2fs @ %dHz, %d channel(s)", deviceID, payload.Duration, recording.SampleRate, recording.Channels)
+	traceOf(msg).notef("parsed audio bytes=%d sample_rate=%d channels=%d format=%s duration=%.2f device=%s",
+		len(recording.Data), recording.SampleRate, recording.Channels, recording.Format, payload.Duration, deviceID)
+
+	s.forwarded(msg, "audio", deviceID, s.pipeline.Audio(recording))
 }
 
 // handleWindowControl processes window control responses from ML service and writes to channel
@@ -223,28 +566,473 @@ func (s *Subscriber) handleWindowControl(client mqtt.Client, msg mqtt.Message) {
 	var response models.InferenceResponse
 
 	if err := json.Unmarshal(msg.Payload(), &response); err != nil {
+		err = invalidPayload(msg, err)
 		log.Printf("Error unmarshaling window control response: %v", err)
+		s.deadLetter("window_control", msg, err)
 		return
 	}
 
 	// Extract device ID from topic if not in payload
 	if response.DeviceID == "" {
-		response.DeviceID = extractDeviceID(msg.Topic())
+		response.DeviceID = topicVars(msg).DeviceID
+	}
+
+	if s.InferenceRouter != nil {
+		s.InferenceRouter.Complete(response.DeviceID)
+	}
+	if s.Pending != nil {
+		if rtt, ok := s.Pending.ResolveResponse(&response); ok {
+			metrics.Default.Timer("inference_round_trip").Observe(rtt)
+		}
 	}
 
 	log.Printf("Received window control for %s: position=%.2f%%, confidence=%.2f",
 		response.DeviceID, response.Position, response.Confidence)
+	s.forwardWindowControl(msg, &response)
+}
+
+// domainResponseHandler returns the handler for an inference domain model's responses,
+// which are arbitrated with the other domains' like window control responses
+func (s *Subscriber) domainResponseHandler(domain MLModel) mqtt.MessageHandler {
+	source := "domain_" + domain.Name
+	return func(client mqtt.Client, msg mqtt.Message) {
+		var response models.InferenceResponse
+		if err := json.Unmarshal(msg.Payload(), &response); err != nil {
+			err = invalidPayload(msg, err)
+			log.Printf("Error unmarshaling %s domain response: %v", domain.Name, err)
+			s.deadLetter(source, msg, err)
+			return
+		}
+
+		// The domain comes from the topic, so a misconfigured service can't answer for another domain
+		response.Domain = domain.Name
+		if response.DeviceID == "" {
+			response.DeviceID = topicVars(msg).DeviceID
+		}
+		if s.Pending != nil {
+			if rtt, ok := s.Pending.ResolveResponse(&response); ok {
+				metrics.Default.Timer("inference_round_trip_" + domain.Name).Observe(rtt)
+			}
+		}
+
+		log.Printf("Received %s domain window control for %s: position=%.2f%%, confidence=%.2f",
+			domain.Name, response.DeviceID, response.Position, response.Confidence)
+		s.forwardWindowControl(msg, &response)
+	}
+}
+
+// forwardWindowControl queues a parsed window control response for arbitration
+func (s *Subscriber) forwardWindowControl(msg mqtt.Message, response *models.InferenceResponse) {
+	traceOf(msg).notef("parsed position=%.2f confidence=%.2f correlation_id=%s device=%s domain=%s",
+		response.Position, response.Confidence, response.CorrelationID, response.DeviceID, response.Domain)
 
 	// Write to channel (non-blocking with timeout)
 	select {
-	case s.WindowControlChan <- &response:
-		// Successfully sent
+	case s.WindowControlChan <- response:
+		traceOf(msg).decide("forwarded", "queued")
 	case <-time.After(1 * time.Second):
 		log.Printf("Warning: Window control channel full, dropping message for %s", response.DeviceID)
+		dropMessage(msg, "channel full")
 	}
 }
 
-// extractDeviceID extracts device ID from MQTT topic
+// handleWindowAck resolves the window command an actuator acknowledged
+func (s *Subscriber) handleWindowAck(client mqtt.Client, msg mqtt.Message) {
+	var ack models.WindowCommandAck
+	if err := json.Unmarshal(msg.Payload(), &ack); err != nil {
+		err = invalidPayload(msg, err)
+		log.Printf("Error unmarshaling window command ack: %v", err)
+		s.deadLetter("window_ack", msg, err)
+		return
+	}
+	if ack.CommandID == "" {
+		err := invalidPayload(msg, fmt.Errorf("window command ack without command_id"))
+		log.Printf("Error parsing window command ack: %v", err)
+		s.deadLetter("window_ack", msg, err)
+		return
+	}
+	if ack.Status != "" && ack.Status != models.AckApplied && ack.Status != models.AckRejected {
+		err := invalidPayload(msg, fmt.Errorf("unknown window command ack status %q", ack.Status))
+		log.Printf("Error parsing window command ack: %v", err)
+		s.deadLetter("window_ack", msg, err)
+		return
+	}
+
+	// The topic names the device, whatever the payload claims
+	ack.DeviceID = topicVars(msg).DeviceID
+	if ack.Timestamp.IsZero() {
+		ack.Timestamp = time.Now()
+	}
+	traceOf(msg).notef("parsed ack command_id=%s status=%s device=%s", ack.CommandID, ack.Status, ack.DeviceID)
+
+	if outcome := s.Commands.Ack(&ack); outcome != nil {
+		traceOf(msg).decide("forwarded", "%s", outcome.Outcome)
+	} else {
+		dropMessage(msg, "repeated, unknown or foreign command_id")
+	}
+}
+
+// modelResponseHandler returns the handler for an additional ML model's responses
+func (s *Subscriber) modelResponseHandler(model MLModel) mqtt.MessageHandler {
+	source := "model_" + model.Name
+	return func(client mqtt.Client, msg mqtt.Message) {
+		var prediction models.ModelPrediction
+		if err := json.Unmarshal(msg.Payload(), &prediction); err != nil {
+			err = invalidPayload(msg, err)
+			log.Printf("Error unmarshaling %s model response: %v", model.Name, err)
+			s.deadLetter(source, msg, err)
+			return
+		}
+
+		// The model name comes from the topic, so a misconfigured service can't file results under another model
+		prediction.Model = model.Name
+		if prediction.DeviceID == "" {
+			prediction.DeviceID = topicVars(msg).DeviceID
+		}
+		if prediction.Timestamp.IsZero() {
+			prediction.Timestamp = time.Now()
+		}
+		if s.Pending != nil {
+			if rtt, ok := s.Pending.Resolve(prediction.CorrelationID, prediction.DeviceID); ok {
+				metrics.Default.Timer("inference_round_trip_" + model.Name).Observe(rtt)
+			}
+		}
+
+		log.Printf("Received %s model response for %s: prediction=%.2f, confidence=%.2f",
+			model.Name, prediction.DeviceID, prediction.Prediction, prediction.Confidence)
+		if s.ModelPredictions == nil {
+			dropMessage(msg, "no model prediction store")
+			return
+		}
+		if err := s.ModelPredictions.SaveModelPrediction(&prediction); err != nil {
+			log.Printf("Error saving %s model prediction: %v", model.Name, err)
+			dropMessage(msg, "save failed: %v", err)
+			return
+		}
+		traceOf(msg).decide("forwarded", "stored %s prediction device=%s", model.Name, prediction.DeviceID)
+	}
+}
+
+// handleFrame decodes a packed binary frame and fans its fields out to the reading channels
+func (s *Subscriber) handleFrame(client mqtt.Client, msg mqtt.Message) {
+	values, err := s.frameLayout.Decode(msg.Payload())
+	if err != nil {
+		err = invalidPayload(msg, err)
+		log.Printf("Error decoding binary frame: %v", err)
+		return
+	}
+
+	// Extract device ID from topic (sensor/{device_id}/frame)
+	deviceID := topicVars(msg).DeviceID
+	if deviceID == "" {
+		log.Printf("Could not extract device ID from topic: %s", msg.Topic())
+		traceOf(msg).decide("no_device_id", "no device ID in topic")
+		return
+	}
+
+	// Generate timestamp server-side
+	timestamp := time.Now()
+
+	log.Printf("Received binary frame from %s: %v", deviceID, values)
+	traceOf(msg).decide("forwarded", "decoded %v device=%s", values, deviceID)
+
+	s.forwardValues(deviceID, timestamp, values)
+}
+
+// maxBackfillReadings bounds the entries accepted in one backfill message
+const maxBackfillReadings = 10000
+
+// handleBackfill processes readings a device buffered while offline. Unlike live
+// readings they keep the timestamps the device recorded, and go to their own channel
+// so they're stored without reaching real-time consumers.
+func (s *Subscriber) handleBackfill(client mqtt.Client, msg mqtt.Message) {
+	var payload models.BackfillPayload
+	if err := json.Unmarshal(msg.Payload(), &payload); err != nil {
+		err = invalidPayload(msg, err)
+		log.Printf("Error unmarshaling backfill: %v", err)
+		s.deadLetter("backfill", msg, err)
+		return
+	}
+	if len(payload.Readings) > maxBackfillReadings {
+		err := invalidPayload(msg, fmt.Errorf("%d readings exceed the limit of %d per message", len(payload.Readings), maxBackfillReadings))
+		log.Printf("Error parsing backfill: %v", err)
+		s.deadLetter("backfill", msg, err)
+		return
+	}
+	unit := ""
+	if payload.Unit != "" {
+		var err error
+		if unit, err = models.ParseTemperatureUnit(payload.Unit); err != nil {
+			err = invalidPayload(msg, err)
+			log.Printf("Error parsing backfill: %v", err)
+			s.deadLetter("backfill", msg, err)
+			return
+		}
+	}
+
+	// Extract device ID from topic (sensor/{device_id}/backfill)
+	deviceID := topicVars(msg).DeviceID
+	if deviceID == "" {
+		log.Printf("Could not extract device ID from topic: %s", msg.Topic())
+		traceOf(msg).decide("no_device_id", "no device ID in topic")
+		return
+	}
+
+	hash := sha256.Sum256(msg.Payload())
+	batch := &models.BackfillBatch{
+		DeviceID:    deviceID,
+		ReceivedAt:  time.Now(),
+		PayloadHash: hex.EncodeToString(hash[:]),
+	}
+	var untimed int
+	for _, entry := range payload.Readings {
+		if entry.Timestamp <= 0 {
+			untimed++
+			continue
+		}
+		timestamp := time.UnixMilli(int64(entry.Timestamp * 1000))
+		if entry.Temperature != nil {
+			batch.Temperature = append(batch.Temperature, &models.TemperatureReading{
+				Timestamp: timestamp, DeviceID: deviceID, Value: *entry.Temperature, Unit: unit,
+			})
+		}
+		if entry.Humidity != nil {
+			batch.Humidity = append(batch.Humidity, &models.HumidityReading{
+				Timestamp: timestamp, DeviceID: deviceID, Value: *entry.Humidity,
+			})
+		}
+	}
+	if untimed > 0 {
+		metrics.Default.Counter("backfill_readings_untimed").Add(int64(untimed))
+		log.Printf("Warning: Backfill from %s has %d entries without a timestamp, skipped", deviceID, untimed)
+	}
+
+	log.Printf("Received backfill from %s: %d temperature, %d humidity readings",
+		deviceID, len(batch.Temperature), len(batch.Humidity))
+	traceOf(msg).notef("parsed backfill temperature=%d humidity=%d device=%s", len(batch.Temperature), len(batch.Humidity), deviceID)
+
+	select {
+	case s.BackfillChan <- batch:
+		traceOf(msg).decide("forwarded", "queued")
+	case <-time.After(1 * time.Second):
+		log.Printf("Warning: Backfill channel full, dropping backfill from %s", deviceID)
+		dropMessage(msg, "channel full")
+	}
+}
+
+// handleLoRaWAN processes network server uplinks and maps decoded values to readings
+func (s *Subscriber) handleLoRaWAN(client mqtt.Client, msg mqtt.Message) {
+	uplink, err := ParseLoRaWANUplink(msg.Payload())
+	if err != nil {
+		err = invalidPayload(msg, err)
+		log.Printf("Error parsing LoRaWAN uplink: %v", err)
+		s.deadLetter("lorawan", msg, err)
+		return
+	}
+
+	values, err := s.loraWANCodecs.Decode(uplink)
+	if err != nil {
+		err = errs.Wrap(errs.ErrPayloadInvalid, err)
+		log.Printf("Error decoding LoRaWAN uplink from %s: %v", uplink.DeviceID, err)
+		s.deadLetter("lorawan", msg, err)
+		return
+	}
+
+	// Prefer the network server timestamp; uplinks can be delayed in the gateway backhaul
+	timestamp := uplink.ReceivedAt
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	log.Printf("Received LoRaWAN uplink from %s (fport=%d): %v", uplink.DeviceID, uplink.FPort, values)
+	traceOf(msg).decide("forwarded", "decoded %v device=%s fport=%d", values, uplink.DeviceID, uplink.FPort)
+
+	s.forwardValues(uplink.DeviceID, timestamp, values)
+}
+
+// handleBoot processes device boot announcements. The payload is optional JSON
+// (e.g., {"firmware":"1.4.0"}); an empty or plain-text payload is still a valid boot.
+func (s *Subscriber) handleBoot(client mqtt.Client, msg mqtt.Message) {
+	// Extract device ID from topic (sensor/{device_id}/boot)
+	deviceID := topicVars(msg).DeviceID
+	if deviceID == "" {
+		log.Printf("Could not extract device ID from topic: %s", msg.Topic())
+		traceOf(msg).decide("no_device_id", "no device ID in topic")
+		return
+	}
+
+	boot := &models.DeviceBoot{}
+	if len(msg.Payload()) > 0 {
+		if err := json.Unmarshal(msg.Payload(), boot); err != nil {
+			boot.Firmware = strings.TrimSpace(string(msg.Payload()))
+		}
+	}
+	boot.DeviceID = deviceID
+	boot.Timestamp = time.Now()
+
+	log.Printf("Received boot from %s (firmware=%q)", deviceID, boot.Firmware)
+	traceOf(msg).notef("parsed boot firmware=%q device=%s", boot.Firmware, deviceID)
+
+	select {
+	case s.BootChan <- boot:
+		traceOf(msg).decide("forwarded", "queued")
+	case <-time.After(1 * time.Second):
+		log.Printf("Warning: Boot channel full, dropping boot from %s", deviceID)
+		dropMessage(msg, "channel full")
+	}
+}
+
+// presenceHandler returns a handler for raw motion or CO2 values
+// (the PIR payload may also be "true"/"false")
+func (s *Subscriber) presenceHandler(kind string) mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
+		raw := strings.TrimSpace(string(msg.Payload()))
+		var value float64
+		switch {
+		case kind == models.PresenceMotion && strings.EqualFold(raw, "true"):
+			value = 1
+		case kind == models.PresenceMotion && strings.EqualFold(raw, "false"):
+			value = 0
+		default:
+			if _, err := fmt.Sscanf(raw, "%f", &value); err != nil {
+				err = invalidPayload(msg, err)
+				log.Printf("Error parsing %s value: %v", kind, err)
+				return
+			}
+		}
+
+		// Extract device ID from topic (sensor/{device_id}/motion)
+		deviceID := topicVars(msg).DeviceID
+		if deviceID == "" {
+			log.Printf("Could not extract device ID from topic: %s", msg.Topic())
+			traceOf(msg).decide("no_device_id", "no device ID in topic")
+			return
+		}
+
+		traceOf(msg).notef("parsed %s=%.2f device=%s", kind, value, deviceID)
+		s.forwarded(msg, kind, deviceID, s.pipeline.Presence(&models.PresenceReading{
+			Timestamp: time.Now(),
+			DeviceID:  deviceID,
+			Kind:      kind,
+			Value:     value,
+		}))
+	}
+}
+
+// forwardValues hands decoded "temperature", "humidity", "motion", and "co2" values to the pipeline
+func (s *Subscriber) forwardValues(deviceID string, timestamp time.Time, values map[string]float64) {
+	for _, kind := range []string{"temperature", "humidity", models.PresenceMotion, models.PresenceCO2} {
+		value, ok := values[kind]
+		if !ok {
+			continue
+		}
+		reading := ingest.Reading{DeviceID: deviceID, Type: kind, Value: value, Timestamp: timestamp}
+		if err := ingest.Submit(s.pipeline, reading, timestamp); err != nil {
+			log.Printf("Warning: Dropping decoded %s value from %s: %v", kind, deviceID, err)
+		}
+	}
+}
+
+// forwarded records the pipeline's verdict on a parsed message: readings it rejects
+// count as parse errors, readings it couldn't queue as drops
+func (s *Subscriber) forwarded(msg mqtt.Message, kind, deviceID string, err error) {
+	switch {
+	case err == nil:
+		traceOf(msg).decide("forwarded", "queued")
+	case errors.Is(err, ingest.ErrQueueFull):
+		log.Printf("Warning: %s queue full, dropping message from %s", kind, deviceID)
+		dropMessage(msg, "queue full")
+	default:
+		log.Printf("Error: Rejected %s from %s: %v", kind, deviceID, invalidPayload(msg, err))
+	}
+}
+
+// handleSafety processes safety-critical messages and hands them to the pipeline's priority queue.
+// Unlike routine readings it never waits long: the handler runs on paho's
+// delivery goroutine, so blocking here would delay every other topic.
+func (s *Subscriber) handleSafety(client mqtt.Client, msg mqtt.Message) {
+	var payload models.SafetyPayload
+
+	if err := json.Unmarshal(msg.Payload(), &payload); err != nil {
+		err = invalidPayload(msg, err)
+		log.Printf("Error unmarshaling safety event: %v", err)
+		return
+	}
+
+	// Extract device ID from topic (sensor/{device_id}/safety)
+	deviceID := topicVars(msg).DeviceID
+	if deviceID == "" {
+		log.Printf("Could not extract device ID from topic: %s", msg.Topic())
+		traceOf(msg).decide("no_device_id", "no device ID in topic")
+		return
+	}
+
+	event := &models.SafetyEvent{
+		Timestamp: time.Now(),
+		DeviceID:  deviceID,
+		EventType: payload.Type,
+		Value:     payload.Value,
+	}
+
+	log.Printf("Received safety event from %s: type=%s, value=%.2f", deviceID, event.EventType, event.Value)
+	traceOf(msg).notef("parsed safety type=%s value=%.2f device=%s", event.EventType, event.Value, deviceID)
+
+	// The pipeline waits only briefly: the priority queue should always have room
+	err := s.pipeline.Safety(event)
+	if errors.Is(err, ingest.ErrQueueFull) {
+		log.Printf("CRITICAL: Safety queue full, dropping %s event from %s", event.EventType, deviceID)
+		dropMessage(msg, "queue full")
+		return
+	}
+	s.forwarded(msg, "safety", deviceID, err)
+}
+
+// authenticate verifies the sender of a message on a device stream, unwrapping its
+// payload. Rejected messages are logged and counted, and reach no handler.
+func (s *Subscriber) authenticate(stream string, tm *templatedMessage) bool {
+	binary, ok := authenticatedStreams[stream]
+	if s.Auth == nil || !ok || tm.vars.DeviceID == "" {
+		return true
+	}
+	payload, err := s.Auth.Verify(tm.vars.DeviceID, tm.Message.Payload(), binary)
+	if err != nil && stream == StreamSafety && errors.Is(err, ErrTokenUnavailable) {
+		// Safety events only ever close windows, so an unreachable registry mustn't block them
+		log.Printf("Warning: Accepting unverified safety message on %s: %v", tm.Topic(), err)
+		metrics.Default.Counter("mqtt_auth_unverified").Inc()
+		payload, err = Unverified(tm.Message.Payload(), binary)
+	}
+	if err != nil {
+		err = errs.Wrap(errs.ErrUnauthorized, err)
+		markOutcome(tm, "unauthorized")
+		metrics.Default.Counter("mqtt_unauthorized_" + stream).Inc()
+		log.Printf("Rejected %s message on %s: %v", stream, tm.Topic(), err)
+		return false
+	}
+	tm.payload = payload
+	return true
+}
+
+// deadLetter forwards an unparseable message to the dead-letter sink, if configured
+func (s *Subscriber) deadLetter(source string, msg mqtt.Message, reason error) {
+	if s.DeadLetters == nil {
+		return
+	}
+
+	letter := &models.DeadLetter{
+		Timestamp: time.Now(),
+		Source:    source,
+		DeviceID:  topicVars(msg).DeviceID,
+		Topic:     msg.Topic(),
+		Payload:   string(msg.Payload()),
+		Reason:    reason.Error(),
+	}
+	if err := s.DeadLetters.SaveDeadLetter(letter); err != nil {
+		log.Printf("Error saving dead letter: %v", err)
+	}
+}
+
+// extractDeviceID extracts device ID from an MQTT topic following the
+// "prefix/{device_id}/..." convention
 // Example: "sensor/sensor-001/temperature" -> "sensor-001"
 // Example: "window/sensor-001/control" -> "sensor-001"
 func extractDeviceID(topic string) string {
@@ -256,3 +1044,11 @@ func extractDeviceID(topic string) string {
 	}
 	return ""
 }
+
+// invalidPayload classifies a message parse failure and records it on the message trace
+func invalidPayload(msg mqtt.Message, err error) error {
+	err = errs.Wrap(errs.ErrPayloadInvalid, err)
+	markOutcome(msg, "parse_error")
+	traceOf(msg).decide("parse_error", "%v", err)
+	return err
+}
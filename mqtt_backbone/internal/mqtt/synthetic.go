@@ -0,0 +1,108 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"iot-backend/internal/models"
+)
+
+// SyntheticDevice publishes readings for one device ID on the topics the
+// subscriber listens to, so they take the same path through the backend as a
+// real sensor's (used by the end-to-end canary)
+type SyntheticDevice struct {
+	client           mqtt.Client
+	deviceID         string
+	token            string
+	temperatureTopic string
+	humidityTopic    string
+	audioTopic       string
+}
+
+// SyntheticDeviceConfig holds configuration for a synthetic device
+type SyntheticDeviceConfig struct {
+	DeviceID         string
+	Token            string // Wraps payloads in an authenticated envelope when set
+	TemperatureTopic string // Subscriber filters, e.g. "sensor/+/temperature"
+	HumidityTopic    string
+	AudioTopic       string
+	Tenant           string // Value of the {tenant} placeholder
+}
+
+// NewSyntheticDevice resolves the device's concrete topics from the subscriber filters
+func NewSyntheticDevice(client mqtt.Client, config SyntheticDeviceConfig) (*SyntheticDevice, error) {
+	d := &SyntheticDevice{client: client, deviceID: config.DeviceID, token: config.Token}
+	for _, t := range []struct {
+		filter string
+		topic  *string
+	}{
+		{config.TemperatureTopic, &d.temperatureTopic},
+		{config.HumidityTopic, &d.humidityTopic},
+		{config.AudioTopic, &d.audioTopic},
+	} {
+		template, err := ParseTopicTemplate(t.filter)
+		if err != nil {
+			return nil, err
+		}
+		topic := template.DeviceFilter(config.DeviceID, TopicVars{Tenant: config.Tenant})
+		if strings.ContainsAny(topic, "+#") {
+			return nil, fmt.Errorf("topic %q has levels besides the device ID that can't be filled in (is a tenant set?)", t.filter)
+		}
+		*t.topic = topic
+	}
+	return d, nil
+}
+
+// DeviceID returns the ID the device publishes as
+func (d *SyntheticDevice) DeviceID() string {
+	return d.deviceID
+}
+
+// PublishTemperature publishes a temperature reading in °C, with the unit so the
+// device's registry unit doesn't apply
+func (d *SyntheticDevice) PublishTemperature(value float64) error {
+	return d.publish(d.temperatureTopic, strconv.FormatFloat(value, 'f', 3, 64)+"C")
+}
+
+// PublishHumidity publishes a relative humidity reading in %
+func (d *SyntheticDevice) PublishHumidity(value float64) error {
+	return d.publish(d.humidityTopic, strconv.FormatFloat(value, 'f', 3, 64))
+}
+
+// PublishAudio publishes an audio clip
+func (d *SyntheticDevice) PublishAudio(payload *models.AudioPayload) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audio: %w", err)
+	}
+	return d.publish(d.audioTopic, json.RawMessage(data))
+}
+
+// publish sends a text or JSON payload at QoS 1, inside a token envelope if configured
+func (d *SyntheticDevice) publish(topic string, payload interface{}) error {
+	var data []byte
+	switch p := payload.(type) {
+	case string:
+		data = []byte(p)
+	case json.RawMessage:
+		data = p
+	}
+	if d.token != "" {
+		var err error
+		data, err = json.Marshal(struct {
+			DeviceToken string      `json:"device_token"`
+			Payload     interface{} `json:"payload"`
+		}{d.token, payload})
+		if err != nil {
+			return fmt.Errorf("failed to marshal authenticated envelope: %w", err)
+		}
+	}
+	token := d.client.Publish(topic, 1, false, data)
+	if err := waitToken("publish", topic, token); err != nil {
+		return fmt.Errorf("failed to publish synthetic reading: %w", err)
+	}
+	return nil
+}
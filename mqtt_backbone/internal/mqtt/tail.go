@@ -0,0 +1,207 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"iot-backend/internal/models"
+)
+
+// Kinds of messages a tail follows
+const (
+	TailTemperature = "temperature"
+	TailHumidity    = "humidity"
+	TailAudio       = "audio"
+	TailSafety      = "safety"
+	TailTrigger     = "trigger" // Inference request published by the backend
+	TailResponse    = "response"
+	TailCommand     = "command"
+	TailAck         = "ack"
+)
+
+// TailEvent is a message about the tailed device, decoded for display
+type TailEvent struct {
+	Received time.Time       `json:"received"`
+	Kind     string          `json:"kind"`
+	Topic    string          `json:"topic"`
+	Summary  string          `json:"summary"`           // One-line rendering of the payload
+	Payload  json.RawMessage `json:"payload,omitempty"` // The payload itself when it is JSON (audio data left out)
+}
+
+// TailConfig holds configuration for a tail
+type TailConfig struct {
+	DeviceID string
+	Tenant   string            // Value of the {tenant} placeholder (empty follows every tenant)
+	Topics   map[string]string // Topic filter or template by kind; empty ones aren't followed
+}
+
+// Tail follows the messages of one device on the topics the backend uses, for
+// watching a device live without a dashboard ("iot-backend tail")
+type Tail struct {
+	client  mqtt.Client
+	filters map[string]string // Kind → concrete filter
+}
+
+// NewTail resolves the device's filters for every configured topic
+func NewTail(client mqtt.Client, config TailConfig) (*Tail, error) {
+	t := &Tail{client: client, filters: make(map[string]string)}
+	for kind, pattern := range config.Topics {
+		for _, p := range strings.Split(pattern, ",") {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			}
+			template, err := ParseTopicTemplate(p)
+			if err != nil {
+				return nil, err
+			}
+			// Several inference request topics share a kind; the later ones get their own key
+			key := kind
+			for i := 2; t.filters[key] != ""; i++ {
+				key = kind + "#" + strconv.Itoa(i)
+			}
+			t.filters[key] = template.DeviceFilter(config.DeviceID, TopicVars{Tenant: config.Tenant})
+		}
+	}
+	return t, nil
+}
+
+// Filters returns the followed topic filters by kind
+func (t *Tail) Filters() map[string]string {
+	return t.filters
+}
+
+// Start subscribes to every filter and sends each message's event to events.
+// The caller must keep receiving; a slow reader stalls the client.
+func (t *Tail) Start(events chan<- *TailEvent) error {
+	for key, filter := range t.filters {
+		kind, _, _ := strings.Cut(key, "#")
+		handler := func(_ mqtt.Client, msg mqtt.Message) {
+			events <- decodeTail(kind, msg.Topic(), msg.Payload())
+		}
+		if err := waitToken("subscribe", filter, t.client.Subscribe(filter, 0, handler)); err != nil {
+			return fmt.Errorf("failed to follow %s: %w", kind, err)
+		}
+	}
+	return nil
+}
+
+// decodeTail renders a message of a kind; payloads that don't decode are shown as they are
+func decodeTail(kind, topic string, payload []byte) *TailEvent {
+	event := &TailEvent{Received: time.Now(), Kind: kind, Topic: topic}
+
+	// Readings may come wrapped in an authentication envelope
+	if isTokenEnvelope(payload) {
+		var env tokenEnvelope
+		if err := json.Unmarshal(payload, &env); err == nil && env.Payload != nil {
+			payload = env.Payload
+			var text string
+			if json.Unmarshal(payload, &text) == nil {
+				payload = []byte(text)
+			}
+		}
+	}
+
+	summary, err := summarizeTail(kind, payload)
+	if err != nil {
+		summary = fmt.Sprintf("undecodable (%v): %s", err, truncateTail(string(payload)))
+	}
+	event.Summary = summary
+	if kind != TailAudio && json.Valid(payload) && strings.HasPrefix(strings.TrimSpace(string(payload)), "{") {
+		event.Payload = payload
+	}
+	return event
+}
+
+// summarizeTail renders a payload of a kind on one line
+func summarizeTail(kind string, payload []byte) (string, error) {
+	switch kind {
+	case TailTemperature:
+		value, unit, err := parseTemperaturePayload(string(payload))
+		if err != nil {
+			return "", err
+		}
+		if unit == "" {
+			return fmt.Sprintf("%.2f (device unit)", value), nil
+		}
+		return fmt.Sprintf("%.2f °%s", value, unit), nil
+
+	case TailHumidity:
+		var value float64
+		if _, err := fmt.Sscanf(string(payload), "%f", &value); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%.1f %%", value), nil
+
+	case TailAudio:
+		var audio models.AudioPayload
+		if err := json.Unmarshal(payload, &audio); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%.2fs clip, %d Hz, %d channel(s), %d bytes", audio.Duration, audio.SampleRate, audio.Channels, len(audio.Data)), nil
+
+	case TailTrigger:
+		var request models.InferenceRequest
+		if err := json.Unmarshal(payload, &request); err != nil {
+			return "", err
+		}
+		s := fmt.Sprintf("temp=%.2f°C humidity=%.1f%% volume=%.1f dB", request.Temperature, request.Humidity, request.SoundVolume)
+		if request.Domain != "" {
+			s = request.Domain + ": " + s
+		}
+		return s, nil
+
+	case TailResponse:
+		var response models.InferenceResponse
+		if err := json.Unmarshal(payload, &response); err != nil {
+			return "", err
+		}
+		s := fmt.Sprintf("position %.1f%% (confidence %.2f)", response.Position, response.Confidence)
+		if response.Domain != "" {
+			s = response.Domain + ": " + s
+		}
+		return s, nil
+
+	case TailCommand:
+		var command models.WindowCommand
+		if err := json.Unmarshal(payload, &command); err != nil {
+			return "", err
+		}
+		s := fmt.Sprintf("move to %.1f%% (source %s)", command.Position, command.Source)
+		if command.WindowID != "" && command.WindowID != command.DeviceID {
+			s = command.WindowID + ": " + s
+		}
+		return s, nil
+
+	case TailAck:
+		var ack models.WindowCommandAck
+		if err := json.Unmarshal(payload, &ack); err != nil {
+			return "", err
+		}
+		status := ack.Status
+		if status == "" {
+			status = models.AckApplied
+		}
+		s := fmt.Sprintf("%s command %s", status, ack.CommandID)
+		if ack.Position != nil {
+			s += fmt.Sprintf(", now at %.1f%%", *ack.Position)
+		}
+		if ack.Error != "" {
+			s += ": " + ack.Error
+		}
+		return s, nil
+	}
+	return truncateTail(string(payload)), nil
+}
+
+// truncateTail shortens a raw payload for display
+func truncateTail(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > 200 {
+		return s[:200] + "..."
+	}
+	return s
+}
@@ -0,0 +1,81 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// TLSConfig holds the certificates for a broker reached over TLS
+type TLSConfig struct {
+	CAFile     string // PEM bundle of the CAs the broker's certificate is verified against (empty = system roots)
+	CertFile   string // PEM client certificate, for brokers that require mutual TLS
+	KeyFile    string // PEM private key of the client certificate
+	ServerName string // Name the broker's certificate is verified for (empty = the broker URL's host)
+}
+
+// enabled reports whether any TLS option is set
+func (t TLSConfig) enabled() bool {
+	return t != TLSConfig{}
+}
+
+// IsTLSBroker reports whether a broker URL uses TLS (tls://, ssl://, mqtts://, or
+// wss:// for MQTT over secure WebSockets)
+func IsTLSBroker(broker string) bool {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "tls", "ssl", "mqtts", "mqtt+ssl", "tcps", "wss":
+		return true
+	}
+	return false
+}
+
+// BuildTLSConfig returns the TLS settings for a broker, or nil for a plain TCP broker.
+// Certificates set for a broker that isn't reached over TLS are an error rather than
+// silently unused.
+func BuildTLSConfig(broker string, t TLSConfig) (*tls.Config, error) {
+	if !IsTLSBroker(broker) {
+		if t.enabled() {
+			return nil, fmt.Errorf("TLS options are set but broker %s isn't a tls:// or wss:// URL", broker)
+		}
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: t.ServerName,
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MQTT CA file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates in MQTT CA file %s", t.CAFile)
+		}
+	}
+
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return nil, fmt.Errorf("MQTT client certificate and key must be set together")
+	}
+	if t.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile); err != nil {
+			return nil, fmt.Errorf("failed to load MQTT client certificate: %w", err)
+		}
+		// Read on every handshake, so a renewed certificate is presented from the next reconnect
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load MQTT client certificate: %w", err)
+			}
+			return &cert, nil
+		}
+	}
+	return config, nil
+}
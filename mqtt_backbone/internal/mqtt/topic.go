@@ -0,0 +1,261 @@
+package mqtt
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// TopicVars are the values substituted into (or captured from) topic templates
+type TopicVars struct {
+	DeviceID   string // {device_id}
+	SensorType string // {sensor_type}, e.g. "temperature"
+	Tenant     string // {tenant}
+	WindowID   string // {window_id}; publishers default it to the device ID (one window per device)
+}
+
+// get returns the value of a placeholder
+func (v TopicVars) get(name string) string {
+	switch name {
+	case "device_id":
+		return v.DeviceID
+	case "sensor_type":
+		return v.SensorType
+	case "tenant":
+		return v.Tenant
+	case "window_id":
+		return v.WindowID
+	}
+	return ""
+}
+
+// set stores the value of a placeholder
+func (v *TopicVars) set(name, value string) {
+	switch name {
+	case "device_id":
+		v.DeviceID = value
+	case "sensor_type":
+		v.SensorType = value
+	case "tenant":
+		v.Tenant = value
+	case "window_id":
+		v.WindowID = value
+	}
+}
+
+// topicPlaceholders lists the supported placeholder names
+var topicPlaceholders = map[string]bool{"device_id": true, "sensor_type": true, "tenant": true, "window_id": true}
+
+// TopicTemplate is a parsed topic pattern such as "site/{tenant}/sensor/{device_id}/{sensor_type}".
+// Placeholders fill whole topic levels. Templates are immutable and safe for concurrent use.
+// A shared subscription prefix ("$share/<group>/") is kept apart from the levels:
+// it only appears in the subscription filter, never in message topics.
+type TopicTemplate struct {
+	pattern string
+	share   string   // "$share/<group>/" of a shared subscription, else empty
+	levels  []string // Literal levels, MQTT wildcards ("+", "#"), or placeholder names in braces
+}
+
+// ParseTopicTemplate parses and validates a topic pattern
+func ParseTopicTemplate(pattern string) (*TopicTemplate, error) {
+	t := &TopicTemplate{pattern: pattern}
+	topic := pattern
+	if strings.HasPrefix(pattern, "$share/") {
+		parts := strings.SplitN(pattern, "/", 3)
+		if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("topic %q: shared subscriptions need the form $share/<group>/<filter>", pattern)
+		}
+		t.share = parts[0] + "/" + parts[1] + "/"
+		topic = parts[2]
+	}
+	t.levels = strings.Split(topic, "/")
+	for i, level := range t.levels {
+		if name, ok := placeholderName(level); ok {
+			if !topicPlaceholders[name] {
+				return nil, fmt.Errorf("topic %q has unknown placeholder {%s}", pattern, name)
+			}
+			continue
+		}
+		if strings.ContainsAny(level, "{}") {
+			return nil, fmt.Errorf("topic %q: placeholders must fill a whole level, got %q", pattern, level)
+		}
+		if level == "#" && i != len(t.levels)-1 {
+			return nil, fmt.Errorf("topic %q: # must be the last level", pattern)
+		}
+	}
+	return t, nil
+}
+
+// placeholderName returns the name of a "{name}" level
+func placeholderName(level string) (string, bool) {
+	if len(level) > 2 && level[0] == '{' && level[len(level)-1] == '}' {
+		return level[1 : len(level)-1], true
+	}
+	return "", false
+}
+
+// String returns the pattern
+func (t *TopicTemplate) String() string {
+	return t.pattern
+}
+
+// Format substitutes every placeholder. It fails when a placeholder has no value,
+// so a message is never published to a topic with a literal "{...}" in it. A
+// shared subscription prefix is left out, since messages aren't published to it.
+func (t *TopicTemplate) Format(vars TopicVars) (string, error) {
+	levels := make([]string, len(t.levels))
+	for i, level := range t.levels {
+		name, ok := placeholderName(level)
+		if !ok {
+			levels[i] = level
+			continue
+		}
+		value := vars.get(name)
+		if value == "" {
+			return "", fmt.Errorf("topic %q needs a value for {%s}", t.pattern, name)
+		}
+		levels[i] = value
+	}
+	return strings.Join(levels, "/"), nil
+}
+
+// Filter returns the subscription filter: placeholders with a value in vars are
+// substituted (e.g., a configured tenant) and the rest become "+". A shared
+// subscription keeps its "$share/<group>/" prefix.
+func (t *TopicTemplate) Filter(vars TopicVars) string {
+	levels := make([]string, len(t.levels))
+	for i, level := range t.levels {
+		levels[i] = level
+		if name, ok := placeholderName(level); ok {
+			levels[i] = "+"
+			if value := vars.get(name); value != "" {
+				levels[i] = value
+			}
+		}
+	}
+	return t.share + strings.Join(levels, "/")
+}
+
+// DeviceFilter returns the filter covering one device's topics, e.g. for a broker
+// ACL: {device_id} (or, in templates without it, the first "+" level) becomes the
+// device ID, {window_id} does too when it's the only device level, {tenant} takes
+// its value in vars, and the remaining placeholders become "+". Shared
+// subscription prefixes are left out: the filter names the device's own topics.
+func (t *TopicTemplate) DeviceFilter(deviceID string, vars TopicVars) string {
+	named := strings.Contains(t.pattern, "{device_id}")
+	levels := make([]string, len(t.levels))
+	placed := named
+	for i, level := range t.levels {
+		levels[i] = level
+		name, ok := placeholderName(level)
+		switch {
+		case ok && name == "device_id":
+			levels[i] = deviceID
+		case ok && name == "window_id" && !named:
+			levels[i] = deviceID
+			placed = true
+		case ok && name == "tenant" && vars.Tenant != "":
+			levels[i] = vars.Tenant
+		case ok:
+			levels[i] = "+"
+		case level == "+" && !placed:
+			levels[i] = deviceID
+			placed = true
+		}
+	}
+	return strings.Join(levels, "/")
+}
+
+// Match captures the placeholder values of a topic matching the template. In
+// templates without {device_id}, such as "sensor/+/temperature", the first "+"
+// level is the device ID (or, without one, the second level). Topics are matched
+// without the shared subscription prefix, as brokers deliver them.
+func (t *TopicTemplate) Match(topic string) (TopicVars, bool) {
+	var vars TopicVars
+	parts := strings.Split(topic, "/")
+	named := strings.Contains(t.pattern, "{device_id}")
+	if !named && !strings.Contains(t.pattern, "+") {
+		vars.DeviceID = extractDeviceID(topic)
+	}
+
+	for i, level := range t.levels {
+		if level == "#" {
+			return vars, true
+		}
+		if i >= len(parts) {
+			return vars, false
+		}
+		if name, ok := placeholderName(level); ok {
+			vars.set(name, parts[i])
+			continue
+		}
+		switch {
+		case level == "+":
+			if !named && vars.DeviceID == "" {
+				vars.DeviceID = parts[i]
+			}
+		case level != parts[i]:
+			return vars, false
+		}
+	}
+	return vars, len(parts) == len(t.levels)
+}
+
+// topicTemplates caches parsed patterns; publishers format topics from many goroutines
+var topicTemplates sync.Map // pattern -> *TopicTemplate
+
+// cachedTopicTemplate parses a pattern once
+func cachedTopicTemplate(pattern string) (*TopicTemplate, error) {
+	if t, ok := topicTemplates.Load(pattern); ok {
+		return t.(*TopicTemplate), nil
+	}
+	t, err := ParseTopicTemplate(pattern)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := topicTemplates.LoadOrStore(pattern, t)
+	return actual.(*TopicTemplate), nil
+}
+
+// formatTopic fills the placeholders of a configured topic pattern
+func formatTopic(topicPattern string, vars TopicVars) (string, error) {
+	t, err := cachedTopicTemplate(topicPattern)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(vars)
+}
+
+// templatedMessage carries the placeholder values captured from its topic
+type templatedMessage struct {
+	mqtt.Message
+	vars    TopicVars
+	outcome string // Set by handlers through markOutcome for the message statistics
+	payload []byte // Replaces the received payload once unwrapped from its authentication envelope
+}
+
+// Payload returns the message body handlers should parse
+func (m *templatedMessage) Payload() []byte {
+	if m.payload != nil {
+		return m.payload
+	}
+	return m.Message.Payload()
+}
+
+// topicVars returns the values captured from a message's topic by the template it
+// was subscribed with. Messages delivered without one fall back to the
+// "prefix/{device_id}/..." convention.
+func topicVars(msg mqtt.Message) TopicVars {
+	for {
+		switch m := msg.(type) {
+		case *templatedMessage:
+			return m.vars
+		case *tracedMessage:
+			msg = m.Message
+		default:
+			return TopicVars{DeviceID: extractDeviceID(msg.Topic())}
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+)
+
+// TraceSink stores sampled message traces
+type TraceSink interface {
+	SaveMessageTrace(trace *models.MessageTrace) error
+}
+
+// TraceConfig holds configuration for message tracing
+type TraceConfig struct {
+	Rates           map[string]float64 // Sampling rate (0-1) per subscription filter; "*" applies to the rest
+	MaxPayloadBytes int                // Longer payloads are truncated in the trace
+	BufferSize      int                // Traces waiting to be written; more are dropped
+}
+
+// DefaultTraceConfig returns default configuration (tracing disabled)
+func DefaultTraceConfig() TraceConfig {
+	return TraceConfig{
+		Rates:           map[string]float64{},
+		MaxPayloadBytes: 4096,
+		BufferSize:      256,
+	}
+}
+
+// ParseTraceRates parses "filter=rate,filter=rate" (e.g., "*=0.01,sensor/+/audio=0.1")
+func ParseTraceRates(spec string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		filter, value, ok := strings.Cut(part, "=")
+		filter = strings.TrimSpace(filter)
+		if !ok || filter == "" {
+			return nil, fmt.Errorf("trace rate %q must be filter=rate", part)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("trace rate %q must be between 0 and 1", part)
+		}
+		rates[filter] = rate
+	}
+	return rates, nil
+}
+
+// Tracer records the lifecycle of a sampled fraction of incoming messages:
+// the raw payload, what the handler parsed, and what it decided to do.
+type Tracer struct {
+	rates      map[string]float64
+	maxPayload int
+	sink       TraceSink
+	queue      chan *models.MessageTrace
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewTracer creates a tracer writing to sink
+func NewTracer(config TraceConfig, sink TraceSink) *Tracer {
+	return &Tracer{
+		rates:      config.Rates,
+		maxPayload: config.MaxPayloadBytes,
+		sink:       sink,
+		queue:      make(chan *models.MessageTrace, config.BufferSize),
+		rng:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Rate returns the sampling rate for a subscription filter
+func (t *Tracer) Rate(filter string) float64 {
+	if rate, ok := t.rates[filter]; ok {
+		return rate
+	}
+	return t.rates["*"]
+}
+
+// Start writes finished traces until context is cancelled
+func (t *Tracer) Start(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case trace := <-t.queue:
+			if err := t.sink.SaveMessageTrace(trace); err != nil {
+				log.Printf("Error saving message trace: %v", err)
+			}
+		}
+	}
+}
+
+// Wrap returns a handler that traces a sampled fraction of the messages on a filter.
+// Handlers annotate traces through traceOf, which is a no-op for unsampled messages.
+func (t *Tracer) Wrap(filter string, handler mqtt.MessageHandler) mqtt.MessageHandler {
+	rate := t.Rate(filter)
+	if rate <= 0 {
+		return handler
+	}
+	return func(client mqtt.Client, msg mqtt.Message) {
+		if !t.sample(rate) {
+			handler(client, msg)
+			return
+		}
+
+		trace := t.begin(filter, msg)
+		start := time.Now()
+		handler(client, &tracedMessage{Message: msg, trace: trace})
+		trace.finish(time.Since(start))
+
+		select {
+		case t.queue <- &trace.record:
+			metrics.Default.Counter("mqtt_traces").Inc()
+		default:
+			metrics.Default.Counter("mqtt_traces_dropped").Inc()
+		}
+	}
+}
+
+// sample decides whether to trace one message
+func (t *Tracer) sample(rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rng.Float64() < rate
+}
+
+// begin starts a trace with the raw message
+func (t *Tracer) begin(filter string, msg mqtt.Message) *messageTrace {
+	payload := msg.Payload()
+	record := models.MessageTrace{
+		Timestamp:    time.Now(),
+		TraceID:      NewCorrelationID(),
+		Filter:       filter,
+		Topic:        msg.Topic(),
+		DeviceID:     topicVars(msg).DeviceID,
+		PayloadBytes: len(payload),
+	}
+	if t.maxPayload > 0 && len(payload) > t.maxPayload {
+		payload = payload[:t.maxPayload]
+		record.Truncated = true
+	}
+	if utf8.Valid(payload) {
+		record.Payload = string(payload)
+	} else {
+		record.Payload = fmt.Sprintf("%x", payload)
+	}
+	return &messageTrace{record: record}
+}
+
+// messageTrace collects handler annotations for one message
+type messageTrace struct {
+	mu     sync.Mutex
+	record models.MessageTrace
+}
+
+// notef appends a handler event (nil-safe, so handlers can annotate unconditionally)
+func (mt *messageTrace) notef(format string, args ...interface{}) {
+	if mt == nil {
+		return
+	}
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.record.Events = append(mt.record.Events, fmt.Sprintf(format, args...))
+}
+
+// decide records the handler's final decision and why (nil-safe)
+func (mt *messageTrace) decide(outcome, format string, args ...interface{}) {
+	if mt == nil {
+		return
+	}
+	mt.notef(format, args...)
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.record.Outcome = outcome
+}
+
+// finish stamps the handler duration
+func (mt *messageTrace) finish(elapsed time.Duration) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.record.DurationUs = elapsed.Microseconds()
+	if mt.record.Outcome == "" {
+		mt.record.Outcome = "handled"
+	}
+}
+
+// tracedMessage carries a trace through a message handler
+type tracedMessage struct {
+	mqtt.Message
+	trace *messageTrace
+}
+
+// traceOf returns the trace attached to a message, or nil if it wasn't sampled
+func traceOf(msg mqtt.Message) *messageTrace {
+	if tm, ok := msg.(*tracedMessage); ok {
+		return tm.trace
+	}
+	return nil
+}
+
+// FileTraceSink appends traces to a file as JSON lines
+type FileTraceSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileTraceSink opens (or creates) a trace file for appending
+func NewFileTraceSink(path string) (*FileTraceSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+	return &FileTraceSink{file: file}, nil
+}
+
+// SaveMessageTrace writes one trace as a JSON line
+func (s *FileTraceSink) SaveMessageTrace(trace *models.MessageTrace) error {
+	line, err := json.Marshal(trace)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message trace: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write message trace: %w", err)
+	}
+	return nil
+}
+
+// Close closes the trace file
+func (s *FileTraceSink) Close() error {
+	return s.file.Close()
+}
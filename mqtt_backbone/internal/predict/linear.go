@@ -0,0 +1,126 @@
+// Package predict evaluates the window position model locally, without the ML service
+package predict
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	"iot-backend/internal/errs"
+	"iot-backend/internal/models"
+)
+
+// LinearModel is a regression model over named features, stored as JSON:
+//
+//	{
+//	  "version": "2024-06-01",
+//	  "intercept": 40.0,
+//	  "coefficients": {"temperature": 2.1, "humidity": 0.4, "sound_volume": -0.8, "mold_risk": 25},
+//	  "scaler": {"type": "standard", "means": {"temperature": 22.0}, "stds": {"temperature": 3.5}}
+//	}
+//
+// Features are normalized by the scaler the model was trained with (see ScalerSpec)
+// before the coefficient is applied; the same scaling is sent with inference
+// requests. Older artifacts give top-level "means" and "scales" instead, which act
+// as a standard scaler. Feature names match the inference request fields.
+type LinearModel struct {
+	Version      string             `json:"version"`
+	Intercept    float64            `json:"intercept"`
+	Coefficients map[string]float64 `json:"coefficients"`
+	ScalerSpec   *ScalerSpec        `json:"scaler,omitempty"`
+	Means        map[string]float64 `json:"means,omitempty"`  // Legacy standard scaler
+	Scales       map[string]float64 `json:"scales,omitempty"` // Legacy standard scaler
+
+	scaler Scaler
+}
+
+// Prediction is the model output for one set of conditions
+type Prediction struct {
+	Position      float64            `json:"position"`          // 0-100%
+	RawPosition   float64            `json:"raw_position"`      // Before clamping to 0-100
+	Contributions map[string]float64 `json:"contributions"`     // Per-feature share of the raw position
+	Missing       []string           `json:"missing,omitempty"` // Model features that were not supplied (contributing nothing)
+}
+
+// LoadLinearModel reads and validates a model file
+func LoadLinearModel(path string) (*LinearModel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model: %w", err)
+	}
+	return ParseLinearModel(data)
+}
+
+// ParseLinearModel decodes and validates a model
+func ParseLinearModel(data []byte) (*LinearModel, error) {
+	var m LinearModel
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse model: %w", errs.Wrap(errs.ErrModelInvalid, err))
+	}
+	if len(m.Coefficients) == 0 {
+		return nil, errs.Wrap(errs.ErrModelInvalid, fmt.Errorf("model has no coefficients"))
+	}
+	for name, scale := range m.Scales {
+		if scale == 0 || math.IsNaN(scale) || math.IsInf(scale, 0) {
+			return nil, errs.Wrap(errs.ErrModelInvalid, fmt.Errorf("model scale for %s must be a non-zero number", name))
+		}
+	}
+	scaler, err := modelScaler(&m)
+	if err != nil {
+		return nil, err
+	}
+	m.scaler = scaler
+	if m.Version == "" {
+		m.Version = "unversioned"
+	}
+	return &m, nil
+}
+
+// Scaler returns the feature scaler the model was trained with
+func (m *LinearModel) Scaler() Scaler {
+	return m.scaler
+}
+
+// Scaling returns the model's feature scaling as inference request metadata
+func (m *LinearModel) Scaling() *models.FeatureScaling {
+	scaling := m.scaler.Metadata()
+	scaling.ModelVersion = m.Version
+	return scaling
+}
+
+// Features returns the model's feature names in sorted order
+func (m *LinearModel) Features() []string {
+	names := make([]string, 0, len(m.Coefficients))
+	for name := range m.Coefficients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Predict scales the features and evaluates the model. Features absent from the
+// input contribute nothing, as a standardized feature at its mean would.
+func (m *LinearModel) Predict(features map[string]float64) Prediction {
+	p := Prediction{
+		RawPosition:   m.Intercept,
+		Contributions: make(map[string]float64, len(m.Coefficients)),
+	}
+
+	for _, name := range m.Features() {
+		value, ok := features[name]
+		if !ok {
+			p.Missing = append(p.Missing, name)
+			p.Contributions[name] = 0
+			continue
+		}
+
+		contribution := m.Coefficients[name] * m.scaler.Transform(name, value)
+		p.Contributions[name] = contribution
+		p.RawPosition += contribution
+	}
+
+	p.Position = math.Max(0, math.Min(100, p.RawPosition))
+	return p
+}
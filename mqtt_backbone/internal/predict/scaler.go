@@ -0,0 +1,151 @@
+package predict
+
+import (
+	"fmt"
+	"math"
+
+	"iot-backend/internal/errs"
+	"iot-backend/internal/models"
+)
+
+// Scaler types in model artifacts
+const (
+	ScalerStandard = "standard" // (x - mean) / std
+	ScalerMinMax   = "minmax"   // (x - min) / (max - min)
+)
+
+// Scaler normalizes raw feature values the way the model was trained
+type Scaler interface {
+	// Transform returns a feature's scaled value; features the scaler has no
+	// parameters for are returned unchanged
+	Transform(name string, value float64) float64
+
+	// Metadata describes the scaler for inference requests, so the ML service can
+	// check it scales inputs identically
+	Metadata() *models.FeatureScaling
+}
+
+// ScalerSpec is the "scaler" object of a model artifact:
+//
+//	{"type": "standard", "means": {"temperature": 22.0}, "stds": {"temperature": 3.5}}
+//	{"type": "minmax", "mins": {"humidity": 20}, "maxs": {"humidity": 90}}
+type ScalerSpec struct {
+	Type  string             `json:"type"`
+	Means map[string]float64 `json:"means,omitempty"`
+	Stds  map[string]float64 `json:"stds,omitempty"`
+	Mins  map[string]float64 `json:"mins,omitempty"`
+	Maxs  map[string]float64 `json:"maxs,omitempty"`
+}
+
+// NewScaler builds and validates the scaler a spec describes
+func NewScaler(spec ScalerSpec) (Scaler, error) {
+	switch spec.Type {
+	case ScalerStandard:
+		for name := range spec.Means {
+			if _, ok := spec.Stds[name]; !ok {
+				return nil, fmt.Errorf("standard scaler has a mean but no std for %s", name)
+			}
+		}
+		for name, std := range spec.Stds {
+			if std == 0 || math.IsNaN(std) || math.IsInf(std, 0) {
+				return nil, fmt.Errorf("standard scaler std for %s must be a non-zero number", name)
+			}
+		}
+		return &StandardScaler{Means: spec.Means, Stds: spec.Stds}, nil
+	case ScalerMinMax:
+		if len(spec.Mins) != len(spec.Maxs) {
+			return nil, fmt.Errorf("minmax scaler needs a min and a max for every feature")
+		}
+		for name, lo := range spec.Mins {
+			hi, ok := spec.Maxs[name]
+			if !ok {
+				return nil, fmt.Errorf("minmax scaler has a min but no max for %s", name)
+			}
+			if !(hi > lo) || math.IsInf(hi-lo, 0) {
+				return nil, fmt.Errorf("minmax scaler max for %s must be above its min", name)
+			}
+		}
+		return &MinMaxScaler{Mins: spec.Mins, Maxs: spec.Maxs}, nil
+	default:
+		return nil, fmt.Errorf("unknown scaler type %q (want %s or %s)", spec.Type, ScalerStandard, ScalerMinMax)
+	}
+}
+
+// StandardScaler centers features on their training mean in units of standard deviation
+type StandardScaler struct {
+	Means map[string]float64
+	Stds  map[string]float64
+}
+
+// Transform implements Scaler
+func (s *StandardScaler) Transform(name string, value float64) float64 {
+	std, ok := s.Stds[name]
+	if !ok {
+		return value
+	}
+	return (value - s.Means[name]) / std
+}
+
+// Metadata implements Scaler
+func (s *StandardScaler) Metadata() *models.FeatureScaling {
+	return &models.FeatureScaling{Type: ScalerStandard, Means: s.Means, Stds: s.Stds}
+}
+
+// MinMaxScaler maps features from their training range onto 0-1
+type MinMaxScaler struct {
+	Mins map[string]float64
+	Maxs map[string]float64
+}
+
+// Transform implements Scaler
+func (s *MinMaxScaler) Transform(name string, value float64) float64 {
+	lo, ok := s.Mins[name]
+	if !ok {
+		return value
+	}
+	return (value - lo) / (s.Maxs[name] - lo)
+}
+
+// Metadata implements Scaler
+func (s *MinMaxScaler) Metadata() *models.FeatureScaling {
+	return &models.FeatureScaling{Type: ScalerMinMax, Mins: s.Mins, Maxs: s.Maxs}
+}
+
+// ScaleFeatures applies a scaler to a set of raw features
+func ScaleFeatures(scaler Scaler, features map[string]float64) map[string]float64 {
+	scaled := make(map[string]float64, len(features))
+	for name, value := range features {
+		scaled[name] = scaler.Transform(name, value)
+	}
+	return scaled
+}
+
+// modelScaler returns the scaler of a parsed model: its "scaler" object, or the
+// legacy means/scales as a standard scaler
+func modelScaler(m *LinearModel) (Scaler, error) {
+	if m.ScalerSpec != nil {
+		if len(m.Means) > 0 || len(m.Scales) > 0 {
+			return nil, errs.Wrap(errs.ErrModelInvalid, fmt.Errorf("model has both a scaler and legacy means/scales"))
+		}
+		scaler, err := NewScaler(*m.ScalerSpec)
+		if err != nil {
+			return nil, errs.Wrap(errs.ErrModelInvalid, err)
+		}
+		return scaler, nil
+	}
+
+	// Legacy models standardize features that have a scale, and center the rest on their mean
+	stds := make(map[string]float64, len(m.Means)+len(m.Scales))
+	means := make(map[string]float64, len(m.Means)+len(m.Scales))
+	for name, mean := range m.Means {
+		means[name], stds[name] = mean, 1
+	}
+	for name, scale := range m.Scales {
+		means[name], stds[name] = m.Means[name], scale
+	}
+	scaler, err := NewScaler(ScalerSpec{Type: ScalerStandard, Means: means, Stds: stds})
+	if err != nil {
+		return nil, errs.Wrap(errs.ErrModelInvalid, err)
+	}
+	return scaler, nil
+}
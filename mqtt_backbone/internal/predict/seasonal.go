@@ -0,0 +1,160 @@
+package predict
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultVariant names the model used when no variant matches
+const DefaultVariant = "default"
+
+// seasonMonths are the meteorological seasons of the northern hemisphere by their
+// first month; each lasts three months
+var seasonMonths = map[string]time.Month{
+	"spring": time.March,
+	"summer": time.June,
+	"autumn": time.September,
+	"winter": time.December,
+}
+
+// ModelVariant is a model trained for a season, an outdoor temperature range, or
+// both; it is active while all of its conditions hold
+type ModelVariant struct {
+	Name       string
+	Season     string  // spring, summer, autumn, or winter (empty: any season)
+	OutdoorMin float64 // Outdoor temperature range (°C) the variant covers, [min, max); -Inf/+Inf when open
+	OutdoorMax float64
+	Model      *LinearModel
+}
+
+// hasRange reports whether the variant depends on the outdoor temperature
+func (v ModelVariant) hasRange() bool {
+	return !math.IsInf(v.OutdoorMin, -1) || !math.IsInf(v.OutdoorMax, 1)
+}
+
+// ModelSet selects the active model among seasonal and outdoor temperature
+// variants of the window model. The first matching variant, in configured order,
+// wins; a variant with an outdoor range only matches while the device's outdoor
+// temperature is known. Without a match the default model is active.
+type ModelSet struct {
+	defaultModel *LinearModel // May be nil
+	variants     []ModelVariant
+	southern     bool // Seasons of the southern hemisphere (summer in January)
+}
+
+// NewModelSet creates a set over a default model (nil when none is loaded) and its variants
+func NewModelSet(defaultModel *LinearModel, variants []ModelVariant, southern bool) *ModelSet {
+	return &ModelSet{defaultModel: defaultModel, variants: variants, southern: southern}
+}
+
+// Default returns the model used when no variant matches (nil when none is loaded)
+func (s *ModelSet) Default() *LinearModel {
+	return s.defaultModel
+}
+
+// Variants returns the configured variants in selection order
+func (s *ModelSet) Variants() []ModelVariant {
+	return s.variants
+}
+
+// Select returns the model active at a time for a device with the given outdoor
+// temperature (ok is false when it's unknown), and the name of its variant
+// (DefaultVariant for the default model). The model is nil when nothing matches
+// and no default model is loaded.
+func (s *ModelSet) Select(at time.Time, outdoor float64, ok bool) (*LinearModel, string) {
+	season := s.season(at)
+	for _, v := range s.variants {
+		if v.Season != "" && v.Season != season {
+			continue
+		}
+		if v.hasRange() && (!ok || outdoor < v.OutdoorMin || outdoor >= v.OutdoorMax) {
+			continue
+		}
+		return v.Model, v.Name
+	}
+	return s.defaultModel, DefaultVariant
+}
+
+// season returns the meteorological season of a time
+func (s *ModelSet) season(at time.Time) string {
+	month := at.Month()
+	if s.southern {
+		month = (month+5)%12 + 1
+	}
+	for name, first := range seasonMonths {
+		if (month-first+12)%12 < 3 {
+			return name
+		}
+	}
+	return ""
+}
+
+// ParseModelVariants parses and loads variants such as
+// "winter=./model/winter.json;cold=./model/cold.json@..5;hot=./model/hot.json@25..".
+// A variant named after a season is active in it; "@min..max" limits a variant to
+// an outdoor temperature range in °C, either end open. Every variant needs one or
+// both.
+func ParseModelVariants(spec string) ([]ModelVariant, error) {
+	seen := map[string]bool{DefaultVariant: true}
+
+	var variants []ModelVariant
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, rest, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid model variant %q, expected name=path[@min..max]", entry)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("model variant name %q is reserved or duplicated", name)
+		}
+		seen[name] = true
+
+		v := ModelVariant{Name: name, OutdoorMin: math.Inf(-1), OutdoorMax: math.Inf(1)}
+		if _, ok := seasonMonths[name]; ok {
+			v.Season = name
+		}
+
+		path, rangeSpec, hasRange := strings.Cut(rest, "@")
+		if path = strings.TrimSpace(path); path == "" {
+			return nil, fmt.Errorf("model variant %s needs a model path", name)
+		}
+		if hasRange {
+			lo, hi, ok := strings.Cut(strings.TrimSpace(rangeSpec), "..")
+			if !ok {
+				return nil, fmt.Errorf("model variant %s has invalid outdoor range %q, expected min..max", name, rangeSpec)
+			}
+			var err error
+			if lo = strings.TrimSpace(lo); lo != "" {
+				if v.OutdoorMin, err = strconv.ParseFloat(lo, 64); err != nil {
+					return nil, fmt.Errorf("model variant %s has invalid outdoor minimum %q", name, lo)
+				}
+			}
+			if hi = strings.TrimSpace(hi); hi != "" {
+				if v.OutdoorMax, err = strconv.ParseFloat(hi, 64); err != nil {
+					return nil, fmt.Errorf("model variant %s has invalid outdoor maximum %q", name, hi)
+				}
+			}
+			if v.OutdoorMin >= v.OutdoorMax {
+				return nil, fmt.Errorf("model variant %s has an empty outdoor range", name)
+			}
+		}
+		if v.Season == "" && !v.hasRange() {
+			return nil, fmt.Errorf("model variant %s needs a season name or an outdoor range", name)
+		}
+
+		model, err := LoadLinearModel(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load model variant %s: %w", name, err)
+		}
+		v.Model = model
+		variants = append(variants, v)
+	}
+	return variants, nil
+}
@@ -0,0 +1,225 @@
+// Package preflight verifies the backend's dependencies (broker, topic permissions,
+// ClickHouse schema, model file) and reports a pass/fail summary.
+package preflight
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"iot-backend/internal/database"
+	"iot-backend/internal/mqtt"
+	"iot-backend/internal/predict"
+	"iot-backend/pkg/config"
+)
+
+// Status is the outcome of a single check
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn" // Degraded but the service can run
+	StatusFail Status = "fail"
+)
+
+// Check is the result of one preflight check
+type Check struct {
+	Name       string  `json:"name"`
+	Status     Status  `json:"status"`
+	Detail     string  `json:"detail"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Report collects preflight check results
+type Report struct {
+	Checks []Check `json:"checks"`
+	Passed bool    `json:"passed"` // True if no check failed
+}
+
+// timeout bounds each network check
+const timeout = 5 * time.Second
+
+// Run executes all checks against the configured dependencies. It never creates
+// tables or publishes messages.
+func Run(cfg *config.Config) *Report {
+	report := &Report{Passed: true}
+
+	checkBroker(report, cfg)
+	checkClickHouse(report, cfg)
+	checkModel(report, cfg)
+
+	return report
+}
+
+// add records a check result
+func (r *Report) add(name string, status Status, start time.Time, detail string) {
+	r.Checks = append(r.Checks, Check{
+		Name:       name,
+		Status:     status,
+		Detail:     detail,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000.0,
+	})
+	if status == StatusFail {
+		r.Passed = false
+	}
+}
+
+// Failures returns the checks that failed
+func (r *Report) Failures() []Check {
+	var failed []Check
+	for _, c := range r.Checks {
+		if c.Status == StatusFail {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+// Print writes a human-readable summary
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintln(w, "Preflight checks:")
+	for _, c := range r.Checks {
+		fmt.Fprintf(w, "  [%s] %-28s %s (%.0f ms)\n", strings.ToUpper(string(c.Status)), c.Name, c.Detail, c.DurationMs)
+	}
+	if r.Passed {
+		fmt.Fprintln(w, "Result: PASS")
+	} else {
+		fmt.Fprintf(w, "Result: FAIL (%d failed)\n", len(r.Failures()))
+	}
+}
+
+// checkBroker verifies broker reachability and subscribe permission for every input topic
+func checkBroker(report *Report, cfg *config.Config) {
+	start := time.Now()
+	topics := subscribeTopics(cfg)
+	result := mqtt.ProbeBroker(mqtt.ClientConfig{
+		Broker:      cfg.MQTTBroker,
+		ClientID:    cfg.MQTTClientID,
+		Username:    cfg.MQTTUsername,
+		Password:    cfg.MQTTPassword,
+		TopicPrefix: cfg.MQTTTopicPrefix,
+		TLS: mqtt.TLSConfig{
+			CAFile:     cfg.MQTTCAFile,
+			CertFile:   cfg.MQTTCertFile,
+			KeyFile:    cfg.MQTTKeyFile,
+			ServerName: cfg.MQTTTLSServerName,
+		},
+	}, topics, timeout)
+
+	if result.ConnectErr != nil {
+		report.add("mqtt_broker", StatusFail, start, fmt.Sprintf("%s unreachable: %v", cfg.MQTTBroker, result.ConnectErr))
+		return
+	}
+	report.add("mqtt_broker", StatusPass, start, fmt.Sprintf("%s connected in %v", cfg.MQTTBroker, result.Latency.Round(time.Millisecond)))
+
+	for _, topic := range topics {
+		if err := result.Topics[topic]; err != nil {
+			report.add("mqtt_subscribe "+topic, StatusFail, start, err.Error())
+		} else {
+			report.add("mqtt_subscribe "+topic, StatusPass, start, "granted")
+		}
+	}
+}
+
+// subscribeTopics lists the topic filters the service subscribes to
+func subscribeTopics(cfg *config.Config) []string {
+	candidates := []string{
+		cfg.MQTTTopicSafety,
+		cfg.MQTTTopicTemperature,
+		cfg.MQTTTopicHumidity,
+		cfg.MQTTTopicAudio,
+		cfg.MQTTTopicLoRaWAN,
+		cfg.MQTTTopicWindowControl,
+		cfg.MQTTTopicWindowAck,
+		cfg.MQTTTopicBoot,
+		cfg.MQTTTopicMotion,
+		cfg.MQTTTopicCO2,
+		cfg.MQTTTopicBackfill,
+	}
+	if cfg.MQTTFrameLayout != "" {
+		candidates = append(candidates, cfg.MQTTTopicFrame)
+	}
+
+	var topics []string
+	for _, t := range candidates {
+		if t != "" {
+			topics = append(topics, t)
+		}
+	}
+	return topics
+}
+
+// checkClickHouse verifies connectivity and that the live schema has every expected table and column
+func checkClickHouse(report *Report, cfg *config.Config) {
+	start := time.Now()
+	db, err := database.OpenClickHouseDB(cfg.ClickHouseAddr, cfg.ClickHouseDB, cfg.ClickHouseUser, cfg.ClickHousePass,
+		database.ClusterConfig{
+			Cluster:       cfg.ClickHouseCluster,
+			ZooKeeperPath: cfg.ClickHouseZooKeeperPath,
+			ReplicaName:   cfg.ClickHouseReplicaName,
+		},
+		database.PoolsConfig{Read: database.PoolConfig{Addr: cfg.ClickHouseReadAddr}})
+	if err != nil {
+		report.add("clickhouse", StatusFail, start, err.Error())
+		return
+	}
+	defer db.Close()
+	detail := "connected to " + cfg.ClickHouseAddr
+	if cfg.ClickHouseReadAddr != "" {
+		detail += ", reads from " + cfg.ClickHouseReadAddr
+	}
+	report.add("clickhouse", StatusPass, start, detail)
+
+	start = time.Now()
+	problems, err := db.CheckSchema()
+	switch {
+	case err != nil:
+		report.add("clickhouse_schema", StatusFail, start, err.Error())
+	case len(problems) > 0:
+		detail := strings.Join(problems, "; ")
+		if len(problems) > 5 {
+			detail = strings.Join(problems[:5], "; ") + fmt.Sprintf("; and %d more", len(problems)-5)
+		}
+		report.add("clickhouse_schema", StatusFail, start, detail)
+	default:
+		report.add("clickhouse_schema", StatusPass, start, fmt.Sprintf("%d tables compatible", len(database.ExpectedColumns())))
+	}
+}
+
+// checkModel verifies the model file parses. A missing file is only a warning,
+// since the ML service may hold its own copy.
+func checkModel(report *Report, cfg *config.Config) {
+	start := time.Now()
+	if cfg.ModelPath == "" {
+		report.add("model_file", StatusPass, start, "not configured")
+		return
+	}
+
+	data, err := os.ReadFile(cfg.ModelPath)
+	if os.IsNotExist(err) {
+		report.add("model_file", StatusWarn, start, cfg.ModelPath+" not found")
+		return
+	}
+	if err != nil {
+		report.add("model_file", StatusFail, start, err.Error())
+		return
+	}
+
+	var model map[string]interface{}
+	if err := json.Unmarshal(data, &model); err != nil {
+		report.add("model_file", StatusFail, start, fmt.Sprintf("%s is not valid JSON: %v", cfg.ModelPath, err))
+		return
+	}
+	if len(model) == 0 {
+		report.add("model_file", StatusFail, start, cfg.ModelPath+" is empty")
+		return
+	}
+	detail := fmt.Sprintf("%s (%d keys)", cfg.ModelPath, len(model))
+	if _, err := predict.ParseLinearModel(data); err == nil {
+		detail += ", usable for dry-run predictions"
+	}
+	report.add("model_file", StatusPass, start, detail)
+}
@@ -0,0 +1,93 @@
+package quality
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"iot-backend/internal/models"
+)
+
+// Audio format issues reported by CheckAudioFormat
+const (
+	IssueUnsupportedSampleRate = "unsupported_sample_rate"
+	IssueDurationMismatch      = "duration_mismatch"
+	IssueUnalignedSamples      = "unaligned_samples"
+)
+
+// audioBytesPerSample is the size of one 16-bit PCM sample the firmware sends
+const audioBytesPerSample = 2
+
+// AudioFormatCheck is the outcome of checking a clip's declared format against its data
+type AudioFormatCheck struct {
+	ActualDuration float64  // Seconds implied by the byte length and declared sample rate
+	Issues         []string // Empty when the clip is consistent
+}
+
+// OK reports whether the clip passed every format check
+func (c AudioFormatCheck) OK() bool {
+	return len(c.Issues) == 0
+}
+
+// CheckAudioFormat verifies that the clip's sample rate is supported and that its
+// declared duration matches what the byte length implies. A mismatch means level
+// and feature extraction will have run with the wrong timing.
+func (s *Scorer) CheckAudioFormat(recording *models.AudioRecording) AudioFormatCheck {
+	var check AudioFormatCheck
+	frameBytes := audioBytesPerSample * max(recording.Channels, 1)
+
+	if len(recording.Data)%frameBytes != 0 {
+		check.Issues = append(check.Issues, IssueUnalignedSamples)
+	}
+
+	if !s.supportedSampleRate(recording.SampleRate) {
+		check.Issues = append(check.Issues, IssueUnsupportedSampleRate)
+	}
+	if recording.SampleRate <= 0 {
+		return check
+	}
+
+	check.ActualDuration = float64(len(recording.Data)/frameBytes) / float64(recording.SampleRate)
+	// Older firmware omits the duration; nothing to compare against then
+	if recording.Duration > 0 && s.config.AudioDurationTolerance > 0 {
+		diff := math.Abs(recording.Duration - check.ActualDuration)
+		if diff > s.config.AudioDurationTolerance*recording.Duration {
+			check.Issues = append(check.Issues, IssueDurationMismatch)
+		}
+	}
+	return check
+}
+
+// supportedSampleRate reports whether a rate is allowed (any positive rate when none are configured)
+func (s *Scorer) supportedSampleRate(rate int) bool {
+	if rate <= 0 {
+		return false
+	}
+	if len(s.config.AudioSampleRates) == 0 {
+		return true
+	}
+	for _, r := range s.config.AudioSampleRates {
+		if r == rate {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSampleRates parses a comma-separated list of sample rates in Hz
+func ParseSampleRates(spec string) ([]int, error) {
+	var rates []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		rate, err := strconv.Atoi(part)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid sample rate %q", part)
+		}
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
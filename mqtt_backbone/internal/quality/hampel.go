@@ -0,0 +1,79 @@
+package quality
+
+import (
+	"math"
+	"sync"
+
+	"iot-backend/internal/stats"
+)
+
+// HampelConfig holds configuration for the spike rejection filter
+type HampelConfig struct {
+	WindowSize   int     // Number of recent samples the median is computed over
+	Threshold    float64 // Outlier if |x - median| > Threshold * scaled MAD
+	MinDeviation float64 // Deviations below this are never spikes (guards MAD = 0 on flat signals)
+}
+
+// DefaultTemperatureHampelConfig returns spike filter defaults for temperature (Celsius)
+func DefaultTemperatureHampelConfig() HampelConfig {
+	return HampelConfig{WindowSize: 7, Threshold: 3.0, MinDeviation: 2.0}
+}
+
+// DefaultHumidityHampelConfig returns spike filter defaults for humidity (percentage)
+func DefaultHumidityHampelConfig() HampelConfig {
+	return HampelConfig{WindowSize: 7, Threshold: 3.0, MinDeviation: 8.0}
+}
+
+// madScale converts the median absolute deviation into a std dev estimate for normal data
+const madScale = 1.4826
+
+// HampelFilter flags single-sample spikes per device using a rolling median.
+// It is safe for concurrent use.
+type HampelFilter struct {
+	config HampelConfig
+
+	mu      sync.Mutex
+	windows map[string][]float64 // Recent samples per device
+}
+
+// NewHampelFilter creates a new spike filter
+func NewHampelFilter(config HampelConfig) *HampelFilter {
+	return &HampelFilter{
+		config:  config,
+		windows: make(map[string][]float64),
+	}
+}
+
+// Check adds a sample to the device window and reports whether it is a spike,
+// along with the window median it was compared against. Until the window is
+// full, no sample is reported as a spike.
+func (f *HampelFilter) Check(deviceID string, value float64) (bool, float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	window := f.windows[deviceID]
+	isSpike := false
+	median := value
+
+	if len(window) >= f.config.WindowSize && f.config.WindowSize > 0 {
+		median = stats.Median(window)
+		deviations := make([]float64, len(window))
+		for i, v := range window {
+			deviations[i] = math.Abs(v - median)
+		}
+		mad := madScale * stats.Median(deviations)
+
+		deviation := math.Abs(value - median)
+		isSpike = deviation > f.config.MinDeviation && deviation > f.config.Threshold*mad
+	}
+
+	// Spikes still enter the window: the median is robust to them, and a
+	// genuine level shift must eventually be accepted
+	window = append(window, value)
+	if f.config.WindowSize > 0 && len(window) > f.config.WindowSize {
+		window = window[len(window)-f.config.WindowSize:]
+	}
+	f.windows[deviceID] = window
+
+	return isSpike, median
+}
@@ -0,0 +1,187 @@
+package quality
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"iot-backend/internal/aggregator"
+	"iot-backend/internal/models"
+)
+
+// Config holds plausibility limits used when scoring readings
+type Config struct {
+	// Temperature limits (DHT22 operating range by default)
+	TemperatureMin           float64 // Celsius
+	TemperatureMax           float64 // Celsius
+	TemperatureMaxRatePerMin float64 // Max plausible change per minute
+
+	// Humidity limits
+	HumidityMin           float64 // Percentage
+	HumidityMax           float64 // Percentage
+	HumidityMaxRatePerMin float64 // Max plausible change per minute
+
+	// StuckCount is the number of identical consecutive values after which a sensor is considered stuck
+	StuckCount int
+
+	// Audio format limits
+	AudioSampleRates       []int   // Supported sample rates in Hz (empty accepts any)
+	AudioDurationTolerance float64 // Allowed relative difference between declared and actual duration
+	AudioClippingThreshold int     // Absolute sample value above which a sample counts as clipped
+}
+
+// DefaultConfig returns default quality scoring configuration
+func DefaultConfig() Config {
+	return Config{
+		TemperatureMin:           -40.0,
+		TemperatureMax:           80.0,
+		TemperatureMaxRatePerMin: 5.0,
+		HumidityMin:              0.0,
+		HumidityMax:              100.0,
+		HumidityMaxRatePerMin:    20.0,
+		StuckCount:               30,
+		AudioSampleRates:         []int{8000, 16000, 22050, 32000, 44100, 48000},
+		AudioDurationTolerance:   0.05,
+		AudioClippingThreshold:   aggregator.DefaultAudioConfig().ClippingThreshold,
+	}
+}
+
+// Score penalties applied by individual checks
+const (
+	scoreOutOfRange = 0.0
+	scoreRateLimit  = 0.5
+	scoreStuck      = 0.7
+	scoreClipping   = 0.6
+	scoreDeadMic    = 0.3
+	scoreBadFormat  = 0.5
+)
+
+// Result is the outcome of scoring a single reading
+type Result struct {
+	Score  float64 // 0 (unusable) - 1 (fully trusted)
+	Flag   string  // models.QualityGood, QualitySuspect, or QualityBad
+	Reason string  // Check that lowered the score, empty when good
+}
+
+// lastSample tracks the previous reading of a metric for change-rate and stuck checks
+type lastSample struct {
+	value     float64
+	timestamp time.Time
+	repeats   int
+}
+
+// Scorer rates readings per device and metric. It is safe for concurrent use.
+type Scorer struct {
+	config Config
+
+	mu   sync.Mutex
+	last map[string]*lastSample // keyed by device_id + "/" + metric
+}
+
+// NewScorer creates a new quality scorer
+func NewScorer(config Config) *Scorer {
+	return &Scorer{
+		config: config,
+		last:   make(map[string]*lastSample),
+	}
+}
+
+// ScoreTemperature rates a temperature reading
+func (s *Scorer) ScoreTemperature(reading *models.TemperatureReading) Result {
+	return s.scoreScalar(reading.DeviceID, "temperature", reading.Value, reading.Timestamp,
+		s.config.TemperatureMin, s.config.TemperatureMax, s.config.TemperatureMaxRatePerMin)
+}
+
+// ScoreHumidity rates a humidity reading
+func (s *Scorer) ScoreHumidity(reading *models.HumidityReading) Result {
+	return s.scoreScalar(reading.DeviceID, "humidity", reading.Value, reading.Timestamp,
+		s.config.HumidityMin, s.config.HumidityMax, s.config.HumidityMaxRatePerMin)
+}
+
+// ScoreAudio rates an audio clip using its signal characteristics
+func (s *Scorer) ScoreAudio(recording *models.AudioRecording) Result {
+	if len(recording.Data) < 2 {
+		return newResult(scoreOutOfRange, "empty_audio")
+	}
+
+	metrics := s.AnalyzeAudio(recording)
+	switch {
+	case metrics.PeakAmplitude == 0:
+		// All-zero samples: microphone disconnected or I2S misconfigured
+		return newResult(scoreDeadMic, "dead_microphone")
+	case metrics.IsClipping:
+		return newResult(scoreClipping, "clipping")
+	}
+
+	// Timing stats are skewed when the declared format doesn't match the data
+	if check := s.CheckAudioFormat(recording); !check.OK() {
+		return newResult(scoreBadFormat, check.Issues[0])
+	}
+	return newResult(1.0, "")
+}
+
+// AnalyzeAudio measures a clip's signal with the configured clipping threshold
+func (s *Scorer) AnalyzeAudio(recording *models.AudioRecording) aggregator.AudioQualityMetrics {
+	config := aggregator.DefaultAudioConfig()
+	if s.config.AudioClippingThreshold > 0 {
+		config.ClippingThreshold = s.config.AudioClippingThreshold
+	}
+	return aggregator.AnalyzeAudioWithConfig(recording.Data, recording.SampleRate, config)
+}
+
+// scoreScalar applies range, change-rate, and stuck-value checks to a scalar reading
+func (s *Scorer) scoreScalar(deviceID, metric string, value float64, timestamp time.Time, min, max, maxRatePerMin float64) Result {
+	if math.IsNaN(value) || math.IsInf(value, 0) || value < min || value > max {
+		// Out-of-range values don't update the history, so one spike can't poison the rate check
+		return newResult(scoreOutOfRange, "out_of_range")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := deviceID + "/" + metric
+	prev, ok := s.last[key]
+	if !ok {
+		s.last[key] = &lastSample{value: value, timestamp: timestamp}
+		return newResult(1.0, "")
+	}
+
+	result := newResult(1.0, "")
+
+	if elapsed := timestamp.Sub(prev.timestamp).Minutes(); elapsed > 0 && maxRatePerMin > 0 {
+		// Allow at least one minute's worth of change for readings that arrive close together
+		if math.Abs(value-prev.value) > maxRatePerMin*math.Max(elapsed, 1.0) {
+			result = newResult(scoreRateLimit, "change_rate")
+		}
+	}
+
+	if value == prev.value {
+		prev.repeats++
+	} else {
+		prev.repeats = 0
+	}
+	if s.config.StuckCount > 0 && prev.repeats >= s.config.StuckCount && result.Score > scoreStuck {
+		result = newResult(scoreStuck, "stuck_value")
+	}
+
+	prev.value = value
+	prev.timestamp = timestamp
+	return result
+}
+
+// SpikeResult is the result assigned to readings rejected by the spike filter
+func SpikeResult() Result {
+	return newResult(scoreOutOfRange, "spike")
+}
+
+// newResult builds a Result and derives its flag from the score
+func newResult(score float64, reason string) Result {
+	flag := models.QualityGood
+	switch {
+	case score < 0.4:
+		flag = models.QualityBad
+	case score < 0.8:
+		flag = models.QualitySuspect
+	}
+	return Result{Score: score, Flag: flag, Reason: reason}
+}
@@ -0,0 +1,166 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Flags an automation condition may test
+var AutomationFlags = []string{"occupancy", "rain", "wind", "alarm", "frost"}
+
+// Term is one part of an automation condition
+type Term struct {
+	Negate    bool
+	Flag      string     // Set for flag terms
+	Condition *Condition // Set for comparisons
+}
+
+// Expr is an automation condition: terms that must all hold
+type Expr struct {
+	Terms []Term
+}
+
+// Facts are the current values an expression is evaluated against
+type Facts struct {
+	Values map[string]float64 // Metric values; missing metrics are unknown
+	Flags  map[string]bool
+}
+
+// ParseExpr parses terms joined with "and", e.g. "temperature > 24 and occupancy and not rain"
+func ParseExpr(s string) (Expr, error) {
+	var expr Expr
+	for _, part := range strings.Split(s, " and ") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return Expr{}, fmt.Errorf("condition %q has an empty term", s)
+		}
+
+		var term Term
+		if rest, ok := strings.CutPrefix(part, "not "); ok {
+			term.Negate, part = true, strings.TrimSpace(rest)
+		}
+		if strings.ContainsAny(part, "<>=! ") {
+			c, err := ParseCondition(part)
+			if err != nil {
+				return Expr{}, err
+			}
+			term.Condition = &c
+		} else if knownFlag(part) {
+			term.Flag = part
+		} else {
+			return Expr{}, fmt.Errorf("condition %q: unknown flag %q", s, part)
+		}
+		expr.Terms = append(expr.Terms, term)
+	}
+	return expr, nil
+}
+
+func knownFlag(name string) bool {
+	for _, f := range AutomationFlags {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Eval reports whether every term holds. A comparison on an unknown metric never
+// holds, negated or not, so missing data can't trigger an automation.
+func (e Expr) Eval(facts Facts) bool {
+	for _, term := range e.Terms {
+		var holds bool
+		if term.Condition != nil {
+			v, ok := facts.Values[term.Condition.Metric]
+			if !ok {
+				return false
+			}
+			holds = term.Condition.Holds(v)
+		} else {
+			holds = facts.Flags[term.Flag]
+		}
+		if holds == term.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+// Metrics returns the metrics the expression compares
+func (e Expr) Metrics() []string {
+	var metrics []string
+	for _, term := range e.Terms {
+		if term.Condition != nil {
+			metrics = append(metrics, term.Condition.Metric)
+		}
+	}
+	return metrics
+}
+
+// Automation opens (or closes) windows to a position while its condition holds
+type Automation struct {
+	Name string  `yaml:"name"`
+	When string  `yaml:"when"`
+	Open float64 `yaml:"open"` // Window position (0-100%)
+
+	Expr Expr `yaml:"-"`
+}
+
+// AutomationSet is a parsed automations file
+type AutomationSet struct {
+	IntervalSeconds int          `yaml:"interval_seconds"` // How often automations are evaluated (default 60)
+	Automations     []Automation `yaml:"automations"`
+}
+
+// ParseAutomations reads and validates an automations file
+func ParseAutomations(r io.Reader) (*AutomationSet, error) {
+	set := &AutomationSet{}
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(set); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to decode automations: %w", err)
+	}
+	if set.IntervalSeconds <= 0 {
+		set.IntervalSeconds = 60
+	}
+
+	names := make(map[string]bool)
+	for i := range set.Automations {
+		a := &set.Automations[i]
+		if a.Name == "" {
+			return nil, fmt.Errorf("automation %d has no name", i)
+		}
+		if names[a.Name] {
+			return nil, fmt.Errorf("duplicate automation name %q", a.Name)
+		}
+		names[a.Name] = true
+
+		expr, err := ParseExpr(a.When)
+		if err != nil {
+			return nil, fmt.Errorf("automation %q: %w", a.Name, err)
+		}
+		for _, metric := range expr.Metrics() {
+			if !isReadingMetric(metric) {
+				return nil, fmt.Errorf("automation %q: %s is a flag, not a metric (write \"%s\" or \"not %s\")", a.Name, metric, metric, metric)
+			}
+		}
+		a.Expr = expr
+		if a.Open < 0 || a.Open > 100 {
+			return nil, fmt.Errorf("automation %q: open %.0f outside 0-100", a.Name, a.Open)
+		}
+	}
+	return set, nil
+}
+
+// Match returns the first automation whose condition holds
+func (s *AutomationSet) Match(facts Facts) (*Automation, bool) {
+	for i := range s.Automations {
+		if s.Automations[i].Expr.Eval(facts) {
+			return &s.Automations[i], true
+		}
+	}
+	return nil, false
+}
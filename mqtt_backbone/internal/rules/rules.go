@@ -0,0 +1,205 @@
+// Package rules defines operator-written window rules: safety rules evaluated
+// against recorded history (what-if), and automations the backend runs live.
+//
+// A safety rule set is a JSON file:
+//
+//	{
+//	  "hold_minutes": 15,
+//	  "rules": [
+//	    {"name": "storm", "when": "wind >= 12", "action": "close"},
+//	    {"name": "cold-damp", "when": "temperature <= 5 and humidity > 80", "action": "cap", "position": 20, "hysteresis": 1}
+//	  ]
+//	}
+//
+// Conditions compare a metric with a number: sensor readings ("temperature",
+// "humidity", "sound_volume") or safety event values ("rain", "wind", "alarm",
+// "outdoor_temperature"). A rule fires when all of its conditions hold and stays
+// active until one of them fails by more than the rule's hysteresis.
+//
+// Automations are local window rules the backend evaluates itself, so basic
+// behaviour keeps working while the ML service is unavailable. They are written
+// in YAML:
+//
+//	interval_seconds: 60
+//	automations:
+//	  - name: cool-occupied-room
+//	    when: temperature > 24 and occupancy and not rain
+//	    open: 40
+//	  - name: damp
+//	    when: humidity >= 75 and not wind
+//	    open: 20
+//
+// The first automation whose condition holds sets the position. Besides metric
+// comparisons, a condition may test flags: "occupancy" (the room is estimated to be
+// occupied) and the safety conditions ("rain", "wind", "alarm", "frost") while they
+// hold the window. Any term may be negated with "not".
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Metrics that conditions may reference
+var (
+	ReadingMetrics = []string{"temperature", "humidity", "sound_volume"}
+	SafetyMetrics  = []string{"rain", "wind", "alarm", "outdoor_temperature"}
+)
+
+// Rule actions
+const (
+	ActionClose = "close" // Force the window shut
+	ActionCap   = "cap"   // Limit the opening to Position
+)
+
+// Condition compares one metric with a threshold, e.g. "wind >= 12"
+type Condition struct {
+	Metric string
+	Op     string // "<", "<=", ">", ">=", "==", "!="
+	Value  float64
+}
+
+// ParseCondition parses "metric op value"
+func ParseCondition(s string) (Condition, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		return Condition{}, fmt.Errorf("condition %q must be \"metric op value\"", s)
+	}
+	c := Condition{Metric: fields[0], Op: fields[1]}
+	if !knownMetric(c.Metric) {
+		return Condition{}, fmt.Errorf("condition %q: unknown metric %q", s, c.Metric)
+	}
+	switch c.Op {
+	case "<", "<=", ">", ">=", "==", "!=":
+	default:
+		return Condition{}, fmt.Errorf("condition %q: unknown operator %q", s, c.Op)
+	}
+	v, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return Condition{}, fmt.Errorf("condition %q: invalid value: %w", s, err)
+	}
+	c.Value = v
+	return c, nil
+}
+
+func knownMetric(name string) bool {
+	for _, m := range ReadingMetrics {
+		if m == name {
+			return true
+		}
+	}
+	for _, m := range SafetyMetrics {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Holds reports whether the condition is met by v
+func (c Condition) Holds(v float64) bool {
+	switch c.Op {
+	case "<":
+		return v < c.Value
+	case "<=":
+		return v <= c.Value
+	case ">":
+		return v > c.Value
+	case ">=":
+		return v >= c.Value
+	case "==":
+		return v == c.Value
+	case "!=":
+		return v != c.Value
+	}
+	return false
+}
+
+// Releases reports whether an active condition lets go at v: it must fail by more
+// than hysteresis, so a value hovering around the threshold does not flap.
+// Equality conditions have no hysteresis.
+func (c Condition) Releases(v, hysteresis float64) bool {
+	switch c.Op {
+	case "<", "<=":
+		return v > c.Value+hysteresis
+	case ">", ">=":
+		return v < c.Value-hysteresis
+	}
+	return !c.Holds(v)
+}
+
+// String formats the condition in ParseCondition syntax
+func (c Condition) String() string {
+	return fmt.Sprintf("%s %s %s", c.Metric, c.Op, strconv.FormatFloat(c.Value, 'f', -1, 64))
+}
+
+// Rule is one safety rule
+type Rule struct {
+	Name       string  `json:"name"`
+	When       string  `json:"when"`                 // Conditions joined with "and"
+	Action     string  `json:"action"`               // ActionClose or ActionCap
+	Position   float64 `json:"position,omitempty"`   // Maximum opening for caps (0-100%)
+	Hysteresis float64 `json:"hysteresis,omitempty"` // Release margin in the metric's unit
+
+	Conditions []Condition `json:"-"`
+}
+
+// RuleSet is a parsed rule file
+type RuleSet struct {
+	HoldMinutes int    `json:"hold_minutes"` // How long a safety event's value stays in effect (default 15)
+	Rules       []Rule `json:"rules"`
+}
+
+// Parse reads and validates a rule set
+func Parse(r io.Reader) (*RuleSet, error) {
+	set := &RuleSet{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(set); err != nil {
+		return nil, fmt.Errorf("failed to decode rules: %w", err)
+	}
+	if set.HoldMinutes <= 0 {
+		set.HoldMinutes = 15
+	}
+	if len(set.Rules) == 0 {
+		return nil, fmt.Errorf("rule set has no rules")
+	}
+
+	names := make(map[string]bool)
+	for i := range set.Rules {
+		rule := &set.Rules[i]
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d has no name", i)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate rule name %q", rule.Name)
+		}
+		names[rule.Name] = true
+
+		for _, clause := range strings.Split(rule.When, " and ") {
+			c, err := ParseCondition(clause)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+			}
+			rule.Conditions = append(rule.Conditions, c)
+		}
+
+		switch rule.Action {
+		case ActionClose:
+			rule.Position = 0
+		case ActionCap:
+			if rule.Position < 0 || rule.Position > 100 {
+				return nil, fmt.Errorf("rule %q: cap position %.0f outside 0-100", rule.Name, rule.Position)
+			}
+		default:
+			return nil, fmt.Errorf("rule %q: action must be %q or %q", rule.Name, ActionClose, ActionCap)
+		}
+		if rule.Hysteresis < 0 {
+			return nil, fmt.Errorf("rule %q: hysteresis must not be negative", rule.Name)
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,255 @@
+package rules
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"iot-backend/internal/arbitration"
+)
+
+// Sample is a metric value observed at a point in time
+type Sample struct {
+	Timestamp time.Time
+	Metric    string
+	Value     float64
+}
+
+// Prediction is a recorded ML window decision
+type Prediction struct {
+	Timestamp time.Time
+	Position  float64
+}
+
+// Timeline is the recorded history of one device
+type Timeline struct {
+	DeviceID    string
+	From, To    time.Time
+	Samples     []Sample      // Readings and safety event values, in any order
+	Predictions []Prediction  // ML decisions, in any order
+	ReadingTTL  time.Duration // How long a reading stays current without a newer one
+}
+
+// RuleReport summarizes how one rule behaved over the replayed history
+type RuleReport struct {
+	Name          string  `json:"name"`
+	Activations   int     `json:"activations"`    // Times the rule started firing
+	ActiveMinutes float64 `json:"active_minutes"` // Total time the rule was firing
+	Overridden    int     `json:"overridden"`     // ML decisions this rule replaced with its own position
+	Capped        int     `json:"capped"`         // ML decisions this rule limited
+}
+
+// Report is the outcome of a what-if evaluation
+type Report struct {
+	From        time.Time    `json:"from"`
+	To          time.Time    `json:"to"`
+	Devices     int          `json:"devices"`
+	MLDecisions int          `json:"ml_decisions"`
+	Changed     int          `json:"changed"` // ML decisions whose position the rules would have changed
+	Rules       []RuleReport `json:"rules"`
+}
+
+// Evaluator replays device timelines against a rule set. Each rule is arbitrated
+// as a safety proposal next to the recorded ML decision, exactly as the window
+// service would, so the report reflects live priority and cap handling.
+type Evaluator struct {
+	set    *RuleSet
+	report Report
+	index  map[string]int // rule name -> index in report.Rules
+}
+
+// NewEvaluator creates an evaluator for a rule set
+func NewEvaluator(set *RuleSet, from, to time.Time) *Evaluator {
+	e := &Evaluator{
+		set:    set,
+		report: Report{From: from, To: to},
+		index:  make(map[string]int),
+	}
+	for i, rule := range set.Rules {
+		e.report.Rules = append(e.report.Rules, RuleReport{Name: rule.Name})
+		e.index[rule.Name] = i
+	}
+	return e
+}
+
+// Report returns the results accumulated so far
+func (e *Evaluator) Report() Report {
+	return e.report
+}
+
+// current is the latest value of a metric and when it stops applying
+type current struct {
+	value   float64
+	expires time.Time
+}
+
+// replayEvent is a sample or prediction in time order
+type replayEvent struct {
+	at         time.Time
+	sample     *Sample
+	prediction *Prediction
+}
+
+// Replay evaluates one device's history
+func (e *Evaluator) Replay(tl *Timeline) {
+	e.report.Devices++
+
+	events := make([]replayEvent, 0, len(tl.Samples)+len(tl.Predictions))
+	for i := range tl.Samples {
+		events = append(events, replayEvent{at: tl.Samples[i].Timestamp, sample: &tl.Samples[i]})
+	}
+	for i := range tl.Predictions {
+		events = append(events, replayEvent{at: tl.Predictions[i].Timestamp, prediction: &tl.Predictions[i]})
+	}
+	// Samples sort before predictions at the same instant, as the live service
+	// would have seen the reading before deciding
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].at.Equal(events[j].at) {
+			return events[i].at.Before(events[j].at)
+		}
+		return events[i].sample != nil && events[j].sample == nil
+	})
+
+	safetyTTL := time.Duration(e.set.HoldMinutes) * time.Minute
+	values := make(map[string]current)
+	activeSince := make([]time.Time, len(e.set.Rules)) // Zero while the rule is not firing
+
+	for _, ev := range events {
+		if ev.sample != nil {
+			ttl := tl.ReadingTTL
+			if !isReadingMetric(ev.sample.Metric) {
+				ttl = safetyTTL
+			}
+			values[ev.sample.Metric] = current{value: ev.sample.Value, expires: ev.at.Add(ttl)}
+		}
+		e.evaluate(values, activeSince, ev.at)
+
+		if ev.prediction != nil {
+			e.decide(tl.DeviceID, ev.prediction, activeSince)
+		}
+	}
+
+	// Close out rules still firing at the end of the range
+	for i, since := range activeSince {
+		if !since.IsZero() {
+			end := tl.To
+			if expiry := e.inputsExpire(e.set.Rules[i], values); expiry.Before(end) {
+				end = expiry
+			}
+			e.addActive(i, since, end)
+		}
+	}
+}
+
+// evaluate updates which rules are firing at now
+func (e *Evaluator) evaluate(values map[string]current, activeSince []time.Time, now time.Time) {
+	for i, rule := range e.set.Rules {
+		if activeSince[i].IsZero() {
+			if e.fires(rule, values, now) {
+				activeSince[i] = now
+				e.report.Rules[i].Activations++
+			}
+			continue
+		}
+
+		if expiry := e.inputsExpire(rule, values); !expiry.After(now) {
+			e.addActive(i, activeSince[i], expiry)
+			activeSince[i] = time.Time{}
+			continue
+		}
+		for _, c := range rule.Conditions {
+			if c.Releases(values[c.Metric].value, rule.Hysteresis) {
+				e.addActive(i, activeSince[i], now)
+				activeSince[i] = time.Time{}
+				break
+			}
+		}
+	}
+}
+
+// fires reports whether every condition of a rule holds on current values
+func (e *Evaluator) fires(rule Rule, values map[string]current, now time.Time) bool {
+	for _, c := range rule.Conditions {
+		v, ok := values[c.Metric]
+		if !ok || !v.expires.After(now) || !c.Holds(v.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// inputsExpire returns when the first value a rule depends on goes stale
+func (e *Evaluator) inputsExpire(rule Rule, values map[string]current) time.Time {
+	var earliest time.Time
+	for _, c := range rule.Conditions {
+		if v := values[c.Metric]; earliest.IsZero() || v.expires.Before(earliest) {
+			earliest = v.expires
+		}
+	}
+	return earliest
+}
+
+func (e *Evaluator) addActive(i int, from, to time.Time) {
+	if to.After(from) {
+		e.report.Rules[i].ActiveMinutes += to.Sub(from).Minutes()
+	}
+}
+
+// decide arbitrates a recorded ML decision against the firing rules
+func (e *Evaluator) decide(deviceID string, p *Prediction, activeSince []time.Time) {
+	e.report.MLDecisions++
+
+	proposals := []arbitration.Proposal{{
+		DeviceID:  deviceID,
+		Source:    arbitration.SourceML,
+		Position:  p.Position,
+		Reason:    "recorded ml prediction",
+		CreatedAt: p.Timestamp,
+	}}
+	for i, rule := range e.set.Rules {
+		if activeSince[i].IsZero() {
+			continue
+		}
+		proposals = append(proposals, arbitration.Proposal{
+			DeviceID:  deviceID,
+			Source:    arbitration.SourceSafety,
+			Key:       rule.Name,
+			Position:  rule.Position,
+			Cap:       rule.Action == ActionCap,
+			Reason:    fmt.Sprintf("rule %s (%s)", rule.Name, rule.When),
+			CreatedAt: activeSince[i],
+		})
+	}
+	if len(proposals) == 1 {
+		return
+	}
+
+	decision := arbitration.Resolve(deviceID, p.Timestamp, proposals)
+	if decision.Position != p.Position {
+		e.report.Changed++
+	}
+	for _, entry := range decision.Trace {
+		if entry.Source != arbitration.SourceSafety {
+			continue
+		}
+		i, ok := e.index[entry.Key]
+		if !ok {
+			continue
+		}
+		switch entry.Outcome {
+		case "won", "capped": // A rule set the position (possibly limited by another rule)
+			e.report.Rules[i].Overridden++
+		case "applied_cap":
+			e.report.Rules[i].Capped++
+		}
+	}
+}
+
+func isReadingMetric(name string) bool {
+	for _, m := range ReadingMetrics {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"iot-backend/internal/arbitration"
+	"iot-backend/internal/models"
+)
+
+// minMovement is the smallest position change (%) counted as a movement; smaller
+// changes are resent commands, not travel
+const minMovement = 0.5
+
+// ActuationBudgetConfig holds the daily movement budget of every window, protecting
+// cheap actuators from wearing out under a flapping model. Devices can override it
+// with an "actuation_budget" object in their registry config, e.g.
+// {"actuation_budget": {"max_movements": 20, "max_travel": 400}}.
+type ActuationBudgetConfig struct {
+	MaxMovements int     // Movements per local day (0 = unlimited)
+	MaxTravel    float64 // Summed position change per local day, in % (0 = unlimited)
+}
+
+// DefaultActuationBudgetConfig returns default configuration (no budget)
+func DefaultActuationBudgetConfig() ActuationBudgetConfig {
+	return ActuationBudgetConfig{}
+}
+
+// withOverrides applies a device's registry "actuation_budget" object to the defaults
+func (c ActuationBudgetConfig) withOverrides(stored map[string]interface{}) ActuationBudgetConfig {
+	values := numberMap(stored["actuation_budget"])
+	if v, ok := values["max_movements"]; ok && v >= 0 {
+		c.MaxMovements = int(v)
+	}
+	if v, ok := values["max_travel"]; ok && v >= 0 {
+		c.MaxTravel = v
+	}
+	return c
+}
+
+// unlimited reports whether neither limit is set
+func (c ActuationBudgetConfig) unlimited() bool {
+	return c.MaxMovements == 0 && c.MaxTravel == 0
+}
+
+// actuationBudgetTTL is how long a device's registry overrides are cached
+const actuationBudgetTTL = 5 * time.Minute
+
+// budgetDevice is the budget consumption of one window over the current day
+type budgetDevice struct {
+	day       time.Time // Local midnight the counts belong to
+	movements int
+	travel    float64
+	denied    int
+
+	position float64 // Last commanded position
+	known    bool    // position is set
+
+	settings ActuationBudgetConfig
+	loadedAt time.Time
+}
+
+// actuationBudget enforces the daily movement budget on the control path. Safety
+// and manual decisions always move the window (and consume budget); schedule,
+// rules and ML decisions are held at the current position once the budget is spent.
+// Safe for concurrent use.
+type actuationBudget struct {
+	defaults ActuationBudgetConfig
+	lookup   func(deviceID string) map[string]interface{} // Registry config of a device (nil if unknown)
+
+	mu      sync.Mutex
+	devices map[string]*budgetDevice
+}
+
+func newActuationBudget(defaults ActuationBudgetConfig, lookup func(deviceID string) map[string]interface{}) *actuationBudget {
+	return &actuationBudget{
+		defaults: defaults,
+		lookup:   lookup,
+		devices:  make(map[string]*budgetDevice),
+	}
+}
+
+// device returns a device's state for the day containing now, with fresh settings.
+// Must be called with b.mu held; it releases the lock around registry reads.
+func (b *actuationBudget) device(deviceID string, now time.Time) *budgetDevice {
+	dev, ok := b.devices[deviceID]
+	if !ok || now.Sub(dev.loadedAt) > actuationBudgetTTL {
+		b.mu.Unlock()
+		settings := b.defaults.withOverrides(b.lookup(deviceID))
+		b.mu.Lock()
+		if dev = b.devices[deviceID]; dev == nil {
+			dev = &budgetDevice{}
+			b.devices[deviceID] = dev
+		}
+		dev.settings, dev.loadedAt = settings, now
+	}
+	if day := localMidnight(now); !dev.day.Equal(day) {
+		dev.day, dev.movements, dev.travel, dev.denied = day, 0, 0, 0
+	}
+	return dev
+}
+
+// allow decides whether a decision may move the window. It returns false and the
+// reason when the decision has to hold the current position instead. The movement
+// only counts once it is committed.
+func (b *actuationBudget) allow(decision arbitration.Decision, now time.Time) (bool, string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	dev := b.device(decision.DeviceID, now)
+	travel := 0.0
+	if dev.known {
+		travel = math.Abs(decision.Position - dev.position)
+		if travel < minMovement {
+			return true, ""
+		}
+	}
+
+	// Safety and user decisions always move, also when they only cap another source's
+	// position (e.g., frost limiting an ML opening)
+	exempt := decision.Winner == arbitration.SourceSafety || decision.Winner == arbitration.SourceManual ||
+		decision.CappedBy(arbitration.SourceSafety) || decision.CappedBy(arbitration.SourceManual)
+	if !exempt && dev.known && !dev.settings.unlimited() {
+		if dev.settings.MaxMovements > 0 && dev.movements >= dev.settings.MaxMovements {
+			dev.denied++
+			return false, fmt.Sprintf("daily budget of %d movements spent", dev.settings.MaxMovements)
+		}
+		if dev.settings.MaxTravel > 0 && dev.travel+travel > dev.settings.MaxTravel {
+			dev.denied++
+			return false, fmt.Sprintf("daily travel budget of %.0f%% spent (%.0f%% used, move needs %.0f%%)",
+				dev.settings.MaxTravel, dev.travel, travel)
+		}
+	}
+
+	return true, ""
+}
+
+// commit records a movement to position once its command was sent to the actuator
+func (b *actuationBudget) commit(deviceID string, position float64, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	dev := b.device(deviceID, now)
+	travel := 0.0
+	if dev.known {
+		travel = math.Abs(position - dev.position)
+		if travel < minMovement {
+			return
+		}
+	}
+	dev.movements++
+	dev.travel += travel
+	dev.position, dev.known = position, true
+}
+
+// position returns a device's last commanded position, if known
+func (b *actuationBudget) position(deviceID string) (float64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if dev, ok := b.devices[deviceID]; ok && dev.known {
+		return dev.position, true
+	}
+	return 0, false
+}
+
+// restore replays today's commanded positions (so a restart doesn't refill the
+// budget) and the last position before today
+func (b *actuationBudget) restore(initial map[string]float64, changes map[string][]models.WindowAction, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	day := localMidnight(now)
+	restore := func(deviceID string) *budgetDevice {
+		dev, ok := b.devices[deviceID]
+		if !ok {
+			dev = &budgetDevice{day: day, settings: b.defaults}
+			b.devices[deviceID] = dev
+		}
+		return dev
+	}
+	for id, position := range initial {
+		dev := restore(id)
+		dev.position, dev.known = position, true
+	}
+	for id, actions := range changes {
+		dev := restore(id)
+		for _, a := range actions {
+			if dev.known {
+				travel := math.Abs(a.Position - dev.position)
+				if travel < minMovement {
+					continue
+				}
+				dev.travel += travel
+			}
+			dev.movements++
+			dev.position, dev.known = a.Position, true
+		}
+	}
+}
+
+// usage returns a device's budget consumption today
+func (b *actuationBudget) usage(deviceID string, now time.Time) *models.ActuationBudget {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	dev := b.device(deviceID, now)
+	usage := &models.ActuationBudget{
+		DeviceID:     deviceID,
+		Day:          dev.day,
+		Movements:    dev.movements,
+		MaxMovements: dev.settings.MaxMovements,
+		Travel:       dev.travel,
+		MaxTravel:    dev.settings.MaxTravel,
+		Denied:       dev.denied,
+	}
+	if dev.settings.MaxMovements > 0 {
+		remaining := max(dev.settings.MaxMovements-dev.movements, 0)
+		usage.RemainingMovements = &remaining
+	}
+	if dev.settings.MaxTravel > 0 {
+		remaining := math.Max(dev.settings.MaxTravel-dev.travel, 0)
+		usage.RemainingTravel = &remaining
+	}
+	if dev.known {
+		position := dev.position
+		usage.Position = &position
+	}
+	return usage
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"iot-backend/internal/aggregator"
+	"iot-backend/internal/models"
+)
+
+// agcGainKey is the registry config key holding the fixed gain (dB) a device's
+// microphone AGC applies, for firmware that doesn't report it per clip
+const agcGainKey = "agc_gain_db"
+
+// agcGainTTL is how long a device's registry gain is cached
+const agcGainTTL = 5 * time.Minute
+
+// cachedGain is a device's gain as last read from the registry
+type cachedGain struct {
+	gain     float64
+	loadedAt time.Time
+}
+
+// agcCompensation undoes the gain of AGC microphones so levels reflect the room, not
+// the AGC's target level. Safe for concurrent use.
+type agcCompensation struct {
+	lookup func(deviceID string) map[string]interface{} // Registry config of a device (nil if unknown)
+
+	mu    sync.Mutex
+	gains map[string]cachedGain
+}
+
+func newAGCCompensation(lookup func(deviceID string) map[string]interface{}) *agcCompensation {
+	return &agcCompensation{lookup: lookup, gains: make(map[string]cachedGain)}
+}
+
+// gain returns the AGC gain of a clip: the one the firmware reported, or else the device's registry gain
+func (a *agcCompensation) gain(recording *models.AudioRecording, now time.Time) float64 {
+	if recording.AGCGainDB != nil {
+		return *recording.AGCGainDB
+	}
+
+	a.mu.Lock()
+	cached, ok := a.gains[recording.DeviceID]
+	a.mu.Unlock()
+	if ok && now.Sub(cached.loadedAt) < agcGainTTL {
+		return cached.gain
+	}
+
+	// Registry reads happen outside the lock; a slow registry must not stall other devices
+	gain, _ := a.lookup(recording.DeviceID)[agcGainKey].(float64)
+
+	a.mu.Lock()
+	a.gains[recording.DeviceID] = cachedGain{gain: gain, loadedAt: now}
+	a.mu.Unlock()
+	return gain
+}
+
+// compensate subtracts a clip's AGC gain from its volume and frame levels and returns
+// the compensated volume. The applied gain is recorded on the clip.
+func (a *agcCompensation) compensate(recording *models.AudioRecording, volume float64, levels []float64) float64 {
+	gain := a.gain(recording, time.Now())
+	recording.AGCCompensation = gain
+	if gain == 0 {
+		return volume
+	}
+	for i := range levels {
+		levels[i] = aggregator.CompensateAGC(levels[i], gain)
+	}
+	for i := range recording.ChannelVolumes {
+		recording.ChannelVolumes[i] = aggregator.CompensateAGC(recording.ChannelVolumes[i], gain)
+	}
+	return aggregator.CompensateAGC(volume, gain)
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// audioDedup remembers recent clip hashes per device so retransmitted clips
+// (e.g., QoS 1 redeliveries after a lost PUBACK) are stored and acted on once
+type audioDedup struct {
+	window time.Duration // Zero disables suppression
+
+	mu   sync.Mutex
+	seen map[string]map[string]time.Time // Device ID -> clip hash -> first seen
+}
+
+func newAudioDedup(window time.Duration) *audioDedup {
+	return &audioDedup{
+		window: window,
+		seen:   make(map[string]map[string]time.Time),
+	}
+}
+
+// duplicate reports whether a device sent a clip with the same hash within
+// the window, and otherwise remembers the hash
+func (d *audioDedup) duplicate(deviceID, hash string, now time.Time) bool {
+	if d.window <= 0 {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	hashes, ok := d.seen[deviceID]
+	if !ok {
+		hashes = make(map[string]time.Time)
+		d.seen[deviceID] = hashes
+	}
+	for h, seen := range hashes {
+		if now.Sub(seen) >= d.window {
+			delete(hashes, h)
+		}
+	}
+
+	if _, ok := hashes[hash]; ok {
+		return true
+	}
+	hashes[hash] = now
+	return false
+}
+
+// forget drops a hash so a retransmission is accepted (e.g., the first copy failed to save)
+func (d *audioDedup) forget(deviceID, hash string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.seen[deviceID], hash)
+}
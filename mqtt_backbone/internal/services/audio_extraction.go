@@ -0,0 +1,237 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"iot-backend/internal/clipstore"
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+)
+
+// AudioExtractionConfig holds settings for deferred audio feature extraction
+type AudioExtractionConfig struct {
+	Workers        int           // Concurrent extractions (clips of one device stay in order)
+	QueueSize      int           // Clips queued per worker; the rest wait in the store
+	RescanInterval time.Duration // How often the store is checked for clips that didn't fit the queue
+}
+
+// DefaultAudioExtractionConfig returns default deferred extraction settings
+func DefaultAudioExtractionConfig() AudioExtractionConfig {
+	return AudioExtractionConfig{
+		Workers:        2,
+		QueueSize:      50,
+		RescanInterval: time.Minute,
+	}
+}
+
+// storedClip is the object written to the clip store: the recording as received
+type storedClip struct {
+	DeviceID   string    `json:"device_id"`
+	Timestamp  time.Time `json:"timestamp"`
+	SampleRate int       `json:"sample_rate"`
+	Duration   float64   `json:"duration"`
+	Format     string    `json:"format"`
+	Channels   int       `json:"channels"`
+	Hash       string    `json:"hash"`
+	Data       []byte    `json:"data"`
+}
+
+// deferredClip is a stored clip waiting for extraction
+type deferredClip struct {
+	key       string
+	recording *models.AudioRecording
+	hash      string
+}
+
+// audioExtraction persists clips on receipt and extracts their features later, so
+// ingestion latency doesn't depend on DSP work. Clips are deleted from the store
+// once their metadata is saved; clips left over from a restart or a full queue are
+// picked up by the periodic rescan.
+type audioExtraction struct {
+	store   clipstore.Store
+	extract func(recording *models.AudioRecording, hash string) bool // Returns false when the clip should be retried
+	shards  *deviceShards[*deferredClip]
+	rescan  time.Duration
+
+	mu     sync.Mutex
+	queued map[string]bool // Keys in a worker queue or being extracted
+}
+
+func newAudioExtraction(store clipstore.Store, config AudioExtractionConfig, extract func(*models.AudioRecording, string) bool) *audioExtraction {
+	e := &audioExtraction{
+		store:   store,
+		extract: extract,
+		rescan:  config.RescanInterval,
+		queued:  make(map[string]bool),
+	}
+	e.shards = newDeviceShards(config.Workers, config.QueueSize, e.process)
+	return e
+}
+
+// clipKey names the object of a clip; keys sort by receipt time
+func clipKey(recording *models.AudioRecording, hash string) string {
+	return fmt.Sprintf("%020d-%s.json", recording.Timestamp.UnixNano(), hash[:16])
+}
+
+// submit stores a clip and queues it for extraction. It returns false when the clip
+// couldn't be stored, in which case the caller processes it inline.
+func (e *audioExtraction) submit(recording *models.AudioRecording, hash string) bool {
+	data, err := json.Marshal(storedClip{
+		DeviceID:   recording.DeviceID,
+		Timestamp:  recording.Timestamp,
+		SampleRate: recording.SampleRate,
+		Duration:   recording.Duration,
+		Format:     recording.Format,
+		Channels:   recording.Channels,
+		Hash:       hash,
+		Data:       recording.Data,
+	})
+	if err != nil {
+		log.Printf("Error encoding audio clip from %s: %v", recording.DeviceID, err)
+		return false
+	}
+
+	key := clipKey(recording, hash)
+	if err := e.store.Put(key, data); err != nil {
+		log.Printf("Error storing audio clip from %s, extracting inline: %v", recording.DeviceID, err)
+		metrics.Default.Counter("audio_clip_store_errors").Inc()
+		return false
+	}
+	metrics.Default.Counter("audio_clips_deferred").Inc()
+
+	if !e.enqueue(&deferredClip{key: key, recording: recording, hash: hash}) {
+		metrics.Default.Counter("audio_extraction_backlogged").Inc()
+	}
+	return true
+}
+
+// enqueue hands a clip to its device's worker unless it is already queued. It
+// returns false when the worker queue is full; the clip then waits for a rescan.
+func (e *audioExtraction) enqueue(clip *deferredClip) bool {
+	e.mu.Lock()
+	if e.queued[clip.key] {
+		e.mu.Unlock()
+		return true
+	}
+	e.queued[clip.key] = true
+	e.mu.Unlock()
+
+	queued := metrics.Default.Gauge("audio_extraction_queued")
+	queued.Add(1)
+	if e.shards.tryDispatch(clip.recording.DeviceID, clip) {
+		return true
+	}
+	queued.Add(-1)
+	e.done(clip.key)
+	return false
+}
+
+// done forgets a queued key
+func (e *audioExtraction) done(key string) {
+	e.mu.Lock()
+	delete(e.queued, key)
+	e.mu.Unlock()
+}
+
+// process extracts one clip and removes it from the store
+func (e *audioExtraction) process(clip *deferredClip) {
+	defer e.done(clip.key)
+	defer metrics.Default.Gauge("audio_extraction_queued").Add(-1)
+
+	start := time.Now()
+	if !e.extract(clip.recording, clip.hash) {
+		return // Kept in the store for the next rescan
+	}
+	metrics.Default.Timer("audio_extraction").Since(start)
+	if err := e.store.Delete(clip.key); err != nil {
+		log.Printf("Error removing extracted audio clip %s: %v", clip.key, err)
+	}
+}
+
+// decodeClip reads a stored clip back into a recording
+func decodeClip(key string, data []byte) (*deferredClip, error) {
+	var stored storedClip
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to decode audio clip %s: %w", key, err)
+	}
+	if stored.DeviceID == "" || stored.Hash == "" {
+		return nil, fmt.Errorf("audio clip %s has no device ID or hash", key)
+	}
+	recording := &models.AudioRecording{
+		Timestamp:  stored.Timestamp,
+		DeviceID:   stored.DeviceID,
+		Data:       stored.Data,
+		SampleRate: stored.SampleRate,
+		Duration:   stored.Duration,
+		Format:     stored.Format,
+		Channels:   stored.Channels,
+	}
+	return &deferredClip{key: key, recording: recording, hash: stored.Hash}, nil
+}
+
+// rescanStore queues stored clips that aren't queued yet, oldest first, until the
+// worker queues are full
+func (e *audioExtraction) rescanStore() {
+	keys, err := e.store.List()
+	if err != nil {
+		log.Printf("Error listing audio clip store: %v", err)
+		return
+	}
+
+	queued := 0
+	for _, key := range keys {
+		e.mu.Lock()
+		busy := e.queued[key]
+		e.mu.Unlock()
+		if busy {
+			continue
+		}
+
+		data, err := e.store.Get(key)
+		if errors.Is(err, clipstore.ErrNotFound) {
+			continue // Extracted since the listing
+		}
+		if err != nil {
+			log.Printf("Error reading stored audio clip: %v", err)
+			return
+		}
+		clip, err := decodeClip(key, data)
+		if err != nil {
+			// A corrupt clip would be retried forever
+			log.Printf("Discarding stored audio clip: %v", err)
+			e.store.Delete(key)
+			continue
+		}
+		if !e.enqueue(clip) {
+			break
+		}
+		queued++
+	}
+	if queued > 0 {
+		log.Printf("Audio extraction: Queued %d stored clips (%d in store)", queued, len(keys))
+	}
+}
+
+// run starts the workers and rescans the store until context is cancelled. The
+// first scan picks up clips stored before a restart.
+func (e *audioExtraction) run(ctx context.Context) {
+	e.shards.run(ctx)
+	e.rescanStore()
+
+	ticker := time.NewTicker(e.rescan)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.rescanStore()
+		}
+	}
+}
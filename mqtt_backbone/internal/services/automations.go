@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"iot-backend/internal/arbitration"
+	"iot-backend/internal/derived"
+	"iot-backend/internal/models"
+	"iot-backend/internal/rules"
+	"iot-backend/internal/state"
+)
+
+// AutomationConfig holds configuration for local automations
+type AutomationConfig struct {
+	StaleMinutes int // Devices without a reading for this long are not evaluated
+}
+
+// DefaultAutomationConfig returns default configuration
+func DefaultAutomationConfig() AutomationConfig {
+	return AutomationConfig{
+		StaleMinutes: 10,
+	}
+}
+
+// AutomationTarget applies automation decisions (the window control service)
+type AutomationTarget interface {
+	ApplyAutomation(deviceID string, automation *rules.Automation, hold time.Duration)
+	ReleaseAutomation(deviceID string)
+	ActiveSafety(deviceID string) []string
+}
+
+// appliedAutomation is the automation currently holding a device's window
+type appliedAutomation struct {
+	name      string
+	position  float64
+	refreshed time.Time
+}
+
+// AutomationService evaluates the automations file against each device's latest
+// readings on an interval and proposes the matching position. Its proposals
+// expire unless renewed, so a stopped backend never leaves an automation in force.
+type AutomationService struct {
+	set      *rules.AutomationSet
+	state    *state.Store
+	target   AutomationTarget
+	interval time.Duration
+	stale    time.Duration
+
+	// Optional occupancy estimator for the "occupancy" flag; set before Start
+	Occupancy *derived.OccupancyEstimator
+
+	mu      sync.Mutex
+	applied map[string]appliedAutomation
+}
+
+// NewAutomationService creates a new automation service
+func NewAutomationService(set *rules.AutomationSet, store *state.Store, target AutomationTarget, config AutomationConfig) *AutomationService {
+	return &AutomationService{
+		set:      set,
+		state:    store,
+		target:   target,
+		interval: time.Duration(set.IntervalSeconds) * time.Second,
+		stale:    time.Duration(config.StaleMinutes) * time.Minute,
+		applied:  make(map[string]appliedAutomation),
+	}
+}
+
+// Start evaluates automations until context is cancelled
+func (as *AutomationService) Start(ctx context.Context) {
+	log.Printf("AutomationService: Starting with %d automations every %s", len(as.set.Automations), as.interval)
+	ticker := time.NewTicker(as.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("AutomationService: Shutting down...")
+			return
+		case now := <-ticker.C:
+			for _, deviceID := range as.state.DeviceIDs() {
+				as.evaluate(deviceID, now)
+			}
+		}
+	}
+}
+
+// facts collects the values automations are evaluated against. Metrics the device
+// hasn't reported within the stale window are left out, so conditions on them are false.
+func (as *AutomationService) facts(deviceID string, st models.DeviceState, now time.Time) rules.Facts {
+	facts := rules.Facts{
+		Values: make(map[string]float64),
+		Flags:  make(map[string]bool),
+	}
+	for _, m := range []struct {
+		name  string
+		value float64
+		at    time.Time
+	}{
+		{"temperature", st.LastTemperature, st.TemperatureAt},
+		{"humidity", st.LastHumidity, st.HumidityAt},
+		{"sound_volume", st.LastSoundVolume, st.SoundVolumeAt},
+	} {
+		if !m.at.IsZero() && now.Sub(m.at) <= as.stale {
+			facts.Values[m.name] = m.value
+		}
+	}
+	if as.Occupancy != nil {
+		if occ, ok := as.Occupancy.Get(deviceID); ok {
+			facts.Flags["occupancy"] = occ.Occupied
+		}
+	}
+	for _, key := range as.target.ActiveSafety(deviceID) {
+		facts.Flags[key] = true
+	}
+	return facts
+}
+
+// evaluate applies, renews, or releases a device's automation
+func (as *AutomationService) evaluate(deviceID string, now time.Time) {
+	st, ok := as.state.Get(deviceID)
+	fresh := ok && now.Sub(st.LastSeen) <= as.stale
+
+	var match *rules.Automation
+	if fresh {
+		match, _ = as.set.Match(as.facts(deviceID, st, now))
+	}
+
+	as.mu.Lock()
+	prev, active := as.applied[deviceID]
+	apply := match != nil && (!active || prev.name != match.Name || prev.position != match.Open || now.Sub(prev.refreshed) >= as.interval*2)
+	if match == nil {
+		delete(as.applied, deviceID)
+	} else if apply {
+		as.applied[deviceID] = appliedAutomation{name: match.Name, position: match.Open, refreshed: now}
+	}
+	as.mu.Unlock()
+
+	switch {
+	case apply:
+		if !active || prev.name != match.Name {
+			log.Printf("AutomationService: %s matched for %s, opening to %.0f%%", match.Name, deviceID, match.Open)
+		}
+		// Held for three intervals so one missed evaluation doesn't drop it
+		as.target.ApplyAutomation(deviceID, match, as.interval*3)
+	case match == nil && active:
+		as.target.ReleaseAutomation(deviceID)
+		reason := "no automation matches"
+		if !fresh {
+			reason = "no recent readings"
+		}
+		log.Printf("AutomationService: %s released for %s (%s)", prev.name, deviceID, reason)
+	}
+}
+
+// ApplyAutomation proposes an automation's position until hold passes
+func (ws *WindowControlService) ApplyAutomation(deviceID string, automation *rules.Automation, hold time.Duration) {
+	ws.apply(arbitration.Proposal{
+		DeviceID:  deviceID,
+		Source:    arbitration.SourceRules,
+		Key:       "automation",
+		Position:  automation.Open,
+		Reason:    fmt.Sprintf("automation %s (%s)", automation.Name, automation.When),
+		ExpiresAt: time.Now().Add(hold),
+	}, &models.WindowAction{Confidence: 1.0})
+}
+
+// ReleaseAutomation withdraws a device's automation proposal
+func (ws *WindowControlService) ReleaseAutomation(deviceID string) {
+	ws.arbiter.Release(deviceID, arbitration.SourceRules, "automation")
+}
+
+// ActiveSafety returns the safety conditions currently holding a device's window (e.g., "rain")
+func (ws *WindowControlService) ActiveSafety(deviceID string) []string {
+	var keys []string
+	for _, p := range ws.arbiter.Active(deviceID) {
+		if p.Source == arbitration.SourceSafety {
+			keys = append(keys, p.Key)
+		}
+	}
+	return keys
+}
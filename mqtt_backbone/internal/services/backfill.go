@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+	"iot-backend/internal/quality"
+)
+
+// backfillMaxSkew is how far ahead of the server clock a backfilled reading may be
+const backfillMaxSkew = time.Minute
+
+// backfill stores readings devices buffered while offline. They are scored by their
+// own quality scorer, since rate-of-change checks against the live stream would
+// compare them with newer readings, and spike filters and derived indicators only
+// see live readings.
+type backfill struct {
+	maxAge time.Duration // Older readings are rejected
+	scorer *quality.Scorer
+	dedup  *audioDedup // Payload hashes of recent batches; redelivered batches are stored once
+}
+
+func newBackfill(config SensorServiceConfig) *backfill {
+	return &backfill{
+		maxAge: config.BackfillMaxAge,
+		scorer: quality.NewScorer(config.Quality),
+		dedup:  newAudioDedup(config.BackfillDedupWindow),
+	}
+}
+
+// processBackfillLoop stores replayed offline buffers, one batch at a time
+func (s *SensorService) processBackfillLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case batch, ok := <-s.BackfillChan:
+			if !ok {
+				return
+			}
+			s.processBackfill(batch)
+		}
+	}
+}
+
+// processBackfill saves a batch with its original timestamps, marked as backfilled.
+// Unlike live readings it doesn't update device state, the hot store, frost
+// protection, or mold risk, and the trigger, drift and baseline queries skip it, so
+// hours of old readings neither look like a burst nor act on the window now.
+func (s *SensorService) processBackfill(batch *models.BackfillBatch) {
+	if s.backfill.dedup.duplicate(batch.DeviceID, batch.PayloadHash, batch.ReceivedAt) {
+		metrics.Default.Counter("backfill_batches_duplicate").Inc()
+		log.Printf("Dropping repeated backfill from %s", batch.DeviceID)
+		return
+	}
+
+	oldest := batch.ReceivedAt.Add(-s.backfill.maxAge)
+	newest := batch.ReceivedAt.Add(backfillMaxSkew)
+	inRange := func(t time.Time) bool {
+		return !t.Before(oldest) && !t.After(newest)
+	}
+
+	var rejected int
+	var temperature []*models.TemperatureReading
+	for _, reading := range batch.Temperature {
+		if !inRange(reading.Timestamp) {
+			rejected++
+			continue
+		}
+		temperature = append(temperature, reading)
+	}
+	var humidity []*models.HumidityReading
+	for _, reading := range batch.Humidity {
+		if !inRange(reading.Timestamp) {
+			rejected++
+			continue
+		}
+		humidity = append(humidity, reading)
+	}
+
+	// The scorer's rate checks compare consecutive readings of a device
+	sort.SliceStable(temperature, func(i, j int) bool { return temperature[i].Timestamp.Before(temperature[j].Timestamp) })
+	sort.SliceStable(humidity, func(i, j int) bool { return humidity[i].Timestamp.Before(humidity[j].Timestamp) })
+
+	var suspect int
+	keptTemperature := temperature[:0]
+	for _, reading := range temperature {
+		reading.Backfilled = true
+		s.temperatureUnits.normalize(reading)
+		result := s.backfill.scorer.ScoreTemperature(reading)
+		reading.QualityScore, reading.QualityFlag = result.Score, result.Flag
+		if result.Flag != models.QualityGood {
+			suspect++
+		}
+		if result.Flag == models.QualityBad && s.suppressOutliers {
+			continue
+		}
+		keptTemperature = append(keptTemperature, reading)
+	}
+	keptHumidity := humidity[:0]
+	for _, reading := range humidity {
+		reading.Backfilled = true
+		result := s.backfill.scorer.ScoreHumidity(reading)
+		reading.QualityScore, reading.QualityFlag = result.Score, result.Flag
+		if result.Flag != models.QualityGood {
+			suspect++
+		}
+		if result.Flag == models.QualityBad && s.suppressOutliers {
+			continue
+		}
+		keptHumidity = append(keptHumidity, reading)
+	}
+
+	if len(keptTemperature) > 0 {
+		if err := s.db.SaveTemperatureBatch(keptTemperature); err != nil {
+			log.Printf("Error saving backfilled temperature from %s: %v", batch.DeviceID, err)
+			s.backfill.dedup.forget(batch.DeviceID, batch.PayloadHash)
+			return
+		}
+	}
+	if len(keptHumidity) > 0 {
+		if err := s.db.SaveHumidityBatch(keptHumidity); err != nil {
+			// The temperature readings are stored, so a redelivery would duplicate them
+			log.Printf("Error saving backfilled humidity from %s: %v", batch.DeviceID, err)
+			return
+		}
+	}
+
+	saved := len(keptTemperature) + len(keptHumidity)
+	metrics.Default.Counter("backfill_readings_saved").Add(int64(saved))
+	if rejected > 0 {
+		metrics.Default.Counter("backfill_readings_out_of_range").Add(int64(rejected))
+	}
+	log.Printf("Saved backfill from %s: %d temperature, %d humidity readings (%d outside the last %v, %d flagged by quality checks)",
+		batch.DeviceID, len(keptTemperature), len(keptHumidity), rejected, s.backfill.maxAge, suspect)
+
+	s.registerDevice(batch.DeviceID)
+}
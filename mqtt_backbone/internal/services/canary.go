@@ -0,0 +1,320 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"iot-backend/internal/alerts"
+	"iot-backend/internal/database"
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+	"iot-backend/internal/mqtt"
+)
+
+// Synthetic readings of the canary: values vary a little from run to run so the
+// stored temperature identifies its run and no reading looks stuck
+const (
+	canaryTemperature = 21.0  // °C, plus up to one degree
+	canaryHumidity    = 45.0  // %, plus up to one point
+	canarySampleRate  = 16000 // Hz
+	canaryClipSeconds = 0.5
+)
+
+// canaryStep is how often an in-flight run checks for its next stage
+const canaryStep = time.Second
+
+// CanaryServiceConfig holds configuration for the end-to-end canary
+type CanaryServiceConfig struct {
+	Interval    time.Duration // Time between runs
+	Deadline    time.Duration // A run must go from publish to response within this
+	ChannelSize int
+}
+
+// DefaultCanaryServiceConfig returns default configuration
+func DefaultCanaryServiceConfig() CanaryServiceConfig {
+	return CanaryServiceConfig{
+		Interval:    5 * time.Minute,
+		Deadline:    2 * time.Minute,
+		ChannelSize: 10,
+	}
+}
+
+// canaryRun is a run in flight
+type canaryRun struct {
+	seq           int
+	started       time.Time
+	temperature   float64
+	stage         string // Latest stage reached
+	correlationID string // Of the inference request, once queued
+	stages        map[string]float64
+}
+
+// CanaryService checks the whole pipeline end to end: it periodically publishes
+// synthetic readings for a reserved device ID through the broker, follows them
+// into ClickHouse and through inference to the ML service's window control
+// response, and raises an alert when a run doesn't complete within the deadline.
+// The window control service never actuates the canary's responses.
+type CanaryService struct {
+	db        *database.ClickHouseDB
+	device    *mqtt.SyntheticDevice
+	inference *InferenceService
+	alerts    *alerts.Manager // May be nil
+	interval  time.Duration
+	deadline  time.Duration
+
+	// Input channel of window control responses (all devices; others are ignored)
+	ResponseChan <-chan *models.InferenceResponse
+
+	seq int
+	run *canaryRun
+
+	mu     sync.RWMutex
+	status *models.CanaryStatus // Nil until the first run finished
+}
+
+// NewCanaryService creates a canary publishing through a synthetic device
+func NewCanaryService(
+	db *database.ClickHouseDB,
+	device *mqtt.SyntheticDevice,
+	inference *InferenceService,
+	alertManager *alerts.Manager,
+	config CanaryServiceConfig,
+) *CanaryService {
+	return &CanaryService{
+		db:           db,
+		device:       device,
+		inference:    inference,
+		alerts:       alertManager,
+		interval:     config.Interval,
+		deadline:     config.Deadline,
+		ResponseChan: make(chan *models.InferenceResponse, config.ChannelSize),
+	}
+}
+
+// DeviceID returns the reserved device ID of the canary
+func (c *CanaryService) DeviceID() string {
+	return c.device.DeviceID()
+}
+
+// Status returns the outcome of the latest finished run (nil before the first)
+func (c *CanaryService) Status() *models.CanaryStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.status
+}
+
+// Start runs the canary every interval until context is cancelled
+func (c *CanaryService) Start(ctx context.Context) {
+	log.Printf("CanaryService: Starting (device=%s, interval=%v, deadline=%v)", c.DeviceID(), c.interval, c.deadline)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	step := time.NewTicker(canaryStep)
+	defer step.Stop()
+
+	c.begin()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("CanaryService: Shutting down...")
+			return
+
+		case <-ticker.C:
+			if c.run != nil {
+				// The deadline ends a run before the next one is due unless it exceeds the interval
+				log.Printf("CanaryService: Previous run still in flight, skipping this one")
+				continue
+			}
+			c.begin()
+
+		case <-step.C:
+			c.advance()
+
+		case response, ok := <-c.ResponseChan:
+			if !ok {
+				log.Println("CanaryService: Channel closed, shutting down...")
+				return
+			}
+			c.handleResponse(response)
+		}
+	}
+}
+
+// begin publishes a new run's readings
+func (c *CanaryService) begin() {
+	c.seq++
+	run := &canaryRun{
+		seq:         c.seq,
+		started:     time.Now(),
+		temperature: canaryTemperature + float64(c.seq%50)*0.02,
+		stages:      make(map[string]float64),
+	}
+	c.run = run
+	metrics.Default.Counter("canary_runs").Inc()
+
+	if err := c.device.PublishTemperature(run.temperature); err != nil {
+		c.fail(err)
+		return
+	}
+	if err := c.device.PublishHumidity(canaryHumidity + float64(c.seq%50)*0.02); err != nil {
+		c.fail(err)
+		return
+	}
+	if err := c.device.PublishAudio(canaryClip(run.seq)); err != nil {
+		c.fail(err)
+		return
+	}
+	c.reach(models.CanaryStagePublish)
+}
+
+// advance moves the run in flight to its next stage once it's reached, and fails it past the deadline
+func (c *CanaryService) advance() {
+	run := c.run
+	if run == nil {
+		return
+	}
+
+	switch run.stage {
+	case models.CanaryStagePublish:
+		// The reading's server-side timestamp is truncated to the second in ClickHouse
+		stored, err := c.db.HasTemperatureReading(c.DeviceID(), run.temperature, run.started.Add(-time.Second))
+		if err != nil {
+			log.Printf("CanaryService: Error checking persistence: %v", err)
+		} else if stored {
+			c.reach(models.CanaryStagePersist)
+		}
+
+	case models.CanaryStagePersist:
+		// Inference needs every metric of the run in the data window, which may lag the temperature
+		request, err := c.inference.TriggerManual(c.DeviceID(), true)
+		switch {
+		case errors.Is(err, ErrNoCurrentData):
+		case err != nil:
+			log.Printf("CanaryService: Error triggering inference: %v", err)
+		default:
+			run.correlationID = request.CorrelationID
+			c.reach(models.CanaryStageInference)
+		}
+	}
+
+	if c.run != nil && time.Since(run.started) > c.deadline {
+		c.fail(fmt.Errorf("not past stage %q after %v", run.stage, c.deadline))
+	}
+}
+
+// handleResponse completes the run in flight on the canary's window control response
+func (c *CanaryService) handleResponse(response *models.InferenceResponse) {
+	run := c.run
+	if response.DeviceID != c.DeviceID() || run == nil || run.stage != models.CanaryStageInference {
+		return
+	}
+	// Older ML services don't echo the correlation ID; any response after the request then counts
+	if response.CorrelationID != "" && response.CorrelationID != run.correlationID {
+		return
+	}
+	c.reach(models.CanaryStageResponse)
+	c.finish(nil)
+}
+
+// reach records that the run in flight reached a stage
+func (c *CanaryService) reach(stage string) {
+	elapsed := time.Since(c.run.started)
+	c.run.stage = stage
+	c.run.stages[stage] = elapsed.Seconds()
+	metrics.Default.Timer("canary_stage_" + stage).Observe(elapsed)
+}
+
+// fail ends the run in flight unsuccessfully and raises an alert
+func (c *CanaryService) fail(err error) {
+	run := c.run
+	next := models.CanaryStagePublish
+	switch run.stage {
+	case models.CanaryStagePublish:
+		next = models.CanaryStagePersist
+	case models.CanaryStagePersist:
+		next = models.CanaryStageInference
+	case models.CanaryStageInference:
+		next = models.CanaryStageResponse
+	}
+	log.Printf("CanaryService: Run %d failed at stage %s: %v", run.seq, next, err)
+	metrics.Default.Counter("canary_failures").Inc()
+	metrics.Default.Counter("canary_failures_" + next).Inc()
+
+	if c.alerts != nil {
+		c.alerts.Raise(&models.Alert{
+			DeviceID: c.DeviceID(),
+			Type:     models.AlertCanaryFailed,
+			Severity: models.SeverityCritical,
+			Message:  fmt.Sprintf("End-to-end canary did not reach stage %s: %v", next, err),
+			Value:    time.Since(run.started).Seconds(),
+		})
+	}
+	c.finish(&canaryFailure{stage: next, err: err})
+}
+
+// canaryFailure is why a run failed
+type canaryFailure struct {
+	stage string
+	err   error
+}
+
+// finish records the outcome of the run in flight and clears it
+func (c *CanaryService) finish(failure *canaryFailure) {
+	run := c.run
+	c.run = nil
+	latency := time.Since(run.started)
+
+	status := &models.CanaryStatus{
+		DeviceID:       c.DeviceID(),
+		OK:             failure == nil,
+		LastRun:        run.started,
+		LatencySeconds: latency.Seconds(),
+		StageSeconds:   run.stages,
+	}
+	c.mu.Lock()
+	if c.status != nil {
+		status.LastSuccess = c.status.LastSuccess
+	}
+	if failure == nil {
+		status.LastSuccess = run.started
+		if c.status != nil && !c.status.OK {
+			log.Printf("CanaryService: Recovered, run %d completed in %v", run.seq, latency.Round(time.Millisecond))
+		}
+	} else {
+		status.FailedStage = failure.stage
+		status.Error = failure.err.Error()
+	}
+	c.status = status
+	c.mu.Unlock()
+
+	if failure == nil {
+		metrics.Default.Timer("canary_latency").Observe(latency)
+		metrics.Default.Gauge("canary_ok").Set(1)
+	} else {
+		metrics.Default.Gauge("canary_ok").Set(0)
+	}
+}
+
+// canaryClip is a short mono 16-bit tone; its pitch changes with the run so
+// consecutive clips aren't suppressed as retransmissions
+func canaryClip(seq int) *models.AudioPayload {
+	samples := int(canarySampleRate * canaryClipSeconds)
+	frequency := 440.0 + float64(seq%50)*10
+	data := make([]byte, 2*samples)
+	for i := 0; i < samples; i++ {
+		v := int16(0.1 * math.MaxInt16 * math.Sin(2*math.Pi*frequency*float64(i)/canarySampleRate))
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(v))
+	}
+	return &models.AudioPayload{
+		Data:       data,
+		SampleRate: canarySampleRate,
+		Duration:   canaryClipSeconds,
+		Channels:   1,
+	}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"iot-backend/internal/database"
+	"iot-backend/internal/models"
+)
+
+// ConfigSyncService answers device boot announcements with the device's stored
+// configuration from the registry (sampling interval, thresholds, calibration)
+type ConfigSyncService struct {
+	db        *database.ClickHouseDB
+	publisher ConfigPublisher
+
+	// Input channel of boot announcements
+	BootChan <-chan *models.DeviceBoot
+
+	// Used for devices without a stored sampling interval
+	defaultSamplingInterval int
+}
+
+// ConfigPublisher interface for sending configuration to devices
+type ConfigPublisher interface {
+	PublishDeviceConfig(cfg *models.DeviceConfig) error
+}
+
+// ConfigSyncServiceConfig holds configuration for the config sync service
+type ConfigSyncServiceConfig struct {
+	ChannelSize                    int
+	DefaultSamplingIntervalSeconds int
+}
+
+// DefaultConfigSyncServiceConfig returns default configuration
+func DefaultConfigSyncServiceConfig() ConfigSyncServiceConfig {
+	return ConfigSyncServiceConfig{
+		ChannelSize:                    20,
+		DefaultSamplingIntervalSeconds: 60,
+	}
+}
+
+// NewConfigSyncService creates a new config sync service
+func NewConfigSyncService(db *database.ClickHouseDB, publisher ConfigPublisher, config ConfigSyncServiceConfig) *ConfigSyncService {
+	return &ConfigSyncService{
+		db:                      db,
+		publisher:               publisher,
+		BootChan:                make(chan *models.DeviceBoot, config.ChannelSize),
+		defaultSamplingInterval: config.DefaultSamplingIntervalSeconds,
+	}
+}
+
+// Start answers boot announcements until context is cancelled
+func (cs *ConfigSyncService) Start(ctx context.Context) {
+	log.Println("ConfigSyncService: Starting...")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("ConfigSyncService: Shutdown complete")
+			return
+		case boot, ok := <-cs.BootChan:
+			if !ok {
+				log.Println("ConfigSyncService: Channel closed, shutting down...")
+				return
+			}
+			cs.handleBoot(boot)
+		}
+	}
+}
+
+// handleBoot looks up the booting device and publishes its configuration
+func (cs *ConfigSyncService) handleBoot(boot *models.DeviceBoot) {
+	device, err := cs.db.GetDevice(boot.DeviceID)
+	if err != nil {
+		// Still answer: a device waiting for config shouldn't hang on a registry outage
+		log.Printf("ConfigSyncService: Error reading registry for %s, sending defaults: %v", boot.DeviceID, err)
+	}
+
+	var stored map[string]interface{}
+	if device != nil {
+		stored = device.Config
+	}
+	cfg := cs.BuildConfig(boot.DeviceID, stored)
+
+	if err := cs.publisher.PublishDeviceConfig(cfg); err != nil {
+		log.Printf("ConfigSyncService: Error sending config to %s: %v", boot.DeviceID, err)
+		return
+	}
+
+	log.Printf("ConfigSyncService: Sent config to %s (sampling=%ds, %d thresholds, %d calibration values)",
+		boot.DeviceID, cfg.SamplingIntervalSeconds, len(cfg.Thresholds), len(cfg.Calibration))
+}
+
+// BuildConfig maps a registry config object to the device config payload.
+// Recognized keys are "sampling_interval_seconds", "thresholds", and "calibration";
+// anything else is backend-only and not sent to the device.
+func (cs *ConfigSyncService) BuildConfig(deviceID string, stored map[string]interface{}) *models.DeviceConfig {
+	cfg := &models.DeviceConfig{
+		DeviceID:                deviceID,
+		SamplingIntervalSeconds: cs.defaultSamplingInterval,
+		IssuedAt:                time.Now(),
+	}
+
+	if v, ok := stored["sampling_interval_seconds"].(float64); ok && v > 0 {
+		cfg.SamplingIntervalSeconds = int(v)
+	}
+	cfg.Thresholds = numberMap(stored["thresholds"])
+	cfg.Calibration = numberMap(stored["calibration"])
+
+	return cfg
+}
+
+// numberMap extracts the numeric entries of a decoded JSON object
+func numberMap(v interface{}) map[string]float64 {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	values := make(map[string]float64, len(obj))
+	for key, raw := range obj {
+		if n, ok := raw.(float64); ok {
+			values[key] = n
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Frost protection levels, ordered by severity
+type frostLevel int
+
+const (
+	frostClear  frostLevel = iota // No restriction
+	frostCapped                   // Window limited to the frost maximum opening
+	frostClosed                   // Window forced closed
+)
+
+func (l frostLevel) String() string {
+	switch l {
+	case frostCapped:
+		return "capped"
+	case frostClosed:
+		return "closed"
+	}
+	return "clear"
+}
+
+// FrostConfig holds frost protection settings. Devices can override any of them
+// with a "frost" object in their registry config, e.g.
+// {"frost": {"cap_temperature": 5, "max_position": 10, "enabled": true}}.
+type FrostConfig struct {
+	Enabled          bool
+	CapTemperature   float64 // At or below this (°C) the window opens at most MaxPosition
+	CloseTemperature float64 // At or below this (°C) the window is forced closed
+	MaxPosition      float64 // Maximum opening (%) while capped
+	Hysteresis       float64 // Degrees above a threshold before its restriction is lifted
+	HoldMinutes      int     // A restriction expires this long after the last cold reading
+}
+
+// DefaultFrostConfig returns default configuration
+func DefaultFrostConfig() FrostConfig {
+	return FrostConfig{
+		Enabled:          true,
+		CapTemperature:   3,
+		CloseTemperature: 0,
+		MaxPosition:      20,
+		Hysteresis:       1,
+		HoldMinutes:      60,
+	}
+}
+
+// withOverrides applies a device's registry "frost" object to the defaults
+func (c FrostConfig) withOverrides(stored map[string]interface{}) FrostConfig {
+	obj, ok := stored["frost"].(map[string]interface{})
+	if !ok {
+		return c
+	}
+	if v, ok := obj["enabled"].(bool); ok {
+		c.Enabled = v
+	}
+	values := numberMap(obj)
+	if v, ok := values["cap_temperature"]; ok {
+		c.CapTemperature = v
+	}
+	if v, ok := values["close_temperature"]; ok {
+		c.CloseTemperature = v
+	}
+	if v, ok := values["max_position"]; ok {
+		c.MaxPosition = math.Max(0, math.Min(100, v))
+	}
+	if v, ok := values["hysteresis"]; ok && v >= 0 {
+		c.Hysteresis = v
+	}
+	return c
+}
+
+// frostSettingsTTL is how long a device's registry overrides are cached
+const frostSettingsTTL = 5 * time.Minute
+
+// frostDevice is the frost state of one device
+type frostDevice struct {
+	level       frostLevel
+	indoor      float64 // Latest indoor temperature
+	indoorAt    time.Time
+	outdoor     float64 // Latest outdoor temperature (from "outdoor_temperature" safety events)
+	outdoorAt   time.Time
+	settings    FrostConfig
+	loadedAt    time.Time // When settings were read from the registry
+	submittedAt time.Time // When the current restriction was last submitted for arbitration
+}
+
+// frostGuard tracks the frost level of every device. Each level has its own
+// hysteresis band, so a temperature hovering around a threshold doesn't make the
+// window flap. Safe for concurrent use.
+type frostGuard struct {
+	defaults FrostConfig
+	lookup   func(deviceID string) map[string]interface{} // Registry config of a device (nil if unknown)
+
+	mu      sync.Mutex
+	devices map[string]*frostDevice
+}
+
+// frostTransition is the outcome of one temperature observation
+type frostTransition struct {
+	From, To    frostLevel
+	Temperature float64     // Coldest recent temperature the level was decided on
+	Settings    FrostConfig // Device settings in effect
+	Refresh     bool        // The level is unchanged but its restriction is due for resubmission
+}
+
+func newFrostGuard(defaults FrostConfig, lookup func(deviceID string) map[string]interface{}) *frostGuard {
+	return &frostGuard{
+		defaults: defaults,
+		lookup:   lookup,
+		devices:  make(map[string]*frostDevice),
+	}
+}
+
+// observe records a temperature and returns the resulting level change, if any
+func (g *frostGuard) observe(deviceID string, value float64, at time.Time, outdoor bool) frostTransition {
+	// Registry reads happen outside the lock; a slow registry must not stall other devices
+	g.mu.Lock()
+	dev, ok := g.devices[deviceID]
+	stale := !ok || at.Sub(dev.loadedAt) > frostSettingsTTL
+	g.mu.Unlock()
+
+	var settings FrostConfig
+	if stale {
+		settings = g.defaults.withOverrides(g.lookup(deviceID))
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if dev = g.devices[deviceID]; dev == nil {
+		dev = &frostDevice{}
+		g.devices[deviceID] = dev
+	}
+	if stale {
+		dev.settings, dev.loadedAt = settings, at
+	}
+	if outdoor {
+		dev.outdoor, dev.outdoorAt = value, at
+	} else {
+		dev.indoor, dev.indoorAt = value, at
+	}
+
+	hold := time.Duration(dev.settings.HoldMinutes) * time.Minute
+	temperature := dev.coldest(at, hold)
+	next := dev.settings.next(dev.level, temperature)
+
+	t := frostTransition{From: dev.level, To: next, Temperature: temperature, Settings: dev.settings}
+	if next != dev.level {
+		dev.level = next
+		dev.submittedAt = at
+	} else if next != frostClear && at.Sub(dev.submittedAt) >= hold/2 {
+		// Resubmit before the restriction expires
+		t.Refresh = true
+		dev.submittedAt = at
+	}
+	return t
+}
+
+// coldest returns the lowest of the indoor and outdoor temperatures reported within hold
+func (d *frostDevice) coldest(now time.Time, hold time.Duration) float64 {
+	temperature := math.Inf(1)
+	if !d.indoorAt.IsZero() && now.Sub(d.indoorAt) <= hold {
+		temperature = d.indoor
+	}
+	if !d.outdoorAt.IsZero() && now.Sub(d.outdoorAt) <= hold {
+		temperature = math.Min(temperature, d.outdoor)
+	}
+	return temperature
+}
+
+// next returns the frost level for a temperature given the current level. A level
+// is entered at its threshold and only left at threshold + hysteresis.
+func (c FrostConfig) next(current frostLevel, temperature float64) frostLevel {
+	if !c.Enabled {
+		return frostClear
+	}
+
+	closeAt, capAt := c.CloseTemperature, c.CapTemperature
+	if current >= frostClosed {
+		closeAt += c.Hysteresis
+	}
+	if current >= frostCapped {
+		capAt += c.Hysteresis
+	}
+
+	switch {
+	case temperature <= closeAt:
+		return frostClosed
+	case temperature <= capAt:
+		return frostCapped
+	}
+	return frostClear
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"sort"
+	"time"
+
+	"iot-backend/internal/models"
+	"iot-backend/internal/stats"
+)
+
+// heatmapDays labels the heatmap rows, ISO order
+var heatmapDays = []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+
+// BuildHeatmap groups a device's hourly means by day of week and hour of day in loc,
+// summarizing each cell by its mean, percentiles, and maximum
+func BuildHeatmap(deviceID, metric string, from, to time.Time, loc *time.Location, hours []models.SeriesPoint) *models.Heatmap {
+	var values [7][24][]float64
+	for _, h := range hours {
+		local := h.Timestamp.In(loc)
+		day := (int(local.Weekday()) + 6) % 7 // Monday = 0
+		values[day][local.Hour()] = append(values[day][local.Hour()], h.Value)
+	}
+
+	heatmap := &models.Heatmap{
+		DeviceID: deviceID,
+		Metric:   metric,
+		From:     from,
+		To:       to,
+		TimeZone: loc.String(),
+		Days:     heatmapDays,
+		Cells:    make([][]*models.HeatmapCell, 7),
+	}
+	for day := range values {
+		heatmap.Cells[day] = make([]*models.HeatmapCell, 24)
+		for hour, cell := range values[day] {
+			if len(cell) == 0 {
+				continue
+			}
+			sort.Float64s(cell)
+			var sum float64
+			for _, v := range cell {
+				sum += v
+			}
+			heatmap.Cells[day][hour] = &models.HeatmapCell{
+				Mean:  sum / float64(len(cell)),
+				P10:   stats.Percentile(cell, 10),
+				P50:   stats.Percentile(cell, 50),
+				P90:   stats.Percentile(cell, 90),
+				Max:   cell[len(cell)-1],
+				Hours: len(cell),
+			}
+		}
+	}
+	return heatmap
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"time"
+
+	"iot-backend/internal/database"
+	"iot-backend/internal/metrics"
+)
+
+// aggregateMetrics are the readings averaged into inference window aggregates, named as in the hot store
+var aggregateMetrics = []string{"temperature", "humidity", "sound_volume"}
+
+// currentAggregates returns a device's mean readings over the data window up to now
+func (is *InferenceService) currentAggregates(deviceID string) (*database.SensorAggregates, error) {
+	now := time.Now()
+	if agg, ok := is.hotAggregates(deviceID, now.Add(-is.dataWindow), now); ok {
+		return agg, nil
+	}
+	return is.db.GetCurrentWindowAggregates(deviceID, int(is.dataWindow.Seconds()))
+}
+
+// lastInferenceAggregates returns a device's mean readings over the data window before its last inference
+func (is *InferenceService) lastInferenceAggregates(deviceID string, lastInferenceTime time.Time) (*database.SensorAggregates, error) {
+	if agg, ok := is.hotAggregates(deviceID, lastInferenceTime.Add(-is.dataWindow), lastInferenceTime); ok {
+		return agg, nil
+	}
+	return is.db.GetLastInferenceWindowAggregates(deviceID, lastInferenceTime, int(is.dataWindow.Seconds()))
+}
+
+// hotAggregates averages readings within [from, to] from the hot store. It returns
+// false when the store doesn't hold the whole range, so ClickHouse must answer.
+func (is *InferenceService) hotAggregates(deviceID string, from, to time.Time) (*database.SensorAggregates, bool) {
+	if is.Hot == nil {
+		return nil, false
+	}
+	for _, metric := range aggregateMetrics {
+		if !is.Hot.Covers(deviceID, metric, from) {
+			metrics.Default.Counter("hotstore_misses").Inc()
+			return nil, false
+		}
+	}
+	metrics.Default.Counter("hotstore_hits").Inc()
+
+	temperature, temperatureCount := is.Hot.Mean(deviceID, "temperature", from, to)
+	humidity, humidityCount := is.Hot.Mean(deviceID, "humidity", from, to)
+	volume, volumeCount := is.Hot.Mean(deviceID, "sound_volume", from, to)
+
+	// Like the database query, aggregates need readings of every metric
+	if temperatureCount == 0 || humidityCount == 0 || volumeCount == 0 {
+		return &database.SensorAggregates{HasData: false}, true
+	}
+	return &database.SensorAggregates{
+		Temperature: temperature,
+		Humidity:    humidity,
+		SoundVolume: volume,
+		HasData:     true,
+	}, true
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+// InferenceCacheConfig holds configuration for the inference cache. Features are
+// in different units, so each sensor feature has its own epsilon, which its lag
+// features ("<feature>_lag_<offset>") share.
+type InferenceCacheConfig struct {
+	FeatureEpsilons map[string]float64 // Largest difference of a feature, in its unit, that counts as unchanged
+	Epsilon         float64            // Same for features without their own (the 0-1 indices)
+	MaxAge          time.Duration      // Responses older than this aren't reused, so the model is asked now and then
+	ChannelSize     int                // Size of the response channel
+}
+
+// DefaultInferenceCacheConfig returns default configuration
+func DefaultInferenceCacheConfig() InferenceCacheConfig {
+	return InferenceCacheConfig{
+		FeatureEpsilons: map[string]float64{
+			"temperature":  0.05, // °C
+			"humidity":     0.5,  // %RH
+			"sound_volume": 1.0,  // dB
+		},
+		Epsilon:     0.02,
+		MaxAge:      time.Hour,
+		ChannelSize: 50,
+	}
+}
+
+// cachedInference is the latest answered request of a device and domain
+type cachedInference struct {
+	features   map[string]float64
+	variant    string
+	response   models.InferenceResponse
+	answeredAt time.Time
+}
+
+// InferenceCache remembers the last answered request of every device and domain,
+// so a request whose features haven't moved reuses that answer instead of costing
+// an ML round trip. Sensors reporting flat values would otherwise be asked about
+// the same inputs over and over.
+type InferenceCache struct {
+	epsilons map[string]float64
+	epsilon  float64
+	maxAge   time.Duration
+
+	// Input channel of ML responses (all devices), matched to the requests sent
+	ResponseChan <-chan *models.InferenceResponse
+
+	mu      sync.Mutex
+	sent    map[string]*models.InferenceRequest // Device/domain → latest request awaiting its response
+	entries map[string]*cachedInference
+}
+
+// NewInferenceCache creates an inference cache
+func NewInferenceCache(config InferenceCacheConfig) *InferenceCache {
+	return &InferenceCache{
+		epsilons:     config.FeatureEpsilons,
+		epsilon:      config.Epsilon,
+		maxAge:       config.MaxAge,
+		ResponseChan: make(chan *models.InferenceResponse, config.ChannelSize),
+		sent:         make(map[string]*models.InferenceRequest),
+		entries:      make(map[string]*cachedInference),
+	}
+}
+
+// Start records responses until context is cancelled
+func (c *InferenceCache) Start(ctx context.Context) {
+	log.Printf("InferenceCache: Reusing responses for unchanged features (epsilons=%v, others=%.3f, max age=%v)", c.epsilons, c.epsilon, c.maxAge)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case response, ok := <-c.ResponseChan:
+			if !ok {
+				return
+			}
+			c.observe(response)
+		}
+	}
+}
+
+// epsilonFor returns the largest change of a feature that counts as unchanged
+func (c *InferenceCache) epsilonFor(feature string) float64 {
+	if epsilon, ok := c.epsilons[feature]; ok {
+		return epsilon
+	}
+	if metric, _, ok := strings.Cut(feature, "_lag_"); ok {
+		if epsilon, ok := c.epsilons[metric]; ok {
+			return epsilon
+		}
+	}
+	return c.epsilon
+}
+
+// cacheKey identifies a device's inferences in a domain
+func cacheKey(deviceID, domain string) string {
+	return deviceID + "/" + domain
+}
+
+// Sent records a request published to the ML service; its response becomes the
+// cached answer for the request's features
+func (c *InferenceCache) Sent(request *models.InferenceRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent[cacheKey(request.DeviceID, request.Domain)] = request
+}
+
+// observe caches a response to the latest request sent for its device and domain.
+// Responses to older requests, and reused ones, are ignored.
+func (c *InferenceCache) observe(response *models.InferenceResponse) {
+	if response.Cached {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey(response.DeviceID, response.Domain)
+	request, ok := c.sent[key]
+	// Older ML services don't echo the correlation ID; their response answers the latest request
+	if !ok || response.CorrelationID != "" && response.CorrelationID != request.CorrelationID {
+		return
+	}
+	delete(c.sent, key)
+	c.entries[key] = &cachedInference{
+		features:   request.Features(),
+		variant:    request.ModelVariant,
+		response:   *response,
+		answeredAt: time.Now(),
+	}
+}
+
+// Lookup returns the cached response for a request whose features all lie within
+// their epsilon of the cached request's, for the same model variant, answered within
+// the maximum age. The response is a copy stamped as the request's answer.
+func (c *InferenceCache) Lookup(request *models.InferenceRequest) (*models.InferenceResponse, bool) {
+	c.mu.Lock()
+	entry := c.entries[cacheKey(request.DeviceID, request.Domain)]
+	c.mu.Unlock()
+
+	if entry == nil || time.Since(entry.answeredAt) > c.maxAge || entry.variant != request.ModelVariant {
+		return nil, false
+	}
+	features := request.Features()
+	if len(features) != len(entry.features) {
+		return nil, false // A lag feature appeared or went missing
+	}
+	for name, value := range features {
+		cached, ok := entry.features[name]
+		if !ok || math.Abs(value-cached) > c.epsilonFor(name) {
+			return nil, false
+		}
+	}
+
+	response := entry.response
+	response.CorrelationID = request.CorrelationID
+	response.Timestamp = request.Timestamp
+	response.Cached = true
+	return &response, true
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"iot-backend/internal/mqtt"
+)
+
+// InferenceDomain is an independent slice of window control (e.g., thermal comfort,
+// noise) with its own triggers and model. Each domain is checked and inferred on its
+// own; the arbitration module merges the positions its model proposes with the
+// other domains'.
+type InferenceDomain struct {
+	Name    string
+	Metrics []string // Aggregate metrics whose changes trigger the domain (temperature, humidity, sound_volume)
+}
+
+// defaultDomain covers every metric with the single window model
+var defaultDomain = InferenceDomain{Metrics: aggregateMetrics}
+
+// watches reports whether changes of a metric trigger the domain
+func (d InferenceDomain) watches(metric string) bool {
+	for _, m := range d.Metrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// InferenceDomains derives the domains from their model routes: a domain is
+// triggered by the aggregate metrics among its model's features (all of them
+// without a feature list), so it needs at least one of them
+func InferenceDomains(routes []mqtt.MLModel) ([]InferenceDomain, error) {
+	var domains []InferenceDomain
+	for _, route := range routes {
+		domain := InferenceDomain{Name: route.Name}
+		if len(route.Features) == 0 {
+			domain.Metrics = aggregateMetrics
+		}
+		for _, feature := range route.Features {
+			if defaultDomain.watches(feature) {
+				domain.Metrics = append(domain.Metrics, feature)
+			}
+		}
+		if len(domain.Metrics) == 0 {
+			return nil, fmt.Errorf("inference domain %s needs at least one of %s among its features", route.Name, strings.Join(aggregateMetrics, ", "))
+		}
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
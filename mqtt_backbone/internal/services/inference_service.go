@@ -2,73 +2,205 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"math"
+	"math/rand"
+	"strings"
 	"sync"
 	"time"
 
 	"iot-backend/internal/database"
+	"iot-backend/internal/derived"
+	"iot-backend/internal/hotstore"
+	"iot-backend/internal/metrics"
 	"iot-backend/internal/models"
+	"iot-backend/internal/mqtt"
+	"iot-backend/internal/predict"
+	"iot-backend/internal/state"
 )
 
 // InferenceService manages ML inference triggering using CQRS pattern
 // Instead of event-driven triggering, it polls ClickHouse periodically
-// and uses statistical analysis (Z-scores) to determine when to trigger inference
+// and uses statistical analysis (Z-scores against hour-of-day baselines, and regression
+// drift) to determine when to trigger inference
 type InferenceService struct {
-	db *database.ClickHouseDB
+	db    *database.ClickHouseDB
+	state *state.Store
 
 	// Configuration
 	pollingInterval time.Duration
+	pollingMode     string // PollingBurst or PollingStaggered
 	dataWindow      time.Duration
 	baselineDays    int
 	zScoreThreshold float64
 
-	// Output channel for inference requests
-	InferenceReqChan chan *models.InferenceRequest
+	// Per-hour-of-day baselines (cached per device)
+	hourlyBaseline bool
+	minHourSamples uint64
+	profileTTL     time.Duration
+
+	// Sustained drift detection
+	driftWindow     time.Duration
+	driftThreshold  float64
+	driftMinSamples uint64
+
+	// Cold-start suppression
+	minBaselineSamples  uint64
+	coldStartMaxPerPoll int
+	coldStartJitter     time.Duration
+
+	// Manual triggers
+	manualCooldown time.Duration
+
+	// Independently triggered domains (defaultDomain alone without inference domains)
+	domains []InferenceDomain
+
+	// Output channel for inference requests (owned by the event bus, never closed here)
+	InferenceReqChan chan<- *models.InferenceRequest
+
+	// Optional source of the derived mold risk feature; set before Start
+	MoldRisk *derived.MoldRiskTracker
+
+	// Optional source of the occupancy feature; set before Start
+	Occupancy *derived.OccupancyEstimator
+
+	// Optional in-memory recent readings; window aggregates they cover skip ClickHouse; set before Start
+	Hot *hotstore.Store
+
+	// Optional local models, the active one's variant and feature scaling sent with every request; set before Start
+	Models *predict.ModelSet
+
+	// Optional source of outdoor temperatures selecting model variants; set before Start
+	Outdoor *derived.OutdoorTemperatures
+
+	// Adds the lag features of the lag_features table to requests; set before Start
+	LagFeatures bool
+
+	// Optional cache answering requests with unchanged features without the ML service; set before Start
+	Cache *InferenceCache
+
+	// Output channel for cached responses (the event bus input of ML responses); required with Cache
+	CachedResponseChan chan<- *models.InferenceResponse
 
 	// Internal state
-	mu             sync.RWMutex
-	trackedDevices map[string]bool // Devices we've seen
+	mu               sync.RWMutex
+	trackedDevices   map[string]bool          // Devices we've seen
+	pendingColdStart map[string]bool          // Device/domain pairs with a jittered cold-start trigger scheduled
+	profiles         map[string]cachedProfile // Hourly baseline profiles, refreshed every profileTTL
+	coldStartWG      sync.WaitGroup           // Outstanding cold-start goroutines (drained before shutdown completes)
 }
 
 // InferenceServiceConfig holds configuration for inference service
 type InferenceServiceConfig struct {
 	PollingIntervalSeconds int     // How often to check for changes
+	PollingMode            string  // PollingStaggered spreads checks across the interval; PollingBurst checks all at once
 	DataWindowSeconds      int     // Time window for querying current data
 	HistoricalBaselineDays int     // Days of historical data for std dev
 	ZScoreThreshold        float64 // Threshold for triggering
 	ChannelSize            int     // Size of inference request channel
+
+	// Per-hour-of-day baselines
+	HourlyBaseline        bool // Judge changes against the device's daily cycle instead of a flat spread
+	MinHourSamples        int  // Readings an hour of the day needs before its baseline is used (else the flat one is)
+	ProfileRefreshMinutes int  // How long a device's hourly profile is cached
+
+	// Sustained drift detection
+	DriftWindowSeconds int     // Window the regression slope is fitted over (0 disables drift triggers)
+	DriftThreshold     float64 // Drift since the last inference, in baseline std devs, that triggers
+	DriftMinSamples    int     // Minimum readings in the window before a metric's slope is trusted
+
+	// Cold-start suppression
+	MinBaselineSamples     int // Minimum baseline samples before any trigger fires
+	ColdStartMaxPerPoll    int // Maximum cold-start triggers scheduled per poll cycle
+	ColdStartJitterSeconds int // Cold-start triggers are spread randomly over this window
+
+	// Manual triggers
+	ManualCooldownSeconds int // Manual triggers this soon after the last inference are refused unless forced
+
+	// Optional inference domains, each triggered by its own metrics (empty = one request per device)
+	Domains []InferenceDomain
 }
 
 // DefaultInferenceServiceConfig returns default configuration
 func DefaultInferenceServiceConfig() InferenceServiceConfig {
 	return InferenceServiceConfig{
 		PollingIntervalSeconds: 60,
+		PollingMode:            PollingStaggered,
 		DataWindowSeconds:      120,
 		HistoricalBaselineDays: 7,
 		ZScoreThreshold:        1.5,
 		ChannelSize:            50,
+		HourlyBaseline:         true,
+		MinHourSamples:         10,
+		ProfileRefreshMinutes:  60,
+		DriftWindowSeconds:     3600,
+		DriftThreshold:         2.0,
+		DriftMinSamples:        10,
+		MinBaselineSamples:     30,
+		ColdStartMaxPerPoll:    5,
+		ColdStartJitterSeconds: 10,
+		ManualCooldownSeconds:  60,
 	}
 }
 
 // NewInferenceService creates a new CQRS-based inference service
-func NewInferenceService(db *database.ClickHouseDB, config InferenceServiceConfig) *InferenceService {
-	return &InferenceService{
+// Devices with restored state are tracked immediately instead of waiting for their next reading.
+func NewInferenceService(db *database.ClickHouseDB, store *state.Store, config InferenceServiceConfig) *InferenceService {
+	is := &InferenceService{
 		db:               db,
+		state:            store,
 		pollingInterval:  time.Duration(config.PollingIntervalSeconds) * time.Second,
+		pollingMode:      config.PollingMode,
 		dataWindow:       time.Duration(config.DataWindowSeconds) * time.Second,
 		baselineDays:     config.HistoricalBaselineDays,
 		zScoreThreshold:  config.ZScoreThreshold,
 		InferenceReqChan: make(chan *models.InferenceRequest, config.ChannelSize),
 		trackedDevices:   make(map[string]bool),
+
+		hourlyBaseline: config.HourlyBaseline,
+		minHourSamples: uint64(config.MinHourSamples),
+		profileTTL:     time.Duration(config.ProfileRefreshMinutes) * time.Minute,
+		profiles:       make(map[string]cachedProfile),
+
+		driftWindow:     time.Duration(config.DriftWindowSeconds) * time.Second,
+		driftThreshold:  config.DriftThreshold,
+		driftMinSamples: uint64(config.DriftMinSamples),
+
+		minBaselineSamples:  uint64(config.MinBaselineSamples),
+		coldStartMaxPerPoll: config.ColdStartMaxPerPoll,
+		coldStartJitter:     time.Duration(config.ColdStartJitterSeconds) * time.Second,
+		pendingColdStart:    make(map[string]bool),
+		manualCooldown:      time.Duration(config.ManualCooldownSeconds) * time.Second,
+		domains:             config.Domains,
+	}
+	if len(is.domains) == 0 {
+		is.domains = []InferenceDomain{defaultDomain}
+	}
+
+	for _, deviceID := range store.DeviceIDs() {
+		is.trackedDevices[deviceID] = true
 	}
+
+	return is
 }
 
 // Start begins the polling loop
 func (is *InferenceService) Start(ctx context.Context) {
 	log.Println("InferenceService: Starting CQRS polling loop...")
-	log.Printf("InferenceService: Polling every %v, data window=%v, baseline=%d days, Z-threshold=%.2f",
-		is.pollingInterval, is.dataWindow, is.baselineDays, is.zScoreThreshold)
+	log.Printf("InferenceService: Polling every %v (%s), data window=%v, baseline=%d days (hourly=%v), Z-threshold=%.2f",
+		is.pollingInterval, is.pollingMode, is.dataWindow, is.baselineDays, is.hourlyBaseline, is.zScoreThreshold)
+	for _, domain := range is.domains {
+		if domain.Name != "" {
+			log.Printf("InferenceService: Domain %s triggered by %s", domain.Name, strings.Join(domain.Metrics, ", "))
+		}
+	}
+
+	if is.pollingMode == PollingStaggered {
+		is.runStaggered(ctx)
+		return
+	}
 
 	ticker := time.NewTicker(is.pollingInterval)
 	defer ticker.Stop()
@@ -80,7 +212,7 @@ func (is *InferenceService) Start(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			log.Println("InferenceService: Shutting down...")
-			close(is.InferenceReqChan)
+			is.coldStartWG.Wait()
 			log.Println("InferenceService: Shutdown complete")
 			return
 		case <-ticker.C:
@@ -89,14 +221,55 @@ func (is *InferenceService) Start(ctx context.Context) {
 	}
 }
 
-// pollAllDevices checks all known devices for inference triggers
-func (is *InferenceService) pollAllDevices(ctx context.Context) {
+// runStaggered checks each device once per interval at its own phase offset until
+// context is cancelled, so ClickHouse sees a steady trickle of queries instead of
+// one spike per interval
+func (is *InferenceService) runStaggered(ctx context.Context) {
+	schedule := &pollSchedule{interval: is.pollingInterval}
+	schedule.advance(time.Now())
+
+	ticker := time.NewTicker(staggerStep(is.pollingInterval))
+	defer ticker.Stop()
+
+	coldStarts := 0
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("InferenceService: Shutting down...")
+			is.coldStartWG.Wait()
+			log.Println("InferenceService: Shutdown complete")
+			return
+		case now := <-ticker.C:
+			from, to, wrapped := schedule.advance(now)
+			if wrapped {
+				coldStarts = 0 // The cold-start budget is per cycle, as in burst mode
+			}
+			for _, deviceID := range is.devices() {
+				if ctx.Err() != nil {
+					break
+				}
+				if due(pollPhase(deviceID, is.pollingInterval), from, to) {
+					is.checkDevice(ctx, deviceID, &coldStarts)
+				}
+			}
+		}
+	}
+}
+
+// devices returns the tracked device IDs
+func (is *InferenceService) devices() []string {
 	is.mu.RLock()
+	defer is.mu.RUnlock()
 	devices := make([]string, 0, len(is.trackedDevices))
 	for deviceID := range is.trackedDevices {
 		devices = append(devices, deviceID)
 	}
-	is.mu.RUnlock()
+	return devices
+}
+
+// pollAllDevices checks all known devices for inference triggers
+func (is *InferenceService) pollAllDevices(ctx context.Context) {
+	devices := is.devices()
 
 	if len(devices) == 0 {
 		// Try to discover devices from device registry
@@ -106,25 +279,20 @@ func (is *InferenceService) pollAllDevices(ctx context.Context) {
 
 	log.Printf("InferenceService: Polling %d devices", len(devices))
 
+	coldStarts := 0
 	for _, deviceID := range devices {
 		if ctx.Err() != nil {
 			return // Context cancelled
 		}
-		is.checkDevice(deviceID)
+		is.checkDevice(ctx, deviceID, &coldStarts)
 	}
 }
 
-// checkDevice checks a single device and triggers inference if needed
-func (is *InferenceService) checkDevice(deviceID string) {
-	// Get last inference timestamp
-	lastInferenceTime, err := is.db.GetLastInferenceTimestamp(deviceID)
-	if err != nil {
-		log.Printf("InferenceService: Error getting last inference time for %s: %v", deviceID, err)
-		return
-	}
-
+// checkDevice checks a single device and triggers inference for each domain that needs it.
+// coldStarts counts cold-start triggers already scheduled during this poll cycle.
+func (is *InferenceService) checkDevice(ctx context.Context, deviceID string, coldStarts *int) {
 	// Get current window aggregates
-	currentAgg, err := is.db.GetCurrentWindowAggregates(deviceID, int(is.dataWindow.Seconds()))
+	currentAgg, err := is.currentAggregates(deviceID)
 	if err != nil {
 		log.Printf("InferenceService: Error getting current aggregates for %s: %v", deviceID, err)
 		return
@@ -135,30 +303,55 @@ func (is *InferenceService) checkDevice(deviceID string) {
 		return
 	}
 
-	// If no previous inference, trigger immediately
+	for _, domain := range is.domains {
+		is.checkDomain(ctx, deviceID, domain, currentAgg, coldStarts)
+	}
+}
+
+// checkDomain triggers a domain's inference for a device if any of the domain's
+// metrics changed significantly since the domain's last inference
+func (is *InferenceService) checkDomain(ctx context.Context, deviceID string, domain InferenceDomain, currentAgg *database.SensorAggregates, coldStarts *int) {
+	label := deviceID
+	if domain.Name != "" {
+		label = deviceID + "/" + domain.Name
+	}
+
+	// Get last inference timestamp
+	lastInferenceTime, err := is.db.GetLastInferenceTimestamp(deviceID, domain.Name)
+	if err != nil {
+		log.Printf("InferenceService: Error getting last inference time for %s: %v", label, err)
+		return
+	}
+
+	// Fall back to persisted state when the history row is missing (e.g., a failed
+	// insert); the state only tracks a device's latest inference of any domain
+	if st, ok := is.state.Get(deviceID); ok && domain.Name == "" && st.LastInferenceTime.After(lastInferenceTime) {
+		lastInferenceTime = st.LastInferenceTime
+	}
+
+	// If no previous inference, schedule a rate-limited cold-start trigger
 	if lastInferenceTime.IsZero() {
-		log.Printf("InferenceService: First inference for %s, triggering immediately", deviceID)
-		is.triggerInference(deviceID, currentAgg, 0, 0, 0, "first_inference")
+		is.scheduleColdStart(ctx, deviceID, domain.Name, currentAgg, "first_inference", coldStarts)
 		return
 	}
 
 	// Get last inference window aggregates
-	lastAgg, err := is.db.GetLastInferenceWindowAggregates(deviceID, lastInferenceTime, int(is.dataWindow.Seconds()))
+	lastAgg, err := is.lastInferenceAggregates(deviceID, lastInferenceTime)
 	if err != nil {
-		log.Printf("InferenceService: Error getting last inference aggregates for %s: %v", deviceID, err)
+		log.Printf("InferenceService: Error getting last inference aggregates for %s: %v", label, err)
 		return
 	}
 
 	if !lastAgg.HasData {
-		log.Printf("InferenceService: No last inference data for %s, triggering", deviceID)
-		is.triggerInference(deviceID, currentAgg, 0, 0, 0, "missing_last_data")
+		log.Printf("InferenceService: No last inference data for %s", label)
+		is.scheduleColdStart(ctx, deviceID, domain.Name, currentAgg, "missing_last_data", coldStarts)
 		return
 	}
 
 	// Get historical baseline statistics
 	baseline, err := is.db.GetHistoricalBaselineStats(deviceID, is.baselineDays)
 	if err != nil {
-		log.Printf("InferenceService: Error getting baseline stats for %s: %v", deviceID, err)
+		log.Printf("InferenceService: Error getting baseline stats for %s: %v", label, err)
 		return
 	}
 
@@ -167,38 +360,150 @@ func (is *InferenceService) checkDevice(deviceID string) {
 	humidityZScore := is.calculateZScore(currentAgg.Humidity, lastAgg.Humidity, baseline.Humidity)
 	volumeZScore := is.calculateZScore(currentAgg.SoundVolume, lastAgg.SoundVolume, baseline.SoundVolume)
 
+	// Where the daily cycle is known, discount the change it explains
+	if profile := is.baselineProfile(deviceID); profile != nil {
+		nowHour, lastHour := time.Now().UTC().Hour(), lastInferenceTime.UTC().Hour()
+		if z, ok := is.hourlyZScore(currentAgg.Temperature, lastAgg.Temperature, profile.Temperature[nowHour], profile.Temperature[lastHour]); ok {
+			tempZScore = z
+		}
+		if z, ok := is.hourlyZScore(currentAgg.Humidity, lastAgg.Humidity, profile.Humidity[nowHour], profile.Humidity[lastHour]); ok {
+			humidityZScore = z
+		}
+		if z, ok := is.hourlyZScore(currentAgg.SoundVolume, lastAgg.SoundVolume, profile.SoundVolume[nowHour], profile.SoundVolume[lastHour]); ok {
+			volumeZScore = z
+		}
+	}
+
 	log.Printf("InferenceService: Device %s Z-scores: temp=%.2f, humidity=%.2f, volume=%.2f",
-		deviceID, tempZScore, humidityZScore, volumeZScore)
-
-	// Check if any Z-score exceeds threshold
-	shouldTrigger := false
-	triggerReason := ""
-
-	if math.Abs(tempZScore) >= is.zScoreThreshold {
-		shouldTrigger = true
-		triggerReason = "temperature_zscore"
-	}
-	if math.Abs(humidityZScore) >= is.zScoreThreshold {
-		shouldTrigger = true
-		if triggerReason != "" {
-			triggerReason += ",humidity_zscore"
-		} else {
-			triggerReason = "humidity_zscore"
+		label, tempZScore, humidityZScore, volumeZScore)
+
+	// Check if any Z-score of the domain's metrics exceeds threshold
+	var reasons []string
+	if domain.watches("temperature") && math.Abs(tempZScore) >= is.zScoreThreshold {
+		reasons = append(reasons, "temperature_zscore")
+	}
+	if domain.watches("humidity") && math.Abs(humidityZScore) >= is.zScoreThreshold {
+		reasons = append(reasons, "humidity_zscore")
+	}
+	if domain.watches("sound_volume") && math.Abs(volumeZScore) >= is.zScoreThreshold {
+		reasons = append(reasons, "volume_zscore")
+	}
+
+	// A slow trend never moves consecutive windows far apart, so also check how far
+	// each metric's fitted line has moved since the last inference
+	reasons = append(reasons, is.checkDrift(deviceID, domain, lastInferenceTime, baseline)...)
+
+	if len(reasons) > 0 {
+		triggerReason := strings.Join(reasons, ",")
+		log.Printf("InferenceService: Triggering inference for %s (reason: %s)", label, triggerReason)
+		is.triggerInference(deviceID, domain.Name, currentAgg, tempZScore, humidityZScore, volumeZScore, triggerReason)
+	}
+}
+
+// checkDrift returns a "<metric>_drift" trigger reason for each metric whose
+// regression slope over the drift window, extrapolated across the time since the
+// last inference (at most the window), exceeds the drift threshold in baseline std
+// devs. Only the time since the last inference counts, so a trend that already
+// triggered must keep going to trigger again. Metrics outside the domain are skipped.
+func (is *InferenceService) checkDrift(deviceID string, domain InferenceDomain, lastInferenceTime time.Time, baseline *database.SensorStdDevs) []string {
+	if is.driftWindow <= 0 {
+		return nil
+	}
+	now := time.Now()
+	drift, err := is.db.GetDriftSlopes(deviceID, now.Add(-is.driftWindow), now)
+	if err != nil {
+		log.Printf("InferenceService: Error getting drift slopes for %s: %v", deviceID, err)
+		return nil
+	}
+
+	elapsed := now.Sub(lastInferenceTime)
+	if elapsed > is.driftWindow {
+		elapsed = is.driftWindow
+	}
+	hours := elapsed.Hours()
+
+	checks := []struct {
+		metric  string
+		reason  string
+		slope   float64
+		samples uint64
+		stdDev  float64
+	}{
+		{"temperature", "temperature_drift", drift.Temperature, drift.TemperatureSamples, baseline.Temperature},
+		{"humidity", "humidity_drift", drift.Humidity, drift.HumiditySamples, baseline.Humidity},
+		{"sound_volume", "volume_drift", drift.SoundVolume, drift.VolumeSamples, baseline.SoundVolume},
+	}
+	var reasons []string
+	for _, c := range checks {
+		if !domain.watches(c.metric) || c.samples < is.driftMinSamples || c.stdDev == 0 {
+			continue
+		}
+		driftZ := c.slope * hours / c.stdDev
+		if math.Abs(driftZ) >= is.driftThreshold {
+			log.Printf("InferenceService: Device %s %s: %.3f/h over %v is %.2f std devs",
+				deviceID, c.reason, c.slope, elapsed.Round(time.Second), driftZ)
+			reasons = append(reasons, c.reason)
 		}
 	}
-	if math.Abs(volumeZScore) >= is.zScoreThreshold {
-		shouldTrigger = true
-		if triggerReason != "" {
-			triggerReason += ",volume_zscore"
-		} else {
-			triggerReason = "volume_zscore"
+	return reasons
+}
+
+// scheduleColdStart triggers a domain's inference for a device without usable history.
+// Triggers are skipped until enough baseline data exists, capped per poll cycle,
+// and spread over a random jitter window so a fresh deployment doesn't stampede
+// the ML service with one request per device at the same instant.
+func (is *InferenceService) scheduleColdStart(ctx context.Context, deviceID, domain string, agg *database.SensorAggregates, reason string, coldStarts *int) {
+	if is.minBaselineSamples > 0 {
+		samples, err := is.db.GetBaselineSampleCount(deviceID, is.baselineDays)
+		if err != nil {
+			log.Printf("InferenceService: Error counting baseline samples for %s: %v", deviceID, err)
+			return
+		}
+		if samples < is.minBaselineSamples {
+			log.Printf("InferenceService: Skipping %s for %s, baseline has %d/%d samples",
+				reason, deviceID, samples, is.minBaselineSamples)
+			return
 		}
 	}
 
-	if shouldTrigger {
-		log.Printf("InferenceService: Triggering inference for %s (reason: %s)", deviceID, triggerReason)
-		is.triggerInference(deviceID, currentAgg, tempZScore, humidityZScore, volumeZScore, triggerReason)
+	if is.coldStartMaxPerPoll > 0 && *coldStarts >= is.coldStartMaxPerPoll {
+		log.Printf("InferenceService: Cold-start limit reached, deferring %s for %s to next poll", reason, deviceID)
+		return
 	}
+
+	key := deviceID + "/" + domain
+	is.mu.Lock()
+	if is.pendingColdStart[key] {
+		is.mu.Unlock()
+		return
+	}
+	is.pendingColdStart[key] = true
+	is.mu.Unlock()
+	*coldStarts++
+
+	var delay time.Duration
+	if is.coldStartJitter > 0 {
+		delay = time.Duration(rand.Int63n(int64(is.coldStartJitter)))
+	}
+
+	log.Printf("InferenceService: Scheduling %s for %s in %v", reason, deviceID, delay.Round(time.Millisecond))
+
+	is.coldStartWG.Add(1)
+	go func() {
+		defer is.coldStartWG.Done()
+		defer func() {
+			is.mu.Lock()
+			delete(is.pendingColdStart, key)
+			is.mu.Unlock()
+		}()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+			is.triggerInference(deviceID, domain, agg, 0, 0, 0, reason)
+		}
+	}()
 }
 
 // calculateZScore computes normalized Z-score
@@ -211,21 +516,114 @@ func (is *InferenceService) calculateZScore(current, last, stdDev float64) float
 	return (current - last) / stdDev
 }
 
-// triggerInference creates and sends an inference request
-func (is *InferenceService) triggerInference(deviceID string, agg *database.SensorAggregates, tempZ, humidityZ, volumeZ float64, reason string) {
-	// Save inference history
-	err := is.db.SaveInferenceHistory(deviceID, reason, tempZ, humidityZ, volumeZ)
+// cachedProfile is a device's hourly baseline profile and when it was computed
+type cachedProfile struct {
+	profile *database.BaselineProfile
+	fetched time.Time
+}
+
+// baselineProfile returns a device's per-hour-of-day baseline, recomputed at most
+// every profileTTL. It returns nil when hourly baselines are disabled or unavailable.
+func (is *InferenceService) baselineProfile(deviceID string) *database.BaselineProfile {
+	if !is.hourlyBaseline {
+		return nil
+	}
+
+	is.mu.RLock()
+	cached, ok := is.profiles[deviceID]
+	is.mu.RUnlock()
+	if ok && time.Since(cached.fetched) < is.profileTTL {
+		return cached.profile
+	}
+
+	profile, err := is.db.GetBaselineProfile(deviceID, is.baselineDays)
 	if err != nil {
-		log.Printf("InferenceService: Error saving inference history for %s: %v", deviceID, err)
+		log.Printf("InferenceService: Error getting hourly baseline for %s, using the flat baseline: %v", deviceID, err)
+		return cached.profile // Stale beats none
+	}
+
+	is.mu.Lock()
+	is.profiles[deviceID] = cachedProfile{profile: profile, fetched: time.Now()}
+	is.mu.Unlock()
+	return profile
+}
+
+// hourlyZScore computes a Z-score against the daily cycle:
+// Z = ((current - last) - (mean[hour now] - mean[hour of last inference])) / std[hour now]
+// i.e., the change the time of day doesn't explain, in units of the current hour's
+// spread. It reports false when either hour has too few samples to be trusted.
+func (is *InferenceService) hourlyZScore(current, last float64, now, then database.HourStats) (float64, bool) {
+	if now.Samples < is.minHourSamples || then.Samples < is.minHourSamples || now.Samples == 0 || then.Samples == 0 {
+		return 0, false
+	}
+	if now.StdDev == 0 {
+		return 0, true // No variance at this hour, so no significant change
 	}
+	return ((current - last) - (now.Mean - then.Mean)) / now.StdDev, true
+}
+
+// triggerInference creates and sends an inference request for a domain (empty
+// without inference domains), returning it (nil if the channel was full). With a
+// cache, a request whose features haven't changed is answered with the previous
+// response instead.
+func (is *InferenceService) triggerInference(deviceID, domain string, agg *database.SensorAggregates, tempZ, humidityZ, volumeZ float64, reason string) *models.InferenceRequest {
+	is.state.MarkInference(deviceID, time.Now())
+	correlationID := mqtt.NewCorrelationID()
 
 	// Create inference request
 	request := &models.InferenceRequest{
-		DeviceID:    deviceID,
-		Timestamp:   time.Now(),
-		Temperature: agg.Temperature,
-		Humidity:    agg.Humidity,
-		SoundVolume: agg.SoundVolume,
+		CorrelationID: correlationID,
+		Domain:        domain,
+		DeviceID:      deviceID,
+		Timestamp:     time.Now(),
+		Temperature:   agg.Temperature,
+		Humidity:      agg.Humidity,
+		SoundVolume:   agg.SoundVolume,
+	}
+	if is.MoldRisk != nil {
+		if risk, ok := is.MoldRisk.Get(deviceID); ok {
+			request.MoldRisk = risk.RiskIndex
+		}
+	}
+	if is.Occupancy != nil {
+		if occ, ok := is.Occupancy.Get(deviceID); ok {
+			request.Occupancy = occ.Probability
+		}
+	}
+	if is.LagFeatures {
+		lags, err := is.db.GetLagFeatures(deviceID, request.Timestamp)
+		if err != nil {
+			log.Printf("InferenceService: Error getting lag features for %s: %v", deviceID, err)
+		} else if len(lags) > 0 {
+			request.Lags = lags
+		}
+	}
+	if is.Models != nil {
+		outdoor, ok := is.outdoorTemperature(deviceID, request.Timestamp)
+		if model, variant := is.Models.Select(request.Timestamp, outdoor, ok); model != nil {
+			request.ModelVariant = variant
+			metrics.Default.Counter("inference_model_variant_" + variant).Inc()
+			request.Scaling = model.Scaling()
+			request.ScaledFeatures = predict.ScaleFeatures(model.Scaler(), request.Features())
+		}
+	}
+
+	// Manual triggers always ask the ML service, since they validate the full loop
+	if is.Cache != nil && reason != "manual" {
+		if response, ok := is.Cache.Lookup(request); ok && is.replayCached(response) {
+			metrics.Default.Counter("inference_cache_hits").Inc()
+			if err := is.db.SaveInferenceHistory(deviceID, domain, reason, tempZ, humidityZ, volumeZ, correlationID, true); err != nil {
+				log.Printf("InferenceService: Error saving inference history for %s: %v", deviceID, err)
+			}
+			log.Printf("InferenceService: Features of %s unchanged, reused the last response (position=%.1f%%)", deviceID, response.Position)
+			return request
+		}
+		metrics.Default.Counter("inference_cache_misses").Inc()
+	}
+
+	// Save inference history
+	if err := is.db.SaveInferenceHistory(deviceID, domain, reason, tempZ, humidityZ, volumeZ, correlationID, false); err != nil {
+		log.Printf("InferenceService: Error saving inference history for %s: %v", deviceID, err)
 	}
 
 	// Send request to channel (non-blocking with timeout)
@@ -233,9 +631,84 @@ func (is *InferenceService) triggerInference(deviceID string, agg *database.Sens
 	case is.InferenceReqChan <- request:
 		log.Printf("InferenceService: Inference request sent for %s (temp=%.2f°C, humidity=%.2f%%, volume=%.2f dB)",
 			deviceID, request.Temperature, request.Humidity, request.SoundVolume)
+		if is.Cache != nil {
+			is.Cache.Sent(request)
+		}
+		return request
 	case <-time.After(1 * time.Second):
 		log.Printf("InferenceService: Warning - Inference request channel full, dropping request for %s", deviceID)
+		return nil
+	}
+}
+
+// replayCached hands a cached response to window control as if the ML service had
+// answered, reporting whether it was queued (if not, the request is sent after all)
+func (is *InferenceService) replayCached(response *models.InferenceResponse) bool {
+	select {
+	case is.CachedResponseChan <- response:
+		return true
+	case <-time.After(1 * time.Second):
+		log.Printf("InferenceService: Warning - Response channel full, asking the ML service for %s instead", response.DeviceID)
+		return false
+	}
+}
+
+// outdoorTemperature returns a device's recent outdoor temperature, if one is known
+func (is *InferenceService) outdoorTemperature(deviceID string, now time.Time) (float64, bool) {
+	if is.Outdoor == nil {
+		return 0, false
+	}
+	return is.Outdoor.Get(deviceID, now)
+}
+
+// ErrNoCurrentData is returned by TriggerManual when a device has no readings in the data window
+var ErrNoCurrentData = errors.New("no current data for device")
+
+// ErrInferenceQueueFull is returned by TriggerManual when the request could not be queued
+var ErrInferenceQueueFull = errors.New("inference request channel full")
+
+// CooldownError is returned by TriggerManual when the device's last inference was too recent
+type CooldownError struct {
+	Remaining time.Duration
+}
+
+func (e *CooldownError) Error() string {
+	return fmt.Sprintf("last inference was too recent, retry in %v", e.Remaining.Round(time.Second))
+}
+
+// TriggerManual forces an inference for a device now with reason "manual", so an
+// installer can validate the full loop on site. It is refused within the manual
+// cooldown of the device's last inference unless force is set. With inference
+// domains every domain is triggered, and the first queued request is returned.
+func (is *InferenceService) TriggerManual(deviceID string, force bool) (*models.InferenceRequest, error) {
+	if !force && is.manualCooldown > 0 {
+		if st, ok := is.state.Get(deviceID); ok && !st.LastInferenceTime.IsZero() {
+			if remaining := is.manualCooldown - time.Since(st.LastInferenceTime); remaining > 0 {
+				return nil, &CooldownError{Remaining: remaining}
+			}
+		}
+	}
+
+	agg, err := is.currentAggregates(deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current aggregates: %w", err)
+	}
+	if !agg.HasData {
+		return nil, ErrNoCurrentData
+	}
+
+	is.RegisterDevice(deviceID)
+	log.Printf("InferenceService: Manual inference for %s (force=%v)", deviceID, force)
+	var request *models.InferenceRequest
+	for _, domain := range is.domains {
+		if r := is.triggerInference(deviceID, domain.Name, agg, 0, 0, 0, "manual"); request == nil {
+			request = r
+		}
+	}
+	if request == nil {
+		return nil, ErrInferenceQueueFull
 	}
+	return request, nil
 }
 
 // RegisterDevice adds a device to the tracking list
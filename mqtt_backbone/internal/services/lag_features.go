@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"iot-backend/internal/database"
+)
+
+// LagFeaturesConfig holds settings for the lag features table
+type LagFeaturesConfig struct {
+	Interval time.Duration // How often completed minutes are computed
+	Lateness time.Duration // Minutes this recent are computed again, picking up late readings
+	Backfill time.Duration // How far back the table catches up at startup
+}
+
+// DefaultLagFeaturesConfig returns default lag features settings
+func DefaultLagFeaturesConfig() LagFeaturesConfig {
+	return LagFeaturesConfig{
+		Interval: time.Minute,
+		Lateness: 5 * time.Minute,
+		Backfill: 48 * time.Hour,
+	}
+}
+
+// LagFeaturesService keeps the lag_features table up to date: each completed minute's
+// mean per device and metric, with the means 15 minutes, 1 hour and 24 hours earlier
+// looked up from the minutes already stored. Lateness must stay below the shortest
+// lag, so a lagged minute is final by the time a later minute reads it.
+type LagFeaturesService struct {
+	db     *database.ClickHouseDB
+	config LagFeaturesConfig
+}
+
+// NewLagFeaturesService creates a new lag features service
+func NewLagFeaturesService(db *database.ClickHouseDB, config LagFeaturesConfig) *LagFeaturesService {
+	return &LagFeaturesService{db: db, config: config}
+}
+
+// Start catches up from the last computed minute (at most Backfill ago) and then
+// computes the recent minutes every interval until context is cancelled
+func (ls *LagFeaturesService) Start(ctx context.Context) {
+	log.Printf("LagFeaturesService: Starting (every %v, late readings within %v)", ls.config.Interval, ls.config.Lateness)
+
+	now := time.Now()
+	from := now.Add(-ls.config.Backfill).Truncate(time.Minute)
+	last, err := ls.db.GetLastLagFeaturesMinute()
+	if err != nil {
+		log.Printf("LagFeaturesService: Error finding last computed minute: %v", err)
+	} else if !last.IsZero() {
+		if resume := last.Add(-ls.config.Lateness); resume.After(from) {
+			from = resume
+		}
+	}
+	done := ls.update(ctx, from, now)
+
+	ticker := time.NewTicker(ls.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("LagFeaturesService: Stopped")
+			return
+		case <-ticker.C:
+			now := time.Now()
+			from := now.Add(-ls.config.Lateness - ls.config.Interval).Truncate(time.Minute)
+			if done.Before(from) {
+				// An earlier update stopped short
+				from = done
+			}
+			done = ls.update(ctx, from, now)
+		}
+	}
+}
+
+// update computes the completed minutes from from until now, oldest first and in
+// batches no longer than the shortest lag, and returns where it stopped
+func (ls *LagFeaturesService) update(ctx context.Context, from, now time.Time) time.Time {
+	to := now.Truncate(time.Minute)
+	for from.Before(to) && ctx.Err() == nil {
+		end := from.Add(database.ShortestLag)
+		if end.After(to) {
+			end = to
+		}
+		if err := ls.db.UpdateLagFeatures(from, end, now); err != nil {
+			// Later batches would lag to the missing minutes; the next update resumes here
+			log.Printf("LagFeaturesService: Error computing %s to %s: %v", from.Format(time.RFC3339), end.Format(time.RFC3339), err)
+			return from
+		}
+		from = end
+	}
+	return from
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"iot-backend/internal/alerts"
+	"iot-backend/internal/database"
+	"iot-backend/internal/derived"
+	"iot-backend/internal/models"
+)
+
+// MoldRiskConfig holds configuration for mold risk tracking
+type MoldRiskConfig struct {
+	Thresholds          derived.MoldRiskConfig
+	SaveIntervalSeconds int     // Minimum time between stored samples per device
+	AlertThreshold      float64 // Risk index at which an alert is raised (0-1)
+}
+
+// DefaultMoldRiskConfig returns default configuration
+func DefaultMoldRiskConfig() MoldRiskConfig {
+	return MoldRiskConfig{
+		Thresholds:          derived.DefaultMoldRiskConfig(),
+		SaveIntervalSeconds: 600,
+		AlertThreshold:      0.8,
+	}
+}
+
+// moldRiskMonitor updates the mold risk tracker from readings, stores samples, and raises alerts
+type moldRiskMonitor struct {
+	tracker           *derived.MoldRiskTracker
+	db                *database.ClickHouseDB
+	humidityThreshold float64
+	saveInterval      time.Duration
+	alertThreshold    float64
+
+	mu        sync.Mutex
+	lastSaved map[string]time.Time
+}
+
+func newMoldRiskMonitor(db *database.ClickHouseDB, config MoldRiskConfig) *moldRiskMonitor {
+	return &moldRiskMonitor{
+		tracker:           derived.NewMoldRiskTracker(config.Thresholds),
+		db:                db,
+		humidityThreshold: config.Thresholds.HumidityThreshold,
+		saveInterval:      time.Duration(config.SaveIntervalSeconds) * time.Second,
+		alertThreshold:    config.AlertThreshold,
+		lastSaved:         make(map[string]time.Time),
+	}
+}
+
+// observe stores the updated risk (throttled per device) and raises an alert above the threshold
+func (m *moldRiskMonitor) observe(risk models.MoldRisk, ok bool, alertManager *alerts.Manager) {
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	due := risk.Timestamp.Sub(m.lastSaved[risk.DeviceID]) >= m.saveInterval
+	if due {
+		m.lastSaved[risk.DeviceID] = risk.Timestamp
+	}
+	m.mu.Unlock()
+
+	if due {
+		if err := m.db.SaveMoldRisk(&risk); err != nil {
+			log.Printf("Error saving mold risk: %v", err)
+		}
+	}
+
+	if alertManager != nil && m.alertThreshold > 0 && risk.RiskIndex >= m.alertThreshold {
+		alertManager.Raise(&models.Alert{
+			Timestamp: risk.Timestamp,
+			DeviceID:  risk.DeviceID,
+			Type:      models.AlertMoldRisk,
+			Severity:  models.SeverityWarning,
+			Message: fmt.Sprintf("Mold risk %.2f: %.1f h above %.0f%% RH (now %.1f%% RH, %.1f°C); ventilate",
+				risk.RiskIndex, risk.HoursAtRisk, m.humidityThreshold, risk.Humidity, risk.Temperature),
+			Value: risk.RiskIndex,
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-backend/internal/aggregator"
+	"iot-backend/internal/alerts"
+	"iot-backend/internal/models"
+)
+
+// NoiseExposureConfig holds configuration for sustained noise exposure alerts
+type NoiseExposureConfig struct {
+	Level          float64 // Level (dB) that must be exceeded; 0 disables the alerts
+	Percentile     string  // Clip statistic compared with Level: "l10", "l50", "l90", or "leq"
+	Minutes        int     // How long the level must be exceeded before alerting
+	QuietStartHour int     // Local hour the watched period starts (e.g., 22)
+	QuietEndHour   int     // Local hour it ends (e.g., 7); equal to the start watches all day
+	MaxGapMinutes  int     // A longer gap between clips restarts the exposure
+	CloseWindow    bool    // Also close the window while the exposure lasts
+}
+
+// DefaultNoiseExposureConfig returns default configuration
+func DefaultNoiseExposureConfig() NoiseExposureConfig {
+	return NoiseExposureConfig{
+		Level:          0,
+		Percentile:     "l50",
+		Minutes:        30,
+		QuietStartHour: 22,
+		QuietEndHour:   7,
+		MaxGapMinutes:  5,
+		CloseWindow:    false,
+	}
+}
+
+// ParseQuietHours parses "start-end" local hours (e.g., "22-7"); an empty spec
+// watches all day
+func ParseQuietHours(spec string) (start, end int, err error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return 0, 0, nil
+	}
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("quiet hours %q must be start-end", spec)
+	}
+	if start, err = strconv.Atoi(strings.TrimSpace(from)); err != nil || start < 0 || start > 23 {
+		return 0, 0, fmt.Errorf("quiet hours %q: invalid start hour", spec)
+	}
+	if end, err = strconv.Atoi(strings.TrimSpace(to)); err != nil || end < 0 || end > 23 {
+		return 0, 0, fmt.Errorf("quiet hours %q: invalid end hour", spec)
+	}
+	return start, end, nil
+}
+
+// Validate checks the configured statistic
+func (c NoiseExposureConfig) Validate() error {
+	switch c.Percentile {
+	case "l10", "l50", "l90", "leq":
+		return nil
+	}
+	return fmt.Errorf("noise exposure percentile %q must be l10, l50, l90, or leq", c.Percentile)
+}
+
+// noiseExposure tracks the current stretch of loud clips for one device
+type noiseExposure struct {
+	since     time.Time // First clip above the level
+	last      time.Time // Latest clip above the level
+	sustained bool      // The stretch has lasted long enough to alert
+	refreshed time.Time // Last closure request sent for the stretch
+}
+
+// noiseExposureMonitor raises alerts (and optionally closes windows) when clip
+// percentile levels stay above a limit during the watched hours
+type noiseExposureMonitor struct {
+	config   NoiseExposureConfig
+	duration time.Duration
+	maxGap   time.Duration
+
+	mu      sync.Mutex
+	devices map[string]*noiseExposure
+}
+
+func newNoiseExposureMonitor(config NoiseExposureConfig) *noiseExposureMonitor {
+	return &noiseExposureMonitor{
+		config:   config,
+		duration: time.Duration(config.Minutes) * time.Minute,
+		maxGap:   time.Duration(config.MaxGapMinutes) * time.Minute,
+		devices:  make(map[string]*noiseExposure),
+	}
+}
+
+// level returns the configured statistic of a clip
+func (m *noiseExposureMonitor) level(stats aggregator.LevelStats) float64 {
+	switch m.config.Percentile {
+	case "l10":
+		return stats.L10
+	case "l90":
+		return stats.L90
+	case "leq":
+		return stats.Leq
+	}
+	return stats.L50
+}
+
+// watched reports whether t falls in the watched hours
+func (m *noiseExposureMonitor) watched(t time.Time) bool {
+	start, end, hour := m.config.QuietStartHour, m.config.QuietEndHour, t.Local().Hour()
+	switch {
+	case start == end:
+		return true
+	case start < end:
+		return hour >= start && hour < end
+	default: // Wraps midnight
+		return hour >= start || hour < end
+	}
+}
+
+// exposureUpdate is the outcome of observing a clip
+type exposureUpdate struct {
+	Level    float64
+	Duration time.Duration // Length of the current stretch
+	Started  bool          // The stretch just became sustained
+	Refresh  bool          // A sustained stretch continues and its closure should be renewed
+	Ended    bool          // A sustained stretch just ended
+}
+
+// observe updates a device's exposure with a clip's level statistics
+func (m *noiseExposureMonitor) observe(deviceID string, at time.Time, stats aggregator.LevelStats) exposureUpdate {
+	update := exposureUpdate{Level: m.level(stats)}
+	loud := stats.Frames > 0 && update.Level > m.config.Level && m.watched(at)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.devices[deviceID]
+	if e != nil && (!loud || at.Sub(e.last) > m.maxGap) {
+		update.Ended = e.sustained
+		delete(m.devices, deviceID)
+		e = nil
+	}
+	if !loud {
+		return update
+	}
+	if e == nil {
+		e = &noiseExposure{since: at}
+		m.devices[deviceID] = e
+	}
+	e.last = at
+	update.Duration = at.Sub(e.since)
+
+	if !e.sustained && update.Duration >= m.duration {
+		e.sustained, e.refreshed = true, at
+		update.Started = true
+	} else if e.sustained && at.Sub(e.refreshed) >= m.maxGap {
+		e.refreshed = at
+		update.Refresh = true
+	}
+	return update
+}
+
+// report raises the alert for a sustained exposure and drives the window closure
+func (m *noiseExposureMonitor) report(recording *models.AudioRecording, update exposureUpdate, alertManager *alerts.Manager, safety SafetyHandler) {
+	if update.Started {
+		log.Printf("Noise exposure: device=%s, %s=%.1f dB above %.1f dB for %.0f min",
+			recording.DeviceID, m.config.Percentile, update.Level, m.config.Level, update.Duration.Minutes())
+		if alertManager != nil {
+			alertManager.Raise(&models.Alert{
+				Timestamp: recording.Timestamp,
+				DeviceID:  recording.DeviceID,
+				Type:      models.AlertNoiseExposure,
+				Severity:  models.SeverityWarning,
+				Message: fmt.Sprintf("Noise above %.0f dB (%s %.1f dB) for %.0f min",
+					m.config.Level, m.config.Percentile, update.Level, update.Duration.Minutes()),
+				Value: update.Level,
+			})
+		}
+	}
+	if update.Ended {
+		log.Printf("Noise exposure ended: device=%s", recording.DeviceID)
+	}
+
+	if !m.config.CloseWindow || safety == nil {
+		return
+	}
+	event := &models.SafetyEvent{Timestamp: recording.Timestamp, DeviceID: recording.DeviceID, EventType: models.SafetyNoise}
+	switch {
+	case update.Started || update.Refresh:
+		event.Value = update.Level
+	case update.Ended:
+		event.Value = 0 // Releases the closure
+	default:
+		return
+	}
+	safety.HandleSafetyEvent(event)
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"iot-backend/internal/aggregator"
+	"iot-backend/internal/database"
+	"iot-backend/internal/models"
+)
+
+// levelWindow accumulates one device's frame levels for the current hour
+type levelWindow struct {
+	start time.Time
+	hist  aggregator.LevelHistogram
+}
+
+// hourlyLevels maintains rolling hourly L10/L50/L90 per device and stores each completed hour
+type hourlyLevels struct {
+	db *database.ClickHouseDB
+
+	mu      sync.Mutex
+	windows map[string]*levelWindow
+}
+
+func newHourlyLevels(db *database.ClickHouseDB) *hourlyLevels {
+	return &hourlyLevels{
+		db:      db,
+		windows: make(map[string]*levelWindow),
+	}
+}
+
+// add records a clip's frame levels in the hour of its timestamp, storing the previous hour if it just ended
+func (h *hourlyLevels) add(deviceID string, timestamp time.Time, levels []float64) {
+	if len(levels) == 0 {
+		return
+	}
+	hour := timestamp.Truncate(time.Hour)
+
+	h.mu.Lock()
+	var completed *models.AudioLevelWindow
+	w, ok := h.windows[deviceID]
+	if ok && !w.start.Equal(hour) {
+		completed = windowResult(deviceID, w)
+		ok = false
+	}
+	if !ok {
+		w = &levelWindow{start: hour}
+		h.windows[deviceID] = w
+	}
+	w.hist.Add(levels)
+	h.mu.Unlock()
+
+	if completed != nil {
+		h.save(completed)
+	}
+}
+
+// run stores hours that ended without a newer clip (e.g., a device went quiet) until
+// context is cancelled, then stores the partial current hours
+func (h *hourlyLevels) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			h.flush(time.Time{})
+			return
+		case now := <-ticker.C:
+			h.flush(now.Truncate(time.Hour))
+		}
+	}
+}
+
+// flush stores and removes windows that started before cutoff (zero cutoff flushes everything)
+func (h *hourlyLevels) flush(cutoff time.Time) {
+	h.mu.Lock()
+	var completed []*models.AudioLevelWindow
+	for deviceID, w := range h.windows {
+		if cutoff.IsZero() || w.start.Before(cutoff) {
+			completed = append(completed, windowResult(deviceID, w))
+			delete(h.windows, deviceID)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, window := range completed {
+		h.save(window)
+	}
+}
+
+// save persists a completed window
+func (h *hourlyLevels) save(window *models.AudioLevelWindow) {
+	if err := h.db.SaveAudioLevelWindow(window); err != nil {
+		log.Printf("Error saving hourly sound levels: %v", err)
+		return
+	}
+	log.Printf("Saved hourly sound levels: device=%s, hour=%s, L10=%.1f, L50=%.1f, L90=%.1f dB",
+		window.DeviceID, window.WindowStart.Format(time.RFC3339), window.L10, window.L50, window.L90)
+}
+
+// windowResult converts an accumulated window into its stored form
+func windowResult(deviceID string, w *levelWindow) *models.AudioLevelWindow {
+	stats := w.hist.Stats()
+	return &models.AudioLevelWindow{
+		WindowStart: w.start,
+		DeviceID:    deviceID,
+		L10:         stats.L10,
+		L50:         stats.L50,
+		L90:         stats.L90,
+		Leq:         stats.Leq,
+		Frames:      stats.Frames,
+	}
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"iot-backend/internal/database"
+	"iot-backend/internal/derived"
+	"iot-backend/internal/models"
+)
+
+// OccupancyConfig holds configuration for occupancy estimation
+type OccupancyConfig struct {
+	Estimator           derived.OccupancyConfig
+	SaveIntervalSeconds int // Minimum time between stored estimates per device
+}
+
+// DefaultOccupancyConfig returns default configuration
+func DefaultOccupancyConfig() OccupancyConfig {
+	return OccupancyConfig{
+		Estimator:           derived.DefaultOccupancyConfig(),
+		SaveIntervalSeconds: 300,
+	}
+}
+
+// occupancyMonitor feeds the occupancy estimator and stores estimates (throttled per device)
+type occupancyMonitor struct {
+	estimator    *derived.OccupancyEstimator
+	db           *database.ClickHouseDB
+	saveInterval time.Duration
+
+	mu        sync.Mutex
+	lastSaved map[string]time.Time
+	lastState map[string]bool // Last stored occupied flag, so transitions are stored immediately
+}
+
+func newOccupancyMonitor(db *database.ClickHouseDB, config OccupancyConfig) *occupancyMonitor {
+	return &occupancyMonitor{
+		estimator:    derived.NewOccupancyEstimator(config.Estimator),
+		db:           db,
+		saveInterval: time.Duration(config.SaveIntervalSeconds) * time.Second,
+		lastSaved:    make(map[string]time.Time),
+		lastState:    make(map[string]bool),
+	}
+}
+
+// observePresence updates the estimate from a motion or CO2 reading
+func (m *occupancyMonitor) observePresence(reading *models.PresenceReading) {
+	switch reading.Kind {
+	case models.PresenceMotion:
+		m.observe(m.estimator.UpdateMotion(reading.DeviceID, reading.Value > 0, reading.Timestamp))
+	case models.PresenceCO2:
+		m.observe(m.estimator.UpdateCO2(reading.DeviceID, reading.Value, reading.Timestamp))
+	default:
+		log.Printf("Occupancy: Ignoring unknown presence reading %q from %s", reading.Kind, reading.DeviceID)
+	}
+}
+
+// observeSound updates the estimate from a clip's loudness above the noise floor
+func (m *occupancyMonitor) observeSound(deviceID string, relativeDB float64, timestamp time.Time) {
+	m.observe(m.estimator.UpdateSound(deviceID, relativeDB, timestamp))
+}
+
+// observe stores the estimate when the save interval has passed or the room changed state
+func (m *occupancyMonitor) observe(occ models.Occupancy) {
+	m.mu.Lock()
+	last, seen := m.lastState[occ.DeviceID]
+	due := !seen || last != occ.Occupied || occ.Timestamp.Sub(m.lastSaved[occ.DeviceID]) >= m.saveInterval
+	if due {
+		m.lastSaved[occ.DeviceID] = occ.Timestamp
+		m.lastState[occ.DeviceID] = occ.Occupied
+	}
+	m.mu.Unlock()
+
+	if !due {
+		return
+	}
+	if seen && last != occ.Occupied {
+		log.Printf("Occupancy: %s is now %s (p=%.2f)", occ.DeviceID, occupancyLabel(occ.Occupied), occ.Probability)
+	}
+	if err := m.db.SaveOccupancy(&occ); err != nil {
+		log.Printf("Error saving occupancy: %v", err)
+	}
+}
+
+// occupancyLabel describes an occupied flag for logs
+func occupancyLabel(occupied bool) string {
+	if occupied {
+		return "occupied"
+	}
+	return "empty"
+}
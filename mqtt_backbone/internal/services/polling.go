@@ -0,0 +1,76 @@
+package services
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// Polling modes
+const (
+	PollingBurst     = "burst"     // Check every device at each tick (query spike, simplest to reason about)
+	PollingStaggered = "staggered" // Spread device checks evenly across the interval
+)
+
+// ParsePollingMode validates a polling mode name
+func ParsePollingMode(name string) (string, error) {
+	switch name {
+	case PollingBurst, PollingStaggered:
+		return name, nil
+	}
+	return "", fmt.Errorf("polling mode %q must be %s or %s", name, PollingBurst, PollingStaggered)
+}
+
+// pollPhase returns a device's fixed offset within the polling interval. The
+// offset is derived from a hash of the device ID, so it stays the same across
+// restarts and devices spread evenly regardless of when they were discovered.
+func pollPhase(deviceID string, interval time.Duration) time.Duration {
+	h := fnv.New32a()
+	h.Write([]byte(deviceID))
+
+	// FNV-1a alone clusters similar IDs ("dev-1", "dev-2", ...); a final avalanche
+	// step (MurmurHash3's fmix32) spreads them across the interval
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return time.Duration(float64(x) / (1 << 32) * float64(interval))
+}
+
+// staggerStep returns how often the staggered scheduler wakes up: about sixty
+// slots per interval, but never more than once a second
+func staggerStep(interval time.Duration) time.Duration {
+	step := interval / 60
+	if step < time.Second {
+		step = time.Second
+	}
+	return step
+}
+
+// pollSchedule tracks the position within the polling cycle between wake-ups
+type pollSchedule struct {
+	interval time.Duration
+	last     time.Duration // Cycle position covered by the previous wake-up
+}
+
+// cyclePosition returns how far t is into its polling cycle (cycles are aligned to the Unix epoch)
+func (ps *pollSchedule) cyclePosition(t time.Time) time.Duration {
+	return time.Duration(t.UnixNano() % int64(ps.interval))
+}
+
+// advance moves the schedule to now and reports whether a new cycle started
+func (ps *pollSchedule) advance(now time.Time) (from, to time.Duration, wrapped bool) {
+	from, to = ps.last, ps.cyclePosition(now)
+	ps.last = to
+	return from, to, to < from
+}
+
+// due reports whether a phase falls in the cycle range (from, to], wrapping at the interval
+func due(phase, from, to time.Duration) bool {
+	if from <= to {
+		return phase > from && phase <= to
+	}
+	return phase > from || phase <= to
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"iot-backend/internal/database"
+)
+
+// ReadingsRollupConfig holds settings for the sensor_readings wide-row roll-up
+type ReadingsRollupConfig struct {
+	Interval time.Duration // How often completed minutes are rolled up
+	Lateness time.Duration // Minutes this recent are rolled up again, picking up late readings
+	Backfill time.Duration // How far back the roll-up catches up at startup
+}
+
+// DefaultReadingsRollupConfig returns default readings roll-up settings
+func DefaultReadingsRollupConfig() ReadingsRollupConfig {
+	return ReadingsRollupConfig{
+		Interval: time.Minute,
+		Lateness: 5 * time.Minute,
+		Backfill: 24 * time.Hour,
+	}
+}
+
+// ReadingsRollupService joins each device's temperature, humidity and volume into one
+// row per minute in the sensor_readings table, for analytics queries that want a
+// single row per device-minute. Only completed minutes are rolled up.
+type ReadingsRollupService struct {
+	db     *database.ClickHouseDB
+	config ReadingsRollupConfig
+}
+
+// NewReadingsRollupService creates a new readings roll-up service
+func NewReadingsRollupService(db *database.ClickHouseDB, config ReadingsRollupConfig) *ReadingsRollupService {
+	return &ReadingsRollupService{db: db, config: config}
+}
+
+// Start catches up from the last rolled-up minute (at most Backfill ago) and then rolls
+// up the recent minutes every interval until context is cancelled
+func (rs *ReadingsRollupService) Start(ctx context.Context) {
+	log.Printf("ReadingsRollupService: Starting (every %v, late readings within %v)", rs.config.Interval, rs.config.Lateness)
+
+	now := time.Now()
+	from := now.Add(-rs.config.Backfill).Truncate(time.Minute)
+	last, err := rs.db.GetLastSensorReadingsMinute()
+	if err != nil {
+		log.Printf("ReadingsRollupService: Error finding last rolled-up minute: %v", err)
+	} else if !last.IsZero() {
+		// The last minute may have been rolled up before all its readings arrived
+		if resume := last.Add(-rs.config.Lateness); resume.After(from) {
+			from = resume
+		}
+	}
+	rs.rollup(from, now)
+
+	ticker := time.NewTicker(rs.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("ReadingsRollupService: Stopped")
+			return
+		case <-ticker.C:
+			now := time.Now()
+			rs.rollup(now.Add(-rs.config.Lateness-rs.config.Interval).Truncate(time.Minute), now)
+		}
+	}
+}
+
+// rollup rolls up the completed minutes from from until now
+func (rs *ReadingsRollupService) rollup(from, now time.Time) {
+	to := now.Truncate(time.Minute)
+	if !from.Before(to) {
+		return
+	}
+	if err := rs.db.RollupSensorReadings(from, to, now); err != nil {
+		log.Printf("ReadingsRollupService: Error rolling up %s to %s: %v", from.Format(time.RFC3339), to.Format(time.RFC3339), err)
+	}
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"iot-backend/internal/arbitration"
+	"iot-backend/internal/database"
+	"iot-backend/internal/models"
+)
+
+// SchedulerConfig holds settings for executing scheduled window commands
+type SchedulerConfig struct {
+	CheckInterval      time.Duration // How often due commands are looked up
+	MissedGraceMinutes int           // Commands later than this (e.g., due while the backend was down) are marked missed, not executed
+}
+
+// DefaultSchedulerConfig returns default scheduler settings
+func DefaultSchedulerConfig() SchedulerConfig {
+	return SchedulerConfig{
+		CheckInterval:      15 * time.Second,
+		MissedGraceMinutes: 15,
+	}
+}
+
+// ScheduleTarget executes scheduled commands
+type ScheduleTarget interface {
+	ApplySchedule(command *models.ScheduledCommand)
+}
+
+// SchedulerService executes one-off scheduled window commands. Commands live in the
+// scheduled_commands table, so they survive restarts: after downtime, commands that
+// came due within the grace period run late and older ones are marked missed, so a
+// backend restarting in the morning doesn't close windows as planned for last night.
+type SchedulerService struct {
+	db       *database.ClickHouseDB
+	target   ScheduleTarget
+	interval time.Duration
+	grace    time.Duration
+}
+
+// NewSchedulerService creates a new scheduler service
+func NewSchedulerService(db *database.ClickHouseDB, target ScheduleTarget, config SchedulerConfig) *SchedulerService {
+	return &SchedulerService{
+		db:       db,
+		target:   target,
+		interval: config.CheckInterval,
+		grace:    time.Duration(config.MissedGraceMinutes) * time.Minute,
+	}
+}
+
+// Start executes due commands until context is cancelled. The first check runs
+// immediately to handle commands that came due while the backend was down.
+func (ss *SchedulerService) Start(ctx context.Context) {
+	log.Printf("SchedulerService: Starting (check every %v, missed after %v)", ss.interval, ss.grace)
+
+	ticker := time.NewTicker(ss.interval)
+	defer ticker.Stop()
+
+	for {
+		ss.runDue(time.Now())
+		select {
+		case <-ctx.Done():
+			log.Println("SchedulerService: Stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runDue executes or expires every pending command that has come due
+func (ss *SchedulerService) runDue(now time.Time) {
+	due, err := ss.db.GetDueScheduledCommands(now)
+	if err != nil {
+		log.Printf("SchedulerService: Error reading due commands: %v", err)
+		return
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	for _, cmd := range due {
+		late := now.Sub(cmd.ExecuteAt)
+		cmd.UpdatedAt = now
+		if late > ss.grace {
+			cmd.Status = models.ScheduleStatusMissed
+			log.Printf("SchedulerService: Command %s for %s was due %v ago, marked missed",
+				cmd.ID, cmd.DeviceID, late.Round(time.Second))
+			continue
+		}
+
+		ss.target.ApplySchedule(cmd)
+		cmd.Status = models.ScheduleStatusExecuted
+		executedAt := now
+		cmd.ExecutedAt = &executedAt
+		log.Printf("SchedulerService: Executed command %s: %s to %.0f%% (%v late)",
+			cmd.ID, cmd.DeviceID, cmd.Position, late.Round(time.Second))
+	}
+
+	// A failed write leaves the commands pending; executing one again sets the same position
+	if err := ss.db.SaveScheduledCommands(due); err != nil {
+		log.Printf("SchedulerService: Error saving command status: %v", err)
+	}
+}
+
+// ApplySchedule proposes a scheduled command's position for its hold period
+func (ws *WindowControlService) ApplySchedule(command *models.ScheduledCommand) {
+	proposal := arbitration.Proposal{
+		DeviceID: command.DeviceID,
+		Source:   arbitration.SourceSchedule,
+		Key:      "scheduled",
+		Position: command.Position,
+		Reason:   fmt.Sprintf("scheduled command %s", command.ID),
+	}
+	if command.Reason != "" {
+		proposal.Reason += " (" + command.Reason + ")"
+	}
+	if command.HoldMinutes > 0 {
+		proposal.ExpiresAt = time.Now().Add(time.Duration(command.HoldMinutes) * time.Minute)
+	}
+	ws.apply(proposal, &models.WindowAction{Confidence: 1.0})
+}
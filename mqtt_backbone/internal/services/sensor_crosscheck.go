@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"iot-backend/internal/alerts"
+	"iot-backend/internal/database"
+	"iot-backend/internal/models"
+	"iot-backend/internal/stats"
+)
+
+// SensorCrossCheckConfig holds configuration for cross-validating the sensors of a room
+type SensorCrossCheckConfig struct {
+	Interval             time.Duration // How often the judged window is recomputed
+	RoomTag              string        // Device tag naming the room; devices without it aren't checked
+	WindowHours          int           // Recent complete hours a divergence must persist over
+	MinNeighbors         int           // Other devices of the room that must report in an hour for it to count
+	MinHours             int           // Compared hours within the window before a device is judged
+	DivergentShare       float64       // Share of compared hours beyond the threshold that raises an alert
+	TemperatureThreshold float64       // Largest normal distance from the room median (°C)
+	HumidityThreshold    float64       // Largest normal distance from the room median (% RH)
+}
+
+// DefaultSensorCrossCheckConfig returns default configuration
+func DefaultSensorCrossCheckConfig() SensorCrossCheckConfig {
+	return SensorCrossCheckConfig{
+		Interval:             time.Hour,
+		RoomTag:              "room",
+		WindowHours:          24,
+		MinNeighbors:         2,
+		MinHours:             6,
+		DivergentShare:       0.75,
+		TemperatureThreshold: 1.5,
+		HumidityThreshold:    8,
+	}
+}
+
+// SensorCrossCheckService compares the hourly means of devices sharing a room: a
+// sensor whose temperature or humidity stays away from the room's median for most
+// of the window is likely failing (or badly placed) and raises an alert. Two
+// devices can't tell which of them is wrong, so a device needs MinNeighbors others
+// reporting. Every compared hour is stored in sensor_divergence.
+type SensorCrossCheckService struct {
+	db     *database.ClickHouseDB
+	alerts *alerts.Manager // Optional
+	config SensorCrossCheckConfig
+}
+
+// NewSensorCrossCheckService creates a cross-check service; alertManager may be nil
+func NewSensorCrossCheckService(db *database.ClickHouseDB, alertManager *alerts.Manager, config SensorCrossCheckConfig) *SensorCrossCheckService {
+	return &SensorCrossCheckService{db: db, alerts: alertManager, config: config}
+}
+
+// Start checks the window now and then every interval until context is cancelled
+func (sc *SensorCrossCheckService) Start(ctx context.Context) {
+	log.Printf("SensorCrossCheckService: Starting (every %v, rooms by tag %q, %d hour window)",
+		sc.config.Interval, sc.config.RoomTag, sc.config.WindowHours)
+	sc.check(time.Now())
+
+	ticker := time.NewTicker(sc.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("SensorCrossCheckService: Stopped")
+			return
+		case <-ticker.C:
+			sc.check(time.Now())
+		}
+	}
+}
+
+// check compares the complete hours of the window ending at the start of now's hour
+func (sc *SensorCrossCheckService) check(now time.Time) {
+	to := now.Truncate(time.Hour)
+	from := to.Add(-time.Duration(sc.config.WindowHours) * time.Hour)
+
+	devices, err := sc.db.ListDevices()
+	if err != nil {
+		log.Printf("SensorCrossCheckService: Error listing devices: %v", err)
+		return
+	}
+	rooms := make(map[string]string) // Device ID → room
+	for _, d := range devices {
+		if room := d.Tags[sc.config.RoomTag]; room != "" {
+			rooms[d.DeviceID] = room
+		}
+	}
+
+	for _, metric := range []string{"temperature", "humidity"} {
+		means, err := sc.db.GetHourlyMeans(metric, from, to)
+		if err != nil {
+			log.Printf("SensorCrossCheckService: Error reading hourly %s: %v", metric, err)
+			continue
+		}
+		divergences := roomDivergences(metric, means, rooms, sc.config.MinNeighbors)
+		if err := sc.db.SaveSensorDivergence(divergences, now); err != nil {
+			log.Printf("SensorCrossCheckService: Error saving %s divergence: %v", metric, err)
+		}
+		sc.judge(metric, divergences, now)
+	}
+}
+
+// threshold returns the largest normal divergence of a metric
+func (sc *SensorCrossCheckService) threshold(metric string) float64 {
+	if metric == "humidity" {
+		return sc.config.HumidityThreshold
+	}
+	return sc.config.TemperatureThreshold
+}
+
+// judge raises an alert for every device whose divergence persisted over the window
+func (sc *SensorCrossCheckService) judge(metric string, divergences []*models.SensorDivergence, now time.Time) {
+	byDevice := make(map[string][]*models.SensorDivergence)
+	for _, d := range divergences {
+		byDevice[d.DeviceID] = append(byDevice[d.DeviceID], d)
+	}
+
+	threshold := sc.threshold(metric)
+	for deviceID, hours := range byDevice {
+		if len(hours) < sc.config.MinHours {
+			continue
+		}
+		beyond := 0
+		var sum float64
+		for _, d := range hours {
+			if math.Abs(d.Divergence) > threshold {
+				beyond++
+			}
+			sum += d.Divergence
+		}
+		if float64(beyond) < sc.config.DivergentShare*float64(len(hours)) {
+			continue
+		}
+
+		mean := sum / float64(len(hours))
+		latest := hours[len(hours)-1]
+		log.Printf("SensorCrossCheckService: %s of %s diverges from room %s by %+.2f on average (%d of %d hours beyond %.1f)",
+			metric, deviceID, latest.Room, mean, beyond, len(hours), threshold)
+		if sc.alerts == nil {
+			continue
+		}
+		sc.alerts.Raise(&models.Alert{
+			Timestamp: now,
+			DeviceID:  deviceID,
+			Type:      models.AlertSensorDivergence,
+			Severity:  models.SeverityWarning,
+			Message: fmt.Sprintf("%s differs from the %d other devices in room %s by %+.2f on average, beyond %.1f in %d of %d hours; the sensor is likely failing",
+				metric, latest.Neighbors, latest.Room, mean, threshold, beyond, len(hours)),
+			Value: mean,
+		})
+	}
+}
+
+// roomDivergences compares every device's hourly means with the median of its room,
+// for the hours at least minNeighbors other devices reported in. Results are ordered
+// by device and hour.
+func roomDivergences(metric string, means map[string]map[int64]float64, rooms map[string]string, minNeighbors int) []*models.SensorDivergence {
+	members := make(map[string][]string) // Room → devices with readings
+	for deviceID := range means {
+		if room, ok := rooms[deviceID]; ok {
+			members[room] = append(members[room], deviceID)
+		}
+	}
+
+	var divergences []*models.SensorDivergence
+	for room, devices := range members {
+		if len(devices) <= minNeighbors {
+			continue
+		}
+		for _, deviceID := range devices {
+			for hour, value := range means[deviceID] {
+				values := []float64{value}
+				for _, other := range devices {
+					if v, ok := means[other][hour]; ok && other != deviceID {
+						values = append(values, v)
+					}
+				}
+				if len(values)-1 < minNeighbors {
+					continue
+				}
+				median := stats.Median(values)
+				divergences = append(divergences, &models.SensorDivergence{
+					Hour:       time.Unix(hour, 0).UTC(),
+					DeviceID:   deviceID,
+					Metric:     metric,
+					Room:       room,
+					Value:      value,
+					RoomMedian: median,
+					Divergence: value - median,
+					Neighbors:  len(values) - 1,
+				})
+			}
+		}
+	}
+
+	sort.Slice(divergences, func(i, j int) bool {
+		a, b := divergences[i], divergences[j]
+		if a.DeviceID != b.DeviceID {
+			return a.DeviceID < b.DeviceID
+		}
+		return a.Hour.Before(b.Hour)
+	})
+	return divergences
+}
@@ -2,31 +2,137 @@ package services
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"math"
+	"sync"
 	"time"
 
 	"iot-backend/internal/aggregator"
+	"iot-backend/internal/alerts"
+	"iot-backend/internal/clipstore"
 	"iot-backend/internal/database"
+	"iot-backend/internal/derived"
+	"iot-backend/internal/hotstore"
+	"iot-backend/internal/metrics"
 	"iot-backend/internal/models"
+	"iot-backend/internal/quality"
+	"iot-backend/internal/state"
 )
 
 // SensorService handles sensor data processing, persistence, and forwarding
 type SensorService struct {
 	db               *database.ClickHouseDB
 	inferenceService *InferenceService
+	state            *state.Store
 
 	// Input channels from MQTT subscribers
-	TempChan     chan *models.TemperatureReading
-	HumidityChan chan *models.HumidityReading
-	AudioChan    chan *models.AudioRecording
+	TempChan     <-chan *models.TemperatureReading
+	HumidityChan <-chan *models.HumidityReading
+	AudioChan    <-chan *models.AudioRecording
+
+	// High-priority input channel for safety-critical events
+	SafetyChan <-chan *models.SafetyEvent
+
+	// Optional input channel for motion and CO2 readings; set before Start
+	PresenceChan <-chan *models.PresenceReading
+
+	// Optional input channel for readings devices buffered while offline; set before Start
+	BackfillChan <-chan *models.BackfillBatch
+
+	// Maximum time a safety event may take from receipt to persistence
+	safetyMaxLatency time.Duration
+
+	// Optional consumer acting on safety events (e.g., window control); set before Start
+	SafetyHandler SafetyHandler
+
+	// Optional consumer of temperature readings (e.g., frost protection); set before Start
+	TemperatureHandler TemperatureHandler
+
+	// Optional alert manager for derived-indicator alerts; set before Start
+	Alerts *alerts.Manager
+
+	// Optional in-memory store of recent readings, fed with every saved reading that
+	// counts towards aggregates; set before Start
+	Hot *hotstore.Store
+
+	// Derived mold risk indicator (sustained high humidity in the risk temperature band)
+	moldRisk *moldRiskMonitor
+
+	// Rolling hourly percentile sound levels per device
+	hourlyLevels *hourlyLevels
+
+	// Sustained noise exposure alerts (nil when disabled)
+	noiseExposure *noiseExposureMonitor
+
+	// Per-device acoustic fingerprint for clip anomaly scoring
+	audioAnomaly *derived.AudioAnomalyDetector
+
+	// Per-device quiet baseline so loudness is comparable across microphones
+	noiseFloor *derived.NoiseFloorCalibrator
+
+	// Occupancy probability fused from sound, motion, and CO2
+	occupancy *occupancyMonitor
+
+	// Latest outdoor temperatures, which select seasonal model variants
+	outdoor *derived.OutdoorTemperatures
+
+	// Last registry write per device (readings arrive far more often than last_seen needs updating)
+	registryMu      sync.Mutex
+	registryTouched map[string]time.Time
 
 	// Audio processor for volume extraction
 	audioProcessor AudioProcessor
+	audioDownmix   aggregator.DownmixStrategy // How multi-channel clips are reduced to mono before processing
+	agc            *agcCompensation           // nil when AGC gains aren't compensated
+
+	// Recent clip hashes per device; exact retransmissions are dropped
+	audioDedup *audioDedup
+
+	// Optional object store for deferred feature extraction: clips are persisted on
+	// receipt and extracted by background workers; set before Start
+	ClipStore        clipstore.Store
+	extractionConfig AudioExtractionConfig
+	extraction       *audioExtraction // Created by Start when ClipStore is set
+
+	// Per-device temperature units; readings are converted to Celsius before anything else
+	temperatureUnits *temperatureUnits
+
+	// Data-quality scorer applied to every reading before persistence
+	qualityScorer *quality.Scorer
+
+	// Storage of replayed offline buffers, apart from the live path
+	backfill *backfill
+
+	// Spike rejection filters; rejected readings go to the quarantine table
+	tempSpikeFilter     *quality.HampelFilter
+	humiditySpikeFilter *quality.HampelFilter
+	suppressOutliers    bool // Drop rejected readings from the main tables entirely
+
+	// Per-device worker shards (parallel across devices, ordered within a device)
+	tempShards     *deviceShards[*models.TemperatureReading]
+	humidityShards *deviceShards[*models.HumidityReading]
+	audioShards    *deviceShards[*models.AudioRecording]
 }
 
+// registryTouchInterval is the minimum time between device registry writes per device
+const registryTouchInterval = time.Minute
+
 // AudioProcessor interface for extracting volume from audio
 type AudioProcessor interface {
 	ExtractVolume(audioData []byte, sampleRate int) float64
+	FrameLevels(audioData []byte, sampleRate int) []float64 // Per-frame levels (dB) for percentile statistics
+	ClipFeatures(audioData []byte, sampleRate int) aggregator.ClipFeatures
+}
+
+// SafetyHandler interface for reacting to safety-critical events
+type SafetyHandler interface {
+	HandleSafetyEvent(event *models.SafetyEvent)
+}
+
+// TemperatureHandler interface for reacting to temperature readings
+type TemperatureHandler interface {
+	HandleTemperature(reading *models.TemperatureReading)
 }
 
 // defaultAudioProcessor implements AudioProcessor using the aggregator package
@@ -36,11 +142,49 @@ func (p *defaultAudioProcessor) ExtractVolume(audioData []byte, sampleRate int)
 	return aggregator.ExtractSoundVolume(audioData, sampleRate)
 }
 
+func (p *defaultAudioProcessor) FrameLevels(audioData []byte, sampleRate int) []float64 {
+	return aggregator.FrameLevels(audioData, sampleRate)
+}
+
+func (p *defaultAudioProcessor) ClipFeatures(audioData []byte, sampleRate int) aggregator.ClipFeatures {
+	return aggregator.ExtractClipFeatures(audioData, sampleRate)
+}
+
 // SensorServiceConfig holds configuration for sensor service
 type SensorServiceConfig struct {
 	TempChannelSize     int
 	HumidityChannelSize int
 	AudioChannelSize    int
+	SafetyChannelSize   int
+	PresenceChannelSize int
+	BackfillChannelSize int
+	SafetyMaxLatencyMs  int // Processing budget for safety events
+	Quality             quality.Config
+	AudioDownmix        aggregator.DownmixStrategy // Reduction of multi-channel clips to mono (average, loudest, first)
+	AudioDedupWindow    time.Duration              // Identical clips from a device within this window are dropped (0 disables)
+	AGCCompensation     bool                       // Subtract the microphone AGC gain (reported per clip or in the registry) from levels
+	AudioExtraction     AudioExtractionConfig      // Worker pool used when a ClipStore is set
+	BackfillMaxAge      time.Duration              // Backfilled readings older than this are rejected
+	BackfillDedupWindow time.Duration              // Identical backfill batches from a device within this window are dropped (0 disables)
+
+	// Spike rejection (Hampel filter)
+	TemperatureSpikeFilter quality.HampelConfig
+	HumiditySpikeFilter    quality.HampelConfig
+	SuppressOutliers       bool // If false, rejected readings are kept but flagged bad
+
+	// Per-device sharding
+	WorkersPerSensor int // Worker goroutines per sensor type
+	WorkerQueueSize  int // Queue capacity per worker
+
+	// Derived indicators
+	MoldRisk     MoldRiskConfig
+	AudioAnomaly derived.AudioAnomalyConfig
+	NoiseFloor   derived.NoiseFloorConfig
+	Occupancy    OccupancyConfig
+	Noise        NoiseExposureConfig
+
+	// Outdoor temperatures older than this don't select model variants
+	OutdoorMaxAge time.Duration
 }
 
 // DefaultSensorServiceConfig returns default configuration
@@ -49,6 +193,31 @@ func DefaultSensorServiceConfig() SensorServiceConfig {
 		TempChannelSize:     100,
 		HumidityChannelSize: 100,
 		AudioChannelSize:    50, // Smaller since audio is larger
+		SafetyChannelSize:   20,
+		PresenceChannelSize: 50,
+		BackfillChannelSize: 10, // Batches hold many readings
+		SafetyMaxLatencyMs:  500,
+		Quality:             quality.DefaultConfig(),
+		AudioDownmix:        aggregator.DownmixAverage,
+		AudioDedupWindow:    5 * time.Minute,
+		AudioExtraction:     DefaultAudioExtractionConfig(),
+		BackfillMaxAge:      7 * 24 * time.Hour,
+		BackfillDedupWindow: time.Hour,
+
+		TemperatureSpikeFilter: quality.DefaultTemperatureHampelConfig(),
+		HumiditySpikeFilter:    quality.DefaultHumidityHampelConfig(),
+		SuppressOutliers:       false,
+
+		WorkersPerSensor: 4,
+		WorkerQueueSize:  20,
+
+		MoldRisk:     DefaultMoldRiskConfig(),
+		AudioAnomaly: derived.DefaultAudioAnomalyConfig(),
+		NoiseFloor:   derived.DefaultNoiseFloorConfig(),
+		Occupancy:    DefaultOccupancyConfig(),
+		Noise:        DefaultNoiseExposureConfig(),
+
+		OutdoorMaxAge: time.Hour,
 	}
 }
 
@@ -56,16 +225,50 @@ func DefaultSensorServiceConfig() SensorServiceConfig {
 func NewSensorService(
 	db *database.ClickHouseDB,
 	inferenceService *InferenceService,
+	store *state.Store,
 	config SensorServiceConfig,
 ) *SensorService {
-	return &SensorService{
+	s := &SensorService{
 		db:               db,
 		inferenceService: inferenceService,
+		state:            store,
 		TempChan:         make(chan *models.TemperatureReading, config.TempChannelSize),
 		HumidityChan:     make(chan *models.HumidityReading, config.HumidityChannelSize),
 		AudioChan:        make(chan *models.AudioRecording, config.AudioChannelSize),
+		SafetyChan:       make(chan *models.SafetyEvent, config.SafetyChannelSize),
+		safetyMaxLatency: time.Duration(config.SafetyMaxLatencyMs) * time.Millisecond,
 		audioProcessor:   &defaultAudioProcessor{},
+		audioDownmix:     config.AudioDownmix,
+		audioDedup:       newAudioDedup(config.AudioDedupWindow),
+		extractionConfig: config.AudioExtraction,
+		qualityScorer:    quality.NewScorer(config.Quality),
+		backfill:         newBackfill(config),
+
+		tempSpikeFilter:     quality.NewHampelFilter(config.TemperatureSpikeFilter),
+		humiditySpikeFilter: quality.NewHampelFilter(config.HumiditySpikeFilter),
+		suppressOutliers:    config.SuppressOutliers,
+		moldRisk:            newMoldRiskMonitor(db, config.MoldRisk),
+		hourlyLevels:        newHourlyLevels(db),
+		audioAnomaly:        derived.NewAudioAnomalyDetector(config.AudioAnomaly),
+		noiseFloor:          derived.NewNoiseFloorCalibrator(config.NoiseFloor),
+		occupancy:           newOccupancyMonitor(db, config.Occupancy),
+		outdoor:             derived.NewOutdoorTemperatures(config.OutdoorMaxAge),
+		registryTouched:     make(map[string]time.Time),
 	}
+
+	s.temperatureUnits = newTemperatureUnits(s.deviceConfig)
+	if config.Noise.Level > 0 {
+		s.noiseExposure = newNoiseExposureMonitor(config.Noise)
+	}
+	if config.AGCCompensation {
+		s.agc = newAGCCompensation(s.deviceConfig)
+	}
+
+	s.tempShards = newDeviceShards(config.WorkersPerSensor, config.WorkerQueueSize, s.processTemperature)
+	s.humidityShards = newDeviceShards(config.WorkersPerSensor, config.WorkerQueueSize, s.processHumidity)
+	s.audioShards = newDeviceShards(config.WorkersPerSensor, config.WorkerQueueSize, s.processAudio)
+
+	return s
 }
 
 // Start begins processing sensor data from channels
@@ -74,9 +277,29 @@ func (s *SensorService) Start(ctx context.Context) {
 	log.Println("SensorService: Starting...")
 
 	// Start goroutines for each sensor type
+	// Safety events get a dedicated loop so they never queue behind audio processing
+	go s.processSafetyLoop(ctx)
+
+	// Readings are dispatched to per-device shards so one slow write doesn't stall every device
+	s.tempShards.run(ctx)
+	s.humidityShards.run(ctx)
+	s.audioShards.run(ctx)
+	if s.ClipStore != nil {
+		s.extraction = newAudioExtraction(s.ClipStore, s.extractionConfig, s.extractAudio)
+		go s.extraction.run(ctx)
+		log.Printf("SensorService: Deferring audio feature extraction to %d workers", s.extractionConfig.Workers)
+	}
+
 	go s.processTemperatureLoop(ctx)
 	go s.processHumidityLoop(ctx)
 	go s.processAudioLoop(ctx)
+	if s.PresenceChan != nil {
+		go s.processPresenceLoop(ctx)
+	}
+	if s.BackfillChan != nil {
+		go s.processBackfillLoop(ctx)
+	}
+	go s.hourlyLevels.run(ctx)
 
 	log.Println("SensorService: All processing loops started")
 
@@ -84,14 +307,25 @@ func (s *SensorService) Start(ctx context.Context) {
 	<-ctx.Done()
 	log.Println("SensorService: Shutting down...")
 
-	// Close all channels
-	close(s.TempChan)
-	close(s.HumidityChan)
-	close(s.AudioChan)
-
+	// Input channels belong to the event bus, which closes them on shutdown
 	log.Println("SensorService: Shutdown complete")
 }
 
+// processSafetyLoop continuously processes safety-critical events
+func (s *SensorService) processSafetyLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-s.SafetyChan:
+			if !ok {
+				return
+			}
+			s.processSafetyEvent(event)
+		}
+	}
+}
+
 // processTemperatureLoop continuously processes temperature readings
 func (s *SensorService) processTemperatureLoop(ctx context.Context) {
 	for {
@@ -102,7 +336,7 @@ func (s *SensorService) processTemperatureLoop(ctx context.Context) {
 			if !ok {
 				return
 			}
-			s.processTemperature(reading)
+			s.tempShards.dispatch(ctx, reading.DeviceID, reading)
 		}
 	}
 }
@@ -117,7 +351,23 @@ func (s *SensorService) processHumidityLoop(ctx context.Context) {
 			if !ok {
 				return
 			}
-			s.processHumidity(reading)
+			s.humidityShards.dispatch(ctx, reading.DeviceID, reading)
+		}
+	}
+}
+
+// processPresenceLoop feeds motion and CO2 readings to the occupancy estimator
+func (s *SensorService) processPresenceLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case reading, ok := <-s.PresenceChan:
+			if !ok {
+				return
+			}
+			s.occupancy.observePresence(reading)
+			s.registerDevice(reading.DeviceID)
 		}
 	}
 }
@@ -132,13 +382,30 @@ func (s *SensorService) processAudioLoop(ctx context.Context) {
 			if !ok {
 				return
 			}
-			s.processAudio(recording)
+			s.audioShards.dispatch(ctx, recording.DeviceID, recording)
 		}
 	}
 }
 
 // processTemperature handles a single temperature reading
 func (s *SensorService) processTemperature(reading *models.TemperatureReading) {
+	// Quality and spike checks assume Celsius
+	s.temperatureUnits.normalize(reading)
+
+	// Score data quality (bad readings are stored but excluded from aggregates)
+	result := s.qualityScorer.ScoreTemperature(reading)
+	result = s.screenSpike("temperature", reading.DeviceID, reading.Timestamp, reading.Value, s.tempSpikeFilter, result)
+	reading.QualityScore, reading.QualityFlag = result.Score, result.Flag
+	logQuality("temperature", reading.DeviceID, result)
+	if result.Flag == models.QualityBad && s.suppressOutliers {
+		return
+	}
+
+	// A spike must not close a window, but frost protection shouldn't wait on ClickHouse
+	if s.TemperatureHandler != nil && result.Flag != models.QualityBad {
+		s.TemperatureHandler.HandleTemperature(reading)
+	}
+
 	// Save to database
 	if err := s.db.SaveTemperature(reading); err != nil {
 		log.Printf("Error saving temperature: %v", err)
@@ -146,13 +413,59 @@ func (s *SensorService) processTemperature(reading *models.TemperatureReading) {
 	}
 
 	log.Printf("Saved temperature: device=%s, value=%.2f°C", reading.DeviceID, reading.Value)
+	s.state.UpdateTemperature(reading.DeviceID, reading.Value, reading.Timestamp)
+	s.addHot(reading.DeviceID, "temperature", reading.Timestamp, reading.Value, reading.QualityFlag)
+	s.observeMoldRisk(s.moldRisk.tracker.UpdateTemperature(reading.DeviceID, reading.Value, reading.Timestamp))
 
 	// Auto-register device
 	s.registerDevice(reading.DeviceID)
 }
 
+// addHot keeps a saved reading in the hot store; bad readings are excluded from
+// aggregates in ClickHouse, so they are left out here too
+func (s *SensorService) addHot(deviceID, metric string, timestamp time.Time, value float64, flag string) {
+	if s.Hot != nil && flag != models.QualityBad {
+		s.Hot.Add(deviceID, metric, timestamp, value)
+	}
+}
+
+// processSafetyEvent handles a single safety event and checks its latency budget
+func (s *SensorService) processSafetyEvent(event *models.SafetyEvent) {
+	// Act first, persist second: the window must not wait on ClickHouse
+	if s.SafetyHandler != nil {
+		s.SafetyHandler.HandleSafetyEvent(event)
+	}
+	if event.EventType == models.SafetyOutdoorTemperature {
+		s.outdoor.Update(event.DeviceID, event.Value, event.Timestamp)
+	}
+
+	latency := time.Since(event.Timestamp)
+
+	if err := s.db.SaveSafetyEvent(event, latency); err != nil {
+		log.Printf("Error saving safety event: %v", err)
+		return
+	}
+
+	if s.safetyMaxLatency > 0 && latency > s.safetyMaxLatency {
+		log.Printf("Warning: Safety event %s from %s exceeded latency budget (%v > %v)",
+			event.EventType, event.DeviceID, latency, s.safetyMaxLatency)
+	}
+
+	log.Printf("Saved safety event: device=%s, type=%s, value=%.2f, latency=%v",
+		event.DeviceID, event.EventType, event.Value, latency)
+}
+
 // processHumidity handles a single humidity reading
 func (s *SensorService) processHumidity(reading *models.HumidityReading) {
+	// Score data quality (bad readings are stored but excluded from aggregates)
+	result := s.qualityScorer.ScoreHumidity(reading)
+	result = s.screenSpike("humidity", reading.DeviceID, reading.Timestamp, reading.Value, s.humiditySpikeFilter, result)
+	reading.QualityScore, reading.QualityFlag = result.Score, result.Flag
+	logQuality("humidity", reading.DeviceID, result)
+	if result.Flag == models.QualityBad && s.suppressOutliers {
+		return
+	}
+
 	// Save to database
 	if err := s.db.SaveHumidity(reading); err != nil {
 		log.Printf("Error saving humidity: %v", err)
@@ -160,6 +473,9 @@ func (s *SensorService) processHumidity(reading *models.HumidityReading) {
 	}
 
 	log.Printf("Saved humidity: device=%s, value=%.2f%%", reading.DeviceID, reading.Value)
+	s.state.UpdateHumidity(reading.DeviceID, reading.Value, reading.Timestamp)
+	s.addHot(reading.DeviceID, "humidity", reading.Timestamp, reading.Value, reading.QualityFlag)
+	s.observeMoldRisk(s.moldRisk.tracker.UpdateHumidity(reading.DeviceID, reading.Value, reading.Timestamp))
 
 	// Auto-register device
 	s.registerDevice(reading.DeviceID)
@@ -167,46 +483,276 @@ func (s *SensorService) processHumidity(reading *models.HumidityReading) {
 
 // processAudio handles a single audio recording
 func (s *SensorService) processAudio(recording *models.AudioRecording) {
+	// Retransmitted clips would otherwise be stored and trigger inference twice
+	audioHash := aggregator.ComputeAudioHash(recording.Data)
+	if s.audioDedup.duplicate(recording.DeviceID, audioHash, time.Now()) {
+		log.Printf("Dropped duplicate audio: device=%s, hash=%s", recording.DeviceID, audioHash[:8])
+		metrics.Default.Counter("audio_duplicates_dropped").Inc()
+		return
+	}
+
+	// In deferred mode the clip only has to reach the store before the next one is accepted
+	if s.extraction != nil && s.extraction.submit(recording, audioHash) {
+		s.registerDevice(recording.DeviceID)
+		return
+	}
+	if !s.extractAudio(recording, audioHash) {
+		s.audioDedup.forget(recording.DeviceID, audioHash)
+	}
+}
+
+// extractAudio computes a clip's levels, quality, and features, saves its metadata,
+// and updates the derived indicators. It returns false when the metadata couldn't be saved.
+func (s *SensorService) extractAudio(recording *models.AudioRecording, audioHash string) bool {
+	// Levels and features are defined on mono audio; mic arrays send interleaved frames
+	mono := recording.Data
+	if recording.Channels > 1 {
+		recording.ChannelVolumes = aggregator.ChannelLevels(recording.Data, recording.Channels)
+		mono = aggregator.Downmix(recording.Data, recording.Channels, s.audioDownmix)
+		log.Printf("Downmixed audio: device=%s, channels=%d, strategy=%s, channel volumes=%.1f dB",
+			recording.DeviceID, recording.Channels, s.audioDownmix, recording.ChannelVolumes)
+	}
+
 	// Extract sound volume from audio data
-	volume := s.audioProcessor.ExtractVolume(recording.Data, recording.SampleRate)
+	volume := s.audioProcessor.ExtractVolume(mono, recording.SampleRate)
 
-	log.Printf("Extracted volume: device=%s, volume=%.2f dB, duration=%.2fs",
-		recording.DeviceID, volume, recording.Duration)
+	// Percentile levels describe sustained exposure better than a single RMS value
+	levels := s.audioProcessor.FrameLevels(mono, recording.SampleRate)
+	if s.agc != nil {
+		volume = s.agc.compensate(recording, volume, levels)
+	}
 
-	// Compute audio hash for reference
-	audioHash := aggregator.ComputeAudioHash(recording.Data)
+	log.Printf("Extracted volume: device=%s, volume=%.2f dB (AGC %+.1f dB), duration=%.2fs",
+		recording.DeviceID, volume, -recording.AGCCompensation, recording.Duration)
+
+	stats := aggregator.ComputeLevelStats(levels)
+	recording.L10, recording.L50, recording.L90 = stats.L10, stats.L50, stats.L90
+
+	// Score data quality
+	result := s.qualityScorer.ScoreAudio(recording)
+	recording.QualityScore, recording.QualityFlag = result.Score, result.Flag
+	logQuality("audio", recording.DeviceID, result)
+	s.recordAudioFormat(recording)
+
+	// Clipping is tracked per clip, so a device's rate over time shows mics set too hot
+	recording.ClippingRatio = s.qualityScorer.AnalyzeAudio(recording).ClippingRatio
+	if recording.ClippingRatio > 0 {
+		metrics.Default.Counter("audio_clipped_clips").Inc()
+	}
+
+	// Score the clip against the device's acoustic fingerprint (unusable clips would skew the baseline)
+	features := s.audioProcessor.ClipFeatures(mono, recording.SampleRate)
+	recording.Features = features.Map()
+	if recording.QualityFlag != models.QualityBad {
+		anomaly := s.audioAnomaly.Score(recording.DeviceID, recording.Features)
+		recording.AnomalyScore = anomaly.Score
+		s.reportAudioAnomaly(recording, anomaly)
+		if s.calibrateNoiseFloor(recording, volume, levels) {
+			// Loudness only says something about occupancy relative to the room's quiet baseline
+			s.occupancy.observeSound(recording.DeviceID, recording.RelativeVolume, recording.Timestamp)
+		}
+	}
 
 	// Save audio metadata to database (not the raw data)
 	if err := s.db.SaveAudio(recording, audioHash, volume); err != nil {
 		log.Printf("Error saving audio metadata: %v", err)
-		return
+		return false
 	}
 
-	log.Printf("Saved audio metadata: device=%s, hash=%s, volume=%.2f dB", recording.DeviceID, audioHash[:8], volume)
+	log.Printf("Saved audio metadata: device=%s, hash=%s, volume=%.2f dB (%+.1f dB above floor), L10/L50/L90=%.1f/%.1f/%.1f dB",
+		recording.DeviceID, audioHash[:8], volume, recording.RelativeVolume, recording.L10, recording.L50, recording.L90)
+	s.state.UpdateSoundVolume(recording.DeviceID, volume, recording.Timestamp)
+	s.addHot(recording.DeviceID, "sound_volume", recording.Timestamp, volume, recording.QualityFlag)
+	if recording.QualityFlag != models.QualityBad {
+		s.hourlyLevels.add(recording.DeviceID, recording.Timestamp, levels)
+		if s.noiseExposure != nil {
+			update := s.noiseExposure.observe(recording.DeviceID, recording.Timestamp, stats)
+			s.noiseExposure.report(recording, update, s.Alerts, s.SafetyHandler)
+		}
+	}
 
 	// Auto-register device
 	s.registerDevice(recording.DeviceID)
+	return true
 }
 
-// registerDevice auto-registers a device on first message
-func (s *SensorService) registerDevice(deviceID string) {
-	device := &models.Device{
-		DeviceID:     deviceID,
-		Name:         deviceID,
-		Location:     "Unknown",
-		RegisteredAt: time.Now(),
-		LastSeen:     time.Now(),
-		IsActive:     true,
-		Config:       make(map[string]interface{}),
+// recordAudioFormat stores clips whose declared sample rate or duration disagrees with their data
+func (s *SensorService) recordAudioFormat(recording *models.AudioRecording) {
+	check := s.qualityScorer.CheckAudioFormat(recording)
+	if check.OK() {
+		return
 	}
 
-	// Best effort - don't fail if registration fails
-	if err := s.db.UpsertDevice(device); err != nil {
-		log.Printf("Error registering device %s: %v", deviceID, err)
+	metrics.Default.Counter("audio_format_mismatches").Inc()
+	log.Printf("Audio format mismatch: device=%s, issues=%v, declared=%.2fs @ %dHz, actual=%.2fs (%d bytes)",
+		recording.DeviceID, check.Issues, recording.Duration, recording.SampleRate, check.ActualDuration, len(recording.Data))
+
+	mismatch := &models.AudioFormatMismatch{
+		Timestamp:        recording.Timestamp,
+		DeviceID:         recording.DeviceID,
+		Issues:           check.Issues,
+		SampleRate:       recording.SampleRate,
+		DeclaredDuration: recording.Duration,
+		ActualDuration:   check.ActualDuration,
+		Bytes:            len(recording.Data),
+	}
+	if err := s.db.SaveAudioFormatMismatch(mismatch); err != nil {
+		log.Printf("Error saving audio format mismatch: %v", err)
 	}
+}
+
+// reportAudioAnomaly logs anomalous clips and alerts once an anomaly is sustained
+func (s *SensorService) reportAudioAnomaly(recording *models.AudioRecording, anomaly derived.AnomalyResult) {
+	if !anomaly.Anomalous {
+		return
+	}
+	log.Printf("Audio anomaly: device=%s, score=%.2f, streak=%d", recording.DeviceID, anomaly.Score, anomaly.Streak)
+
+	if anomaly.Sustained && s.Alerts != nil {
+		s.Alerts.Raise(&models.Alert{
+			Timestamp: recording.Timestamp,
+			DeviceID:  recording.DeviceID,
+			Type:      models.AlertAudioAnomaly,
+			Severity:  models.SeverityWarning,
+			Message: fmt.Sprintf("Unusual sound for %d consecutive clips (score %.1f, %.1f dB, centroid %.0f Hz)",
+				anomaly.Streak, anomaly.Score, recording.Features["volume_db"], recording.Features["spectral_centroid"]),
+			Value: anomaly.Score,
+		})
+	}
+}
 
+// calibrateNoiseFloor updates the device's noise floor and sets the clip's loudness relative to it.
+// The first clip from a device restores the floor from stored hourly levels so a restart
+// doesn't repeat the calibration window. Returns false while no floor is known.
+func (s *SensorService) calibrateNoiseFloor(recording *models.AudioRecording, volume float64, levels []float64) bool {
+	if !s.noiseFloor.Known(recording.DeviceID) {
+		hourly, err := s.db.GetHourlyBackgroundLevels(recording.DeviceID, s.noiseFloor.CalibrationHours())
+		if err != nil {
+			log.Printf("Error loading noise floor history for %s: %v", recording.DeviceID, err)
+		}
+		s.noiseFloor.Seed(recording.DeviceID, hourly)
+	}
+
+	floor, ok := s.noiseFloor.Add(recording.DeviceID, recording.Timestamp, levels)
+	if !ok {
+		return false
+	}
+	recording.NoiseFloor = floor.Level
+	recording.RelativeVolume = floor.Relative(volume)
+	recording.NoiseFloorCalibrated = floor.Calibrated
+	return true
+}
+
+// screenSpike runs the spike filter on a scalar reading and quarantines rejected values.
+// Readings already rejected by the quality scorer skip the filter so they don't enter its window.
+func (s *SensorService) screenSpike(metric, deviceID string, timestamp time.Time, value float64, filter *quality.HampelFilter, result quality.Result) quality.Result {
+	expected := math.NaN()
+	if result.Flag != models.QualityBad {
+		spike, median := filter.Check(deviceID, value)
+		if !spike {
+			return result
+		}
+		result = quality.SpikeResult()
+		expected = median
+	}
+
+	quarantined := &models.QuarantinedReading{
+		Timestamp: timestamp,
+		DeviceID:  deviceID,
+		Metric:    metric,
+		Value:     value,
+		Expected:  expected,
+		Reason:    result.Reason,
+	}
+	if err := s.db.SaveQuarantinedReading(quarantined); err != nil {
+		log.Printf("Error saving quarantined %s reading: %v", metric, err)
+	}
+
+	return result
+}
+
+// logQuality reports readings that failed a quality check
+func logQuality(metric, deviceID string, result quality.Result) {
+	if result.Flag != models.QualityGood {
+		log.Printf("Quality: %s reading from %s flagged %s (score=%.2f, reason=%s)",
+			metric, deviceID, result.Flag, result.Score, result.Reason)
+	}
+}
+
+// observeMoldRisk stores and alerts on an updated mold risk indicator
+func (s *SensorService) observeMoldRisk(risk models.MoldRisk, ok bool) {
+	s.moldRisk.observe(risk, ok, s.Alerts)
+}
+
+// MoldRisk returns the tracker for the derived mold risk indicator
+func (s *SensorService) MoldRisk() *derived.MoldRiskTracker {
+	return s.moldRisk.tracker
+}
+
+// NoiseFloors returns the calibrator learning each device's noise floor
+func (s *SensorService) NoiseFloors() *derived.NoiseFloorCalibrator {
+	return s.noiseFloor
+}
+
+// Occupancy returns the occupancy estimator (used as an inference feature)
+func (s *SensorService) Occupancy() *derived.OccupancyEstimator {
+	return s.occupancy.estimator
+}
+
+// OutdoorTemperatures returns the latest outdoor temperatures (used to select model variants)
+func (s *SensorService) OutdoorTemperatures() *derived.OutdoorTemperatures {
+	return s.outdoor
+}
+
+// deviceConfig returns a device's registry config (nil if unknown or unreadable)
+func (s *SensorService) deviceConfig(deviceID string) map[string]interface{} {
+	device, err := s.db.GetDevice(deviceID)
+	if err != nil {
+		log.Printf("SensorService: Error reading registry for %s: %v", deviceID, err)
+		return nil
+	}
+	if device == nil {
+		return nil
+	}
+	return device.Config
+}
+
+// registerDevice auto-registers a device on first message and refreshes its last-seen time.
+// Registry writes are throttled per device, and existing name, location, and config are kept.
+func (s *SensorService) registerDevice(deviceID string) {
 	// Register device with inference service for tracking
 	if s.inferenceService != nil {
 		s.inferenceService.RegisterDevice(deviceID)
 	}
+
+	now := time.Now()
+	s.registryMu.Lock()
+	if last, ok := s.registryTouched[deviceID]; ok && now.Sub(last) < registryTouchInterval {
+		s.registryMu.Unlock()
+		return
+	}
+	s.registryTouched[deviceID] = now
+	s.registryMu.Unlock()
+
+	device, err := s.db.GetDevice(deviceID)
+	if err != nil {
+		log.Printf("Error looking up device %s: %v", deviceID, err)
+		return
+	}
+	if device == nil {
+		device = &models.Device{
+			DeviceID:     deviceID,
+			Name:         deviceID,
+			Location:     "Unknown",
+			RegisteredAt: now,
+			Config:       make(map[string]interface{}),
+		}
+	}
+	device.LastSeen = now
+	device.IsActive = true
+
+	// Best effort - don't fail if registration fails
+	if err := s.db.UpsertDevice(device); err != nil {
+		log.Printf("Error registering device %s: %v", deviceID, err)
+	}
 }
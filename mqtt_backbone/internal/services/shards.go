@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"hash/fnv"
+)
+
+// deviceShards fans work out to a fixed set of workers keyed by device ID.
+// Every item for a device lands on the same worker queue, so per-device ordering
+// is preserved while different devices are processed in parallel.
+type deviceShards[T any] struct {
+	queues []chan T
+	handle func(T)
+}
+
+// newDeviceShards creates n worker queues of the given size. Workers are started by run.
+func newDeviceShards[T any](n, queueSize int, handle func(T)) *deviceShards[T] {
+	if n < 1 {
+		n = 1
+	}
+	queues := make([]chan T, n)
+	for i := range queues {
+		queues[i] = make(chan T, queueSize)
+	}
+	return &deviceShards[T]{queues: queues, handle: handle}
+}
+
+// run starts one goroutine per shard; each exits when context is cancelled
+func (d *deviceShards[T]) run(ctx context.Context) {
+	for _, q := range d.queues {
+		go func(q chan T) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case item := <-q:
+					d.handle(item)
+				}
+			}
+		}(q)
+	}
+}
+
+// dispatch queues an item on its device's shard, blocking while that shard is full
+// so backpressure reaches the input channel instead of dropping readings
+func (d *deviceShards[T]) dispatch(ctx context.Context, deviceID string, item T) {
+	select {
+	case d.queues[shardIndex(deviceID, len(d.queues))] <- item:
+	case <-ctx.Done():
+	}
+}
+
+// shardIndex maps a device ID to a shard using FNV-1a
+func shardIndex(deviceID string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(deviceID))
+	return int(h.Sum32() % uint32(n))
+}
+
+// tryDispatch queues an item on its device's shard without blocking; it returns
+// false when that shard is full
+func (d *deviceShards[T]) tryDispatch(deviceID string, item T) bool {
+	select {
+	case d.queues[shardIndex(deviceID, len(d.queues))] <- item:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"iot-backend/internal/database"
+)
+
+// StorageReportConfig holds settings for the periodic storage usage log
+type StorageReportConfig struct {
+	Interval   time.Duration // How often the summary is logged
+	Days       int           // Days ingestion rates are averaged over
+	TopDevices int           // Devices with the highest ingestion listed
+}
+
+// DefaultStorageReportConfig returns default storage report settings
+func DefaultStorageReportConfig() StorageReportConfig {
+	return StorageReportConfig{
+		Interval:   24 * time.Hour,
+		Days:       7,
+		TopDevices: 5,
+	}
+}
+
+// StorageReportService logs table sizes and ingestion rates per table and for the
+// busiest devices, so storage growth is visible before the disk fills up
+type StorageReportService struct {
+	db     *database.ClickHouseDB
+	config StorageReportConfig
+}
+
+// NewStorageReportService creates a new storage report service
+func NewStorageReportService(db *database.ClickHouseDB, config StorageReportConfig) *StorageReportService {
+	return &StorageReportService{db: db, config: config}
+}
+
+// Start logs a summary now and then every interval until context is cancelled
+func (ss *StorageReportService) Start(ctx context.Context) {
+	log.Printf("StorageReportService: Starting (every %v, averaged over %d days)", ss.config.Interval, ss.config.Days)
+
+	ss.report()
+
+	ticker := time.NewTicker(ss.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("StorageReportService: Stopped")
+			return
+		case <-ticker.C:
+			ss.report()
+		}
+	}
+}
+
+// report logs the totals, every table with data, and the busiest devices
+func (ss *StorageReportService) report() {
+	report, err := ss.db.GetStorageReport(ss.config.Days, ss.config.TopDevices)
+	if err != nil {
+		log.Printf("StorageReportService: Error reading storage report: %v", err)
+		return
+	}
+
+	log.Printf("StorageReportService: %d rows, %s on disk; ingesting %.0f rows/day, %s/day",
+		report.Rows, formatBytes(float64(report.BytesOnDisk)), report.RowsPerDay, formatBytes(report.BytesPerDay))
+	for _, t := range report.Tables {
+		if t.Rows == 0 {
+			continue
+		}
+		log.Printf("StorageReportService:   %-32s %12d rows %10s  %10.0f rows/day %10s/day",
+			t.Table, t.Rows, formatBytes(float64(t.BytesOnDisk)), t.RowsPerDay, formatBytes(t.BytesPerDay))
+	}
+	for _, d := range report.Devices {
+		log.Printf("StorageReportService:   device %-25s %10.0f rows/day %10s/day", d.DeviceID, d.RowsPerDay, formatBytes(d.BytesPerDay))
+	}
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "1.5 GiB"
+func formatBytes(b float64) string {
+	if b < 1024 {
+		return fmt.Sprintf("%.0f B", b)
+	}
+	unit := 0
+	for b /= 1024; b >= 1024 && unit < 5; b /= 1024 {
+		unit++
+	}
+	return fmt.Sprintf("%.1f %ciB", b, "KMGTPE"[unit])
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+// temperatureUnitKey is the registry config key naming the unit a device reports
+// temperatures in ("C" or "F"; absent = Celsius)
+const temperatureUnitKey = "temperature_unit"
+
+// temperatureUnitTTL is how long a device's registry unit is cached
+const temperatureUnitTTL = 5 * time.Minute
+
+// cachedUnit is a device's unit as last read from the registry
+type cachedUnit struct {
+	unit     string
+	loadedAt time.Time
+}
+
+// temperatureUnits resolves the unit of readings whose payload doesn't name one.
+// Safe for concurrent use.
+type temperatureUnits struct {
+	lookup func(deviceID string) map[string]interface{} // Registry config of a device (nil if unknown)
+
+	mu    sync.Mutex
+	units map[string]cachedUnit
+}
+
+func newTemperatureUnits(lookup func(deviceID string) map[string]interface{}) *temperatureUnits {
+	return &temperatureUnits{lookup: lookup, units: make(map[string]cachedUnit)}
+}
+
+// unit returns the configured unit of a device
+func (u *temperatureUnits) unit(deviceID string, now time.Time) string {
+	u.mu.Lock()
+	cached, ok := u.units[deviceID]
+	u.mu.Unlock()
+	if ok && now.Sub(cached.loadedAt) < temperatureUnitTTL {
+		return cached.unit
+	}
+
+	// Registry reads happen outside the lock; a slow registry must not stall other devices
+	unit := models.UnitCelsius
+	if raw, ok := u.lookup(deviceID)[temperatureUnitKey].(string); ok {
+		parsed, err := models.ParseTemperatureUnit(raw)
+		if err != nil {
+			log.Printf("Warning: device %s has an invalid %s, assuming Celsius: %v", deviceID, temperatureUnitKey, err)
+		} else {
+			unit = parsed
+		}
+	}
+
+	u.mu.Lock()
+	u.units[deviceID] = cachedUnit{unit: unit, loadedAt: now}
+	u.mu.Unlock()
+	return unit
+}
+
+// normalize converts a reading to Celsius, using the payload's unit or else the device's
+func (u *temperatureUnits) normalize(reading *models.TemperatureReading) {
+	unit := reading.Unit
+	if unit == "" {
+		unit = u.unit(reading.DeviceID, time.Now())
+	}
+	if unit == models.UnitFahrenheit {
+		reading.Value = models.FahrenheitToCelsius(reading.Value)
+	}
+	reading.Unit = models.UnitCelsius
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"iot-backend/internal/database"
+	"iot-backend/internal/models"
+)
+
+// thresholdMetrics are the metrics the inference trigger computes z-scores for
+var thresholdMetrics = []string{"temperature", "humidity", "sound_volume"}
+
+// ThresholdAdvisor periodically analyzes recent readings and recommends trigger
+// thresholds per device and metric, so the z-score threshold can be tuned to a
+// desired trigger frequency instead of guessed.
+type ThresholdAdvisor struct {
+	db     *database.ClickHouseDB
+	config ThresholdAdvisorConfig
+
+	mu     sync.RWMutex
+	report *models.ThresholdReport
+}
+
+// ThresholdAdvisorConfig holds configuration for threshold suggestions
+type ThresholdAdvisorConfig struct {
+	Days                 int           // Days of readings analyzed
+	WindowSeconds        int           // Changes are measured between windows this long (the inference data window)
+	TargetTriggersPerDay float64       // Desired inference triggers per device per day, across all metrics
+	CurrentZScore        float64       // Configured threshold, for the current trigger rate
+	RefreshInterval      time.Duration // How often the report is recomputed
+}
+
+// DefaultThresholdAdvisorConfig returns default configuration
+func DefaultThresholdAdvisorConfig() ThresholdAdvisorConfig {
+	return ThresholdAdvisorConfig{
+		Days:                 7,
+		WindowSeconds:        120,
+		TargetTriggersPerDay: 24,
+		CurrentZScore:        1.5,
+		RefreshInterval:      6 * time.Hour,
+	}
+}
+
+// NewThresholdAdvisor creates a threshold advisor
+func NewThresholdAdvisor(db *database.ClickHouseDB, config ThresholdAdvisorConfig) *ThresholdAdvisor {
+	return &ThresholdAdvisor{db: db, config: config}
+}
+
+// Start computes the report now and then every refresh interval until context is cancelled
+func (ta *ThresholdAdvisor) Start(ctx context.Context) {
+	log.Println("ThresholdAdvisor: Starting...")
+	ta.refresh()
+
+	ticker := time.NewTicker(ta.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("ThresholdAdvisor: Shutdown complete")
+			return
+		case <-ticker.C:
+			ta.refresh()
+		}
+	}
+}
+
+// Report returns the latest report, or nil before the first analysis completes
+func (ta *ThresholdAdvisor) Report() *models.ThresholdReport {
+	ta.mu.RLock()
+	defer ta.mu.RUnlock()
+	return ta.report
+}
+
+// refresh recomputes the report; a failed analysis keeps the previous one
+func (ta *ThresholdAdvisor) refresh() {
+	report, err := ta.analyze(time.Now())
+	if err != nil {
+		log.Printf("ThresholdAdvisor: Error analyzing thresholds: %v", err)
+		return
+	}
+
+	ta.mu.Lock()
+	ta.report = report
+	ta.mu.Unlock()
+	log.Printf("ThresholdAdvisor: %d suggestions from %d days of data", len(report.Suggestions), report.Days)
+}
+
+// analyze recommends the z-score each metric must reach so that, together, the
+// metrics trigger about TargetTriggersPerDay times per day. A trigger fires when any
+// metric exceeds the threshold, so each metric gets an equal share of the target,
+// and its threshold is the change quantile exceeded at that share of windows.
+func (ta *ThresholdAdvisor) analyze(now time.Time) (*models.ThresholdReport, error) {
+	windowsPerDay := 86400 / float64(ta.config.WindowSeconds)
+	share := ta.config.TargetTriggersPerDay / float64(len(thresholdMetrics))
+	quantile := math.Max(0, math.Min(1, 1-share/windowsPerDay))
+	from := now.Add(-time.Duration(ta.config.Days) * 24 * time.Hour)
+
+	report := &models.ThresholdReport{
+		GeneratedAt:          now,
+		Days:                 ta.config.Days,
+		WindowSeconds:        ta.config.WindowSeconds,
+		TargetTriggersPerDay: ta.config.TargetTriggersPerDay,
+		CurrentZScore:        ta.config.CurrentZScore,
+		Suggestions:          []models.ThresholdSuggestion{},
+	}
+
+	for _, metric := range thresholdMetrics {
+		stats, err := ta.db.GetDeltaStats(metric, from, ta.config.WindowSeconds, quantile, ta.config.CurrentZScore)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range stats {
+			// Days actually observed, so a device installed yesterday isn't diluted over the full period
+			observedDays := float64(s.Intervals) / windowsPerDay
+			report.Suggestions = append(report.Suggestions, models.ThresholdSuggestion{
+				DeviceID:              s.DeviceID,
+				Metric:                metric,
+				StdDev:                s.StdDev,
+				Intervals:             s.Intervals,
+				SuggestedZScore:       s.DeltaQuantile / s.StdDev,
+				SuggestedDelta:        s.DeltaQuantile,
+				CurrentTriggersPerDay: float64(s.Exceeding) / observedDays,
+			})
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"fmt"
+	"math"
+
+	"iot-backend/internal/errs"
+	"iot-backend/internal/models"
+)
+
+// ValidateInferenceResponse checks an ML response before it may be actuated.
+// Positions slightly outside 0-100 (within tolerance) are clamped; anything
+// else that is out of range, NaN, or infinite is rejected as ErrModelInvalid.
+func ValidateInferenceResponse(response *models.InferenceResponse, tolerance float64) error {
+	if response.DeviceID == "" {
+		return errs.Wrap(errs.ErrPayloadInvalid, fmt.Errorf("missing device_id"))
+	}
+
+	if math.IsNaN(response.Position) || math.IsInf(response.Position, 0) {
+		return errs.Wrap(errs.ErrModelInvalid, fmt.Errorf("position is not a finite number"))
+	}
+	if response.Position < -tolerance || response.Position > 100+tolerance {
+		return errs.Wrap(errs.ErrModelInvalid, fmt.Errorf("position %.2f outside 0-100%%", response.Position))
+	}
+	response.Position = math.Max(0, math.Min(100, response.Position))
+
+	if math.IsNaN(response.Confidence) || math.IsInf(response.Confidence, 0) {
+		return errs.Wrap(errs.ErrModelInvalid, fmt.Errorf("confidence is not a finite number"))
+	}
+	if response.Confidence < 0 || response.Confidence > 1 {
+		return errs.Wrap(errs.ErrModelInvalid, fmt.Errorf("confidence %.2f outside 0-1", response.Confidence))
+	}
+
+	return nil
+}
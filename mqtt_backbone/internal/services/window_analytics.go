@@ -0,0 +1,317 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"iot-backend/internal/database"
+	"iot-backend/internal/models"
+)
+
+// minCorrelationHours is the fewest hours a correlation is computed from
+const minCorrelationHours = 6
+
+// WindowAnalyticsConfig holds settings for window usage analytics
+type WindowAnalyticsConfig struct {
+	Interval     time.Duration // How often today's and yesterday's usage is recomputed
+	BackfillDays int           // Days (including today) computed at startup
+}
+
+// DefaultWindowAnalyticsConfig returns default window analytics settings
+func DefaultWindowAnalyticsConfig() WindowAnalyticsConfig {
+	return WindowAnalyticsConfig{
+		Interval:     time.Hour,
+		BackfillDays: 7,
+	}
+}
+
+// WindowAnalyticsService computes how often and how long each device's window is
+// open, its position over the day, and the room conditions alongside, from the
+// commanded positions in window_actions. Results are stored per day and per hour in
+// the window_usage tables; recent days are recomputed as commands arrive.
+type WindowAnalyticsService struct {
+	db     *database.ClickHouseDB
+	config WindowAnalyticsConfig
+}
+
+// NewWindowAnalyticsService creates a new window analytics service
+func NewWindowAnalyticsService(db *database.ClickHouseDB, config WindowAnalyticsConfig) *WindowAnalyticsService {
+	return &WindowAnalyticsService{db: db, config: config}
+}
+
+// Start computes the backfill days now and then refreshes today and yesterday every
+// interval until context is cancelled. Yesterday is included so its last hours are
+// complete after midnight.
+func (wa *WindowAnalyticsService) Start(ctx context.Context) {
+	log.Printf("WindowAnalyticsService: Starting (every %v, %d days backfilled)", wa.config.Interval, wa.config.BackfillDays)
+	wa.analyze(time.Now(), wa.config.BackfillDays)
+
+	ticker := time.NewTicker(wa.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("WindowAnalyticsService: Stopped")
+			return
+		case <-ticker.C:
+			wa.analyze(time.Now(), 2)
+		}
+	}
+}
+
+// analyze computes the given number of local days up to and including today
+func (wa *WindowAnalyticsService) analyze(now time.Time, days int) {
+	today := localMidnight(now)
+	for i := days - 1; i >= 0; i-- {
+		day := today.AddDate(0, 0, -i)
+		if err := wa.analyzeDay(day, now); err != nil {
+			log.Printf("WindowAnalyticsService: Error analyzing %s: %v", day.Format("2006-01-02"), err)
+		}
+	}
+}
+
+// localMidnight returns the start of the local day containing t
+func localMidnight(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// analyzeDay computes and stores every device's usage for one day, up to now for today
+func (wa *WindowAnalyticsService) analyzeDay(day, now time.Time) error {
+	end := day.AddDate(0, 0, 1)
+	if end.After(now) {
+		end = now
+	}
+
+	initial, changes, err := wa.db.GetWindowPositionChanges(day, end)
+	if err != nil {
+		return err
+	}
+	temperatures, err := wa.db.GetHourlyMeans("temperature", day, end)
+	if err != nil {
+		return err
+	}
+	noise, err := wa.db.GetHourlyMeans("sound_volume", day, end)
+	if err != nil {
+		return err
+	}
+
+	devices := make(map[string]bool)
+	for id := range initial {
+		devices[id] = true
+	}
+	for id := range changes {
+		devices[id] = true
+	}
+
+	var dayRows []*models.WindowUsageDay
+	var hourRows []*models.WindowUsageHour
+	for id := range devices {
+		var start *float64
+		if p, ok := initial[id]; ok {
+			start = &p
+		}
+		usage, hours := computeWindowUsage(id, day, end, start, changes[id])
+		if usage == nil {
+			continue
+		}
+		for _, h := range hours {
+			if v, ok := temperatures[id][h.Hour.Unix()]; ok {
+				h.Temperature = &v
+			}
+			if v, ok := noise[id][h.Hour.Unix()]; ok {
+				h.Noise = &v
+			}
+		}
+		dayRows = append(dayRows, usage)
+		hourRows = append(hourRows, hours...)
+	}
+	if len(dayRows) == 0 {
+		return nil
+	}
+	return wa.db.SaveWindowUsage(dayRows, hourRows, now)
+}
+
+// usageTotals accumulates time spent at positions
+type usageTotals struct {
+	seconds  float64
+	open     float64
+	weighted float64 // Position × seconds
+}
+
+func (u *usageTotals) add(seconds, position float64) {
+	u.seconds += seconds
+	u.weighted += position * seconds
+	if position > database.WindowOpenAbove {
+		u.open += seconds
+	}
+}
+
+func (u *usageTotals) mean() float64 {
+	if u.seconds == 0 {
+		return 0
+	}
+	return u.weighted / u.seconds
+}
+
+// computeWindowUsage replays a device's commanded positions over [from, to). A window
+// holds each position until the next command; time before the first known position
+// isn't counted. It returns nil when the position was never known.
+func computeWindowUsage(deviceID string, from, to time.Time, initial *float64, actions []models.WindowAction) (*models.WindowUsageDay, []*models.WindowUsageHour) {
+	var day usageTotals
+	hourly := make(map[time.Time]*usageTotals)
+	var order []time.Time
+
+	// hold spreads the time spent at a position over the hours it spans
+	hold := func(start, end time.Time, position float64) {
+		for start.Before(end) {
+			hour := start.Truncate(time.Hour)
+			next := hour.Add(time.Hour)
+			if next.After(end) {
+				next = end
+			}
+			seconds := next.Sub(start).Seconds()
+			totals, ok := hourly[hour]
+			if !ok {
+				totals = &usageTotals{}
+				hourly[hour] = totals
+				order = append(order, hour)
+			}
+			totals.add(seconds, position)
+			day.add(seconds, position)
+			start = next
+		}
+	}
+
+	openEvents := 0
+	position, known := 0.0, initial != nil
+	if known {
+		position = *initial
+	}
+	at := from
+	for _, a := range actions {
+		if known {
+			hold(at, a.Timestamp, position)
+		}
+		if a.Position > database.WindowOpenAbove && (!known || position <= database.WindowOpenAbove) {
+			openEvents++
+		}
+		position, known, at = a.Position, true, a.Timestamp
+	}
+	if known {
+		hold(at, to, position)
+	}
+	if day.seconds == 0 {
+		return nil, nil
+	}
+
+	usage := &models.WindowUsageDay{
+		Day:         from,
+		DeviceID:    deviceID,
+		Seconds:     int(math.Round(day.seconds)),
+		OpenSeconds: int(math.Round(day.open)),
+		OpenEvents:  openEvents,
+		AvgPosition: day.mean(),
+	}
+	hours := make([]*models.WindowUsageHour, 0, len(order))
+	for _, hour := range order {
+		totals := hourly[hour]
+		hours = append(hours, &models.WindowUsageHour{
+			Hour:        hour,
+			DeviceID:    deviceID,
+			Seconds:     int(math.Round(totals.seconds)),
+			OpenSeconds: int(math.Round(totals.open)),
+			AvgPosition: totals.mean(),
+		})
+	}
+	return usage, hours
+}
+
+// SummarizeWindowUsage combines a device's stored daily and hourly usage into a report
+func SummarizeWindowUsage(deviceID string, from, to time.Time, days []models.WindowUsageDay, hours []models.WindowUsageHour) *models.WindowUsageReport {
+	report := &models.WindowUsageReport{
+		DeviceID:          deviceID,
+		From:              from,
+		To:                to,
+		AvgPositionByHour: make([]*float64, 24),
+		Days:              days,
+	}
+	if report.Days == nil {
+		report.Days = []models.WindowUsageDay{}
+	}
+	for _, d := range days {
+		report.OpenEvents += d.OpenEvents
+	}
+
+	var total usageTotals
+	var byHour [24]usageTotals
+	var positions, temperatures, positionsWithNoise, noise []float64
+	for _, h := range hours {
+		if h.Seconds == 0 {
+			continue
+		}
+		seconds := float64(h.Seconds)
+		total.seconds += seconds
+		total.open += float64(h.OpenSeconds)
+		total.weighted += h.AvgPosition * seconds
+
+		slot := &byHour[h.Hour.Local().Hour()]
+		slot.seconds += seconds
+		slot.weighted += h.AvgPosition * seconds
+
+		if h.Temperature != nil {
+			positions = append(positions, h.AvgPosition)
+			temperatures = append(temperatures, *h.Temperature)
+		}
+		if h.Noise != nil {
+			positionsWithNoise = append(positionsWithNoise, h.AvgPosition)
+			noise = append(noise, *h.Noise)
+		}
+	}
+
+	report.Seconds = int(total.seconds)
+	report.OpenSeconds = int(total.open)
+	report.AvgPosition = total.mean()
+	if total.seconds > 0 {
+		report.OpenFraction = total.open / total.seconds
+	}
+	for i := range byHour {
+		if byHour[i].seconds > 0 {
+			mean := byHour[i].mean()
+			report.AvgPositionByHour[i] = &mean
+		}
+	}
+	report.TemperatureCorrelation = pearson(positions, temperatures)
+	report.NoiseCorrelation = pearson(positionsWithNoise, noise)
+	return report
+}
+
+// pearson returns the correlation coefficient of two series, or nil when it's undefined
+func pearson(x, y []float64) *float64 {
+	n := float64(len(x))
+	if len(x) < minCorrelationHours {
+		return nil
+	}
+	var sumX, sumY float64
+	for i := range x {
+		sumX += x[i]
+		sumY += y[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var cov, varX, varY float64
+	for i := range x {
+		dx, dy := x[i]-meanX, y[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return nil
+	}
+	r := cov / math.Sqrt(varX*varY)
+	return &r
+}
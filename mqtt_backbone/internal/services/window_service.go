@@ -0,0 +1,495 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"iot-backend/internal/arbitration"
+	"iot-backend/internal/database"
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+)
+
+// WindowControlService turns window position proposals (ML responses, safety events)
+// into actuator commands via the arbitration module, and records every decision in ClickHouse
+type WindowControlService struct {
+	db        *database.ClickHouseDB
+	publisher CommandPublisher
+	arbiter   *arbitration.Arbiter
+
+	// Input channel from MQTT subscriber
+	ResponseChan <-chan *models.InferenceResponse
+
+	// In dry-run mode commands are computed and stored but never reach the actuator
+	dryRun bool
+
+	// How long a safety event keeps the window closed after its last report
+	safetyHold time.Duration
+
+	// How long an inference domain's proposal is merged with later responses of other domains
+	domainHold time.Duration
+
+	// Positions this far outside 0-100 are clamped instead of rejected
+	positionTolerance float64
+
+	// Commands carry an expiry this long after their decision, so a command held
+	// back while the actuator was offline isn't applied once conditions changed
+	commandTTL time.Duration
+
+	// Caps and then closes windows as the temperature approaches freezing
+	frost *frostGuard
+
+	// Daily movement budget per window
+	budget *actuationBudget
+
+	// Device ID of the end-to-end canary, whose responses are never actuated or stored; set before Start
+	CanaryDeviceID string
+
+	// Device boots, on which the device's current command is issued again; set before Start
+	BootChan <-chan *models.DeviceBoot
+
+	mu      sync.Mutex
+	current map[string]models.WindowCommand // device_id -> last command sent to the actuator
+}
+
+// CommandPublisher interface for sending window commands to actuators
+type CommandPublisher interface {
+	PublishWindowCommand(cmd *models.WindowCommand) error
+	PublishShadowCommand(cmd *models.WindowCommand) error
+}
+
+// WindowControlServiceConfig holds configuration for window control service
+type WindowControlServiceConfig struct {
+	ChannelSize       int
+	DryRun            bool          // Log/store commands (and publish to the shadow topic) without actuating
+	SafetyHoldMinutes int           // Safety closure duration after the last safety event
+	DomainHoldMinutes int           // How long an inference domain's position stays in the merge
+	PositionTolerance float64       // Clamp (not reject) positions up to this far outside 0-100
+	CommandTTL        time.Duration // Commands expire this long after they're issued (0 = never)
+	Frost             FrostConfig
+	Budget            ActuationBudgetConfig
+}
+
+// DefaultWindowControlServiceConfig returns default configuration
+func DefaultWindowControlServiceConfig() WindowControlServiceConfig {
+	return WindowControlServiceConfig{
+		ChannelSize:       50,
+		DryRun:            false,
+		SafetyHoldMinutes: 15,
+		DomainHoldMinutes: 30,
+		PositionTolerance: 1.0,
+		CommandTTL:        5 * time.Minute,
+		Frost:             DefaultFrostConfig(),
+		Budget:            DefaultActuationBudgetConfig(),
+	}
+}
+
+// NewWindowControlService creates a new window control service
+func NewWindowControlService(
+	db *database.ClickHouseDB,
+	publisher CommandPublisher,
+	config WindowControlServiceConfig,
+) *WindowControlService {
+	ws := &WindowControlService{
+		db:           db,
+		publisher:    publisher,
+		arbiter:      arbitration.NewArbiter(),
+		ResponseChan: make(chan *models.InferenceResponse, config.ChannelSize),
+		dryRun:       config.DryRun,
+		safetyHold:   time.Duration(config.SafetyHoldMinutes) * time.Minute,
+		domainHold:   time.Duration(config.DomainHoldMinutes) * time.Minute,
+
+		positionTolerance: config.PositionTolerance,
+		commandTTL:        config.CommandTTL,
+		current:           make(map[string]models.WindowCommand),
+	}
+	ws.frost = newFrostGuard(config.Frost, ws.deviceConfig)
+	ws.budget = newActuationBudget(config.Budget, ws.deviceConfig)
+	return ws
+}
+
+// Start processes window control responses until context is cancelled
+func (ws *WindowControlService) Start(ctx context.Context) {
+	log.Println("WindowControlService: Starting...")
+	if ws.dryRun {
+		log.Println("WindowControlService: DRY-RUN mode, commands will not be sent to actuators")
+	}
+	ws.restoreBudget()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("WindowControlService: Shutting down...")
+			return
+
+		case response, ok := <-ws.ResponseChan:
+			if !ok {
+				log.Println("WindowControlService: Channel closed, shutting down...")
+				return
+			}
+
+			ws.handleWindowControl(response)
+
+		case boot, ok := <-ws.BootChan:
+			if !ok {
+				ws.BootChan = nil
+				continue
+			}
+			ws.reissue(boot.DeviceID)
+		}
+	}
+}
+
+// reissue sends a device's current command again after it booted, since an
+// actuator that restarted may have lost a command it never acknowledged. The
+// publisher gives the copy a new command ID; a command past its expiry is dropped.
+func (ws *WindowControlService) reissue(deviceID string) {
+	ws.mu.Lock()
+	command, ok := ws.current[deviceID]
+	ws.mu.Unlock()
+	if !ok || ws.dryRun {
+		return
+	}
+	if command.Expired(time.Now()) {
+		log.Printf("WindowControlService: Not re-issuing the expired command for %s after its boot", deviceID)
+		return
+	}
+
+	log.Printf("WindowControlService: %s booted, re-issuing its command (%.2f%%)", deviceID, command.Position)
+	metrics.Default.Counter("window_commands_reissued").Inc()
+	if err := ws.publisher.PublishWindowCommand(&command); err != nil {
+		log.Printf("Error re-issuing window command: %v", err)
+	}
+}
+
+// restoreBudget counts the movements already commanded today, so a restart doesn't refill the budget
+func (ws *WindowControlService) restoreBudget() {
+	now := time.Now()
+	initial, changes, err := ws.db.GetWindowPositionChanges(localMidnight(now), now)
+	if err != nil {
+		log.Printf("WindowControlService: Error restoring actuation budgets, starting from zero: %v", err)
+		return
+	}
+	ws.budget.restore(initial, changes, now)
+}
+
+// ActuationBudget returns a device's movement budget and today's consumption
+func (ws *WindowControlService) ActuationBudget(deviceID string) *models.ActuationBudget {
+	return ws.budget.usage(deviceID, time.Now())
+}
+
+// Position returns the position last commanded to a device's window, if known
+func (ws *WindowControlService) Position(deviceID string) (float64, bool) {
+	return ws.budget.position(deviceID)
+}
+
+// ApplyOverride proposes a user's manual position (e.g., from the companion app) until
+// hold passes, and returns the decision. Safety conditions still win over it.
+func (ws *WindowControlService) ApplyOverride(deviceID string, position float64, hold time.Duration, reason string) arbitration.Decision {
+	return ws.apply(arbitration.Proposal{
+		DeviceID:  deviceID,
+		Source:    arbitration.SourceManual,
+		Key:       "override",
+		Position:  position,
+		Reason:    reason,
+		ExpiresAt: time.Now().Add(hold),
+	}, &models.WindowAction{Confidence: 1.0})
+}
+
+// ReleaseOverride withdraws a device's manual override; the window stays put until
+// the next proposal from another source
+func (ws *WindowControlService) ReleaseOverride(deviceID string) {
+	ws.arbiter.Release(deviceID, arbitration.SourceManual, "override")
+}
+
+// HandleSafetyEvent closes the window while a safety condition is active.
+// A non-positive value (e.g., rain stopped) releases the hold for that event type.
+// Outdoor temperature reports feed frost protection instead.
+func (ws *WindowControlService) HandleSafetyEvent(event *models.SafetyEvent) {
+	if event.EventType == models.SafetyOutdoorTemperature {
+		ws.checkFrost(event.DeviceID, event.Value, event.Timestamp, true)
+		return
+	}
+	if event.Value <= 0 {
+		ws.arbiter.Release(event.DeviceID, arbitration.SourceSafety, event.EventType)
+		log.Printf("WindowControlService: Safety hold released for %s (%s cleared)", event.DeviceID, event.EventType)
+		return
+	}
+
+	proposal := arbitration.Proposal{
+		DeviceID:  event.DeviceID,
+		Source:    arbitration.SourceSafety,
+		Key:       event.EventType,
+		Position:  0,
+		Reason:    fmt.Sprintf("%s detected (value=%.2f)", event.EventType, event.Value),
+		ExpiresAt: time.Now().Add(ws.safetyHold),
+	}
+
+	ws.apply(proposal, &models.WindowAction{Confidence: 1.0})
+}
+
+// HandleTemperature applies frost protection to an indoor temperature reading
+func (ws *WindowControlService) HandleTemperature(reading *models.TemperatureReading) {
+	ws.checkFrost(reading.DeviceID, reading.Value, reading.Timestamp, false)
+}
+
+// checkFrost updates a device's frost level and arbitrates the matching safety
+// proposal: a cap at the frost maximum opening, then forced closure
+func (ws *WindowControlService) checkFrost(deviceID string, value float64, at time.Time, outdoor bool) {
+	if at.IsZero() {
+		at = time.Now()
+	}
+	t := ws.frost.observe(deviceID, value, at, outdoor)
+	if t.From == t.To && !t.Refresh {
+		return
+	}
+
+	if t.To == frostClear {
+		ws.arbiter.Release(deviceID, arbitration.SourceSafety, "frost")
+		log.Printf("WindowControlService: Frost protection lifted for %s (%.1f°C)", deviceID, t.Temperature)
+		ws.recordFrost(deviceID, t)
+		return
+	}
+
+	proposal := arbitration.Proposal{
+		DeviceID:  deviceID,
+		Source:    arbitration.SourceSafety,
+		Key:       "frost",
+		ExpiresAt: time.Now().Add(time.Duration(t.Settings.HoldMinutes) * time.Minute),
+	}
+	if t.To == frostClosed {
+		proposal.Reason = fmt.Sprintf("frost closure (%.1f°C <= %.1f°C)", t.Temperature, t.Settings.CloseTemperature)
+	} else {
+		proposal.Cap = true
+		proposal.Position = t.Settings.MaxPosition
+		proposal.Reason = fmt.Sprintf("frost cap at %.0f%% (%.1f°C <= %.1f°C)", t.Settings.MaxPosition, t.Temperature, t.Settings.CapTemperature)
+	}
+
+	if t.From != t.To {
+		log.Printf("WindowControlService: Frost protection %s -> %s for %s (%.1f°C)", t.From, t.To, deviceID, t.Temperature)
+		ws.recordFrost(deviceID, t)
+	}
+	ws.apply(proposal, &models.WindowAction{Confidence: 1.0, Temperature: t.Temperature})
+}
+
+// recordFrost logs a frost level change as a safety event ("frost_capped",
+// "frost_closed", "frost_clear") carrying the deciding temperature
+func (ws *WindowControlService) recordFrost(deviceID string, t frostTransition) {
+	event := &models.SafetyEvent{
+		Timestamp: time.Now(),
+		DeviceID:  deviceID,
+		EventType: "frost_" + t.To.String(),
+		Value:     t.Temperature,
+	}
+	if err := ws.db.SaveSafetyEvent(event, 0); err != nil {
+		log.Printf("Error saving frost safety event: %v", err)
+	}
+}
+
+// deviceConfig returns a device's registry config, or nil if it can't be read
+func (ws *WindowControlService) deviceConfig(deviceID string) map[string]interface{} {
+	device, err := ws.db.GetDevice(deviceID)
+	if err != nil {
+		log.Printf("WindowControlService: Error reading registry for %s, using default frost and budget settings: %v", deviceID, err)
+		return nil
+	}
+	if device == nil {
+		return nil
+	}
+	return device.Config
+}
+
+// handleWindowControl submits an ML window control response for arbitration and saves its metadata
+func (ws *WindowControlService) handleWindowControl(response *models.InferenceResponse) {
+	log.Printf("Window control received: Device=%s, Domain=%s, Position=%.2f%%, Confidence=%.2f",
+		response.DeviceID, response.Domain, response.Position, response.Confidence)
+
+	// Never actuate an invalid response
+	if err := ValidateInferenceResponse(response, ws.positionTolerance); err != nil {
+		log.Printf("WindowControlService: Rejected response for %s: %v", response.DeviceID, err)
+		ws.deadLetter(response, err)
+		return
+	}
+	if ws.CanaryDeviceID != "" && response.DeviceID == ws.CanaryDeviceID {
+		return // The canary service follows it
+	}
+
+	// Create window action record
+	windowAction := &models.WindowAction{
+		Timestamp:   response.Timestamp,
+		Confidence:  response.Confidence,
+		Temperature: 0.0,
+		Humidity:    0.0,
+		SoundVolume: 0.0,
+	}
+
+	// Extract features from response if available
+	if temp, ok := response.FeaturesUsed["temperature"].(float64); ok {
+		windowAction.Temperature = temp
+	}
+	if humidity, ok := response.FeaturesUsed["humidity"].(float64); ok {
+		windowAction.Humidity = humidity
+	}
+	if volume, ok := response.FeaturesUsed["sound_volume"].(float64); ok {
+		windowAction.SoundVolume = volume
+	}
+
+	proposal := arbitration.Proposal{
+		DeviceID: response.DeviceID,
+		Source:   arbitration.SourceML,
+		Position: response.Position,
+		Reason:   fmt.Sprintf("ml prediction (confidence=%.2f)", response.Confidence),
+	}
+	if response.Domain != "" {
+		// Domains answer independently, so each keeps its position in the merge
+		// until the domain answers again or the hold passes
+		proposal.Key = response.Domain
+		proposal.Reason = fmt.Sprintf("%s ml prediction (confidence=%.2f)", response.Domain, response.Confidence)
+		proposal.Weight = math.Max(response.Confidence, minDomainWeight)
+		proposal.ExpiresAt = time.Now().Add(ws.domainHold)
+	}
+	ws.apply(proposal, windowAction)
+
+	// Save ML prediction metadata
+	mlPrediction := &models.MLPrediction{
+		Timestamp:    response.Timestamp,
+		DeviceID:     response.DeviceID,
+		Prediction:   response.Position,
+		Confidence:   response.Confidence,
+		ModelVersion: response.ModelVersion,
+		ModelVariant: response.ModelVariant,
+	}
+	if mlPrediction.ModelVersion == "" {
+		mlPrediction.ModelVersion = "unversioned"
+	}
+
+	if err := ws.db.SaveMLPrediction(mlPrediction); err != nil {
+		log.Printf("Error saving ML prediction: %v", err)
+	}
+}
+
+// minDomainWeight keeps a zero-confidence domain response in the merge, at negligible weight
+const minDomainWeight = 0.01
+
+// deadLetter records a rejected ML response
+func (ws *WindowControlService) deadLetter(response *models.InferenceResponse, reason error) {
+	// NaN/Inf can't be JSON-encoded, so fall back to a formatted dump
+	payload, err := json.Marshal(response)
+	if err != nil {
+		payload = []byte(fmt.Sprintf("%+v", *response))
+	}
+
+	letter := &models.DeadLetter{
+		Timestamp: time.Now(),
+		Source:    "window_control",
+		DeviceID:  response.DeviceID,
+		Payload:   string(payload),
+		Reason:    reason.Error(),
+	}
+	if err := ws.db.SaveDeadLetter(letter); err != nil {
+		log.Printf("Error saving dead letter: %v", err)
+	}
+}
+
+// apply arbitrates a proposal, sends the resulting command, and records the
+// window action and decision trace. action carries the input features; its
+// device, position, and timestamp are filled in from the decision. A decision
+// that would exceed the window's movement budget holds the current position: no
+// command is sent and only the decision is recorded.
+func (ws *WindowControlService) apply(proposal arbitration.Proposal, action *models.WindowAction) arbitration.Decision {
+	decision := ws.arbiter.Submit(proposal)
+
+	log.Printf("WindowControlService: Decision for %s: %.2f%% - %s",
+		decision.DeviceID, decision.Position, decision.Summary)
+
+	if ok, reason := ws.budget.allow(decision, time.Now()); !ok {
+		held, _ := ws.budget.position(decision.DeviceID)
+		log.Printf("WindowControlService: Holding %s at %.2f%% instead of %.2f%%: %s",
+			decision.DeviceID, held, decision.Position, reason)
+		metrics.Default.Counter("window_budget_denied").Inc()
+		decision.Position = held
+		decision.Summary = fmt.Sprintf("%s; held: %s", decision.Summary, reason)
+		ws.recordDecision(decision)
+		return decision
+	}
+
+	command := &models.WindowCommand{
+		DeviceID:  decision.DeviceID,
+		Timestamp: decision.Timestamp,
+		Position:  decision.Position,
+		Source:    string(decision.Winner),
+		Critical:  critical(decision),
+	}
+	// Expiry is sent in UTC, which is what the firmware parses
+	if ws.commandTTL > 0 && !command.Critical {
+		expires := decision.Timestamp.Add(ws.commandTTL).UTC()
+		command.ExpiresAt = &expires
+	}
+
+	if ws.dryRun {
+		log.Printf("WindowControlService: [dry-run] Would move %s to %.2f%%", command.DeviceID, command.Position)
+		if err := ws.publisher.PublishShadowCommand(command); err != nil {
+			log.Printf("Error publishing shadow command: %v", err)
+		}
+	} else if err := ws.publisher.PublishWindowCommand(command); err != nil {
+		log.Printf("Error publishing window command: %v", err)
+	} else {
+		// Only commands that reached the broker use up the budget, as on restore
+		ws.budget.commit(command.DeviceID, command.Position, time.Now())
+		ws.mu.Lock()
+		ws.current[command.DeviceID] = *command
+		ws.mu.Unlock()
+	}
+
+	if action.Timestamp.IsZero() {
+		action.Timestamp = decision.Timestamp
+	}
+	action.DeviceID = decision.DeviceID
+	action.Position = decision.Position
+	action.DryRun = ws.dryRun
+
+	// Save window action to database
+	if err := ws.db.SaveWindowAction(action); err != nil {
+		log.Printf("Error saving window action: %v", err)
+	}
+
+	ws.recordDecision(decision)
+	return decision
+}
+
+// critical reports whether a decision must reach the actuator however late: a
+// safety or manual winner, or a position limited by a safety or manual cap
+func critical(decision arbitration.Decision) bool {
+	for _, source := range []arbitration.Source{arbitration.SourceSafety, arbitration.SourceManual} {
+		if decision.Winner == source || decision.CappedBy(source) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordDecision saves a decision and its trace
+func (ws *WindowControlService) recordDecision(decision arbitration.Decision) {
+	trace, err := json.Marshal(decision.Trace)
+	if err != nil {
+		log.Printf("Error marshaling decision trace: %v", err)
+		trace = []byte("[]")
+	}
+
+	record := &models.WindowDecision{
+		Timestamp: decision.Timestamp,
+		DeviceID:  decision.DeviceID,
+		Position:  decision.Position,
+		Winner:    string(decision.Winner),
+		Summary:   decision.Summary,
+		Trace:     string(trace),
+	}
+	if err := ws.db.SaveWindowDecision(record); err != nil {
+		log.Printf("Error saving window decision: %v", err)
+	}
+}
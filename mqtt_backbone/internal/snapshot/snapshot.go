@@ -0,0 +1,149 @@
+// Package snapshot reads and writes operational-state archives: the device
+// registry (with per-device config such as thresholds, calibration, and frost
+// settings) and configuration-held rules such as alert scoping. Archives move
+// state between instances for disaster recovery and staging/production parity.
+//
+// An archive is a gzip-compressed tar file with one JSON document per section:
+//
+//	manifest.json   version, creation time, source, and section counts
+//	devices.json    device registry rows
+//	settings.json   environment settings (e.g., ALERT_RULES) by variable name
+//
+// Readers skip sections they don't know, so archives from newer versions restore
+// what this version understands.
+package snapshot
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"iot-backend/internal/models"
+)
+
+// FormatVersion is the archive layout version written by this package. It changes
+// only when an existing section changes incompatibly; new sections don't bump it.
+const FormatVersion = 1
+
+// Section file names
+const (
+	manifestFile = "manifest.json"
+	devicesFile  = "devices.json"
+	settingsFile = "settings.json"
+)
+
+// Manifest describes an archive
+type Manifest struct {
+	Version   int            `json:"version"`
+	CreatedAt time.Time      `json:"created_at"`
+	Source    string         `json:"source"`   // Instance the snapshot was taken on (hostname)
+	Sections  map[string]int `json:"sections"` // Entries per section file
+}
+
+// Snapshot is the operational state of one instance
+type Snapshot struct {
+	Manifest Manifest
+	Devices  []models.Device
+	Settings map[string]string // Environment variable -> value
+}
+
+// Write encodes a snapshot as an archive. The manifest's version and section
+// counts are filled in.
+func Write(w io.Writer, snap *Snapshot) error {
+	snap.Manifest.Version = FormatVersion
+	snap.Manifest.Sections = map[string]int{
+		devicesFile:  len(snap.Devices),
+		settingsFile: len(snap.Settings),
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	sections := []struct {
+		name string
+		v    interface{}
+	}{
+		{manifestFile, snap.Manifest},
+		{devicesFile, snap.Devices},
+		{settingsFile, snap.Settings},
+	}
+	for _, s := range sections {
+		data, err := json.MarshalIndent(s.v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", s.name, err)
+		}
+		header := &tar.Header{
+			Name:    s.name,
+			Mode:    0o644,
+			Size:    int64(len(data)),
+			ModTime: snap.Manifest.CreatedAt,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write %s: %w", s.name, err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", s.name, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return nil
+}
+
+// Read decodes an archive. Unknown sections are returned by name so callers can
+// report what was skipped.
+func Read(r io.Reader) (*Snapshot, []string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer gz.Close()
+
+	snap := &Snapshot{}
+	var unknown []string
+	seenManifest := false
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		var target interface{}
+		switch header.Name {
+		case manifestFile:
+			target, seenManifest = &snap.Manifest, true
+		case devicesFile:
+			target = &snap.Devices
+		case settingsFile:
+			target = &snap.Settings
+		default:
+			unknown = append(unknown, header.Name)
+			continue
+		}
+		if err := json.NewDecoder(tr).Decode(target); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode %s: %w", header.Name, err)
+		}
+	}
+
+	if !seenManifest {
+		return nil, nil, fmt.Errorf("archive has no %s", manifestFile)
+	}
+	if snap.Manifest.Version > FormatVersion {
+		return nil, nil, fmt.Errorf("archive version %d is newer than supported version %d", snap.Manifest.Version, FormatVersion)
+	}
+	return snap, unknown, nil
+}
@@ -0,0 +1,306 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"iot-backend/internal/metrics"
+	"iot-backend/internal/models"
+)
+
+// SharedStore holds device state where every replica can read it
+type SharedStore interface {
+	SaveDeviceStates(states []models.DeviceState) error
+	GetDeviceState(ctx context.Context, deviceID string) (*models.DeviceState, error) // nil when no replica wrote it
+}
+
+// adoptTimeout bounds the shared store read of a device being taken over. The read
+// happens on the reading's path, so a slow store keeps the local state instead.
+const adoptTimeout = 500 * time.Millisecond
+
+// Store keeps per-device state in memory and snapshots it to a local file,
+// so change detection and rate limiting survive backend restarts
+type Store struct {
+	mu      sync.RWMutex
+	devices map[string]*models.DeviceState
+
+	// Snapshot file path (empty keeps state in memory only)
+	path string
+
+	// Optional store shared by replicas; set before Start. Updated states are written
+	// to it every snapshot interval and read back when this replica takes a device
+	// over, so moving a device between replicas keeps its deltas and cooldowns.
+	Shared SharedStore
+
+	// How long a device may go without updates here before its shared state is read
+	// again (another replica may have handled it meanwhile); set before Start
+	HandoffIdle time.Duration
+
+	dirty   map[string]bool      // Devices updated since the last shared write
+	touched map[string]time.Time // When each device was last updated or read from the shared store
+}
+
+// NewStore creates a device state store backed by the given snapshot file
+func NewStore(path string) *Store {
+	return &Store{
+		devices: make(map[string]*models.DeviceState),
+		path:    path,
+		dirty:   make(map[string]bool),
+		touched: make(map[string]time.Time),
+	}
+}
+
+// get returns the state for a device, creating it if needed. Caller must hold mu.
+func (s *Store) get(deviceID string) *models.DeviceState {
+	st, ok := s.devices[deviceID]
+	if !ok {
+		st = &models.DeviceState{DeviceID: deviceID}
+		s.devices[deviceID] = st
+	}
+	return st
+}
+
+// update returns the state for a device about to be changed, marking it for the
+// shared store. Caller must hold mu.
+func (s *Store) update(deviceID string) *models.DeviceState {
+	s.dirty[deviceID] = true
+	s.touched[deviceID] = time.Now()
+	return s.get(deviceID)
+}
+
+// adopt reads a device's state from the shared store when this replica hasn't
+// updated the device within HandoffIdle, taking it when it holds a later reading
+// than the local state. The cooldown keeps the later inference of the two.
+func (s *Store) adopt(deviceID string) {
+	if s.Shared == nil {
+		return
+	}
+	s.mu.RLock()
+	touched, ok := s.touched[deviceID]
+	s.mu.RUnlock()
+	if ok && time.Since(touched) < s.HandoffIdle {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), adoptTimeout)
+	shared, err := s.Shared.GetDeviceState(ctx, deviceID)
+	cancel()
+	if err != nil {
+		log.Printf("DeviceState: Error reading shared state of %s, keeping local state: %v", deviceID, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touched[deviceID] = time.Now() // Also after an error, so an outage isn't queried on every reading
+	if shared == nil {
+		return
+	}
+	st := s.get(deviceID)
+	if !shared.LastSeen.After(st.LastSeen) {
+		return
+	}
+	lastInference := st.LastInferenceTime
+	*st = *shared
+	st.DeviceID = deviceID
+	if lastInference.After(st.LastInferenceTime) {
+		st.LastInferenceTime = lastInference
+	}
+	metrics.Default.Counter("device_state_adopted").Inc()
+}
+
+// UpdateTemperature records the latest temperature for a device
+func (s *Store) UpdateTemperature(deviceID string, value float64, timestamp time.Time) {
+	s.adopt(deviceID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.update(deviceID)
+	st.LastTemperature = value
+	st.TemperatureAt = timestamp
+	st.LastSeen = timestamp
+}
+
+// UpdateHumidity records the latest humidity for a device
+func (s *Store) UpdateHumidity(deviceID string, value float64, timestamp time.Time) {
+	s.adopt(deviceID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.update(deviceID)
+	st.LastHumidity = value
+	st.HumidityAt = timestamp
+	st.LastSeen = timestamp
+}
+
+// UpdateSoundVolume records the latest sound volume for a device
+func (s *Store) UpdateSoundVolume(deviceID string, value float64, timestamp time.Time) {
+	s.adopt(deviceID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.update(deviceID)
+	st.LastSoundVolume = value
+	st.SoundVolumeAt = timestamp
+	st.LastSeen = timestamp
+}
+
+// MarkInference records that an inference was triggered for a device
+func (s *Store) MarkInference(deviceID string, timestamp time.Time) {
+	s.adopt(deviceID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update(deviceID).LastInferenceTime = timestamp
+}
+
+// Get returns a copy of a device's state
+func (s *Store) Get(deviceID string) (models.DeviceState, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	st, ok := s.devices[deviceID]
+	if !ok {
+		return models.DeviceState{}, false
+	}
+	return *st, true
+}
+
+// DeviceIDs returns all devices with recorded state
+func (s *Store) DeviceIDs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]string, 0, len(s.devices))
+	for id := range s.devices {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Load restores state from the snapshot file. A missing file is not an error.
+func (s *Store) Load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read device state: %w", err)
+	}
+
+	var snapshot []*models.DeviceState
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse device state: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, st := range snapshot {
+		if st.DeviceID != "" {
+			s.devices[st.DeviceID] = st
+		}
+	}
+
+	log.Printf("DeviceState: Restored state for %d devices from %s", len(snapshot), s.path)
+	return nil
+}
+
+// Save writes the current state to the snapshot file atomically
+func (s *Store) Save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.mu.RLock()
+	snapshot := make([]models.DeviceState, 0, len(s.devices))
+	for _, st := range s.devices {
+		snapshot = append(snapshot, *st)
+	}
+	s.mu.RUnlock()
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal device state: %w", err)
+	}
+
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create state directory: %w", err)
+		}
+	}
+
+	// Write to a temp file and rename so a crash never leaves a truncated snapshot
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write device state: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace device state: %w", err)
+	}
+	return nil
+}
+
+// SaveShared writes the states updated since the last call to the shared store.
+// States that fail to be written are retried on the next call.
+func (s *Store) SaveShared() error {
+	if s.Shared == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	states := make([]models.DeviceState, 0, len(s.dirty))
+	for deviceID := range s.dirty {
+		states = append(states, *s.devices[deviceID])
+	}
+	s.dirty = make(map[string]bool)
+	s.mu.Unlock()
+	if len(states) == 0 {
+		return nil
+	}
+
+	if err := s.Shared.SaveDeviceStates(states); err != nil {
+		s.mu.Lock()
+		for _, st := range states {
+			s.dirty[st.DeviceID] = true
+		}
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Start periodically snapshots state, to the file and the shared store, until context
+// is cancelled, then saves a final snapshot
+func (s *Store) Start(ctx context.Context, interval time.Duration) {
+	if s.path == "" && s.Shared == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.Save(); err != nil {
+				log.Printf("DeviceState: Error saving final snapshot: %v", err)
+			} else if s.path != "" {
+				log.Println("DeviceState: Final snapshot saved")
+			}
+			if err := s.SaveShared(); err != nil {
+				log.Printf("DeviceState: Error handing off final state: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := s.Save(); err != nil {
+				log.Printf("DeviceState: Error saving snapshot: %v", err)
+			}
+			if err := s.SaveShared(); err != nil {
+				log.Printf("DeviceState: Error writing shared state: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,28 @@
+// Package stats holds the order statistics shared by the data quality filters,
+// the audio level analysis, and the reports built from stored readings.
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// Median returns the median of values without modifying the slice
+func Median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// Percentile returns the p-th percentile (0-100) of sorted values, interpolating
+// linearly between the closest ranks. sorted must not be empty.
+func Percentile(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
@@ -0,0 +1,255 @@
+// Package audiofixture synthesizes audio clips with known properties (RMS level,
+// tones, clipping) for validating the audio pipeline end to end: the expected
+// volume of every clip is computed from its ideal waveform, independently of the
+// PCM encoding the backend decodes. Intended for test rigs and companion tools,
+// alongside package client.
+package audiofixture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Tone is one sine component of a clip
+type Tone struct {
+	Frequency float64 // Hz
+	Amplitude float64 // Peak amplitude as a fraction of full scale (0-1)
+	Phase     float64 // Radians
+}
+
+// Spec describes a synthetic clip. The ideal waveform is the sum of the tones and the
+// noise, multiplied by Gain, then hard-clipped at full scale like an overdriven ADC.
+type Spec struct {
+	SampleRate    int     // Hz (default 16000)
+	Duration      float64 // Seconds (default 1)
+	BitsPerSample int     // 8 (unsigned), 16, 24, or 32 (default 16)
+	Channels      int     // Interleaved channels, each carrying the same signal (default 1)
+
+	Tones    []Tone
+	NoiseRMS float64 // White Gaussian noise RMS as a fraction of full scale
+	Seed     int64   // Noise seed; equal specs produce identical clips
+	Gain     float64 // Applied before clipping (default 1); above 1 overdrives loud tones
+}
+
+// withDefaults fills unset fields
+func (s Spec) withDefaults() Spec {
+	if s.SampleRate <= 0 {
+		s.SampleRate = 16000
+	}
+	if s.Duration <= 0 {
+		s.Duration = 1
+	}
+	if s.BitsPerSample == 0 {
+		s.BitsPerSample = 16
+	}
+	if s.Channels <= 0 {
+		s.Channels = 1
+	}
+	if s.Gain == 0 {
+		s.Gain = 1
+	}
+	return s
+}
+
+// Validate checks that a spec can be encoded
+func (s Spec) Validate() error {
+	s = s.withDefaults()
+	switch s.BitsPerSample {
+	case 8, 16, 24, 32:
+	default:
+		return fmt.Errorf("unsupported bit depth %d (want 8, 16, 24, or 32)", s.BitsPerSample)
+	}
+	for _, t := range s.Tones {
+		if t.Frequency <= 0 || t.Frequency >= float64(s.SampleRate)/2 {
+			return fmt.Errorf("tone frequency %.1f Hz must be between 0 and the Nyquist frequency (%d Hz)", t.Frequency, s.SampleRate/2)
+		}
+		if t.Amplitude < 0 {
+			return fmt.Errorf("tone amplitude %.3f must not be negative", t.Amplitude)
+		}
+	}
+	if s.NoiseRMS < 0 {
+		return fmt.Errorf("noise RMS %.3f must not be negative", s.NoiseRMS)
+	}
+	return nil
+}
+
+// Samples returns the ideal waveform, one value per frame in full-scale units (-1 to 1)
+func (s Spec) Samples() []float64 {
+	s = s.withDefaults()
+	n := int(math.Round(s.Duration * float64(s.SampleRate)))
+	rng := rand.New(rand.NewSource(s.Seed))
+
+	samples := make([]float64, n)
+	for i := range samples {
+		t := float64(i) / float64(s.SampleRate)
+		var x float64
+		for _, tone := range s.Tones {
+			x += tone.Amplitude * math.Sin(2*math.Pi*tone.Frequency*t+tone.Phase)
+		}
+		if s.NoiseRMS > 0 {
+			x += s.NoiseRMS * rng.NormFloat64()
+		}
+		samples[i] = math.Max(-1, math.Min(1, x*s.Gain))
+	}
+	return samples
+}
+
+// PCM encodes the clip as interleaved little-endian PCM
+func (s Spec) PCM() ([]byte, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	s = s.withDefaults()
+
+	bytesPerSample := s.BitsPerSample / 8
+	full := math.Ldexp(1, s.BitsPerSample-1)
+	samples := s.Samples()
+	data := make([]byte, 0, len(samples)*s.Channels*bytesPerSample)
+	for _, x := range samples {
+		// Full scale is 2^(bits-1); the positive peak clamps one step below it
+		v := int64(math.Max(-full, math.Min(full-1, math.Round(x*full))))
+		for c := 0; c < s.Channels; c++ {
+			if s.BitsPerSample == 8 {
+				data = append(data, byte(v+128))
+				continue
+			}
+			for b := 0; b < bytesPerSample; b++ {
+				data = append(data, byte(v>>(8*b)))
+			}
+		}
+	}
+	return data, nil
+}
+
+// WAV encodes the clip as a PCM WAV file
+func (s Spec) WAV() ([]byte, error) {
+	pcm, err := s.PCM()
+	if err != nil {
+		return nil, err
+	}
+	s = s.withDefaults()
+
+	blockAlign := s.Channels * s.BitsPerSample / 8
+	header := make([]byte, 44)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(36+len(pcm)))
+	copy(header[8:12], "WAVE")
+	copy(header[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16)
+	binary.LittleEndian.PutUint16(header[20:22], 1) // PCM
+	binary.LittleEndian.PutUint16(header[22:24], uint16(s.Channels))
+	binary.LittleEndian.PutUint32(header[24:28], uint32(s.SampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(s.SampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(header[32:34], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(header[34:36], uint16(s.BitsPerSample))
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], uint32(len(pcm)))
+	return append(header, pcm...), nil
+}
+
+// ExpectedRMS returns the RMS of the ideal waveform as a fraction of full scale
+func (s Spec) ExpectedRMS() float64 {
+	samples := s.Samples()
+	if len(samples) == 0 {
+		return 0
+	}
+	var sumSquares float64
+	for _, x := range samples {
+		sumSquares += x * x
+	}
+	return math.Sqrt(sumSquares / float64(len(samples)))
+}
+
+// ExpectedVolumeDB returns the volume the backend should report for the clip (dBFS),
+// within its -80 to 0 dB range
+func (s Spec) ExpectedVolumeDB() float64 {
+	rms := s.ExpectedRMS()
+	if rms <= 0 {
+		return -80
+	}
+	return math.Max(-80, math.Min(0, 20*math.Log10(rms)))
+}
+
+// ExpectedClippingRatio returns the share of samples at or beyond the given fraction
+// of full scale
+func (s Spec) ExpectedClippingRatio(threshold float64) float64 {
+	samples := s.Samples()
+	if len(samples) == 0 {
+		return 0
+	}
+	clipped := 0
+	for _, x := range samples {
+		if math.Abs(x) >= threshold {
+			clipped++
+		}
+	}
+	return float64(clipped) / float64(len(samples))
+}
+
+// ParseTone reads a tone written as "frequency:amplitude" (e.g., "1000:0.5")
+func ParseTone(v string) (Tone, error) {
+	freq, amp, ok := strings.Cut(v, ":")
+	if !ok {
+		return Tone{}, fmt.Errorf("invalid tone %q: want frequency:amplitude", v)
+	}
+	f, err := strconv.ParseFloat(freq, 64)
+	if err != nil {
+		return Tone{}, fmt.Errorf("invalid tone frequency %q: %w", freq, err)
+	}
+	a, err := strconv.ParseFloat(amp, 64)
+	if err != nil {
+		return Tone{}, fmt.Errorf("invalid tone amplitude %q: %w", amp, err)
+	}
+	return Tone{Frequency: f, Amplitude: a}, nil
+}
+
+// Golden is a clip with its analytically known volume
+type Golden struct {
+	Name     string
+	Spec     Spec
+	VolumeDB float64 // dBFS, derived by hand from the waveform
+}
+
+// Goldens returns reference clips for every supported bit depth. Their volumes are
+// closed-form: a sine of peak A has RMS A/√2; a full-scale sine overdriven 2× spends
+// 2/3 of its time pinned at full scale, giving RMS² = 4/3 - √3/π. Tones are at 997 Hz
+// (prime, like audio test gear uses) so samples sweep every phase rather than
+// repeating the same few, which would bias both formulas.
+func Goldens() []Golden {
+	clippedRMS := math.Sqrt(4.0/3.0 - math.Sqrt(3)/math.Pi)
+
+	var goldens []Golden
+	for _, bits := range []int{8, 16, 24, 32} {
+		sine := func(amplitude float64) Spec {
+			return Spec{BitsPerSample: bits, Tones: []Tone{{Frequency: 997, Amplitude: amplitude}}}
+		}
+		goldens = append(goldens,
+			Golden{fmt.Sprintf("%d-bit full-scale sine", bits), sine(1), 20 * math.Log10(1/math.Sqrt2)},
+			Golden{fmt.Sprintf("%d-bit -6 dB sine", bits), sine(0.5), 20 * math.Log10(0.5/math.Sqrt2)},
+			Golden{fmt.Sprintf("%d-bit -20 dB sine", bits), sine(0.1), 20 * math.Log10(0.1/math.Sqrt2)},
+			Golden{
+				Name: fmt.Sprintf("%d-bit two tones", bits),
+				Spec: Spec{BitsPerSample: bits, Tones: []Tone{
+					{Frequency: 443, Amplitude: 0.5},
+					{Frequency: 997, Amplitude: 0.25},
+				}},
+				VolumeDB: 10 * math.Log10((0.5*0.5+0.25*0.25)/2),
+			},
+			Golden{
+				Name:     fmt.Sprintf("%d-bit clipped sine", bits),
+				Spec:     Spec{BitsPerSample: bits, Gain: 2, Tones: []Tone{{Frequency: 997, Amplitude: 1}}},
+				VolumeDB: 20 * math.Log10(clippedRMS),
+			},
+			Golden{fmt.Sprintf("%d-bit silence", bits), Spec{BitsPerSample: bits}, -80},
+		)
+		if bits > 8 {
+			// Too close to the 8-bit quantization step to have a precise level
+			goldens = append(goldens, Golden{fmt.Sprintf("%d-bit -40 dB sine", bits), sine(0.01), 20 * math.Log10(0.01/math.Sqrt2)})
+		}
+	}
+	return goldens
+}
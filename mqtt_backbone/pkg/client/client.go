@@ -0,0 +1,308 @@
+// Package client is a thin Go client for the IoT backend. It publishes
+// synthetic sensor readings, subscribes to window commands over MQTT, and
+// queries the REST API. Intended for test rigs and companion tools.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"iot-backend/internal/mqtt"
+)
+
+// Config holds client configuration. Topic patterns use the backend defaults
+// when empty; {device_id} is replaced with the target device.
+type Config struct {
+	Broker   string // e.g., "tcp://localhost:1883", "tls://host:8883" or "wss://host/mqtt" (empty disables MQTT)
+	ClientID string
+	Username string
+	Password string
+	TLS      mqtt.TLSConfig // Certificates for a TLS broker, as the backend's MQTT_CA_FILE etc.
+
+	APIBaseURL string // e.g., "http://localhost:8080" (empty disables REST calls)
+
+	TemperatureTopic   string // Default "sensor/{device_id}/temperature"
+	HumidityTopic      string // Default "sensor/{device_id}/humidity"
+	AudioTopic         string // Default "sensor/{device_id}/audio"
+	SafetyTopic        string // Default "sensor/{device_id}/safety"
+	WindowCommandTopic string // Default "window/{device_id}/command"
+	TopicPrefix        string // Namespace matching the backend's MQTT_TOPIC_PREFIX (e.g., "site-A/")
+
+	DeviceTokens map[string]string // Registry device_token per device; readings of listed devices carry it
+
+	Timeout time.Duration // MQTT token and HTTP timeout (default 10s)
+}
+
+// Client talks to the backend over MQTT and HTTP
+type Client struct {
+	config Config
+	mqtt   paho.Client
+	http   *http.Client
+}
+
+// New creates a client and connects to the broker if one is configured
+func New(config Config) (*Client, error) {
+	applyDefaults(&config)
+
+	c := &Client{
+		config: config,
+		http:   &http.Client{Timeout: config.Timeout},
+	}
+
+	if config.Broker != "" {
+		tlsConfig, err := mqtt.BuildTLSConfig(config.Broker, config.TLS)
+		if err != nil {
+			return nil, err
+		}
+
+		opts := paho.NewClientOptions()
+		opts.AddBroker(config.Broker)
+		if tlsConfig != nil {
+			opts.SetTLSConfig(tlsConfig)
+		}
+		opts.SetClientID(config.ClientID)
+		opts.SetUsername(config.Username)
+		opts.SetPassword(config.Password)
+		opts.SetAutoReconnect(true)
+
+		c.mqtt = paho.NewClient(opts)
+		if err := c.wait(c.mqtt.Connect()); err != nil {
+			return nil, fmt.Errorf("failed to connect to MQTT broker: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// applyDefaults fills in unset configuration values
+func applyDefaults(config *Config) {
+	if config.ClientID == "" {
+		config.ClientID = fmt.Sprintf("iot-client-%d", time.Now().UnixNano())
+	}
+	if config.TemperatureTopic == "" {
+		config.TemperatureTopic = "sensor/{device_id}/temperature"
+	}
+	if config.HumidityTopic == "" {
+		config.HumidityTopic = "sensor/{device_id}/humidity"
+	}
+	if config.AudioTopic == "" {
+		config.AudioTopic = "sensor/{device_id}/audio"
+	}
+	if config.SafetyTopic == "" {
+		config.SafetyTopic = "sensor/{device_id}/safety"
+	}
+	if config.WindowCommandTopic == "" {
+		config.WindowCommandTopic = "window/{device_id}/command"
+	}
+	if prefix := strings.Trim(config.TopicPrefix, "/"); prefix != "" {
+		for _, t := range []*string{&config.TemperatureTopic, &config.HumidityTopic, &config.AudioTopic,
+			&config.SafetyTopic, &config.WindowCommandTopic} {
+			*t = prefix + "/" + *t
+		}
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	config.APIBaseURL = strings.TrimRight(config.APIBaseURL, "/")
+}
+
+// Close disconnects from the broker
+func (c *Client) Close() {
+	if c.mqtt != nil {
+		c.mqtt.Disconnect(250)
+	}
+}
+
+// wait waits for an MQTT token with the configured timeout
+func (c *Client) wait(token paho.Token) error {
+	if !token.WaitTimeout(c.config.Timeout) {
+		return fmt.Errorf("timed out after %v", c.config.Timeout)
+	}
+	return token.Error()
+}
+
+// topic expands a topic pattern for a device
+func topic(pattern, deviceID string) string {
+	return strings.ReplaceAll(pattern, "{device_id}", deviceID)
+}
+
+// publish sends a payload to a device topic, in the authentication envelope when the
+// device has a token
+func (c *Client) publish(pattern, deviceID string, payload []byte) error {
+	if c.mqtt == nil {
+		return fmt.Errorf("MQTT broker not configured")
+	}
+	if token, ok := c.config.DeviceTokens[deviceID]; ok {
+		var err error
+		if payload, err = authenticate(token, payload); err != nil {
+			return err
+		}
+	}
+	return c.wait(c.mqtt.Publish(topic(pattern, deviceID), 1, false, payload))
+}
+
+// authenticate wraps a payload with a device token, as the backend's device
+// authentication expects: JSON payloads are embedded, others as a string
+func authenticate(token string, payload []byte) ([]byte, error) {
+	inner := json.RawMessage(payload)
+	if !json.Valid(payload) {
+		encoded, err := json.Marshal(string(payload))
+		if err != nil {
+			return nil, err
+		}
+		inner = encoded
+	}
+	envelope, err := json.Marshal(struct {
+		DeviceToken string          `json:"device_token"`
+		Payload     json.RawMessage `json:"payload"`
+	}{token, inner})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal authenticated payload: %w", err)
+	}
+	return envelope, nil
+}
+
+// PublishTemperature publishes a temperature reading (Celsius) as a device would
+func (c *Client) PublishTemperature(deviceID string, value float64) error {
+	return c.publish(c.config.TemperatureTopic, deviceID, []byte(fmt.Sprintf("%.2f", value)))
+}
+
+// PublishHumidity publishes a humidity reading (percentage) as a device would
+func (c *Client) PublishHumidity(deviceID string, value float64) error {
+	return c.publish(c.config.HumidityTopic, deviceID, []byte(fmt.Sprintf("%.2f", value)))
+}
+
+// PublishAudio publishes a 16-bit PCM audio clip as a device would
+func (c *Client) PublishAudio(deviceID string, pcm []byte, sampleRate int) error {
+	payload, err := json.Marshal(AudioPayload{
+		Data:       pcm,
+		SampleRate: sampleRate,
+		Duration:   float64(len(pcm)/2) / float64(sampleRate),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal audio payload: %w", err)
+	}
+	return c.publish(c.config.AudioTopic, deviceID, payload)
+}
+
+// PublishSafetyEvent publishes a safety event (e.g., "rain" with value 1)
+func (c *Client) PublishSafetyEvent(deviceID, eventType string, value float64) error {
+	payload, err := json.Marshal(SafetyPayload{Type: eventType, Value: value})
+	if err != nil {
+		return fmt.Errorf("failed to marshal safety payload: %w", err)
+	}
+	return c.publish(c.config.SafetyTopic, deviceID, payload)
+}
+
+// SubscribeWindowCommands calls handler for every window command sent by the backend.
+// Pass "+" as deviceID to receive commands for all devices.
+func (c *Client) SubscribeWindowCommands(deviceID string, handler func(WindowCommand)) error {
+	if c.mqtt == nil {
+		return fmt.Errorf("MQTT broker not configured")
+	}
+	return c.wait(c.mqtt.Subscribe(topic(c.config.WindowCommandTopic, deviceID), 1, func(_ paho.Client, msg paho.Message) {
+		var cmd WindowCommand
+		if err := json.Unmarshal(msg.Payload(), &cmd); err != nil {
+			return
+		}
+		handler(cmd)
+	}))
+}
+
+// getJSON performs a GET request against the REST API and decodes the response
+func (c *Client) getJSON(ctx context.Context, path string, out interface{}) error {
+	_, err := c.get(ctx, path, out)
+	return err
+}
+
+// get performs a GET request, decodes the response into out, and returns its headers
+func (c *Client) get(ctx context.Context, path string, out interface{}) (http.Header, error) {
+	if c.config.APIBaseURL == "" {
+		return nil, fmt.Errorf("API base URL not configured")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.config.APIBaseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		return nil, fmt.Errorf("GET %s: %s: %s", path, resp.Status, apiErr.Error)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return resp.Header, nil
+}
+
+// listPageLimit is the page size list calls request (the backend's maximum)
+const listPageLimit = 1000
+
+// getAll fetches every page of a paged list endpoint, following the cursor the
+// backend returns in X-Next-Cursor until the last page
+func getAll[T any](ctx context.Context, c *Client, path string) ([]T, error) {
+	var items []T
+	query := url.Values{"limit": {strconv.Itoa(listPageLimit)}}
+	for {
+		var page []T
+		header, err := c.get(ctx, path+"?"+query.Encode(), &page)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+
+		next := header.Get("X-Next-Cursor")
+		if next == "" || len(page) == 0 {
+			return items, nil
+		}
+		query.Set("cursor", next)
+	}
+}
+
+// Health returns the backend health status
+func (c *Client) Health(ctx context.Context) (map[string]interface{}, error) {
+	var health map[string]interface{}
+	err := c.getJSON(ctx, "/health", &health)
+	return health, err
+}
+
+// ListDevices returns all active devices, fetching every page of the list
+func (c *Client) ListDevices(ctx context.Context) ([]Device, error) {
+	return getAll[Device](ctx, c, "/devices")
+}
+
+// GetDevice returns a single device from the registry
+func (c *Client) GetDevice(ctx context.Context, deviceID string) (*Device, error) {
+	var device Device
+	if err := c.getJSON(ctx, "/devices/"+url.PathEscape(deviceID), &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// GetDeviceState returns the latest in-memory state of a device
+func (c *Client) GetDeviceState(ctx context.Context, deviceID string) (*DeviceState, error) {
+	var st DeviceState
+	if err := c.getJSON(ctx, "/devices/"+url.PathEscape(deviceID)+"/state", &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
@@ -0,0 +1,18 @@
+package client
+
+import "iot-backend/internal/models"
+
+// Public aliases for the backend's data models, so other Go programs can
+// exchange messages with the backend without copying struct definitions.
+type (
+	TemperatureReading = models.TemperatureReading
+	HumidityReading    = models.HumidityReading
+	AudioPayload       = models.AudioPayload
+	SafetyPayload      = models.SafetyPayload
+	WindowAction       = models.WindowAction
+	WindowCommand      = models.WindowCommand
+	InferenceRequest   = models.InferenceRequest
+	InferenceResponse  = models.InferenceResponse
+	Device             = models.Device
+	DeviceState        = models.DeviceState
+)
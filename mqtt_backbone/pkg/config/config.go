@@ -8,19 +8,99 @@ import (
 	"github.com/joho/godotenv"
 )
 
+// DefaultMQTTClientID is the MQTT client ID used when MQTT_CLIENT_ID isn't set
+const DefaultMQTTClientID = "iot-backend"
+
 type Config struct {
 	// MQTT Configuration
 	MQTTBroker             string
 	MQTTClientID           string
 	MQTTUsername           string
 	MQTTPassword           string
+	MQTTTopicPrefix        string // Namespace for every topic below, e.g. "site-A/" (empty = none)
+
+	// MQTT TLS (brokers reached over tls://, ssl:// or mqtts://)
+	MQTTCAFile        string // PEM CA bundle verifying the broker (empty = system roots)
+	MQTTCertFile      string // PEM client certificate, for brokers requiring mutual TLS
+	MQTTKeyFile       string // PEM private key of the client certificate
+	MQTTTLSServerName string // Name the broker certificate is verified for (empty = the broker host)
+
+	// Reconnect backoff after losing the broker: delays double from the initial to the
+	// maximum, each spread by ±jitter (a fraction)
+	MQTTReconnectInitialMs  int
+	MQTTReconnectMaxSeconds int
+	MQTTReconnectJitter     float64
+
+	// Channel Sizing (event bus topic buffers and each consumer's queue)
+	ChannelReadingSize   int // Temperature, humidity, and presence
+	ChannelAudioSize     int // Smaller since audio is larger
+	ChannelSafetySize    int // Safety and boot events
+	ChannelInferenceSize int // Inference requests and responses
 
 	// Multi-topic MQTT configuration
 	MQTTTopicTemperature   string
 	MQTTTopicHumidity      string
 	MQTTTopicAudio         string
-	MQTTTopicInferenceReq  string
+	MQTTTopicInferenceReq  string // Comma-separated for multiple ML service instances
 	MQTTTopicWindowControl string
+	MQTTTopicSafety        string
+	MQTTTopicWindowCommand string
+	MQTTTopicShadowCommand string
+	MQTTTopicWindowAck     string // Actuator acks of window commands (empty disables ack tracking)
+	MQTTTopicBoot          string
+	MQTTTopicDeviceConfig  string
+	MQTTTopicMotion        string // PIR triggers for occupancy estimation (empty disables)
+	MQTTTopicCO2           string // CO2 readings (ppm) for occupancy estimation (empty disables)
+	MQTTTopicBackfill      string // Readings devices buffered while offline, with their timestamps (empty disables)
+	MQTTTopicAudioChunk    string // Clips uploaded in resumable chunks (empty disables)
+	MQTTTopicAudioControl  string // Per-device NACKs and completions of chunked audio uploads
+	MQTTTenant             string // Value of {tenant} in topics; topics may also use {device_id}, {sensor_type}, {window_id}
+
+	// Backend availability (retained, with last will; empty topic disables)
+	MQTTTopicBackendStatus       string
+	BackendStatusIntervalSeconds int
+
+	// LoRaWAN uplinks via the network server's MQTT integration (empty topic disables)
+	MQTTTopicLoRaWAN    string // e.g., "v3/+/devices/+/up" (TTN) or "application/+/device/+/event/up" (ChirpStack)
+	LoRaWANCodecs       string // Per-device frame layouts, "device=layout;device=layout"
+	LoRaWANDefaultCodec string // Frame layout for devices without their own codec
+	LoRaWANByteOrder    string // "big" (LoRaWAN convention) or "little"
+
+	// ML service routing (applies when several inference request topics are configured)
+	MQTTInferenceRouting        string // round_robin, sticky, or least_inflight
+	MQTTInferenceTimeoutSeconds int    // Unanswered requests are recorded as ML timeouts after this
+
+	// Window command delivery (QoS 2, with command IDs acknowledged on MQTTTopicWindowAck)
+	WindowCommandAckTimeoutSeconds int     // Unacknowledged commands are resent after this (0 disables resending)
+	WindowCommandMaxAttempts       int     // Publishes per command before it's recorded as expired
+	WindowCommandPositionTolerance float64 // Reported positions further than this from the command are mismatches (%)
+	WindowCommandTTLSeconds        int     // Commands carry an expiry this long after they're issued (0 = never expire)
+
+	// Additional ML models (e.g., noise, security) beside the window model, each with its own topics and features
+	MLModels string // "name=request_topic>response_topic[:feature,...];..." (empty = window model only)
+
+	// Inference domains (e.g., thermal comfort, noise), each with its own triggers and window model; their positions are merged
+	InferenceDomains           string // Same format as MLModels; the features include the metrics that trigger the domain (empty = one request per device)
+	InferenceDomainHoldMinutes int    // How long a domain's position stays in the merge after its last response
+
+	// Message tracing (debug; empty rates disable)
+	MQTTTraceRates           string // Sampling rate per subscription filter, "filter=rate,..." ("*" = all others)
+	MQTTTraceFile            string // JSON-lines file for traces instead of the message_traces table
+	MQTTTraceMaxPayloadBytes int    // Longer payloads are truncated in traces
+
+	// Per-device message statistics
+	DeviceStatsFlushSeconds int // How often per-device message counts are stored in device_stats (0 disables storing)
+
+	// Payload encryption (per-device AES-GCM keys at config.payload_key in the registry)
+	PayloadEncryptionRequired bool // Reject plaintext audio even from devices without a key
+
+	// Device authentication (per-device tokens at config.device_token in the registry)
+	DeviceAuthRequired bool // Reject messages without a valid token even from devices without one
+
+	// Packed binary frame configuration (empty layout disables the frame topic)
+	MQTTTopicFrame         string
+	MQTTFrameLayout        string
+	MQTTFrameByteOrder     string
 
 	// Legacy topics (for backward compatibility)
 	MQTTTopicSensor        string
@@ -32,14 +112,242 @@ type Config struct {
 	ClickHouseUser         string
 	ClickHousePass         string
 
+	// ClickHouse Cluster Configuration (optional)
+	ClickHouseCluster       string
+	ClickHouseZooKeeperPath string
+	ClickHouseReplicaName   string
+
+	// ClickHouse Connection Pools (writes use ClickHouseAddr)
+	ClickHouseReadAddr          string // Hosts for aggregate queries, e.g. read replicas (empty = ClickHouseAddr)
+	ClickHouseWriteMaxOpenConns int    // 0 = driver default
+	ClickHouseWriteMaxIdleConns int
+	ClickHouseReadMaxOpenConns  int
+	ClickHouseReadMaxIdleConns  int
+
+	// ClickHouse Failed Inserts
+	ClickHouseFailedInsertsFile string // JSON lines fallback when failed_inserts itself can't be written (empty = log only)
+
+	// ClickHouse Schema Management
+	ClickHouseInitSchema        bool // Create and migrate tables on startup (disable where the app has no DDL rights)
+	ClickHouseSchemaConcurrency int  // DDL statements run at once during schema initialization
+
 	// ML Model Configuration
 	ModelPath              string
+	ModelVariants          string // Seasonal / outdoor temperature variants, "winter=path;cold=path@..5;hot=path@25.."
+	ModelSeasonHemisphere  string // "north" or "south", for the seasons of variants named after one
+	ModelOutdoorMinutes    int    // Outdoor temperatures older than this don't select variants
 
 	// CQRS Inference Configuration
 	InferencePollingIntervalSeconds int     // How often to poll ClickHouse (seconds)
+	InferencePollingMode            string  // "staggered" spreads device checks across the interval, "burst" checks all at each tick
 	InferenceDataWindowSeconds      int     // Time window for querying current data (seconds)
 	InferenceHistoricalBaselineDays int     // Days of historical data for std dev calculation
 	InferenceZScoreThreshold        float64 // Z-score threshold for triggering inference
+	InferenceHourlyBaseline         bool    // Z-scores against per-hour-of-day baselines (false = flat std dev)
+	InferenceMinHourSamples         int     // Minimum readings in an hour of the day before its baseline is used
+	InferenceProfileRefreshMinutes  int     // How long a device's hourly baseline profile is cached (minutes)
+	InferenceDriftWindowSeconds     int     // Window for the sustained drift regression (seconds, 0 disables)
+	InferenceDriftThreshold         float64 // Drift since the last inference, in baseline std devs, that triggers inference
+	InferenceDriftMinSamples        int     // Minimum readings in the drift window per metric
+	InferenceMinBaselineSamples     int     // Minimum baseline samples before triggering
+	InferenceColdStartMaxPerPoll    int     // Maximum cold-start triggers per poll cycle
+	InferenceColdStartJitterSeconds int     // Random spread for cold-start triggers (seconds)
+	InferenceManualCooldownSeconds  int     // Manual triggers within this long of the last inference are refused unless forced
+	InferenceCacheEnabled           bool    // Answer requests whose features haven't changed with the last response
+	InferenceCacheEpsilon           float64 // Largest change of a 0-1 index feature (mold risk, occupancy) that still counts as unchanged
+	InferenceCacheEpsilonTemperature float64 // Same for temperature and its lags (°C)
+	InferenceCacheEpsilonHumidity    float64 // Same for humidity and its lags (%RH)
+	InferenceCacheEpsilonSoundVolume float64 // Same for sound volume and its lags (dB)
+	InferenceCacheMaxAgeSeconds     int     // Responses older than this are never reused
+
+	// Threshold Suggestions (recommended z-scores per device and metric, served by the API)
+	ThresholdSuggestionDays         int     // Days of readings analyzed
+	ThresholdTargetTriggersPerDay   float64 // Desired inference triggers per device per day
+	ThresholdSuggestionRefreshHours int     // How often suggestions are recomputed (0 disables)
+
+	// Window Usage Analytics (open time, position by hour of day, correlation with temperature and noise)
+	WindowAnalyticsIntervalMinutes int // How often recent days are recomputed (0 disables)
+	WindowAnalyticsBackfillDays    int // Days computed at startup
+
+	// Sensor Cross-Validation (devices sharing a room compared hourly; a persistent outlier raises an alert)
+	SensorCrossCheckEnabled      bool    // Compare the hourly means of devices with the same room tag
+	SensorCrossCheckRoomTag      string  // Device tag naming the room
+	SensorCrossCheckWindowHours  int     // Recent hours a divergence must persist over
+	SensorCrossCheckMinNeighbors int     // Other reporting devices a device is compared with (at least 2)
+	SensorCrossCheckTemperatureC float64 // Normal distance from the room's median temperature (°C)
+	SensorCrossCheckHumidity     float64 // Normal distance from the room's median humidity (% RH)
+
+	// Sensor Readings Roll-up (one wide row per device-minute in the legacy sensor_readings table)
+	SensorReadingsRollupEnabled   bool // Roll up temperature, humidity and volume per minute
+	SensorReadingsLatenessMinutes int  // Recent minutes rolled up again for late readings
+	SensorReadingsBackfillHours   int  // How far back the roll-up catches up at startup
+
+	// Lag Features (t-15m, t-1h and t-24h means per metric and device, shared by inference and training)
+	LagFeaturesEnabled         bool // Maintain the lag_features table and add its lags to inference requests
+	LagFeaturesLatenessMinutes int  // Recent minutes computed again for late readings (below 15)
+	LagFeaturesBackfillHours   int  // How far back the table catches up at startup
+
+	// Storage Report (table sizes and rows/bytes per day per table and device, logged periodically)
+	StorageReportIntervalHours int // How often the summary is logged (0 disables)
+	StorageReportDays          int // Days ingestion rates are averaged over
+	StorageReportTopDevices    int // Devices with the highest ingestion listed
+
+	// Safety Event Configuration
+	SafetyMaxLatencyMs int // Processing budget for safety events (milliseconds)
+	SafetyHoldMinutes  int // Window stays closed this long after the last safety event
+
+	// Frost Protection (defaults; devices override them with a "frost" registry config object)
+	FrostProtectionEnabled bool    // Cap and then close windows near freezing
+	FrostCapTemperature    float64 // At or below this (°C) windows open at most FrostMaxPosition
+	FrostCloseTemperature  float64 // At or below this (°C) windows are forced closed
+	FrostMaxPosition       float64 // Maximum opening (%) while capped
+	FrostHysteresis        float64 // Degrees above a threshold before its restriction is lifted
+	FrostHoldMinutes       int     // A restriction expires this long after the last cold reading
+
+	// Actuation Budget (defaults; devices override them with an "actuation_budget" registry config object)
+	ActuationMaxMovements int     // Window movements per local day from schedules, rules, and ML (0 = unlimited)
+	ActuationMaxTravel    float64 // Summed position change (%) per local day (0 = unlimited)
+
+	// Local Automations
+	AutomationsFile         string // YAML automations evaluated by the backend (empty disables)
+	AutomationsStaleMinutes int    // Devices without a reading for this long are not automated
+
+	// Scheduled Commands
+	ScheduleMissedGraceMinutes int // Commands later than this (e.g., after downtime) are marked missed instead of executed
+
+	// Actuator Configuration
+	ActuatorDryRun bool // Compute and store window commands without actuating
+
+	// Spike Rejection
+	SuppressOutliers bool // Keep spikes out of the sensor tables (quarantine only)
+
+	// Sensor Processing
+	SensorWorkersPerType int // Per-device shards per sensor type
+
+	// Backfill (offline buffers replayed on MQTTTopicBackfill)
+	BackfillMaxAgeHours  int // Older buffered readings are rejected
+	BackfillDedupMinutes int // Identical batches from a device within this window are stored once (0 disables)
+
+	// Mold Risk
+	MoldRiskHumidityThreshold float64 // RH (%) above which mold risk accumulates
+	MoldRiskSustainedHours    float64 // Hours of risk conditions for a full risk index
+	MoldRiskAlertThreshold    float64 // Risk index (0-1) that raises an alert
+
+	// Noise Exposure Alerts
+	NoiseExposureLevel       float64 // dB that must be exceeded for a sustained period (0 disables)
+	NoiseExposurePercentile  string  // Clip statistic compared with the level: l10, l50, l90, or leq
+	NoiseExposureMinutes     int     // Minutes above the level before alerting
+	NoiseExposureQuietHours  string  // Local hours watched, "start-end" (e.g., "22-7"); empty watches all day
+	NoiseExposureCloseWindow bool    // Close the window while the exposure lasts
+
+	// Audio Anomaly Scoring
+	AudioAnomalyThreshold      float64 // Clip score (RMS z-score) above which a clip is anomalous
+	AudioAnomalySustainedClips int     // Consecutive anomalous clips that raise an alert
+
+	// Audio Format Checks
+	AudioSampleRates       string  // Supported sample rates in Hz, comma-separated
+	AudioDurationTolerance float64 // Allowed relative difference between declared and actual clip duration
+	AudioDownmix           string  // Reduction of multi-channel clips to mono: average, loudest, or first
+
+	// Audio Clipping and AGC
+	AudioClippingThreshold int  // Absolute 16-bit sample value above which a sample counts as clipped
+	AudioAGCCompensation   bool // Subtract the microphone AGC gain (clip's agc_gain_db, else the registry's) from levels
+
+	// Audio Deduplication
+	AudioDedupWindowSeconds int // Identical clips from a device within this window are dropped (0 disables)
+
+	// Deferred Audio Feature Extraction (clips are stored first, features extracted by background workers)
+	AudioClipStoreDir      string // Directory (or mounted bucket) clips wait in (empty extracts inline)
+	AudioExtractionWorkers int    // Concurrent extractions
+
+	// Audio Spillover (clips the sensor service can't take in time wait on disk instead of being dropped)
+	AudioSpillDir      string // Empty drops clips when the audio queue is full
+	AudioSpillMaxClips int    // Clips dropped beyond this
+
+	// Chunked Audio Uploads (on MQTTTopicAudioChunk, NACKed on MQTTTopicAudioControl)
+	AudioUploadDir         string // Directory chunks wait in until their clip is complete, across restarts
+	AudioUploadNackSeconds int    // An incomplete upload without new chunks for this long is NACKed
+	AudioUploadMaxNacks    int    // NACKs without progress before an upload is abandoned
+	AudioUploadMaxChunks   int    // Chunks per clip
+	AudioUploadMaxBytes    int    // Size of a reassembled clip
+
+	// Noise Floor Calibration
+	NoiseFloorCalibrationHours int // Rolling window each device's quiet baseline is learned over
+
+	// Occupancy Estimation
+	OccupancyPrior               float64 // Probability a room is occupied before any evidence (0-1)
+	OccupancySaveIntervalSeconds int     // Minimum time between stored estimates per device
+
+	// Alerts
+	AlertCooldownMinutes int    // Minimum time between repeats of the same alert per device
+	AlertRules           string // Tag scoping per alert type, "type:key=value,key=value;type:key=value"
+
+	// Email alerts (enabled when SMTPHost and AlertEmailRoutes are set)
+	SMTPHost         string
+	SMTPPort         int
+	SMTPUsername     string
+	SMTPPassword     string
+	SMTPFrom         string
+	AlertEmailRoutes string // Per-recipient routing, "recipient:type,type[:key=value,...];..." ("*" = all types)
+
+	// End-to-End Canary (synthetic readings followed through persistence and inference)
+	CanaryEnabled         bool
+	CanaryDeviceID        string // Reserved device ID the readings are published as
+	CanaryDeviceToken     string // Token of the canary in the device registry (empty sends bare payloads)
+	CanaryIntervalSeconds int    // Time between runs
+	CanaryDeadlineSeconds int    // A run not answered by the ML service within this raises an alert
+
+	// Device Config Sync
+	DeviceDefaultSamplingSeconds int // Sampling interval sent to devices without a stored one
+
+	// Startup
+	PreflightOnStartup bool // Run dependency checks before serving; failures abort startup
+
+	// Instance Role
+	InstanceRole string // "primary", or "observer": subscribes and serves the API but never writes to ClickHouse or publishes
+
+	// REST API
+	APIAddr                string // Listen address (empty disables the API)
+	APICommandToken        string // Bearer token of the WebSocket command channel (empty disables it)
+	APIIngestEnabled       bool   // Accept readings posted to POST /readings
+	APIQueryTimeoutSeconds int    // Execution time of one ClickHouse query run for a request (0 = the pool's 60 s)
+	APIQueryMaxRowsRead    int    // Rows one query for a request may scan (0 = unlimited)
+	APIQueryMaxResultRows  int    // Rows one query for a request may return (0 = unlimited)
+
+	// mDNS Discovery (the broker and API advertised as _mqtt._tcp and _http._tcp on the LAN)
+	MDNSEnabled    bool   // Answer mDNS queries so devices find the backend without hardcoded addresses
+	MDNSInstance   string // Service instance name
+	MDNSHostname   string // Name of this host in .local (empty = the OS host name)
+	MDNSInterface  string // Interface to advertise on (empty = the default multicast interface)
+	MDNSBrokerHost string // Broker host devices should connect to (empty = the MQTT_BROKER host, this host if loopback)
+
+	// Gateway Ingestion (gRPC streams from gateways aggregating many devices)
+	GatewayGRPCAddr            string // Listen address (empty disables gateway ingestion)
+	GatewayGRPCTokens          string // Bearer token of each gateway, "gateway_id=token;..."
+	GatewayGRPCMaxMessageBytes int    // Largest accepted reading batch
+	GatewayGRPCTLSCertFile     string // Server certificate (empty serves HTTP/2 without TLS)
+	GatewayGRPCTLSKeyFile      string // Server private key
+	GatewayGRPCClientCAFile    string // CA gateway client certificates must be signed by (mutual TLS)
+	GatewayGRPCInsecure        bool   // Accept gateways without a token or client certificate (safety events are refused)
+	GatewayGRPCReleaseMinutes  int    // A device bound to a gateway moves to another only after the first has been gone this long
+
+	// Reading Simulator (made-up devices fed through the ingestion pipeline, for trying a deployment without hardware)
+	SimulatorDevices         int // Number of simulated devices (0 disables the simulator)
+	SimulatorIntervalSeconds int // Time between readings of a simulated device
+
+	// Metrics
+	MetricsLogIntervalSeconds int // How often to log a metrics summary (0 disables)
+	MQTTSlowBrokerMs          int // Broker round-trip above which a warning is logged
+
+	// Device State Persistence
+	DeviceStateFile                string // Snapshot file (empty disables persistence)
+	DeviceStateSaveIntervalSeconds int    // How often to snapshot device state
+	DeviceStateShared              bool   // Also hand state off through ClickHouse, for replicas that rebalance devices
+	DeviceStateHandoffIdleSeconds  int    // Idle time after which a device's shared state is read again
+
+	// Hot Reading Store (recent readings kept in memory so short ranges skip ClickHouse)
+	HotStoreMinutes     int // How far back readings are kept (0 disables)
+	HotStoreMaxReadings int // Per device and metric
 
 	// Legacy Change Detection Thresholds (deprecated in CQRS model)
 	TemperatureThreshold   float64
@@ -54,9 +362,26 @@ func Load() *Config {
 	return &Config{
 		// MQTT Configuration
 		MQTTBroker:             getEnv("MQTT_BROKER", "tcp://localhost:1883"),
-		MQTTClientID:           getEnv("MQTT_CLIENT_ID", "iot-backend"),
+		MQTTClientID:           getEnv("MQTT_CLIENT_ID", DefaultMQTTClientID),
 		MQTTUsername:           getEnv("MQTT_USERNAME", ""),
 		MQTTPassword:           getEnv("MQTT_PASSWORD", ""),
+		MQTTTopicPrefix:        getEnv("MQTT_TOPIC_PREFIX", ""),
+
+		// MQTT TLS
+		MQTTCAFile:        getEnv("MQTT_CA_FILE", ""),
+		MQTTCertFile:      getEnv("MQTT_CERT_FILE", ""),
+		MQTTKeyFile:       getEnv("MQTT_KEY_FILE", ""),
+		MQTTTLSServerName: getEnv("MQTT_TLS_SERVER_NAME", ""),
+
+		MQTTReconnectInitialMs:  getEnvInt("MQTT_RECONNECT_INITIAL_MS", 1000),
+		MQTTReconnectMaxSeconds: getEnvInt("MQTT_RECONNECT_MAX_SECONDS", 120),
+		MQTTReconnectJitter:     getEnvFloat("MQTT_RECONNECT_JITTER", 0.2),
+
+		// Channel Sizing
+		ChannelReadingSize:   getEnvInt("CHANNEL_READING_SIZE", 100),
+		ChannelAudioSize:     getEnvInt("CHANNEL_AUDIO_SIZE", 50),
+		ChannelSafetySize:    getEnvInt("CHANNEL_SAFETY_SIZE", 20),
+		ChannelInferenceSize: getEnvInt("CHANNEL_INFERENCE_SIZE", 50),
 
 		// Multi-topic MQTT configuration
 		MQTTTopicTemperature:   getEnv("MQTT_TOPIC_TEMPERATURE", "sensor/+/temperature"),
@@ -64,6 +389,64 @@ func Load() *Config {
 		MQTTTopicAudio:         getEnv("MQTT_TOPIC_AUDIO", "sensor/+/audio"),
 		MQTTTopicInferenceReq:  getEnv("MQTT_TOPIC_INFERENCE_REQ", "ml/inference/request/{device_id}"),
 		MQTTTopicWindowControl: getEnv("MQTT_TOPIC_WINDOW_CONTROL", "window/+/control"),
+		MQTTTopicSafety:        getEnv("MQTT_TOPIC_SAFETY", "sensor/+/safety"),
+		MQTTTopicWindowCommand: getEnv("MQTT_TOPIC_WINDOW_COMMAND", "window/{device_id}/command"),
+		MQTTTopicShadowCommand: getEnv("MQTT_TOPIC_SHADOW_COMMAND", ""),
+		MQTTTopicWindowAck:     getEnv("MQTT_TOPIC_WINDOW_ACK", ""),
+		MQTTTopicBoot:          getEnv("MQTT_TOPIC_BOOT", "sensor/+/boot"),
+		MQTTTopicDeviceConfig:  getEnv("MQTT_TOPIC_DEVICE_CONFIG", "device/{device_id}/config"),
+		MQTTTopicMotion:        getEnv("MQTT_TOPIC_MOTION", "sensor/+/motion"),
+		MQTTTopicCO2:           getEnv("MQTT_TOPIC_CO2", "sensor/+/co2"),
+		MQTTTopicBackfill:      getEnv("MQTT_TOPIC_BACKFILL", "sensor/+/backfill"),
+		MQTTTopicAudioChunk:    getEnv("MQTT_TOPIC_AUDIO_CHUNK", "sensor/+/audio/chunk"),
+		MQTTTopicAudioControl:  getEnv("MQTT_TOPIC_AUDIO_CONTROL", "device/{device_id}/audio/control"),
+		MQTTTenant:             getEnv("MQTT_TENANT", ""),
+
+		// Backend availability
+		MQTTTopicBackendStatus:       getEnv("MQTT_TOPIC_BACKEND_STATUS", "backend/status"),
+		BackendStatusIntervalSeconds: getEnvInt("BACKEND_STATUS_INTERVAL_SECONDS", 60),
+
+		// LoRaWAN uplinks
+		MQTTTopicLoRaWAN:    getEnv("MQTT_TOPIC_LORAWAN", ""),
+		LoRaWANCodecs:       getEnv("LORAWAN_CODECS", ""),
+		LoRaWANDefaultCodec: getEnv("LORAWAN_DEFAULT_CODEC", ""),
+		LoRaWANByteOrder:    getEnv("LORAWAN_BYTE_ORDER", "big"),
+
+		// ML service routing
+		MQTTInferenceRouting:        getEnv("MQTT_INFERENCE_ROUTING", "round_robin"),
+		MQTTInferenceTimeoutSeconds: getEnvInt("MQTT_INFERENCE_TIMEOUT_SECONDS", 120),
+
+		// Window command delivery
+		WindowCommandAckTimeoutSeconds: getEnvInt("WINDOW_COMMAND_ACK_TIMEOUT_SECONDS", 30),
+		WindowCommandMaxAttempts:       getEnvInt("WINDOW_COMMAND_MAX_ATTEMPTS", 3),
+		WindowCommandPositionTolerance: getEnvFloat("WINDOW_COMMAND_POSITION_TOLERANCE", 2.0),
+		WindowCommandTTLSeconds:        getEnvInt("WINDOW_COMMAND_TTL_SECONDS", 300),
+
+		// Additional ML models
+		MLModels: getEnv("ML_MODELS", ""),
+
+		// Inference domains
+		InferenceDomains:           getEnv("INFERENCE_DOMAINS", ""),
+		InferenceDomainHoldMinutes: getEnvInt("INFERENCE_DOMAIN_HOLD_MINUTES", 30),
+
+		// Message tracing
+		MQTTTraceRates:           getEnv("MQTT_TRACE_RATES", ""),
+		MQTTTraceFile:            getEnv("MQTT_TRACE_FILE", ""),
+		MQTTTraceMaxPayloadBytes: getEnvInt("MQTT_TRACE_MAX_PAYLOAD_BYTES", 4096),
+
+		// Per-device message statistics
+		DeviceStatsFlushSeconds: getEnvInt("DEVICE_STATS_FLUSH_SECONDS", 300),
+
+		// Payload encryption
+		PayloadEncryptionRequired: getEnvBool("PAYLOAD_ENCRYPTION_REQUIRED", false),
+
+		// Device authentication
+		DeviceAuthRequired: getEnvBool("DEVICE_AUTH_REQUIRED", false),
+
+		// Packed binary frames, e.g. "temperature:int16:0.01,humidity:uint16:0.01"
+		MQTTTopicFrame:         getEnv("MQTT_TOPIC_FRAME", "sensor/+/frame"),
+		MQTTFrameLayout:        getEnv("MQTT_FRAME_LAYOUT", ""),
+		MQTTFrameByteOrder:     getEnv("MQTT_FRAME_BYTE_ORDER", "little"),
 
 		// Legacy topics
 		MQTTTopicSensor:        getEnv("MQTT_TOPIC_SENSOR", "sensor/data"),
@@ -75,14 +458,242 @@ func Load() *Config {
 		ClickHouseUser:         getEnv("CLICKHOUSE_USER", "default"),
 		ClickHousePass:         getEnv("CLICKHOUSE_PASS", ""),
 
+		// ClickHouse Cluster Configuration (empty cluster = single host)
+		ClickHouseCluster:       getEnv("CLICKHOUSE_CLUSTER", ""),
+		ClickHouseZooKeeperPath: getEnv("CLICKHOUSE_ZOOKEEPER_PATH", "/clickhouse/tables/{shard}/{database}/{table}"),
+		ClickHouseReplicaName:   getEnv("CLICKHOUSE_REPLICA_NAME", "{replica}"),
+
+		// ClickHouse Connection Pools
+		ClickHouseReadAddr:          getEnv("CLICKHOUSE_READ_ADDR", ""),
+		ClickHouseWriteMaxOpenConns: getEnvInt("CLICKHOUSE_WRITE_MAX_OPEN_CONNS", 0),
+		ClickHouseWriteMaxIdleConns: getEnvInt("CLICKHOUSE_WRITE_MAX_IDLE_CONNS", 0),
+		ClickHouseReadMaxOpenConns:  getEnvInt("CLICKHOUSE_READ_MAX_OPEN_CONNS", 0),
+		ClickHouseReadMaxIdleConns:  getEnvInt("CLICKHOUSE_READ_MAX_IDLE_CONNS", 0),
+
+		// ClickHouse Failed Inserts
+		ClickHouseFailedInsertsFile: getEnv("CLICKHOUSE_FAILED_INSERTS_FILE", ""),
+
+		// ClickHouse Schema Management
+		ClickHouseInitSchema:        getEnvBool("CLICKHOUSE_INIT_SCHEMA", true),
+		ClickHouseSchemaConcurrency: getEnvInt("CLICKHOUSE_SCHEMA_CONCURRENCY", 4),
+
 		// ML Model Configuration
 		ModelPath:              getEnv("MODEL_PATH", "./model/regression_model.json"),
+		ModelVariants:          getEnv("MODEL_VARIANTS", ""),
+		ModelSeasonHemisphere:  getEnv("MODEL_SEASON_HEMISPHERE", "north"),
+		ModelOutdoorMinutes:    getEnvInt("MODEL_OUTDOOR_MAX_AGE_MINUTES", 60),
 
 		// CQRS Inference Configuration
 		InferencePollingIntervalSeconds: getEnvInt("INFERENCE_POLLING_INTERVAL_SECONDS", 60),
+		InferencePollingMode:            getEnv("INFERENCE_POLLING_MODE", "staggered"),
 		InferenceDataWindowSeconds:      getEnvInt("INFERENCE_DATA_WINDOW_SECONDS", 120),
 		InferenceHistoricalBaselineDays: getEnvInt("INFERENCE_HISTORICAL_BASELINE_DAYS", 7),
 		InferenceZScoreThreshold:        getEnvFloat("INFERENCE_Z_SCORE_THRESHOLD", 1.5),
+		InferenceHourlyBaseline:         getEnvBool("INFERENCE_HOURLY_BASELINE", true),
+		InferenceMinHourSamples:         getEnvInt("INFERENCE_MIN_HOUR_SAMPLES", 10),
+		InferenceProfileRefreshMinutes:  getEnvInt("INFERENCE_PROFILE_REFRESH_MINUTES", 60),
+		InferenceDriftWindowSeconds:     getEnvInt("INFERENCE_DRIFT_WINDOW_SECONDS", 3600),
+		InferenceDriftThreshold:         getEnvFloat("INFERENCE_DRIFT_THRESHOLD", 2.0),
+		InferenceDriftMinSamples:        getEnvInt("INFERENCE_DRIFT_MIN_SAMPLES", 10),
+		InferenceMinBaselineSamples:     getEnvInt("INFERENCE_MIN_BASELINE_SAMPLES", 30),
+		InferenceColdStartMaxPerPoll:    getEnvInt("INFERENCE_COLD_START_MAX_PER_POLL", 5),
+		InferenceColdStartJitterSeconds: getEnvInt("INFERENCE_COLD_START_JITTER_SECONDS", 10),
+		InferenceManualCooldownSeconds:  getEnvInt("INFERENCE_MANUAL_COOLDOWN_SECONDS", 60),
+		InferenceCacheEnabled:           getEnvBool("INFERENCE_CACHE_ENABLED", false),
+		InferenceCacheEpsilon:           getEnvFloat("INFERENCE_CACHE_EPSILON", 0.02),
+		InferenceCacheEpsilonTemperature: getEnvFloat("INFERENCE_CACHE_EPSILON_TEMPERATURE", 0.05),
+		InferenceCacheEpsilonHumidity:    getEnvFloat("INFERENCE_CACHE_EPSILON_HUMIDITY", 0.5),
+		InferenceCacheEpsilonSoundVolume: getEnvFloat("INFERENCE_CACHE_EPSILON_SOUND_VOLUME", 1.0),
+		InferenceCacheMaxAgeSeconds:     getEnvInt("INFERENCE_CACHE_MAX_AGE_SECONDS", 3600),
+
+		// Threshold Suggestions
+		ThresholdSuggestionDays:         getEnvInt("THRESHOLD_SUGGESTION_DAYS", 7),
+		ThresholdTargetTriggersPerDay:   getEnvFloat("THRESHOLD_TARGET_TRIGGERS_PER_DAY", 24),
+		ThresholdSuggestionRefreshHours: getEnvInt("THRESHOLD_SUGGESTION_REFRESH_HOURS", 6),
+
+		// Window Usage Analytics
+		WindowAnalyticsIntervalMinutes: getEnvInt("WINDOW_ANALYTICS_INTERVAL_MINUTES", 60),
+		WindowAnalyticsBackfillDays:    getEnvInt("WINDOW_ANALYTICS_BACKFILL_DAYS", 7),
+
+		// Sensor Cross-Validation
+		SensorCrossCheckEnabled:      getEnvBool("SENSOR_CROSSCHECK_ENABLED", false),
+		SensorCrossCheckRoomTag:      getEnv("SENSOR_CROSSCHECK_ROOM_TAG", "room"),
+		SensorCrossCheckWindowHours:  getEnvInt("SENSOR_CROSSCHECK_WINDOW_HOURS", 24),
+		SensorCrossCheckMinNeighbors: getEnvInt("SENSOR_CROSSCHECK_MIN_NEIGHBORS", 2),
+		SensorCrossCheckTemperatureC: getEnvFloat("SENSOR_CROSSCHECK_TEMPERATURE_C", 1.5),
+		SensorCrossCheckHumidity:     getEnvFloat("SENSOR_CROSSCHECK_HUMIDITY", 8),
+
+		// Sensor Readings Roll-up
+		SensorReadingsRollupEnabled:   getEnvBool("SENSOR_READINGS_ROLLUP_ENABLED", false),
+		SensorReadingsLatenessMinutes: getEnvInt("SENSOR_READINGS_LATENESS_MINUTES", 5),
+		SensorReadingsBackfillHours:   getEnvInt("SENSOR_READINGS_BACKFILL_HOURS", 24),
+
+		// Lag Features
+		LagFeaturesEnabled:         getEnvBool("LAG_FEATURES_ENABLED", false),
+		LagFeaturesLatenessMinutes: getEnvInt("LAG_FEATURES_LATENESS_MINUTES", 5),
+		LagFeaturesBackfillHours:   getEnvInt("LAG_FEATURES_BACKFILL_HOURS", 48),
+
+		// Storage Report
+		StorageReportIntervalHours: getEnvInt("STORAGE_REPORT_INTERVAL_HOURS", 24),
+		StorageReportDays:          getEnvInt("STORAGE_REPORT_DAYS", 7),
+		StorageReportTopDevices:    getEnvInt("STORAGE_REPORT_TOP_DEVICES", 5),
+
+		// Safety Event Configuration
+		SafetyMaxLatencyMs: getEnvInt("SAFETY_MAX_LATENCY_MS", 500),
+		SafetyHoldMinutes:  getEnvInt("SAFETY_HOLD_MINUTES", 15),
+
+		// Frost Protection
+		FrostProtectionEnabled: getEnvBool("FROST_PROTECTION_ENABLED", true),
+		FrostCapTemperature:    getEnvFloat("FROST_CAP_TEMPERATURE", 3),
+		FrostCloseTemperature:  getEnvFloat("FROST_CLOSE_TEMPERATURE", 0),
+		FrostMaxPosition:       getEnvFloat("FROST_MAX_POSITION", 20),
+		FrostHysteresis:        getEnvFloat("FROST_HYSTERESIS", 1),
+		FrostHoldMinutes:       getEnvInt("FROST_HOLD_MINUTES", 60),
+
+		// Actuation Budget
+		ActuationMaxMovements: getEnvInt("ACTUATION_MAX_MOVEMENTS", 0),
+		ActuationMaxTravel:    getEnvFloat("ACTUATION_MAX_TRAVEL", 0),
+
+		// Local Automations
+		AutomationsFile:         getEnv("AUTOMATIONS_FILE", ""),
+		AutomationsStaleMinutes: getEnvInt("AUTOMATIONS_STALE_MINUTES", 10),
+
+		// Scheduled Commands
+		ScheduleMissedGraceMinutes: getEnvInt("SCHEDULE_MISSED_GRACE_MINUTES", 15),
+
+		// Actuator Configuration
+		ActuatorDryRun: getEnvBool("ACTUATOR_DRY_RUN", false),
+
+		// Spike Rejection
+		SuppressOutliers: getEnvBool("SUPPRESS_OUTLIERS", false),
+
+		// Sensor Processing
+		SensorWorkersPerType: getEnvInt("SENSOR_WORKERS_PER_TYPE", 4),
+
+		// Backfill
+		BackfillMaxAgeHours:  getEnvInt("BACKFILL_MAX_AGE_HOURS", 168),
+		BackfillDedupMinutes: getEnvInt("BACKFILL_DEDUP_MINUTES", 60),
+
+		// Mold Risk
+		MoldRiskHumidityThreshold: getEnvFloat("MOLD_RISK_HUMIDITY_THRESHOLD", 70.0),
+		MoldRiskSustainedHours:    getEnvFloat("MOLD_RISK_SUSTAINED_HOURS", 6.0),
+		MoldRiskAlertThreshold:    getEnvFloat("MOLD_RISK_ALERT_THRESHOLD", 0.8),
+
+		// Noise Exposure Alerts
+		NoiseExposureLevel:       getEnvFloat("NOISE_EXPOSURE_LEVEL", 0),
+		NoiseExposurePercentile:  getEnv("NOISE_EXPOSURE_PERCENTILE", "l50"),
+		NoiseExposureMinutes:     getEnvInt("NOISE_EXPOSURE_MINUTES", 30),
+		NoiseExposureQuietHours:  getEnv("NOISE_EXPOSURE_QUIET_HOURS", "22-7"),
+		NoiseExposureCloseWindow: getEnvBool("NOISE_EXPOSURE_CLOSE_WINDOW", false),
+
+		// Audio Anomaly Scoring
+		AudioAnomalyThreshold:      getEnvFloat("AUDIO_ANOMALY_THRESHOLD", 3.0),
+		AudioAnomalySustainedClips: getEnvInt("AUDIO_ANOMALY_SUSTAINED_CLIPS", 3),
+
+		// Audio Format Checks
+		AudioSampleRates:       getEnv("AUDIO_SUPPORTED_SAMPLE_RATES", "8000,16000,22050,32000,44100,48000"),
+		AudioDurationTolerance: getEnvFloat("AUDIO_DURATION_TOLERANCE", 0.05),
+		AudioDownmix:           getEnv("AUDIO_DOWNMIX", "average"),
+
+		// Audio Clipping and AGC
+		AudioClippingThreshold: getEnvInt("AUDIO_CLIPPING_THRESHOLD", 32000),
+		AudioAGCCompensation:   getEnvBool("AUDIO_AGC_COMPENSATION", false),
+
+		// Audio Deduplication
+		AudioDedupWindowSeconds: getEnvInt("AUDIO_DEDUP_WINDOW_SECONDS", 300),
+
+		// Deferred Audio Feature Extraction
+		AudioClipStoreDir:      getEnv("AUDIO_CLIP_STORE_DIR", ""),
+		AudioExtractionWorkers: getEnvInt("AUDIO_EXTRACTION_WORKERS", 2),
+
+		// Audio Spillover
+		AudioSpillDir:      getEnv("AUDIO_SPILL_DIR", ""),
+		AudioSpillMaxClips: getEnvInt("AUDIO_SPILL_MAX_CLIPS", 500),
+
+		// Chunked Audio Uploads
+		AudioUploadDir:         getEnv("AUDIO_UPLOAD_DIR", "./data/audio_uploads"),
+		AudioUploadNackSeconds: getEnvInt("AUDIO_UPLOAD_NACK_SECONDS", 5),
+		AudioUploadMaxNacks:    getEnvInt("AUDIO_UPLOAD_MAX_NACKS", 5),
+		AudioUploadMaxChunks:   getEnvInt("AUDIO_UPLOAD_MAX_CHUNKS", 1024),
+		AudioUploadMaxBytes:    getEnvInt("AUDIO_UPLOAD_MAX_BYTES", 8<<20),
+
+		// Noise Floor Calibration
+		NoiseFloorCalibrationHours: getEnvInt("NOISE_FLOOR_CALIBRATION_HOURS", 24),
+
+		// Occupancy Estimation
+		OccupancyPrior:               getEnvFloat("OCCUPANCY_PRIOR", 0.3),
+		OccupancySaveIntervalSeconds: getEnvInt("OCCUPANCY_SAVE_INTERVAL_SECONDS", 300),
+
+		// Alerts
+		AlertCooldownMinutes: getEnvInt("ALERT_COOLDOWN_MINUTES", 60),
+		AlertRules:           getEnv("ALERT_RULES", ""),
+
+		// Email alerts
+		SMTPHost:         getEnv("SMTP_HOST", ""),
+		SMTPPort:         getEnvInt("SMTP_PORT", 587),
+		SMTPUsername:     getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:     getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:         getEnv("SMTP_FROM", "iot-backend@localhost"),
+		AlertEmailRoutes: getEnv("ALERT_EMAIL_ROUTES", ""),
+
+		// End-to-End Canary
+		CanaryEnabled:         getEnvBool("CANARY_ENABLED", false),
+		CanaryDeviceID:        getEnv("CANARY_DEVICE_ID", "canary"),
+		CanaryDeviceToken:     getEnv("CANARY_DEVICE_TOKEN", ""),
+		CanaryIntervalSeconds: getEnvInt("CANARY_INTERVAL_SECONDS", 300),
+		CanaryDeadlineSeconds: getEnvInt("CANARY_DEADLINE_SECONDS", 120),
+
+		// Device Config Sync
+		DeviceDefaultSamplingSeconds: getEnvInt("DEVICE_DEFAULT_SAMPLING_SECONDS", 60),
+
+		// Startup
+		PreflightOnStartup: getEnvBool("PREFLIGHT_ON_STARTUP", true),
+
+		// Instance Role
+		InstanceRole: getEnv("INSTANCE_ROLE", "primary"),
+
+		// REST API
+		APIAddr:                getEnv("API_ADDR", ":8080"),
+		APICommandToken:        getEnv("API_COMMAND_TOKEN", ""),
+		APIIngestEnabled:       getEnvBool("API_INGEST_ENABLED", false),
+		APIQueryTimeoutSeconds: getEnvInt("API_QUERY_TIMEOUT_SECONDS", 15),
+		APIQueryMaxRowsRead:    getEnvInt("API_QUERY_MAX_ROWS_READ", 100000000),
+		APIQueryMaxResultRows:  getEnvInt("API_QUERY_MAX_RESULT_ROWS", 1000000),
+
+		// mDNS Discovery
+		MDNSEnabled:    getEnvBool("MDNS_ENABLED", false),
+		MDNSInstance:   getEnv("MDNS_INSTANCE", "iot-backend"),
+		MDNSHostname:   getEnv("MDNS_HOSTNAME", ""),
+		MDNSInterface:  getEnv("MDNS_INTERFACE", ""),
+		MDNSBrokerHost: getEnv("MDNS_BROKER_HOST", ""),
+
+		// Gateway Ingestion
+		GatewayGRPCAddr:            getEnv("GATEWAY_GRPC_ADDR", ""),
+		GatewayGRPCTokens:          getEnv("GATEWAY_GRPC_TOKENS", ""),
+		GatewayGRPCMaxMessageBytes: getEnvInt("GATEWAY_GRPC_MAX_MESSAGE_BYTES", 4<<20),
+		GatewayGRPCTLSCertFile:     getEnv("GATEWAY_GRPC_TLS_CERT_FILE", ""),
+		GatewayGRPCTLSKeyFile:      getEnv("GATEWAY_GRPC_TLS_KEY_FILE", ""),
+		GatewayGRPCClientCAFile:    getEnv("GATEWAY_GRPC_CLIENT_CA_FILE", ""),
+		GatewayGRPCInsecure:        getEnvBool("GATEWAY_GRPC_INSECURE", false),
+		GatewayGRPCReleaseMinutes:  getEnvInt("GATEWAY_GRPC_RELEASE_MINUTES", 10),
+
+		// Reading Simulator
+		SimulatorDevices:         getEnvInt("SIMULATOR_DEVICES", 0),
+		SimulatorIntervalSeconds: getEnvInt("SIMULATOR_INTERVAL_SECONDS", 10),
+
+		// Metrics
+		MetricsLogIntervalSeconds: getEnvInt("METRICS_LOG_INTERVAL_SECONDS", 300),
+		MQTTSlowBrokerMs:          getEnvInt("MQTT_SLOW_BROKER_MS", 500),
+
+		// Device State Persistence
+		DeviceStateFile:                getEnv("DEVICE_STATE_FILE", "./data/device_state.json"),
+		DeviceStateSaveIntervalSeconds: getEnvInt("DEVICE_STATE_SAVE_INTERVAL_SECONDS", 30),
+		DeviceStateShared:              getEnvBool("DEVICE_STATE_SHARED", false),
+		DeviceStateHandoffIdleSeconds:  getEnvInt("DEVICE_STATE_HANDOFF_IDLE_SECONDS", 120),
+
+		// Hot Reading Store
+		HotStoreMinutes:     getEnvInt("HOT_STORE_MINUTES", 10),
+		HotStoreMaxReadings: getEnvInt("HOT_STORE_MAX_READINGS", 600),
 
 		// Legacy Change Detection Thresholds (deprecated in CQRS model)
 		TemperatureThreshold:   getEnvFloat("TEMPERATURE_THRESHOLD", 0.5),
@@ -91,6 +702,18 @@ func Load() *Config {
 	}
 }
 
+// Redacted returns a copy of the configuration with its passwords and tokens
+// replaced, safe to show to support staff
+func (c *Config) Redacted() Config {
+	r := *c
+	for _, secret := range []*string{&r.MQTTPassword, &r.ClickHousePass, &r.SMTPPassword, &r.CanaryDeviceToken, &r.APICommandToken, &r.GatewayGRPCTokens} {
+		if *secret != "" {
+			*secret = "[redacted]"
+		}
+	}
+	return r
+}
+
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
 	if value == "" {